@@ -0,0 +1,114 @@
+package tinyrpc
+
+import (
+	"context"
+	"fmt"
+	"tinyrpc/rpclog"
+)
+
+// An Authorizer decides whether the call being handled in ctx may go on
+// to serviceMethod, e.g. by the AuthTokenFromContext of its connection.
+// The call fails with ErrPermissionDenied if it returns an error.
+type Authorizer func(ctx context.Context, serviceMethod string) error
+
+// EnableAdmin serves the "_admin_" service, which lists the connections
+// of the server and closes them, e.g. to kick an abusive client during an
+// incident. Every call is checked with authorize first, which must not be
+// nil. It must be called before the server starts serving.
+//
+// The methods are "_admin_.ListConnections", with any int argument,
+// "_admin_.DescribeConnection" with a ConnInfo.ID,
+// "_admin_.CloseConnection" with CloseConnectionArgs, "_admin_.Limits"
+// with any int argument and "_admin_.UpdateLimits" with a LimitsConfig,
+// see UpdateLimits.
+func (server *Server) EnableAdmin(authorize Authorizer) {
+	server.authorizeAdmin = authorize
+}
+
+// AuthTokenFromContext returns the Option.AuthToken of the connection of
+// the call being handled in ctx.
+func AuthTokenFromContext(ctx context.Context) string {
+	if sc, ok := ctx.Value(connKey{}).(*serverConn); ok {
+		return sc.authToken
+	}
+	return ""
+}
+
+// CloseConnectionArgs are the arguments of "_admin_.CloseConnection".
+type CloseConnectionArgs struct {
+	ID     uint64 // see ConnInfo
+	Reason string // sent to the client with the GoAway notice, and logged
+}
+
+// adminService answers the "_admin_" calls, see EnableAdmin.
+type adminService struct{ server *Server }
+
+func (s *adminService) authorize(ctx context.Context, method string) error {
+	if err := s.server.authorizeAdmin(ctx, "_admin_."+method); err != nil {
+		return fmt.Errorf("%w: %v", ErrPermissionDenied, err)
+	}
+	return nil
+}
+
+func (s *adminService) ListConnections(ctx context.Context, _ int, reply *[]ConnInfo) error {
+	if err := s.authorize(ctx, "ListConnections"); err != nil {
+		return err
+	}
+	*reply = s.server.Connections()
+	return nil
+}
+
+func (s *adminService) DescribeConnection(ctx context.Context, id uint64, reply *ConnInfo) error {
+	if err := s.authorize(ctx, "DescribeConnection"); err != nil {
+		return err
+	}
+	sc := s.server.connByID(id)
+	if sc == nil {
+		return fmt.Errorf("rpc server: no connection %d", id)
+	}
+	*reply = sc.info()
+	reply.History = sc.history.frames()
+	return nil
+}
+
+func (s *adminService) CloseConnection(ctx context.Context, args CloseConnectionArgs, reply *bool) error {
+	if err := s.authorize(ctx, "CloseConnection"); err != nil {
+		return err
+	}
+	sc := s.server.connByID(args.ID)
+	if sc == nil {
+		return fmt.Errorf("rpc server: no connection %d", args.ID)
+	}
+	s.server.log(rpclog.LevelWarn, "closing connection", "conn", sc.id, "reason", args.Reason)
+	sc.goAway(s.server, args.Reason)
+	_ = sc.cc.Close() // without waiting for the calls in flight
+	*reply = true
+	return nil
+}
+
+func (s *adminService) Limits(ctx context.Context, _ int, reply *LimitsConfig) error {
+	if err := s.authorize(ctx, "Limits"); err != nil {
+		return err
+	}
+	*reply = s.server.Limits()
+	return nil
+}
+
+func (s *adminService) UpdateLimits(ctx context.Context, limits LimitsConfig, reply *bool) error {
+	if err := s.authorize(ctx, "UpdateLimits"); err != nil {
+		return err
+	}
+	if err := s.server.UpdateLimits(limits); err != nil {
+		return err
+	}
+	s.server.log(rpclog.LevelWarn, "limits updated", "limits", fmt.Sprintf("%+v", limits))
+	*reply = true
+	return nil
+}
+
+// connByID returns the connection with id, nil if none is served.
+func (server *Server) connByID(id uint64) *serverConn {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.connsByID[id]
+}
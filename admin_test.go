@@ -0,0 +1,62 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"tinyrpc/codec"
+)
+
+func TestServer_Admin(t *testing.T) {
+	server := NewServer()
+	server.EnableAdmin(func(ctx context.Context, serviceMethod string) error {
+		if AuthTokenFromContext(ctx) != "root" {
+			return errors.New("not root")
+		}
+		return nil
+	})
+	addr := startServer(t, server).Addr().String()
+	dial := func(token string) *Client {
+		client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1, AuthToken: token})
+		_assert(err == nil, "dial error: %v", err)
+		t.Cleanup(func() { _ = client.Close() })
+		return client
+	}
+	sum := func(client *Client) error {
+		var reply int
+		return client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	}
+	first, second := dial("first"), dial("second") // tokens wait for the handshake, so IDs are in order
+	_assert(sum(first) == nil && sum(second) == nil, "failed to call Foo.Sum")
+	admin := dial("root")
+
+	var conns []ConnInfo
+	err := dial("guest").Call("_admin_.ListConnections", 0, &conns)
+	_assert(errors.Is(err, ErrPermissionDenied), "expect ErrPermissionDenied, but got %v", err)
+	err = admin.Call("_admin_.ListConnections", 0, &conns)
+	_assert(err == nil && len(conns) == 4, "expect 4 connections, but got %v, %v", conns, err)
+	firstID, secondID := conns[0].ID, conns[1].ID
+
+	var info ConnInfo
+	err = admin.Call("_admin_.DescribeConnection", secondID, &info)
+	_assert(err == nil && info.ID == secondID && info.Codec == codec.GobType && info.BytesRead > 0 && info.RemoteAddr != "",
+		"unexpected connection %+v, %v", info, err)
+	_assert(!info.ConnectedAt.IsZero() && info.InFlight == 0, "unexpected connection %+v", info)
+
+	var closed bool
+	err = admin.Call("_admin_.CloseConnection", CloseConnectionArgs{ID: firstID, Reason: "abusive"}, &closed)
+	_assert(err == nil && closed, "failed to close the connection: %v", err)
+	_assert(sum(first) != nil, "expect the closed connection to fail its next call")
+	_assert(sum(second) == nil, "expect the other connection to work")
+	err = admin.Call("_admin_.DescribeConnection", firstID, &info)
+	_assert(err != nil, "expect the closed connection gone")
+}
+
+func TestServer_AdminDisabled(t *testing.T) {
+	client, err := Dial("tcp", startServer(t, NewServer()).Addr().String())
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var conns []ConnInfo
+	err = client.Call("_admin_.ListConnections", 0, &conns)
+	_assert(errors.Is(err, ErrMethodNotFound), "expect no admin service by default, but got %v", err)
+}
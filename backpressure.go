@@ -0,0 +1,108 @@
+package tinyrpc
+
+import (
+	"sync/atomic"
+	"time"
+	"tinyrpc/internal/clock"
+	"tinyrpc/rpclog"
+)
+
+// ResponseLimits bound the responses of a connection that are ready but
+// not written yet, e.g. because its client reads slowly, so that they
+// can't pile up in memory. Zero fields mean no limit.
+type ResponseLimits struct {
+	// MaxUnsent pauses reading the requests of a connection while this
+	// many of its responses are unsent. Calls already read still complete.
+	MaxUnsent int
+	// DropUnsent closes a connection with more unsent responses.
+	DropUnsent int
+	// MaxReordered pauses reading the requests of a connection with
+	// Option.OrderedResponses while this many of its responses wait for
+	// the ones before, DefaultMaxReordered if 0.
+	MaxReordered int
+	// WriteTimeout closes a connection whose response waited this long
+	// to be written.
+	WriteTimeout time.Duration
+}
+
+// SetResponseLimits sets the limits of the unsent responses of every
+// connection. It must be called before the server starts serving, see
+// UpdateLimits afterwards.
+func (server *Server) SetResponseLimits(limits ResponseLimits) {
+	server.limits = limits
+}
+
+// waitUnsent blocks while sc has MaxUnsent unsent responses or more, or
+// a full reordering buffer.
+func (server *Server) waitUnsent(sc *serverConn) {
+	limits := server.currentLimits().Responses
+	max := limits.MaxUnsent
+	if max <= 0 && !sc.ordered {
+		return
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for ((max > 0 && sc.unsent >= max) || sc.reorderFull(limits.MaxReordered)) && !sc.closed {
+		sc.unsentCond.Wait()
+	}
+}
+
+// respond sends the response of req on sc, in its turn and within the
+// limits. A response waiting for its turn is not counted as unsent yet.
+func (server *Server) respond(sc *serverConn, req *request, body interface{}) {
+	limits := server.currentLimits().Responses
+	sc.mu.Lock()
+	sc.awaitTurn(req.turn)
+	sc.unsent++
+	if sc.unsent > sc.unsentHigh {
+		sc.unsentHigh = sc.unsent
+	}
+	drop := limits.DropUnsent > 0 && sc.unsent > limits.DropUnsent
+	sc.mu.Unlock()
+	defer func() {
+		sc.mu.Lock()
+		sc.endTurn(req.turn)
+		sc.unsent--
+		sc.unsentCond.Broadcast()
+		sc.mu.Unlock()
+	}()
+	if drop {
+		server.dropSlowConn(sc, "too many unsent responses")
+		return
+	}
+	if limits.WriteTimeout > 0 {
+		written := make(chan struct{})
+		defer close(written)
+		t := clock.Or(server.clock).NewTimer(limits.WriteTimeout)
+		go func() {
+			select {
+			case <-t.C():
+				server.dropSlowConn(sc, "response write timeout")
+			case <-written:
+				t.Stop()
+			}
+		}()
+	}
+	err := server.writeResponse(sc.writer(req), req, body)
+	if req.timings != nil {
+		req.timings.Written = clock.Or(server.clock).Now()
+	}
+	if t := server.config.Trace; t != nil && t.WroteResponse != nil {
+		t.WroteResponse(req.h.ServiceMethod, req.h.Seq, err)
+	}
+}
+
+// dropSlowConn closes sc, whose client doesn't read its responses.
+func (server *Server) dropSlowConn(sc *serverConn, reason string) {
+	sc.mu.Lock()
+	closed := sc.closed
+	sc.closed = true
+	sc.unsentCond.Broadcast()
+	sc.mu.Unlock()
+	if closed {
+		return
+	}
+	server.log(rpclog.LevelWarn, "closing slow connection", "conn", sc.id, "reason", reason)
+	atomic.AddUint64(&server.slowDropped, 1)
+	_ = sc.cc.Close()
+}
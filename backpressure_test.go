@@ -0,0 +1,84 @@
+package tinyrpc
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+	"tinyrpc/codec"
+	"tinyrpc/tinyrpctest"
+)
+
+// flood serves one side of a pipe with server and issues n calls from the
+// other side, which never reads the responses. It returns once the calls
+// are written or the connection is closed.
+func flood(t *testing.T, server *Server, n int) <-chan struct{} {
+	t.Helper()
+	var foo Foo
+	_assert(server.Register(&foo) == nil, "failed to register Foo")
+	conn, peer := net.Pipe()
+	t.Cleanup(func() { _ = peer.Close() })
+	go server.ServeConn(conn)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := json.NewEncoder(peer).Encode(DefaultOption); err != nil {
+			return
+		}
+		cc := codec.NewGobCodec(peer)
+		for i := 1; i <= n; i++ {
+			h := &codec.Header{ServiceMethod: "Foo.Sum", Seq: uint64(i)}
+			if err := cc.Write(h, Args{Num1: i, Num2: i}); err != nil {
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// floodCalls is enough calls that the server can't read them all before
+// the handlers of the first ones respond.
+const floodCalls = 20000
+
+func TestServer_ResponseLimits(t *testing.T) {
+	t.Run("backpressure", func(t *testing.T) {
+		server := NewServer()
+		clock := tinyrpctest.NewClock()
+		server.SetClock(clock)
+		server.SetResponseLimits(ResponseLimits{MaxUnsent: 10, WriteTimeout: 5 * time.Second})
+		done := flood(t, server, floodCalls)
+
+		clock.BlockUntil(10) // a write timer per unsent response
+		time.Sleep(20 * time.Millisecond)
+		stats := server.Stats()
+		_assert(stats.Requests < floodCalls, "expect the server to stop reading, but read %d requests", stats.Requests)
+		conns := server.Connections()
+		_assert(len(conns) == 1 && conns[0].UnsentHigh >= 10, "expect an unsent high-water mark of 10 at least, but got %+v", conns)
+		select {
+		case <-done:
+			t.Fatal("expect the peer blocked writing its requests")
+		default:
+		}
+
+		clock.Advance(5 * time.Second)
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("expect the stalled connection dropped")
+		}
+		_assert(server.Stats().SlowDropped == 1, "expect 1 slow connection dropped, but got %d", server.Stats().SlowDropped)
+	})
+	t.Run("drop", func(t *testing.T) {
+		server := NewServer()
+		server.SetResponseLimits(ResponseLimits{DropUnsent: 50})
+		done := flood(t, server, floodCalls)
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("expect the connection dropped past 50 unsent responses")
+		}
+		stats := server.Stats()
+		_assert(stats.SlowDropped == 1, "expect 1 slow connection dropped, but got %d", stats.SlowDropped)
+		_assert(stats.Requests < floodCalls, "expect the connection dropped before %d requests, but read %d", floodCalls, stats.Requests)
+	})
+}
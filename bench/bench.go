@@ -0,0 +1,185 @@
+// Package bench provides an echo service and a load generator, to measure
+// tinyrpc servers and clients without writing them each time.
+package bench
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+	"tinyrpc"
+)
+
+// ServiceName is the name Register publishes the echo service under.
+const ServiceName = "Echo"
+
+// EchoArgs are the arguments of "Echo.Echo".
+type EchoArgs struct {
+	Payload   []byte
+	ReplySize int           // bytes of the reply, the payload is echoed if 0
+	Delay     time.Duration // how long the server waits before replying
+}
+
+// EchoReply is the reply of "Echo.Echo".
+type EchoReply struct {
+	Payload []byte
+}
+
+// Echo is the echo service.
+type Echo struct{}
+
+// Echo replies with args.Payload, or args.ReplySize bytes, after args.Delay.
+func (e *Echo) Echo(ctx context.Context, args *EchoArgs, reply *EchoReply) error {
+	if args.Delay > 0 {
+		t := time.NewTimer(args.Delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	reply.Payload = args.Payload
+	if args.ReplySize > 0 {
+		reply.Payload = make([]byte, args.ReplySize)
+	}
+	return nil
+}
+
+// Register publishes the echo service in server.
+func Register(server *tinyrpc.Server) error {
+	return server.RegisterName(ServiceName, &Echo{})
+}
+
+// Caller makes calls; *xclient.XClient is one, ClientCaller adapts a
+// *tinyrpc.Client.
+type Caller interface {
+	Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...tinyrpc.CallOption) error
+}
+
+type clientCaller struct{ *tinyrpc.Client }
+
+func (c clientCaller) Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...tinyrpc.CallOption) error {
+	return c.CallContext(ctx, serviceMethod, args, reply, opts...)
+}
+
+// ClientCaller returns a Caller making its calls with client.
+func ClientCaller(client *tinyrpc.Client) Caller {
+	return clientCaller{client}
+}
+
+// LoadGen drives concurrent echo calls against a server.
+type LoadGen struct {
+	// Caller makes the calls. If nil, Run dials Addr.
+	Caller Caller
+	// Addr is the address of the server, in XDial format, e.g.
+	// "tcp@localhost:9999", and Option its options, e.g. the codec.
+	Addr   string
+	Option *tinyrpc.Option
+
+	Workers  int           // concurrent callers, 1 if 0
+	Duration time.Duration // how long to run, until Requests are made if 0
+	Requests int           // calls to make at most, no limit if 0
+
+	Args EchoArgs // sent by every call
+}
+
+// Result reports a run of a LoadGen.
+type Result struct {
+	Calls    int
+	Errors   int
+	Duration time.Duration
+	QPS      float64 // calls per second, errors included
+	// Latency percentiles of the calls, errors included.
+	P50, P90, P99, Max time.Duration
+}
+
+// Run makes the calls until the duration is over, the requests are made
+// or ctx is done, and reports them.
+func (g *LoadGen) Run(ctx context.Context) (Result, error) {
+	if g.Duration <= 0 && g.Requests <= 0 {
+		return Result{}, errors.New("bench: neither Duration nor Requests set")
+	}
+	caller := g.Caller
+	if caller == nil {
+		client, err := tinyrpc.XDial(g.Addr, g.Option)
+		if err != nil {
+			return Result{}, err
+		}
+		defer func() { _ = client.Close() }()
+		caller = ClientCaller(client)
+	}
+	workers := g.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	if g.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.Duration)
+		defer cancel()
+	}
+
+	var mu sync.Mutex // protect following
+	issued := 0
+	take := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if g.Requests > 0 && issued >= g.Requests {
+			return false
+		}
+		issued++
+		return true
+	}
+	latencies := make([][]time.Duration, workers)
+	errs := make([]int, workers)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for ctx.Err() == nil && take() {
+				var reply EchoReply
+				begin := time.Now()
+				err := caller.Call(ctx, ServiceName+".Echo", &g.Args, &reply)
+				if err != nil && (ctx.Err() != nil || ended(ctx)) {
+					return // cut short by the end of the run, not counted
+				}
+				latencies[i] = append(latencies[i], time.Since(begin))
+				if err != nil {
+					errs[i]++
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	return summarize(latencies, errs, time.Since(start)), nil
+}
+
+// ended reports whether the deadline of ctx passed, which the client may
+// notice a little before ctx does.
+func ended(ctx context.Context) bool {
+	d, ok := ctx.Deadline()
+	return ok && !time.Now().Before(d)
+}
+
+func summarize(latencies [][]time.Duration, errs []int, elapsed time.Duration) Result {
+	var all []time.Duration
+	r := Result{Duration: elapsed}
+	for i := range latencies {
+		all = append(all, latencies[i]...)
+		r.Errors += errs[i]
+	}
+	r.Calls = len(all)
+	if r.Calls == 0 {
+		return r
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	percentile := func(p int) time.Duration {
+		return all[(len(all)-1)*p/100]
+	}
+	r.P50, r.P90, r.P99, r.Max = percentile(50), percentile(90), percentile(99), all[len(all)-1]
+	r.QPS = float64(r.Calls) / elapsed.Seconds()
+	return r
+}
@@ -0,0 +1,69 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+	"tinyrpc"
+	"tinyrpc/xclient"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func startEcho(t *testing.T) string {
+	t.Helper()
+	server := tinyrpc.NewServer()
+	if err := Register(server); err != nil {
+		t.Fatal("register error:", err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	go server.Accept(lis)
+	t.Cleanup(func() { _ = lis.Close() })
+	return "tcp@" + lis.Addr().String()
+}
+
+func TestLoadGen_Requests(t *testing.T) {
+	g := &LoadGen{
+		Addr:     startEcho(t),
+		Workers:  8,
+		Requests: 1000,
+		Args:     EchoArgs{Payload: []byte("ping"), ReplySize: 128},
+	}
+	r, err := g.Run(context.Background())
+	_assert(err == nil && r.Calls == 1000 && r.Errors == 0, "expect 1000 calls, but got %+v, %v", r, err)
+	_assert(r.QPS > 0 && r.P50 <= r.P90 && r.P90 <= r.P99 && r.P99 <= r.Max, "unexpected latencies %+v", r)
+}
+
+func TestLoadGen_XClient(t *testing.T) {
+	d := xclient.NewMultiServerDiscovery([]string{startEcho(t), startEcho(t)})
+	xc := xclient.NewXClient(d, xclient.RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	g := &LoadGen{
+		Caller:   xc,
+		Workers:  4,
+		Duration: 100 * time.Millisecond,
+		Args:     EchoArgs{Delay: time.Millisecond},
+	}
+	r, err := g.Run(context.Background())
+	_assert(err == nil && r.Calls > 0 && r.Errors == 0 && r.P50 >= time.Millisecond,
+		"expect delayed calls for the duration, but got %+v, %v", r, err)
+	_assert(len(xc.Stats()) == 2, "expect calls to both servers, but got %v", xc.Stats())
+}
+
+func TestEcho(t *testing.T) {
+	var e Echo
+	var reply EchoReply
+	_ = e.Echo(context.Background(), &EchoArgs{Payload: []byte("abc")}, &reply)
+	_assert(string(reply.Payload) == "abc", "expect the payload echoed, but got %q", reply.Payload)
+	_ = e.Echo(context.Background(), &EchoArgs{Payload: []byte("abc"), ReplySize: 10}, &reply)
+	_assert(len(reply.Payload) == 10, "expect a 10 byte reply, but got %d", len(reply.Payload))
+}
@@ -0,0 +1,111 @@
+package tinyrpc
+
+import (
+	"bufio"
+	"io"
+	"time"
+	"tinyrpc/internal/clock"
+)
+
+// bodyLinger is how long the server keeps reading a connection it closes
+// for a body too large, so that the client gets the error response
+// rather than a connection reset.
+const bodyLinger = time.Second
+
+// limitedConn is the stream the codec of a connection reads past the
+// handshake, failing the reads of a body past its limit. It is a
+// ByteReader, so that codecs such as gob don't buffer it and read exactly
+// the frames they decode.
+type limitedConn struct {
+	io.ReadWriteCloser // for writing and closing
+	r                  byteReader
+	left               int64 // bytes the body being read may still take, if limited
+	limited            bool
+	soft               bool // counts left without failing the reads, see WarnOnly
+	exceeded           bool
+	stamp              func() time.Time // sets arrived at the next byte read, see arriving
+	arrived            time.Time
+}
+
+func newLimitedConn(conn io.ReadWriteCloser) *limitedConn {
+	r, ok := conn.(byteReader)
+	if !ok {
+		r = bufio.NewReader(conn)
+	}
+	return &limitedConn{ReadWriteCloser: conn, r: r}
+}
+
+func (l *limitedConn) Read(p []byte) (int, error) {
+	if l.limited {
+		if l.left <= 0 {
+			l.exceeded = true
+			return 0, ErrBodyTooLarge
+		}
+		if int64(len(p)) > l.left {
+			p = p[:l.left]
+		}
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if n > 0 && l.stamp != nil {
+		l.arrived, l.stamp = l.stamp(), nil
+	}
+	return n, err
+}
+
+func (l *limitedConn) ReadByte() (byte, error) {
+	if l.limited && l.left <= 0 {
+		l.exceeded = true
+		return 0, ErrBodyTooLarge
+	}
+	b, err := l.r.ReadByte()
+	if err == nil {
+		l.left--
+		if l.stamp != nil {
+			l.arrived, l.stamp = l.stamp(), nil
+		}
+	}
+	return b, err
+}
+
+// limit makes the reads fail past n more bytes, none if n is 0, or only
+// tracks them if soft.
+func (l *limitedConn) limit(n int64, soft bool) {
+	l.limited, l.soft, l.left, l.exceeded = n > 0 && !soft, n > 0 && soft, n, false
+}
+
+// arriving makes the next byte read set arrived to now().
+func (l *limitedConn) arriving(now func() time.Time) {
+	l.stamp = now
+}
+
+// unlimit lifts the limit and returns the verdict of the reads since it
+// was set, allowed if l is nil.
+func (l *limitedConn) unlimit() verdict {
+	if l == nil {
+		return verdictAllow
+	}
+	v := judge(l.exceeded || l.soft && l.left < 0, l.soft)
+	l.limited, l.soft = false, false
+	return v
+}
+
+// linger half-closes sc and discards what the client still sends, for
+// bodyLinger at most, before sc is closed.
+func (server *Server) linger(sc *serverConn) {
+	if cw, ok := sc.conn.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+	}
+	t := clock.Or(server.clock).NewTimer(bodyLinger)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-t.C():
+			_ = sc.cc.Close()
+		case <-done:
+			t.Stop()
+		}
+	}()
+	_, _ = io.Copy(io.Discard, sc.stream)
+}
@@ -0,0 +1,100 @@
+package tinyrpc
+
+import (
+	"errors"
+	"strings"
+	"tinyrpc/rpclog"
+)
+
+// BuiltinPrefix starts the names of the built-in services, such as
+// "_ping_". User services can't be registered under it.
+const BuiltinPrefix = "_"
+
+// SetReservedPrefix reserves another prefix of service names that Register
+// and RegisterName reject, in addition to BuiltinPrefix.
+// It must be called before registering services.
+func (server *Server) SetReservedPrefix(prefix string) {
+	server.reserved = prefix
+}
+
+func (server *Server) isReserved(name string) bool {
+	return strings.HasPrefix(name, BuiltinPrefix) ||
+		(server.reserved != "" && strings.HasPrefix(name, server.reserved))
+}
+
+// builtin returns the built-in service called name, if any.
+func (server *Server) builtin(name string) *service {
+	if !strings.HasPrefix(name, BuiltinPrefix) {
+		return nil
+	}
+	if name == "_log_" && !server.logAdmin {
+		return nil
+	}
+	if name == "_admin_" && server.authorizeAdmin == nil {
+		return nil
+	}
+	server.builtinOnce.Do(server.registerBuiltins)
+	return server.builtins[name]
+}
+
+func (server *Server) registerBuiltins() {
+	server.builtins = make(map[string]*service)
+	for name, rcvr := range map[string]interface{}{
+		"_ping_":   &pingService{},
+		"_sub_":    &subscribeService{server},
+		"_log_":    &logService{server},
+		"_admin_":  &adminService{server},
+		"_schema_": &schemaService{server},
+		"_health_": &healthService{server},
+	} {
+		server.builtins[name] = newNamedService(rcvr, name)
+	}
+}
+
+// pingService answers "_ping_.Ping" with its argument, so clients can
+// check a connection end to end.
+type pingService struct{}
+
+func (p *pingService) Ping(args int, reply *int) error {
+	*reply = args
+	return nil
+}
+
+// SetLogLevel sets the level of the default logger for subsystem, such as
+// "server", "client", "registry" or "codec", or for all of them if it is
+// "". Like the logger, the levels are shared by the whole process.
+func (server *Server) SetLogLevel(subsystem string, level rpclog.Level) error {
+	if !rpclog.SetLevel(subsystem, level) {
+		return errors.New("rpc server: the logger has no levels")
+	}
+	return nil
+}
+
+// EnableLogAdmin serves "_log_.SetLevel", which lets clients change the
+// log levels with SetLogLevel, e.g. to debug the codec during an incident.
+// Any client of the server can call it. It must be called before the
+// server starts serving.
+func (server *Server) EnableLogAdmin() {
+	server.logAdmin = true
+}
+
+// LogLevelArgs are the arguments of "_log_.SetLevel".
+type LogLevelArgs struct {
+	Subsystem string // "" for all subsystems
+	Level     string // "debug", "info", "warn" or "error"
+}
+
+// logService answers "_log_.SetLevel", see EnableLogAdmin.
+type logService struct{ server *Server }
+
+func (s *logService) SetLevel(args LogLevelArgs, reply *string) error {
+	level, err := rpclog.ParseLevel(args.Level)
+	if err != nil {
+		return err
+	}
+	if err = s.server.SetLogLevel(args.Subsystem, level); err != nil {
+		return err
+	}
+	*reply = level.String()
+	return nil
+}
@@ -0,0 +1,38 @@
+package tinyrpc
+
+import "testing"
+
+type SysInfo int
+
+func (s SysInfo) Ping(args int, reply *int) error {
+	*reply = -1
+	return nil
+}
+
+func TestRegister_Reserved(t *testing.T) {
+	var foo Foo
+	server := NewServer()
+	err := server.RegisterName("_health_", &foo)
+	_assert(err != nil, "expect _health_ to be rejected")
+	err = server.RegisterName("_ping_", &foo)
+	_assert(err != nil, "expect _ping_ to be rejected")
+	_assert(server.RegisterName("Bar", &foo) == nil, "expect Bar to be accepted")
+
+	server = NewServer()
+	server.SetReservedPrefix("Sys")
+	var info SysInfo
+	_assert(server.Register(&info) != nil, "expect SysInfo to be rejected with prefix Sys")
+	_assert(server.RegisterName("_ping_", &info) != nil, "expect _ping_ to stay reserved with prefix Sys")
+}
+
+func TestBuiltin_Ping(t *testing.T) {
+	lis := startServer(t, NewServer())
+	client, err := Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call("_ping_.Ping", 42, &reply)
+	_assert(err == nil && reply == 42, "failed to call _ping_.Ping: %v", err)
+}
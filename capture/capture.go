@@ -0,0 +1,318 @@
+// Package capture records the frames of tinyrpc connections to files and
+// replays them, to reproduce what a client or a server saw.
+//
+// A capture file starts with Magic, followed by records, each one a 4-byte
+// big-endian length and the record: the time in Unix nanoseconds (8 bytes),
+// the direction (1 byte) and the gob encoding of the header and the body.
+// The body is gob-encoded on its own, so every record can be decoded alone.
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"tinyrpc/codec"
+	"tinyrpc/rpclog"
+)
+
+// Magic starts every capture file.
+const Magic = "TRPCCAP1"
+
+// Direction tells whether a frame was read or written.
+type Direction byte
+
+const (
+	Inbound  Direction = '<' // read from the peer
+	Outbound Direction = '>' // written to the peer
+)
+
+// Record is one captured frame.
+type Record struct {
+	Time   time.Time
+	Dir    Direction
+	Header codec.Header
+	// Body is the gob encoding of the body, nil if it was not decoded,
+	// e.g. drained after an unknown method.
+	Body []byte
+}
+
+// frame is the gob-encoded part of a record.
+type frame struct {
+	Header codec.Header
+	Body   []byte
+}
+
+// Decode decodes the body of r into v, a pointer.
+func (r *Record) Decode(v interface{}) error {
+	if r.Body == nil {
+		return errors.New("capture: body not captured")
+	}
+	return gob.NewDecoder(bytes.NewReader(r.Body)).Decode(v)
+}
+
+func encodeBody(body interface{}) []byte {
+	if body == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(body); err != nil {
+		rpclog.Warn("capture", "encode body error", "err", err)
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func (r *Record) marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	var head [12]byte
+	binary.BigEndian.PutUint64(head[4:], uint64(r.Time.UnixNano()))
+	buf.Write(head[:])
+	buf.WriteByte(byte(r.Dir))
+	if err := gob.NewEncoder(&buf).Encode(frame{r.Header, r.Body}); err != nil {
+		return nil, err
+	}
+	b := buf.Bytes()
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	return b, nil
+}
+
+// Reader reads the records of a capture file.
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader checks that r starts with Magic and returns a Reader of its records.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != Magic {
+		return nil, errors.New("capture: not a capture file")
+	}
+	return &Reader{r: br}, nil
+}
+
+// Next returns the next record, io.EOF after the last one.
+func (r *Reader) Next() (*Record, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r.r, size[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if len(b) < 9 {
+		return nil, errors.New("capture: short record")
+	}
+	rec := &Record{
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(b))),
+		Dir:  Direction(b[8]),
+	}
+	var f frame
+	if err := gob.NewDecoder(bytes.NewReader(b[9:])).Decode(&f); err != nil {
+		return nil, fmt.Errorf("capture: bad record: %w", err)
+	}
+	rec.Header, rec.Body = f.Header, f.Body
+	return rec, nil
+}
+
+// ReadFile returns the records of the capture file at path.
+func ReadFile(path string) ([]*Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	r, err := NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var records []*Record
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+}
+
+// Config configures a Writer. Zero fields take the defaults below.
+type Config struct {
+	MaxSize    int64 // bytes of a file before it is rotated, DefaultMaxSize
+	MaxBackups int   // rotated files kept, path.1 being the newest, DefaultMaxBackups
+	Queue      int   // records queued for writing, dropped beyond, DefaultQueue
+}
+
+// Defaults of Config.
+const (
+	DefaultMaxSize    = 64 << 20
+	DefaultMaxBackups = 3
+	DefaultQueue      = 1024
+)
+
+// DefaultFlushInterval is how often a Writer flushes its buffer.
+const DefaultFlushInterval = time.Second
+
+// Writer writes records to a capture file in the background, so that
+// capturing never blocks the connections: records are dropped when the
+// queue is full. The file is rotated when it reaches MaxSize.
+type Writer struct {
+	path   string
+	cfg    Config
+	queue  chan *Record
+	done   chan struct{}
+	closed chan struct{}
+	once   sync.Once
+
+	file *os.File // used by the writing goroutine only
+	buf  *bufio.Writer
+	size int64
+
+	dropped uint64 // accessed atomically
+}
+
+// NewWriter creates the capture file at path, rotating an existing one.
+func NewWriter(path string, cfg Config) (*Writer, error) {
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = DefaultMaxSize
+	}
+	if cfg.MaxBackups <= 0 {
+		cfg.MaxBackups = DefaultMaxBackups
+	}
+	if cfg.Queue <= 0 {
+		cfg.Queue = DefaultQueue
+	}
+	w := &Writer{
+		path:   path,
+		cfg:    cfg,
+		queue:  make(chan *Record, cfg.Queue),
+		done:   make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// Write queues rec for writing, or drops it if the queue is full or w is
+// closed.
+func (w *Writer) Write(rec *Record) {
+	select {
+	case <-w.closed:
+		atomic.AddUint64(&w.dropped, 1)
+		return
+	default:
+	}
+	select {
+	case w.queue <- rec:
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+	}
+}
+
+// Dropped returns the number of records dropped.
+func (w *Writer) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// Close writes the queued records and closes the file.
+func (w *Writer) Close() error {
+	w.once.Do(func() { close(w.closed) })
+	<-w.done
+	return nil
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(DefaultFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case rec := <-w.queue:
+			w.write(rec)
+		case <-ticker.C:
+			_ = w.buf.Flush()
+		case <-w.closed:
+			for {
+				select {
+				case rec := <-w.queue:
+					w.write(rec)
+				default:
+					_ = w.buf.Flush()
+					_ = w.file.Close()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *Writer) write(rec *Record) {
+	b, err := rec.marshal()
+	if err != nil {
+		rpclog.Warn("capture", "encode record error", "err", err)
+		atomic.AddUint64(&w.dropped, 1)
+		return
+	}
+	if w.size > int64(len(Magic)) && w.size+int64(len(b)) > w.cfg.MaxSize {
+		if err := w.rotate(); err != nil {
+			rpclog.Error("capture", "rotate error", "path", w.path, "err", err)
+			atomic.AddUint64(&w.dropped, 1)
+			return
+		}
+	}
+	n, err := w.buf.Write(b)
+	w.size += int64(n)
+	if err != nil {
+		rpclog.Error("capture", "write error", "path", w.path, "err", err)
+	}
+}
+
+func (w *Writer) open() error {
+	if _, err := os.Stat(w.path); err == nil {
+		if err := w.shift(); err != nil {
+			return err
+		}
+	}
+	f, err := os.Create(w.path)
+	if err != nil {
+		return err
+	}
+	w.file, w.buf = f, bufio.NewWriter(f)
+	n, err := w.buf.WriteString(Magic)
+	w.size = int64(n)
+	return err
+}
+
+// shift renames path.i to path.i+1, dropping the oldest, and path to path.1.
+func (w *Writer) shift() error {
+	_ = os.Remove(fmt.Sprintf("%s.%d", w.path, w.cfg.MaxBackups))
+	for i := w.cfg.MaxBackups - 1; i > 0; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+	}
+	return os.Rename(w.path, w.path+".1")
+}
+
+func (w *Writer) rotate() error {
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	return w.open()
+}
@@ -0,0 +1,163 @@
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+	"tinyrpc"
+	"tinyrpc/codec"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+type Args struct{ Num1, Num2 int }
+
+type Arith int
+
+func (a *Arith) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (a *Arith) Div(args Args, reply *int) error {
+	if args.Num2 == 0 {
+		return fmt.Errorf("divide by zero")
+	}
+	*reply = args.Num1 / args.Num2
+	return nil
+}
+
+func newArithServer() *tinyrpc.Server {
+	server := tinyrpc.NewServer()
+	var arith Arith
+	_ = server.Register(&arith)
+	return server
+}
+
+func responses(records []*Record) []*Record {
+	var out []*Record
+	for _, rec := range records {
+		if rec.Dir == Outbound {
+			out = append(out, rec)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Header.Seq < out[j].Header.Seq })
+	return out
+}
+
+// session makes the calls recorded by the tests, with client.
+func session(t *testing.T, client *tinyrpc.Client) []int {
+	var replies []int
+	for _, args := range []Args{{1, 2}, {10, 5}, {3, 0}} {
+		var reply int
+		method := "Arith.Sum"
+		if args.Num1 >= 3 {
+			method = "Arith.Div"
+		}
+		err := client.Call(method, args, &reply)
+		if err != nil {
+			reply = -1
+		}
+		replies = append(replies, reply)
+	}
+	_ = client.Call("Arith.Missing", Args{}, new(int))
+	return replies
+}
+
+func TestCapture_ReplayServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.cap")
+	w, err := NewWriter(path, Config{})
+	if err != nil {
+		t.Fatal("capture error:", err)
+	}
+	server := newArithServer()
+	server.WrapCodec(Wrap(w))
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = lis.Close() }()
+	go server.Accept(lis)
+	client, err := tinyrpc.Dial("tcp", lis.Addr().String(), &tinyrpc.Option{HeartbeatIdle: -1})
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	session(t, client)
+	_ = client.Close() // every frame is queued once the client has its replies
+	_ = w.Close()
+
+	records, err := ReadFile(path)
+	_assert(err == nil && len(records) == 8, "expect 4 requests and 4 responses, but got %d, %v", len(records), err)
+	want := responses(records)
+	got := responses(ReplayServer(newArithServer(), records))
+	_assert(len(got) == len(want), "expect %d responses, but got %d", len(want), len(got))
+	for i := range want {
+		_assert(fmt.Sprint(got[i].Header) == fmt.Sprint(want[i].Header) && bytes.Equal(got[i].Body, want[i].Body),
+			"expect response %+v, but got %+v", want[i].Header, got[i].Header)
+	}
+	var quotient int
+	_assert(want[1].Decode(&quotient) == nil && quotient == 2, "expect the reply of Arith.Div captured")
+}
+
+func TestCapture_ReplayClient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.cap")
+	w, err := NewWriter(path, Config{})
+	if err != nil {
+		t.Fatal("capture error:", err)
+	}
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = lis.Close() }()
+	go newArithServer().Accept(lis)
+	client, err := tinyrpc.Dial("tcp", lis.Addr().String(), &tinyrpc.Option{HeartbeatIdle: -1, WrapCodec: Wrap(w)})
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	want := session(t, client)
+	_ = client.Close()
+	_ = w.Close()
+
+	records, err := ReadFile(path)
+	if err != nil {
+		t.Fatal("read error:", err)
+	}
+	replay, err := tinyrpc.NewClientWithCodec(NewClientReplay(records), &tinyrpc.Option{HeartbeatIdle: -1})
+	if err != nil {
+		t.Fatal("replay error:", err)
+	}
+	defer func() { _ = replay.Close() }()
+	got := session(t, replay) // no server involved
+	_assert(fmt.Sprint(got) == fmt.Sprint(want) && fmt.Sprint(want) == "[3 2 -1]", "expect replies %v, but got %v", want, got)
+}
+
+func TestWriter_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rot.cap")
+	w, err := NewWriter(path, Config{MaxSize: 512, MaxBackups: 2})
+	if err != nil {
+		t.Fatal("capture error:", err)
+	}
+	for i := 0; i < 40; i++ {
+		w.Write(&Record{Time: time.Now(), Dir: Inbound, Header: codec.Header{ServiceMethod: "Arith.Sum", Seq: uint64(i)}})
+	}
+	_ = w.Close()
+	w.Write(&Record{}) // dropped once closed
+	_assert(w.Dropped() == 1, "expect 1 dropped record, but got %d", w.Dropped())
+
+	total := 0
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		fi, err := os.Stat(name)
+		_assert(err == nil && fi.Size() <= 512, "expect %s within the size cap, but got %v", name, err)
+		records, err := ReadFile(name)
+		_assert(err == nil && len(records) > 0, "expect records in %s, but got %v", name, err)
+		total += len(records)
+	}
+	_, err = os.Stat(path + ".3")
+	_assert(os.IsNotExist(err), "expect 2 backups at most")
+	last, _ := ReadFile(path)
+	_assert(last[len(last)-1].Header.Seq == 39 && total < 40, "expect the newest records in %s", path)
+}
@@ -0,0 +1,154 @@
+package capture
+
+import (
+	"io"
+	"sync"
+	"time"
+	"tinyrpc"
+	"tinyrpc/codec"
+)
+
+// Wrap returns a function wrapping codecs so that they copy every frame
+// they read or write to w, for Server.WrapCodec and Option.WrapCodec.
+func Wrap(w *Writer) func(codec.Codec) codec.Codec {
+	return func(cc codec.Codec) codec.Codec {
+		return &capturingCodec{Codec: cc, w: w}
+	}
+}
+
+// capturingCodec tees the frames of its codec to a Writer. Reads are made
+// by one goroutine at a time, so the header read last belongs to the next
+// body read.
+type capturingCodec struct {
+	codec.Codec
+	w      *Writer
+	header codec.Header // read last
+}
+
+func (c *capturingCodec) ReadHeader(h *codec.Header) error {
+	if err := c.Codec.ReadHeader(h); err != nil {
+		return err
+	}
+	c.header = *h
+	return nil
+}
+
+func (c *capturingCodec) ReadBody(body interface{}) error {
+	if err := c.Codec.ReadBody(body); err != nil {
+		return err
+	}
+	c.w.Write(&Record{Time: time.Now(), Dir: Inbound, Header: c.header, Body: encodeBody(body)})
+	return nil
+}
+
+func (c *capturingCodec) Write(h *codec.Header, body interface{}) error {
+	// queued first, so that it is captured before the peer can answer
+	c.w.Write(&Record{Time: time.Now(), Dir: Outbound, Header: *h, Body: encodeBody(body)})
+	return c.Codec.Write(h, body)
+}
+
+// ReplayCodec is a codec reading the inbound records of a capture and
+// collecting what is written to it, to replay a capture with
+// Server.ServeCodec or NewClientWithCodec.
+type ReplayCodec struct {
+	mu      sync.Mutex // protect following
+	cond    *sync.Cond
+	inbound []*Record
+	next    *Record // whose header was read last
+	written []*Record
+	sent    map[uint64]bool // Seqs written, when awaiting requests
+	await   bool
+	closed  bool
+}
+
+// NewServerReplay returns a codec replaying the requests a server read,
+// the inbound records of a capture made on the server.
+func NewServerReplay(records []*Record) *ReplayCodec {
+	return newReplayCodec(records, false)
+}
+
+// NewClientReplay returns a codec replaying the responses a client read,
+// the inbound records of a capture made on the client. A response is read
+// only once its request was written, so that the client has the call
+// pending.
+func NewClientReplay(records []*Record) *ReplayCodec {
+	return newReplayCodec(records, true)
+}
+
+func newReplayCodec(records []*Record, await bool) *ReplayCodec {
+	c := &ReplayCodec{await: await, sent: make(map[uint64]bool)}
+	c.cond = sync.NewCond(&c.mu)
+	for _, rec := range records {
+		if rec.Dir == Inbound {
+			c.inbound = append(c.inbound, rec)
+		}
+	}
+	return c
+}
+
+// ReadHeader reads the header of the next inbound record, io.EOF after
+// the last one.
+func (c *ReplayCodec) ReadHeader(h *codec.Header) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		if c.closed || len(c.inbound) == 0 {
+			return io.EOF
+		}
+		rec := c.inbound[0]
+		if !c.await || rec.Header.Seq == 0 || c.sent[rec.Header.Seq] {
+			c.inbound, c.next = c.inbound[1:], rec
+			*h = rec.Header
+			return nil
+		}
+		c.cond.Wait()
+	}
+}
+
+// ReadBody decodes the body of the record whose header was read last.
+func (c *ReplayCodec) ReadBody(body interface{}) error {
+	c.mu.Lock()
+	rec := c.next
+	c.mu.Unlock()
+	if body == nil || rec == nil || rec.Body == nil {
+		return nil
+	}
+	return rec.Decode(body)
+}
+
+// Write records the frame, see Written.
+func (c *ReplayCodec) Write(h *codec.Header, body interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	c.written = append(c.written, &Record{Time: time.Now(), Dir: Outbound, Header: *h, Body: encodeBody(body)})
+	c.sent[h.Seq] = true
+	c.cond.Broadcast()
+	return nil
+}
+
+// Written returns the frames written so far, in order.
+func (c *ReplayCodec) Written() []*Record {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*Record(nil), c.written...)
+}
+
+// Close makes ReadHeader return io.EOF.
+func (c *ReplayCodec) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.cond.Broadcast()
+	return nil
+}
+
+// ReplayServer serves the requests of a capture made on a server with
+// server, and returns its responses once they are all written.
+func ReplayServer(server *tinyrpc.Server, records []*Record) []*Record {
+	c := NewServerReplay(records)
+	server.ServeCodec(c)
+	return c.Written()
+}
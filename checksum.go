@@ -0,0 +1,117 @@
+package tinyrpc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// maxChecksumFrame is the largest payload of a checksummed frame; larger
+// writes are split, and a larger length read means a corrupted stream.
+const maxChecksumFrame = 1 << 24
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// checksumConn frames the codec stream of a connection with
+// Option.EnableChecksum: every write is sent as its length and CRC32C,
+// 4 bytes each, then its bytes. Reads only return the bytes of frames
+// whose checksum matched, and fail with ErrCorrupted from the first one
+// that didn't, so that nothing corrupted is decoded.
+type checksumConn struct {
+	io.ReadWriteCloser // for closing
+	r                  byteReader
+	frame              []byte // verified, not read yet
+	buf                []byte
+	err                error // sticky read error
+}
+
+func newChecksumConn(conn io.ReadWriteCloser) *checksumConn {
+	r, ok := conn.(byteReader)
+	if !ok {
+		r = bufio.NewReader(conn)
+	}
+	return &checksumConn{ReadWriteCloser: conn, r: r}
+}
+
+func (c *checksumConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > maxChecksumFrame {
+			n = maxChecksumFrame
+		}
+		frame := make([]byte, 8+n)
+		binary.BigEndian.PutUint32(frame, uint32(n))
+		binary.BigEndian.PutUint32(frame[4:], crc32.Checksum(p[:n], castagnoli))
+		copy(frame[8:], p[:n])
+		if _, err := c.ReadWriteCloser.Write(frame); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// next reads and verifies the next frame.
+func (c *checksumConn) next() error {
+	if c.err != nil {
+		return c.err
+	}
+	var head [8]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		c.err = err
+		return err
+	}
+	n := binary.BigEndian.Uint32(head[:])
+	if n > maxChecksumFrame {
+		c.err = fmt.Errorf("%w: frame of %d bytes", ErrCorrupted, n)
+		return c.err
+	}
+	if cap(c.buf) < int(n) {
+		c.buf = make([]byte, n)
+	}
+	frame := c.buf[:n]
+	if _, err := io.ReadFull(c.r, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		c.err = err
+		return err
+	}
+	if crc32.Checksum(frame, castagnoli) != binary.BigEndian.Uint32(head[4:]) {
+		c.err = fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
+		return c.err
+	}
+	c.frame = frame
+	return nil
+}
+
+func (c *checksumConn) Read(p []byte) (int, error) {
+	for len(c.frame) == 0 {
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.frame)
+	c.frame = c.frame[n:]
+	return n, nil
+}
+
+func (c *checksumConn) ReadByte() (byte, error) {
+	for len(c.frame) == 0 {
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+	b := c.frame[0]
+	c.frame = c.frame[1:]
+	return b, nil
+}
@@ -0,0 +1,109 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// manglingConn flips the last byte of the next read once armed, as
+// faulty network gear would.
+type manglingConn struct {
+	net.Conn
+	armed *int32
+}
+
+func (c manglingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && atomic.CompareAndSwapInt32(c.armed, 1, 0) {
+		p[n-1] ^= 0x40
+	}
+	return n, err
+}
+
+type manglingDialer struct{ armed *int32 }
+
+func (d manglingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := new(net.Dialer).DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return manglingConn{conn, d.armed}, nil
+}
+
+func TestChecksum_CorruptedRequest(t *testing.T) {
+	var armed int32
+	server := NewServer()
+	server.WrapConn(func(conn net.Conn) net.Conn { return manglingConn{conn, &armed} })
+	client, err := Dial("tcp", startServer(t, server).Addr().String(), &Option{HeartbeatIdle: -1, EnableChecksum: true})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+
+	atomic.StoreInt32(&armed, 1)
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(errors.Is(err, ErrCorrupted), "expect the server to detect the corruption, but got %v", err)
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil, "expect the connection closed")
+}
+
+func TestChecksum_CorruptedResponse(t *testing.T) {
+	var armed int32
+	addr := startServer(t, NewServer()).Addr().String()
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1, EnableChecksum: true, Dialer: manglingDialer{&armed}})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+
+	atomic.StoreInt32(&armed, 1)
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(errors.Is(err, ErrCorrupted), "expect the client to detect the corruption, but got %v", err)
+}
+
+func TestChecksum_LargeBody(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(Blob{})
+	client, err := Dial("tcp", startServer(t, server).Addr().String(), &Option{EnableChecksum: true})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var n int
+	err = client.Call("Blob.Len", make([]byte, 1<<20), &n)
+	_assert(err == nil && n == 1<<20, "failed to send a large body: %v", err)
+}
+
+func BenchmarkChecksum(b *testing.B) {
+	server := NewServer()
+	_ = server.Register(Blob{})
+	lis, _ := server.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(lis)
+	defer func() { _ = lis.Close() }()
+	for _, enabled := range []bool{false, true} {
+		name := "off"
+		if enabled {
+			name = "on"
+		}
+		b.Run(name, func(b *testing.B) {
+			client, err := Dial("tcp", lis.Addr().String(), &Option{EnableChecksum: enabled})
+			if err != nil {
+				b.Fatal("dial error:", err)
+			}
+			defer func() { _ = client.Close() }()
+			payload := make([]byte, 4<<10)
+			b.SetBytes(int64(len(payload)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var n int
+				if err := client.Call("Blob.Len", payload, &n); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
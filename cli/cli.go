@@ -0,0 +1,150 @@
+// Package cli calls the methods of a running tinyrpc server from the
+// command line, with arguments and a reply in JSON. It needs none of
+// the Go types of the server: the shapes of the arguments and the reply
+// are those the server describes with its "_schema_" service, see
+// tinyrpc.MethodSchema. A program only has to call Main:
+//
+//	func main() {
+//		os.Exit(cli.Main(os.Args[1:], os.Stdout, os.Stderr))
+//	}
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+	"tinyrpc"
+	"tinyrpc/codec"
+)
+
+// ErrBadArgs is returned for arguments that aren't JSON, or that don't
+// fit the schema of the arguments of the method.
+var ErrBadArgs = errors.New("cli: bad arguments")
+
+// Error is an error sent by the server, with its code, see
+// tinyrpc.ErrorCode.
+type Error struct {
+	Code    string // "" if the server sent none
+	Message string
+	err     error
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return e.Code + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error { return e.err }
+
+// serverError returns err as an *Error if the server sent it.
+func serverError(err error) error {
+	if !tinyrpc.IsServerError(err) {
+		return err
+	}
+	return &Error{Code: tinyrpc.ErrorCode(err), Message: err.Error(), err: err}
+}
+
+// Call calls serviceMethod on the server at rpcAddr, see tinyrpc.XDial,
+// with args, a JSON value, and writes its reply to w as JSON.
+//
+// The arguments are checked against the schema of the method, then sent
+// as JSON if the connection allows it, that is if its codec is
+// codec.JsonType or the client set Option.AllowBodyCodecs. Otherwise
+// they are decoded into a value of a type built from the schema, which
+// the codec of the connection encodes like the type of the server.
+func Call(ctx context.Context, w io.Writer, rpcAddr, serviceMethod, args string, opts ...tinyrpc.ClientOption) error {
+	client, err := tinyrpc.XDial(rpcAddr, opts...)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
+
+	var schema tinyrpc.MethodSchema
+	if err := client.CallContext(ctx, "_schema_.Method", serviceMethod, &schema); err != nil {
+		return serverError(err)
+	}
+	argType, err := typeOf(schema.Args)
+	if err != nil {
+		return fmt.Errorf("cli: arguments of %s: %w", serviceMethod, err)
+	}
+	argv := reflect.New(argType)
+	dec := json.NewDecoder(bytes.NewReader([]byte(args)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(argv.Interface()); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadArgs, err)
+	}
+	if dec.More() {
+		return fmt.Errorf("%w: more than one JSON value", ErrBadArgs)
+	}
+
+	state := client.ConnState()
+	if state.Codec == codec.JsonType || state.BodyCodecs {
+		var opts []tinyrpc.CallOption
+		if state.Codec != codec.JsonType {
+			opts = append(opts, tinyrpc.WithBodyCodec(codec.JsonType))
+		}
+		var reply json.RawMessage
+		if err := client.CallContext(ctx, serviceMethod, json.RawMessage(args), &reply, opts...); err != nil {
+			return serverError(err)
+		}
+		var out bytes.Buffer
+		if err := json.Indent(&out, reply, "", "  "); err != nil {
+			return err
+		}
+		out.WriteByte('\n')
+		_, err := out.WriteTo(w)
+		return err
+	}
+
+	replyType, err := typeOf(schema.Reply)
+	if err != nil {
+		return fmt.Errorf("cli: reply of %s: %w", serviceMethod, err)
+	}
+	replyv := reflect.New(replyType)
+	if err := client.CallContext(ctx, serviceMethod, argv.Elem().Interface(), replyv.Interface()); err != nil {
+		return serverError(err)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(replyv.Interface())
+}
+
+// Main runs the command line args, "[-timeout d] addr Service.Method
+// [json]", writing the reply to stdout and the errors to stderr, and
+// returns the exit code. The arguments default to null, the zero value.
+func Main(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("tinyrpc", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	timeout := fs.Duration("timeout", 10*time.Second, "of the call, the handshake included")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(stderr, "usage: [-timeout d] addr Service.Method [json]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 2 || fs.NArg() > 3 {
+		fs.Usage()
+		return 2
+	}
+	callArgs := "null"
+	if fs.NArg() == 3 {
+		callArgs = fs.Arg(2)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	err := Call(ctx, stdout, fs.Arg(0), fs.Arg(1), callArgs, tinyrpc.WithConnectTimeout(*timeout))
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
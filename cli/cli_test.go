@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"tinyrpc"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+type Item struct {
+	Name  string
+	Count int
+}
+
+type Order struct {
+	ID    int64
+	Items []Item
+	Tags  map[string]bool
+	Note  *string
+}
+
+type Total struct {
+	Items int
+	Tags  []string
+}
+
+type Shop struct{}
+
+func (Shop) Total(order Order, reply *Total) error {
+	if order.ID == 0 {
+		return fmt.Errorf("%w: no id", tinyrpc.ErrInvalidArgument)
+	}
+	for _, it := range order.Items {
+		reply.Items += it.Count
+	}
+	for tag := range order.Tags {
+		reply.Tags = append(reply.Tags, tag)
+	}
+	return nil
+}
+
+func (Shop) Echo(s string, reply *string) error {
+	*reply = s
+	return nil
+}
+
+func startServer(t *testing.T) string {
+	t.Helper()
+	server := tinyrpc.NewServer()
+	_ = server.Register(Shop{})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	go server.Accept(lis)
+	t.Cleanup(func() { _ = lis.Close() })
+	return "tcp@" + lis.Addr().String()
+}
+
+func TestCall(t *testing.T) {
+	addr := startServer(t)
+	args := `{"ID": 7, "Items": [{"Name": "a", "Count": 2}, {"Name": "b", "Count": 3}], "Tags": {"gift": true}, "Note": "x"}`
+	for _, opt := range []*tinyrpc.Option{{HeartbeatIdle: -1}, {HeartbeatIdle: -1, AllowBodyCodecs: true}} {
+		var out bytes.Buffer
+		err := Call(context.Background(), &out, addr, "Shop.Total", args, opt)
+		_assert(err == nil, "call error: %v", err)
+		var total Total
+		_assert(json.Unmarshal(out.Bytes(), &total) == nil, "expect the reply printed as JSON, but got %q", out.String())
+		_assert(total.Items == 5 && len(total.Tags) == 1 && total.Tags[0] == "gift",
+			"expect the args decoded by the server (body codecs %v), but got %+v", opt.AllowBodyCodecs, total)
+	}
+
+	var out bytes.Buffer
+	_assert(Call(context.Background(), &out, addr, "Shop.Echo", `"hi"`) == nil && out.String() == "\"hi\"\n",
+		"expect a reply of a basic type, but got %q", out.String())
+}
+
+func TestCall_Errors(t *testing.T) {
+	addr := startServer(t)
+	ctx := context.Background()
+
+	err := Call(ctx, &bytes.Buffer{}, addr, "Shop.Missing", `{}`)
+	var e *Error
+	_assert(errors.As(err, &e) && e.Code == "method_not_found" && strings.Contains(e.Message, "Missing"),
+		"expect the code and message of a bad method, but got %#v", err)
+	_assert(errors.Is(err, tinyrpc.ErrMethodNotFound), "expect the sentinel kept, but got %v", err)
+
+	err = Call(ctx, &bytes.Buffer{}, addr, "Shop.Total", `{}`)
+	_assert(errors.As(err, &e) && e.Code == "invalid_argument" && strings.HasPrefix(err.Error(), "invalid_argument: "),
+		"expect the code of a handler error, but got %v", err)
+
+	for _, args := range []string{`{"ID": `, `{"Id": 1, "Price": 2}`, `{"ID": "seven"}`, `{} {}`} {
+		err := Call(ctx, &bytes.Buffer{}, addr, "Shop.Total", args)
+		_assert(errors.Is(err, ErrBadArgs), "expect ErrBadArgs for %s, but got %v", args, err)
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	addr := startServer(t)
+	var stdout, stderr bytes.Buffer
+	code := Main([]string{"-timeout", "5s", addr, "Shop.Echo", `"hi"`}, &stdout, &stderr)
+	_assert(code == 0 && stdout.String() == "\"hi\"\n", "expect the reply, but got %d, %q, %q", code, stdout.String(), stderr.String())
+
+	stdout.Reset()
+	code = Main([]string{addr, "Shop.Missing"}, &stdout, &stderr)
+	_assert(code == 1 && strings.Contains(stderr.String(), "error: method_not_found: "), "expect the error printed, but got %d, %q", code, stderr.String())
+	_assert(Main([]string{addr}, &stdout, &stderr) == 2, "expect a usage error")
+}
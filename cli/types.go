@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"tinyrpc"
+)
+
+// leafTypes are the types of the kinds of the leaf fields of a schema.
+var leafTypes = map[string]reflect.Type{
+	"bool":       reflect.TypeOf(false),
+	"int":        reflect.TypeOf(int(0)),
+	"int8":       reflect.TypeOf(int8(0)),
+	"int16":      reflect.TypeOf(int16(0)),
+	"int32":      reflect.TypeOf(int32(0)),
+	"int64":      reflect.TypeOf(int64(0)),
+	"uint":       reflect.TypeOf(uint(0)),
+	"uint8":      reflect.TypeOf(uint8(0)),
+	"uint16":     reflect.TypeOf(uint16(0)),
+	"uint32":     reflect.TypeOf(uint32(0)),
+	"uint64":     reflect.TypeOf(uint64(0)),
+	"uintptr":    reflect.TypeOf(uintptr(0)),
+	"float32":    reflect.TypeOf(float32(0)),
+	"float64":    reflect.TypeOf(float64(0)),
+	"string":     reflect.TypeOf(""),
+	"interface":  reflect.TypeOf((*interface{})(nil)).Elem(),
+	"[]byte":     reflect.TypeOf([]byte(nil)),
+	"struct{}":   reflect.TypeOf(struct{}{}),
+	"time.Time":  reflect.TypeOf(time.Time{}),
+	"complex64":  reflect.TypeOf(complex64(0)),
+	"complex128": reflect.TypeOf(complex128(0)),
+}
+
+// shape is a node of the tree of fields of a schema.
+type shape struct {
+	leaf      string // the kind of a leaf field
+	names     []string
+	fields    map[string]*shape // by name, in the order of names
+	key, elem *shape            // of a map, or elem of a slice
+}
+
+func (sh *shape) field(name string) *shape {
+	if f := sh.fields[name]; f != nil {
+		return f
+	}
+	if sh.fields == nil {
+		sh.fields = make(map[string]*shape)
+	}
+	f := &shape{}
+	sh.fields[name], sh.names = f, append(sh.names, name)
+	return f
+}
+
+// at returns the node of path, e.g. "Items[].Name", made if needed.
+func (sh *shape) at(path string) *shape {
+	for path != "" {
+		switch {
+		case strings.HasPrefix(path, "[]"):
+			if sh.elem == nil {
+				sh.elem = &shape{}
+			}
+			sh, path = sh.elem, path[len("[]"):]
+		case strings.HasPrefix(path, "[key]"):
+			if sh.key == nil {
+				sh.key = &shape{}
+			}
+			sh, path = sh.key, path[len("[key]"):]
+		default:
+			path = strings.TrimPrefix(path, ".")
+			n := strings.IndexAny(path, ".[")
+			if n < 0 {
+				n = len(path)
+			}
+			sh, path = sh.field(path[:n]), path[n:]
+		}
+	}
+	return sh
+}
+
+func (sh *shape) typ() (reflect.Type, error) {
+	switch {
+	case sh.leaf != "":
+		if t := leafTypes[sh.leaf]; t != nil {
+			return t, nil
+		}
+		return nil, fmt.Errorf("fields of kind %s are not supported", sh.leaf)
+	case sh.key != nil:
+		if sh.elem == nil {
+			return nil, errors.New("map without values")
+		}
+		key, err := sh.key.typ()
+		if err != nil {
+			return nil, err
+		}
+		elem, err := sh.elem.typ()
+		if err != nil {
+			return nil, err
+		}
+		return reflect.MapOf(key, elem), nil
+	case sh.elem != nil:
+		elem, err := sh.elem.typ()
+		if err != nil {
+			return nil, err
+		}
+		return reflect.SliceOf(elem), nil
+	}
+	fields := make([]reflect.StructField, 0, len(sh.names))
+	for _, name := range sh.names {
+		t, err := sh.fields[name].typ()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		fields = append(fields, reflect.StructField{Name: name, Type: t})
+	}
+	return reflect.StructOf(fields), nil
+}
+
+// typeOf returns a type of schema s, built field by field: the codecs
+// encode its values like those of the type s describes. Structs are
+// built with reflect.StructOf rather than decoded into maps, which gob
+// doesn't match with structs, and arrays and pointers become slices and
+// values.
+func typeOf(s tinyrpc.Schema) (reflect.Type, error) {
+	if len(s.Fields) == 0 {
+		return nil, errors.New("empty schema")
+	}
+	root := &shape{}
+	for _, f := range s.Fields {
+		path, kind := "", f
+		if i := strings.LastIndexByte(f, ' '); i >= 0 {
+			path, kind = f[:i], f[i+1:]
+		}
+		root.at(path).leaf = kind
+	}
+	return root.typ()
+}
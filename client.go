@@ -5,14 +5,19 @@
 package tinyrpc
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"strings"
 	"sync"
+	"time"
 	"tinyrpc/codec"
+	"tinyrpc/internal/clock"
+	"tinyrpc/rpclog"
 )
 
 // Call represents an active RPC.
@@ -23,9 +28,28 @@ type Call struct {
 	Reply         interface{} // reply from the function
 	Error         error       // if error occurs, it will be set
 	Done          chan *Call  // Strobes when call is complete.
+	Metadata      Metadata    // sent with the request
+	Trailer       Metadata    // set by the server with the response
+	BodyCodec     codec.Type  // of Args and Reply, see WithBodyCodec
+	Compression   string      // of Args, see WithCompression
+	Checksum      bool        // of Args, see WithChecksum
+	opts          []CallOption
+	progress      chan struct{} // progress frames, see WithProgressKeepalive
+	progressIdle  time.Duration
+	onProgress    func(ProgressReport) // see WithProgress
+	stats         *clientStats         // nil for calls not counted
+	start         time.Time
+	trace         *ClientTrace
+	wrote         chan struct{} // closed once WroteRequest is called, if traced
 }
 
 func (call *Call) done() {
+	if call.stats != nil {
+		call.stats.end(time.Since(call.start), call.Error)
+	}
+	for _, opt := range call.opts {
+		opt.after(call)
+	}
 	call.Done <- call
 }
 
@@ -35,20 +59,47 @@ func (call *Call) done() {
 // multiple goroutines simultaneously.
 type Client struct {
 	cc       codec.Codec
+	bulk     codec.Codec // of the bulk channel, nil without Option.BulkChannel
 	opt      *Option
-	sending  sync.Mutex // protect following
-	header   codec.Header
-	mu       sync.Mutex // protect following
+	config   *clientConfig // holds opt
+	mu       sync.Mutex    // protect following
 	seq      uint64
 	pending  map[uint64]*Call
-	closing  bool // user has called Close
-	shutdown bool // server has told us to stop
+	closing  bool  // user has called Close
+	shutdown bool  // server has told us to stop
+	goAway   bool  // server is draining the connection
+	closeErr error // why the client closed the connection, e.g. a heartbeat timeout
+
+	clock        Clock
+	lastActivity int64         // unix nanoseconds, accessed atomically
+	done         chan struct{} // closed when the receive loop ends
+
+	subs        map[string][]*subscription // by topic, protected by mu
+	timeouts    methodTimeouts
+	pushDropped uint64 // accessed atomically
+	stats       clientStats
+	bodyCodecs  map[codec.Type]bool // advertised by the server
+	encodings   bodyEncodings       // advertised by the server
+	state       ConnState           // agreed on in the handshake
+	schemas     map[schemaKey]error // checked, protected by mu
+	outstanding int                 // pending calls against WithMaxOutstanding, protected by mu
+	decoders    chan struct{}       // a slot per decode worker, nil without WithDecodeWorkers
+}
+
+// ProtocolVersion returns the version of the protocol agreed on with the
+// server, see Option.ProtocolVersion.
+func (client *Client) ProtocolVersion() int {
+	return client.state.Version
 }
 
 var _ io.Closer = (*Client)(nil)
 
 var ErrShutdown = errors.New("connection is shut down")
 
+// ErrGoAway is returned for new calls after the server announced that it is
+// shutting down. Calls already sent still complete; reconnect to issue more.
+var ErrGoAway = errors.New("connection is going away")
+
 // Close the connection
 func (client *Client) Close() error {
 	client.mu.Lock()
@@ -64,7 +115,7 @@ func (client *Client) Close() error {
 func (client *Client) IsAvailable() bool {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	return !client.shutdown && !client.closing
+	return !client.shutdown && !client.closing && !client.goAway
 }
 
 func (client *Client) registerCall(call *Call) (uint64, error) {
@@ -73,6 +124,15 @@ func (client *Client) registerCall(call *Call) (uint64, error) {
 	if client.closing || client.shutdown {
 		return 0, ErrShutdown
 	}
+	if client.goAway {
+		return 0, ErrGoAway
+	}
+	if call.stats != nil {
+		if max := client.config.maxOutstanding; max > 0 && client.outstanding >= max {
+			return 0, ErrClientOverloaded
+		}
+		client.outstanding++
+	}
 	call.Seq = client.seq
 	client.pending[call.Seq] = call
 	client.seq++
@@ -83,27 +143,53 @@ func (client *Client) removeCall(seq uint64) *Call {
 	client.mu.Lock()
 	defer client.mu.Unlock()
 	call := client.pending[seq]
-	delete(client.pending, seq)
+	if call != nil {
+		delete(client.pending, seq)
+		if call.stats != nil {
+			client.outstanding--
+		}
+	}
 	return call
 }
 
-func (client *Client) terminateCalls(err error) {
-	client.sending.Lock()
-	defer client.sending.Unlock()
+// terminateCalls fails the pending calls, and returns the error they
+// failed with.
+func (client *Client) terminateCalls(err error) error {
 	client.mu.Lock()
 	defer client.mu.Unlock()
 	client.shutdown = true
-	for _, call := range client.pending {
+	close(client.done)
+	if client.closeErr != nil {
+		err = client.closeErr
+	}
+	for seq, call := range client.pending {
+		delete(client.pending, seq) // so that send doesn't complete it again
+		if call.stats != nil {
+			client.outstanding--
+		}
 		call.Error = err
 		call.done()
 	}
+	for topic, subs := range client.subs {
+		for _, sub := range subs {
+			close(sub.ch)
+		}
+		delete(client.subs, topic)
+	}
+	return err
 }
 
 func (client *Client) send(call *Call) {
-	// make sure that the client will send a complete request
-	client.sending.Lock()
-	defer client.sending.Unlock()
-
+	if call.BodyCodec != "" && !client.bodyCodecs[call.BodyCodec] {
+		call.Error = fmt.Errorf("%w: %s", ErrBodyCodec, call.BodyCodec)
+		call.done()
+		return
+	}
+	if err := client.encodings.check(call); err != nil {
+		call.Error = err
+		call.done()
+		return
+	}
 	// register this call.
 	seq, err := client.registerCall(call)
 	if err != nil {
@@ -112,13 +198,46 @@ func (client *Client) send(call *Call) {
 		return
 	}
 
+	args, bodyCodec := call.Args, call.BodyCodec
+	pre, _ := args.(*PreencodedBody)
+	if pre != nil {
+		args = pre.args
+		if t := pre.encodedFor(client, call); t != "" {
+			bodyCodec = t
+		} else {
+			pre = nil
+		}
+	}
+
+	md := call.Metadata
+	if s := client.config.signer; s != nil && !strings.HasPrefix(call.ServiceMethod, BuiltinPrefix) {
+		if md, err = s.sign(call.ServiceMethod, seq, args, md, client.clock.Now()); err != nil {
+			if call := client.removeCall(seq); call != nil {
+				call.Error = err
+				call.done()
+			}
+			return
+		}
+	}
+
 	// prepare request header
-	client.header.ServiceMethod = call.ServiceMethod
-	client.header.Seq = seq
-	client.header.Error = ""
+	h := codec.Header{ServiceMethod: call.ServiceMethod, Seq: seq, Metadata: md, BodyCodec: bodyCodec,
+		Compression: call.Compression, Checksum: call.Checksum}
 
-	// encode and send the request
-	if err := client.cc.Write(&client.header, call.Args); err != nil {
+	// encode and send the request, the codec writes it whole
+	client.touch()
+	if pre != nil {
+		err = writeEncoded(client.cc, &h, pre.data)
+	} else {
+		err = client.cc.Write(&h, args)
+	}
+	if t := call.trace; t != nil {
+		if t.WroteRequest != nil {
+			t.WroteRequest(call.ServiceMethod, seq, err)
+		}
+		close(call.wrote)
+	}
+	if err != nil {
 		call := client.removeCall(seq)
 		// call may be nil, it usually means that Write partially failed,
 		// client has received the response and handled
@@ -129,38 +248,106 @@ func (client *Client) send(call *Call) {
 	}
 }
 
-func (client *Client) receive() {
+// readFrames reads the frames of cc and completes their calls, until it
+// fails.
+func (client *Client) readFrames(cc codec.Codec) error {
 	var err error
 	for err == nil {
 		var h codec.Header
-		if err = client.cc.ReadHeader(&h); err != nil {
+		if err = cc.ReadHeader(&h); err != nil {
 			break
 		}
+		client.touch()
+		if h.Seq == 0 && h.ServiceMethod == "" && h.Error != "" {
+			err = newServerError(h.Error, h.Code) // about the connection, e.g. ErrCorrupted
+			break
+		}
+		if h.Seq == 0 && h.ServiceMethod == goAwayMethod {
+			client.mu.Lock()
+			client.goAway = true
+			client.mu.Unlock()
+			err = codec.DiscardBody(cc)
+			continue
+		}
+		if h.Seq != 0 && h.ServiceMethod == progressMethod {
+			client.progress(h.Seq, h.Metadata)
+			err = codec.DiscardBody(cc)
+			continue
+		}
+		if h.Seq == 0 && h.ServiceMethod == publishMethod {
+			var data []byte
+			if err = cc.ReadBody(&data); err == nil {
+				client.deliver(h.Metadata["topic"], h.Metadata["retained"], data)
+			}
+			continue
+		}
 		call := client.removeCall(h.Seq)
+		var trace *ClientTrace
+		if call != nil {
+			call.Trailer = h.Metadata
+			trace = call.trace
+		}
+		if trace != nil && trace.GotResponseHeader != nil {
+			<-call.wrote // so that WroteRequest is called first
+			var callErr error
+			if h.Error != "" {
+				callErr = newServerError(h.Error, h.Code)
+			}
+			trace.GotResponseHeader(call.ServiceMethod, h.Seq, callErr)
+		}
 		switch {
 		case call == nil:
 			// it usually means that Write partially failed
 			// and call was already removed.
-			err = client.cc.ReadBody(nil)
+			err = codec.DiscardBody(cc)
 		case h.Error != "":
-			call.Error = fmt.Errorf(h.Error)
-			err = client.cc.ReadBody(nil)
-			call.done()
-		default:
-			err = client.cc.ReadBody(call.Reply)
-			if err != nil {
-				call.Error = errors.New("reading body " + err.Error())
+			call.Error = newServerError(h.Error, h.Code)
+			if se, ok := call.Error.(*serverError); ok {
+				se.retryAfter = parseRetryAfter(h.Metadata)
 			}
+			err = codec.DiscardBody(cc)
 			call.done()
+		default:
+			err = client.readReply(cc, call)
 		}
 	}
+	return err
+}
+
+// receiveBulk reads the frames of the bulk channel, see Option.BulkChannel.
+// The connection is closed when it fails, which ends receive.
+func (client *Client) receiveBulk() {
+	err := client.readFrames(client.bulk)
+	client.mu.Lock()
+	if client.closeErr == nil && !client.closing && err != io.EOF {
+		client.closeErr = err
+	}
+	client.mu.Unlock()
+	_ = client.cc.Close()
+}
+
+func (client *Client) receive() {
+	err := client.readFrames(client.cc)
+	if errors.Is(err, codec.ErrStreamCorrupt) {
+		err = fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+	if errors.Is(err, ErrCorrupted) {
+		_ = client.cc.Close() // the stream can't be trusted
+	}
 	// error occurs, so terminateCalls pending calls
-	client.terminateCalls(err)
+	err = client.terminateCalls(err)
+	if t := client.config.trace; t != nil && t.ConnClosed != nil {
+		t.ConnClosed(err)
+	}
 }
 
 // Go invokes the function asynchronously.
 // It returns the Call structure representing the invocation.
-func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+// reply must be a non-nil pointer, or DiscardReply. A reply that the codec
+// of the connection fails to decode and can't skip, see
+// codec.ErrStreamCorrupt, closes the connection and fails the pending
+// calls with ErrCorrupted.
+func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call, opts ...CallOption) *Call {
 	if done == nil {
 		done = make(chan *Call, 10)
 	} else if cap(done) == 0 {
@@ -171,6 +358,26 @@ func (client *Client) Go(serviceMethod string, args, reply interface{}, done cha
 		Args:          args,
 		Reply:         reply,
 		Done:          done,
+		opts:          opts,
+		trace:         client.config.trace,
+	}
+	for _, opt := range opts {
+		opt.before(call)
+	}
+	if call.trace != nil {
+		call.wrote = make(chan struct{})
+	}
+	if err := checkReply(reply); err != nil {
+		call.Error = err
+		call.done()
+		return call
+	}
+	if !strings.HasPrefix(serviceMethod, BuiltinPrefix) { // e.g. heartbeat pings
+		call.stats, call.start = &client.stats, time.Now()
+		call.stats.begin()
+		if ids := client.config.atMostOnce; ids != nil && call.Metadata[AtMostOnceHeader] == "" {
+			WithHeader(AtMostOnceHeader, ids.callID(nextCallSeq())).before(call)
+		}
 	}
 	client.send(call)
 	return call
@@ -178,11 +385,99 @@ func (client *Client) Go(serviceMethod string, args, reply interface{}, done cha
 
 // Call invokes the named function, waits for it to complete,
 // and returns its error status.
-func (client *Client) Call(serviceMethod string, args, reply interface{}) error {
-	call := <-client.Go(serviceMethod, args, reply, make(chan *Call, 1)).Done
+func (client *Client) Call(serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	if client.timeouts.lookup(serviceMethod) > 0 || hasProgressKeepalive(opts) || client.config.wrapsCalls() ||
+		client.config.schemaCheck != SchemaCheckOff {
+		return client.CallContext(context.Background(), serviceMethod, args, reply, opts...)
+	}
+	call := <-client.Go(serviceMethod, args, reply, make(chan *Call, 1), opts...).Done
 	return call.Error
 }
 
+// Dialer establishes the connection for a Client.
+// *net.Dialer implements it; custom ones may add proxies, custom DNS,
+// or return in-memory connections in tests.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// CallContext is like Call, but gives up waiting when ctx is done.
+// The response, if it still arrives, is discarded. The deadline of ctx
+// is propagated to the server, which stops handling the call after it.
+// Calls are made through the interceptors and the retry policy of the
+// client, see WithInterceptors and WithRetryPolicy.
+func (client *Client) CallContext(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	if err := client.checkSchema(ctx, serviceMethod, args, reply); err != nil {
+		return err
+	}
+	if client.config.atMostOnce != nil {
+		ctx = AtMostOnceContext(ctx) // for the retries
+	}
+	if !client.config.wrapsCalls() {
+		return client.callContext(ctx, serviceMethod, args, reply, opts...)
+	}
+	return client.wrapCall(ctx, serviceMethod, args, func(ctx context.Context) error {
+		return client.callContext(ctx, serviceMethod, args, reply, opts...)
+	})
+}
+
+func (client *Client) callContext(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	if d := client.timeouts.lookup(serviceMethod); d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = clock.WithTimeout(client.clock, ctx, d)
+		defer cancel()
+	}
+	opts = withDeadlineHeader(client.clock, ctx, opts)
+	if seq, ok := ctx.Value(callSeqKey{}).(uint64); ok && client.config.atMostOnce != nil {
+		opts = append(opts[:len(opts):len(opts)], WithHeader(AtMostOnceHeader, client.config.atMostOnce.callID(seq)))
+	}
+	if trace := ContextClientTrace(ctx); trace != nil {
+		opts = append(opts[:len(opts):len(opts)], traceOption{trace})
+	}
+	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1), opts...)
+	var idle clock.Timer
+	var idleC <-chan time.Time
+	if call.progressIdle > 0 {
+		idle = client.clock.NewTimer(call.progressIdle)
+		defer func() { idle.Stop() }()
+		idleC = idle.C()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return client.abandon(call, contextError(ctx.Err()))
+		case <-idleC:
+			return client.abandon(call, ErrDeadlineExceeded)
+		case <-call.progress:
+			idle.Stop()
+			idle = client.clock.NewTimer(call.progressIdle)
+			idleC = idle.C()
+		case call := <-call.Done:
+			return call.Error
+		}
+	}
+}
+
+// abandon stops waiting for call, telling the server, and returns err.
+func (client *Client) abandon(call *Call, err error) error {
+	if client.removeCall(call.Seq) != nil {
+		if call.stats != nil {
+			call.stats.end(time.Since(call.start), err)
+		}
+		client.sendCancel(call.Seq)
+	}
+	return fmt.Errorf("rpc client: call failed: %w", err)
+}
+
+// sendCancel tells the server to stop handling the call seq, on a best
+// effort basis.
+func (client *Client) sendCancel(seq uint64) {
+	h := codec.Header{ServiceMethod: cancelMethod, Seq: seq}
+	if err := client.cc.Write(&h, invalidRequest); err != nil {
+		client.config.log(rpclog.LevelError, "send cancel error", "err", err)
+	}
+}
+
 func parseOptions(opts ...*Option) (*Option, error) {
 	// if opts is nil or pass nil as parameter
 	if len(opts) == 0 || opts[0] == nil {
@@ -199,48 +494,235 @@ func parseOptions(opts ...*Option) (*Option, error) {
 	return opt, nil
 }
 
+// NewClient performs the handshake on conn with opt and returns a client
+// making its calls on conn.
 func NewClient(conn net.Conn, opt *Option) (*Client, error) {
+	return newClient(conn, &clientConfig{opt: *opt})
+}
+
+func newClient(conn net.Conn, cfg *clientConfig) (*Client, error) {
+	opt := &cfg.opt
+	start := clock.Or(opt.Clock).Now()
 	f := codec.NewCodecFuncMap[opt.CodecType]
 	if f == nil {
 		err := fmt.Errorf("invalid codec type %s", opt.CodecType)
-		log.Println("rpc client: codec error:", err)
+		cfg.log(rpclog.LevelError, "codec error", "err", err)
 		return nil, err
 	}
+	raw := conn
+	var wconn *writeTimeoutConn
+	if d := opt.writeTimeout(); d > 0 {
+		wconn = &writeTimeoutConn{Conn: conn, timeout: d}
+		conn = wconn
+	}
 	// send options with server
 	if err := json.NewEncoder(conn).Encode(opt); err != nil {
-		log.Println("rpc client: options error: ", err)
+		cfg.log(rpclog.LevelError, "options error", "err", err)
 		_ = conn.Close()
 		return nil, err
 	}
-	return newClientCodec(f(conn), opt), nil
+	var stream io.ReadWriteCloser = conn
+	var reply *handshakeReply
+	codecType := opt.CodecType
+	if opt.wantsHandshakeReply() {
+		t, r, s, err := negotiateCodec(conn, opt)
+		if err != nil {
+			cfg.log(rpclog.LevelError, "codec negotiation error", "err", err)
+			_ = conn.Close()
+			return nil, err
+		}
+		f, reply, stream, codecType = codec.NewCodecFuncMap[t], r, s, t
+		if opt.EncryptionKeyID != "" {
+			if !reply.Encrypted {
+				err = errors.New("rpc client: the server doesn't encrypt the stream")
+			} else {
+				stream, err = codec.NewEncryptedConn(stream, opt.EncryptionKey, true)
+			}
+			if err != nil {
+				cfg.log(rpclog.LevelError, "encryption error", "err", err)
+				_ = conn.Close()
+				return nil, err
+			}
+		}
+		if opt.EnableChecksum {
+			if !reply.Checksum {
+				err := errors.New("rpc client: the server doesn't checksum frames")
+				cfg.log(rpclog.LevelError, "codec negotiation error", "err", err)
+				_ = conn.Close()
+				return nil, err
+			}
+			stream = newChecksumConn(stream)
+		}
+	}
+	var bulk codec.Codec
+	if opt.BulkChannel && reply.BulkChannel {
+		seg := newSegmentConn(stream)
+		stream = seg.channel(controlChannel)
+		if bulk = f(seg.channel(bulkChannel)); opt.WrapCodec != nil {
+			bulk = opt.WrapCodec(bulk)
+		}
+	}
+	cc := f(stream)
+	if opt.WrapCodec != nil {
+		cc = opt.WrapCodec(cc)
+	}
+	var bodyCodecs map[codec.Type]bool
+	if reply != nil && opt.AllowBodyCodecs {
+		bodyCodecs = make(map[codec.Type]bool, len(reply.BodyCodecs))
+		for _, t := range reply.BodyCodecs {
+			bodyCodecs[t] = true
+		}
+	}
+	client := newClientCodec(cc, bulk, cfg, bodyCodecs)
+	if bodyCodecs != nil {
+		client.encodings = newBodyEncodings(reply)
+	}
+	if wconn != nil {
+		wconn.client.Store(client)
+	}
+	client.state = ConnState{
+		Codec:             codecType,
+		BodyCodecs:        bodyCodecs != nil,
+		Version:           agreedVersion(opt, reply),
+		Checksum:          opt.EnableChecksum,
+		Bulk:              bulk != nil,
+		EncryptionKeyID:   opt.EncryptionKeyID,
+		TLS:               tlsState(raw),
+		RemoteAddr:        remoteAddr(raw),
+		HandshakeDuration: clock.Or(opt.Clock).Now().Sub(start),
+	}
+	return client, nil
+}
+
+// NewClientWithCodec returns a client making its calls with cc, a codec
+// past the handshake, e.g. one replaying captured traffic. opt.WrapCodec
+// is not applied.
+func NewClientWithCodec(cc codec.Codec, opt *Option) (*Client, error) {
+	opt, err := parseOptions(opt)
+	if err != nil {
+		return nil, err
+	}
+	client := newClientCodec(cc, nil, &clientConfig{opt: *opt}, nil)
+	client.state = ConnState{Codec: opt.CodecType, Version: opt.protocolVersion()}
+	return client, nil
 }
 
-func newClientCodec(cc codec.Codec, opt *Option) *Client {
+func newClientCodec(cc, bulk codec.Codec, cfg *clientConfig, bodyCodecs map[codec.Type]bool) *Client {
+	opt := &cfg.opt
 	client := &Client{
 		seq:     1, // seq starts with 1, 0 means invalid call
 		cc:      cc,
+		bulk:    bulk,
 		opt:     opt,
+		config:  cfg,
 		pending: make(map[uint64]*Call),
+		clock:   clock.Or(opt.Clock),
+		done:    make(chan struct{}),
+
+		bodyCodecs: bodyCodecs,
+	}
+	if cfg.decodeWorkers > 0 {
+		client.decoders = make(chan struct{}, cfg.decodeWorkers)
 	}
+	client.touch()
 	go client.receive()
+	if bulk != nil {
+		go client.receiveBulk()
+	}
+	if client.heartbeatIdle() > 0 {
+		go client.heartbeat()
+	}
 	return client
 }
 
-// Dial connects to an RPC server at the specified network address
-func Dial(network, address string, opts ...*Option) (client *Client, err error) {
-	opt, err := parseOptions(opts...)
+// dialConn opens a connection with the Dialer and SocketOptions of opt.
+func dialConn(ctx context.Context, opt *Option, network, address string) (net.Conn, error) {
+	sockOpt := opt.Socket
+	if sockOpt == nil {
+		sockOpt = DefaultSocketOptions
+	}
+	var dialer Dialer = opt.Dialer
+	if dialer == nil {
+		dialer = sockOpt.dialer()
+	}
+	conn, err := dialer.DialContext(ctx, network, address)
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.Dial(network, address)
+	sockOpt.apply(conn)
+	return conn, nil
+}
+
+// Dial connects to an RPC server at the specified network address,
+// configured by opts: at most one *Option, the base, overridden by the
+// other ClientOptions, e.g. WithConnectTimeout.
+func Dial(network, address string, opts ...ClientOption) (client *Client, err error) {
+	return DialContext(context.Background(), network, address, opts...)
+}
+
+// DialContext connects to an RPC server at the specified network address.
+// ctx is handed to the Dialer, so it bounds or cancels connecting, and
+// so does Option.ConnectTimeout.
+func DialContext(ctx context.Context, network, address string, opts ...ClientOption) (client *Client, err error) {
+	cfg, err := parseClientOptions(opts...)
 	if err != nil {
 		return nil, err
 	}
+	opt := &cfg.opt
+	dialCtx := ctx
+	if opt.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, opt.ConnectTimeout)
+		defer cancel()
+	}
+	trace := ContextClientTrace(ctx)
+	cfg.trace = trace
+	if trace != nil && trace.DialStart != nil {
+		trace.DialStart(network, address)
+	}
+	conn, err := dialConn(dialCtx, opt, network, address)
+	if trace != nil && trace.DialDone != nil {
+		trace.DialDone(network, address, err)
+	}
+	if err != nil {
+		switch {
+		case ctx.Err() != nil:
+			return nil, fmt.Errorf("rpc client: dial %s: %w", address, contextError(ctx.Err()))
+		case dialCtx.Err() != nil:
+			return nil, fmt.Errorf("%w: expect within %s", ErrConnectTimeout, opt.ConnectTimeout)
+		}
+		return nil, err
+	}
 	// close the connection if client is nil
 	defer func() {
 		if err != nil {
 			_ = conn.Close()
 		}
 	}()
-	return NewClient(conn, opt)
+	client, err = newClient(conn, cfg)
+	if trace != nil && trace.HandshakeDone != nil {
+		var state ConnState
+		if client != nil {
+			state = client.ConnState()
+		}
+		trace.HandshakeDone(state, err)
+	}
+	return client, err
+}
+
+// XDial connects to an RPC server at rpcAddr, which is a bare "host:port",
+// "protocol@addr" such as "tcp@host:port" and "unix@/path/to.sock",
+// or "unix:///path/to.sock". Labels used by discovery, such as the
+// "?zone=us-east-1a" of "tcp@host:port?zone=us-east-1a", are ignored.
+func XDial(rpcAddr string, opts ...ClientOption) (*Client, error) {
+	return XDialContext(context.Background(), rpcAddr, opts...)
+}
+
+// XDialContext is like XDial, connecting within ctx as DialContext does.
+func XDialContext(ctx context.Context, rpcAddr string, opts ...ClientOption) (*Client, error) {
+	if i := strings.IndexByte(rpcAddr, '?'); i >= 0 {
+		rpcAddr = rpcAddr[:i]
+	}
+	network, address := parseAddr(rpcAddr)
+	return DialContext(ctx, network, address, opts...)
 }
@@ -0,0 +1,85 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClient_CallContext(t *testing.T) {
+	server := NewServer()
+	var slow Slow
+	_ = server.Register(&slow)
+	lis := startServer(t, server)
+	client, err := Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var reply int
+	err = client.CallContext(ctx, "Slow.Sleep", 300, &reply)
+	_assert(errors.Is(err, context.DeadlineExceeded), "expect a deadline error, but got %v", err)
+	client.mu.Lock()
+	pending := len(client.pending)
+	client.mu.Unlock()
+	_assert(pending == 0, "expect the cancelled call to leave pending, but %d remain", pending)
+
+	// the late response is discarded and the stream stays usable
+	time.Sleep(300 * time.Millisecond)
+	err = client.CallContext(context.Background(), "Slow.Sleep", 1, &reply)
+	_assert(err == nil && reply == 1, "expect the next call to work, but got %d, %v", reply, err)
+}
+
+// Waiter blocks until its context is cancelled and reports when.
+type Waiter struct{ done chan time.Time }
+
+func (w *Waiter) Wait(ctx context.Context, ms int, reply *int) error {
+	select {
+	case <-ctx.Done():
+		w.done <- time.Now()
+		return ctx.Err()
+	case <-time.After(time.Duration(ms) * time.Millisecond):
+		*reply = ms
+		return nil
+	}
+}
+
+func TestClient_CallContextCancelsServer(t *testing.T) {
+	server := NewServer()
+	w := &Waiter{done: make(chan time.Time, 1)}
+	_ = server.Register(w)
+	lis := startServer(t, server)
+	client, err := Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var reply int
+	start := time.Now()
+	err = client.CallContext(ctx, "Waiter.Wait", 5000, &reply)
+	_assert(errors.Is(err, context.DeadlineExceeded), "expect a deadline error, but got %v", err)
+	select {
+	case at := <-w.done:
+		_assert(at.Sub(start) < 300*time.Millisecond, "expect the handler to stop soon, but it took %v", at.Sub(start))
+	case <-time.After(time.Second):
+		t.Fatal("expect the handler context to be cancelled")
+	}
+
+	err = client.CallContext(context.Background(), "Waiter.Wait", 1, &reply)
+	_assert(err == nil && reply == 1, "expect the next call to work, but got %d, %v", reply, err)
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for _, sc := range server.conns {
+		sc.mu.Lock()
+		tracked := len(sc.requests)
+		sc.mu.Unlock()
+		_assert(tracked == 0, "expect no tracked requests, but got %d", tracked)
+	}
+}
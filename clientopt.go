@@ -0,0 +1,218 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"tinyrpc/codec"
+	"tinyrpc/rpclog"
+)
+
+// A ClientOption configures a client when it is dialed, see Dial. An
+// *Option is one: it carries the options sent to the server and is the
+// base the other ClientOptions override, whatever their order.
+type ClientOption interface {
+	applyClient(c *clientConfig) error
+}
+
+// clientConfig is the configuration of a client, set by its ClientOptions.
+type clientConfig struct {
+	opt          Option
+	logger       rpclog.Logger // nil for the default logger
+	interceptors []Interceptor
+	retry        *RetryPolicy
+	signer       *requestSigner // nil unless WithRequestSigning
+	trace        *ClientTrace   // of the context of DialContext
+	schemaCheck  SchemaCheck
+	// maxOutstanding caps the calls waiting for their response
+	maxOutstanding int
+	decodeWorkers  int         // goroutines decoding the replies framed on their own
+	atMostOnce     *atMostOnce // nil unless WithAtMostOnce
+}
+
+func (o *Option) applyClient(c *clientConfig) error {
+	if o != nil {
+		c.opt = *o
+	}
+	return nil
+}
+
+type clientOptionFunc func(c *clientConfig)
+
+func (f clientOptionFunc) applyClient(c *clientConfig) error {
+	f(c)
+	return nil
+}
+
+// applyClient makes WithLogger and WithInterceptors client options too.
+// The other ServerOptions are not.
+func (o ServerOption) applyClient(c *clientConfig) error {
+	sc := ServerConfig{Logger: c.logger, Interceptors: c.interceptors}
+	if err := o(&sc); err != nil {
+		return err
+	}
+	if sc.HandleTimeout != 0 || sc.MaxConnections != 0 || sc.IdleTimeout != 0 ||
+		sc.MaxBodySize != 0 || sc.MaxInFlight != 0 || sc.WarnOnly != (WarnOnly{}) || sc.Authenticate != nil || sc.EncryptionKeys != nil || sc.DebugAuth != nil ||
+		sc.WriteCoalescing != (WriteCoalescing{}) || sc.Trace != nil || sc.Workers != 0 || sc.PriorityAging != 0 || sc.MaxQueued != 0 ||
+		sc.DrainGrace != 0 || sc.Timings != nil || sc.StrictProtocol || sc.AtMostOnce || sc.FrameHistory {
+		return errors.New("rpc client: server option passed to a client")
+	}
+	c.logger, c.interceptors = sc.Logger, sc.Interceptors
+	return nil
+}
+
+// WithConnectTimeout sets Option.ConnectTimeout.
+func WithConnectTimeout(d time.Duration) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) { c.opt.ConnectTimeout = d })
+}
+
+// WithWriteTimeout sets Option.WriteTimeout.
+func WithWriteTimeout(d time.Duration) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) { c.opt.WriteTimeout = d })
+}
+
+// WithCodec sets Option.CodecType.
+func WithCodec(t codec.Type) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) { c.opt.CodecType = t })
+}
+
+// WithHeartbeat sets Option.HeartbeatIdle.
+func WithHeartbeat(idle time.Duration) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) { c.opt.HeartbeatIdle = idle })
+}
+
+// WithDialer sets Option.Dialer.
+func WithDialer(d Dialer) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) { c.opt.Dialer = d })
+}
+
+// RetryPolicy retries the calls of a client, made with Call or
+// CallContext, that fail with a retryable error, on the same connection.
+// Only retry methods that are safe to call twice.
+type RetryPolicy struct {
+	// MaxAttempts of a call, the first one included. No retry if below 2.
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled before each
+	// next one, timed by Option.Clock. A retry waits at least the
+	// RetryAfter of the error, if longer.
+	Backoff time.Duration
+	// Retryable reports whether a call failing with err is retried. If
+	// nil, the calls the server gave up with ErrHandleTimeout are, and
+	// those it shed with a RetryAfter.
+	Retryable func(err error) bool
+}
+
+// WithRetryPolicy makes the client retry its calls according to p.
+func WithRetryPolicy(p RetryPolicy) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) { c.retry = &p })
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return errors.Is(err, ErrHandleTimeout) || RetryAfter(err) > 0
+}
+
+// parseClientOptions returns the configuration set by opts, on the base
+// of their *Option or DefaultOption.
+func parseClientOptions(opts ...ClientOption) (*clientConfig, error) {
+	c := &clientConfig{opt: *DefaultOption}
+	bases := 0
+	for _, o := range opts {
+		if base, ok := o.(*Option); ok && base != nil {
+			if bases++; bases > 1 {
+				return nil, errors.New("number of options is more than 1")
+			}
+			_ = base.applyClient(c)
+		}
+	}
+	for _, o := range opts {
+		if _, ok := o.(*Option); ok || o == nil {
+			continue
+		}
+		if err := o.applyClient(c); err != nil {
+			return nil, err
+		}
+	}
+	c.opt.MagicNumber = DefaultOption.MagicNumber
+	if c.opt.CodecType == "" {
+		c.opt.CodecType = DefaultOption.CodecType
+	}
+	return c, nil
+}
+
+// MergeOptions returns the Option that Dial uses with opts: the *Option
+// among them, or DefaultOption, overridden by the other ClientOptions.
+func MergeOptions(opts ...ClientOption) (*Option, error) {
+	c, err := parseClientOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &c.opt, nil
+}
+
+// OptionsKey returns a key of the configuration opts make: clients
+// dialed to the same address with options of the same key are
+// interchangeable, e.g. for sharing one. The settings that are funcs,
+// pointers or interfaces, such as interceptors or a TLS config, match
+// only if they are the same ones.
+func OptionsKey(opts ...ClientOption) (string, error) {
+	c, err := parseClientOptions(opts...)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%#v", *c), nil
+}
+
+// log logs msg with the logger of the client.
+func (c *clientConfig) log(level rpclog.Level, msg string, kv ...interface{}) {
+	l := c.logger
+	if l == nil {
+		l = rpclog.Default()
+	}
+	l.Log(level, "client", msg, kv...)
+}
+
+// wrapsCalls reports whether Call must go through CallContext, to apply
+// the interceptors and the retry policy.
+func (c *clientConfig) wrapsCalls() bool {
+	return len(c.interceptors) > 0 || (c.retry != nil && c.retry.MaxAttempts > 1)
+}
+
+// wrapCall calls call through the interceptors and the retry policy.
+func (client *Client) wrapCall(ctx context.Context, serviceMethod string, args interface{}, call func(ctx context.Context) error) error {
+	c := client.config
+	if c.retry != nil && c.retry.MaxAttempts > 1 {
+		once := call
+		call = func(ctx context.Context) error { return client.withRetries(ctx, once) }
+	}
+	return intercept(c.interceptors, ctx, serviceMethod, args, call)
+}
+
+// withRetries calls call until it succeeds, fails with an error the
+// retry policy doesn't retry, or runs out of attempts.
+func (client *Client) withRetries(ctx context.Context, call func(ctx context.Context) error) error {
+	p := client.config.retry
+	err := call(ctx)
+	backoff := p.Backoff
+	for attempt := 1; attempt < p.MaxAttempts && err != nil && p.retryable(err); attempt++ {
+		wait := backoff
+		if d := RetryAfter(err); d > wait {
+			wait = d
+		}
+		if wait > 0 {
+			t := client.clock.NewTimer(wait)
+			select {
+			case <-t.C():
+			case <-ctx.Done():
+				t.Stop()
+				return err
+			}
+		}
+		backoff *= 2
+		err = call(ctx)
+	}
+	return err
+}
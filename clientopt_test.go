@@ -0,0 +1,86 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"tinyrpc/codec"
+)
+
+func TestParseClientOptions_Precedence(t *testing.T) {
+	base := &Option{CodecType: codec.GobType, ConnectTimeout: time.Second, HeartbeatIdle: time.Minute}
+	opt, err := MergeOptions(WithCodec(testCodecType), base, WithConnectTimeout(2*time.Second))
+	_assert(err == nil, "merge error: %v", err)
+	_assert(opt.CodecType == testCodecType && opt.ConnectTimeout == 2*time.Second,
+		"expect the new options to override the Option, but got %+v", opt)
+	_assert(opt.HeartbeatIdle == time.Minute && opt.MagicNumber == MagicNumber, "expect the rest of the Option kept, but got %+v", opt)
+	_assert(base.CodecType == codec.GobType && base.ConnectTimeout == time.Second, "expect the Option left unchanged, but got %+v", base)
+
+	opt, err = MergeOptions(WithHeartbeat(time.Second), WithHeartbeat(2*time.Second))
+	_assert(err == nil && opt.HeartbeatIdle == 2*time.Second, "expect the last option to win, but got %+v, %v", opt, err)
+	_assert(opt.CodecType == DefaultOption.CodecType, "expect the DefaultOption as base, but got %+v", opt)
+
+	_, err = MergeOptions(base, &Option{})
+	_assert(err != nil, "expect two Options to fail")
+	_, err = MergeOptions(WithMaxConnections(1))
+	_assert(err != nil, "expect a server option to fail")
+	_, err = MergeOptions(WithEncryptionKeys(map[string][]byte{"k": make([]byte, 16)}))
+	_assert(err != nil, "expect the server keys to fail on a client")
+	_, err = MergeOptions(nil, (*Option)(nil))
+	_assert(err == nil, "expect nil options to be ignored, but got %v", err)
+}
+
+func TestDial_ClientOptions(t *testing.T) {
+	lis := startServer(t, NewServer())
+	logger := &recordingLogger{}
+	var methods []string
+	record := func(ctx context.Context, serviceMethod string, args interface{}, next func(context.Context) error) error {
+		methods = append(methods, serviceMethod)
+		return next(ctx)
+	}
+	client, err := Dial("tcp", lis.Addr().String(), &Option{ConnectTimeout: time.Second},
+		WithCodec(codec.GobType), WithLogger(logger), WithInterceptors(record))
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(client.opt.ConnectTimeout == time.Second && client.opt.CodecType == codec.GobType,
+		"expect the merged Option, but got %+v", client.opt)
+
+	var reply int
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect 3, but got %d, %v", reply, err)
+	_assert(len(methods) == 1 && methods[0] == "Foo.Sum", "expect the call intercepted, but got %v", methods)
+
+	client.config.log(0, "hello")
+	_assert(logger.has("client: hello"), "expect the client logger used")
+}
+
+func TestDial_RetryPolicy(t *testing.T) {
+	failures := 2
+	flaky := func(ctx context.Context, _ string, _ interface{}, next func(context.Context) error) error {
+		if failures > 0 {
+			failures--
+			return errors.New("unavailable")
+		}
+		return next(ctx)
+	}
+	lis := startServer(t, NewServer(WithInterceptors(flaky)))
+	attempts := 0
+	count := func(ctx context.Context, _ string, _ interface{}, next func(context.Context) error) error {
+		attempts++
+		return next(ctx)
+	}
+	retry := RetryPolicy{MaxAttempts: 3, Retryable: func(err error) bool { return err.Error() == "unavailable" }}
+	client, err := Dial("tcp", lis.Addr().String(), WithRetryPolicy(retry), WithInterceptors(count))
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect the third attempt to succeed, but got %d, %v", reply, err)
+	_assert(attempts == 1, "expect the interceptors to wrap the retries, but got %d calls", attempts)
+
+	failures = 3
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil && err.Error() == "unavailable", "expect the attempts to run out, but got %v", err)
+}
@@ -0,0 +1,8 @@
+package tinyrpc
+
+import "tinyrpc/internal/clock"
+
+// Clock is the source of time of the timeouts, heartbeats and expiries of
+// clients, servers, XClients and registries, the real clock by default.
+// Tests may set tinyrpctest.Clock to control it.
+type Clock = clock.Clock
@@ -0,0 +1,154 @@
+package tinyrpc
+
+import (
+	"io"
+	"sync"
+	"time"
+	"tinyrpc/internal/clock"
+)
+
+// DefaultCoalesceBytes is the WriteCoalescing.MaxBytes of a zero value.
+const DefaultCoalesceBytes = 32 << 10
+
+// WriteCoalescing trades latency for fewer writes to the connections:
+// the responses of a connection are buffered and written together, by a
+// goroutine of the connection, once Delay passed since the first of them
+// or MaxBytes are buffered. Off if Delay is 0.
+type WriteCoalescing struct {
+	Delay    time.Duration // e.g. 500µs
+	MaxBytes int           // DefaultCoalesceBytes if 0
+}
+
+// coalescingConn buffers the writes to its conn and writes them together,
+// in order, from its own goroutine. A failed write closes the conn and
+// fails the next writes, as a failed write of a codec does.
+type coalescingConn struct {
+	io.ReadWriteCloser
+	delay    time.Duration
+	maxBytes int
+	clock    clock.Clock
+	kick     chan struct{} // a write made the buffer not empty
+	full     chan struct{} // the buffer is to be written now
+	done     chan struct{} // closed by Close
+	once     sync.Once
+
+	mu      sync.Mutex // protect following
+	cond    *sync.Cond // signalled when the buffer was written
+	pending []byte
+	spare   []byte // the buffer written last, reused
+	writing bool
+	err     error // of the write that failed
+	closed  bool
+}
+
+func newCoalescingConn(conn io.ReadWriteCloser, wc WriteCoalescing, c clock.Clock) *coalescingConn {
+	if wc.MaxBytes <= 0 {
+		wc.MaxBytes = DefaultCoalesceBytes
+	}
+	cc := &coalescingConn{
+		ReadWriteCloser: conn,
+		delay:           wc.Delay,
+		maxBytes:        wc.MaxBytes,
+		clock:           clock.Or(c),
+		kick:            make(chan struct{}, 1),
+		full:            make(chan struct{}, 1),
+		done:            make(chan struct{}),
+	}
+	cc.cond = sync.NewCond(&cc.mu)
+	go cc.run()
+	return cc
+}
+
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Write buffers p. It blocks while twice MaxBytes are buffered, so that a
+// client reading slowly holds back its responses, see ResponseLimits.
+func (c *coalescingConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.pending) >= 2*c.maxBytes && c.err == nil && !c.closed {
+		c.cond.Wait()
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if len(c.pending) == 0 {
+		wake(c.kick)
+	}
+	c.pending = append(c.pending, p...)
+	if len(c.pending) >= c.maxBytes {
+		wake(c.full)
+	}
+	return len(p), nil
+}
+
+func (c *coalescingConn) run() {
+	for {
+		select {
+		case <-c.kick:
+		case <-c.done:
+			return
+		}
+		t := c.clock.NewTimer(c.delay)
+		select {
+		case <-t.C():
+		case <-c.full:
+			t.Stop()
+		case <-c.done:
+			t.Stop()
+			return
+		}
+		c.mu.Lock()
+		buf := c.pending
+		c.pending, c.writing = c.spare[:0], true
+		select {
+		case <-c.full: // written now
+		default:
+		}
+		c.mu.Unlock()
+
+		_, err := c.ReadWriteCloser.Write(buf)
+		c.mu.Lock()
+		c.spare, c.writing = buf, false
+		if err != nil {
+			c.err = err
+		}
+		c.cond.Broadcast()
+		c.mu.Unlock()
+		if err != nil {
+			_ = c.ReadWriteCloser.Close()
+			return
+		}
+	}
+}
+
+// flush writes the buffer now and waits until it is written, or fails.
+func (c *coalescingConn) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) > 0 {
+		wake(c.full)
+	}
+	for (len(c.pending) > 0 || c.writing) && c.err == nil && !c.closed {
+		c.cond.Wait()
+	}
+}
+
+// Close closes the conn at once, dropping the responses not written yet;
+// flush first to write them.
+func (c *coalescingConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	c.once.Do(func() { close(c.done) })
+	return c.ReadWriteCloser.Close()
+}
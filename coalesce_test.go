@@ -0,0 +1,176 @@
+package tinyrpc
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tinyrpc/tinyrpctest"
+)
+
+// writeLog records what is written to it, and fails the writes once
+// fail is set.
+type writeLog struct {
+	mu     sync.Mutex
+	writes [][]byte
+	fail   bool
+	closed bool
+}
+
+func (w *writeLog) Read([]byte) (int, error) { select {} }
+
+func (w *writeLog) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.fail {
+		return 0, errors.New("broken pipe")
+	}
+	w.writes = append(w.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (w *writeLog) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return nil
+}
+
+func (w *writeLog) written() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var s []string
+	for _, p := range w.writes {
+		s = append(s, string(p))
+	}
+	return s
+}
+
+func TestCoalescingConn(t *testing.T) {
+	clock := tinyrpctest.NewClock()
+	w := &writeLog{}
+	c := newCoalescingConn(w, WriteCoalescing{Delay: time.Millisecond, MaxBytes: 8}, clock)
+	defer func() { _ = c.Close() }()
+	eventually := func(want ...string) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if len(w.written()) == len(want) {
+				break
+			}
+		}
+		got := w.written()
+		_assert(len(got) == len(want), "expect writes %q, but got %q", want, got)
+		for i := range want {
+			_assert(got[i] == want[i], "expect writes %q, but got %q", want, got)
+		}
+	}
+
+	_, _ = c.Write([]byte("ab"))
+	_, _ = c.Write([]byte("cd"))
+	clock.BlockUntil(1)
+	eventually() // held until the delay passes
+	clock.Advance(time.Millisecond)
+	eventually("abcd")
+
+	_, _ = c.Write([]byte("efgh"))
+	_, _ = c.Write([]byte("ijkl")) // MaxBytes buffered
+	eventually("abcd", "efghijkl")
+
+	_, _ = c.Write([]byte("mn"))
+	c.flush()
+	eventually("abcd", "efghijkl", "mn")
+
+	w.mu.Lock()
+	w.fail = true
+	w.mu.Unlock()
+	_, _ = c.Write([]byte("op"))
+	c.flush()
+	_, err := c.Write([]byte("qr"))
+	_assert(err != nil, "expect the writes to fail after a failed write")
+	w.mu.Lock()
+	_assert(w.closed, "expect a failed write to close the conn")
+	w.mu.Unlock()
+}
+
+// writeCountingConn counts the writes to its conn, the syscalls of a TCP conn.
+type writeCountingConn struct {
+	net.Conn
+	writes *int64
+}
+
+func (c writeCountingConn) Write(p []byte) (int, error) {
+	atomic.AddInt64(c.writes, 1)
+	return c.Conn.Write(p)
+}
+
+func TestServer_WriteCoalescing(t *testing.T) {
+	server := NewServer(WithWriteCoalescing(WriteCoalescing{Delay: 20 * time.Millisecond}))
+	var writes int64
+	server.WrapConn(func(conn net.Conn) net.Conn { return writeCountingConn{conn, &writes} })
+	addr := startServer(t, server).Addr().String()
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	handshake := atomic.LoadInt64(&writes)
+
+	const n = 50
+	replies := make([]int, n)
+	calls := make([]*Call, n)
+	done := make(chan *Call, n)
+	for i := range calls {
+		calls[i] = client.Go("Foo.Sum", Args{Num1: i, Num2: 1}, &replies[i], done)
+	}
+	for range calls {
+		<-done
+	}
+	for i, call := range calls {
+		_assert(call.Error == nil && replies[i] == i+1, "expect %d, but got %d, %v", i+1, replies[i], call.Error)
+	}
+	got := atomic.LoadInt64(&writes) - handshake
+	_assert(got < n/5, "expect the %d responses in a few writes, but got %d", n, got)
+
+	_, err = MergeOptions(WithWriteCoalescing(WriteCoalescing{Delay: time.Millisecond}))
+	_assert(err != nil, "expect WithWriteCoalescing refused by a client")
+	_assert(NewServer().Configure(WithWriteCoalescing(WriteCoalescing{Delay: -1})) != nil, "expect a negative delay refused")
+}
+
+func BenchmarkWriteCoalescing(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		wc   WriteCoalescing
+	}{
+		{"off", WriteCoalescing{}},
+		{"500us", WriteCoalescing{Delay: 500 * time.Microsecond}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			server := NewServer(WithWriteCoalescing(bc.wc))
+			var writes int64
+			server.WrapConn(func(conn net.Conn) net.Conn { return writeCountingConn{conn, &writes} })
+			var foo Foo
+			_ = server.Register(&foo)
+			lis, _ := server.Listen("tcp", "127.0.0.1:0")
+			go server.Accept(lis)
+			defer func() { _ = lis.Close() }()
+			client, err := Dial("tcp", lis.Addr().String(), &Option{HeartbeatIdle: -1})
+			if err != nil {
+				b.Fatal("dial error:", err)
+			}
+			defer func() { _ = client.Close() }()
+			start := atomic.LoadInt64(&writes)
+			b.SetParallelism(128)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				var reply int
+				for pb.Next() {
+					if err := client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(atomic.LoadInt64(&writes)-start)/float64(b.N), "writes/op")
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+)
+
+// BodyCodec encodes the body of a frame on its own, for frames whose
+// Header.BodyCodec is not the codec of the connection.
+type BodyCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// BodyCodecs are the body codecs by type. Servers advertise them in the
+// handshake, see Option.AllowBodyCodecs.
+var BodyCodecs = map[Type]BodyCodec{
+	GobType:  gobBody{},
+	JsonType: jsonBody{},
+}
+
+// ErrUnknownBodyCodec is returned when a body is read or written with a
+// BodyCodec not in BodyCodecs. A body read is skipped, so the next frame
+// is still read from the right place.
+var ErrUnknownBodyCodec = errors.New("codec: unknown body codec")
+
+// ErrNotFramed is returned by RawReader.ReadRawBody for a body that can't
+// be read without decoding it, such as a body in gob on a gob connection.
+var ErrNotFramed = errors.New("codec: body not framed")
+
+// Encoded is a body already encoded in the body codec of its frame, as
+// read by a proxy: it is written as is, and a body read into an *Encoded
+// is kept encoded.
+type Encoded []byte
+
+// unmarshal decodes data with bc into v, or keeps data as is if v is an
+// *Encoded.
+func unmarshal(bc BodyCodec, data []byte, v interface{}) error {
+	if e, ok := v.(*Encoded); ok {
+		*e = data
+		return nil
+	}
+	return bc.Unmarshal(data, v)
+}
+
+// marshal encodes v with bc, unless it is already Encoded.
+func marshal(bc BodyCodec, v interface{}) ([]byte, error) {
+	if e, ok := v.(Encoded); ok {
+		return e, nil
+	}
+	return bc.Marshal(v)
+}
+
+type gobBody struct{}
+
+func (gobBody) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobBody) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type jsonBody struct{}
+
+func (jsonBody) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonBody) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
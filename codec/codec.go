@@ -1,6 +1,7 @@
 package codec
 
 import (
+	"errors"
 	"io"
 )
 
@@ -8,8 +9,32 @@ type Header struct {
 	ServiceMethod string // format "Service.Method"
 	Seq           uint64 // sequence number chosen by client
 	Error         string
+	// Code classifies Error, e.g. "deadline_exceeded". Peers without the
+	// field ignore it.
+	Code string
+	// Metadata of the request, or trailer of the response.
+	// Peers without the field ignore it.
+	Metadata map[string]string
+	// BodyCodec is the codec of the body, if not the codec of the
+	// connection. Such a body is framed as a byte slice holding its
+	// encoding, so that it can be skipped when its codec is unknown.
+	// Send it only to peers advertising the codec in the handshake.
+	BodyCodec Type
+	// Compression is the compression of the body, one of Compressors,
+	// and Checksum appends the CRC32C of the body to it, for this frame
+	// only. Such a body is framed like one in a BodyCodec, the codec of
+	// the connection if none. Send them only to peers advertising them in
+	// the handshake.
+	Compression string
+	Checksum    bool
 }
 
+// Codec reads and writes the frames of a connection. ReadHeader and
+// ReadBody are called by one goroutine at a time, Write is safe for
+// concurrent use: each frame is written whole. ReadBody(nil) consumes
+// the next body and discards it, whatever its type, leaving the stream
+// at the next header; codecs that can't do it cheaply also implement
+// BodyDiscarder.
 type Codec interface {
 	io.Closer
 	ReadHeader(*Header) error
@@ -17,13 +42,66 @@ type Codec interface {
 	Write(*Header, interface{}) error
 }
 
+// RawReader is implemented by codecs whose bodies are framed on their
+// own, so that a body can be read without decoding it.
+type RawReader interface {
+	// ReadRawBody reads the next body as it is encoded, or returns
+	// ErrNotFramed without reading it if the body isn't framed.
+	ReadRawBody() ([]byte, error)
+}
+
+// DeferredReader is implemented by codecs that can read a body framed on
+// its own without decoding it, so that it is decoded later, on another
+// goroutine, while the next frames are read.
+type DeferredReader interface {
+	// ReadBodyDeferred reads the next body and returns the function
+	// decoding it, or nil without reading it if the body isn't framed:
+	// ReadBody must read it then.
+	ReadBodyDeferred() (decode func(v interface{}) error, err error)
+}
+
+// BodyDiscarder is implemented by codecs that can skip a body without
+// decoding it into a value, or whose ReadBody(nil) predates discarding.
+type BodyDiscarder interface {
+	DiscardBody() error
+}
+
+// SizeReporter is implemented by codecs that know the encoded size of
+// the bodies they read and write, for the size stats of the server.
+type SizeReporter interface {
+	// ReadBodySize returns the encoded size of the body read last, by
+	// ReadBody or DiscardBody.
+	ReadBodySize() int64
+	// WriteSized writes the frame like Write, and returns the encoded
+	// size of body.
+	WriteSized(h *Header, body interface{}) (int64, error)
+}
+
+// EncodedWriter is implemented by codecs that can write a body encoded
+// once for many frames, e.g. a message published to many connections.
+type EncodedWriter interface {
+	// WriteEncoded writes the frame like Write with raw as its body, a
+	// byte slice, or Encoded if h.BodyCodec is set, without
+	// encoding raw again for the frame.
+	WriteEncoded(h *Header, raw []byte) error
+}
+
+// DiscardBody skips the body of the header cc read last, with DiscardBody
+// if cc is a BodyDiscarder, else with ReadBody(nil).
+func DiscardBody(cc Codec) error {
+	if d, ok := cc.(BodyDiscarder); ok {
+		return d.DiscardBody()
+	}
+	return cc.ReadBody(nil)
+}
+
 type NewCodecFunc func(io.ReadWriteCloser) Codec
 
 type Type string
 
 const (
 	GobType  Type = "application/gob"
-	JsonType Type = "application/json" // not implemented
+	JsonType Type = "application/json" // a body codec only, see BodyCodecs
 )
 
 var NewCodecFuncMap map[Type]NewCodecFunc
@@ -32,3 +110,22 @@ func init() {
 	NewCodecFuncMap = make(map[Type]NewCodecFunc)
 	NewCodecFuncMap[GobType] = NewGobCodec
 }
+
+// ErrStreamCorrupt matches the fatal DecodeErrors: the stream is at an
+// unknown place, or its state, such as the types gob defined, is lost,
+// so that no frame can be read after it.
+var ErrStreamCorrupt = errors.New("codec: stream corrupt")
+
+// DecodeError is an error decoding a frame, as opposed to reading the
+// connection, whose errors codecs return as is. After a recoverable one,
+// such as a value that doesn't fit its type, the next frame can be read.
+type DecodeError struct {
+	Fatal bool // see ErrStreamCorrupt
+	Err   error
+}
+
+func (e *DecodeError) Error() string { return e.Err.Error() }
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// Is makes a fatal DecodeError match ErrStreamCorrupt.
+func (e *DecodeError) Is(target error) bool { return e.Fatal && target == ErrStreamCorrupt }
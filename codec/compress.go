@@ -0,0 +1,186 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Gzip is the name of the gzip compression, see Header.Compression.
+const Gzip = "gzip"
+
+// Compressor compresses the bodies of the frames whose
+// Header.Compression names it.
+type Compressor interface {
+	NewWriter(w io.Writer) io.WriteCloser
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// Compressors are the compressions by name. Servers advertise them in
+// the handshake, see Option.AllowBodyCodecs.
+var Compressors = map[string]Compressor{
+	Gzip: gzipCompressor{},
+}
+
+// MaxDecompressedSize bounds the size of a body once decompressed, so
+// that a small frame can't take the memory of the peer.
+var MaxDecompressedSize int64 = 256 << 20
+
+var (
+	// ErrUnknownCompression is returned when a body is read or written
+	// with a Header.Compression not in Compressors.
+	ErrUnknownCompression = errors.New("codec: unknown compression")
+	// ErrBodyChecksum is returned for a body whose checksum, see
+	// Header.Checksum, doesn't match. The next frame can still be read.
+	ErrBodyChecksum = errors.New("codec: body checksum mismatch")
+	// ErrDecompressedTooLarge is returned for a body larger than
+	// MaxDecompressedSize once decompressed.
+	ErrDecompressedTooLarge = errors.New("codec: decompressed body too large")
+)
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) NewWriter(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+
+func (gzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) }
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// bodyEncoding is how the body of a frame is encoded, from its header.
+type bodyEncoding struct {
+	codec       Type
+	compression string
+	checksum    bool
+}
+
+func encodingOf(h *Header) bodyEncoding {
+	return bodyEncoding{codec: h.BodyCodec, compression: h.Compression, checksum: h.Checksum}
+}
+
+// framed reports whether the body is framed as a byte slice on a
+// connection of the codec conn.
+func (e bodyEncoding) framed(conn Type) bool {
+	return (e.codec != "" && e.codec != conn) || e.compression != "" || e.checksum
+}
+
+// inConn reports whether the body is in the codec of the connection,
+// framed or not.
+func (e bodyEncoding) inConn(conn Type) bool {
+	return e.codec == "" || e.codec == conn
+}
+
+// bodyCodec returns the body codec of a framed body, that of conn if it
+// has none.
+func (e bodyEncoding) bodyCodec(conn Type) (BodyCodec, error) {
+	t := e.codec
+	if t == "" {
+		t = conn
+	}
+	bc := BodyCodecs[t]
+	if bc == nil {
+		return nil, fmt.Errorf("%w %s", ErrUnknownBodyCodec, t)
+	}
+	return bc, nil
+}
+
+// compressor returns the Compressor of the body, nil if not compressed.
+func (e bodyEncoding) compressor() (Compressor, error) {
+	if e.compression == "" {
+		return nil, nil
+	}
+	c := Compressors[e.compression]
+	if c == nil {
+		return nil, fmt.Errorf("%w %s", ErrUnknownCompression, e.compression)
+	}
+	return c, nil
+}
+
+// check fails if the body can't be encoded, before anything is written.
+func (e bodyEncoding) check(conn Type) error {
+	if _, err := e.bodyCodec(conn); err != nil {
+		return err
+	}
+	_, err := e.compressor()
+	return err
+}
+
+// encode returns the frame of v, a framed body: encoded with its body
+// codec, unless it is Encoded already, then compressed and checksummed.
+func (e bodyEncoding) encode(conn Type, v interface{}) ([]byte, error) {
+	bc, err := e.bodyCodec(conn)
+	if err != nil {
+		return nil, err
+	}
+	data, err := marshal(bc, v)
+	if err != nil {
+		return nil, err
+	}
+	c, err := e.compressor()
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		var buf bytes.Buffer
+		w := c.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		data = buf.Bytes()
+	}
+	if e.checksum {
+		data = binary.BigEndian.AppendUint32(data, crc32.Checksum(data, castagnoli))
+	}
+	return data, nil
+}
+
+// unframe returns the encoding of a framed body in its body codec,
+// checked and decompressed.
+func (e bodyEncoding) unframe(data []byte) ([]byte, error) {
+	if e.checksum {
+		if len(data) < 4 {
+			return nil, ErrBodyChecksum
+		}
+		n := len(data) - 4
+		if crc32.Checksum(data[:n], castagnoli) != binary.BigEndian.Uint32(data[n:]) {
+			return nil, ErrBodyChecksum
+		}
+		data = data[:n]
+	}
+	c, err := e.compressor()
+	if err != nil || c == nil {
+		return data, err
+	}
+	r, err := c.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	out, err := io.ReadAll(io.LimitReader(r, MaxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > MaxDecompressedSize {
+		return nil, ErrDecompressedTooLarge
+	}
+	return out, nil
+}
+
+// decode decodes a framed body into v, or keeps it in its body codec if
+// v is an *Encoded.
+func (e bodyEncoding) decode(conn Type, data []byte, v interface{}) error {
+	bc, err := e.bodyCodec(conn)
+	if err != nil {
+		return err
+	}
+	if data, err = e.unframe(data); err != nil {
+		return err
+	}
+	return unmarshal(bc, data, v)
+}
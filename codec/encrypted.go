@@ -0,0 +1,193 @@
+package codec
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrFrameAuth is returned when a frame of an encrypted stream fails
+// its authentication, see NewEncryptedConn. The connection is closed.
+var ErrFrameAuth = errors.New("codec: frame authentication failed")
+
+// maxEncryptedFrame is the largest plaintext of a frame; larger writes
+// are split, and a larger length read means a tampered stream.
+const maxEncryptedFrame = 1 << 24
+
+// NewEncryptedConn returns conn with every write sealed with AES-GCM
+// under key, of 16, 24 or 32 bytes, for links that can't carry TLS.
+// client tells the two ends apart, so that one side's frames can't be
+// replayed to it. Each side starts its stream with a random nonce
+// prefix, then sends every write as its length, 4 bytes, and its
+// ciphertext; the nonces count the frames, so that frames can't be
+// dropped, reordered or replayed either. A frame failing authentication
+// closes conn, and the reads fail with ErrFrameAuth.
+func NewEncryptedConn(conn io.ReadWriteCloser, key []byte, client bool) (io.ReadWriteCloser, error) {
+	return newEncryptedConn(conn, key, client, rand.Reader)
+}
+
+// NewEncryptedCodec returns the codec newCodec makes on
+// NewEncryptedConn(conn, key, client), e.g. for Server.ServeCodec and
+// NewClientWithCodec over a link set up by other means.
+func NewEncryptedCodec(conn io.ReadWriteCloser, key []byte, client bool, newCodec NewCodecFunc) (Codec, error) {
+	ec, err := NewEncryptedConn(conn, key, client)
+	if err != nil {
+		return nil, err
+	}
+	return newCodec(ec), nil
+}
+
+// encryptedConn is the stream of NewEncryptedConn. The nonces are the
+// direction, 0 from the client, the 3 random bytes of the prefix its
+// writer sent, and the big-endian count of its frames.
+type encryptedConn struct {
+	conn io.ReadWriteCloser
+	aead cipher.AEAD
+	rand io.Reader
+
+	wmu    sync.Mutex // protect following
+	wnonce [12]byte
+	wsent  bool // the prefix
+	wcount uint64
+
+	r      byteReader
+	rnonce [12]byte
+	rgot   bool // the prefix
+	rcount uint64
+	frame  []byte // decrypted, not read yet
+	buf    []byte
+	err    error // sticky read error
+}
+
+func newEncryptedConn(conn io.ReadWriteCloser, key []byte, client bool, random io.Reader) (*encryptedConn, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := conn.(byteReader)
+	if !ok {
+		r = bufio.NewReader(conn)
+	}
+	c := &encryptedConn{conn: conn, aead: aead, rand: random, r: r}
+	if !client {
+		c.wnonce[0] = 1
+	} else {
+		c.rnonce[0] = 1
+	}
+	return c, nil
+}
+
+func (c *encryptedConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	var out []byte
+	if !c.wsent {
+		if _, err := io.ReadFull(c.rand, c.wnonce[1:4]); err != nil {
+			return 0, err
+		}
+		out = append(out, c.wnonce[1:4]...)
+		c.wsent = true
+	}
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > maxEncryptedFrame {
+			n = maxEncryptedFrame
+		}
+		binary.BigEndian.PutUint64(c.wnonce[4:], c.wcount)
+		c.wcount++
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(n+c.aead.Overhead()))
+		out = c.aead.Seal(append(out, size[:]...), c.wnonce[:], p[:n], nil)
+		if _, err := c.conn.Write(out); err != nil {
+			return written, err
+		}
+		out, written, p = out[:0], written+n, p[n:]
+	}
+	return written, nil
+}
+
+// next reads and decrypts the next frame, closing the connection if it
+// fails authentication.
+func (c *encryptedConn) next() error {
+	if c.err != nil {
+		return c.err
+	}
+	if !c.rgot {
+		if _, err := io.ReadFull(c.r, c.rnonce[1:4]); err != nil {
+			c.err = err
+			return err
+		}
+		c.rgot = true
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		c.err = err
+		return err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxEncryptedFrame+uint32(c.aead.Overhead()) {
+		return c.fail(fmt.Errorf("%w: frame of %d bytes", ErrFrameAuth, n))
+	}
+	if cap(c.buf) < int(n) {
+		c.buf = make([]byte, n)
+	}
+	sealed := c.buf[:n]
+	if _, err := io.ReadFull(c.r, sealed); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		c.err = err
+		return err
+	}
+	binary.BigEndian.PutUint64(c.rnonce[4:], c.rcount)
+	c.rcount++
+	frame, err := c.aead.Open(sealed[:0], c.rnonce[:], sealed, nil)
+	if err != nil {
+		return c.fail(ErrFrameAuth)
+	}
+	c.frame = frame
+	return nil
+}
+
+func (c *encryptedConn) fail(err error) error {
+	c.err = err
+	_ = c.conn.Close() // the stream can't be trusted
+	return err
+}
+
+func (c *encryptedConn) Read(p []byte) (int, error) {
+	for len(c.frame) == 0 {
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.frame)
+	c.frame = c.frame[n:]
+	return n, nil
+}
+
+func (c *encryptedConn) ReadByte() (byte, error) {
+	for len(c.frame) == 0 {
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+	b := c.frame[0]
+	c.frame = c.frame[1:]
+	return b, nil
+}
+
+func (c *encryptedConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,113 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// nopConn is a connection writing to its buffer.
+type nopConn struct{ bytes.Buffer }
+
+func (*nopConn) Close() error { return nil }
+
+// wireOf returns a connection reading stream.
+func wireOf(stream []byte) *nopConn {
+	c := &nopConn{}
+	_, _ = c.Write(stream)
+	return c
+}
+
+func TestEncryptedConn_KnownAnswer(t *testing.T) {
+	// test case 2 of the GCM specification: the first frame of a client
+	// with a zero prefix has the zero nonce
+	var conn nopConn
+	ec, err := newEncryptedConn(&conn, make([]byte, 16), true, bytes.NewReader(make([]byte, 3)))
+	if err != nil {
+		t.Fatal("cipher error:", err)
+	}
+	if _, err := ec.Write(make([]byte, 16)); err != nil {
+		t.Fatal("write error:", err)
+	}
+	want := "000000" + "00000020" + "0388dace60b6a392f328c2b971b2fe78" + "ab6e47d42cec13bdf53a67b21257bddf"
+	if got := hex.EncodeToString(conn.Bytes()); got != want {
+		t.Fatalf("expect %s, but got %s", want, got)
+	}
+}
+
+func TestEncryptedCodec_Interop(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	c, s := net.Pipe()
+	client, err := NewEncryptedCodec(c, key, true, NewGobCodec)
+	if err != nil {
+		t.Fatal("client codec error:", err)
+	}
+	server, _ := NewEncryptedCodec(s, key, false, NewGobCodec)
+	defer func() { _ = client.Close() }()
+	go func() {
+		_ = client.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, "request")
+	}()
+	var h Header
+	var body string
+	if err := server.ReadHeader(&h); err != nil || server.ReadBody(&body) != nil || body != "request" {
+		t.Fatalf("expect the request, but got %+v %q, %v", h, body, err)
+	}
+	go func() {
+		_ = server.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, "response")
+	}()
+	if err := client.ReadHeader(&h); err != nil || client.ReadBody(&body) != nil || body != "response" {
+		t.Fatalf("expect the response, but got %+v %q, %v", h, body, err)
+	}
+}
+
+// tamperConn flips a bit of the byte at offset of the stream it reads.
+type tamperConn struct {
+	io.ReadWriteCloser
+	offset, read int
+}
+
+func (c *tamperConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	if i := c.offset - c.read; i >= 0 && i < n {
+		p[i] ^= 1
+	}
+	c.read += n
+	return n, err
+}
+
+func TestEncryptedConn_Tampered(t *testing.T) {
+	key := make([]byte, 32)
+	var wire nopConn
+	w, _ := NewEncryptedConn(&wire, key, true)
+	_, _ = w.Write([]byte("first frame"))
+	_, _ = w.Write([]byte("second frame"))
+	stream := wire.Bytes()
+
+	for _, tt := range []struct {
+		name   string
+		offset int
+		client bool
+	}{
+		{"ciphertext", 3 + 4 + 2, false},
+		{"tag", 3 + 4 + 11 + 5, false},
+		{"nonce prefix", 1, false},
+		{"reflected", -1, true}, // a client reading its own frames
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &tamperConn{ReadWriteCloser: wireOf(stream), offset: tt.offset}
+			r, _ := NewEncryptedConn(conn, key, tt.client)
+			_, err := io.ReadAll(r)
+			if !errors.Is(err, ErrFrameAuth) {
+				t.Fatalf("expect ErrFrameAuth, but got %v", err)
+			}
+		})
+	}
+	r, _ := NewEncryptedConn(wireOf(stream), key, false)
+	data, err := io.ReadAll(r)
+	if err != nil || string(data) != "first framesecond frame" {
+		t.Fatalf("expect both frames, but got %q, %v", data, err)
+	}
+}
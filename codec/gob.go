@@ -4,14 +4,24 @@ import (
 	"bufio"
 	"encoding/gob"
 	"io"
-	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"tinyrpc/rpclog"
 )
 
 type GobCodec struct {
-	conn io.ReadWriteCloser
-	buf  *bufio.Writer
-	dec  *gob.Decoder
-	enc  *gob.Encoder
+	conn     io.ReadWriteCloser
+	in       countingReader
+	dec      *gob.Decoder
+	body     bodyEncoding // of the header read last
+	bodySize int64        // of the body read last
+	writers  int32        // waiting for mu, see WriteSized
+	mu       sync.Mutex   // protect following
+	buf      *bufio.Writer
+	out      countingWriter
+	enc      *gob.Encoder
 }
 
 /*
@@ -32,41 +42,270 @@ Go 语言中，变量赋值表达式左侧如果有一个下划线（_）标识
 var _ Codec = (*GobCodec)(nil)
 
 func NewGobCodec(conn io.ReadWriteCloser) Codec {
-	buf := bufio.NewWriter(conn)
-	return &GobCodec{
-		conn: conn,
-		buf:  buf,
-		dec:  gob.NewDecoder(conn),
-		enc:  gob.NewEncoder(buf),
+	c := &GobCodec{conn: conn, buf: bufio.NewWriter(conn)}
+	// buffered here rather than by gob, so that the bytes it decodes are counted exactly
+	r, ok := conn.(byteReader)
+	if !ok {
+		r = bufio.NewReader(conn)
 	}
+	c.in.r = r
+	c.out.w = c.buf
+	c.dec = gob.NewDecoder(&c.in)
+	c.enc = gob.NewEncoder(&c.out)
+	return c
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// countingReader counts the bytes read from r, and keeps its error.
+type countingReader struct {
+	r   byteReader
+	n   int64
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	} else {
+		c.err = err
+	}
+	return b, err
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// corruptingErrors are the messages of the errors gob returns once the
+// stream is out of step: a bad message length, or a type definition that
+// doesn't fit those of the decoder. The message of any other error was
+// read whole, so the next one can be.
+var corruptingErrors = []string{
+	"invalid message length",
+	"duplicate type received",
+	"extra data in buffer",
+	"unknown type id",
+}
+
+// decodeError classifies err, of gob decoding a header if header, see
+// DecodeError. A header that fails leaves its body to be read, so it is
+// fatal. The errors of the conn are returned as is.
+func (c *GobCodec) decodeError(err error, header bool) error {
+	readErr := c.in.err
+	c.in.err = nil // of this decode only, e.g. a read deadline
+	if err == nil || readErr != nil {
+		return err
+	}
+	fatal := header
+	for _, msg := range corruptingErrors {
+		if strings.Contains(err.Error(), msg) {
+			fatal = true
+		}
+	}
+	return &DecodeError{Fatal: fatal, Err: err}
 }
 
 // --------------------------
 
 func (c *GobCodec) ReadHeader(h *Header) error {
-	return c.dec.Decode(h)
+	err := c.decodeError(c.dec.Decode(h), true)
+	c.body = encodingOf(h)
+	if err == nil && rpclog.Enabled(rpclog.LevelDebug, "codec") {
+		rpclog.Debug("codec", "read header", "method", h.ServiceMethod, "seq", h.Seq, "error", h.Error)
+	}
+	return err
 }
 
 func (c *GobCodec) ReadBody(body interface{}) error {
-	return c.dec.Decode(body)
+	if body == nil {
+		return c.DiscardBody()
+	}
+	defer c.countBody(c.in.n)
+	if !c.body.framed(GobType) {
+		return c.decodeError(c.dec.Decode(body), false)
+	}
+	var data []byte
+	if err := c.dec.Decode(&data); err != nil {
+		return c.decodeError(err, false)
+	}
+	return c.body.decode(GobType, data, body)
 }
 
-func (c *GobCodec) Write(h *Header, body interface{}) (err error) {
+// ReadBodyDeferred reads a body framed as a byte slice, in a body codec
+// (see Header.BodyCodec) or compressed or checksummed. The other bodies
+// in gob aren't framed.
+func (c *GobCodec) ReadBodyDeferred() (func(v interface{}) error, error) {
+	if !c.body.framed(GobType) {
+		return nil, nil
+	}
+	defer c.countBody(c.in.n)
+	var data []byte
+	if err := c.dec.Decode(&data); err != nil {
+		return nil, c.decodeError(err, false)
+	}
+	e := c.body
+	return func(v interface{}) error {
+		return e.decode(GobType, data, v)
+	}, nil
+}
+
+// ReadRawBody reads a body in a body codec, see Header.BodyCodec, as its
+// encoding in the body codec, checked and decompressed. The bodies in
+// gob aren't framed.
+func (c *GobCodec) ReadRawBody() ([]byte, error) {
+	if c.body.inConn(GobType) {
+		return nil, ErrNotFramed
+	}
+	defer c.countBody(c.in.n)
+	var data []byte
+	if err := c.dec.Decode(&data); err != nil {
+		return nil, c.decodeError(err, false)
+	}
+	return c.body.unframe(data)
+}
+
+// DiscardBody skips the next body: gob decodes it into nothing, and a
+// framed body is read as its byte slice only.
+func (c *GobCodec) DiscardBody() error {
+	defer c.countBody(c.in.n)
+	if !c.body.framed(GobType) {
+		return c.decodeError(c.dec.DecodeValue(reflect.Value{}), false)
+	}
+	var data []byte
+	return c.decodeError(c.dec.Decode(&data), false)
+}
+
+func (c *GobCodec) countBody(start int64) {
+	c.bodySize = c.in.n - start
+}
+
+// ReadBodySize returns the bytes taken by the body read last, including
+// the definitions of the types it sent first.
+func (c *GobCodec) ReadBodySize() int64 {
+	return c.bodySize
+}
+
+func (c *GobCodec) Write(h *Header, body interface{}) error {
+	_, err := c.WriteSized(h, body)
+	return err
+}
+
+// WriteSized writes the frame like Write, and returns the bytes taken by
+// body, including the definitions of the types it sends first.
+func (c *GobCodec) WriteSized(h *Header, body interface{}) (n int64, err error) {
+	// encoded before anything is written, so that an unknown body codec
+	// fails the frame and not the connection
+	if e := encodingOf(h); e.framed(GobType) {
+		if body, err = e.encode(GobType, body); err != nil {
+			return 0, err
+		}
+	}
+	err = c.writeFrame(h, func() error {
+		start := c.out.n
+		if err := c.enc.Encode(body); err != nil {
+			rpclog.Error("codec", "gob error encoding body", "err", err)
+			return err
+		}
+		n = c.out.n - start
+		return nil
+	})
+	return n, err
+}
+
+// WriteEncoded writes the frame like Write(h, raw), copying raw to the
+// connection as is rather than through the encoder. raw is compressed
+// and checksummed first if h says so.
+func (c *GobCodec) WriteEncoded(h *Header, raw []byte) error {
+	if h.Compression != "" || h.Checksum {
+		var err error
+		if raw, err = encodingOf(h).encode(GobType, Encoded(raw)); err != nil {
+			return err
+		}
+	}
+	return c.writeFrame(h, func() error {
+		if _, err := c.out.Write(gobBytesPrefix(len(raw))); err != nil {
+			return err
+		}
+		_, err := c.out.Write(raw)
+		return err
+	})
+}
+
+// writeFrame writes h, then the body with writeBody.
+func (c *GobCodec) writeFrame(h *Header, writeBody func() error) (err error) {
+	// a frame waiting for mu flushes this one along with it, so that
+	// frames written at once take one write to the conn
+	atomic.AddInt32(&c.writers, 1)
+	c.mu.Lock()
+	atomic.AddInt32(&c.writers, -1)
+	defer c.mu.Unlock()
 	defer func() {
-		_ = c.buf.Flush()
+		if err != nil || atomic.LoadInt32(&c.writers) == 0 {
+			if ferr := c.buf.Flush(); err == nil {
+				err = ferr // the frame may be half written
+			}
+		}
 		if err != nil {
 			_ = c.Close()
 		}
 	}()
+	if rpclog.Enabled(rpclog.LevelDebug, "codec") {
+		rpclog.Debug("codec", "write header", "method", h.ServiceMethod, "seq", h.Seq, "error", h.Error)
+	}
 	if err = c.enc.Encode(h); err != nil {
-		log.Println("rpc: gob error encoding header:", err)
+		rpclog.Error("codec", "gob error encoding header", "err", err)
 		return
 	}
-	if err = c.enc.Encode(body); err != nil {
-		log.Println("rpc: gob error encoding body:", err)
-		return
+	return writeBody()
+}
+
+// gobBytesType is the gob type id of []byte followed by the field delta
+// of a value that is not a struct. The type is predefined, so that a
+// []byte is encoded the same in any stream.
+var gobBytesType = []byte{0x0a, 0x00}
+
+// gobBytesPrefix returns the start of the gob message of a []byte of n
+// bytes, up to the bytes themselves.
+func gobBytesPrefix(n int) []byte {
+	msg := appendGobUint(append([]byte(nil), gobBytesType...), uint64(n))
+	return append(appendGobUint(make([]byte, 0, 9+len(msg)), uint64(len(msg)+n)), msg...)
+}
+
+// appendGobUint appends x encoded as a gob unsigned integer: one byte
+// below 0x80, else its byte count negated and its big-endian bytes.
+func appendGobUint(b []byte, x uint64) []byte {
+	if x < 0x80 {
+		return append(b, byte(x))
+	}
+	var be [8]byte
+	i := len(be)
+	for ; x > 0; x >>= 8 {
+		i--
+		be[i] = byte(x)
 	}
-	return
+	return append(append(b, byte(i-len(be))), be[i:]...)
 }
 
 func (c *GobCodec) Close() error {
@@ -0,0 +1,447 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGobCodec_ConcurrentWrite(t *testing.T) {
+	client, server := net.Pipe()
+	w, r := NewGobCodec(client), NewGobCodec(server)
+	defer func() { _ = w.Close() }()
+	const writers = 50
+	var wg sync.WaitGroup
+	for i := 1; i <= writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			h := &Header{ServiceMethod: "Foo.Sum", Seq: uint64(i)}
+			if err := w.Write(h, fmt.Sprintf("body %d", i)); err != nil {
+				t.Error("write error:", err)
+			}
+		}(i)
+	}
+
+	seen := make(map[uint64]bool)
+	for len(seen) < writers {
+		var h Header
+		var body string
+		if err := r.ReadHeader(&h); err != nil {
+			t.Fatal("read header error:", err)
+		}
+		if err := r.ReadBody(&body); err != nil {
+			t.Fatal("read body error:", err)
+		}
+		if want := fmt.Sprintf("body %d", h.Seq); body != want || seen[h.Seq] {
+			t.Fatalf("expect %q once, but got %q", want, body)
+		}
+		seen[h.Seq] = true
+	}
+	wg.Wait()
+}
+
+// writeCounter counts the writes made to it.
+type writeCounter struct {
+	mu     sync.Mutex
+	writes int
+}
+
+func (c *writeCounter) Read([]byte) (int, error) { return 0, io.EOF }
+func (c *writeCounter) Close() error             { return nil }
+
+func (c *writeCounter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes++
+	return len(p), nil
+}
+
+func TestGobCodec_CoalescesFlushes(t *testing.T) {
+	conn := &writeCounter{}
+	gc := NewGobCodec(conn).(*GobCodec)
+	_ = gc.Write(&Header{Seq: 1}, "first")
+	assertWrites := func(want int) {
+		t.Helper()
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		if conn.writes != want {
+			t.Fatalf("expect %d writes to the conn, but got %d", want, conn.writes)
+		}
+	}
+	assertWrites(1)
+
+	// frames queued behind a write are flushed by the last of them
+	gc.mu.Lock()
+	var wg sync.WaitGroup
+	for i := 2; i <= 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_ = gc.Write(&Header{Seq: uint64(i)}, "queued")
+		}(i)
+	}
+	for atomic.LoadInt32(&gc.writers) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	gc.mu.Unlock()
+	wg.Wait()
+	assertWrites(2)
+}
+
+// plainCodec hides the DiscardBody of its codec, like a third-party codec.
+type plainCodec struct{ Codec }
+
+func TestGobCodec_DiscardBody(t *testing.T) {
+	type point struct{ X, Y int }
+	bodies := []struct {
+		bodyCodec Type
+		body      interface{}
+	}{
+		{"", 42},
+		{"", "a string"},
+		{"", point{1, 2}},
+		{"", map[string][]int{"a": {1, 2}}},
+		{"", []byte("bytes")},
+		{"", struct{}{}},
+		{JsonType, point{3, 4}},
+		{GobType, point{5, 6}},
+	}
+	for _, discard := range []func(Codec) error{
+		func(cc Codec) error { return cc.ReadBody(nil) },
+		func(cc Codec) error { return DiscardBody(plainCodec{cc}) },
+		DiscardBody,
+	} {
+		client, server := net.Pipe()
+		w, r := NewGobCodec(client), NewGobCodec(server)
+		go func() {
+			for i, b := range bodies {
+				_ = w.Write(&Header{Seq: uint64(i), BodyCodec: b.bodyCodec}, b.body)
+			}
+			// a body in a codec the reader doesn't know, which Write refuses
+			gc := w.(*GobCodec)
+			_ = gc.enc.Encode(&Header{Seq: 100, BodyCodec: "application/x-unknown"})
+			_ = gc.enc.Encode([]byte("unknown"))
+			_ = gc.buf.Flush()
+			_ = w.Write(&Header{Seq: 101}, point{7, 8})
+		}()
+		for i := 0; i <= len(bodies); i++ {
+			var h Header
+			if err := r.ReadHeader(&h); err != nil {
+				t.Fatal("read header error:", err)
+			}
+			if err := discard(r); err != nil {
+				t.Fatalf("discard body %d error: %v", h.Seq, err)
+			}
+		}
+		var h Header
+		var p point
+		if err := r.ReadHeader(&h); err != nil || r.ReadBody(&p) != nil {
+			t.Fatal("failed to read the frame after the discarded ones")
+		}
+		if h.Seq != 101 || p != (point{7, 8}) {
+			t.Fatalf("expect frame 101 with {7 8}, but got %d with %v", h.Seq, p)
+		}
+		_ = w.Close()
+		_ = r.Close()
+	}
+}
+
+func TestGobCodec_BodySizes(t *testing.T) {
+	client, server := net.Pipe()
+	w, r := NewGobCodec(client).(SizeReporter), NewGobCodec(server)
+	defer func() { _ = r.Close() }()
+	sizes := make(chan int64, 2)
+	go func() {
+		for _, body := range [][]byte{make([]byte, 10), make([]byte, 5000)} {
+			n, err := w.WriteSized(&Header{ServiceMethod: "Blob.Len"}, body)
+			if err != nil {
+				t.Error("write error:", err)
+			}
+			sizes <- n
+		}
+	}()
+	for _, bodyLen := range []int{10, 5000} {
+		var h Header
+		var body []byte
+		if err := r.ReadHeader(&h); err != nil {
+			t.Fatal("read header error:", err)
+		}
+		if err := r.ReadBody(&body); err != nil {
+			t.Fatal("read body error:", err)
+		}
+		read, written := r.(SizeReporter).ReadBodySize(), <-sizes
+		if read != written || read < int64(bodyLen) || read > int64(bodyLen)+16 {
+			t.Fatalf("expect about %d bytes read and written, but got %d and %d", bodyLen, read, written)
+		}
+	}
+}
+
+func TestGobCodec_SkipsLaterHeaderFields(t *testing.T) {
+	client, server := net.Pipe()
+	r := NewGobCodec(server)
+	defer func() { _ = r.Close() }()
+	// a header of a later protocol version, with a field this one lacks
+	type laterHeader struct {
+		ServiceMethod string
+		Seq           uint64
+		Flags         uint32
+	}
+	go func() {
+		enc := gob.NewEncoder(client)
+		_ = enc.Encode(&laterHeader{ServiceMethod: "Foo.Sum", Seq: 7, Flags: 3})
+		_ = enc.Encode("body")
+	}()
+	var h Header
+	var body string
+	if err := r.ReadHeader(&h); err != nil || h.ServiceMethod != "Foo.Sum" || h.Seq != 7 {
+		t.Fatalf("expect the known fields of the header, but got %+v, %v", h, err)
+	}
+	if err := r.ReadBody(&body); err != nil || body != "body" {
+		t.Fatalf("expect the body after the header, but got %q, %v", body, err)
+	}
+}
+
+func TestGobCodec_RawBody(t *testing.T) {
+	client, server := net.Pipe()
+	w, r := NewGobCodec(client), NewGobCodec(server).(*GobCodec)
+	defer func() { _ = w.Close() }()
+	go func() {
+		_ = w.Write(&Header{Seq: 1, BodyCodec: JsonType}, Encoded(`{"a":1}`))
+		_ = w.Write(&Header{Seq: 2}, "gob")
+		_ = w.Write(&Header{Seq: 3, BodyCodec: JsonType}, map[string]int{"a": 2})
+	}()
+
+	_ = r.ReadHeader(&Header{})
+	body, err := r.ReadRawBody()
+	if err != nil || string(body) != `{"a":1}` {
+		t.Fatalf("expect the encoded body written as is, but got %q, %v", body, err)
+	}
+	_ = r.ReadHeader(&Header{})
+	if _, err := r.ReadRawBody(); err != ErrNotFramed {
+		t.Fatalf("expect ErrNotFramed for a gob body, but got %v", err)
+	}
+	var s string
+	if err := r.ReadBody(&s); err != nil || s != "gob" {
+		t.Fatalf("expect the gob body still unread, but got %q, %v", s, err)
+	}
+	_ = r.ReadHeader(&Header{})
+	var e Encoded
+	if err := r.ReadBody(&e); err != nil || string(e) != `{"a":2}` {
+		t.Fatalf("expect the body kept encoded, but got %q, %v", e, err)
+	}
+}
+
+func TestGobCodec_DecodeErrors(t *testing.T) {
+	var stream nopConn
+	enc := gob.NewEncoder(&stream)
+	_ = enc.Encode(&Header{ServiceMethod: "Foo.Sum", Seq: 1})
+	_ = enc.Encode("not an int") // a value of another type
+	_ = enc.Encode(&Header{ServiceMethod: "Foo.Sum", Seq: 2})
+	_ = enc.Encode(42)
+	_ = enc.Encode(&Header{ServiceMethod: "Foo.Sum", Seq: 3})
+	_, _ = stream.Write([]byte{0xf8, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) // a length of 2^64-1
+	r := NewGobCodec(&stream)
+
+	var h Header
+	var n int
+	_ = r.ReadHeader(&h)
+	err := r.ReadBody(&n)
+	var de *DecodeError
+	if !errors.As(err, &de) || de.Fatal || errors.Is(err, ErrStreamCorrupt) {
+		t.Fatalf("expect a recoverable DecodeError for a type mismatch, but got %#v", err)
+	}
+	if err := r.ReadHeader(&h); err != nil || h.Seq != 2 {
+		t.Fatalf("expect the next header after a recoverable error, but got %+v, %v", h, err)
+	}
+	if err := r.ReadBody(&n); err != nil || n != 42 {
+		t.Fatalf("expect the next body, but got %d, %v", n, err)
+	}
+	_ = r.ReadHeader(&h)
+	if err := r.ReadBody(&n); !errors.Is(err, ErrStreamCorrupt) {
+		t.Fatalf("expect ErrStreamCorrupt for a bad message length, but got %v", err)
+	}
+	if err := r.ReadHeader(&h); err != io.EOF {
+		t.Fatalf("expect the errors of the conn as is, but got %#v", err)
+	}
+}
+
+func TestGobCodec_CorruptHeader(t *testing.T) {
+	var stream nopConn
+	_, _ = stream.Write([]byte{0x03, 0x7f, 0x7f, 0x7f}) // a message of an undefined type
+	r := NewGobCodec(&stream)
+	if err := r.ReadHeader(&Header{}); !errors.Is(err, ErrStreamCorrupt) {
+		t.Fatalf("expect a header that doesn't decode to be fatal, but got %v", err)
+	}
+}
+
+// bufferConn is a connection writing to a buffer.
+type bufferConn struct{ bytes.Buffer }
+
+func (c *bufferConn) Close() error { return nil }
+
+func TestGobCodec_WriteEncoded(t *testing.T) {
+	for _, n := range []int{0, 1, 127, 128, 255, 256, 1 << 16, 300000} {
+		raw := bytes.Repeat([]byte{7}, n)
+		var want, got bufferConn
+		_ = NewGobCodec(&want).Write(&Header{Seq: 1}, raw)
+		if err := NewGobCodec(&got).(EncodedWriter).WriteEncoded(&Header{Seq: 1}, raw); err != nil {
+			t.Fatal("write error:", err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Fatalf("expect the frame of %d bytes encoded like gob", n)
+		}
+	}
+
+	// in between frames of the encoder, whose type ids are unaffected
+	client, server := net.Pipe()
+	w, r := NewGobCodec(client), NewGobCodec(server)
+	defer func() { _ = w.Close() }()
+	type pair struct{ A, B int }
+	go func() {
+		_ = w.Write(&Header{Seq: 1}, pair{1, 2})
+		_ = w.(EncodedWriter).WriteEncoded(&Header{Seq: 2}, []byte("raw"))
+		_ = w.(EncodedWriter).WriteEncoded(&Header{Seq: 3, BodyCodec: JsonType}, []byte(`{"A":3}`))
+		_ = w.Write(&Header{Seq: 4}, pair{4, 5})
+	}()
+	var p pair
+	var data []byte
+	_ = r.ReadHeader(&Header{})
+	if err := r.ReadBody(&p); err != nil || p != (pair{1, 2}) {
+		t.Fatalf("expect the first body, but got %+v, %v", p, err)
+	}
+	_ = r.ReadHeader(&Header{})
+	if err := r.ReadBody(&data); err != nil || string(data) != "raw" {
+		t.Fatalf("expect the encoded bytes, but got %q, %v", data, err)
+	}
+	_ = r.ReadHeader(&Header{})
+	if err := r.ReadBody(&p); err != nil || p.A != 3 {
+		t.Fatalf("expect the body in its body codec, but got %+v, %v", p, err)
+	}
+	_ = r.ReadHeader(&Header{})
+	if err := r.ReadBody(&p); err != nil || p != (pair{4, 5}) {
+		t.Fatalf("expect the last body, but got %+v, %v", p, err)
+	}
+}
+
+func TestGobCodec_Compression(t *testing.T) {
+	zeros := make([]byte, 1<<20)
+	var plain, gzipped bufferConn
+	_ = NewGobCodec(&plain).Write(&Header{Seq: 1}, zeros)
+	_ = NewGobCodec(&gzipped).Write(&Header{Seq: 1, Compression: Gzip}, zeros)
+	if gzipped.Len()*100 > plain.Len() {
+		t.Fatalf("expect the compressed frame much smaller, but got %d bytes for %d", gzipped.Len(), plain.Len())
+	}
+
+	client, server := net.Pipe()
+	w, r := NewGobCodec(client), NewGobCodec(server)
+	defer func() { _ = w.Close() }()
+	go func() {
+		_ = w.Write(&Header{Seq: 1, Compression: Gzip}, "gzip")
+		_ = w.Write(&Header{Seq: 2}, "plain")
+		_ = w.Write(&Header{Seq: 3, Compression: Gzip, Checksum: true, BodyCodec: JsonType}, "both")
+		_ = w.Write(&Header{Seq: 4, Checksum: true}, "checked")
+		_ = w.(EncodedWriter).WriteEncoded(&Header{Seq: 5, Compression: Gzip, BodyCodec: JsonType}, []byte(`"encoded"`))
+	}()
+	for _, want := range []string{"gzip", "plain", "both", "checked", "encoded"} {
+		var h Header
+		var s string
+		if err := r.ReadHeader(&h); err != nil {
+			t.Fatal("read header error:", err)
+		}
+		if err := r.ReadBody(&s); err != nil || s != want {
+			t.Fatalf("expect %q in frame %d, but got %q, %v", want, h.Seq, s, err)
+		}
+	}
+
+	if err := NewGobCodec(&bufferConn{}).Write(&Header{Compression: "lz4"}, "x"); !errors.Is(err, ErrUnknownCompression) {
+		t.Fatalf("expect ErrUnknownCompression, but got %v", err)
+	}
+}
+
+func TestGobCodec_BodyChecksum(t *testing.T) {
+	var stream nopConn
+	w := NewGobCodec(&stream)
+	_ = w.Write(&Header{Seq: 1, Checksum: true}, "checked")
+	data := stream.Bytes()
+	data[len(data)-5] ^= 0xff // the last byte of the body, before its checksum
+	_ = w.Write(&Header{Seq: 2, Checksum: true}, "next")
+
+	r := NewGobCodec(&stream)
+	var h Header
+	var s string
+	_ = r.ReadHeader(&h)
+	if err := r.ReadBody(&s); !errors.Is(err, ErrBodyChecksum) {
+		t.Fatalf("expect ErrBodyChecksum, but got %v", err)
+	}
+	if err := r.ReadHeader(&h); err != nil || h.Seq != 2 {
+		t.Fatalf("expect the next header after a checksum mismatch, but got %+v, %v", h, err)
+	}
+	if err := r.ReadBody(&s); err != nil || s != "next" {
+		t.Fatalf("expect the next body, but got %q, %v", s, err)
+	}
+}
+
+func TestGobCodec_DecompressedTooLarge(t *testing.T) {
+	defer func(n int64) { MaxDecompressedSize = n }(MaxDecompressedSize)
+	MaxDecompressedSize = 1 << 10
+	var stream nopConn
+	w := NewGobCodec(&stream)
+	_ = w.Write(&Header{Seq: 1, Compression: Gzip}, make([]byte, 1<<20))
+	r := NewGobCodec(&stream)
+	var data []byte
+	_ = r.ReadHeader(&Header{})
+	if err := r.ReadBody(&data); !errors.Is(err, ErrDecompressedTooLarge) {
+		t.Fatalf("expect ErrDecompressedTooLarge, but got %v", err)
+	}
+}
+
+// discardConn is a connection dropping what is written to it.
+type discardConn struct{}
+
+func (discardConn) Read([]byte) (int, error)    { return 0, io.EOF }
+func (discardConn) Write(p []byte) (int, error) { return len(p), nil }
+func (discardConn) Close() error                { return nil }
+
+// BenchmarkGobCodec_Broadcast writes a 256KB body to 50 connections:
+// encoded for each, encoded once then copied by gob into each frame, and
+// encoded once then written as is with WriteEncoded.
+func BenchmarkGobCodec_Broadcast(b *testing.B) {
+	type blob struct{ Data []byte }
+	body := blob{Data: bytes.Repeat([]byte{7}, 256<<10)}
+	conns := make([]Codec, 50)
+	for i := range conns {
+		conns[i] = NewGobCodec(discardConn{})
+	}
+	h := &Header{ServiceMethod: "Foo.Bar"}
+	b.Run("PerConn", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, cc := range conns {
+				_ = cc.Write(h, body)
+			}
+		}
+	})
+	b.Run("Bytes", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			raw, _ := gobBody{}.Marshal(body)
+			for _, cc := range conns {
+				_ = cc.Write(h, raw)
+			}
+		}
+	})
+	b.Run("Encoded", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			raw, _ := gobBody{}.Marshal(body)
+			for _, cc := range conns {
+				_ = cc.(EncodedWriter).WriteEncoded(h, raw)
+			}
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"tinyrpc/codec"
+)
+
+// readCounter counts the bytes read from a connection.
+type readCounter struct {
+	net.Conn
+	n uint64
+}
+
+func (c *readCounter) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddUint64(&c.n, uint64(n))
+	return n, err
+}
+
+func (c *readCounter) count() uint64 { return atomic.LoadUint64(&c.n) }
+
+type ZerosArgs struct {
+	N       int
+	Plain   bool // overrides the compression of the reply
+	Checked bool // overrides the checksum of the reply
+}
+
+type Zeros struct{}
+
+func (Zeros) Make(ctx context.Context, args ZerosArgs, reply *[]byte) error {
+	if args.Plain {
+		SetTrailer(ctx, ReplyCompressionHeader, "")
+	}
+	if args.Checked {
+		SetTrailer(ctx, ReplyChecksumHeader, "true")
+	}
+	*reply = make([]byte, args.N)
+	return nil
+}
+
+// dialCounted returns a client allowing body codecs, with the counter of
+// the bytes it reads.
+func dialCounted(t *testing.T, addr string) (*Client, *readCounter) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	rc := &readCounter{Conn: conn}
+	client, err := NewClient(rc, &Option{MagicNumber: MagicNumber, CodecType: codec.GobType, HeartbeatIdle: -1, AllowBodyCodecs: true})
+	_assert(err == nil, "handshake error: %v", err)
+	t.Cleanup(func() { _ = client.Close() })
+	return client, rc
+}
+
+func TestWithCompression(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(Blob{})
+	client, _ := dialCounted(t, startServer(t, server).Addr().String())
+
+	zeros := make([]byte, 1<<20)
+	sizes := make(map[bool][]uint64)
+	for _, compressed := range []bool{true, false, true, false} {
+		var opts []CallOption
+		if compressed {
+			opts = append(opts, WithCompression(codec.Gzip), WithChecksum(true))
+		}
+		before := server.Stats().BytesRead
+		var n int
+		err := client.Call("Blob.Len", zeros, &n, opts...)
+		_assert(err == nil && n == len(zeros), "expect the body decoded whole, but got %d, %v", n, err)
+		sizes[compressed] = append(sizes[compressed], server.Stats().BytesRead-before)
+	}
+	for i := range sizes[true] {
+		_assert(sizes[true][i] < 16<<10, "expect a compressed request to take a few KB, but got %d bytes", sizes[true][i])
+		_assert(sizes[false][i] > 1<<20, "expect a plain request to take its size, but got %d bytes", sizes[false][i])
+	}
+}
+
+func TestWithCompression_Reply(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(Zeros{})
+	client, rc := dialCounted(t, startServer(t, server).Addr().String())
+
+	for _, c := range []struct {
+		args       ZerosArgs
+		opts       []CallOption
+		compressed bool
+	}{
+		{ZerosArgs{N: 1 << 20}, []CallOption{WithCompression(codec.Gzip)}, true},
+		{ZerosArgs{N: 1 << 20}, nil, false},
+		{ZerosArgs{N: 1 << 20, Plain: true}, []CallOption{WithCompression(codec.Gzip)}, false},
+		{ZerosArgs{N: 1 << 20, Checked: true}, []CallOption{WithCompression(codec.Gzip), WithChecksum(false)}, true},
+	} {
+		before := rc.count()
+		var reply []byte
+		call := <-client.Go("Zeros.Make", c.args, &reply, make(chan *Call, 1), c.opts...).Done
+		n := rc.count() - before
+		_assert(call.Error == nil && len(reply) == c.args.N, "expect the reply decoded whole, but got %d bytes, %v", len(reply), call.Error)
+		_assert(c.compressed == (n < 16<<10), "expect the reply compressed %v, but read %d bytes", c.compressed, n)
+		_, ok := call.Trailer[ReplyCompressionHeader]
+		_assert(!ok && call.Trailer[ReplyChecksumHeader] == "", "expect the overrides kept from the trailer, but got %v", call.Trailer)
+	}
+}
+
+func TestWithCompression_NotNegotiated(t *testing.T) {
+	addr := startServer(t, NewServer()).Addr().String()
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	for _, opt := range []CallOption{WithCompression(codec.Gzip), WithChecksum(true)} {
+		err := client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, new(int), opt)
+		_assert(errors.Is(err, ErrBodyEncoding), "expect ErrBodyEncoding without the handshake, but got %v", err)
+	}
+
+	negotiated, _ := dialCounted(t, addr)
+	err = negotiated.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, new(int), WithCompression("lz4"))
+	_assert(errors.Is(err, ErrBodyEncoding), "expect ErrBodyEncoding for a compression the server lacks, but got %v", err)
+	var reply int
+	_assert(negotiated.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply) == nil && reply == 3, "expect the connection still usable")
+}
@@ -0,0 +1,367 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"tinyrpc/internal/clock"
+	"tinyrpc/rpclog"
+)
+
+// ServerConfig is the configuration of a Server set with ServerOptions.
+// Zero fields take the defaults of a Server made without options.
+type ServerConfig struct {
+	// HandleTimeout is the handle timeout of every call, along with
+	// SetMethodTimeout and Option.HandleTimeout. No limit if 0.
+	HandleTimeout time.Duration
+	// MaxConnections closes the connections accepted beyond this many
+	// being served. No limit if 0.
+	MaxConnections int
+	// IdleTimeout closes connections without calls in flight and without
+	// requests for this long. Never if 0.
+	IdleTimeout time.Duration
+	// Logger receives the messages of the server, the default logger if nil.
+	Logger rpclog.Logger
+	// Interceptors wrap every call to a registered method or to the raw
+	// handler, the first one outermost. Built-in services are not wrapped.
+	Interceptors []Interceptor
+	// MaxBodySize is the size in bytes of the largest request body read.
+	// A larger one fails with ErrBodyTooLarge and closes the connection.
+	// No limit if 0.
+	MaxBodySize int64
+	// MaxInFlight is how many requests of a connection are handled at
+	// once. Those read beyond it are answered with ErrResourceExhausted.
+	// Calls to the built-in services are not limited. No limit if 0.
+	MaxInFlight int
+	// WarnOnly are the limits only warned about, see WarnOnly.
+	WarnOnly WarnOnly
+	// Authenticate, if not nil, accepts or rejects the Option.AuthToken
+	// of each connection. Rejected connections are closed after the
+	// handshake.
+	Authenticate func(token string) error
+	// EncryptionKeys are the AES keys of the clients encrypting their
+	// stream, by Option.EncryptionKeyID. Keep the old key along with the
+	// new one while the clients rotate.
+	EncryptionKeys map[string][]byte
+	// DebugAuth, if not nil, accepts or rejects each request to the
+	// endpoints of HandleDebug, e.g. BasicAuth.
+	DebugAuth func(r *http.Request) error
+	// WriteCoalescing buffers the responses of each connection to write
+	// them together. Off by default.
+	WriteCoalescing WriteCoalescing
+	// Trace, if not nil, is run at the stages of the connections and the
+	// calls of the server.
+	Trace *ServerTrace
+	// Workers handle the calls to the registered methods, queued by their
+	// priority (see WithPriority), on this many goroutines. The calls of
+	// connections with Option.OrderedResponses, to the raw handler and to
+	// the built-in services get a goroutine each, as every call does if 0.
+	Workers int
+	// PriorityAging is how long a queued call waits before it counts as
+	// one priority higher, so that low ones aren't starved.
+	// DefaultPriorityAging if 0.
+	PriorityAging time.Duration
+	// MaxQueued is the number of calls, of all priorities, that may wait
+	// for a worker; the calls over it fail with ErrResourceExhausted.
+	// DefaultMaxQueued if 0.
+	MaxQueued int
+	// StrictProtocol closes the connections breaking the protocol, as
+	// StrictChecks tune, rather than tolerating what it can, and counts
+	// them in ServerStats.ProtocolAnomalies. Off by default.
+	StrictProtocol bool
+	StrictChecks   StrictChecks
+	// AtMostOnce remembers the calls of the clients WithAtMostOnce, as
+	// Dedup bounds, so that their retries get the result of the first
+	// attempt instead of executing the method again. Off by default.
+	AtMostOnce bool
+	Dedup      DedupConfig
+	// FrameHistory keeps the last frames of every connection, as History
+	// bounds, for _admin_.DescribeConnection and for the log when the
+	// connection breaks its stream or the protocol. It formats the start
+	// of every body. Off by default.
+	FrameHistory bool
+	History      HistoryConfig
+	// Timings collect the phase timings of every call, see Timings. The
+	// server reads its clock at the phases only if there are some.
+	Timings []TimingsFunc
+	// DrainGrace is how long the connections served with a context that
+	// is done may finish their calls before they are closed, see
+	// ServeConnContext. DefaultDrainGrace if 0.
+	DrainGrace time.Duration
+}
+
+// Interceptor wraps the calls of a server, or of a client, e.g. for
+// authentication or metrics. It calls next to go on with the call, or
+// returns an error instead. args is the argument of the method, the
+// RawBody for the raw handler of a server.
+type Interceptor func(ctx context.Context, serviceMethod string, args interface{}, next func(ctx context.Context) error) error
+
+// A ServerOption configures a Server, see NewServer and Configure.
+type ServerOption func(*ServerConfig) error
+
+// ErrServerStarted is returned by Configure once the server serves.
+var ErrServerStarted = errors.New("rpc server: configured after serving started")
+
+// WithHandleTimeout sets ServerConfig.HandleTimeout.
+func WithHandleTimeout(d time.Duration) ServerOption {
+	return func(c *ServerConfig) error {
+		if d < 0 {
+			return fmt.Errorf("rpc server: negative handle timeout %s", d)
+		}
+		c.HandleTimeout = d
+		return nil
+	}
+}
+
+// WithMaxConnections sets ServerConfig.MaxConnections.
+func WithMaxConnections(n int) ServerOption {
+	return func(c *ServerConfig) error {
+		if n < 0 {
+			return fmt.Errorf("rpc server: negative max connections %d", n)
+		}
+		c.MaxConnections = n
+		return nil
+	}
+}
+
+// WithIdleTimeout sets ServerConfig.IdleTimeout.
+func WithIdleTimeout(d time.Duration) ServerOption {
+	return func(c *ServerConfig) error {
+		if d < 0 {
+			return fmt.Errorf("rpc server: negative idle timeout %s", d)
+		}
+		c.IdleTimeout = d
+		return nil
+	}
+}
+
+// WithMaxInFlight sets ServerConfig.MaxInFlight.
+func WithMaxInFlight(n int) ServerOption {
+	return func(c *ServerConfig) error {
+		if n < 0 {
+			return fmt.Errorf("rpc server: negative max in flight %d", n)
+		}
+		c.MaxInFlight = n
+		return nil
+	}
+}
+
+// WithMaxBodySize sets ServerConfig.MaxBodySize.
+func WithMaxBodySize(n int64) ServerOption {
+	return func(c *ServerConfig) error {
+		if n < 0 {
+			return fmt.Errorf("rpc server: negative max body size %d", n)
+		}
+		c.MaxBodySize = n
+		return nil
+	}
+}
+
+// WithAuthenticator sets ServerConfig.Authenticate.
+func WithAuthenticator(fn func(token string) error) ServerOption {
+	return func(c *ServerConfig) error {
+		c.Authenticate = fn
+		return nil
+	}
+}
+
+// WithEncryptionKeys sets ServerConfig.EncryptionKeys.
+func WithEncryptionKeys(keys map[string][]byte) ServerOption {
+	return func(c *ServerConfig) error {
+		for id, key := range keys {
+			if n := len(key); n != 16 && n != 24 && n != 32 {
+				return fmt.Errorf("rpc server: encryption key %q of %d bytes, not 16, 24 or 32", id, n)
+			}
+		}
+		c.EncryptionKeys = keys
+		return nil
+	}
+}
+
+// WithDebugAuth sets ServerConfig.DebugAuth.
+func WithDebugAuth(fn func(r *http.Request) error) ServerOption {
+	return func(c *ServerConfig) error {
+		c.DebugAuth = fn
+		return nil
+	}
+}
+
+// WithWriteCoalescing sets ServerConfig.WriteCoalescing.
+func WithWriteCoalescing(wc WriteCoalescing) ServerOption {
+	return func(c *ServerConfig) error {
+		if wc.Delay < 0 || wc.MaxBytes < 0 {
+			return fmt.Errorf("rpc server: negative write coalescing %+v", wc)
+		}
+		c.WriteCoalescing = wc
+		return nil
+	}
+}
+
+// WithWorkers sets ServerConfig.Workers and ServerConfig.PriorityAging.
+func WithWorkers(n int, aging time.Duration) ServerOption {
+	return func(c *ServerConfig) error {
+		if n < 0 || aging < 0 {
+			return fmt.Errorf("rpc server: negative workers %d or aging %s", n, aging)
+		}
+		c.Workers, c.PriorityAging = n, aging
+		return nil
+	}
+}
+
+// WithMaxQueued sets ServerConfig.MaxQueued.
+func WithMaxQueued(n int) ServerOption {
+	return func(c *ServerConfig) error {
+		if n < 0 {
+			return fmt.Errorf("rpc server: negative max queued %d", n)
+		}
+		c.MaxQueued = n
+		return nil
+	}
+}
+
+// WithDrainGrace sets ServerConfig.DrainGrace.
+func WithDrainGrace(d time.Duration) ServerOption {
+	return func(c *ServerConfig) error {
+		if d < 0 {
+			return fmt.Errorf("rpc server: negative drain grace %s", d)
+		}
+		c.DrainGrace = d
+		return nil
+	}
+}
+
+// WithServerTrace sets ServerConfig.Trace.
+func WithServerTrace(trace *ServerTrace) ServerOption {
+	return func(c *ServerConfig) error {
+		c.Trace = trace
+		return nil
+	}
+}
+
+// WithLogger sets ServerConfig.Logger.
+func WithLogger(l rpclog.Logger) ServerOption {
+	return func(c *ServerConfig) error {
+		c.Logger = l
+		return nil
+	}
+}
+
+// WithInterceptors appends interceptors to ServerConfig.Interceptors.
+func WithInterceptors(interceptors ...Interceptor) ServerOption {
+	return func(c *ServerConfig) error {
+		for _, i := range interceptors {
+			if i == nil {
+				return errors.New("rpc server: nil interceptor")
+			}
+		}
+		c.Interceptors = append(c.Interceptors, interceptors...)
+		return nil
+	}
+}
+
+// Configure applies opts to the server. It fails with ErrServerStarted
+// once the server listened or served a listener or a connection, and
+// applies none of opts if one of them is invalid.
+func (server *Server) Configure(opts ...ServerOption) error {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.started {
+		return ErrServerStarted
+	}
+	cfg := server.config.clone()
+	for _, opt := range opts {
+		if err := opt(&cfg); err != nil {
+			return err
+		}
+	}
+	server.config = cfg
+	return nil
+}
+
+// Config returns the configuration of the server.
+func (server *Server) Config() ServerConfig {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.config.clone()
+}
+
+func (c ServerConfig) clone() ServerConfig {
+	c.Interceptors = append([]Interceptor(nil), c.Interceptors...)
+	c.Timings = append([]TimingsFunc(nil), c.Timings...)
+	return c
+}
+
+// start freezes the configuration. server.mu must be held.
+func (server *Server) start() {
+	if !server.started {
+		server.live.CompareAndSwap(nil, server.initialLimits())
+		if n := server.config.Workers; n > 0 {
+			server.sched = newScheduler(server.config.PriorityAging, server.config.MaxQueued, clock.Or(server.clock).Now)
+			server.sched.start(n)
+		}
+		if server.config.AtMostOnce {
+			server.dedup = newDedupWindow(server.config.Dedup, clock.Or(server.clock).Now)
+		}
+	}
+	server.started = true
+}
+
+// log logs msg with the logger of the server.
+func (server *Server) log(level rpclog.Level, msg string, kv ...interface{}) {
+	l := server.config.Logger
+	if l == nil {
+		l = rpclog.Default()
+	}
+	l.Log(level, "server", msg, kv...)
+}
+
+// intercept calls call through interceptors, the first one outermost.
+func intercept(interceptors []Interceptor, ctx context.Context, serviceMethod string, args interface{}, call func(ctx context.Context) error) error {
+	var next func(i int) func(ctx context.Context) error
+	next = func(i int) func(ctx context.Context) error {
+		if i == len(interceptors) {
+			return call
+		}
+		return func(ctx context.Context) error {
+			return interceptors[i](ctx, serviceMethod, args, next(i+1))
+		}
+	}
+	return next(0)(ctx)
+}
+
+// watchIdle closes sc once it has been idle for the idle timeout, until
+// the function it returns is called.
+func (server *Server) watchIdle(sc *serverConn) func() {
+	d := sc.idleTimeout
+	if d <= 0 {
+		return func() {}
+	}
+	c := clock.Or(server.clock)
+	stop := make(chan struct{})
+	go func() {
+		t := c.NewTimer(d)
+		for {
+			select {
+			case <-t.C():
+			case <-stop:
+				t.Stop()
+				return
+			}
+			sc.mu.Lock()
+			left := d - c.Now().Sub(sc.lastActive)
+			if sc.inflight > 0 {
+				left = d
+			}
+			sc.mu.Unlock()
+			if left <= 0 {
+				server.log(rpclog.LevelInfo, "closing idle connection", "conn", sc.id)
+				_ = sc.cc.Close()
+				return
+			}
+			t = c.NewTimer(left)
+		}
+	}()
+	return func() { close(stop) }
+}
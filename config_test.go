@@ -0,0 +1,169 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"tinyrpc/rpclog"
+	"tinyrpc/tinyrpctest"
+)
+
+// recordingLogger keeps the messages it receives.
+type recordingLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *recordingLogger) Log(level rpclog.Level, subsystem, msg string, kv ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.msgs = append(l.msgs, subsystem+": "+msg)
+}
+
+func (l *recordingLogger) has(msg string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range l.msgs {
+		if strings.Contains(m, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNewServer_Options(t *testing.T) {
+	logger := &recordingLogger{}
+	noop := func(ctx context.Context, _ string, _ interface{}, next func(context.Context) error) error {
+		return next(ctx)
+	}
+	server := NewServer(
+		WithHandleTimeout(time.Second),
+		WithMaxConnections(10),
+		WithLogger(logger),
+		WithInterceptors(noop, noop),
+	)
+	cfg := server.Config()
+	_assert(cfg.HandleTimeout == time.Second && cfg.MaxConnections == 10 && cfg.IdleTimeout == 0,
+		"expect the options applied, but got %+v", cfg)
+	_assert(cfg.Logger == logger && len(cfg.Interceptors) == 2, "expect the logger and 2 interceptors, but got %+v", cfg)
+
+	_assert(server.Configure(WithIdleTimeout(time.Minute)) == nil, "expect Configure to work before serving")
+	_assert(server.Config().IdleTimeout == time.Minute, "expect the idle timeout set")
+	err := server.Configure(WithHandleTimeout(-time.Second), WithMaxConnections(5))
+	_assert(err != nil && server.Config().MaxConnections == 10, "expect an invalid option to fail them all, but got %v", err)
+
+	startServer(t, server)
+	err = server.Configure(WithMaxConnections(20))
+	_assert(errors.Is(err, ErrServerStarted), "expect ErrServerStarted once serving, but got %v", err)
+	_assert(server.Config().MaxConnections == 10, "expect the configuration frozen")
+
+	func() {
+		defer func() { _assert(recover() != nil, "expect NewServer to panic on an invalid option") }()
+		NewServer(WithInterceptors(nil))
+	}()
+	var zero Server
+	_assert(zero.Config().HandleTimeout == 0 && zero.Configure() == nil, "expect the zero Server usable")
+}
+
+func TestServer_Interceptors(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	trace := func(name string) Interceptor {
+		return func(ctx context.Context, method string, args interface{}, next func(context.Context) error) error {
+			mu.Lock()
+			calls = append(calls, name+" "+method)
+			mu.Unlock()
+			return next(ctx)
+		}
+	}
+	deny := func(ctx context.Context, method string, args interface{}, next func(context.Context) error) error {
+		if a, ok := args.(Args); ok && a.Num1 < 0 {
+			return errors.New("negative")
+		}
+		return next(ctx)
+	}
+	server := NewServer(WithInterceptors(trace("outer"), trace("inner"), deny))
+	client, err := Dial("tcp", startServer(t, server).Addr().String(), &Option{HeartbeatIdle: -1})
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	_assert(client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply) == nil && reply == 3, "failed to call Foo.Sum")
+	err = client.Call("Foo.Sum", Args{Num1: -1, Num2: 2}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "negative"), "expect the call denied, but got %v", err)
+	_assert(client.Call("_ping_.Ping", 1, &reply) == nil, "failed to ping")
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"outer Foo.Sum", "inner Foo.Sum", "outer Foo.Sum", "inner Foo.Sum"}
+	_assert(reflect.DeepEqual(calls, want), "expect the interceptors in order and not on built-ins, but got %v", calls)
+}
+
+func TestServer_ConfigLimits(t *testing.T) {
+	t.Run("handle timeout", func(t *testing.T) {
+		server := NewServer(WithHandleTimeout(50 * time.Millisecond))
+		var slow Slow
+		_ = server.Register(&slow)
+		client, err := Dial("tcp", startServer(t, server).Addr().String(), &Option{HeartbeatIdle: -1})
+		if err != nil {
+			t.Fatal("dial error:", err)
+		}
+		defer func() { _ = client.Close() }()
+		var reply int
+		err = client.Call("Slow.Sleep", 500, &reply)
+		_assert(errors.Is(err, ErrHandleTimeout), "expect ErrHandleTimeout, but got %v", err)
+	})
+	t.Run("max connections", func(t *testing.T) {
+		logger := &recordingLogger{}
+		server := NewServer(WithMaxConnections(1), WithLogger(logger))
+		addr := startServer(t, server).Addr().String()
+		first, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1})
+		if err != nil {
+			t.Fatal("dial error:", err)
+		}
+		defer func() { _ = first.Close() }()
+		var reply int
+		_assert(first.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply) == nil, "failed to call on the first connection")
+		second, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1})
+		if err == nil {
+			defer func() { _ = second.Close() }()
+			err = second.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		}
+		_assert(err != nil, "expect the second connection closed")
+		_assert(logger.has("server: too many connections"), "expect the server logger used, but got %v", logger.msgs)
+	})
+	t.Run("idle timeout", func(t *testing.T) {
+		clock := tinyrpctest.NewClock()
+		server := NewServer(WithIdleTimeout(time.Minute))
+		server.SetClock(clock)
+		conn, peer := net.Pipe()
+		go server.ServeConn(conn)
+		client, err := NewClient(peer, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, HeartbeatIdle: -1})
+		if err != nil {
+			t.Fatal("client error:", err)
+		}
+		defer func() { _ = client.Close() }()
+		var foo Foo
+		_ = server.Register(&foo)
+		var reply int
+		_assert(client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply) == nil, "failed to call Foo.Sum")
+		clock.BlockUntil(1)
+		clock.Advance(30 * time.Second)
+		_assert(client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply) == nil, "expect the connection kept within the idle timeout")
+		for server.Stats().InFlight > 0 {
+			time.Sleep(time.Millisecond) // the call ends after its response
+		}
+		clock.Advance(time.Minute)
+		select {
+		case <-client.done:
+		case <-time.After(time.Second):
+			t.Fatal("expect the idle connection closed")
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package tinyrpc
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+	"tinyrpc/codec"
+)
+
+// ValidationErrors are the problems Validate found, in the order it
+// checks them.
+type ValidationErrors []error
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return "rpc: invalid configuration: " + strings.Join(msgs, "; ")
+}
+
+// registerError is the error of registering the service name.
+type registerError struct {
+	name string
+	err  error
+}
+
+// Validate checks the server without serving anything: the registrations
+// that failed, e.g. a service name defined twice, unless the name was
+// registered later, the codecs of SetCodecs
+// and of the ListenerOptions of AddListener, and the timeouts of
+// SetMethodTimeout, which must name registered methods and be shorter
+// than the handle timeout of their service to have any effect. It returns
+// every problem found as ValidationErrors, nil if none. The listeners
+// refuse to serve a server that fails it.
+func (server *Server) Validate() error {
+	return server.checkConfig(nil, nil)
+}
+
+// checkConfig is Validate, also checking lopt, the options of lis, if not
+// nil.
+func (server *Server) checkConfig(lis net.Listener, lopt *ListenerOptions) error {
+	server.mu.Lock()
+	var errs ValidationErrors
+	for _, e := range server.regErrs {
+		errs = append(errs, e.err)
+	}
+	added := server.added
+	server.mu.Unlock()
+
+	errs = append(errs, server.checkCodecs()...)
+	errs = append(errs, server.validateTimeouts()...)
+	for _, l := range added {
+		errs = append(errs, server.validateListener(listenerName(l.lis), l.opt)...)
+	}
+	if lopt != nil {
+		errs = append(errs, server.validateListener(listenerName(lis), lopt)...)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// checkCodecs returns the codecs of SetCodecs that aren't registered.
+func (server *Server) checkCodecs() []error {
+	var errs []error
+	for _, t := range server.codecs {
+		if codec.NewCodecFuncMap[t] == nil {
+			errs = append(errs, fmt.Errorf("rpc server: SetCodecs: unknown codec %q", t))
+		}
+	}
+	return errs
+}
+
+// listenerName names lis in the errors of Validate.
+func listenerName(lis net.Listener) string {
+	if lis == nil {
+		return "listener"
+	}
+	return "listener " + lis.Addr().String()
+}
+
+// validateListener returns the problems of lopt, the options of the
+// listener name.
+func (server *Server) validateListener(name string, lopt *ListenerOptions) []error {
+	if lopt == nil {
+		return nil
+	}
+	var errs []error
+	for _, t := range lopt.Codecs {
+		switch {
+		case codec.NewCodecFuncMap[t] == nil:
+			errs = append(errs, fmt.Errorf("rpc server: %s: unknown codec %q", name, t))
+		case server.codecs != nil && !hasCodec(server.codecs, t):
+			errs = append(errs, fmt.Errorf("rpc server: %s: codec %q not accepted by SetCodecs", name, t))
+		}
+	}
+	if lopt.MaxBodySize < 0 {
+		errs = append(errs, fmt.Errorf("rpc server: %s: negative MaxBodySize %d", name, lopt.MaxBodySize))
+	}
+	if lopt.IdleTimeout < 0 {
+		errs = append(errs, fmt.Errorf("rpc server: %s: negative IdleTimeout %s", name, lopt.IdleTimeout))
+	}
+	if c := lopt.TLSConfig; c != nil && len(c.Certificates) == 0 && c.GetCertificate == nil && c.GetConfigForClient == nil {
+		errs = append(errs, fmt.Errorf("rpc server: %s: TLS config without certificates", name))
+	}
+	return errs
+}
+
+func hasCodec(types []codec.Type, t codec.Type) bool {
+	for _, u := range types {
+		if u == t {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTimeouts returns the problems of the method timeouts, by
+// pattern. Without a raw handler, the calls to methods that aren't
+// registered fail before any timeout applies.
+func (server *Server) validateTimeouts() []error {
+	var errs []error
+	server.timeouts.each(func(pattern string, d time.Duration) {
+		serviceName := strings.TrimSuffix(pattern, ".*")
+		var svc *service
+		if serviceName != pattern {
+			if svc = server.builtin(serviceName); svc == nil {
+				if svci, ok := server.serviceMap.Load(serviceName); ok {
+					svc = svci.(*service)
+				}
+			}
+		} else if s, _, err := server.findService(pattern); err == nil {
+			svc = s
+		}
+		if svc == nil {
+			if server.rawHandler == nil {
+				errs = append(errs, fmt.Errorf("rpc server: method timeout of %s, which isn't registered", pattern))
+			}
+			return
+		}
+		limit := server.currentLimits().HandleTimeout
+		if svc.config.handleTimeout > 0 {
+			limit = svc.config.handleTimeout
+		}
+		if limit > 0 && d >= limit {
+			errs = append(errs, fmt.Errorf("rpc server: method timeout %s of %s never applies, the handle timeout of %s is %s", d, pattern, svc.name, limit))
+		}
+	})
+	return errs
+}
@@ -0,0 +1,76 @@
+package tinyrpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"tinyrpc/codec"
+)
+
+func TestServer_ValidateConfig(t *testing.T) {
+	server := NewServer(WithHandleTimeout(time.Second))
+	var foo Foo
+	_assert(server.Register(&foo) == nil, "register Foo")
+	_assert(server.Validate() == nil, "expect a valid server, but got %v", server.Validate())
+
+	_ = server.Register(&foo)
+	_ = server.RegisterName("Bad.Name", &foo)
+	server.SetCodecs(codec.GobType, "application/x-nope")
+	server.SetMethodTimeout("Foo.Nope", time.Millisecond)
+	server.SetMethodTimeout("Bar.*", time.Millisecond)
+	server.SetMethodTimeout("Foo.Sum", time.Minute)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	_assert(err == nil, "network error: %v", err)
+	defer func() { _ = lis.Close() }()
+	server.AddListener(lis, &ListenerOptions{Codecs: []codec.Type{"application/x-other"}, MaxBodySize: -1})
+
+	err = server.Validate()
+	var errs ValidationErrors
+	_assert(errors.As(err, &errs), "expect ValidationErrors, but got %v", err)
+	for _, want := range []string{
+		"service already defined: Foo",
+		"invalid service name: Bad.Name",
+		`SetCodecs: unknown codec "application/x-nope"`,
+		"method timeout of Bar.*, which isn't registered",
+		"method timeout of Foo.Nope, which isn't registered",
+		"method timeout 1m0s of Foo.Sum never applies",
+		`unknown codec "application/x-other"`,
+		"negative MaxBodySize -1",
+	} {
+		_assert(strings.Contains(err.Error(), want), "expect %q reported, but got %v", want, err)
+	}
+	_assert(len(errs) == 8, "expect 8 problems, but got %d: %v", len(errs), err)
+
+	err = server.Run(context.Background())
+	_assert(errors.As(err, &errs), "expect Run to refuse to serve, but got %v", err)
+}
+
+func TestServer_ValidateListener(t *testing.T) {
+	server := NewServer()
+	server.SetCodecs(codec.GobType)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	_assert(err == nil, "network error: %v", err)
+	defer func() { _ = lis.Close() }()
+	err = server.ServeWithOptions(lis, ListenerOptions{TLSConfig: &tls.Config{}, Codecs: []codec.Type{testCodecType}, IdleTimeout: -1})
+	for _, want := range []string{
+		"not accepted by SetCodecs",
+		"negative IdleTimeout",
+		"TLS config without certificates",
+	} {
+		_assert(err != nil && strings.Contains(err.Error(), want), "expect %q reported, but got %v", want, err)
+	}
+	_assert(server.Validate() == nil, "expect the server itself valid, but got %v", server.Validate())
+}
+
+func TestServer_ValidateLaterRegistration(t *testing.T) {
+	server := NewServer()
+	err := server.Register(&Mixed{})
+	_assert(err != nil, "expect Mixed ambiguous")
+	_assert(server.Validate() != nil, "expect the failed registration reported")
+	_assert(server.Register(&Mixed{}, WithoutMethods("Ping")) == nil, "expect Ping excluded")
+	_assert(server.Validate() == nil, "expect the registration fixed, but got %v", server.Validate())
+}
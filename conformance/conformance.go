@@ -0,0 +1,280 @@
+// Package conformance pins the bytes tinyrpc puts on the wire, for the
+// authors of clients in other languages: for each Vector it records the
+// handshake and a scripted set of calls between a tinyrpc client and
+// server, and it can act as the server of such a recording, checking
+// that another client sends the same bytes, see Verify.
+//
+// A vector file is a sequence of turns, each one the direction (1 byte,
+// '>' from the client and '<' from the server), the length (4 bytes,
+// big-endian) and the bytes one side wrote before the other one
+// answered. The golden files are in testdata, one per vector, named
+// after it with the .vec extension.
+//
+// The gob codec numbers the types it sends in the order a process first
+// encodes them: the bytes are those of a process that encoded nothing in
+// gob before Record, and a client must send the same numbers.
+package conformance
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"tinyrpc"
+	"tinyrpc/codec"
+)
+
+// Direction tells which side wrote a turn.
+type Direction byte
+
+const (
+	ClientToServer Direction = '>'
+	ServerToClient Direction = '<'
+)
+
+// Turn is what one side wrote before the other one answered.
+type Turn struct {
+	Dir   Direction
+	Bytes []byte
+}
+
+// Args are the arguments of Arith.Add and Arith.Div.
+type Args struct{ A, B int }
+
+// Arith is the service the vectors call.
+type Arith int
+
+func (a *Arith) Add(args Args, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+func (a *Arith) Div(args Args, reply *int) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	*reply = args.A / args.B
+	return nil
+}
+
+func (a *Arith) Echo(args string, reply *string) error {
+	*reply = args
+	return nil
+}
+
+// Call is a scripted call, made after the previous one returned.
+type Call struct {
+	ServiceMethod string
+	Args          interface{}
+	Reply         interface{} // a pointer to the zero reply
+}
+
+// Script are the calls of every vector: a first call sending the type
+// definitions, one reusing them, a string, an error of the method and a
+// method not found.
+func Script() []Call {
+	return []Call{
+		{"Arith.Add", Args{A: 1, B: 2}, new(int)},
+		{"Arith.Add", Args{A: 40, B: 2}, new(int)},
+		{"Arith.Echo", "hello", new(string)},
+		{"Arith.Div", Args{A: 1, B: 0}, new(int)},
+		{"Arith.Nope", 0, new(int)},
+	}
+}
+
+// Vector is a combination of options the script is recorded with.
+type Vector struct {
+	Name      string
+	Option    tinyrpc.Option
+	BodyCodec codec.Type // of every call, see tinyrpc.WithBodyCodec
+}
+
+// Vectors are the recorded combinations, in the order Record records them.
+// The connections are in gob, the only connection codec; JSON is a body
+// codec. There is no compression in the protocol.
+var Vectors = []Vector{
+	{Name: "gob", Option: tinyrpc.Option{CodecType: codec.GobType}},
+	{Name: "json", Option: tinyrpc.Option{CodecType: codec.GobType, AllowBodyCodecs: true}, BodyCodec: codec.JsonType},
+	{Name: "checksum", Option: tinyrpc.Option{CodecType: codec.GobType, EnableChecksum: true}},
+}
+
+// DefaultDir is where the golden files are, relative to this package.
+const DefaultDir = "testdata"
+
+var recordMu sync.Mutex // records one vector at a time, in a known gob state
+
+// Record records every vector, in order, by name.
+func Record() (map[string][]Turn, error) {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	defineTypes()
+	vectors := make(map[string][]Turn, len(Vectors))
+	for _, v := range Vectors {
+		turns, err := record(v)
+		if err != nil {
+			return nil, fmt.Errorf("conformance: vector %s: %w", v.Name, err)
+		}
+		vectors[v.Name] = turns
+	}
+	return vectors, nil
+}
+
+var defineOnce sync.Once
+
+// defineTypes makes gob number the types of the script in a known order,
+// whatever the process encoded before: types already numbered keep
+// their numbers, so Record is only reproducible in a process that
+// encoded nothing before.
+func defineTypes() {
+	defineOnce.Do(func() {
+		enc := gob.NewEncoder(io.Discard)
+		for _, v := range []interface{}{codec.Header{}, Args{}, 0, "", []byte(nil)} {
+			_ = enc.Encode(v)
+		}
+	})
+}
+
+// recorder logs the writes of both sides of a connection, in order.
+type recorder struct {
+	mu    sync.Mutex // protect following
+	turns []Turn
+}
+
+func (r *recorder) add(dir Direction, p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n := len(r.turns); n > 0 && r.turns[n-1].Dir == dir {
+		r.turns[n-1].Bytes = append(r.turns[n-1].Bytes, p...)
+		return
+	}
+	r.turns = append(r.turns, Turn{Dir: dir, Bytes: append([]byte(nil), p...)})
+}
+
+// recordedConn logs its writes before making them, so that a write is
+// logged before the peer can answer it.
+type recordedConn struct {
+	net.Conn
+	dir Direction
+	r   *recorder
+}
+
+func (c *recordedConn) Write(p []byte) (int, error) {
+	c.r.add(c.dir, p)
+	return c.Conn.Write(p)
+}
+
+func record(v Vector) ([]Turn, error) {
+	server := tinyrpc.NewServer()
+	if err := server.Register(new(Arith)); err != nil {
+		return nil, err
+	}
+	r := &recorder{}
+	clientConn, serverConn := net.Pipe()
+	served := make(chan struct{})
+	go func() {
+		server.ServeConn(&recordedConn{Conn: serverConn, dir: ServerToClient, r: r})
+		close(served)
+	}()
+	client, err := runScript(&recordedConn{Conn: clientConn, dir: ClientToServer, r: r}, v)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	turns := r.turns
+	r.turns = nil // closing isn't part of the vector
+	r.mu.Unlock()
+	_ = client.Close()
+	<-served
+	return turns, nil
+}
+
+// runScript makes the calls of the script with the options of v, as a
+// client on conn, and returns the client.
+func runScript(conn net.Conn, v Vector) (*tinyrpc.Client, error) {
+	opt := v.Option
+	opt.MagicNumber = tinyrpc.MagicNumber
+	opt.HeartbeatIdle = -1
+	client, err := tinyrpc.NewClient(conn, &opt)
+	if err != nil {
+		return nil, err
+	}
+	var opts []tinyrpc.CallOption
+	if v.BodyCodec != "" {
+		opts = append(opts, tinyrpc.WithBodyCodec(v.BodyCodec))
+	}
+	for _, call := range Script() {
+		// the errors are part of the script
+		_ = client.Call(call.ServiceMethod, call.Args, call.Reply, opts...)
+	}
+	return client, nil
+}
+
+// WriteTurns writes turns in the format of the vector files.
+func WriteTurns(w io.Writer, turns []Turn) error {
+	for _, t := range turns {
+		var head [5]byte
+		head[0] = byte(t.Dir)
+		binary.BigEndian.PutUint32(head[1:], uint32(len(t.Bytes)))
+		if _, err := w.Write(head[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(t.Bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadTurns reads the turns of a vector file.
+func ReadTurns(r io.Reader) ([]Turn, error) {
+	var turns []Turn
+	for {
+		var head [5]byte
+		if _, err := io.ReadFull(r, head[:]); err == io.EOF {
+			return turns, nil
+		} else if err != nil {
+			return nil, err
+		}
+		dir := Direction(head[0])
+		if dir != ClientToServer && dir != ServerToClient {
+			return nil, fmt.Errorf("conformance: bad direction %q", head[0])
+		}
+		t := Turn{Dir: dir, Bytes: make([]byte, binary.BigEndian.Uint32(head[1:]))}
+		if _, err := io.ReadFull(r, t.Bytes); err != nil {
+			return nil, err
+		}
+		turns = append(turns, t)
+	}
+}
+
+// WriteFiles records every vector and writes it to its file in dir.
+func WriteFiles(dir string) error {
+	vectors, err := Record()
+	if err != nil {
+		return err
+	}
+	for name, turns := range vectors {
+		var buf bytes.Buffer
+		_ = WriteTurns(&buf, turns)
+		if err := os.WriteFile(filepath.Join(dir, name+".vec"), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadFile reads the turns of the vector named name in dir.
+func ReadFile(dir, name string) ([]Turn, error) {
+	f, err := os.Open(filepath.Join(dir, name+".vec"))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return ReadTurns(f)
+}
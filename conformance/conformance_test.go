@@ -0,0 +1,103 @@
+package conformance
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+	"tinyrpc"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func TestGolden(t *testing.T) {
+	if *update {
+		if err := WriteFiles(DefaultDir); err != nil {
+			t.Fatal("write error:", err)
+		}
+	}
+	vectors, err := Record()
+	if err != nil {
+		t.Fatal("record error:", err)
+	}
+	for _, v := range Vectors {
+		golden, err := ReadFile(DefaultDir, v.Name)
+		if err != nil {
+			t.Fatalf("%s: %v, run go test -update to write it", v.Name, err)
+		}
+		turns := vectors[v.Name]
+		_assert(len(turns) == len(golden), "%s: expect %d turns, but got %d", v.Name, len(golden), len(turns))
+		for i := range golden {
+			_assert(turns[i].Dir == golden[i].Dir && bytes.Equal(turns[i].Bytes, golden[i].Bytes),
+				"%s: turn %d: the wire format changed\nwant % x\ngot  % x", v.Name, i, golden[i].Bytes, turns[i].Bytes)
+		}
+	}
+	again, _ := Record()
+	var a, b bytes.Buffer
+	_ = WriteTurns(&a, vectors["gob"])
+	_ = WriteTurns(&b, again["gob"])
+	_assert(bytes.Equal(a.Bytes(), b.Bytes()), "expect the same bytes on every recording")
+}
+
+// verify runs the script of v as a client against Verify serving turns.
+func verify(t *testing.T, v Vector, turns []Turn) error {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- Verify(serverConn, turns, time.Second)
+		_ = serverConn.Close()
+	}()
+	client, err := runScript(clientConn, v)
+	if client != nil {
+		_ = client.Close()
+	}
+	_ = err // the handshake fails if the client departs from it
+	return <-done
+}
+
+func TestVerify(t *testing.T) {
+	for _, v := range Vectors {
+		turns, err := ReadFile(DefaultDir, v.Name)
+		if err != nil {
+			t.Fatal("read error:", err)
+		}
+		err = verify(t, v, turns)
+		_assert(err == nil, "%s: expect the client to conform, but got %v", v.Name, err)
+	}
+}
+
+func TestVerify_Mismatch(t *testing.T) {
+	turns, err := ReadFile(DefaultDir, "gob")
+	if err != nil {
+		t.Fatal("read error:", err)
+	}
+	// a client speaking another protocol version
+	v := Vectors[0]
+	v.Option.ProtocolVersion = tinyrpc.ProtocolVersion + 1
+	err = verify(t, v, turns)
+	var m *MismatchError
+	_assert(errors.As(err, &m), "expect a MismatchError, but got %v", err)
+	want := bytes.Index(turns[0].Bytes, []byte(`"ProtocolVersion":`)) + len(`"ProtocolVersion":`)
+	_assert(m.Turn == 0 && m.Offset == int64(want), "expect the mismatch at the version, offset %d, but got %v", want, err)
+	_assert(m.Got[0] == '2' && m.Want[0] == '0', "expect the bytes at the offset, but got %v", err)
+
+	// a client stopping short
+	clientConn, serverConn := net.Pipe()
+	go func() {
+		_, _ = clientConn.Write(turns[0].Bytes[:10])
+		_ = clientConn.Close()
+	}()
+	err = Verify(serverConn, turns, time.Second)
+	_assert(errors.As(err, &m) && m.Offset == 10 && len(m.Got) == 0 && m.Err != nil,
+		"expect the client stopped at offset 10, but got %v", err)
+}
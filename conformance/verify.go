@@ -0,0 +1,112 @@
+package conformance
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// MismatchError tells where a client departed from a vector.
+type MismatchError struct {
+	Turn   int   // index of the turn in the vector
+	Offset int64 // of the first byte that differs, in all the bytes the client sent
+	Want   []byte
+	Got    []byte // from Offset on, as far as read; short if the client stopped
+	Err    error  // of the read that stopped the client, if any
+}
+
+func (e *MismatchError) Error() string {
+	if len(e.Got) < len(e.Want) && e.Err != nil {
+		return fmt.Sprintf("conformance: turn %d: client stopped at offset %d, want % x: %v", e.Turn, e.Offset, e.Want, e.Err)
+	}
+	return fmt.Sprintf("conformance: turn %d: at offset %d, want % x, got % x", e.Turn, e.Offset, e.Want, e.Got)
+}
+
+func (e *MismatchError) Unwrap() error { return e.Err }
+
+// mismatchContext is how many bytes MismatchError shows from the offset.
+const mismatchContext = 16
+
+// Verify serves the vector turns to the client on conn: it checks that
+// the client sends the bytes of each of its turns and answers with the
+// bytes of the turns of the server, until the last turn. It returns a
+// *MismatchError at the first byte the client sends that isn't the one
+// of the vector. If conn is a net.Conn, a client sending nothing for
+// timeout fails, no limit if 0.
+func Verify(conn io.ReadWriter, turns []Turn, timeout time.Duration) error {
+	nc, _ := conn.(net.Conn)
+	var offset int64
+	for i, t := range turns {
+		if t.Dir == ServerToClient {
+			if _, err := conn.Write(t.Bytes); err != nil {
+				return fmt.Errorf("conformance: turn %d: %w", i, err)
+			}
+			continue
+		}
+		got := make([]byte, 0, len(t.Bytes))
+		buf := make([]byte, len(t.Bytes))
+		for len(got) < len(t.Bytes) {
+			if nc != nil && timeout > 0 {
+				_ = nc.SetReadDeadline(time.Now().Add(timeout))
+			}
+			n, err := conn.Read(buf[:len(t.Bytes)-len(got)])
+			start := len(got)
+			got = append(got, buf[:n]...)
+			if j := firstDiff(t.Bytes[start:], got[start:]); j >= 0 {
+				return mismatch(i, offset, t.Bytes, got, start+j, nil)
+			}
+			if err != nil && len(got) < len(t.Bytes) {
+				return mismatch(i, offset, t.Bytes, got, len(got), err)
+			}
+		}
+		offset += int64(len(t.Bytes))
+	}
+	return nil
+}
+
+// firstDiff returns the index of the first byte of got that isn't the
+// one of want, -1 if none.
+func firstDiff(want, got []byte) int {
+	for i := range got {
+		if got[i] != want[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+// mismatch returns the error of a client whose turn i, starting at
+// offset, departs from want at byte at.
+func mismatch(i int, offset int64, want, got []byte, at int, err error) *MismatchError {
+	end := at + mismatchContext
+	if end > len(want) {
+		end = len(want)
+	}
+	gotEnd := at + mismatchContext
+	if gotEnd > len(got) {
+		gotEnd = len(got)
+	}
+	return &MismatchError{
+		Turn:   i,
+		Offset: offset + int64(at),
+		Want:   append([]byte(nil), want[at:end]...),
+		Got:    append([]byte(nil), got[at:gotEnd]...),
+		Err:    err,
+	}
+}
+
+// Serve verifies the clients connecting to lis against turns, one at a
+// time, and passes the result of each one to report. It returns when
+// lis fails to accept.
+func Serve(lis net.Listener, turns []Turn, timeout time.Duration, report func(addr string, err error)) error {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return err
+		}
+		err = Verify(conn, turns, timeout)
+		report(conn.RemoteAddr().String(), err)
+		_ = conn.Close()
+	}
+}
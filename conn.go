@@ -0,0 +1,216 @@
+package tinyrpc
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+	"tinyrpc/codec"
+	"tinyrpc/internal/clock"
+	"tinyrpc/rpclog"
+)
+
+// goAwayMethod is the ServiceMethod of the control frame a server sends
+// before it closes a connection on Shutdown. Clients stop issuing new calls
+// on that connection and reconnect elsewhere; older clients ignore it,
+// because its Seq 0 matches no call.
+const goAwayMethod = "_ctrl_.GoAway"
+
+// cancelMethod is the ServiceMethod of the frame a client sends when it
+// abandons the call with the same Seq. Older servers answer it with an
+// unknown service error, which the client discards.
+const cancelMethod = "_ctrl_.Cancel"
+
+// SetFirstRequestTimeout closes the connections that send no request
+// within d of their handshake, so that clients that connect and then hang
+// don't hold them open forever. No limit if 0, the default. It must be
+// called before the server starts serving.
+func (server *Server) SetFirstRequestTimeout(d time.Duration) {
+	server.firstRequestTimeout = d
+}
+
+// awaitFirstRequest closes sc if the function it returns is not called
+// within the first-request timeout, counting it in Stats.
+func (server *Server) awaitFirstRequest(sc *serverConn) func() {
+	d := server.firstRequestTimeout
+	if d <= 0 {
+		return func() {}
+	}
+	var state int32 // 1 once a request is read, 2 once timed out
+	received := make(chan struct{})
+	t := clock.Or(server.clock).NewTimer(d)
+	go func() {
+		select {
+		case <-t.C():
+			if atomic.CompareAndSwapInt32(&state, 0, 2) {
+				atomic.AddUint64(&server.handshakeOnly, 1)
+				server.log(rpclog.LevelInfo, "no request after the handshake, closing connection", "conn", sc.id)
+				_ = sc.cc.Close()
+			}
+		case <-received:
+			t.Stop()
+		}
+	}()
+	return func() {
+		if atomic.CompareAndSwapInt32(&state, 0, 1) {
+			close(received)
+		}
+	}
+}
+
+// connKey is the context key of the serverConn a request arrived on.
+type connKey struct{}
+
+// serverConn is the state of a connection past the handshake.
+type serverConn struct {
+	id              uint64
+	conn            io.Closer
+	metered         *meteredConn
+	connectedAt     time.Time
+	cc              codec.Codec
+	bulk            codec.Codec // of the bulk channel, nil without Option.BulkChannel
+	codecType       codec.Type
+	version         int             // of the protocol, 0 if served by ServeCodec
+	timeout         time.Duration   // Option.HandleTimeout of the client
+	ordered         bool            // Option.OrderedResponses of the client
+	authToken       string          // Option.AuthToken of the client
+	ip              string          // remote IP, see SetIPLimits
+	stream          *limitedConn    // read by cc, nil if served by ServeCodec
+	maxSeq          uint64          // highest request Seq, seen by StrictProtocol
+	bodyCodecs      bool            // Option.AllowBodyCodecs of the client
+	maxBody         int64           // of the body being read, no limit if 0
+	listenerMaxBody int64           // MaxBodySize of the listener, no limit if 0
+	idleTimeout     time.Duration   // of the server and the listener, never if 0
+	coalesced       *coalescingConn // nil unless ServerConfig.WriteCoalescing
+	history         *frameHistory   // nil unless ServerConfig.FrameHistory
+	state           *ConnState      // nil if served by ServeCodec
+
+	mu       sync.Mutex // protect following
+	inflight int
+	draining bool
+	goneAway bool                    // the GoAway frame is written, see goAway
+	ctxDone  bool                    // the context of ServeConnContext is done
+	push     *pushQueue              // nil until the first subscription
+	requests map[uint64]*callContext // of the requests being handled
+
+	unsent, unsentHigh int        // responses ready but not written, see ResponseLimits
+	unsentCond         *sync.Cond // signalled when unsent drops
+	closed             bool       // by the response limits
+	turn, lastTurn     uint64     // of the response written last and taken last, if ordered
+	lastActive         time.Time  // when a request began or ended, see IdleTimeout
+}
+
+// writer returns the codec writing the frames of req: the one of the
+// bulk channel for bulk services, if the connection has one.
+func (sc *serverConn) writer(req *request) codec.Codec {
+	if sc.bulk != nil && req.svc != nil && req.svc.config.bulk {
+		return sc.bulk
+	}
+	return sc.cc
+}
+
+// track records ctx as the context of the request seq, cancelled when a
+// cancel frame for seq arrives, or at once if the context of sc is done.
+func (sc *serverConn) track(seq uint64, ctx *callContext) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.requests == nil {
+		sc.requests = make(map[uint64]*callContext)
+	}
+	sc.requests[seq] = ctx
+	if sc.ctxDone {
+		ctx.cancel()
+	}
+}
+
+// inFlight reports whether the request seq is being handled.
+func (sc *serverConn) inFlight(seq uint64) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.requests[seq] != nil
+}
+
+// untrack forgets the request seq and reports whether the client cancelled it.
+func (sc *serverConn) untrack(seq uint64) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	r := sc.requests[seq]
+	delete(sc.requests, seq)
+	if r == nil {
+		return false
+	}
+	r.cancel()
+	return r.canceled
+}
+
+// cancelRequest cancels the context of the request seq, if it is still
+// being handled. Its response won't be sent.
+func (sc *serverConn) cancelRequest(seq uint64) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if r := sc.requests[seq]; r != nil {
+		r.canceled = true
+		r.cancel()
+	}
+}
+
+func (sc *serverConn) begin(now time.Time) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.inflight++
+	sc.lastActive = now
+}
+
+func (sc *serverConn) end(now time.Time) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.inflight--
+	sc.lastActive = now
+	if sc.goneAway && sc.inflight == 0 {
+		_ = sc.cc.Close()
+	}
+}
+
+// goAway tells the client to stop using the connection, and closes it
+// as soon as no request is in flight. reason, if any, is sent along.
+// The frame is written by its own goroutine, without sc.mu held, so that
+// a client that stops reading blocks neither the calls of sc nor the
+// caller; closing the connection ends the write.
+func (sc *serverConn) goAway(server *Server, reason string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.draining {
+		return
+	}
+	sc.draining = true
+	h := &codec.Header{ServiceMethod: goAwayMethod}
+	if reason != "" {
+		h.Metadata = map[string]string{"reason": reason}
+	}
+	go func() {
+		server.sendResponse(sc.cc, h, invalidRequest)
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+		sc.goneAway = true
+		if sc.inflight == 0 {
+			_ = sc.cc.Close()
+		}
+	}()
+}
+
+func (sc *serverConn) info() ConnInfo {
+	sc.mu.Lock()
+	unsentHigh, inflight := sc.unsentHigh, sc.inflight
+	sc.mu.Unlock()
+	return ConnInfo{
+		ID:           sc.id,
+		RemoteAddr:   remoteAddr(sc.metered.ReadWriteCloser),
+		ConnectedAt:  sc.connectedAt,
+		BytesRead:    atomic.LoadUint64(&sc.metered.bytesRead),
+		BytesWritten: atomic.LoadUint64(&sc.metered.bytesWrote),
+		UnsentHigh:   unsentHigh,
+		InFlight:     inflight,
+		Codec:        sc.codecType,
+		Version:      sc.version,
+	}
+}
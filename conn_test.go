@@ -0,0 +1,119 @@
+package tinyrpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+	"tinyrpc/tinyrpctest"
+)
+
+type Slow int
+
+func (s Slow) Sleep(ms int, reply *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+func TestShutdown_GoAway(t *testing.T) {
+	// a rolling restart: old is shut down while a call is in flight,
+	// the client drains it and moves to next
+	old, next := NewServer(), NewServer()
+	var slow Slow
+	_ = old.Register(&slow)
+	_ = next.Register(&slow)
+	oldLis := startServer(t, old)
+	nextLis := startServer(t, next)
+
+	client, err := Dial("tcp", oldLis.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+	var slowReply int
+	call := client.Go("Slow.Sleep", 200, &slowReply, nil)
+	time.Sleep(50 * time.Millisecond) // let the call reach the server
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- old.Shutdown(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+	_assert(!client.IsAvailable(), "expect the client to be unavailable after GoAway")
+	var reply int
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == ErrGoAway, "expect ErrGoAway for a new call, but got %v", err)
+
+	<-call.Done
+	_assert(call.Error == nil && slowReply == 200, "expect the in-flight call to finish, but got %v", call.Error)
+	_assert(<-shutdown == nil, "expect a clean shutdown")
+
+	client, err = Dial("tcp", nextLis.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call the next server: %v", err)
+}
+
+func TestShutdown_ClientNotReading(t *testing.T) {
+	server := NewServer()
+	l := newPipeListener()
+	go server.Accept(l) // closed by Shutdown
+
+	// a client that handshakes, then never reads: the pipe blocks every
+	// write of the server
+	conn, err := l.DialContext(context.Background(), "pipe", "")
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = conn.Close() }()
+	_ = json.NewEncoder(conn).Encode(DefaultOption) // answered by no reply
+	waitFor(t, func() bool { return server.Stats().Connections == 1 }, "expect the connection served")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(ctx) }()
+	select {
+	case err := <-shutdown:
+		_assert(err == context.DeadlineExceeded, "expect the connections closed forcibly, but got %v", err)
+	case <-time.After(time.Second):
+		_assert(false, "expect Shutdown to return by its deadline")
+	}
+}
+
+func TestServer_FirstRequestTimeout(t *testing.T) {
+	server := NewServer()
+	clock := tinyrpctest.NewClock()
+	server.SetClock(clock)
+	server.SetFirstRequestTimeout(10 * time.Second)
+	addr := startServer(t, server).Addr().String()
+
+	// a client that handshakes, then sleeps
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = json.NewEncoder(conn).Encode(DefaultOption)
+	clock.BlockUntil(1) // its first-request timer
+	// a client that makes a request in time
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1})
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+	var reply int
+	_assert(client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply) == nil, "failed to call Foo.Sum")
+
+	for clock.Waiters() > 1 {
+		time.Sleep(time.Millisecond) // the timer of the active client is stopped
+	}
+	clock.Advance(10 * time.Second)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	_assert(err == io.EOF, "expect the sleeping client disconnected, but got %v", err)
+	_assert(server.Stats().HandshakeOnly == 1, "expect 1 handshake-only connection, but got %d", server.Stats().HandshakeOnly)
+	_assert(client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply) == nil, "expect the active client still connected")
+}
@@ -0,0 +1,52 @@
+package tinyrpc
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"time"
+	"tinyrpc/codec"
+)
+
+// ConnState is what the two sides of a connection agreed on in the
+// handshake, see Client.ConnState and ConnStateFromContext.
+type ConnState struct {
+	Codec      codec.Type // of the frames, after any fallback
+	BodyCodecs bool       // bodies may be in other codecs, see Option.AllowBodyCodecs
+	Version    int        // of the protocol, see Option.ProtocolVersion
+	Checksum   bool       // frames are checksummed, see Option.EnableChecksum
+	Bulk       bool       // bulk frames have a channel, see Option.BulkChannel
+	// EncryptionKeyID is the key the stream is encrypted with, empty if
+	// it is not, see Option.EncryptionKeyID.
+	EncryptionKeyID string
+	TLS             *tls.ConnectionState // nil if the conn is not TLS
+	RemoteAddr      string               // of the peer, empty if the conn has no address
+	// HandshakeDuration is the time from the options sent, or the
+	// connection accepted, to the codec stream set up.
+	HandshakeDuration time.Duration
+}
+
+// tlsState returns the state of conn if it is TLS, past its handshake.
+func tlsState(conn io.ReadWriteCloser) *tls.ConnectionState {
+	if c, ok := conn.(*tls.Conn); ok {
+		cs := c.ConnectionState()
+		return &cs
+	}
+	return nil
+}
+
+// ConnState returns what the client agreed on with the server. Clients
+// made with NewClientWithCodec report their options.
+func (client *Client) ConnState() ConnState {
+	return client.state
+}
+
+// ConnStateFromContext returns what the connection of the call handled
+// with ctx agreed on, false outside a handler or for ServeCodec.
+func ConnStateFromContext(ctx context.Context) (ConnState, bool) {
+	sc, ok := ctx.Value(connKey{}).(*serverConn)
+	if !ok || sc.state == nil {
+		return ConnState{}, false
+	}
+	return *sc.state, true
+}
@@ -0,0 +1,93 @@
+package tinyrpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+	"tinyrpc/codec"
+)
+
+// selfSigned returns a certificate for 127.0.0.1, signed by its own key.
+func selfSigned(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_assert(err == nil, "key error: %v", err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	_assert(err == nil, "certificate error: %v", err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestConnState(t *testing.T) {
+	key := bytes.Repeat([]byte{4}, 32)
+	states := make(chan ConnState, 1)
+	server := NewServer(
+		WithEncryptionKeys(map[string][]byte{"k": key}),
+		WithInterceptors(func(ctx context.Context, serviceMethod string, args interface{}, next func(ctx context.Context) error) error {
+			state, ok := ConnStateFromContext(ctx)
+			_assert(ok, "expect the state of the conn in a handler")
+			states <- state
+			return next(ctx)
+		}),
+	)
+	server.SetCodecs(codec.GobType)
+	var foo Foo
+	_ = server.Register(&foo)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	_assert(err == nil, "listen error: %v", err)
+	defer func() { _ = lis.Close() }()
+	go func() {
+		_ = server.ServeWithOptions(lis, ListenerOptions{TLSConfig: &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}}})
+	}()
+
+	conn, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	_assert(err == nil, "dial error: %v", err)
+	client, err := NewClient(conn, &Option{
+		MagicNumber:        MagicNumber,
+		CodecType:          testCodecType,
+		FallbackCodecs:     []codec.Type{codec.GobType},
+		HeartbeatIdle:      -1,
+		AllowBodyCodecs:    true,
+		EnableChecksum:     true,
+		EncryptionKeyID:    "k",
+		EncryptionKey:      key,
+		AllowCodecFallback: true,
+	})
+	_assert(err == nil, "new client error: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	_assert(client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply) == nil, "failed to call Foo.Sum")
+
+	cs, ss := client.ConnState(), <-states
+	for side, s := range map[string]ConnState{"client": cs, "server": ss} {
+		_assert(s.Codec == codec.GobType && s.BodyCodecs && s.Version == ProtocolVersion && s.Checksum && s.EncryptionKeyID == "k",
+			"expect the %s to report the options dialed with, but got %+v", side, s)
+		_assert(s.TLS != nil && s.TLS.HandshakeComplete && s.TLS.Version == cs.TLS.Version,
+			"expect the %s to report TLS, but got %+v", side, s.TLS)
+		_assert(s.HandshakeDuration > 0, "expect the %s to time the handshake", side)
+	}
+	_assert(cs.RemoteAddr == lis.Addr().String(), "expect the client to report the server address, but got %q", cs.RemoteAddr)
+	_assert(ss.RemoteAddr == conn.LocalAddr().String(), "expect the server to report the client address, but got %q", ss.RemoteAddr)
+
+	_, ok := ConnStateFromContext(context.Background())
+	_assert(!ok, "expect no conn state outside a handler")
+	plain, err := Dial("tcp", startServer(t, NewServer()).Addr().String(), &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = plain.Close() }()
+	s := plain.ConnState()
+	_assert(s.Codec == codec.GobType && !s.Checksum && s.EncryptionKeyID == "" && s.TLS == nil && s.Version == 1,
+		"expect a plain conn, but got %+v", s)
+}
@@ -0,0 +1,105 @@
+package tinyrpc
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+	"tinyrpc/codec"
+)
+
+// badLength is a gob message length of 2^64-1: the stream is lost.
+var badLength = []byte{0xf8, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// serveStream serves the requests of stream and returns the responses.
+func serveStream(server *Server, stream []byte) []codec.Header {
+	var out bytes.Buffer
+	server.ServeCodec(codec.NewGobCodec(memConn{Reader: bytes.NewReader(stream), Writer: &out}))
+	cc := codec.NewGobCodec(memConn{Reader: bytes.NewReader(out.Bytes()), Writer: io.Discard})
+	var hs []codec.Header
+	for {
+		var h codec.Header
+		if cc.ReadHeader(&h) != nil || cc.ReadBody(nil) != nil {
+			return hs
+		}
+		hs = append(hs, h)
+	}
+}
+
+func TestServer_RecoverableDecodeError(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	var stream bytes.Buffer
+	enc := gob.NewEncoder(&stream)
+	_ = enc.Encode(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1})
+	_ = enc.Encode(Args{Num1: 1, Num2: 2})
+	_ = enc.Encode(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 2})
+	_ = enc.Encode("not args")
+	_ = enc.Encode(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 3})
+	_ = enc.Encode(Args{Num1: 3, Num2: 4})
+
+	hs := serveStream(server, stream.Bytes())
+	_assert(len(hs) == 3, "expect every request answered, but got %+v", hs)
+	for _, h := range hs {
+		_assert((h.Seq == 2) == (h.Error != ""), "expect only the mismatched request to fail, but got %+v", h)
+	}
+	_assert(server.Stats().CorruptedStreams == 0, "expect no corrupted stream")
+}
+
+func TestServer_QuarantinesCorruptStream(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	var stream bytes.Buffer
+	enc := gob.NewEncoder(&stream)
+	_ = enc.Encode(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1})
+	_ = enc.Encode(Args{Num1: 1, Num2: 2})
+	_ = enc.Encode(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 2})
+	_, _ = stream.Write(badLength)
+	_ = enc.Encode(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 3})
+	_ = enc.Encode(Args{Num1: 3, Num2: 4})
+
+	hs := serveStream(server, stream.Bytes())
+	var conn []codec.Header
+	for _, h := range hs {
+		_assert(h.Seq != 3, "expect nothing served after the corruption, but got %+v", h)
+		if h.Seq == 0 {
+			conn = append(conn, h)
+		}
+	}
+	_assert(len(conn) == 1 && errors.Is(newServerError(conn[0].Error, conn[0].Code), ErrCorrupted),
+		"expect one notice of the corruption, but got %+v", conn)
+	_assert(server.Stats().CorruptedStreams == 1, "expect 1 corrupted stream, but got %d", server.Stats().CorruptedStreams)
+}
+
+func TestClient_CorruptReply(t *testing.T) {
+	conn, peer := net.Pipe()
+	go func() {
+		var opt Option
+		_ = json.NewDecoder(peer).Decode(&opt)
+		go func() { _, _ = io.Copy(io.Discard, peer) }()
+		time.Sleep(50 * time.Millisecond) // the calls are sent
+		_ = gob.NewEncoder(peer).Encode(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1})
+		_, _ = peer.Write(badLength)
+	}()
+	client, err := NewClient(conn, &Option{MagicNumber: MagicNumber, CodecType: codec.GobType, HeartbeatIdle: -1})
+	_assert(err == nil, "client error: %v", err)
+	defer func() { _ = client.Close() }()
+	first := client.Go("Foo.Sum", Args{Num1: 1, Num2: 2}, new(int), nil)
+	pending := client.Go("Foo.Sum", Args{Num1: 3, Num2: 4}, new(int), nil)
+
+	for _, call := range []*Call{first, pending} {
+		select {
+		case call = <-call.Done:
+			_assert(errors.Is(call.Error, ErrCorrupted), "expect ErrCorrupted, but got %v", call.Error)
+		case <-time.After(time.Second):
+			t.Fatal("expect the calls failed with the stream")
+		}
+	}
+	_assert(!client.IsAvailable(), "expect the connection quarantined")
+}
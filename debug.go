@@ -0,0 +1,195 @@
+package tinyrpc
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strings"
+)
+
+// DefaultDebugPrefix is where HandleDebug mounts the debug endpoints if
+// its prefix is empty.
+const DefaultDebugPrefix = "/debug"
+
+// errNoCredentials makes the debug endpoints ask for basic auth.
+var errNoCredentials = fmt.Errorf("%w: no credentials", ErrPermissionDenied)
+
+// BasicAuth returns a ServerConfig.DebugAuth accepting the requests
+// with user and password as their basic auth.
+func BasicAuth(user, password string) func(r *http.Request) error {
+	return func(r *http.Request) error {
+		u, p, ok := r.BasicAuth()
+		if !ok {
+			return errNoCredentials
+		}
+		if subtle.ConstantTimeCompare([]byte(u), []byte(user)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+			return fmt.Errorf("%w: invalid credentials", ErrPermissionDenied)
+		}
+		return nil
+	}
+}
+
+// HandleDebug mounts the debug endpoints of the server on mux under
+// prefix, DefaultDebugPrefix if empty:
+//
+//	prefix/vars      the expvar variables and the server stats, in JSON
+//	prefix/pprof/    the profiles of net/http/pprof
+//	prefix/services  the registered services and their call counts
+//	prefix/healthz   200 while the process is up, for liveness probes
+//	prefix/readyz    200 while the server is ready, 503 if not, see Ready
+//
+// They are served to the requests accepted by ServerConfig.DebugAuth,
+// to any request if it is nil, except for the probes served to all.
+func (server *Server) HandleDebug(mux *http.ServeMux, prefix string) {
+	if prefix == "" {
+		prefix = DefaultDebugPrefix
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	mux.Handle(prefix+"/vars", server.debugAuth(http.HandlerFunc(server.serveVars)))
+	mux.Handle(prefix+"/pprof/", server.debugAuth(http.StripPrefix(prefix+"/pprof/", http.HandlerFunc(servePprof))))
+	mux.Handle(prefix+"/services", server.debugAuth(http.HandlerFunc(server.serveServices)))
+	mux.HandleFunc(prefix+"/healthz", serveHealthz)
+	mux.HandleFunc(prefix+"/readyz", server.serveReadyz)
+}
+
+// debugAuth serves h to the requests accepted by ServerConfig.DebugAuth.
+func (server *Server) debugAuth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		authorize := server.config.DebugAuth
+		server.mu.Unlock()
+		if authorize != nil {
+			if err := authorize(r); err != nil {
+				if errors.Is(err, errNoCredentials) {
+					w.Header().Set("WWW-Authenticate", `Basic realm="tinyrpc debug"`)
+					http.Error(w, err.Error(), http.StatusUnauthorized)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serveVars writes the expvar variables like expvar.Handler, along with
+// the server stats under "tinyrpc".
+func (server *Server) serveVars(w http.ResponseWriter, r *http.Request) {
+	stats, err := json.Marshal(server.debugVars())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n")
+	expvar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "%q: %s,\n", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "%q: %s\n}\n", "tinyrpc", stats)
+}
+
+// debugVars are the server stats by their metric names. The names are
+// kept stable, whatever the fields of ServerStats are called.
+func (server *Server) debugVars() map[string]interface{} {
+	s := server.Stats()
+	return map[string]interface{}{
+		"connections":       s.Connections,
+		"bytes_read":        s.BytesRead,
+		"bytes_written":     s.BytesWritten,
+		"requests":          s.Requests,
+		"in_flight":         s.InFlight,
+		"invalid_requests":  s.Invalid,
+		"not_found":         s.NotFound,
+		"handshake_only":    s.HandshakeOnly,
+		"slow_dropped":      s.SlowDropped,
+		"corrupted_streams": s.CorruptedStreams,
+		"push_dropped":      s.PushDropped,
+		"protocol_anomalies": map[string]uint64{
+			"unknown_options":     s.ProtocolAnomalies.UnknownOptions,
+			"oversized_handshake": s.ProtocolAnomalies.OversizedHandshake,
+			"oversized_metadata":  s.ProtocolAnomalies.OversizedMetadata,
+			"invalid_seq":         s.ProtocolAnomalies.InvalidSeq,
+		},
+		"method_body_sizes": s.Sizes,
+	}
+}
+
+// servePprof serves the pprof profile named by the path, stripped of its
+// prefix. pprof.Index expects to be served at /debug/pprof/.
+func servePprof(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "profile":
+		pprof.Profile(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	case "trace":
+		pprof.Trace(w, r)
+	default:
+		r.URL.Path = "/debug/pprof/" + r.URL.Path
+		pprof.Index(w, r)
+	}
+}
+
+var debugServices = template.Must(template.New("services").Parse(`<html>
+<head><title>tinyrpc services</title></head>
+<body>
+{{range .}}
+<hr>
+Service {{.Name}}
+<hr>
+<table>
+<th align=center>Method</th><th align=center>Calls</th>
+{{range .Methods}}
+<tr>
+<td align=left font=fixed>{{.Name}}({{.ArgType}}, {{.ReplyType}}) error</td>
+<td align=center>{{.Calls}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>`))
+
+type debugService struct {
+	Name    string
+	Methods []debugMethod
+}
+
+type debugMethod struct {
+	Name, ArgType, ReplyType string
+	Calls                    uint64
+}
+
+// serveServices lists the registered services and their methods, sorted.
+func (server *Server) serveServices(w http.ResponseWriter, r *http.Request) {
+	var services []debugService
+	server.serviceMap.Range(func(_, v interface{}) bool {
+		s := v.(*service)
+		ds := debugService{Name: s.name}
+		for name, m := range s.method {
+			ds.Methods = append(ds.Methods, debugMethod{
+				Name:      name,
+				ArgType:   m.ArgType.String(),
+				ReplyType: m.ReplyType.String(),
+				Calls:     m.NumCalls(),
+			})
+		}
+		sort.Slice(ds.Methods, func(i, j int) bool { return ds.Methods[i].Name < ds.Methods[j].Name })
+		services = append(services, ds)
+		return true
+	})
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	if err := debugServices.Execute(w, services); err != nil {
+		fmt.Fprintln(w, "rpc: error executing template:", err)
+	}
+}
@@ -0,0 +1,69 @@
+package tinyrpc
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer_HandleDebug(t *testing.T) {
+	server := NewServer(WithDebugAuth(BasicAuth("admin", "secret")))
+	addr := startServer(t, server).Addr().String()
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	_assert(client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply) == nil, "failed to call Foo.Sum")
+
+	mux := http.NewServeMux()
+	server.HandleDebug(mux, "/rpc/debug/")
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	get := func(path, user, password string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		_assert(err == nil, "request error: %v", err)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		_assert(err == nil, "get %s error: %v", path, err)
+		defer func() { _ = resp.Body.Close() }()
+		body, err := io.ReadAll(resp.Body)
+		_assert(err == nil, "read %s error: %v", path, err)
+		return resp, string(body)
+	}
+
+	for _, path := range []string{"/rpc/debug/vars", "/rpc/debug/pprof/", "/rpc/debug/services"} {
+		resp, _ := get(path, "", "")
+		_assert(resp.StatusCode == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") != "",
+			"expect %s to ask for credentials, but got %s", path, resp.Status)
+		resp, _ = get(path, "admin", "wrong")
+		_assert(resp.StatusCode == http.StatusForbidden, "expect %s forbidden, but got %s", path, resp.Status)
+	}
+
+	resp, body := get("/rpc/debug/vars", "admin", "secret")
+	_assert(resp.StatusCode == http.StatusOK, "expect vars, but got %s", resp.Status)
+	var vars struct {
+		Memstats map[string]interface{}
+		Tinyrpc  map[string]json.RawMessage `json:"tinyrpc"`
+	}
+	_assert(json.Unmarshal([]byte(body), &vars) == nil, "expect vars in JSON, but got %s", body)
+	_assert(vars.Memstats != nil, "expect the expvar memstats, but got %s", body)
+	for _, name := range []string{"connections", "bytes_read", "bytes_written", "requests", "in_flight", "method_body_sizes"} {
+		_, ok := vars.Tinyrpc[name]
+		_assert(ok, "expect tinyrpc.%s, but got %s", name, body)
+	}
+	var requests uint64
+	_assert(json.Unmarshal(vars.Tinyrpc["requests"], &requests) == nil && requests >= 1, "expect requests counted, but got %s", vars.Tinyrpc["requests"])
+
+	resp, body = get("/rpc/debug/pprof/", "admin", "secret")
+	_assert(resp.StatusCode == http.StatusOK && strings.Contains(body, "goroutine"), "expect the pprof index, but got %s", resp.Status)
+	resp, body = get("/rpc/debug/pprof/goroutine?debug=1", "admin", "secret")
+	_assert(resp.StatusCode == http.StatusOK && strings.Contains(body, "goroutine profile"), "expect the goroutine profile, but got %s", resp.Status)
+	resp, body = get("/rpc/debug/services", "admin", "secret")
+	_assert(resp.StatusCode == http.StatusOK && strings.Contains(body, "Service Foo") && strings.Contains(body, "Sum("),
+		"expect Foo.Sum listed, but got %s", body)
+}
@@ -0,0 +1,277 @@
+package tinyrpc
+
+import (
+	"container/list"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"tinyrpc/codec"
+)
+
+// AtMostOnceHeader is the metadata key of the ID of a call made by a
+// client WithAtMostOnce: its client ID and its sequence number, as
+// "clientID/seq".
+const AtMostOnceHeader = "tinyrpc-call-id"
+
+// Defaults of DedupConfig.
+const (
+	DefaultDedupWindow     = 1024
+	DefaultDedupTTL        = 10 * time.Minute
+	DefaultDedupMaxEntries = 100000
+	DefaultDedupMaxBytes   = 64 << 20
+)
+
+// atMostOnce is the identity of the clients made WithAtMostOnce.
+type atMostOnce struct {
+	clientID string
+}
+
+// callSeq numbers the calls of the process, see AtMostOnceContext.
+var callSeq uint64
+
+type callSeqKey struct{}
+
+// WithAtMostOnce sends an ID with every call, of clientID and a sequence
+// number, that the calls retrying it reuse, so that a server deduplicating
+// calls (see WithDedupWindow) executes it at most once. The ID survives
+// reconnects: the clients dialed with the same option share clientID, a
+// random one if empty, e.g. those of an XClient. Retries made with
+// CallContext or XClient.Call reuse the ID of the first attempt, see
+// AtMostOnceContext.
+func WithAtMostOnce(clientID string) ClientOption {
+	if clientID == "" {
+		b := make([]byte, 16)
+		_, _ = rand.Read(b)
+		clientID = hex.EncodeToString(b)
+	}
+	ids := &atMostOnce{clientID: clientID}
+	return clientOptionFunc(func(c *clientConfig) { c.atMostOnce = ids })
+}
+
+// AtMostOnceContext returns ctx with a new sequence number for the calls
+// of the clients WithAtMostOnce made with it, so that they are all one
+// call to the servers, e.g. to retry a call on a new connection. It
+// returns ctx as is if it has one already.
+func AtMostOnceContext(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(callSeqKey{}).(uint64); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, callSeqKey{}, nextCallSeq())
+}
+
+func nextCallSeq() uint64 {
+	return atomic.AddUint64(&callSeq, 1)
+}
+
+// callID returns the ID of the call of seq.
+func (ids *atMostOnce) callID(seq uint64) string {
+	return ids.clientID + "/" + strconv.FormatUint(seq, 10)
+}
+
+// DedupConfig bounds the calls a server remembers with WithDedupWindow.
+// Zero fields take the defaults.
+type DedupConfig struct {
+	// Window is the number of calls remembered per client, the oldest
+	// ones forgotten first. DefaultDedupWindow if 0.
+	Window int
+	// TTL is how long a call is remembered after it arrived.
+	// DefaultDedupTTL if 0.
+	TTL time.Duration
+	// MaxEntries bounds the calls remembered over all the clients.
+	// DefaultDedupMaxEntries if 0.
+	MaxEntries int
+	// MaxBytes bounds the replies kept, in their gob encoding. The calls
+	// whose reply isn't kept are still remembered, their retries fail
+	// with ErrAlreadyExecuted. DefaultDedupMaxBytes if 0.
+	MaxBytes int
+}
+
+// WithDedupWindow sets ServerConfig.AtMostOnce, with cfg.
+func WithDedupWindow(cfg DedupConfig) ServerOption {
+	return func(c *ServerConfig) error {
+		if cfg.Window < 0 || cfg.TTL < 0 || cfg.MaxEntries < 0 || cfg.MaxBytes < 0 {
+			return errors.New("rpc server: negative dedup limit")
+		}
+		c.AtMostOnce, c.Dedup = true, cfg
+		return nil
+	}
+}
+
+// dedupKey is the ID of a call, see AtMostOnceHeader.
+type dedupKey struct {
+	client string
+	seq    uint64
+}
+
+// parseCallID parses the value of AtMostOnceHeader.
+func parseCallID(id string) (dedupKey, bool) {
+	i := strings.LastIndexByte(id, '/')
+	if i <= 0 {
+		return dedupKey{}, false
+	}
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	return dedupKey{id[:i], seq}, err == nil
+}
+
+// dedupEntry is a call remembered, with its result once done.
+type dedupEntry struct {
+	key     dedupKey
+	arrived time.Time
+	done    chan struct{} // closed once the result is set
+	global  *list.Element // in dedupWindow.order
+	local   *list.Element // in dedupWindow.clients
+
+	// the result, set before done is closed
+	data        []byte // the reply in gob
+	nilReply    bool
+	err         error
+	unavailable bool
+}
+
+// dedupWindow is the calls a server remembers, see ServerConfig.AtMostOnce.
+type dedupWindow struct {
+	cfg DedupConfig
+	now func() time.Time
+
+	mu       sync.Mutex // protect following
+	entries  map[dedupKey]*dedupEntry
+	clients  map[string]*list.List // of the entries of each client, oldest first
+	order    list.List             // of all the entries, oldest first
+	bytes    int
+	replayed uint64 // accessed atomically
+}
+
+func newDedupWindow(cfg DedupConfig, now func() time.Time) *dedupWindow {
+	if cfg.Window == 0 {
+		cfg.Window = DefaultDedupWindow
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = DefaultDedupTTL
+	}
+	if cfg.MaxEntries == 0 {
+		cfg.MaxEntries = DefaultDedupMaxEntries
+	}
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = DefaultDedupMaxBytes
+	}
+	return &dedupWindow{
+		cfg:     cfg,
+		now:     now,
+		entries: make(map[dedupKey]*dedupEntry),
+		clients: make(map[string]*list.List),
+	}
+}
+
+// do calls call for req, unless the call of key was remembered: its
+// result is then waited for and given to req instead.
+func (w *dedupWindow) do(ctx context.Context, key dedupKey, req *request, call func() error) error {
+	e, first := w.begin(key)
+	if first {
+		err := call()
+		w.finish(e, req, err)
+		return err
+	}
+	atomic.AddUint64(&w.replayed, 1)
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	switch {
+	case e.unavailable:
+		return ErrAlreadyExecuted
+	case e.err != nil:
+		return e.err
+	case e.nilReply:
+		return nil // the reply of req is nil already
+	}
+	return codec.BodyCodecs[codec.GobType].Unmarshal(e.data, req.replyv.Interface())
+}
+
+// begin returns the entry of key, and whether it is a new one.
+func (w *dedupWindow) begin(key dedupKey) (*dedupEntry, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	w.expire(now)
+	if e := w.entries[key]; e != nil {
+		return e, false
+	}
+	e := &dedupEntry{key: key, arrived: now, done: make(chan struct{})}
+	calls := w.clients[key.client]
+	if calls == nil {
+		calls = list.New()
+		w.clients[key.client] = calls
+	}
+	e.global, e.local = w.order.PushBack(e), calls.PushBack(e)
+	w.entries[key] = e
+	if calls.Len() > w.cfg.Window {
+		w.remove(calls.Front().Value.(*dedupEntry))
+	}
+	for len(w.entries) > w.cfg.MaxEntries {
+		w.remove(w.order.Front().Value.(*dedupEntry))
+	}
+	return e, true
+}
+
+// finish sets the result of e, the call of req that returned err. The
+// replies that don't encode, or past MaxBytes, aren't kept, nor the
+// results of the calls given up on, which may still run.
+func (w *dedupWindow) finish(e *dedupEntry, req *request, err error) {
+	var data []byte
+	var nilReply, unavailable bool
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		unavailable = true
+	case err == nil:
+		var body interface{}
+		if body, nilReply = req.mtype.replyBody(req.replyv); !nilReply {
+			var merr error
+			data, merr = codec.BodyCodecs[codec.GobType].Marshal(body)
+			unavailable = merr != nil || len(data) > w.cfg.MaxBytes
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if unavailable {
+		data = nil
+	}
+	e.data, e.nilReply, e.err, e.unavailable = data, nilReply, err, unavailable
+	close(e.done)
+	if w.entries[e.key] != e {
+		return // forgotten meanwhile
+	}
+	w.bytes += len(data)
+	for w.bytes > w.cfg.MaxBytes {
+		w.remove(w.order.Front().Value.(*dedupEntry))
+	}
+}
+
+// expire forgets the calls older than the TTL. w.mu must be held.
+func (w *dedupWindow) expire(now time.Time) {
+	for front := w.order.Front(); front != nil; front = w.order.Front() {
+		e := front.Value.(*dedupEntry)
+		if now.Sub(e.arrived) < w.cfg.TTL {
+			return
+		}
+		w.remove(e)
+	}
+}
+
+// remove forgets e. w.mu must be held.
+func (w *dedupWindow) remove(e *dedupEntry) {
+	delete(w.entries, e.key)
+	w.order.Remove(e.global)
+	calls := w.clients[e.key.client]
+	calls.Remove(e.local)
+	if calls.Len() == 0 {
+		delete(w.clients, e.key.client)
+	}
+	w.bytes -= len(e.data)
+}
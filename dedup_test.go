@@ -0,0 +1,141 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Once counts the executions of its methods.
+type Once struct {
+	n     int32
+	delay time.Duration
+}
+
+// Do replies with the number of executions so far, after o.delay.
+func (o *Once) Do(args int, reply *int) error {
+	n := atomic.AddInt32(&o.n, 1)
+	time.Sleep(o.delay)
+	*reply = int(n)
+	return nil
+}
+
+// Wait replies once ctx is done, with its error.
+func (o *Once) Wait(ctx context.Context, args int, reply *int) error {
+	atomic.AddInt32(&o.n, 1)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// Big replies with a string of args bytes.
+func (o *Once) Big(args int, reply *string) error {
+	atomic.AddInt32(&o.n, 1)
+	*reply = strings.Repeat("x", args)
+	return nil
+}
+
+func startOnce(t *testing.T, cfg DedupConfig, delay time.Duration) (*Server, *Once, string) {
+	t.Helper()
+	server := NewServer(WithDedupWindow(cfg))
+	once := &Once{delay: delay}
+	_ = server.Register(once)
+	return server, once, startServer(t, server).Addr().String()
+}
+
+func TestAtMostOnce_Reconnect(t *testing.T) {
+	server, once, addr := startOnce(t, DedupConfig{}, 200*time.Millisecond)
+	opt := WithAtMostOnce("")
+	ctx := AtMostOnceContext(context.Background())
+
+	// the first attempt times out, the retry on a new connection gets its reply
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1}, opt)
+	_assert(err == nil, "dial error: %v", err)
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	var reply int
+	err = client.CallContext(timeout, "Once.Do", 1, &reply)
+	_assert(errors.Is(err, ErrDeadlineExceeded), "expect the first attempt to time out, but got %v", err)
+	_ = client.Close()
+
+	client, err = Dial("tcp", addr, &Option{HeartbeatIdle: -1}, opt)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	err = client.CallContext(ctx, "Once.Do", 1, &reply)
+	_assert(err == nil && reply == 1, "expect the reply of the first attempt, but got %d, %v", reply, err)
+	_assert(atomic.LoadInt32(&once.n) == 1, "expect one execution, but got %d", once.n)
+	_assert(server.Stats().Deduplicated == 1, "expect one retry deduplicated, but got %d", server.Stats().Deduplicated)
+
+	// other calls still execute
+	err = client.CallContext(context.Background(), "Once.Do", 1, &reply)
+	_assert(err == nil && reply == 2, "expect a new call executed, but got %d, %v", reply, err)
+}
+
+func TestAtMostOnce_Unavailable(t *testing.T) {
+	_, once, addr := startOnce(t, DedupConfig{}, 0)
+	opt := WithAtMostOnce("client")
+	ctx := AtMostOnceContext(context.Background())
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1}, opt)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// given up on, the method may have done its work
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	var reply int
+	err = client.CallContext(timeout, "Once.Wait", 1, &reply)
+	_assert(errors.Is(err, ErrDeadlineExceeded), "expect the first attempt to time out, but got %v", err)
+	waitFor(t, func() bool {
+		err = client.CallContext(ctx, "Once.Wait", 1, &reply)
+		return !errors.Is(err, ErrDeadlineExceeded)
+	}, "expect the first attempt done")
+	_assert(errors.Is(err, ErrAlreadyExecuted), "expect ErrAlreadyExecuted, but got %v", err)
+	_assert(atomic.LoadInt32(&once.n) == 1, "expect one execution, but got %d", once.n)
+}
+
+func TestAtMostOnce_Window(t *testing.T) {
+	_, once, addr := startOnce(t, DedupConfig{Window: 2, MaxBytes: 64}, 0)
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	call := func(id string) int {
+		var reply int
+		err := client.Call("Once.Do", 1, &reply, WithHeader(AtMostOnceHeader, id))
+		_assert(err == nil, "call error: %v", err)
+		return reply
+	}
+	_assert(call("c/1") == 1 && call("c/1") == 1, "expect c/1 executed once")
+	_assert(call("d/1") == 2, "expect the IDs of other clients apart")
+	_assert(call("c/2") == 3 && call("c/3") == 4, "expect new calls executed")
+	_assert(call("d/1") == 2, "expect d/1 still remembered")
+	_assert(call("c/1") == 5, "expect c/1 out of the window of its client")
+
+	// too large to be kept
+	var s string
+	for i := 0; i < 2; i++ {
+		err = client.Call("Once.Big", 100, &s, WithHeader(AtMostOnceHeader, "c/4"))
+	}
+	_assert(errors.Is(err, ErrAlreadyExecuted), "expect ErrAlreadyExecuted, but got %v", err)
+	_assert(atomic.LoadInt32(&once.n) == 6, "expect the large reply executed once, but got %d executions", once.n)
+}
+
+func TestDedupWindow_Bounds(t *testing.T) {
+	now := time.Unix(0, 0)
+	w := newDedupWindow(DedupConfig{TTL: time.Minute, MaxEntries: 2}, func() time.Time { return now })
+	key := func(seq uint64) dedupKey { return dedupKey{"c", seq} }
+	for seq := uint64(1); seq <= 3; seq++ {
+		_, first := w.begin(key(seq))
+		_assert(first, "expect call %d new", seq)
+	}
+	_, first := w.begin(key(1))
+	_assert(first, "expect the oldest call forgotten past MaxEntries")
+	_, first = w.begin(key(3))
+	_assert(!first, "expect call 3 remembered")
+
+	now = now.Add(time.Minute)
+	_, first = w.begin(key(3))
+	_assert(first, "expect call 3 forgotten past the TTL")
+	_assert(len(w.entries) == 1 && w.order.Len() == 1 && w.clients["c"].Len() == 1, "expect the expired calls removed")
+}
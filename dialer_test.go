@@ -0,0 +1,98 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pipeListener is an in-memory net.Listener fed by pipeDialer.
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error   { close(l.closed); return nil }
+func (l *pipeListener) Addr() net.Addr { return pipeAddr{} }
+
+// DialContext implements Dialer by handing one end of a net.Pipe to the listener.
+func (l *pipeListener) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+type countingConn struct {
+	net.Conn
+	reads *int32
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	atomic.AddInt32(c.reads, 1)
+	return c.Conn.Read(p)
+}
+
+func TestDialer_Pipe(t *testing.T) {
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	var reads int32
+	server.WrapConn(func(conn net.Conn) net.Conn {
+		return countingConn{Conn: conn, reads: &reads}
+	})
+	lis := newPipeListener()
+	go server.Accept(lis)
+	defer func() { _ = lis.Close() }()
+
+	client, err := Dial("pipe", "pipe", &Option{Dialer: lis})
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+	_assert(atomic.LoadInt32(&reads) > 0, "expect reads through the wrapped conn")
+}
+
+// blockDialer connects nowhere, it waits until ctx is done.
+type blockDialer struct{}
+
+func (blockDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestDialContext_Timeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := DialContext(ctx, "tcp", "127.0.0.1:1", &Option{Dialer: blockDialer{}})
+	_assert(errors.Is(err, ErrDeadlineExceeded) && errors.Is(err, context.DeadlineExceeded), "expect the dialer to see the deadline, but got %v", err)
+}
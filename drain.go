@@ -0,0 +1,97 @@
+package tinyrpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+	"tinyrpc/internal/clock"
+	"tinyrpc/rpclog"
+)
+
+// DefaultDrainGrace is how long the calls of a connection whose context
+// is done may take to finish, if ServerConfig.DrainGrace is 0.
+const DefaultDrainGrace = 5 * time.Second
+
+// ServeConnContext is like ServeConn, until ctx is done: the server then
+// sends a GoAway notice, cancels the contexts of the calls being handled
+// and answers the requests still arriving with ErrServerClosed. It closes
+// the connection once the calls are answered, or after
+// ServerConfig.DrainGrace.
+func (server *Server) ServeConnContext(ctx context.Context, conn io.ReadWriteCloser) {
+	server.serveConn(ctx, conn, nil)
+}
+
+// ServeContext is like Accept, until ctx is done: the server then closes
+// lis and ends the connections it accepted like ServeConnContext. It
+// returns the error that stopped lis, ErrServerClosed after Shutdown, or
+// the error of ctx once the connections are closed.
+func (server *Server) ServeContext(ctx context.Context, lis net.Listener) error {
+	return server.serve(ctx, lis, nil)
+}
+
+// closeListenerOn closes lis once ctx is done, unless the returned
+// function, which must be called, is called first.
+func (server *Server) closeListenerOn(ctx context.Context, lis net.Listener) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = lis.Close()
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// watchContext drains sc once ctx is done, unless the returned function,
+// which must be called once sc is served, is called first.
+func (server *Server) watchContext(ctx context.Context, sc *serverConn) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	grace := server.config.DrainGrace
+	if grace <= 0 {
+		grace = DefaultDrainGrace
+	}
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+			return
+		}
+		sc.cancelAll()
+		sc.goAway(server, ctx.Err().Error())
+		t := clock.Or(server.clock).NewTimer(grace)
+		select {
+		case <-t.C():
+			server.log(rpclog.LevelWarn, "closing connection past its drain grace", "conn", sc.id)
+			_ = sc.cc.Close()
+		case <-stop:
+			t.Stop()
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// cancelAll cancels the contexts of the requests being handled, which
+// are still answered, and makes the requests read next fail.
+func (sc *serverConn) cancelAll() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.ctxDone = true
+	for _, r := range sc.requests {
+		r.cancel()
+	}
+}
+
+// contextDone reports whether the context sc is served with is done.
+func (sc *serverConn) contextDone() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.ctxDone
+}
@@ -0,0 +1,89 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+	"tinyrpc/codec"
+)
+
+// CtxWaiter holds its calls until their context is done.
+type CtxWaiter chan error
+
+func (w CtxWaiter) Wait(ctx context.Context, args int, reply *int) error {
+	w <- nil // started
+	<-ctx.Done()
+	w <- ctx.Err()
+	return ctx.Err()
+}
+
+func TestServer_ServeContext(t *testing.T) {
+	server := NewServer()
+	waiter := make(CtxWaiter, 2)
+	_ = server.Register(waiter)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	_assert(err == nil, "network error: %v", err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- server.ServeContext(ctx, lis) }()
+
+	client, err := Dial("tcp", lis.Addr().String(), &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	call := client.Go("CtxWaiter.Wait", 1, new(int), nil)
+	<-waiter
+	cancel()
+
+	select {
+	case err := <-waiter:
+		_assert(errors.Is(err, context.Canceled), "expect the handler context canceled, but got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("expect the handler context canceled")
+	}
+	call = <-call.Done
+	_assert(errors.Is(call.Error, context.Canceled), "expect the call answered as canceled, but got %v", call.Error)
+	select {
+	case err := <-served:
+		_assert(errors.Is(err, context.Canceled), "expect ServeContext to return the context error, but got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("expect ServeContext to return once the connection is closed")
+	}
+	_assert(server.Stats().Connections == 0, "expect the connection closed")
+	_, err = Dial("tcp", lis.Addr().String(), &Option{HeartbeatIdle: -1, ConnectTimeout: time.Second})
+	_assert(err != nil, "expect the listener closed")
+}
+
+func TestServer_ServeConnContextGrace(t *testing.T) {
+	server := NewServer(WithDrainGrace(50 * time.Millisecond))
+	gate := make(Gate) // ignores the context
+	_ = server.Register(gate)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn, peer := net.Pipe()
+	served := make(chan struct{})
+	go func() {
+		server.ServeConnContext(ctx, conn)
+		close(served)
+	}()
+	client, err := NewClient(peer, &Option{MagicNumber: MagicNumber, CodecType: codec.GobType, HeartbeatIdle: -1})
+	_assert(err == nil, "client error: %v", err)
+	defer func() { _ = client.Close() }()
+	call := client.Go("Gate.Wait", 1, new(int), nil)
+	waitFor(t, func() bool { return server.Stats().InFlight == 1 }, "expect the call in flight")
+
+	start := time.Now()
+	cancel()
+	waitFor(t, func() bool { return !client.IsAvailable() }, "expect a GoAway notice")
+	select {
+	case call = <-call.Done:
+	case <-time.After(time.Second):
+		t.Fatal("expect the connection closed after the grace")
+	}
+	_assert(time.Since(start) >= 50*time.Millisecond, "expect the call given its grace, but closed after %s", time.Since(start))
+	_assert(call.Error != nil, "expect the call cut off with the connection")
+	close(gate)
+	<-served // once the handler returns
+}
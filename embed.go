@@ -0,0 +1,92 @@
+package tinyrpc
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ambiguousMethods returns the RPC methods that embedded fields of the
+// struct typ points to promote at the same depth, by name, with the
+// types promoting them. Go leaves them out of the method set of typ, so
+// Register would drop them silently.
+func ambiguousMethods(typ reflect.Type) map[string][]reflect.Type {
+	var ambiguous map[string][]reflect.Type
+	seen := make(map[string]bool) // names resolved at a shallower depth
+	visited := make(map[reflect.Type]bool)
+	level := embeddedTypes(typ, visited)
+	for len(level) > 0 {
+		found := make(map[string][]reflect.Type)
+		var next []reflect.Type
+		for _, t := range level {
+			mset := t
+			if t.Kind() != reflect.Interface && t.Kind() != reflect.Ptr {
+				mset = reflect.PtrTo(t) // addressable through the receiver
+			}
+			for i := 0; i < mset.NumMethod(); i++ {
+				method := mset.Method(i)
+				if !seen[method.Name] && (t.Kind() == reflect.Interface || newMethodType(method) != nil) {
+					found[method.Name] = append(found[method.Name], t)
+				}
+			}
+			next = append(next, embeddedTypes(t, visited)...)
+		}
+		for name, types := range found {
+			if _, ok := typ.MethodByName(name); !ok && len(types) > 1 {
+				if ambiguous == nil {
+					ambiguous = make(map[string][]reflect.Type)
+				}
+				ambiguous[name] = types
+			}
+			seen[name] = true
+		}
+		level = next
+	}
+	return ambiguous
+}
+
+// embeddedTypes returns the types of the embedded fields of the struct
+// t is or points to, but the pointers visited already: only embedded
+// pointers can make a cycle.
+func embeddedTypes(t reflect.Type, visited map[reflect.Type]bool) []reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var types []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.Anonymous || visited[f.Type] {
+			continue
+		}
+		if f.Type.Kind() == reflect.Ptr {
+			visited[f.Type] = true
+		}
+		types = append(types, f.Type)
+	}
+	return types
+}
+
+// checkAmbiguous fails for the ambiguous methods of s not excluded with
+// WithoutMethods, reporting the first by name.
+func (s *service) checkAmbiguous(ambiguous map[string][]reflect.Type) error {
+	var names []string
+	for name := range ambiguous {
+		if !s.config.excluded[name] {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	var from []string
+	for _, t := range ambiguous[names[0]] {
+		from = append(from, t.String())
+	}
+	return fmt.Errorf("rpc: method %s.%s is promoted ambiguously by %s: define it on %s or exclude it with WithoutMethods",
+		s.name, names[0], strings.Join(from, ", "), s.typ)
+}
@@ -0,0 +1,99 @@
+package tinyrpc
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// BaseService holds the methods common to several services.
+type BaseService struct{ name string }
+
+func (b *BaseService) Ping(n int, reply *int) error {
+	*reply = n
+	return nil
+}
+
+func (b *BaseService) Name(n int, reply *string) error {
+	*reply = b.name
+	return nil
+}
+
+type Users struct{ BaseService }
+
+type Orders struct{ *BaseService }
+
+// Audit has a Ping too, colliding with BaseService's when both are embedded.
+type Audit struct{}
+
+func (Audit) Ping(n int, reply *int) error {
+	*reply = -n
+	return nil
+}
+
+type Mixed struct {
+	BaseService
+	Audit
+}
+
+// Resolved defines Ping itself, which shadows the promoted ones.
+type Resolved struct {
+	BaseService
+	Audit
+}
+
+func (r *Resolved) Ping(n int, reply *int) error {
+	*reply = 2 * n
+	return nil
+}
+
+func TestServer_PromotedMethods(t *testing.T) {
+	server := NewServer()
+	_assert(server.Register(&Users{BaseService{"users"}}) == nil, "register Users")
+	_assert(server.Register(&Orders{&BaseService{"orders"}}) == nil, "register Orders")
+	client, err := Dial("tcp", startServer(t, server).Addr().String(), &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	for _, svc := range []string{"Users", "Orders"} {
+		var n int
+		err := client.Call(svc+".Ping", 7, &n)
+		_assert(err == nil && n == 7, "%s.Ping: %v, %d", svc, err, n)
+		var name string
+		err = client.Call(svc+".Name", 0, &name)
+		_assert(err == nil && name == strings.ToLower(svc), "%s.Name: %v, %q", svc, err, name)
+	}
+}
+
+func TestServer_AmbiguousPromotedMethods(t *testing.T) {
+	server := NewServer()
+	err := server.Register(&Mixed{})
+	_assert(err != nil && strings.Contains(err.Error(), "Mixed.Ping") &&
+		strings.Contains(err.Error(), "tinyrpc.BaseService, tinyrpc.Audit"), "expect Ping ambiguous, got %v", err)
+	_assert(server.Register(&Resolved{}) == nil, "expect a Ping defined on the receiver to resolve it")
+	_assert(server.Register(&Mixed{BaseService: BaseService{"mixed"}}, WithoutMethods("Ping")) == nil, "expect Ping excluded")
+
+	client, err := Dial("tcp", startServer(t, server).Addr().String(), &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var n int
+	err = client.Call("Resolved.Ping", 3, &n)
+	_assert(err == nil && n == 6, "Resolved.Ping: %v, %d", err, n)
+	var name string
+	err = client.Call("Mixed.Name", 0, &name)
+	_assert(err == nil && name == "mixed", "Mixed.Name: %v, %q", err, name)
+	err = client.Call("Mixed.Ping", 3, &n)
+	_assert(errors.Is(err, ErrMethodNotFound), "expect Mixed.Ping not found, got %v", err)
+}
+
+func TestWithoutMethods(t *testing.T) {
+	server := NewServer()
+	_assert(server.Register(&Users{}, WithoutMethods("Name")) == nil, "register Users")
+	_, _, err := server.findService("Users.Name")
+	_assert(errors.Is(err, ErrMethodNotFound), "expect Users.Name excluded, got %v", err)
+	_, _, err = server.findService("Users.Ping")
+	_assert(err == nil, "expect Users.Ping kept, got %v", err)
+
+	err = server.Register(&Orders{}, WithoutMethods("Nope"))
+	_assert(err != nil && strings.Contains(err.Error(), "Orders.Nope"), "expect an unknown method to fail, got %v", err)
+}
@@ -0,0 +1,79 @@
+package tinyrpc
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// recordingConn keeps what the server reads, to look at the wire.
+type recordingConn struct {
+	net.Conn
+	mu   *sync.Mutex
+	read *bytes.Buffer
+}
+
+func (c recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	c.read.Write(p[:n])
+	c.mu.Unlock()
+	return n, err
+}
+
+func TestServer_EncryptedStream(t *testing.T) {
+	old, current := bytes.Repeat([]byte{1}, 16), bytes.Repeat([]byte{2}, 32)
+	server := NewServer(WithEncryptionKeys(map[string][]byte{"2023": old, "2024": current}))
+	_ = server.Register(Blob{})
+	var mu sync.Mutex
+	var wire bytes.Buffer
+	server.WrapConn(func(conn net.Conn) net.Conn { return recordingConn{conn, &mu, &wire} })
+	addr := startServer(t, server).Addr().String()
+
+	for id, key := range map[string][]byte{"2023": old, "2024": current} {
+		client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1, EncryptionKeyID: id, EncryptionKey: key})
+		_assert(err == nil, "dial error with key %s: %v", id, err)
+		var n int
+		err = client.Call("Blob.Len", []byte("attack at dawn"), &n)
+		_assert(err == nil && n == 14, "failed to call Blob.Len with key %s: %v", id, err)
+		_ = client.Close()
+	}
+	mu.Lock()
+	_assert(!bytes.Contains(wire.Bytes(), []byte("attack at dawn")), "expect no plaintext on the wire")
+	mu.Unlock()
+
+	_, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1, EncryptionKeyID: "2022", EncryptionKey: old})
+	_assert(errors.Is(err, ErrUnknownKey), "expect ErrUnknownKey, but got %v", err)
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1, EncryptionKeyID: "2024", EncryptionKey: old})
+	if err == nil {
+		var n int
+		err = client.Call("Blob.Len", []byte("attack at dawn"), &n)
+		_ = client.Close()
+	}
+	_assert(err != nil, "expect a wrong key to fail")
+}
+
+func TestServer_EncryptedStreamTampered(t *testing.T) {
+	key := bytes.Repeat([]byte{3}, 16)
+	var armed int32
+	server := NewServer(WithEncryptionKeys(map[string][]byte{"k": key}))
+	server.WrapConn(func(conn net.Conn) net.Conn { return manglingConn{conn, &armed} })
+	client, err := Dial("tcp", startServer(t, server).Addr().String(), &Option{HeartbeatIdle: -1, EncryptionKeyID: "k", EncryptionKey: key})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	_assert(client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply) == nil, "failed to call Foo.Sum")
+
+	atomic.StoreInt32(&armed, 1)
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil, "expect the tampered request to close the connection")
+	_assert(client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply) != nil, "expect the connection closed")
+}
+
+func TestWithEncryptionKeys_Invalid(t *testing.T) {
+	err := NewServer().Configure(WithEncryptionKeys(map[string][]byte{"short": []byte("key")}))
+	_assert(err != nil, "expect a 3-byte key rejected")
+}
@@ -0,0 +1,315 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"tinyrpc/codec"
+	"tinyrpc/rpclog"
+	"unicode"
+	"unicode/utf8"
+)
+
+// timeoutError is a sentinel error that also matches the context error it
+// stands for, so errors.Is(err, context.DeadlineExceeded) keeps working.
+type timeoutError struct {
+	msg string
+	err error // context.DeadlineExceeded or context.Canceled
+}
+
+func (e *timeoutError) Error() string { return e.msg }
+func (e *timeoutError) Unwrap() error { return e.err }
+func (e *timeoutError) Timeout() bool { return e.err == context.DeadlineExceeded }
+
+var (
+	// ErrDeadlineExceeded is returned when the deadline of a call passed,
+	// on the client or on the server, or the heartbeat got no answer in time.
+	ErrDeadlineExceeded error = &timeoutError{"rpc: deadline exceeded", context.DeadlineExceeded}
+	// ErrConnectTimeout is returned when connecting took longer than
+	// Option.ConnectTimeout.
+	ErrConnectTimeout error = &timeoutError{"rpc: connect timeout", context.DeadlineExceeded}
+	// ErrWriteTimeout is returned by clients when a write to the
+	// connection took longer than Option.WriteTimeout, e.g. to a server
+	// that stopped reading. The connection is closed.
+	ErrWriteTimeout error = &timeoutError{"rpc: write timeout", context.DeadlineExceeded}
+	// ErrHandleTimeout is returned when the server took longer than
+	// Option.HandleTimeout to handle a call.
+	ErrHandleTimeout error = &timeoutError{"rpc: handle timeout", context.DeadlineExceeded}
+	// ErrCanceled is returned when the context of a call was canceled.
+	ErrCanceled error = &timeoutError{"rpc: canceled", context.Canceled}
+	// ErrInvalidArgument is returned when the arguments of a call failed
+	// validation, see Validator.
+	ErrInvalidArgument = errors.New("rpc: invalid argument")
+	// ErrMethodNotFound is returned for calls to a service or a method
+	// that is not registered.
+	ErrMethodNotFound = errors.New("rpc: method not found")
+	// ErrBodyTooLarge is returned for requests whose body is larger than
+	// the MaxBodySize of the server or of the listener.
+	ErrBodyTooLarge = errors.New("rpc: request body too large")
+	// ErrPermissionDenied is returned for calls that the Authorizer of
+	// the server rejects, see EnableAdmin.
+	ErrPermissionDenied = errors.New("rpc: permission denied")
+	// ErrRateLimited is returned for requests over the rate limit of
+	// their remote IP, see SetIPLimits.
+	ErrRateLimited = errors.New("rpc: rate limited")
+	// ErrResourceExhausted is returned for calls over the quota of their
+	// principal, see QuotaInterceptor, or over ServerConfig.MaxInFlight.
+	ErrResourceExhausted = errors.New("rpc: resource exhausted")
+	// ErrCorrupted is returned when a frame fails its checksum, see
+	// Option.EnableChecksum, or the codec can't read on after a decode
+	// error, see codec.ErrStreamCorrupt. The connection is closed.
+	ErrCorrupted = errors.New("rpc: corrupted frame")
+	// ErrSchemaMismatch is returned by clients for calls whose args or
+	// reply have another schema than on the server, see WithSchemaCheck.
+	ErrSchemaMismatch = errors.New("rpc: schema mismatch")
+	// ErrClientOverloaded is returned by clients for calls over their cap
+	// of outstanding calls, see WithMaxOutstanding. The call isn't sent.
+	ErrClientOverloaded = errors.New("rpc: client overloaded")
+	// ErrInvalidReply is returned by clients for calls whose reply is not
+	// a non-nil pointer, or doesn't decode. The call isn't sent in the
+	// first case.
+	ErrInvalidReply = errors.New("rpc: invalid reply")
+	// ErrProtocolViolation is returned when a client breaks the protocol
+	// on a server with ServerConfig.StrictProtocol. The connection is
+	// closed.
+	ErrProtocolViolation = errors.New("rpc: protocol violation")
+	// ErrAlreadyExecuted is returned for a retry of a call the server
+	// executed already, whose result it didn't keep, see WithAtMostOnce.
+	ErrAlreadyExecuted = errors.New("rpc: already executed, result unavailable")
+)
+
+// Error codes sent in Header.Code, so that clients can map errors back
+// to the sentinels above.
+const (
+	codeDeadlineExceeded  = "deadline_exceeded"
+	codeHandleTimeout     = "handle_timeout"
+	codeCanceled          = "canceled"
+	codeInvalidArgument   = "invalid_argument"
+	codeMethodNotFound    = "method_not_found"
+	codeBodyTooLarge      = "body_too_large"
+	codePermissionDenied  = "permission_denied"
+	codeRateLimited       = "rate_limited"
+	codeResourceExhausted = "resource_exhausted"
+	codeCorrupted         = "corrupted"
+	codeProtocolViolation = "protocol_violation"
+	codeAlreadyExecuted   = "already_executed"
+)
+
+// registeredError is an application error registered with RegisterError.
+type registeredError struct {
+	code string
+	err  error
+}
+
+var (
+	errorsMu         sync.RWMutex // protect following
+	registeredErrors []registeredError
+)
+
+// RegisterError maps code to the sentinel err, on both sides of the
+// connection. The server sends code with the errors of methods that match
+// err with errors.Is, and the client turns it back into an error that
+// matches err and keeps the remote message. Codes must be registered the
+// same way by clients and servers, usually in an init function. Like
+// gob.Register, it panics if err is nil or code is already registered with
+// another error.
+func RegisterError(code int, err error) {
+	if err == nil {
+		panic("rpc: RegisterError of a nil error")
+	}
+	c := strconv.Itoa(code)
+	errorsMu.Lock()
+	defer errorsMu.Unlock()
+	for _, r := range registeredErrors {
+		if r.code == c {
+			if r.err == err {
+				return
+			}
+			panic("rpc: RegisterError of code " + c + " twice")
+		}
+	}
+	registeredErrors = append(registeredErrors, registeredError{c, err})
+}
+
+// errorCode returns the Header.Code of err, "" if it has none.
+// ErrHandleTimeout is checked first, it also matches DeadlineExceeded.
+// Registered errors are checked in the order of registration.
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrHandleTimeout):
+		return codeHandleTimeout
+	case errors.Is(err, context.DeadlineExceeded):
+		return codeDeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return codeCanceled
+	case errors.Is(err, ErrInvalidArgument):
+		return codeInvalidArgument
+	case errors.Is(err, ErrMethodNotFound):
+		return codeMethodNotFound
+	case errors.Is(err, ErrBodyTooLarge):
+		return codeBodyTooLarge
+	case errors.Is(err, ErrPermissionDenied):
+		return codePermissionDenied
+	case errors.Is(err, ErrRateLimited):
+		return codeRateLimited
+	case errors.Is(err, ErrResourceExhausted):
+		return codeResourceExhausted
+	case errors.Is(err, ErrCorrupted):
+		return codeCorrupted
+	case errors.Is(err, ErrProtocolViolation):
+		return codeProtocolViolation
+	case errors.Is(err, ErrAlreadyExecuted):
+		return codeAlreadyExecuted
+	}
+	errorsMu.RLock()
+	defer errorsMu.RUnlock()
+	for _, r := range registeredErrors {
+		if errors.Is(err, r.err) {
+			return r.code
+		}
+	}
+	return ""
+}
+
+// lookupError returns the error registered with code, nil if none.
+func lookupError(code string) error {
+	errorsMu.RLock()
+	defer errorsMu.RUnlock()
+	for _, r := range registeredErrors {
+		if r.code == code {
+			return r.err
+		}
+	}
+	return nil
+}
+
+// serverError is an error returned by the server. It wraps the sentinel
+// of its code, if any, either a built-in or a registered error.
+type serverError struct {
+	msg        string
+	err        error
+	code       string        // Header.Code, as sent
+	retryAfter time.Duration // see RetryAfter
+}
+
+func (e *serverError) Error() string { return e.msg }
+func (e *serverError) Unwrap() error { return e.err }
+
+// IsServerError reports whether err was sent by the server of the call,
+// as opposed to the call failing to reach it or to get its response.
+func IsServerError(err error) bool {
+	var se *serverError
+	return errors.As(err, &se)
+}
+
+// ErrorCode returns the code the server sent with err, e.g.
+// "method_not_found" or one of RegisterError, "" if it sent none or err
+// is not a server error. It is kept even if the client doesn't know it.
+func ErrorCode(err error) string {
+	var se *serverError
+	if errors.As(err, &se) {
+		return se.code
+	}
+	return ""
+}
+
+func newServerError(msg, code string) error {
+	e := &serverError{msg: msg, code: code}
+	switch code {
+	case codeDeadlineExceeded:
+		e.err = ErrDeadlineExceeded
+	case codeHandleTimeout:
+		e.err = ErrHandleTimeout
+	case codeCanceled:
+		e.err = ErrCanceled
+	case codeInvalidArgument:
+		e.err = ErrInvalidArgument
+	case codeMethodNotFound:
+		e.err = ErrMethodNotFound
+	case codeBodyTooLarge:
+		e.err = ErrBodyTooLarge
+	case codePermissionDenied:
+		e.err = ErrPermissionDenied
+	case codeRateLimited:
+		e.err = ErrRateLimited
+	case codeResourceExhausted:
+		e.err = ErrResourceExhausted
+	case codeCorrupted:
+		e.err = ErrCorrupted
+	case codeProtocolViolation:
+		e.err = ErrProtocolViolation
+	case codeAlreadyExecuted:
+		e.err = ErrAlreadyExecuted
+	default:
+		e.err = lookupError(code) // nil if unknown to this client
+	}
+	return e
+}
+
+// contextError returns the sentinel matching ctx.Err().
+func contextError(err error) error {
+	if err == context.DeadlineExceeded {
+		return ErrDeadlineExceeded
+	}
+	return ErrCanceled
+}
+
+// DefaultMaxErrorLength is the default limit of Header.Error in bytes.
+const DefaultMaxErrorLength = 4 << 10
+
+// SetMaxErrorLength limits the error strings sent to clients to n bytes,
+// DefaultMaxErrorLength if n is 0. Longer ones are truncated with a marker.
+// It must be called before the server starts serving.
+func (server *Server) SetMaxErrorLength(n int) {
+	server.maxErrorLen = n
+}
+
+// setError stores the error message of err in h, without control
+// characters and truncated to the configured maximum.
+func (server *Server) setError(h *codec.Header, err error) {
+	max := server.maxErrorLen
+	if max <= 0 {
+		max = DefaultMaxErrorLength
+	}
+	msg, truncated := sanitizeError(err.Error(), max)
+	if truncated > 0 {
+		server.log(rpclog.LevelWarn, "error message truncated", "method", h.ServiceMethod, "bytes", truncated)
+	}
+	h.Error = msg
+	h.Code = errorCode(err)
+}
+
+// truncatedMarkerLen is room enough for the marker appended to cut messages.
+const truncatedMarkerLen = 48
+
+// sanitizeError strips control characters other than tab and newline from
+// msg and cuts it to at most max bytes at a rune boundary, marking the cut.
+// It returns the number of bytes cut off.
+func sanitizeError(msg string, max int) (string, int) {
+	truncated := 0
+	if len(msg) > max {
+		cut := max - truncatedMarkerLen
+		if cut < 0 {
+			cut = 0
+		}
+		for cut > 0 && !utf8.RuneStart(msg[cut]) {
+			cut--
+		}
+		truncated = len(msg) - cut
+		msg = msg[:cut]
+	}
+	msg = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\t' && r != '\n' {
+			return -1
+		}
+		return r
+	}, msg)
+	if truncated > 0 {
+		msg += fmt.Sprintf("...(%d bytes truncated)", truncated)
+	}
+	return msg, truncated
+}
@@ -0,0 +1,216 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"tinyrpc/tinyrpctest"
+)
+
+type Loud int
+
+func (l Loud) Fail(size int, reply *int) error {
+	return errors.New("\x1b[31mred\x1b[0m " + strings.Repeat("x", size))
+}
+
+func TestSanitizeError(t *testing.T) {
+	msg, n := sanitizeError("bad\x1b[0m\x00 input\n\tline", 100)
+	_assert(msg == "bad[0m input\n\tline" && n == 0, "unexpected sanitized message %q", msg)
+
+	msg, n = sanitizeError(strings.Repeat("é", 100), 101)
+	_assert(len(msg) <= 101 && n > 0 && strings.HasSuffix(msg, "bytes truncated)"), "unexpected truncated message %q", msg)
+	_assert(strings.HasPrefix(msg, "éé") && !strings.Contains(msg, "�"), "expect a cut at a rune boundary: %q", msg)
+}
+
+func TestServer_LargeError(t *testing.T) {
+	var loud Loud
+	server := NewServer()
+	_ = server.Register(&loud)
+	lis := startServer(t, server)
+	client, err := Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call("Loud.Fail", 10<<20, &reply)
+	_assert(err != nil && len(err.Error()) <= DefaultMaxErrorLength, "expect an error of at most %d bytes, but got %d", DefaultMaxErrorLength, len(err.Error()))
+	_assert(strings.HasPrefix(err.Error(), "[31mred[0m xxx"), "expect control characters stripped: %.20q", err.Error())
+	_assert(strings.HasSuffix(err.Error(), "bytes truncated)"), "expect a truncation marker")
+
+	err = client.Call("Loud.Fail", 10, &reply)
+	_assert(err != nil && strings.HasSuffix(err.Error(), " xxxxxxxxxx"), "expect a short error untouched, but got %q", err)
+}
+
+func TestTimeoutErrors(t *testing.T) {
+	server := NewServer()
+	var slow Slow
+	_ = server.Register(&slow)
+	addr := startServer(t, server).Addr().String()
+
+	tests := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{"connect timeout", func() error {
+			// nothing accepts, so dialing blocks until the timeout
+			_, err := Dial("pipe", "", &Option{Dialer: newPipeListener(), ConnectTimeout: 50 * time.Millisecond})
+			return err
+		}, ErrConnectTimeout},
+		{"dial deadline", func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err := DialContext(ctx, "pipe", "", &Option{Dialer: newPipeListener()})
+			return err
+		}, ErrDeadlineExceeded},
+		{"handle timeout", func() error {
+			server := NewServer()
+			clock := tinyrpctest.NewClock()
+			server.SetClock(clock)
+			_ = server.Register(&slow)
+			client, err := Dial("tcp", startServer(t, server).Addr().String(), &Option{HandleTimeout: 50 * time.Millisecond})
+			if err != nil {
+				return err
+			}
+			defer func() { _ = client.Close() }()
+			var reply int
+			errc := goCall(func() error { return client.Call("Slow.Sleep", 300, &reply) })
+			clock.BlockUntil(1)
+			clock.Advance(50 * time.Millisecond)
+			return <-errc
+		}, ErrHandleTimeout},
+		{"call deadline", func() error {
+			client, err := Dial("tcp", addr)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = client.Close() }()
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			var reply int
+			return client.CallContext(ctx, "Slow.Sleep", 300, &reply)
+		}, ErrDeadlineExceeded},
+		{"call canceled", func() error {
+			client, err := Dial("tcp", addr)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = client.Close() }()
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			var reply int
+			return client.CallContext(ctx, "Slow.Sleep", 300, &reply)
+		}, ErrCanceled},
+		{"heartbeat timeout", testHeartbeatTimeout, ErrDeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			_assert(errors.Is(err, tt.want), "expect %v, but got %v", tt.want, err)
+		})
+	}
+}
+
+// testHeartbeatTimeout returns the error of a call pending when the
+// heartbeat gives up on a server that never answers.
+func testHeartbeatTimeout() error {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lis.Close() }()
+	go func() {
+		conn, err := lis.Accept()
+		if err == nil {
+			_, _ = io.Copy(io.Discard, conn)
+		}
+	}()
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		return err
+	}
+	clock := tinyrpctest.NewClock()
+	opt, _ := parseOptions(&Option{HeartbeatIdle: time.Second, Clock: clock})
+	client, err := NewClient(conn, opt)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
+	var reply int
+	call := client.Go("Slow.Sleep", 1, &reply, nil)
+	clock.Advance(2 * time.Second) // idle, so the client pings
+	time.Sleep(20 * time.Millisecond)
+	clock.Advance(2 * time.Second) // the ping got no answer
+	select {
+	case call = <-call.Done:
+		return call.Error
+	case <-time.After(time.Second):
+		return errors.New("expect the pending call to fail")
+	}
+}
+
+func TestServerErrorCodes(t *testing.T) {
+	for _, want := range []error{ErrDeadlineExceeded, ErrHandleTimeout, ErrCanceled} {
+		err := newServerError("detail", errorCode(want))
+		_assert(errors.Is(err, want) && err.Error() == "detail", "expect %v to round trip, but got %v", want, err)
+	}
+	err := newServerError("detail", errorCode(errors.New("plain")))
+	_assert(errors.Unwrap(err) == nil, "expect no sentinel for a plain error")
+	_assert(errors.Is(ErrHandleTimeout, context.DeadlineExceeded), "expect the sentinels to match context errors")
+	err = fmt.Errorf("call: %w", newServerError("detail", "unknown_code"))
+	_assert(ErrorCode(err) == "unknown_code" && ErrorCode(ErrCanceled) == "", "expect the code as sent, but got %q", ErrorCode(err))
+}
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+)
+
+func init() {
+	RegisterError(404, ErrNotFound)
+	RegisterError(409, ErrConflict)
+}
+
+type Store int
+
+func (s Store) Get(key string, reply *string) error {
+	switch key {
+	case "missing":
+		return fmt.Errorf("key %q: %w", key, ErrNotFound)
+	case "taken":
+		return ErrConflict
+	}
+	return errors.New("disk failure")
+}
+
+func TestRegisterError(t *testing.T) {
+	server := NewServer()
+	var store Store
+	_ = server.Register(&store)
+	client, err := Dial("tcp", startServer(t, server).Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err = client.Call("Store.Get", "missing", &reply)
+	_assert(errors.Is(err, ErrNotFound) && !errors.Is(err, ErrConflict), "expect ErrNotFound, but got %v", err)
+	_assert(err.Error() == `key "missing": not found`, "expect the remote message, but got %q", err)
+	err = client.Call("Store.Get", "taken", &reply)
+	_assert(errors.Is(err, ErrConflict), "expect ErrConflict, but got %v", err)
+	err = client.Call("Store.Get", "other", &reply)
+	_assert(err != nil && err.Error() == "disk failure" && errors.Unwrap(err) == nil,
+		"expect an unregistered error as a string, but got %v", err)
+
+	RegisterError(404, ErrNotFound) // registering again is a no-op
+	defer func() { _assert(recover() != nil, "expect a panic for a code taken") }()
+	RegisterError(404, ErrConflict)
+}
@@ -0,0 +1,300 @@
+package tinyrpc
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"tinyrpc/codec"
+)
+
+// Duration is a time.Duration written as a string such as "1m30s" in
+// config files.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("rpc server: invalid duration %q, expect e.g. \"5s\"", text)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// FileConfig is the configuration of a server as a config file holds it,
+// see NewServerFromConfig and ParseFileConfig. Zero fields take the
+// defaults of a Server made without options. It covers the settings
+// that can be written down; the others, such as interceptors or
+// authenticators, are set on the Server it returns.
+type FileConfig struct {
+	Listeners []ListenerConfig `json:"listeners" yaml:"listeners"`
+	Codecs    []codec.Type     `json:"codecs,omitempty" yaml:"codecs,omitempty"` // see SetCodecs
+
+	MaxConnections int     `json:"max_connections,omitempty" yaml:"max_connections,omitempty"`
+	MaxBodySize    int64   `json:"max_body_size,omitempty" yaml:"max_body_size,omitempty"`
+	IPMaxConns     int     `json:"ip_max_conns,omitempty" yaml:"ip_max_conns,omitempty"` // see IPLimits
+	IPQPS          float64 `json:"ip_qps,omitempty" yaml:"ip_qps,omitempty"`
+	IPBurst        int     `json:"ip_burst,omitempty" yaml:"ip_burst,omitempty"`
+
+	HandleTimeout       Duration            `json:"handle_timeout,omitempty" yaml:"handle_timeout,omitempty"`
+	IdleTimeout         Duration            `json:"idle_timeout,omitempty" yaml:"idle_timeout,omitempty"`
+	FirstRequestTimeout Duration            `json:"first_request_timeout,omitempty" yaml:"first_request_timeout,omitempty"`
+	DrainGrace          Duration            `json:"drain_grace,omitempty" yaml:"drain_grace,omitempty"`
+	MethodTimeouts      map[string]Duration `json:"method_timeouts,omitempty" yaml:"method_timeouts,omitempty"` // by pattern, see SetMethodTimeout
+
+	Workers       int      `json:"workers,omitempty" yaml:"workers,omitempty"`
+	PriorityAging Duration `json:"priority_aging,omitempty" yaml:"priority_aging,omitempty"`
+	MaxQueued     int      `json:"max_queued,omitempty" yaml:"max_queued,omitempty"`
+
+	Registry *RegistryFileConfig `json:"registry,omitempty" yaml:"registry,omitempty"`
+	Debug    *DebugConfig        `json:"debug,omitempty" yaml:"debug,omitempty"`
+}
+
+// ListenerConfig is a listener of a FileConfig, see ListenerOptions.
+type ListenerConfig struct {
+	// Address is listened on, e.g. "tcp@:9999", ":9999" or
+	// "unix:///var/run/app.sock".
+	Address string `json:"address" yaml:"address"`
+	// TLSCert and TLSKey are the PEM files of the certificate served, if
+	// set. Clients must then present a certificate signed by TLSClientCA,
+	// if set.
+	TLSCert     string       `json:"tls_cert,omitempty" yaml:"tls_cert,omitempty"`
+	TLSKey      string       `json:"tls_key,omitempty" yaml:"tls_key,omitempty"`
+	TLSClientCA string       `json:"tls_client_ca,omitempty" yaml:"tls_client_ca,omitempty"`
+	Codecs      []codec.Type `json:"codecs,omitempty" yaml:"codecs,omitempty"`
+	MaxBodySize int64        `json:"max_body_size,omitempty" yaml:"max_body_size,omitempty"`
+	IdleTimeout Duration     `json:"idle_timeout,omitempty" yaml:"idle_timeout,omitempty"`
+	// ProxyProtocol expects PROXY protocol headers, see SetAcceptProxyProtocol.
+	ProxyProtocol bool `json:"proxy_protocol,omitempty" yaml:"proxy_protocol,omitempty"`
+}
+
+// RegistryFileConfig is the RegistryConfig of a FileConfig.
+type RegistryFileConfig struct {
+	URLs          []string `json:"urls" yaml:"urls"`
+	Interval      Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+	ServiceName   string   `json:"service_name,omitempty" yaml:"service_name,omitempty"`
+	AdvertiseAddr string   `json:"advertise_addr,omitempty" yaml:"advertise_addr,omitempty"`
+	Zone          string   `json:"zone,omitempty" yaml:"zone,omitempty"`
+}
+
+// DebugConfig serves the debug endpoints of the server, see HandleDebug.
+type DebugConfig struct {
+	Address string `json:"address" yaml:"address"`                   // of the HTTP listener, e.g. "127.0.0.1:6060"
+	Prefix  string `json:"prefix,omitempty" yaml:"prefix,omitempty"` // DefaultDebugPrefix if empty
+}
+
+// ParseFileConfig decodes a FileConfig from JSON. Unknown fields are
+// errors, so that a typo isn't silently ignored.
+func ParseFileConfig(data []byte) (FileConfig, error) {
+	var cfg FileConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return FileConfig{}, fmt.Errorf("rpc server: config: %v", err)
+	}
+	return cfg, nil
+}
+
+// NewServerFromConfig returns a server configured with cfg, and its
+// listeners, opened and added with AddListener, so that Run serves them
+// once the services are registered. If cfg.Debug is set, the debug
+// endpoints are served on a listener of their own, last in the list.
+// Closing the listeners stops them. It returns every problem of cfg as
+// ValidationErrors, opening nothing, if cfg is invalid.
+func NewServerFromConfig(cfg FileConfig) (*Server, []net.Listener, error) {
+	errs := cfg.validate()
+	server := NewServer()
+	err := server.Configure(
+		WithMaxConnections(cfg.MaxConnections),
+		WithMaxBodySize(cfg.MaxBodySize),
+		WithHandleTimeout(time.Duration(cfg.HandleTimeout)),
+		WithIdleTimeout(time.Duration(cfg.IdleTimeout)),
+		WithDrainGrace(time.Duration(cfg.DrainGrace)),
+		WithWorkers(cfg.Workers, time.Duration(cfg.PriorityAging)),
+		WithMaxQueued(cfg.MaxQueued),
+	)
+	if err != nil && len(errs) == 0 { // else validate reported it
+		errs = append(errs, err)
+	}
+	if cfg.Codecs != nil {
+		server.SetCodecs(cfg.Codecs...)
+		errs = append(errs, server.checkCodecs()...)
+	}
+	server.SetFirstRequestTimeout(time.Duration(cfg.FirstRequestTimeout))
+	server.SetIPLimits(IPLimits{MaxConns: cfg.IPMaxConns, QPS: cfg.IPQPS, Burst: cfg.IPBurst})
+	for pattern, d := range cfg.MethodTimeouts {
+		server.SetMethodTimeout(pattern, time.Duration(d))
+	}
+	if r := cfg.Registry; r != nil {
+		server.EnableRegistry(RegistryConfig{
+			URLs:          r.URLs,
+			Interval:      time.Duration(r.Interval),
+			ServiceName:   r.ServiceName,
+			AdvertiseAddr: r.AdvertiseAddr,
+			Zone:          r.Zone,
+		})
+	}
+	lopts := make([]*ListenerOptions, len(cfg.Listeners))
+	for i, l := range cfg.Listeners {
+		lopt, err := l.options()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		lopts[i] = lopt
+		errs = append(errs, server.validateListener("listener "+l.Address, lopt)...)
+	}
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, lis := range listeners {
+			_ = lis.Close()
+		}
+	}
+	for i, l := range cfg.Listeners {
+		lis, err := server.Listen(parseAddr(l.Address))
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		listeners = append(listeners, lis)
+		server.AddListener(lis, lopts[i])
+	}
+	if d := cfg.Debug; d != nil {
+		lis, err := net.Listen("tcp", d.Address)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		listeners = append(listeners, lis)
+		mux := http.NewServeMux()
+		server.HandleDebug(mux, d.Prefix)
+		go func() { _ = http.Serve(lis, mux) }()
+	}
+	return server, listeners, nil
+}
+
+// validate returns the problems of cfg that don't depend on a server.
+func (cfg *FileConfig) validate() ValidationErrors {
+	var errs ValidationErrors
+	fail := func(format string, v ...interface{}) {
+		errs = append(errs, fmt.Errorf("rpc server: config: "+format, v...))
+	}
+	if len(cfg.Listeners) == 0 {
+		fail("no listeners")
+	}
+	seen := make(map[string]bool, len(cfg.Listeners))
+	for i, l := range cfg.Listeners {
+		switch {
+		case l.Address == "":
+			fail("listener %d: no address", i)
+		case seen[l.Address]:
+			fail("listener %s: listed twice", l.Address)
+		}
+		seen[l.Address] = true
+		if (l.TLSCert == "") != (l.TLSKey == "") {
+			fail("listener %s: tls_cert and tls_key go together", l.Address)
+		}
+		if l.TLSClientCA != "" && l.TLSCert == "" {
+			fail("listener %s: tls_client_ca without tls_cert", l.Address)
+		}
+		if l.ProxyProtocol && l.TLSCert != "" {
+			fail("listener %s: proxy_protocol with TLS, the PROXY header comes before the TLS handshake", l.Address)
+		}
+	}
+	for name, v := range map[string]int64{
+		"max_connections": int64(cfg.MaxConnections),
+		"max_body_size":   cfg.MaxBodySize,
+		"ip_max_conns":    int64(cfg.IPMaxConns),
+		"ip_burst":        int64(cfg.IPBurst),
+		"workers":         int64(cfg.Workers),
+		"max_queued":      int64(cfg.MaxQueued),
+	} {
+		if v < 0 {
+			fail("negative %s %d", name, v)
+		}
+	}
+	if cfg.IPQPS < 0 {
+		fail("negative ip_qps %g", cfg.IPQPS)
+	}
+	for name, d := range map[string]Duration{
+		"handle_timeout":        cfg.HandleTimeout,
+		"idle_timeout":          cfg.IdleTimeout,
+		"first_request_timeout": cfg.FirstRequestTimeout,
+		"drain_grace":           cfg.DrainGrace,
+		"priority_aging":        cfg.PriorityAging,
+	} {
+		if d < 0 {
+			fail("negative %s %s", name, time.Duration(d))
+		}
+	}
+	if cfg.PriorityAging != 0 && cfg.Workers == 0 {
+		fail("priority_aging without workers")
+	}
+	if cfg.MaxQueued != 0 && cfg.Workers == 0 {
+		fail("max_queued without workers")
+	}
+	if cfg.IdleTimeout > 0 && cfg.FirstRequestTimeout > cfg.IdleTimeout {
+		fail("first_request_timeout %s exceeds idle_timeout %s, which closes the connections first",
+			time.Duration(cfg.FirstRequestTimeout), time.Duration(cfg.IdleTimeout))
+	}
+	for pattern, d := range cfg.MethodTimeouts {
+		if dot := strings.LastIndex(pattern, "."); dot <= 0 || dot == len(pattern)-1 {
+			fail("method timeout %q: expect \"Service.Method\" or \"Service.*\"", pattern)
+		}
+		if d <= 0 {
+			fail("method timeout %q: expect a positive duration", pattern)
+		}
+	}
+	if r := cfg.Registry; r != nil && len(r.URLs) == 0 {
+		fail("registry without urls")
+	}
+	if d := cfg.Debug; d != nil && d.Address == "" {
+		fail("debug without address")
+	}
+	return errs
+}
+
+// options returns the ListenerOptions of l, loading its TLS files.
+func (l ListenerConfig) options() (*ListenerOptions, error) {
+	lopt := &ListenerOptions{
+		ProxyProtocol: l.ProxyProtocol,
+		Codecs:        l.Codecs,
+		MaxBodySize:   l.MaxBodySize,
+		IdleTimeout:   time.Duration(l.IdleTimeout),
+	}
+	if l.TLSCert == "" || l.TLSKey == "" {
+		return lopt, nil
+	}
+	cert, err := tls.LoadX509KeyPair(l.TLSCert, l.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("rpc server: config: listener %s: %v", l.Address, err)
+	}
+	lopt.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	if l.TLSClientCA != "" {
+		pem, err := os.ReadFile(l.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("rpc server: config: listener %s: %v", l.Address, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("rpc server: config: listener %s: no certificate in %s", l.Address, l.TLSClientCA)
+		}
+		lopt.TLSConfig.ClientCAs = pool
+		lopt.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return lopt, nil
+}
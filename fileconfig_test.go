@@ -0,0 +1,91 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"tinyrpc/codec"
+)
+
+func TestNewServerFromConfig(t *testing.T) {
+	cfg, err := ParseFileConfig([]byte(`{
+		"listeners": [{"address": "tcp@127.0.0.1:0", "max_body_size": 100}],
+		"handle_timeout": "5s",
+		"method_timeouts": {"Blob.*": "1s"},
+		"debug": {"address": "127.0.0.1:0"}
+	}`))
+	_assert(err == nil, "parse error: %v", err)
+	_assert(time.Duration(cfg.HandleTimeout) == 5*time.Second, "expect the handle timeout parsed, but got %s", time.Duration(cfg.HandleTimeout))
+	server, listeners, err := NewServerFromConfig(cfg)
+	_assert(err == nil, "config error: %v", err)
+	_assert(len(listeners) == 2, "expect the rpc and the debug listeners, but got %d", len(listeners))
+	_ = server.Register(Blob{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- server.Run(ctx) }()
+	defer func() { _ = listeners[1].Close() }()
+
+	client, err := Dial("tcp", listeners[0].Addr().String(), &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var n int
+	_assert(client.Call("Blob.Len", make([]byte, 10), &n) == nil && n == 10, "failed to call Blob.Len")
+	err = client.Call("Blob.Len", make([]byte, 1000), &n)
+	_assert(errors.Is(err, ErrBodyTooLarge), "expect the listener's body limit, but got %v", err)
+
+	resp, err := http.Get("http://" + listeners[1].Addr().String() + DefaultDebugPrefix + "/services")
+	_assert(err == nil, "debug error: %v", err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	_assert(resp.StatusCode == http.StatusOK && strings.Contains(string(body), "Blob"),
+		"expect the debug page to list Blob, but got %d: %s", resp.StatusCode, body)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expect Run to return once canceled")
+	}
+}
+
+func TestParseFileConfig_UnknownField(t *testing.T) {
+	_, err := ParseFileConfig([]byte(`{"listeners": [{"address": ":0"}], "max_conections": 10}`))
+	_assert(err != nil && strings.Contains(err.Error(), "max_conections"), "expect the typo reported, but got %v", err)
+	_, err = ParseFileConfig([]byte(`{"handle_timeout": "5 seconds"}`))
+	_assert(err != nil, "expect an invalid duration reported")
+}
+
+func TestNewServerFromConfig_Invalid(t *testing.T) {
+	_, _, err := NewServerFromConfig(FileConfig{
+		Listeners: []ListenerConfig{
+			{Address: "127.0.0.1:0", TLSCert: "cert.pem", ProxyProtocol: true},
+			{Address: "127.0.0.1:0", Codecs: []codec.Type{"application/unknown"}},
+		},
+		MaxBodySize:         -1,
+		IdleTimeout:         Duration(time.Second),
+		FirstRequestTimeout: Duration(time.Minute),
+		PriorityAging:       Duration(time.Second),
+		MethodTimeouts:      map[string]Duration{"Foo": Duration(time.Second)},
+		Registry:            &RegistryFileConfig{ServiceName: "foo"},
+	})
+	var errs ValidationErrors
+	_assert(errors.As(err, &errs), "expect ValidationErrors, but got %v", err)
+	for _, want := range []string{
+		"listed twice",
+		"tls_cert and tls_key",
+		"proxy_protocol with TLS",
+		"unknown codec",
+		"negative max_body_size",
+		"exceeds idle_timeout",
+		"priority_aging without workers",
+		`method timeout "Foo"`,
+		"registry without urls",
+	} {
+		_assert(strings.Contains(err.Error(), want), "expect %q reported, but got %v", want, err)
+	}
+}
@@ -0,0 +1,75 @@
+package tinyrpc
+
+import (
+	"context"
+	"net/http"
+)
+
+// HealthCheckMethod is the method of the built-in health service, which
+// replies whether the server, or the service named by its argument if
+// not empty, is ready to take calls. See SetReady.
+const HealthCheckMethod = "_health_.Check"
+
+// SetReady sets whether the server is ready to take calls, true until
+// then. It doesn't change what the server serves, only what its health
+// service and the /readyz endpoint of HandleDebug answer, e.g. so that
+// the balancers stop sending it calls while it warms up. Shutdown sets
+// it to false before it drains the connections.
+func (server *Server) SetReady(ready bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.notReady = !ready
+}
+
+// SetReadyFor sets whether the service name is ready to take calls, on
+// top of the readiness of the server. The registered services are ready
+// until then.
+func (server *Server) SetReadyFor(service string, ready bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.readyFor == nil {
+		server.readyFor = make(map[string]bool)
+	}
+	server.readyFor[service] = ready
+}
+
+// Ready reports whether the server is ready to take calls, and service
+// too if not empty: false once Shutdown began, and for the services
+// neither registered nor set with SetReadyFor.
+func (server *Server) Ready(service string) bool {
+	server.mu.Lock()
+	if server.notReady || server.shutdown {
+		server.mu.Unlock()
+		return false
+	}
+	ready, set := server.readyFor[service]
+	server.mu.Unlock()
+	if service == "" || set {
+		return service == "" || ready
+	}
+	_, registered := server.serviceMap.Load(service)
+	return registered
+}
+
+// healthService is the built-in service "_health_".
+type healthService struct{ server *Server }
+
+func (h *healthService) Check(ctx context.Context, service string, ready *bool) error {
+	*ready = h.server.Ready(service)
+	return nil
+}
+
+// serveHealthz answers that the process is up.
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// serveReadyz answers whether the server, and the service of the
+// "service" query parameter if any, are ready, with 503 if not.
+func (server *Server) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	if !server.Ready(r.URL.Query().Get("service")) {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ready\n"))
+}
@@ -0,0 +1,51 @@
+package tinyrpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer_Readiness(t *testing.T) {
+	server := NewServer(WithDebugAuth(BasicAuth("user", "password")))
+	addr := startServer(t, server).Addr().String()
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	mux := http.NewServeMux()
+	server.HandleDebug(mux, "")
+
+	// the probes take no credentials
+	probe := func(path string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code
+	}
+	check := func(service string) bool {
+		var ready bool
+		err := client.Call(HealthCheckMethod, service, &ready)
+		_assert(err == nil, "health check error: %v", err)
+		return ready
+	}
+	_assert(check("") && check("Foo") && !check("Bar"), "expect the server and its services ready")
+	_assert(probe("/debug/readyz") == http.StatusOK && probe("/debug/readyz?service=Foo") == http.StatusOK, "expect /readyz ready")
+	_assert(probe("/debug/readyz?service=Bar") == http.StatusServiceUnavailable, "expect an unknown service not ready")
+
+	server.SetReadyFor("Foo", false)
+	_assert(check("") && !check("Foo"), "expect Foo alone not ready")
+	_assert(probe("/debug/readyz?service=Foo") == http.StatusServiceUnavailable, "expect Foo not ready on /readyz")
+	server.SetReadyFor("Foo", true)
+
+	server.SetReady(false)
+	_assert(!check("") && !check("Foo"), "expect nothing ready")
+	_assert(probe("/debug/readyz") == http.StatusServiceUnavailable, "expect /readyz not ready")
+	_assert(probe("/debug/healthz") == http.StatusOK, "expect /healthz up while not ready")
+	server.SetReady(true)
+	_assert(check(""), "expect the server ready again")
+
+	_ = server.Shutdown(context.Background())
+	_assert(!server.Ready("") && probe("/debug/readyz") == http.StatusServiceUnavailable, "expect not ready once shut down")
+	_assert(probe("/debug/healthz") == http.StatusOK, "expect /healthz up during shutdown")
+	_assert(probe("/debug/vars") == http.StatusUnauthorized, "expect the other endpoints still authorized")
+}
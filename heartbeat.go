@@ -0,0 +1,78 @@
+package tinyrpc
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+	"tinyrpc/rpclog"
+)
+
+// DefaultHeartbeatIdle is how long a client connection may stay idle before
+// the client pings the server, e.g. to keep NAT mappings alive. It is
+// independent from the TCP keepalive set by SocketOptions.KeepAlive.
+const DefaultHeartbeatIdle = 60 * time.Second
+
+func (client *Client) heartbeatIdle() time.Duration {
+	if client.opt.HeartbeatIdle == 0 {
+		return DefaultHeartbeatIdle
+	}
+	return client.opt.HeartbeatIdle
+}
+
+// LastActivity returns when the client last sent or received a frame.
+func (client *Client) LastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&client.lastActivity))
+}
+
+func (client *Client) touch() {
+	atomic.StoreInt64(&client.lastActivity, client.clock.Now().UnixNano())
+}
+
+// heartbeat pings the server whenever the connection has been idle for
+// heartbeatIdle, and closes the connection if the ping gets no answer
+// within the same period.
+func (client *Client) heartbeat() {
+	idle := client.heartbeatIdle()
+	for {
+		wait := idle - client.clock.Now().Sub(client.LastActivity())
+		if wait <= 0 {
+			if !client.ping(idle) {
+				return
+			}
+			continue
+		}
+		timer := client.clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-client.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// ping reports whether the server answered in time. Any answer counts,
+// even an error from a server without the built-in ping service.
+func (client *Client) ping(timeout time.Duration) bool {
+	var reply int
+	call := client.Go("_ping_.Ping", 0, &reply, make(chan *Call, 1))
+	timer := client.clock.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case call = <-call.Done:
+		if call.Error == ErrShutdown || call.Error == ErrGoAway {
+			return false
+		}
+		client.touch() // an answer proves the connection is alive
+		return true
+	case <-timer.C():
+		client.config.log(rpclog.LevelWarn, "heartbeat timeout, closing connection")
+		client.mu.Lock()
+		client.closeErr = fmt.Errorf("rpc client: heartbeat timeout: %w", ErrDeadlineExceeded)
+		client.mu.Unlock()
+		_ = client.cc.Close()
+		return false
+	case <-client.done:
+		return false
+	}
+}
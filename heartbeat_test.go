@@ -0,0 +1,55 @@
+package tinyrpc
+
+import (
+	"net"
+	"testing"
+	"time"
+	"tinyrpc/tinyrpctest"
+)
+
+func dialFakeClock(t *testing.T, clock *tinyrpctest.Clock, idle time.Duration) *Client {
+	t.Helper()
+	c, s := net.Pipe()
+	go NewServer().ServeConn(s)
+	opt, _ := parseOptions(&Option{HeartbeatIdle: idle, Clock: clock})
+	client, err := NewClient(c, opt)
+	if err != nil {
+		t.Fatal("handshake error:", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+// waitActivity waits in real time for the heartbeat goroutine to catch up.
+func waitActivity(client *Client, want time.Time) bool {
+	for i := 0; i < 100; i++ {
+		if client.LastActivity().Equal(want) {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func TestHeartbeat(t *testing.T) {
+	clock := tinyrpctest.NewClock()
+	start := clock.Now()
+	client := dialFakeClock(t, clock, 0)
+
+	clock.Advance(30 * time.Second)
+	time.Sleep(20 * time.Millisecond)
+	_assert(client.LastActivity().Equal(start), "expect no ping before DefaultHeartbeatIdle")
+
+	clock.Advance(31 * time.Second)
+	_assert(waitActivity(client, clock.Now()), "expect a ping after DefaultHeartbeatIdle")
+	_assert(client.IsAvailable(), "expect the client to stay available")
+}
+
+func TestHeartbeat_Disabled(t *testing.T) {
+	clock := tinyrpctest.NewClock()
+	start := clock.Now()
+	client := dialFakeClock(t, clock, -1)
+	clock.Advance(10 * time.Minute)
+	time.Sleep(20 * time.Millisecond)
+	_assert(client.LastActivity().Equal(start), "expect no ping when disabled")
+}
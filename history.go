@@ -0,0 +1,379 @@
+package tinyrpc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"tinyrpc/codec"
+	"tinyrpc/internal/clock"
+	"tinyrpc/rpclog"
+)
+
+// Defaults of HistoryConfig.
+const (
+	DefaultHistoryFrames     = 64
+	DefaultHistoryBodyPrefix = 64
+)
+
+// HistoryConfig tunes ServerConfig.FrameHistory.
+type HistoryConfig struct {
+	// Frames is how many of the last frames of a connection are kept.
+	// DefaultHistoryFrames if 0.
+	Frames int
+	// BodyPrefix is how many bytes of the text of each body are kept.
+	// DefaultHistoryBodyPrefix if 0, none if negative.
+	BodyPrefix int
+	// Redact, if not nil, is called with every frame before it is kept,
+	// e.g. to remove credentials from its Metadata or Body. The frame is
+	// a copy, its Metadata included.
+	Redact func(f *Frame)
+}
+
+// WithFrameHistory sets ServerConfig.FrameHistory, with cfg.
+func WithFrameHistory(cfg HistoryConfig) ServerOption {
+	return func(c *ServerConfig) error {
+		if cfg.Frames < 0 {
+			return fmt.Errorf("rpc server: negative history of %d frames", cfg.Frames)
+		}
+		c.FrameHistory, c.History = true, cfg
+		return nil
+	}
+}
+
+// Frame is a frame read or written on a connection, as kept in its
+// history, see ServerConfig.FrameHistory.
+type Frame struct {
+	Time          time.Time
+	Inbound       bool // read from the client, else written to it
+	ServiceMethod string
+	Seq           uint64
+	Error         string // of the header
+	Metadata      map[string]string
+	BodySize      int64  // encoded, -1 if the codec doesn't report it
+	Body          string // the start of the text of the body, see HistoryConfig.BodyPrefix
+	Err           string // reading or writing the frame, if it failed
+}
+
+func (f Frame) String() string {
+	var b strings.Builder
+	if f.Inbound {
+		b.WriteString("in ")
+	} else {
+		b.WriteString("out ")
+	}
+	b.WriteString(f.Time.Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, " %s seq=%d", f.ServiceMethod, f.Seq)
+	if f.Error != "" {
+		b.WriteString(" error=" + strconv.Quote(f.Error))
+	}
+	if len(f.Metadata) > 0 {
+		fmt.Fprintf(&b, " metadata=%v", f.Metadata)
+	}
+	if f.BodySize >= 0 {
+		fmt.Fprintf(&b, " size=%d", f.BodySize)
+	}
+	if f.Body != "" {
+		b.WriteString(" body=" + strconv.Quote(f.Body))
+	}
+	if f.Err != "" {
+		b.WriteString(" err=" + strconv.Quote(f.Err))
+	}
+	return b.String()
+}
+
+// frameHistory is the ring of the last frames of a connection.
+type frameHistory struct {
+	prefix int
+	redact func(f *Frame)
+	now    func() time.Time
+
+	mu   sync.Mutex // protect following
+	ring []Frame
+	next int
+	full bool
+}
+
+func newFrameHistory(cfg HistoryConfig, c clock.Clock) *frameHistory {
+	n := cfg.Frames
+	if n == 0 {
+		n = DefaultHistoryFrames
+	}
+	prefix := cfg.BodyPrefix
+	if prefix == 0 {
+		prefix = DefaultHistoryBodyPrefix
+	}
+	return &frameHistory{prefix: prefix, redact: cfg.Redact, now: clock.Or(c).Now, ring: make([]Frame, n)}
+}
+
+// add keeps the frame of h, its body and err.
+func (fh *frameHistory) add(inbound bool, h *codec.Header, body interface{}, size int64, err error) {
+	f := Frame{
+		Time:          fh.now(),
+		Inbound:       inbound,
+		ServiceMethod: h.ServiceMethod,
+		Seq:           h.Seq,
+		Error:         h.Error,
+		BodySize:      size,
+		Body:          fh.bodyText(body),
+	}
+	if len(h.Metadata) > 0 {
+		f.Metadata = make(map[string]string, len(h.Metadata))
+		for k, v := range h.Metadata {
+			f.Metadata[k] = v
+		}
+	}
+	if err != nil {
+		f.Err = err.Error()
+	}
+	if fh.redact != nil {
+		fh.redact(&f)
+	}
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	fh.ring[fh.next] = f
+	if fh.next++; fh.next == len(fh.ring) {
+		fh.next, fh.full = 0, true
+	}
+}
+
+// bodyText returns the start of the text of body, Go syntax for bytes,
+// with pointers followed. Only the start of the text is formatted.
+func (fh *frameHistory) bodyText(body interface{}) string {
+	if fh.prefix < 0 || body == nil {
+		return ""
+	}
+	var raw []byte
+	switch b := body.(type) {
+	case []byte:
+		raw = b
+	case *[]byte:
+		raw = *b
+	case codec.Encoded:
+		raw = b
+	case *codec.Encoded:
+		raw = *b
+	case string:
+		return truncate(b, fh.prefix)
+	case *string:
+		return truncate(*b, fh.prefix)
+	default:
+		v := reflect.ValueOf(body)
+		for v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem() // the value, not its address
+		}
+		w := &prefixWriter{n: fh.prefix}
+		w.value(v)
+		if w.full {
+			return string(w.buf) + "..."
+		}
+		return string(w.buf)
+	}
+	if len(raw) > fh.prefix {
+		return fmt.Sprintf("%q...", raw[:fh.prefix])
+	}
+	return fmt.Sprintf("%q", raw)
+}
+
+// errPrefixFull stops formatting into a full prefixWriter.
+var errPrefixFull = errors.New("prefix full")
+
+// prefixWriter keeps the first n bytes written to it.
+type prefixWriter struct {
+	buf  []byte
+	n    int
+	full bool // more than n bytes written
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	if room := w.n - len(w.buf); len(p) > room {
+		w.buf = append(w.buf, p[:room]...)
+		w.full = true
+		return room, errPrefixFull
+	}
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+// value writes v like %+v, until w is full: structs, slices and arrays
+// one field or element at a time, so the rest of a large body is never
+// formatted.
+func (w *prefixWriter) value(v reflect.Value) {
+	if w.full {
+		return
+	}
+	if v.CanInterface() {
+		switch v.Interface().(type) {
+		case fmt.Formatter, fmt.Stringer, error:
+			_, _ = fmt.Fprintf(w, "%+v", v)
+			return
+		}
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		_, _ = io.WriteString(w, "{")
+		for i := 0; i < v.NumField() && !w.full; i++ {
+			if i > 0 {
+				_, _ = io.WriteString(w, " ")
+			}
+			_, _ = io.WriteString(w, v.Type().Field(i).Name+":")
+			w.value(v.Field(i))
+		}
+		_, _ = io.WriteString(w, "}")
+	case reflect.Slice, reflect.Array:
+		_, _ = io.WriteString(w, "[")
+		for i := 0; i < v.Len() && !w.full; i++ {
+			if i > 0 {
+				_, _ = io.WriteString(w, " ")
+			}
+			w.value(v.Index(i))
+		}
+		_, _ = io.WriteString(w, "]")
+	case reflect.String:
+		_, _ = io.WriteString(w, v.String())
+	default:
+		_, _ = fmt.Fprintf(w, "%+v", v)
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// frames returns the frames kept, oldest first, nil if fh is.
+func (fh *frameHistory) frames() []Frame {
+	if fh == nil {
+		return nil
+	}
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	if !fh.full {
+		return append([]Frame(nil), fh.ring[:fh.next]...)
+	}
+	return append(append([]Frame(nil), fh.ring[fh.next:]...), fh.ring[:fh.next]...)
+}
+
+// wrap returns cc keeping its frames in fh.
+func (fh *frameHistory) wrap(cc codec.Codec) codec.Codec {
+	c := &historyCodec{Codec: cc, fh: fh}
+	if sr, ok := cc.(codec.SizeReporter); ok {
+		return &sizedHistoryCodec{c, sr}
+	}
+	return c
+}
+
+// historyCodec keeps the frames of its codec in a frameHistory. Reads
+// are made by one goroutine at a time, so the header read last belongs
+// to the next body read. It passes the optional interfaces of the codec
+// on, or what the callers of a codec without them do.
+type historyCodec struct {
+	codec.Codec
+	fh     *frameHistory
+	header codec.Header // read last
+}
+
+// readSize returns the size of the body read last, -1 if unknown.
+func (c *historyCodec) readSize() int64 {
+	if sr, ok := c.Codec.(codec.SizeReporter); ok {
+		return sr.ReadBodySize()
+	}
+	return -1
+}
+
+func (c *historyCodec) ReadHeader(h *codec.Header) error {
+	err := c.Codec.ReadHeader(h)
+	c.header = *h
+	if err != nil && err != io.EOF {
+		c.fh.add(true, h, nil, -1, err)
+	}
+	return err
+}
+
+func (c *historyCodec) ReadBody(body interface{}) error {
+	err := c.Codec.ReadBody(body)
+	if err != nil {
+		body = nil
+	}
+	c.fh.add(true, &c.header, body, c.readSize(), err)
+	return err
+}
+
+func (c *historyCodec) DiscardBody() error {
+	err := codec.DiscardBody(c.Codec)
+	c.fh.add(true, &c.header, nil, c.readSize(), err)
+	return err
+}
+
+func (c *historyCodec) ReadRawBody() ([]byte, error) {
+	r, ok := c.Codec.(codec.RawReader)
+	if !ok {
+		return nil, codec.ErrNotFramed
+	}
+	data, err := r.ReadRawBody()
+	if err != codec.ErrNotFramed {
+		c.fh.add(true, &c.header, data, int64(len(data)), err)
+	}
+	return data, err
+}
+
+func (c *historyCodec) ReadBodyDeferred() (func(v interface{}) error, error) {
+	d, ok := c.Codec.(codec.DeferredReader)
+	if !ok {
+		return nil, nil
+	}
+	decode, err := d.ReadBodyDeferred()
+	if decode != nil || err != nil {
+		c.fh.add(true, &c.header, nil, c.readSize(), err) // not decoded yet
+	}
+	return decode, err
+}
+
+func (c *historyCodec) Write(h *codec.Header, body interface{}) error {
+	err := c.Codec.Write(h, body)
+	c.fh.add(false, h, body, -1, err)
+	return err
+}
+
+func (c *historyCodec) WriteEncoded(h *codec.Header, raw []byte) error {
+	var err error
+	if w, ok := c.Codec.(codec.EncodedWriter); ok {
+		err = w.WriteEncoded(h, raw)
+	} else if h.BodyCodec != "" {
+		err = c.Codec.Write(h, codec.Encoded(raw))
+	} else {
+		err = c.Codec.Write(h, raw)
+	}
+	c.fh.add(false, h, raw, int64(len(raw)), err)
+	return err
+}
+
+// sizedHistoryCodec is a historyCodec of a codec.SizeReporter.
+type sizedHistoryCodec struct {
+	*historyCodec
+	sr codec.SizeReporter
+}
+
+func (c *sizedHistoryCodec) ReadBodySize() int64 {
+	return c.sr.ReadBodySize()
+}
+
+func (c *sizedHistoryCodec) WriteSized(h *codec.Header, body interface{}) (int64, error) {
+	n, err := c.sr.WriteSized(h, body)
+	c.fh.add(false, h, body, n, err)
+	return n, err
+}
+
+// logHistory logs the frames kept of sc, e.g. before it is closed for
+// breaking its stream.
+func (server *Server) logHistory(sc *serverConn) {
+	for _, f := range sc.history.frames() {
+		server.log(rpclog.LevelWarn, "frame history", "conn", sc.id, "frame", f.String())
+	}
+}
@@ -0,0 +1,151 @@
+package tinyrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"tinyrpc/codec"
+	"tinyrpc/rpclog"
+)
+
+// historyLogger keeps the frames of the history logged.
+type historyLogger struct {
+	mu     sync.Mutex
+	frames []string
+}
+
+func (l *historyLogger) Log(level rpclog.Level, subsystem, msg string, kv ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := 0; msg == "frame history" && i+1 < len(kv); i += 2 {
+		if kv[i] == "frame" {
+			l.frames = append(l.frames, kv[i+1].(string))
+		}
+	}
+}
+
+// has reports whether a frame logged starts with prefix and contains all
+// of parts.
+func (l *historyLogger) has(prefix string, parts ...string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+next:
+	for _, f := range l.frames {
+		if !strings.HasPrefix(f, prefix) {
+			continue
+		}
+		for _, p := range parts {
+			if !strings.Contains(f, p) {
+				continue next
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// corruptStream returns the handshake and three calls to Foo.Sum, then
+// a fourth one whose body breaks the gob stream.
+func corruptStream() []byte {
+	var stream bytes.Buffer
+	_ = json.NewEncoder(&stream).Encode(DefaultOption)
+	enc := gob.NewEncoder(&stream)
+	for seq := uint64(1); seq <= 3; seq++ {
+		_ = enc.Encode(&codec.Header{ServiceMethod: "Foo.Sum", Seq: seq, Metadata: map[string]string{"token": "secret", "trace": "t1"}})
+		_ = enc.Encode(Args{Num1: int(seq), Num2: 2})
+	}
+	_ = enc.Encode(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 4})
+	_, _ = stream.Write(badLength)
+	return stream.Bytes()
+}
+
+func TestServer_FrameHistoryLogged(t *testing.T) {
+	logger := &historyLogger{}
+	server := NewServer(WithLogger(logger), WithFrameHistory(HistoryConfig{Redact: func(f *Frame) {
+		delete(f.Metadata, "token")
+	}}))
+	var foo Foo
+	_ = server.Register(&foo)
+	server.ServeConn(memConn{Reader: bytes.NewReader(corruptStream()), Writer: io.Discard})
+
+	for _, seq := range []string{" seq=1 ", " seq=2 ", " seq=3 "} {
+		_assert(logger.has("in ", "Foo.Sum"+seq, "body=\"{Num1:"), "expect the request of%sin the history, but got %q", seq, logger.frames)
+	}
+	_assert(logger.has("in ", "Foo.Sum seq=4 ", "err="), "expect the corrupt frame in the history, but got %q", logger.frames)
+	_assert(logger.has("in ", "trace:t1") && !logger.has("", "secret"), "expect the metadata redacted, but got %q", logger.frames)
+	_assert(server.Stats().CorruptedStreams == 1, "expect the stream counted corrupt")
+
+	// bounded to the last frames
+	logger = &historyLogger{}
+	server = NewServer(WithLogger(logger), WithFrameHistory(HistoryConfig{Frames: 4, BodyPrefix: -1}))
+	_ = server.Register(&foo)
+	server.ServeConn(memConn{Reader: bytes.NewReader(corruptStream()), Writer: io.Discard})
+	_assert(len(logger.frames) == 4, "expect the last 4 frames, but got %q", logger.frames)
+	_assert(logger.has("in ", "seq=4 ") && !logger.has("", "body="), "expect the last frame read without bodies, but got %q", logger.frames)
+
+	// off by default
+	logger = &historyLogger{}
+	server = NewServer(WithLogger(logger))
+	_ = server.Register(&foo)
+	server.ServeConn(memConn{Reader: bytes.NewReader(corruptStream()), Writer: io.Discard})
+	_assert(len(logger.frames) == 0, "expect no history by default, but got %q", logger.frames)
+}
+
+func TestServer_FrameHistoryDescribed(t *testing.T) {
+	server := NewServer(WithFrameHistory(HistoryConfig{BodyPrefix: 8}))
+	server.EnableAdmin(func(context.Context, string) error { return nil })
+	addr := startServer(t, server).Addr().String()
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	_assert(client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply, WithHeader("k", "v")) == nil, "failed to call Foo.Sum")
+
+	admin, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = admin.Close() }()
+	var conns []ConnInfo
+	_assert(admin.Call("_admin_.ListConnections", 0, &conns) == nil && len(conns) == 2, "expect 2 connections, but got %v", conns)
+	_assert(conns[0].History == nil, "expect no history in the list")
+	var info ConnInfo
+	_assert(admin.Call("_admin_.DescribeConnection", conns[0].ID, &info) == nil, "failed to describe the connection")
+
+	var in, out *Frame
+	for i, f := range info.History {
+		if f.ServiceMethod == "Foo.Sum" && f.Inbound {
+			in = &info.History[i]
+		} else if f.ServiceMethod == "Foo.Sum" {
+			out = &info.History[i]
+		}
+	}
+	_assert(in != nil && in.Metadata["k"] == "v" && in.Body == "{Num1:1 ..." && in.BodySize > 0,
+		"expect the request in the history, but got %+v", info.History)
+	_assert(out != nil && out.Body == "3" && out.BodySize > 0 && !out.Time.Before(in.Time),
+		"expect the response in the history, but got %+v", info.History)
+}
+
+// countedText counts the times it is formatted.
+type countedText struct{ n *int }
+
+func (c countedText) String() string {
+	*c.n++
+	return "text"
+}
+
+func TestFrameHistory_BodyPrefix(t *testing.T) {
+	fh := newFrameHistory(HistoryConfig{BodyPrefix: 12}, nil)
+	var n int
+	body := make([]countedText, 1000)
+	for i := range body {
+		body[i] = countedText{&n}
+	}
+	text := fh.bodyText(&body)
+	_assert(text == "[text text t..." && n < 5, "expect only the prefix formatted, but got %q after %d elements", text, n)
+	text = fh.bodyText(Args{Num1: 1, Num2: 2})
+	_assert(text == "{Num1:1 Num2...", "expect a struct formatted like %%+v, but got %q", text)
+}
@@ -0,0 +1,108 @@
+// Package clock is the source of time of tinyrpc, so that tests can
+// replace the real clock, see tinyrpctest.Clock.
+package clock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and makes timers.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a time.Timer of a Clock.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// Ticker is a time.Ticker of a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the clock of the time package.
+var Real Clock = realClock{}
+
+// Or returns c, or Real if c is nil.
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// WithTimeout is context.WithTimeout, timed by c: the deadline of the
+// context is c.Now()+d and it is done when a timer of c fires.
+func WithTimeout(c Clock, parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := c.(realClock); ok {
+		return context.WithTimeout(parent, d)
+	}
+	deadline := c.Now().Add(d)
+	if cur, ok := parent.Deadline(); ok && cur.Before(deadline) {
+		return context.WithCancel(parent) // the parent is done first
+	}
+	ctx, cancel := context.WithCancel(parent)
+	tc := &timerCtx{Context: ctx, deadline: deadline}
+	t := c.NewTimer(d)
+	go func() {
+		select {
+		case <-t.C():
+			tc.mu.Lock()
+			tc.err = context.DeadlineExceeded
+			tc.mu.Unlock()
+			cancel()
+		case <-ctx.Done():
+			t.Stop()
+		}
+	}()
+	return tc, cancel
+}
+
+// timerCtx is a context with a deadline of a Clock other than Real.
+type timerCtx struct {
+	context.Context
+	deadline time.Time
+	mu       sync.Mutex // protect following
+	err      error
+}
+
+func (c *timerCtx) Deadline() (time.Time, bool) { return c.deadline, true }
+
+func (c *timerCtx) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return c.Context.Err()
+}
+
+// Until is time.Until, timed by c.
+func Until(c Clock, t time.Time) time.Duration {
+	return t.Sub(c.Now())
+}
@@ -0,0 +1,106 @@
+package tinyrpc
+
+import (
+	"time"
+	"tinyrpc/codec"
+	"tinyrpc/internal/clock"
+)
+
+// progressMethod is the ServiceMethod of the frames a server sends while
+// it handles a call whose client asked for them, so that the connection
+// is not idle. They carry the Seq of the call and no body, and are
+// followed by the response.
+const progressMethod = "_ctrl_.Progress"
+
+// progressHeader in the metadata of a request asks for progress frames.
+// Older servers ignore it.
+const progressHeader = "tinyrpc-progress"
+
+// SetProgressInterval makes the server send a progress frame every d
+// while it handles a call made with WithProgressKeepalive, e.g. so that
+// proxies don't close connections idle during long calls. No frames if 0,
+// the default. It must be called before the server starts serving.
+func (server *Server) SetProgressInterval(d time.Duration) {
+	server.progressInterval = d
+}
+
+// keepAlive sends progress frames for req until the function it returns
+// is called, if the client asked for them.
+func (server *Server) keepAlive(sc *serverConn, req *request) func() {
+	d := server.progressInterval
+	if d <= 0 || req.h.Metadata[progressHeader] == "" {
+		return func() {}
+	}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := clock.Or(server.clock).NewTicker(d)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				server.sendResponse(sc.writer(req), &codec.Header{ServiceMethod: progressMethod, Seq: req.h.Seq}, invalidRequest)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped // no progress frame after the response
+	}
+}
+
+type progressOption struct{ idle time.Duration }
+
+func (o progressOption) before(call *Call) {
+	if call.Metadata == nil {
+		call.Metadata = make(Metadata)
+	}
+	call.Metadata[progressHeader] = "1"
+	call.progress = make(chan struct{}, 1)
+	call.progressIdle = o.idle
+}
+
+func (progressOption) after(*Call) {}
+
+// WithProgressKeepalive asks the server to send progress frames while it
+// handles the call, see Server.SetProgressInterval. If idle is not 0, the
+// call fails with ErrDeadlineExceeded when neither a progress frame nor
+// the response arrives for idle, instead of waiting for a server that
+// stopped making progress. The deadline of the context still applies.
+func WithProgressKeepalive(idle time.Duration) CallOption {
+	return progressOption{idle}
+}
+
+// progress notes a progress frame for the call seq, with its metadata md,
+// if it is pending.
+func (client *Client) progress(seq uint64, md Metadata) {
+	client.mu.Lock()
+	call := client.pending[seq]
+	client.mu.Unlock()
+	if call == nil {
+		return
+	}
+	if r, ok := progressReport(md); ok && call.onProgress != nil {
+		call.onProgress(r)
+	}
+	if call.progress == nil {
+		return
+	}
+	select {
+	case call.progress <- struct{}{}:
+	default: // one is already noted
+	}
+}
+
+// hasProgressKeepalive reports whether opts has WithProgressKeepalive
+// with an idle limit, which Call then enforces.
+func hasProgressKeepalive(opts []CallOption) bool {
+	for _, opt := range opts {
+		if o, ok := opt.(progressOption); ok && o.idle > 0 {
+			return true
+		}
+	}
+	return false
+}
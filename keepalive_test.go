@@ -0,0 +1,102 @@
+package tinyrpc
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tinyrpc/tinyrpctest"
+)
+
+// idleProxy forwards connections to addr and closes those idle in both
+// directions for idle, like a NAT or a load balancer.
+func idleProxy(t *testing.T, addr string, idle time.Duration) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	t.Cleanup(func() { _ = lis.Close() })
+	go func() {
+		for {
+			in, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			out, err := net.Dial("tcp", addr)
+			if err != nil {
+				_ = in.Close()
+				continue
+			}
+			last := time.Now().UnixNano()
+			pipe := func(dst, src net.Conn) {
+				defer func() { _ = in.Close(); _ = out.Close() }()
+				buf := make([]byte, 4096)
+				for {
+					_ = src.SetReadDeadline(time.Now().Add(idle))
+					n, err := src.Read(buf)
+					if ne, ok := err.(net.Error); ok && ne.Timeout() {
+						if time.Since(time.Unix(0, atomic.LoadInt64(&last))) < idle {
+							continue // the other direction is active
+						}
+						return
+					}
+					if err != nil {
+						return
+					}
+					atomic.StoreInt64(&last, time.Now().UnixNano())
+					if _, err := dst.Write(buf[:n]); err != nil {
+						return
+					}
+				}
+			}
+			go pipe(out, in)
+			go pipe(in, out)
+		}
+	}()
+	return lis.Addr().String()
+}
+
+func TestServer_ProgressKeepalive(t *testing.T) {
+	// a 500ms call, through a proxy closing connections idle for 200ms
+	call := func(progress time.Duration) error {
+		server := NewServer()
+		var slow Slow
+		_ = server.Register(&slow)
+		server.SetProgressInterval(progress)
+		addr := idleProxy(t, startServer(t, server).Addr().String(), 200*time.Millisecond)
+		client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1})
+		if err != nil {
+			t.Fatal("dial error:", err)
+		}
+		defer func() { _ = client.Close() }()
+		var reply int
+		return client.Call("Slow.Sleep", 500, &reply, WithProgressKeepalive(0))
+	}
+	_assert(call(100*time.Millisecond) == nil, "expect the call kept alive by progress frames")
+	_assert(call(0) != nil, "expect the idle connection closed by the proxy")
+}
+
+func TestWithProgressKeepalive_Idle(t *testing.T) {
+	server := NewServer()
+	var slow Slow
+	_ = server.Register(&slow)
+	addr := startServer(t, server).Addr().String() // sends no progress frames
+	clock := tinyrpctest.NewClock()
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1, Clock: clock})
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	errc := make(chan error, 1)
+	go func() {
+		var reply int
+		errc <- client.Call("Slow.Sleep", 1000, &reply, WithProgressKeepalive(time.Second))
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	err = <-errc
+	_assert(errors.Is(err, ErrDeadlineExceeded), "expect ErrDeadlineExceeded without progress, but got %v", err)
+}
@@ -0,0 +1,76 @@
+package tinyrpc
+
+import (
+	"fmt"
+	"time"
+)
+
+// LimitsConfig are the limits of a server that can change while it
+// serves, see UpdateLimits.
+type LimitsConfig struct {
+	MaxBodySize   int64          // see ServerConfig.MaxBodySize
+	MaxInFlight   int            // see ServerConfig.MaxInFlight
+	HandleTimeout time.Duration  // see ServerConfig.HandleTimeout
+	IP            IPLimits       // see SetIPLimits
+	Responses     ResponseLimits // see SetResponseLimits
+	WarnOnly      WarnOnly       // see ServerConfig.WarnOnly
+}
+
+func (l LimitsConfig) validate() error {
+	if l.MaxBodySize < 0 {
+		return fmt.Errorf("rpc server: negative max body size %d", l.MaxBodySize)
+	}
+	if l.MaxInFlight < 0 {
+		return fmt.Errorf("rpc server: negative max in flight %d", l.MaxInFlight)
+	}
+	if l.HandleTimeout < 0 {
+		return fmt.Errorf("rpc server: negative handle timeout %s", l.HandleTimeout)
+	}
+	if err := l.IP.validate(); err != nil {
+		return err
+	}
+	r := l.Responses
+	if r.MaxUnsent < 0 || r.DropUnsent < 0 || r.MaxReordered < 0 || r.WriteTimeout < 0 {
+		return fmt.Errorf("rpc server: negative response limits %+v", r)
+	}
+	return nil
+}
+
+// UpdateLimits replaces the limits of the server, e.g. to react to an
+// incident without a restart. They apply from the next request read, or
+// the next connection for IP.MaxConns; the calls in flight keep the
+// limits they started with. It returns an error, and changes nothing, if
+// a limit is negative.
+func (server *Server) UpdateLimits(limits LimitsConfig) error {
+	if err := limits.validate(); err != nil {
+		return err
+	}
+	limits.IP = limits.IP.withDefaults()
+	server.live.Store(&limits)
+	return nil
+}
+
+// Limits returns the limits the server applies now.
+func (server *Server) Limits() LimitsConfig {
+	return *server.currentLimits()
+}
+
+// currentLimits returns the snapshot of the limits consulted per request.
+func (server *Server) currentLimits() *LimitsConfig {
+	if l := server.live.Load(); l != nil {
+		return l
+	}
+	return server.initialLimits()
+}
+
+// initialLimits are the limits set by the options, before UpdateLimits.
+func (server *Server) initialLimits() *LimitsConfig {
+	return &LimitsConfig{
+		MaxBodySize:   server.config.MaxBodySize,
+		MaxInFlight:   server.config.MaxInFlight,
+		HandleTimeout: server.config.HandleTimeout,
+		IP:            server.ipLimits,
+		Responses:     server.limits,
+		WarnOnly:      server.config.WarnOnly,
+	}
+}
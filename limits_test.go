@@ -0,0 +1,63 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestServer_UpdateLimits(t *testing.T) {
+	server := NewServer(WithMaxBodySize(1 << 20))
+	_assert(server.Register(Blob{}) == nil && server.Register(new(Slow)) == nil, "failed to register")
+	addr := startServer(t, server).Addr().String()
+	dial := func() *Client {
+		client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1})
+		_assert(err == nil, "dial error: %v", err)
+		t.Cleanup(func() { _ = client.Close() })
+		return client
+	}
+	client := dial()
+	var n int
+	_assert(client.Call("Blob.Len", make([]byte, 1000), &n) == nil && n == 1000, "failed to call Blob.Len")
+
+	_assert(server.UpdateLimits(LimitsConfig{MaxBodySize: 100}) == nil, "failed to update the limits")
+	err := client.Call("Blob.Len", make([]byte, 1000), &n)
+	_assert(errors.Is(err, ErrBodyTooLarge), "expect the new limit on the next call, but got %v", err)
+	_assert(dial().Call("Blob.Len", make([]byte, 50), &n) == nil && n == 50, "expect bodies under the new limit")
+
+	// the call in flight keeps the limits it started with
+	client = dial()
+	slow := client.Go("Slow.Sleep", 200, new(int), make(chan *Call, 1))
+	time.Sleep(50 * time.Millisecond)
+	_assert(server.UpdateLimits(LimitsConfig{HandleTimeout: 50 * time.Millisecond}) == nil, "failed to update the limits")
+	_assert((<-slow.Done).Error == nil, "expect the call in flight to complete")
+	err = client.Call("Slow.Sleep", 200, new(int))
+	_assert(errors.Is(err, ErrHandleTimeout), "expect the new handle timeout, but got %v", err)
+
+	err = server.UpdateLimits(LimitsConfig{HandleTimeout: -time.Second})
+	_assert(err != nil, "expect a negative timeout rejected")
+	_assert(server.Limits().HandleTimeout == 50*time.Millisecond, "expect the limits unchanged, but got %+v", server.Limits())
+}
+
+func TestServer_AdminUpdateLimits(t *testing.T) {
+	server := NewServer()
+	server.EnableAdmin(func(ctx context.Context, serviceMethod string) error {
+		if AuthTokenFromContext(ctx) != "root" {
+			return errors.New("not root")
+		}
+		return nil
+	})
+	client, err := Dial("tcp", startServer(t, server).Addr().String(), &Option{HeartbeatIdle: -1, AuthToken: "root"})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var updated bool
+	err = client.Call("_admin_.UpdateLimits", LimitsConfig{MaxBodySize: 512, IP: IPLimits{QPS: 100}}, &updated)
+	_assert(err == nil && updated, "failed to update the limits: %v", err)
+	var limits LimitsConfig
+	err = client.Call("_admin_.Limits", 0, &limits)
+	_assert(err == nil && limits.MaxBodySize == 512 && limits.IP.Burst == 100, "unexpected limits %+v, %v", limits, err)
+	err = client.Call("_admin_.UpdateLimits", LimitsConfig{MaxBodySize: -1}, &updated)
+	_assert(err != nil && server.Limits().MaxBodySize == 512, "expect a negative limit rejected, but got %v", err)
+}
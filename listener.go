@@ -0,0 +1,146 @@
+package tinyrpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"tinyrpc/codec"
+)
+
+// ListenerOptions are settings scoped to the connections accepted on
+// one listener. They compose with the settings of the server: the most
+// restrictive wins.
+type ListenerOptions struct {
+	TLSConfig     *tls.Config // serve TLS on this listener if set
+	ProxyProtocol bool        // expect PROXY protocol headers, see SetAcceptProxyProtocol
+
+	// Authenticate, if not nil, must accept the Option.AuthToken of the
+	// connections, as well as ServerConfig.Authenticate if set.
+	Authenticate func(token string) error
+	// Codecs limits the codecs accepted, among those of SetCodecs.
+	// All of them if nil.
+	Codecs []codec.Type
+	// MaxBodySize and IdleTimeout are like those of ServerConfig; the
+	// smaller one applies when both are set.
+	MaxBodySize int64
+	IdleTimeout time.Duration
+}
+
+// ErrUnauthenticated is returned by Dial when the server rejects the
+// Option.AuthToken of the client.
+var ErrUnauthenticated = errors.New("rpc: unauthenticated")
+
+// ServeWithOptions is like Accept, but serves the connections of lis with
+// o. It returns the error that stopped lis, or ErrServerClosed after
+// Shutdown.
+func (server *Server) ServeWithOptions(lis net.Listener, o ListenerOptions) error {
+	if o.TLSConfig != nil {
+		lis = tls.NewListener(lis, o.TLSConfig)
+	}
+	return server.serve(context.Background(), lis, &o)
+}
+
+// authenticate checks token against the authenticators of the server and
+// of lopt, which may be nil.
+func (server *Server) authenticate(lopt *ListenerOptions, token string) error {
+	if fn := server.config.Authenticate; fn != nil {
+		if err := fn(token); err != nil {
+			return err
+		}
+	}
+	if lopt != nil && lopt.Authenticate != nil {
+		return lopt.Authenticate(token)
+	}
+	return nil
+}
+
+// minLimit returns the smaller of two limits, where 0 means no limit.
+func minLimit(a, b int64) int64 {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+type addedListener struct {
+	lis net.Listener
+	opt *ListenerOptions
+}
+
+// AddListener adds lis to the listeners served by Run. All listeners share
+// the registered services of the server.
+func (server *Server) AddListener(lis net.Listener, opts ...*ListenerOptions) {
+	var opt *ListenerOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if opt != nil && opt.TLSConfig != nil {
+		lis = tls.NewListener(lis, opt.TLSConfig)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.added = append(server.added, addedListener{lis: lis, opt: opt})
+}
+
+// ListenerErrors holds the terminal errors of the listeners served by Run.
+type ListenerErrors []error
+
+func (errs ListenerErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Run serves every listener added by AddListener until ctx is done,
+// Shutdown is called, or all of them fail. When one listener fails the
+// others are shut down too. It returns nil after a clean shutdown,
+// otherwise the ListenerErrors of the failed listeners, or the
+// ValidationErrors of the server, serving nothing, if it fails Validate.
+func (server *Server) Run(ctx context.Context) error {
+	if err := server.Validate(); err != nil {
+		return err
+	}
+	server.mu.Lock()
+	added := server.added
+	server.added = nil
+	server.mu.Unlock()
+	if len(added) == 0 {
+		return errors.New("rpc server: no listener added")
+	}
+
+	var (
+		mu   sync.Mutex
+		errs ListenerErrors
+		wg   sync.WaitGroup
+	)
+	stop := make(chan struct{})
+	var once sync.Once
+	for _, l := range added {
+		wg.Add(1)
+		go func(l addedListener) {
+			defer wg.Done()
+			if err := server.serve(context.Background(), l.lis, l.opt); err != ErrServerClosed {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+			once.Do(func() { close(stop) })
+		}(l)
+	}
+	select {
+	case <-ctx.Done():
+	case <-stop:
+	}
+	_ = server.Shutdown(context.Background())
+	wg.Wait()
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+	"tinyrpc/codec"
+)
+
+func TestServer_Run(t *testing.T) {
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	tcpLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	path := filepath.Join(t.TempDir(), "rpc.sock")
+	unixLis, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	server.AddListener(tcpLis)
+	server.AddListener(unixLis)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Run(ctx) }()
+
+	var clients []*Client
+	for _, addr := range []string{"tcp@" + tcpLis.Addr().String(), "unix@" + path} {
+		client, err := XDial(addr)
+		if err != nil {
+			t.Fatal("dial error:", err)
+		}
+		var reply int
+		err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "failed to call Foo.Sum over %s: %v", addr, err)
+		clients = append(clients, client)
+	}
+
+	cancel()
+	select {
+	case err = <-done:
+		_assert(err == nil, "expect a clean shutdown, but got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+	for _, client := range clients {
+		var reply int
+		err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err != nil, "expect calls to fail after shutdown")
+	}
+	_, err = XDial("tcp@" + tcpLis.Addr().String())
+	_assert(err != nil, "expect dial to fail after shutdown")
+}
+
+func TestServer_RunListenerError(t *testing.T) {
+	server := NewServer()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	other, _ := net.Listen("tcp", "127.0.0.1:0")
+	server.AddListener(lis)
+	server.AddListener(other)
+	_ = lis.Close() // fails its Accept right away, which stops the other one too
+	err = server.Run(context.Background())
+	errs, ok := err.(ListenerErrors)
+	_assert(ok && len(errs) == 1, "expect one listener error, but got %v", err)
+}
+
+type Blob struct{}
+
+func (Blob) Len(data []byte, reply *int) error {
+	*reply = len(data)
+	return nil
+}
+
+func TestServer_ServeWithOptions(t *testing.T) {
+	var foo Foo
+	server := NewServer(WithMaxBodySize(1 << 20))
+	_ = server.Register(&foo)
+	_ = server.Register(Blob{})
+	serve := func(o ListenerOptions) string {
+		lis, err := server.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal("network error:", err)
+		}
+		go func() { _ = server.ServeWithOptions(lis, o) }()
+		t.Cleanup(func() { _ = lis.Close() })
+		return lis.Addr().String()
+	}
+	internal := serve(ListenerOptions{Codecs: []codec.Type{codec.GobType}})
+	external := serve(ListenerOptions{
+		Codecs:      []codec.Type{testCodecType},
+		MaxBodySize: 256,
+		Authenticate: func(token string) error {
+			if token != "secret" {
+				return errors.New("bad token")
+			}
+			return nil
+		},
+	})
+	dial := func(addr string, t codec.Type, token string) (*Client, error) {
+		return Dial("tcp", addr, &Option{CodecType: t, AllowCodecFallback: true, AuthToken: token}, WithHeartbeat(-1))
+	}
+	blob := func(client *Client, n int) error {
+		var reply int
+		return client.Call("Blob.Len", make([]byte, n), &reply)
+	}
+
+	_, err := dial(internal, testCodecType, "")
+	_assert(errors.Is(err, ErrNoCommonCodec), "expect the internal listener to refuse %s, but got %v", testCodecType, err)
+	_, err = dial(external, codec.GobType, "secret")
+	_assert(errors.Is(err, ErrNoCommonCodec), "expect the external listener to refuse gob, but got %v", err)
+	_, err = dial(external, testCodecType, "wrong")
+	_assert(errors.Is(err, ErrUnauthenticated), "expect the external listener to require auth, but got %v", err)
+
+	client, err := dial(internal, codec.GobType, "")
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(blob(client, 1000) == nil, "expect the server limit on the internal listener")
+
+	client, err = dial(external, testCodecType, "secret")
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(blob(client, 100) == nil, "expect a small body accepted")
+	err = blob(client, 1000)
+	_assert(errors.Is(err, ErrBodyTooLarge), "expect ErrBodyTooLarge on the external listener, but got %v", err)
+	_assert(blob(client, 100) != nil, "expect the connection closed after a body too large")
+}
+
+func TestServer_AuthenticateComposes(t *testing.T) {
+	server := NewServer(WithAuthenticator(func(token string) error {
+		if token == "" {
+			return errors.New("no token")
+		}
+		return nil
+	}))
+	lopt := &ListenerOptions{Authenticate: func(token string) error {
+		if token != "admin" {
+			return errors.New("not admin")
+		}
+		return nil
+	}}
+	_assert(server.authenticate(nil, "") != nil, "expect the server authenticator applied")
+	_assert(server.authenticate(nil, "user") == nil, "expect any token accepted by the server")
+	_assert(server.authenticate(lopt, "user") != nil, "expect the listener authenticator applied too")
+	_assert(server.authenticate(lopt, "admin") == nil, "expect a token accepted by both")
+
+	_assert(minLimit(0, 5) == 5 && minLimit(5, 0) == 5 && minLimit(3, 5) == 3 && minLimit(0, 0) == 0,
+		"expect the smallest limit set")
+}
@@ -0,0 +1,41 @@
+package tinyrpc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"tinyrpc/rpclog"
+)
+
+func TestServer_LogAdmin(t *testing.T) {
+	var buf bytes.Buffer
+	logger := rpclog.New(&buf, rpclog.TextFormat)
+	old := rpclog.Default()
+	rpclog.SetDefault(logger)
+	defer rpclog.SetDefault(old)
+
+	server := NewServer()
+	lis := startServer(t, server)
+	client, err := Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var level string
+	err = client.Call("_log_.SetLevel", LogLevelArgs{Subsystem: "codec", Level: "debug"}, &level)
+	_assert(err != nil, "expect the admin service disabled by default")
+
+	server.EnableLogAdmin()
+	err = client.Call("_log_.SetLevel", LogLevelArgs{Subsystem: "codec", Level: "debug"}, &level)
+	_assert(err == nil && level == "debug", "expect the level set, but got %q, %v", level, err)
+	var reply int
+	_ = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(strings.Contains(buf.String(), `level=debug subsystem=codec msg="read header" method=Foo.Sum`),
+		"expect codec debug lines, but got %q", buf.String())
+
+	_ = server.SetLogLevel("codec", rpclog.LevelInfo)
+	buf.Reset()
+	_ = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(!strings.Contains(buf.String(), "subsystem=codec"), "expect no codec lines at info, but got %q", buf.String())
+}
@@ -0,0 +1,202 @@
+package tinyrpc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Metadata is a set of key-value pairs carried in the header of a request
+// (its metadata) or of a response (its trailer).
+type Metadata map[string]string
+
+// A CallOption configures a single call.
+type CallOption interface {
+	before(call *Call) // applied before the request is sent
+	after(call *Call)  // applied when the call is done
+}
+
+type headerOption struct{ key, value string }
+
+func (o headerOption) before(call *Call) {
+	if call.Metadata == nil {
+		call.Metadata = make(Metadata)
+	}
+	call.Metadata[o.key] = o.value
+}
+
+func (headerOption) after(*Call) {}
+
+// WithHeader sends key: value in the metadata of the request.
+// Handlers read it with HeaderFromContext.
+func WithHeader(key, value string) CallOption {
+	return headerOption{key, value}
+}
+
+type trailerOption struct{ md *Metadata }
+
+func (trailerOption) before(*Call) {}
+
+func (o trailerOption) after(call *Call) {
+	*o.md = call.Trailer
+}
+
+// WithTrailer stores the trailer set by the handler with SetTrailer
+// into md when the call is done.
+func WithTrailer(md *Metadata) CallOption {
+	return trailerOption{md}
+}
+
+type metadataKey struct{}
+
+// callMetadata is the metadata of the request being handled.
+type callMetadata struct {
+	seq     uint64 // of the request
+	header  Metadata
+	mu      sync.Mutex // protect trailer
+	trailer Metadata
+}
+
+// callContext is the context of a call being handled, cancelled by a
+// cancel frame or once the call is done. It carries the connection and
+// the metadata of the call, and is held by the request, rather than made
+// of context.WithCancel and context.WithValue, so that calls allocate
+// less. Its Done channel is made only if asked for.
+type callContext struct {
+	sc       *serverConn
+	md       callMetadata
+	canceled bool      // by a cancel frame, protected by sc.mu
+	progress *Progress // nil unless the client asked for progress reports
+	timings  *Timings  // nil unless the server collects them
+
+	mu    sync.Mutex    // protect following
+	done  chan struct{} // nil until Done is called
+	err   error         // context.Canceled once cancelled
+	after []*afterFunc
+}
+
+type afterFunc struct{ f func() }
+
+// closedDone is the Done channel of the contexts cancelled before Done
+// was called.
+var closedDone = make(chan struct{})
+
+func init() { close(closedDone) }
+
+func (c *callContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (c *callContext) Done() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done == nil {
+		c.done = make(chan struct{})
+	}
+	return c.done
+}
+
+func (c *callContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *callContext) Value(key interface{}) interface{} {
+	switch key.(type) {
+	case connKey:
+		return c.sc
+	case metadataKey:
+		return &c.md
+	case progressKey:
+		if c.progress != nil {
+			return c.progress
+		}
+	case timingsKey:
+		if c.timings != nil {
+			return c.timings
+		}
+	}
+	return nil
+}
+
+// AfterFunc runs f in its own goroutine once c is cancelled, like
+// context.AfterFunc. The contexts derived from c use it, rather than a
+// goroutine waiting for Done.
+func (c *callContext) AfterFunc(f func()) (stop func() bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		go f()
+		return func() bool { return false }
+	}
+	a := &afterFunc{f}
+	c.after = append(c.after, a)
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i, b := range c.after {
+			if b == a {
+				c.after = append(c.after[:i], c.after[i+1:]...)
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (c *callContext) cancel() {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.err = context.Canceled
+	if c.done == nil {
+		c.done = closedDone
+	} else {
+		close(c.done)
+	}
+	after := c.after
+	c.after = nil
+	c.mu.Unlock()
+	for _, a := range after {
+		go a.f()
+	}
+}
+
+// seqFromContext returns the Seq of the request handled with ctx.
+func seqFromContext(ctx context.Context) uint64 {
+	if md, ok := ctx.Value(metadataKey{}).(*callMetadata); ok {
+		return md.seq
+	}
+	return 0
+}
+
+// HeaderFromContext returns the metadata of the request handled with ctx.
+// The handler must not modify it.
+func HeaderFromContext(ctx context.Context) Metadata {
+	if md, ok := ctx.Value(metadataKey{}).(*callMetadata); ok {
+		return md.header
+	}
+	return nil
+}
+
+// SetTrailer sets key: value in the trailer of the response to the
+// request handled with ctx. It has no effect outside a handler.
+func SetTrailer(ctx context.Context, key, value string) {
+	md, ok := ctx.Value(metadataKey{}).(*callMetadata)
+	if !ok {
+		return
+	}
+	md.mu.Lock()
+	defer md.mu.Unlock()
+	if md.trailer == nil {
+		md.trailer = make(Metadata)
+	}
+	md.trailer[key] = value
+}
+
+func (md *callMetadata) trailerMap() map[string]string {
+	md.mu.Lock()
+	defer md.mu.Unlock()
+	return md.trailer
+}
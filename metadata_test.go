@@ -0,0 +1,88 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type Pager int
+
+// List echoes the cursor of the request and returns the next one in the trailer.
+func (p Pager) List(ctx context.Context, args int, reply *string) error {
+	*reply = HeaderFromContext(ctx)["cursor"]
+	SetTrailer(ctx, "next", *reply+"+1")
+	if args < 0 {
+		SetTrailer(ctx, "retry-after", "1s")
+		return errors.New("rate limited")
+	}
+	return nil
+}
+
+func TestCall_Metadata(t *testing.T) {
+	server := NewServer()
+	var pager Pager
+	_ = server.Register(&pager)
+	lis := startServer(t, server)
+	client, err := Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	var md Metadata
+	err = client.Call("Pager.List", 1, &reply, WithHeader("cursor", "abc"), WithTrailer(&md))
+	_assert(err == nil && reply == "abc", "expect the header seen by the handler, but got %q, %v", reply, err)
+	_assert(md["next"] == "abc+1", "expect the trailer, but got %v", md)
+
+	md = nil
+	err = client.Call("Pager.List", -1, &reply, WithTrailer(&md))
+	_assert(err != nil && md["retry-after"] == "1s", "expect the trailer with the error, but got %v, %v", md, err)
+
+	// the header is per call, and methods without a context still work
+	call := <-client.Go("Pager.List", 1, &reply, nil).Done
+	_assert(call.Error == nil && reply == "" && call.Trailer["next"] == "+1", "expect no header, but got %q, %v", reply, call.Trailer)
+	var sum int
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &sum, WithHeader("cursor", "x"), WithTrailer(&md))
+	_assert(err == nil && sum == 3 && md == nil, "expect Foo.Sum without trailer, but got %d, %v, %v", sum, md, err)
+}
+
+func TestCallContext_Cancel(t *testing.T) {
+	sc := &serverConn{}
+	c := &callContext{sc: sc, md: callMetadata{seq: 7}}
+	_assert(c.Value(connKey{}) == sc && seqFromContext(c) == 7, "expect the conn and the metadata of the call")
+	_assert(c.Err() == nil, "expect a live context, but got %v", c.Err())
+
+	child, cancelChild := context.WithCancel(context.WithValue(c, Pager(0), "page"))
+	defer cancelChild()
+	timed, cancelTimed := context.WithTimeout(c, time.Hour)
+	defer cancelTimed()
+	stopped := make(chan struct{})
+	stop := c.AfterFunc(func() { close(stopped) })
+	_assert(stop(), "expect the func stopped before the cancellation")
+	ran := make(chan struct{})
+	c.AfterFunc(func() { close(ran) })
+
+	c.cancel()
+	for _, ctx := range []context.Context{c, child, timed} {
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("expect the contexts derived from the call cancelled with it")
+		}
+		_assert(errors.Is(ctx.Err(), context.Canceled), "expect canceled, but got %v", ctx.Err())
+	}
+	<-ran
+	select {
+	case <-stopped:
+		t.Fatal("expect a stopped func not to run")
+	default:
+	}
+	c.cancel() // cancelling twice is fine
+
+	done := &callContext{}
+	done.cancel()
+	<-done.Done() // closed though made after the cancellation
+}
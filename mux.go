@@ -0,0 +1,101 @@
+package tinyrpc
+
+import (
+	"net"
+	"sync"
+)
+
+// Mux shares one connection between lightweight logical clients. They use
+// the send path and receive loop of a single Client, whose Seq numbers are
+// unique across all of them, so every response reaches its caller.
+type Mux struct {
+	client *Client
+	mu     sync.Mutex // protect following
+	refs   int
+	closed bool
+}
+
+// NewMux performs the handshake on conn and returns a Mux
+// that hands out logical clients with NewClient.
+func NewMux(conn net.Conn, opts ...ClientOption) (*Mux, error) {
+	cfg, err := parseClientOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newClient(conn, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Mux{client: client}, nil
+}
+
+// NewClient returns a new logical client. The connection is closed when
+// the last logical client is closed.
+func (m *Mux) NewClient() (*MuxClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrShutdown
+	}
+	m.refs++
+	return &MuxClient{mux: m}, nil
+}
+
+func (m *Mux) release() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refs--
+	if m.refs > 0 {
+		return nil
+	}
+	m.closed = true
+	return m.client.Close()
+}
+
+// MuxClient is a logical client of a Mux.
+type MuxClient struct {
+	mux    *Mux
+	mu     sync.Mutex
+	closed bool
+}
+
+// Go invokes the function asynchronously, see Client.Go.
+func (c *MuxClient) Go(serviceMethod string, args, reply interface{}, done chan *Call, opts ...CallOption) *Call {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		if done == nil {
+			done = make(chan *Call, 1)
+		}
+		call := &Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Error: ErrShutdown, Done: done}
+		call.done()
+		return call
+	}
+	return c.mux.client.Go(serviceMethod, args, reply, done, opts...)
+}
+
+// Call invokes the named function and waits for it to complete, see Client.Call.
+func (c *MuxClient) Call(serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	call := <-c.Go(serviceMethod, args, reply, make(chan *Call, 1), opts...).Done
+	return call.Error
+}
+
+// IsAvailable returns true if the logical client and the connection work.
+func (c *MuxClient) IsAvailable() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.closed && c.mux.client.IsAvailable()
+}
+
+// Close releases the logical client. Calls already issued still complete
+// unless it was the last one, which closes the connection.
+func (c *MuxClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrShutdown
+	}
+	c.closed = true
+	return c.mux.release()
+}
@@ -0,0 +1,53 @@
+package tinyrpc
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestMux(t *testing.T) {
+	lis := startServer(t, NewServer())
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	mux, err := NewMux(conn)
+	if err != nil {
+		t.Fatal("handshake error:", err)
+	}
+
+	var clients []*MuxClient
+	for i := 0; i < 3; i++ {
+		c, err := mux.NewClient()
+		if err != nil {
+			t.Fatal("new client error:", err)
+		}
+		clients = append(clients, c)
+	}
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		wg.Add(1)
+		go func(i int, c *MuxClient) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				var reply int
+				err := c.Call("Foo.Sum", Args{Num1: i * 1000, Num2: j}, &reply)
+				_assert(err == nil && reply == i*1000+j, "client %d got %d for call %d: %v", i, reply, j, err)
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	_ = clients[0].Close()
+	_ = clients[1].Close()
+	var reply int
+	_assert(clients[0].Call("Foo.Sum", Args{}, &reply) == ErrShutdown, "expect a closed logical client to fail")
+	err = clients[2].Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect the last logical client to keep the connection: %v", err)
+
+	_ = clients[2].Close()
+	_assert(!mux.client.IsAvailable(), "expect the last Close to close the connection")
+	_, err = mux.NewClient()
+	_assert(err == ErrShutdown, "expect no new logical client after the connection closed")
+}
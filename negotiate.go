@@ -0,0 +1,358 @@
+package tinyrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"tinyrpc/codec"
+)
+
+// ErrUnknownKey is returned by Dial when the server has no key with the
+// Option.EncryptionKeyID of the client.
+var ErrUnknownKey = errors.New("rpc: unknown encryption key")
+
+// ErrNoCommonCodec is returned by NewClient when the client allows codec
+// fallback but supports none of the codecs of the server.
+var ErrNoCommonCodec = errors.New("rpc: no common codec")
+
+// ProtocolVersion is the version of the protocol spoken past the
+// handshake. Peers speak the lower of their versions: a client of a
+// later version reads the version of the server in its answer to the
+// options, and other clients speak version 1. Later versions only add
+// fields to the headers and the options, which gob and json let older
+// peers skip, so that they never break the framing.
+const ProtocolVersion = 1
+
+// handshakeReply answers the options of a client that allows codec
+// fallback or body codecs, or of any client asking for a codec the
+// server doesn't have. Other clients get no answer when the codec is
+// accepted.
+type handshakeReply struct {
+	Accepted        bool
+	Unauthenticated bool         // the AuthToken was rejected
+	Codecs          []codec.Type // supported by the server
+	BodyCodecs      []codec.Type // see codec.BodyCodecs
+	Compressions    []string     // see codec.Compressors
+	BodyChecksum    bool         // the bodies may be checksummed, see WithChecksum
+	Checksum        bool         // the frames are checksummed, see Option.EnableChecksum
+	Encrypted       bool         // the stream is encrypted, see Option.EncryptionKeyID
+	UnknownKey      bool         // the EncryptionKeyID is not one of the server
+	Version         int          // of the protocol spoken, 0 from servers before versions
+	BulkChannel     bool         // see Option.BulkChannel
+}
+
+// wantsHandshakeReply reports whether the client reads the answer of the
+// server to opt.
+func (opt *Option) wantsHandshakeReply() bool {
+	return opt.AllowCodecFallback || opt.AllowBodyCodecs || opt.AuthToken != "" || opt.EnableChecksum ||
+		opt.EncryptionKeyID != "" || opt.ProtocolVersion > 1 || opt.BulkChannel
+}
+
+// protocolVersion returns the version opt offers, 1 if unset as sent by
+// clients before versions.
+func (opt *Option) protocolVersion() int {
+	if opt.ProtocolVersion <= 0 {
+		return 1
+	}
+	return opt.ProtocolVersion
+}
+
+// protocolVersion returns the version the server speaks with clients
+// offering opt.
+func (server *Server) protocolVersion(opt *Option) int {
+	v := server.maxVersion
+	if v == 0 {
+		v = ProtocolVersion
+	}
+	if o := opt.protocolVersion(); o < v {
+		v = o
+	}
+	return v
+}
+
+// agreedVersion returns the version a client offering opt speaks after
+// reply, nil if it read none.
+func agreedVersion(opt *Option, reply *handshakeReply) int {
+	v := 1
+	if reply != nil && reply.Version > 0 {
+		v = reply.Version
+	}
+	if o := opt.protocolVersion(); o < v {
+		v = o
+	}
+	return v
+}
+
+// ErrBodyCodec is returned for calls with a body codec that the server
+// didn't advertise.
+var ErrBodyCodec = errors.New("rpc client: body codec not supported by the server")
+
+type bodyCodecOption struct{ t codec.Type }
+
+func (o bodyCodecOption) before(call *Call) { call.BodyCodec = o.t }
+
+func (bodyCodecOption) after(*Call) {}
+
+// WithBodyCodec encodes the arguments and the reply of the call with t
+// instead of the codec of the connection, e.g. during a migration from
+// one codec to another. The client must have set Option.AllowBodyCodecs,
+// and the server must support t, or the call fails with ErrBodyCodec.
+func WithBodyCodec(t codec.Type) CallOption {
+	return bodyCodecOption{t}
+}
+
+// ErrBodyEncoding is returned for calls with a compression or a checksum
+// that the server didn't advertise.
+var ErrBodyEncoding = errors.New("rpc client: body encoding not supported by the server")
+
+// Trailer keys of a handler overriding the encoding of its reply, which
+// is otherwise that of the request, see WithCompression and WithChecksum.
+// An empty ReplyCompressionHeader sends the reply uncompressed, and
+// ReplyChecksumHeader is parsed with strconv.ParseBool. They aren't sent
+// to the client.
+const (
+	ReplyCompressionHeader = "tinyrpc-compression"
+	ReplyChecksumHeader    = "tinyrpc-checksum"
+)
+
+type compressionOption struct{ alg string }
+
+func (o compressionOption) before(call *Call) { call.Compression = o.alg }
+
+func (compressionOption) after(*Call) {}
+
+// WithCompression compresses the arguments of the call with alg, one of
+// codec.Compressors, and so the server does its reply unless the handler
+// overrides it with ReplyCompressionHeader. The client must have set
+// Option.AllowBodyCodecs, and the server must support alg, or the call
+// fails with ErrBodyEncoding.
+func WithCompression(alg string) CallOption {
+	return compressionOption{alg}
+}
+
+type checksumOption struct{ on bool }
+
+func (o checksumOption) before(call *Call) { call.Checksum = o.on }
+
+func (checksumOption) after(*Call) {}
+
+// WithChecksum appends a CRC32C to the body of the arguments of the
+// call, and so the server does to its reply unless the handler overrides
+// it with ReplyChecksumHeader. A body whose checksum doesn't match fails
+// the call only, unlike Option.EnableChecksum which checksums all the
+// frames. The client must have set Option.AllowBodyCodecs, or the call
+// fails with ErrBodyEncoding.
+func WithChecksum(on bool) CallOption {
+	return checksumOption{on}
+}
+
+// bodyEncodings are the compressions and checksums of the bodies the
+// server advertised.
+type bodyEncodings struct {
+	compressions map[string]bool
+	checksum     bool
+}
+
+func newBodyEncodings(reply *handshakeReply) bodyEncodings {
+	e := bodyEncodings{compressions: make(map[string]bool, len(reply.Compressions)), checksum: reply.BodyChecksum}
+	for _, alg := range reply.Compressions {
+		e.compressions[alg] = true
+	}
+	return e
+}
+
+// check fails the call with a compression or a checksum the server
+// doesn't support, or the client itself.
+func (e bodyEncodings) check(call *Call) error {
+	if alg := call.Compression; alg != "" && (!e.compressions[alg] || codec.Compressors[alg] == nil) {
+		return fmt.Errorf("%w: compression %s", ErrBodyEncoding, alg)
+	}
+	if call.Checksum && !e.checksum {
+		return fmt.Errorf("%w: checksum", ErrBodyEncoding)
+	}
+	return nil
+}
+
+// replyEncoding sets the encoding of the reply of h from the overrides
+// in trailer, which are removed, for a client allowing body codecs.
+func replyEncoding(h *codec.Header, trailer map[string]string, bodyCodecs bool) {
+	if alg, ok := trailer[ReplyCompressionHeader]; ok {
+		delete(trailer, ReplyCompressionHeader)
+		if bodyCodecs && (alg == "" || codec.Compressors[alg] != nil) {
+			h.Compression = alg
+		}
+	}
+	if v, ok := trailer[ReplyChecksumHeader]; ok {
+		delete(trailer, ReplyChecksumHeader)
+		if on, err := strconv.ParseBool(v); err == nil && bodyCodecs {
+			h.Checksum = on
+		}
+	}
+}
+
+func newHandshakeReply(server *Server, lopt *ListenerOptions, opt *Option, accepted bool) *handshakeReply {
+	bodies := make([]codec.Type, 0, len(codec.BodyCodecs))
+	for t := range codec.BodyCodecs {
+		bodies = append(bodies, t)
+	}
+	sort.Slice(bodies, func(i, j int) bool { return bodies[i] < bodies[j] })
+	compressions := make([]string, 0, len(codec.Compressors))
+	for alg := range codec.Compressors {
+		compressions = append(compressions, alg)
+	}
+	sort.Strings(compressions)
+	return &handshakeReply{
+		Accepted:     accepted,
+		Codecs:       server.supportedCodecs(lopt),
+		BodyCodecs:   bodies,
+		Compressions: compressions,
+		BodyChecksum: true,
+		Checksum:     opt.EnableChecksum,
+		Encrypted:    opt.EncryptionKeyID != "",
+		Version:      server.protocolVersion(opt),
+
+		BulkChannel: opt.BulkChannel,
+	}
+}
+
+// SetCodecs limits the codecs the server accepts to types, which must be
+// registered in codec.NewCodecFuncMap, instead of all the registered
+// ones. It must be called before the server starts serving.
+func (server *Server) SetCodecs(types ...codec.Type) {
+	server.codecs = append([]codec.Type(nil), types...)
+}
+
+// supportedCodecs returns the codecs the server accepts on the
+// connections of lopt, which may be nil.
+func (server *Server) supportedCodecs(lopt *ListenerOptions) []codec.Type {
+	types := server.codecs
+	if types == nil {
+		types = make([]codec.Type, 0, len(codec.NewCodecFuncMap))
+		for t := range codec.NewCodecFuncMap {
+			types = append(types, t)
+		}
+		sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	}
+	if lopt == nil || lopt.Codecs == nil {
+		return types
+	}
+	var both []codec.Type
+	for _, t := range types {
+		for _, l := range lopt.Codecs {
+			if t == l {
+				both = append(both, t)
+				break
+			}
+		}
+	}
+	return both
+}
+
+func (server *Server) acceptsCodec(lopt *ListenerOptions, t codec.Type) bool {
+	if codec.NewCodecFuncMap[t] == nil {
+		return false
+	}
+	for _, s := range server.supportedCodecs(lopt) {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
+// readOptions reads the options of a client connected on the listener of
+// lopt, which may be nil, from dec, and answers them on w. A client
+// asking for a codec the server doesn't have is told the codecs of the
+// server, and if it allows fallback, it sends its options again with one
+// of them. A client whose AuthToken is rejected is told so, if it sent
+// one.
+func (server *Server) readOptions(dec *json.Decoder, w io.Writer, lopt *ListenerOptions) (*Option, error) {
+	opt, err := decodeOptions(dec)
+	if err != nil {
+		return nil, err
+	}
+	if err := server.authenticate(lopt, opt.AuthToken); err != nil {
+		if opt.wantsHandshakeReply() {
+			_ = json.NewEncoder(w).Encode(&handshakeReply{Unauthenticated: true})
+		}
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	if id := opt.EncryptionKeyID; id != "" && server.config.EncryptionKeys[id] == nil {
+		_ = json.NewEncoder(w).Encode(&handshakeReply{UnknownKey: true})
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	if server.acceptsCodec(lopt, opt.CodecType) {
+		if opt.wantsHandshakeReply() {
+			err = json.NewEncoder(w).Encode(newHandshakeReply(server, lopt, opt, true))
+		}
+		return opt, err
+	}
+	_ = json.NewEncoder(w).Encode(newHandshakeReply(server, lopt, opt, false))
+	if !opt.AllowCodecFallback {
+		return nil, fmt.Errorf("invalid codec type %s", opt.CodecType)
+	}
+	if opt, err = decodeOptions(dec); err != nil {
+		return nil, err
+	}
+	if !server.acceptsCodec(lopt, opt.CodecType) {
+		return nil, fmt.Errorf("invalid fallback codec type %s", opt.CodecType)
+	}
+	return opt, nil
+}
+
+func decodeOptions(dec *json.Decoder) (*Option, error) {
+	var opt Option
+	if err := dec.Decode(&opt); err != nil {
+		return nil, err
+	}
+	if opt.MagicNumber != MagicNumber {
+		return nil, fmt.Errorf("invalid magic number %x", opt.MagicNumber)
+	}
+	return &opt, nil
+}
+
+// negotiateCodec reads the answer of the server to opt, sent on conn
+// with fallback or body codecs allowed, or with an AuthToken. If the server doesn't have
+// opt.CodecType and fallback is allowed, it sends the options again with
+// the first codec of opt.CodecType and opt.FallbackCodecs that both sides
+// have. It returns the codec agreed on, the reply of the server and conn
+// to read the codec stream from.
+func negotiateCodec(conn io.ReadWriteCloser, opt *Option) (codec.Type, *handshakeReply, io.ReadWriteCloser, error) {
+	dec := json.NewDecoder(conn)
+	var reply handshakeReply
+	if err := dec.Decode(&reply); err != nil {
+		return "", nil, nil, err
+	}
+	if reply.Unauthenticated {
+		return "", nil, nil, ErrUnauthenticated
+	}
+	if reply.UnknownKey {
+		return "", nil, nil, ErrUnknownKey
+	}
+	stream := newHandshakeConn(conn, dec)
+	if reply.Accepted {
+		return opt.CodecType, &reply, stream, nil
+	}
+	if !opt.AllowCodecFallback {
+		return "", nil, nil, fmt.Errorf("invalid codec type %s: the server supports %v", opt.CodecType, reply.Codecs)
+	}
+	server := make(map[codec.Type]bool, len(reply.Codecs))
+	for _, t := range reply.Codecs {
+		server[t] = true
+	}
+	for _, t := range append([]codec.Type{opt.CodecType}, opt.FallbackCodecs...) {
+		if !server[t] || codec.NewCodecFuncMap[t] == nil {
+			continue
+		}
+		retry := *opt
+		retry.CodecType = t
+		if err := json.NewEncoder(conn).Encode(&retry); err != nil {
+			return "", nil, nil, err
+		}
+		return t, &reply, stream, nil
+	}
+	return "", nil, nil, fmt.Errorf("%w: the server supports %v", ErrNoCommonCodec, reply.Codecs)
+}
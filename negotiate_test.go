@@ -0,0 +1,151 @@
+package tinyrpc
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"tinyrpc/codec"
+)
+
+// testCodecType is gob under another name, a codec some servers don't accept.
+const testCodecType codec.Type = "application/x-test-gob"
+
+func init() {
+	codec.NewCodecFuncMap[testCodecType] = codec.NewGobCodec
+}
+
+func TestNewClient_CodecFallback(t *testing.T) {
+	dial := func(t *testing.T, server *Server, opt *Option) (*Client, error) {
+		t.Helper()
+		addr := startServer(t, server).Addr().String()
+		opt.HeartbeatIdle = -1
+		return Dial("tcp", addr, opt)
+	}
+	call := func(client *Client) error {
+		defer func() { _ = client.Close() }()
+		var reply int
+		return client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	}
+
+	t.Run("exact match", func(t *testing.T) {
+		client, err := dial(t, NewServer(), &Option{CodecType: codec.GobType, AllowCodecFallback: true})
+		_assert(err == nil, "dial error: %v", err)
+		_assert(call(client) == nil, "failed to call Foo.Sum")
+	})
+	t.Run("fallback", func(t *testing.T) {
+		server := NewServer()
+		server.SetCodecs(codec.GobType)
+		opt := &Option{CodecType: testCodecType, AllowCodecFallback: true, FallbackCodecs: []codec.Type{codec.GobType}}
+		client, err := dial(t, server, opt)
+		_assert(err == nil, "dial error: %v", err)
+		_assert(call(client) == nil, "failed to call Foo.Sum after falling back to gob")
+		_assert(opt.CodecType == testCodecType, "expect the options left unchanged")
+	})
+	t.Run("no common codec", func(t *testing.T) {
+		server := NewServer()
+		server.SetCodecs(codec.GobType)
+		_, err := dial(t, server, &Option{CodecType: testCodecType, AllowCodecFallback: true})
+		_assert(errors.Is(err, ErrNoCommonCodec), "expect ErrNoCommonCodec, but got %v", err)
+		_assert(strings.Contains(err.Error(), string(codec.GobType)), "expect the server codecs in %q", err)
+	})
+	t.Run("no fallback", func(t *testing.T) {
+		server := NewServer()
+		server.SetCodecs(codec.GobType)
+		client, err := dial(t, server, &Option{CodecType: testCodecType})
+		_assert(err == nil, "dial error: %v", err)
+		_assert(call(client) != nil, "expect the connection closed for its codec")
+	})
+}
+
+// bodyRecorder records the body codecs of the frames of a client.
+type bodyRecorder struct {
+	codec.Codec
+	mu           sync.Mutex
+	wrote, reads []codec.Type
+}
+
+func (r *bodyRecorder) ReadHeader(h *codec.Header) error {
+	err := r.Codec.ReadHeader(h)
+	r.mu.Lock()
+	r.reads = append(r.reads, h.BodyCodec)
+	r.mu.Unlock()
+	return err
+}
+
+func (r *bodyRecorder) Write(h *codec.Header, body interface{}) error {
+	r.mu.Lock()
+	r.wrote = append(r.wrote, h.BodyCodec)
+	r.mu.Unlock()
+	return r.Codec.Write(h, body)
+}
+
+func TestWithBodyCodec(t *testing.T) {
+	addr := startServer(t, NewServer()).Addr().String()
+	rec := &bodyRecorder{}
+	opt := &Option{HeartbeatIdle: -1, AllowBodyCodecs: true, WrapCodec: func(cc codec.Codec) codec.Codec {
+		rec.Codec = cc
+		return rec
+	}}
+	client, err := Dial("tcp", addr, opt)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	for _, t := range []codec.Type{"", codec.JsonType, codec.GobType, codec.JsonType} {
+		var reply int
+		var opts []CallOption
+		if t != "" {
+			opts = append(opts, WithBodyCodec(t))
+		}
+		err := client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply, opts...)
+		_assert(err == nil && reply == 3, "expect 3 with body codec %q, but got %d, %v", t, reply, err)
+	}
+	want := []codec.Type{"", codec.JsonType, codec.GobType, codec.JsonType}
+	rec.mu.Lock()
+	_assert(reflect.DeepEqual(rec.wrote, want), "expect requests with body codecs %v, but got %v", want, rec.wrote)
+	_assert(reflect.DeepEqual(rec.reads, want), "expect responses with body codecs %v, but got %v", want, rec.reads)
+	rec.mu.Unlock()
+
+	var reply int
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply, WithBodyCodec("application/x-unknown"))
+	_assert(errors.Is(err, ErrBodyCodec), "expect ErrBodyCodec, but got %v", err)
+}
+
+func TestServer_UnknownBodyCodec(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	conn, peer := net.Pipe()
+	go server.ServeConn(conn)
+	_ = json.NewEncoder(peer).Encode(DefaultOption)
+	cc := codec.NewGobCodec(peer)
+	defer func() { _ = cc.Close() }()
+
+	// a body in a codec the server doesn't know, framed as a byte slice
+	enc := gob.NewEncoder(peer)
+	go func() {
+		_ = enc.Encode(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1, BodyCodec: "application/x-unknown"})
+		_ = enc.Encode([]byte("{1, 2}"))
+		_ = enc.Encode(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 2})
+		_ = enc.Encode(Args{Num1: 1, Num2: 2})
+	}()
+	errs := make(map[uint64]string)
+	replies := make(map[uint64]int)
+	for i := 0; i < 2; i++ {
+		var h codec.Header
+		var reply int
+		_assert(cc.ReadHeader(&h) == nil, "failed to read response header")
+		if h.Error != "" {
+			_assert(cc.ReadBody(nil) == nil, "failed to read response body")
+		} else {
+			_assert(cc.ReadBody(&reply) == nil, "failed to read response body")
+		}
+		errs[h.Seq], replies[h.Seq] = h.Error, reply
+	}
+	_assert(strings.Contains(errs[1], "unknown body codec"), "expect an unknown body codec error, but got %q", errs[1])
+	_assert(errs[2] == "" && replies[2] == 3, "expect the next call answered, but got %d, %q", replies[2], errs[2])
+}
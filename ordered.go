@@ -0,0 +1,57 @@
+package tinyrpc
+
+// DefaultMaxReordered bounds the responses of a connection with
+// Option.OrderedResponses waiting for their turn, if
+// ResponseLimits.MaxReordered is 0.
+const DefaultMaxReordered = 256
+
+// takeTurn returns the position of the next response of sc in the
+// request order, 0 if sc doesn't order its responses.
+func (sc *serverConn) takeTurn() uint64 {
+	if !sc.ordered {
+		return 0
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.lastTurn++
+	return sc.lastTurn
+}
+
+// reorderFull reports whether sc has max responses waiting for their
+// turn or more. sc.mu must be held.
+func (sc *serverConn) reorderFull(max int) bool {
+	if !sc.ordered {
+		return false
+	}
+	if max <= 0 {
+		max = DefaultMaxReordered
+	}
+	return sc.lastTurn-sc.turn >= uint64(max)
+}
+
+// awaitTurn blocks until it is the turn of the response turn, or sc is
+// closed. sc.mu must be held.
+func (sc *serverConn) awaitTurn(turn uint64) {
+	for turn != 0 && sc.turn+1 != turn && !sc.closed {
+		sc.unsentCond.Wait()
+	}
+}
+
+// endTurn lets the response after turn be written. sc.mu must be held.
+func (sc *serverConn) endTurn(turn uint64) {
+	if turn != 0 && sc.turn+1 == turn {
+		sc.turn = turn
+		sc.unsentCond.Broadcast()
+	}
+}
+
+// skipTurn gives up the turn of a request that gets no response.
+func (sc *serverConn) skipTurn(turn uint64) {
+	if turn == 0 {
+		return
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.awaitTurn(turn)
+	sc.endTurn(turn)
+}
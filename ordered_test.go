@@ -0,0 +1,39 @@
+package tinyrpc
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"tinyrpc/codec"
+)
+
+func TestServer_OrderedResponses(t *testing.T) {
+	for _, ordered := range []bool{false, true} {
+		server := NewServer()
+		var slow Slow
+		_ = server.Register(&slow)
+		conn, peer := net.Pipe()
+		go server.ServeConn(conn)
+
+		opt := *DefaultOption
+		opt.OrderedResponses = ordered
+		_ = json.NewEncoder(peer).Encode(&opt)
+		cc := codec.NewGobCodec(peer)
+		for i, ms := range []int{200, 1, 1} { // the first is the slowest
+			_ = cc.Write(&codec.Header{ServiceMethod: "Slow.Sleep", Seq: uint64(i + 1)}, ms)
+		}
+		var seqs []uint64
+		for i := 0; i < 3; i++ {
+			var h codec.Header
+			var reply int
+			_assert(cc.ReadHeader(&h) == nil && cc.ReadBody(&reply) == nil, "failed to read response %d", i)
+			seqs = append(seqs, h.Seq)
+		}
+		_ = cc.Close()
+		if ordered {
+			_assert(seqs[0] == 1 && seqs[1] == 2 && seqs[2] == 3, "expect responses in request order, but got %v", seqs)
+		} else {
+			_assert(seqs[2] == 1, "expect the slowest response last, but got %v", seqs)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package tinyrpc
+
+import (
+	"fmt"
+	"tinyrpc/codec"
+)
+
+// PreencodedBody is the arguments of a call encoded once with a body
+// codec, e.g. to send them to many servers with XClient.Broadcast: a
+// client sends the encoded bytes as is to the servers supporting the
+// body codec, see WithBodyCodec, and encodes the arguments with the codec
+// of the connection for the others. The reply is encoded with the body
+// codec by the servers it is sent to. Client interceptors see the
+// PreencodedBody as the arguments.
+type PreencodedBody struct {
+	bodyCodec codec.Type
+	data      []byte
+	args      interface{}
+}
+
+// Preencode encodes args with the body codec t, one of codec.BodyCodecs.
+// The client must have set Option.AllowBodyCodecs for the encoding to be
+// used. args must not be modified while calls send it.
+func Preencode(t codec.Type, args interface{}) (*PreencodedBody, error) {
+	bc := codec.BodyCodecs[t]
+	if bc == nil {
+		return nil, fmt.Errorf("%w %s", codec.ErrUnknownBodyCodec, t)
+	}
+	data, err := bc.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	return &PreencodedBody{bodyCodec: t, data: data, args: args}, nil
+}
+
+// Args returns the arguments encoded.
+func (b *PreencodedBody) Args() interface{} {
+	return b.args
+}
+
+// encodedFor returns the body codec the client sends b with, empty if it
+// must encode b.args for the call.
+func (b *PreencodedBody) encodedFor(client *Client, call *Call) codec.Type {
+	t := b.bodyCodec
+	if call.BodyCodec != "" && call.BodyCodec != t || t == client.state.Codec || !client.bodyCodecs[t] {
+		return "" // in the codec of the connection, the body wouldn't be framed
+	}
+	return t
+}
+
+// writeEncoded writes the frame of h with raw as its body, a byte slice
+// or codec.Encoded if h.BodyCodec is set, with cc's codec.EncodedWriter
+// if it is one.
+func writeEncoded(cc codec.Codec, h *codec.Header, raw []byte) error {
+	if w, ok := cc.(codec.EncodedWriter); ok {
+		return w.WriteEncoded(h, raw)
+	}
+	if h.BodyCodec != "" {
+		return cc.Write(h, codec.Encoded(raw))
+	}
+	return cc.Write(h, raw)
+}
@@ -0,0 +1,55 @@
+package tinyrpc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"tinyrpc/codec"
+)
+
+func TestPreencodedBody(t *testing.T) {
+	addr := startServer(t, NewServer()).Addr().String()
+	body, err := Preencode(codec.JsonType, Args{Num1: 1, Num2: 2})
+	_assert(err == nil, "preencode error: %v", err)
+
+	// sent as is, with WriteEncoded
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1, AllowBodyCodecs: true})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	for i := 0; i < 2; i++ {
+		var reply int
+		err = client.Call("Foo.Sum", body, &reply)
+		_assert(err == nil && reply == 3, "expect 3, but got %d, %v", reply, err)
+	}
+
+	// encoded again in the codec of the connection without body codecs
+	for _, allow := range []bool{true, false} {
+		rec := &bodyRecorder{}
+		opt := &Option{HeartbeatIdle: -1, AllowBodyCodecs: allow, WrapCodec: func(cc codec.Codec) codec.Codec {
+			rec.Codec = cc
+			return rec
+		}}
+		client, err := Dial("tcp", addr, opt)
+		_assert(err == nil, "dial error: %v", err)
+		var reply int
+		err = client.Call("Foo.Sum", body, &reply)
+		_assert(err == nil && reply == 3, "expect 3, but got %d, %v", reply, err)
+		_ = client.Close()
+		want := []codec.Type{""}
+		if allow {
+			want = []codec.Type{codec.JsonType}
+		}
+		rec.mu.Lock()
+		_assert(reflect.DeepEqual(rec.wrote, want), "expect requests with body codecs %v, but got %v", want, rec.wrote)
+		rec.mu.Unlock()
+	}
+
+	// the codec of the connection can't frame its own bodies
+	body, _ = Preencode(codec.GobType, Args{Num1: 1, Num2: 2})
+	var reply int
+	err = client.Call("Foo.Sum", body, &reply)
+	_assert(err == nil && reply == 3, "expect 3 from gob arguments, but got %d, %v", reply, err)
+
+	_, err = Preencode("application/x-unknown", 1)
+	_assert(errors.Is(err, codec.ErrUnknownBodyCodec), "expect ErrUnknownBodyCodec, but got %v", err)
+}
@@ -0,0 +1,224 @@
+package tinyrpc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PriorityHeader is the metadata key of the priority of a request:
+// "high", "normal" or "low", see WithPriority.
+const PriorityHeader = "tinyrpc-priority"
+
+// DefaultPriorityAging is how long a queued request waits before it is
+// promoted one priority up, if ServerConfig.PriorityAging is 0.
+const DefaultPriorityAging = 100 * time.Millisecond
+
+// DefaultMaxQueued is the number of calls that may wait for a worker, if
+// ServerConfig.MaxQueued is 0.
+const DefaultMaxQueued = 1024
+
+// Priority is the priority of a request on a server with workers, see
+// ServerConfig.Workers.
+type Priority int
+
+const (
+	PriorityNormal Priority = iota // the default
+	PriorityHigh                   // handled before the others, e.g. interactive calls
+	PriorityLow                    // handled after the others, e.g. batch jobs
+)
+
+const numPriorities = 3
+
+var priorityNames = [numPriorities]string{"normal", "high", "low"}
+
+func (p Priority) String() string {
+	if p < 0 || p >= numPriorities {
+		return "normal"
+	}
+	return priorityNames[p]
+}
+
+// rank orders the priorities, the highest first.
+func (p Priority) rank() int {
+	switch p {
+	case PriorityHigh:
+		return 0
+	case PriorityLow:
+		return 2
+	}
+	return 1
+}
+
+// parsePriority returns the priority named s, normal if unknown.
+func parsePriority(s string) Priority {
+	for p, name := range priorityNames {
+		if name == s {
+			return Priority(p)
+		}
+	}
+	return PriorityNormal
+}
+
+// WithPriority sends p in the metadata of the request, see PriorityHeader.
+func WithPriority(p Priority) CallOption {
+	return WithHeader(PriorityHeader, p.String())
+}
+
+// PriorityStats are the counters of a priority on a server with workers.
+type PriorityStats struct {
+	Handled  uint64 // requests taken by a worker
+	Promoted uint64 // of them, taken before requests of a higher priority by aging
+	Queued   int    // requests waiting for a worker
+}
+
+// queuedCall is a request waiting for a worker.
+type queuedCall struct {
+	run func()
+	at  time.Time
+}
+
+// scheduler runs the requests queued by their priority on a fixed number
+// of workers. Each priority is a FIFO queue; a worker takes the request
+// of the highest priority, counting a request one priority higher for
+// every aging it has waited so that low ones are not starved.
+type scheduler struct {
+	aging     time.Duration
+	maxQueued int
+	now       func() time.Time
+	workers   sync.WaitGroup
+
+	mu       sync.Mutex // protect following
+	cond     *sync.Cond
+	queues   [numPriorities][]queuedCall // by rank
+	queued   int                         // of all the queues
+	handled  [numPriorities]uint64       // by rank
+	promoted [numPriorities]uint64       // by rank
+	stopped  bool
+}
+
+func newScheduler(aging time.Duration, maxQueued int, now func() time.Time) *scheduler {
+	if aging <= 0 {
+		aging = DefaultPriorityAging
+	}
+	if maxQueued <= 0 {
+		maxQueued = DefaultMaxQueued
+	}
+	s := &scheduler{aging: aging, maxQueued: maxQueued, now: now}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// start runs n workers, until stop.
+func (s *scheduler) start(n int) {
+	s.workers.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer s.workers.Done()
+			for run := s.next(); run != nil; run = s.next() {
+				run()
+			}
+		}()
+	}
+}
+
+// stop makes the workers exit once the queues are empty. The requests
+// pushed after run on a goroutine each.
+func (s *scheduler) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	s.cond.Broadcast()
+}
+
+// push queues run with priority p. It returns ErrResourceExhausted,
+// asking to retry once the oldest request queued could have been taken,
+// if maxQueued requests are waiting already.
+func (s *scheduler) push(p Priority, run func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		go run()
+		return nil
+	}
+	now := s.now()
+	if s.queued >= s.maxQueued {
+		var wait time.Duration
+		for _, q := range s.queues {
+			if len(q) > 0 && now.Sub(q[0].at) > wait {
+				wait = now.Sub(q[0].at)
+			}
+		}
+		return withRetryAfter(fmt.Errorf("%w: %d calls queued for a worker", ErrResourceExhausted, s.queued), wait)
+	}
+	r := p.rank()
+	s.queues[r] = append(s.queues[r], queuedCall{run: run, at: now})
+	s.queued++
+	s.cond.Signal()
+	return nil
+}
+
+// next takes the next request, waiting for one. It returns nil once s is
+// stopped and the queues are empty.
+func (s *scheduler) next() func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if run := s.take(); run != nil {
+			return run
+		}
+		if s.stopped {
+			return nil
+		}
+		s.cond.Wait()
+	}
+}
+
+// take takes the request of the highest priority with its aging, nil if
+// none is queued. s.mu must be held.
+func (s *scheduler) take() func() {
+	now := s.now()
+	best, bestRank := -1, 0
+	for r, q := range s.queues {
+		if len(q) == 0 {
+			continue
+		}
+		// the head is the oldest of its queue
+		rank := r - int(now.Sub(q[0].at)/s.aging)
+		if best < 0 || rank < bestRank {
+			best, bestRank = r, rank
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	for r := 0; r < best; r++ {
+		if len(s.queues[r]) > 0 {
+			s.promoted[best]++
+			break
+		}
+	}
+	s.handled[best]++
+	q := s.queues[best]
+	run := q[0].run
+	q[0] = queuedCall{}
+	s.queues[best] = q[1:]
+	s.queued--
+	return run
+}
+
+// stats returns the counters of each priority, by name.
+func (s *scheduler) stats() map[string]PriorityStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make(map[string]PriorityStats, numPriorities)
+	for p := Priority(0); p < numPriorities; p++ {
+		r := p.rank()
+		stats[p.String()] = PriorityStats{
+			Handled:  s.handled[r],
+			Promoted: s.promoted[r],
+			Queued:   len(s.queues[r]),
+		}
+	}
+	return stats
+}
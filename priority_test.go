@@ -0,0 +1,100 @@
+package tinyrpc
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduler_Aging(t *testing.T) {
+	now := time.Now()
+	s := newScheduler(10*time.Millisecond, 0, func() time.Time { return now })
+	var order []string
+	push := func(p Priority, name string) { s.push(p, func() { order = append(order, name) }) }
+	push(PriorityLow, "low")
+	push(PriorityNormal, "normal")
+	push(PriorityHigh, "high1")
+	s.take()()
+	now = now.Add(25 * time.Millisecond) // low counts as high, normal above it
+	push(PriorityHigh, "high2")
+	s.take()()
+	now = now.Add(25 * time.Millisecond) // low waited more than two agings longer than high2
+	s.take()()
+	s.take()()
+	_assert(s.take() == nil, "expect the queues empty")
+	want := []string{"high1", "normal", "low", "high2"}
+	for i := range want {
+		_assert(i < len(order) && order[i] == want[i], "expect %v, but got %v", want, order)
+	}
+	stats := s.stats()
+	_assert(stats["low"].Promoted == 1 && stats["normal"].Promoted == 1 && stats["high"].Handled == 2,
+		"expect the aged ones promoted, but got %+v", stats)
+}
+
+func TestServer_Priority(t *testing.T) {
+	server := NewServer(WithWorkers(2, time.Minute))
+	var slow Slow
+	_ = server.Register(&slow)
+	client, err := Dial("tcp", startServer(t, server).Addr().String(), &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// a burst of batch work taking 400ms on 2 workers
+	const burst = 40
+	calls := make([]*Call, burst)
+	for i := range calls {
+		calls[i] = client.Go("Slow.Sleep", 20, new(int), nil, WithPriority(PriorityLow))
+	}
+	waitFor(t, func() bool { return server.Stats().Priorities["low"].Queued >= burst/2 }, "expect the low calls queued")
+
+	start := time.Now()
+	var reply int
+	err = client.Call("Slow.Sleep", 0, &reply, WithPriority(PriorityHigh))
+	latency := time.Since(start)
+	_assert(err == nil, "call error: %v", err)
+	_assert(latency < 100*time.Millisecond, "expect the high call ahead of the burst, but took %s", latency)
+	queued := server.Stats().Priorities["low"].Queued
+	_assert(queued > 0, "expect low calls still queued after the high one")
+
+	for _, call := range calls {
+		call = <-call.Done
+		_assert(call.Error == nil, "low call error: %v", call.Error)
+	}
+	stats := server.Stats().Priorities
+	_assert(stats["low"].Handled == burst && stats["high"].Handled == 1 && stats["low"].Queued == 0,
+		"expect every call handled, but got %+v", stats)
+	_ = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply) // normal, the default
+	_assert(server.Stats().Priorities["normal"].Handled == 1, "expect a call without priority handled as normal")
+}
+
+func TestServer_PriorityQueueFull(t *testing.T) {
+	server := NewServer(WithWorkers(1, 0), WithMaxQueued(1))
+	s := sleeper{release: make(chan struct{})}
+	_ = server.Register(s)
+	client, err := Dial("tcp", startServer(t, server).Addr().String(), &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	running := client.Go("sleeper.Wait", 0, new(int), nil)
+	waitFor(t, func() bool { return server.Stats().InFlight == 1 && server.Stats().Priorities["normal"].Queued == 0 }, "expect the worker busy")
+	queued := client.Go("sleeper.Wait", 0, new(int), nil)
+	waitFor(t, func() bool { return server.Stats().Priorities["normal"].Queued == 1 }, "expect a call queued")
+	err = client.Call("sleeper.Wait", 0, new(int))
+	_assert(errors.Is(err, ErrResourceExhausted) && RetryAfter(err) > 0, "expect the queue full with a hint, but got %v", err)
+	close(s.release)
+	_assert((<-running.Done).Error == nil && (<-queued.Done).Error == nil, "expect the calls queued before handled")
+}
+
+func TestScheduler_Stop(t *testing.T) {
+	s := newScheduler(0, 0, time.Now)
+	s.start(2)
+	var ran int32
+	_ = s.push(PriorityNormal, func() { atomic.AddInt32(&ran, 1) })
+	s.stop()
+	s.workers.Wait()
+	done := make(chan struct{})
+	_ = s.push(PriorityNormal, func() { close(done) })
+	<-done
+	_assert(atomic.LoadInt32(&ran) == 1, "expect the calls queued before stop run")
+}
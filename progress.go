@@ -0,0 +1,123 @@
+package tinyrpc
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"tinyrpc/codec"
+)
+
+// progressReportHeader in the metadata of a request asks for the progress
+// reports of its handler, see WithProgress. Older servers ignore it.
+const progressReportHeader = "tinyrpc-progress-report"
+
+// The metadata keys of a progress report, on a progress frame.
+const (
+	progressDoneKey  = "done"
+	progressTotalKey = "total"
+	progressNoteKey  = "note"
+)
+
+// ProgressReport is the progress a handler reported, see Progress.Report.
+type ProgressReport struct {
+	Done, Total int64 // of the units of work of the call, Total 0 if unknown
+	Note        string
+}
+
+// Progress reports the progress of a call to its client, which asked for
+// it with WithProgress. A nil Progress, that of a call whose client
+// didn't ask, ignores the reports.
+type Progress struct {
+	server *Server
+	sc     *serverConn
+	req    *request
+
+	mu     sync.Mutex // protect following
+	closed bool       // the handler returned
+}
+
+type progressKey struct{}
+
+// ProgressFromContext returns the Progress of the call handled with ctx,
+// nil if its client didn't ask for reports or outside a handler.
+func ProgressFromContext(ctx context.Context) *Progress {
+	p, _ := ctx.Value(progressKey{}).(*Progress)
+	return p
+}
+
+// newProgress returns the Progress of req, nil if its client didn't ask.
+func (server *Server) newProgress(sc *serverConn, req *request) *Progress {
+	if req.h.Metadata[progressReportHeader] == "" {
+		return nil
+	}
+	return &Progress{server: server, sc: sc, req: req}
+}
+
+// Report sends done out of total units of work, and note, to the client
+// in a progress frame, before the response. Reports made once the
+// handler returned are dropped.
+func (p *Progress) Report(done, total int64, note string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	md := map[string]string{
+		progressDoneKey:  strconv.FormatInt(done, 10),
+		progressTotalKey: strconv.FormatInt(total, 10),
+	}
+	if note != "" {
+		md[progressNoteKey] = note
+	}
+	h := &codec.Header{ServiceMethod: progressMethod, Seq: p.req.h.Seq, Metadata: md}
+	p.server.sendResponse(p.sc.writer(p.req), h, invalidRequest)
+}
+
+// close drops the reports made from now on, so that none follows the
+// response.
+func (p *Progress) close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+}
+
+type progressReportOption struct{ fn func(ProgressReport) }
+
+func (o progressReportOption) before(call *Call) {
+	if call.Metadata == nil {
+		call.Metadata = make(Metadata)
+	}
+	call.Metadata[progressReportHeader] = "1"
+	call.onProgress = o.fn
+}
+
+func (progressReportOption) after(*Call) {}
+
+// WithProgress asks the handler of the call for its progress reports
+// (see ProgressFromContext) and passes each one to fn, before the call
+// returns. fn is called by the goroutine reading the responses of the
+// client, so it must not block. The reports also count as progress for
+// WithProgressKeepalive.
+func WithProgress(fn func(ProgressReport)) CallOption {
+	return progressReportOption{fn}
+}
+
+// progressReport returns the report carried by the metadata of a
+// progress frame, false if there is none, e.g. in a keepalive frame.
+func progressReport(md Metadata) (ProgressReport, bool) {
+	s, ok := md[progressDoneKey]
+	if !ok {
+		return ProgressReport{}, false
+	}
+	var r ProgressReport
+	r.Done, _ = strconv.ParseInt(s, 10, 64)
+	r.Total, _ = strconv.ParseInt(md[progressTotalKey], 10, 64)
+	r.Note = md[progressNoteKey]
+	return r, true
+}
@@ -0,0 +1,46 @@
+package tinyrpc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// Import reports its progress, one row at a time.
+type Import int
+
+func (i *Import) Rows(ctx context.Context, rows int, reply *int) error {
+	p := ProgressFromContext(ctx)
+	for done := 1; done <= rows; done++ {
+		p.Report(int64(done), int64(rows), fmt.Sprintf("row %d", done))
+	}
+	*reply = rows
+	return nil
+}
+
+func TestProgress(t *testing.T) {
+	server := NewServer()
+	var imp Import
+	_ = server.Register(&imp)
+	client, err := Dial("tcp", startServer(t, server).Addr().String(), &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reports []ProgressReport
+	var reply int
+	err = client.Call("Import.Rows", 3, &reply, WithProgress(func(r ProgressReport) {
+		_assert(reply == 0, "expect the reports before the reply")
+		reports = append(reports, r)
+	}))
+	_assert(err == nil && reply == 3, "call error: %v", err)
+	_assert(len(reports) == 3, "expect 3 reports, but got %v", reports)
+	for i, r := range reports {
+		want := ProgressReport{Done: int64(i + 1), Total: 3, Note: fmt.Sprintf("row %d", i+1)}
+		_assert(r == want, "expect report %+v, but got %+v", want, r)
+	}
+
+	// without opting in, Report is a no-op
+	err = client.Call("Import.Rows", 3, &reply)
+	_assert(err == nil && reply == 3, "expect the reports ignored, but got %v", err)
+	_assert(ProgressFromContext(context.Background()) == nil, "expect no Progress outside a handler")
+}
@@ -0,0 +1,275 @@
+// Package proxy forwards the calls of a tinyrpc server to backends found
+// by discovery, by the prefix of their service name, without decoding
+// their bodies.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"tinyrpc"
+	"tinyrpc/codec"
+	"tinyrpc/xclient"
+)
+
+// ForwardedPeerHeader is the metadata key of the addresses of the peers a
+// request was forwarded for, the first one first, separated by ", ".
+const ForwardedPeerHeader = "x-forwarded-peer"
+
+// ErrBackendUnavailable is returned for calls to a backend whose circuit
+// breaker is open, see Config.FailureThreshold.
+var ErrBackendUnavailable = errors.New("rpc proxy: backend unavailable")
+
+// ErrUnframedBody is returned for calls whose body the proxy can't forward
+// without decoding it: the body must be in a body codec, see
+// tinyrpc.WithBodyCodec.
+var ErrUnframedBody = errors.New("rpc proxy: body must be in a body codec")
+
+// Config configures a Proxy. Zero fields take the defaults below.
+type Config struct {
+	Timeout          time.Duration // of a forwarded call, DefaultTimeout; negative for none
+	FailureThreshold int           // consecutive failures opening the breaker of a backend, DefaultFailureThreshold
+	OpenTime         time.Duration // how long an open breaker fails the calls before one is let through, DefaultOpenTime
+}
+
+// Defaults of Config.
+const (
+	DefaultTimeout          = 10 * time.Second
+	DefaultFailureThreshold = 5
+	DefaultOpenTime         = 5 * time.Second
+)
+
+// Proxy is the raw handler of a server forwarding the calls it doesn't
+// serve itself, see tinyrpc.Server.HandleRaw. Each backend is a
+// discovery of servers serving the services of a prefix; a call goes to
+// the backend of the longest prefix of its service name. The body and
+// the reply are passed through as encoded, so clients must send them in
+// a body codec (tinyrpc.WithBodyCodec) on connections allowing them.
+type Proxy struct {
+	cfg Config
+	now func() time.Time
+
+	mu       sync.RWMutex // protect following
+	backends []*backend   // by prefix, the longest first
+}
+
+// backend is the servers serving the services of a prefix.
+type backend struct {
+	prefix  string
+	xc      *xclient.XClient
+	breaker breaker
+}
+
+// New returns a proxy without backends.
+func New(cfg Config) *Proxy {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = DefaultFailureThreshold
+	}
+	if cfg.OpenTime <= 0 {
+		cfg.OpenTime = DefaultOpenTime
+	}
+	return &Proxy{cfg: cfg, now: time.Now}
+}
+
+// Route forwards the calls to the services whose name starts with prefix
+// to the servers of d, selected according to mode and dialed with opts,
+// as by xclient.NewXClient. The connections allow body codecs whatever
+// the options say. A prefix routed already is replaced.
+func (p *Proxy) Route(prefix string, d xclient.Discovery, mode xclient.SelectMode, opts ...tinyrpc.ClientOption) error {
+	opt, err := tinyrpc.MergeOptions(opts...)
+	if err != nil {
+		return err
+	}
+	opt.AllowBodyCodecs = true
+	dialOpts := []tinyrpc.ClientOption{opt}
+	for _, o := range opts {
+		if _, ok := o.(*tinyrpc.Option); !ok {
+			dialOpts = append(dialOpts, o)
+		}
+	}
+	b := &backend{prefix: prefix, xc: xclient.NewXClient(d, mode, dialOpts...)}
+	b.breaker.init(p.cfg, p.now)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, old := range p.backends {
+		if old.prefix == prefix {
+			_ = old.xc.Close()
+			p.backends = append(p.backends[:i], p.backends[i+1:]...)
+			break
+		}
+	}
+	p.backends = append(p.backends, b)
+	sort.SliceStable(p.backends, func(i, j int) bool {
+		return len(p.backends[i].prefix) > len(p.backends[j].prefix)
+	})
+	return nil
+}
+
+// Close closes the connections to all backends.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, b := range p.backends {
+		_ = b.xc.Close()
+	}
+	p.backends = nil
+	return nil
+}
+
+// lookup returns the backend of serviceMethod, nil if none.
+func (p *Proxy) lookup(serviceMethod string) *backend {
+	service := serviceMethod
+	if dot := strings.LastIndex(serviceMethod, "."); dot >= 0 {
+		service = serviceMethod[:dot]
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, b := range p.backends {
+		if strings.HasPrefix(service, b.prefix) {
+			return b
+		}
+	}
+	return nil
+}
+
+// Handle forwards a call to its backend, with its metadata and with the
+// address of the peer added to ForwardedPeerHeader, and returns the
+// reply with the trailer of the backend. It is a tinyrpc.RawHandler.
+// Calls to services of no backend fail with tinyrpc.ErrMethodNotFound.
+func (p *Proxy) Handle(ctx context.Context, serviceMethod string, body tinyrpc.RawBody) (interface{}, error) {
+	b := p.lookup(serviceMethod)
+	if b == nil {
+		return nil, fmt.Errorf("%w: rpc proxy: no backend for %s", tinyrpc.ErrMethodNotFound, serviceMethod)
+	}
+	args, err := body.Bytes()
+	if errors.Is(err, tinyrpc.ErrNoRawBytes) {
+		return nil, ErrUnframedBody
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := b.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	opts := forwardHeader(ctx)
+	var trailer tinyrpc.Metadata
+	opts = append(opts, tinyrpc.WithBodyCodec(body.BodyCodec()), tinyrpc.WithTrailer(&trailer))
+	callCtx := ctx
+	if p.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, p.cfg.Timeout)
+		defer cancel()
+	}
+	var reply codec.Encoded
+	err = b.xc.Call(callCtx, serviceMethod, codec.Encoded(args), &reply, opts...)
+	if ctx.Err() == nil {
+		b.breaker.record(failed(err))
+	} else {
+		b.breaker.abandon() // the caller giving up says nothing of the backend
+	}
+	for k, v := range trailer {
+		tinyrpc.SetTrailer(ctx, k, v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// forwardHeader returns the options sending the metadata of the request
+// handled with ctx, with the peer added. The signature of the request is
+// not forwarded: it is bound to the connection of the peer.
+func forwardHeader(ctx context.Context) []tinyrpc.CallOption {
+	md := tinyrpc.HeaderFromContext(ctx)
+	opts := make([]tinyrpc.CallOption, 0, len(md)+1)
+	for k, v := range md {
+		switch k {
+		case ForwardedPeerHeader, tinyrpc.SignatureKeyHeader, tinyrpc.SignatureTimeHeader, tinyrpc.SignatureHeader:
+			continue
+		}
+		opts = append(opts, tinyrpc.WithHeader(k, v))
+	}
+	peers := md[ForwardedPeerHeader]
+	if state, ok := tinyrpc.ConnStateFromContext(ctx); ok && state.RemoteAddr != "" {
+		if peers != "" {
+			peers += ", "
+		}
+		peers += state.RemoteAddr
+	}
+	if peers != "" {
+		opts = append(opts, tinyrpc.WithHeader(ForwardedPeerHeader, peers))
+	}
+	return opts
+}
+
+// failed reports whether err counts against the breaker of a backend:
+// the call didn't get a response in time, or at all. The errors of the
+// methods called don't.
+func failed(err error) bool {
+	if err == nil {
+		return false
+	}
+	return !tinyrpc.IsServerError(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// breaker fails the calls to a backend for OpenTime once
+// FailureThreshold calls in a row failed, then lets one call through:
+// the breaker closes if it succeeds, and opens again if it fails.
+type breaker struct {
+	threshold int
+	openTime  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex // protect following
+	failures  int        // in a row
+	openUntil time.Time  // zero if closed
+	probing   bool       // a call is let through the open breaker
+}
+
+func (b *breaker) init(cfg Config, now func() time.Time) {
+	b.threshold, b.openTime, b.now = cfg.FailureThreshold, cfg.OpenTime, now
+}
+
+// allow returns ErrBackendUnavailable if the breaker is open.
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if b.probing || b.now().Before(b.openUntil) {
+		return ErrBackendUnavailable
+	}
+	b.probing = true
+	return nil
+}
+
+// abandon ends a call without a verdict on the backend.
+func (b *breaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *breaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !failed {
+		b.failures, b.openUntil = 0, time.Time{}
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold || !b.openUntil.IsZero() {
+		b.openUntil = b.now().Add(b.openTime)
+	}
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"tinyrpc"
+	"tinyrpc/codec"
+	"tinyrpc/xclient"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+// Echo answers with the name of its backend and the metadata it got.
+type Echo struct{ backend string }
+
+type EchoReply struct {
+	Backend string
+	Arg     string
+	Header  map[string]string
+}
+
+func (e *Echo) Say(ctx context.Context, arg string, reply *EchoReply) error {
+	if arg == "fail" {
+		return tinyrpc.ErrInvalidArgument
+	}
+	if arg == "slow" {
+		time.Sleep(200 * time.Millisecond)
+	}
+	tinyrpc.SetTrailer(ctx, "served-by", e.backend)
+	*reply = EchoReply{Backend: e.backend, Arg: arg, Header: tinyrpc.HeaderFromContext(ctx)}
+	return nil
+}
+
+// startServer starts server on a local address and returns it.
+func startServer(t *testing.T, server *tinyrpc.Server) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	go server.Accept(lis)
+	t.Cleanup(func() { _ = lis.Close() })
+	return "tcp@" + lis.Addr().String()
+}
+
+// startBackend starts a server with Echo registered as service.
+func startBackend(t *testing.T, service, backend string) string {
+	t.Helper()
+	server := tinyrpc.NewServer()
+	_ = server.RegisterName(service, &Echo{backend: backend})
+	return startServer(t, server)
+}
+
+// startProxy starts a server handling its calls with p.
+func startProxy(t *testing.T, p *Proxy) *tinyrpc.Client {
+	t.Helper()
+	server := tinyrpc.NewServer()
+	server.HandleRaw(p.Handle)
+	t.Cleanup(func() { _ = p.Close() })
+	client, err := tinyrpc.XDial(startServer(t, server), &tinyrpc.Option{HeartbeatIdle: -1, AllowBodyCodecs: true})
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestProxy_Routes(t *testing.T) {
+	p := New(Config{})
+	_ = p.Route("Users", xclient.NewMultiServerDiscovery([]string{startBackend(t, "UsersV1", "users")}), xclient.RandomSelect)
+	_ = p.Route("Users.", xclient.NewMultiServerDiscovery(nil), xclient.RandomSelect) // matches no service name
+	_ = p.Route("Orders", xclient.NewMultiServerDiscovery([]string{startBackend(t, "Orders", "orders")}), xclient.RandomSelect)
+	client := startProxy(t, p)
+
+	json := tinyrpc.WithBodyCodec(codec.JsonType)
+	for method, backend := range map[string]string{"UsersV1.Say": "users", "Orders.Say": "orders"} {
+		var reply EchoReply
+		var trailer tinyrpc.Metadata
+		err := client.Call(method, "hi", &reply, json, tinyrpc.WithHeader("request-id", "42"), tinyrpc.WithTrailer(&trailer))
+		_assert(err == nil, "%s: call error: %v", method, err)
+		_assert(reply.Backend == backend && reply.Arg == "hi", "%s: expect %s to answer, but got %+v", method, backend, reply)
+		_assert(reply.Header["request-id"] == "42", "%s: expect the metadata forwarded, but got %v", method, reply.Header)
+		peer := reply.Header[ForwardedPeerHeader]
+		_assert(strings.HasPrefix(peer, "127.0.0.1:"), "%s: expect the peer added, but got %q", method, peer)
+		_assert(trailer["served-by"] == backend, "%s: expect the trailer forwarded, but got %v", method, trailer)
+	}
+
+	var reply EchoReply
+	err := client.Call("Orders.Say", "fail", &reply, json)
+	_assert(errors.Is(err, tinyrpc.ErrInvalidArgument), "expect the backend error forwarded, but got %v", err)
+	err = client.Call("Payments.Say", "hi", &reply, json)
+	_assert(errors.Is(err, tinyrpc.ErrMethodNotFound), "expect method not found for no backend, but got %v", err)
+	err = client.Call("Orders.Say", "hi", &reply)
+	_assert(err != nil && err.Error() == ErrUnframedBody.Error(), "expect gob bodies refused, but got %v", err)
+	err = client.Call("Orders.Say", "hi", &reply, json)
+	_assert(err == nil && reply.Backend == "orders", "expect the connection to survive, but got %v", err)
+}
+
+func TestProxy_Timeout(t *testing.T) {
+	p := New(Config{Timeout: 50 * time.Millisecond})
+	_ = p.Route("Echo", xclient.NewMultiServerDiscovery([]string{startBackend(t, "Echo", "echo")}), xclient.RandomSelect)
+	client := startProxy(t, p)
+
+	start := time.Now()
+	var reply EchoReply
+	err := client.Call("Echo.Say", "slow", &reply, tinyrpc.WithBodyCodec(codec.JsonType))
+	_assert(errors.Is(err, context.DeadlineExceeded), "expect the proxy to time out, but got %v", err)
+	_assert(time.Since(start) < 150*time.Millisecond, "expect the proxy timeout, but took %s", time.Since(start))
+}
+
+func TestProxy_Breaker(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	dead := "tcp@" + lis.Addr().String()
+	_ = lis.Close()
+
+	p := New(Config{FailureThreshold: 2, OpenTime: time.Minute})
+	now := time.Now()
+	p.now = func() time.Time { return now }
+	_ = p.Route("Echo", xclient.NewMultiServerDiscovery([]string{dead}), xclient.RandomSelect)
+	_ = p.Route("Live", xclient.NewMultiServerDiscovery([]string{startBackend(t, "Live", "live")}), xclient.RandomSelect)
+	client := startProxy(t, p)
+
+	json := tinyrpc.WithBodyCodec(codec.JsonType)
+	var reply EchoReply
+	for i := 0; i < 2; i++ {
+		err := client.Call("Echo.Say", "hi", &reply, json)
+		_assert(err != nil && !strings.Contains(err.Error(), ErrBackendUnavailable.Error()), "call %d: expect a dial error, but got %v", i, err)
+	}
+	err = client.Call("Echo.Say", "hi", &reply, json)
+	_assert(err != nil && err.Error() == ErrBackendUnavailable.Error(), "expect the breaker open, but got %v", err)
+	err = client.Call("Live.Say", "hi", &reply, json)
+	_assert(err == nil && reply.Backend == "live", "expect the other backend unaffected, but got %v", err)
+
+	now = now.Add(time.Minute)
+	err = client.Call("Echo.Say", "hi", &reply, json)
+	_assert(err != nil && err.Error() != ErrBackendUnavailable.Error(), "expect a call let through, but got %v", err)
+	err = client.Call("Echo.Say", "hi", &reply, json)
+	_assert(err != nil && err.Error() == ErrBackendUnavailable.Error(), "expect the breaker open again, but got %v", err)
+}
@@ -0,0 +1,140 @@
+package tinyrpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PROXY protocol support, see
+// https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt.
+// Load balancers prepend a header with the real client address
+// to the connection before any application data.
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var errProxyHeader = errors.New("rpc server: invalid PROXY protocol header")
+
+// proxyConn reports the source address advertised in the PROXY header.
+type proxyConn struct {
+	io.ReadWriteCloser
+	r      *bufio.Reader
+	remote net.Addr // nil if the header carried no address (LOCAL / UNKNOWN)
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// RemoteAddr returns the advertised source address, or the address of
+// the underlying connection if there is none.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	if conn, ok := c.ReadWriteCloser.(interface{ RemoteAddr() net.Addr }); ok {
+		return conn.RemoteAddr()
+	}
+	return nil
+}
+
+// readProxyHeader consumes a v1 or v2 PROXY header from conn.
+// A missing or malformed header is an error.
+func readProxyHeader(conn io.ReadWriteCloser) (*proxyConn, error) {
+	r := bufio.NewReader(conn)
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	var remote net.Addr
+	switch {
+	case bytes.Equal(sig, proxyV2Signature):
+		remote, err = readProxyV2(r)
+	case bytes.HasPrefix(sig, []byte("PROXY ")):
+		remote, err = readProxyV1(r)
+	default:
+		err = errProxyHeader
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{ReadWriteCloser: conn, r: r, remote: remote}, nil
+}
+
+// readProxyV1 parses "PROXY TCP4 src dst sport dport\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 { // the longest valid v1 header
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errProxyHeader
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) == 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyV2 parses the binary header: signature, version and command,
+// family and transport, address length and the addresses.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var head [16]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	if head[12]>>4 != 2 {
+		return nil, errProxyHeader
+	}
+	addrs := make([]byte, binary.BigEndian.Uint16(head[14:]))
+	if _, err := io.ReadFull(r, addrs); err != nil {
+		return nil, err
+	}
+	switch head[12] & 0x0f {
+	case 0x0: // LOCAL, e.g. health checks of the proxy itself
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, errProxyHeader
+	}
+	switch head[13] {
+	case 0x11: // TCP over IPv4
+		if len(addrs) < 12 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(addrs[0:4]), Port: int(binary.BigEndian.Uint16(addrs[8:]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(addrs) < 36 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(addrs[0:16]), Port: int(binary.BigEndian.Uint16(addrs[32:]))}, nil
+	case 0x31: // unix stream
+		if len(addrs) < 216 {
+			return nil, errProxyHeader
+		}
+		return &net.UnixAddr{Name: string(bytes.TrimRight(addrs[:108], "\x00")), Net: "unix"}, nil
+	case 0x00: // UNSPEC
+		return nil, nil
+	default:
+		return nil, errProxyHeader
+	}
+}
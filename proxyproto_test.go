@@ -0,0 +1,85 @@
+package tinyrpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+func proxyV2Header(ip net.IP, port uint16) []byte {
+	var buf bytes.Buffer
+	buf.Write(proxyV2Signature)
+	buf.Write([]byte{0x21, 0x11, 0, 12}) // v2 PROXY, TCP over IPv4, 12 address bytes
+	buf.Write(ip.To4())
+	buf.Write(net.IPv4(10, 0, 0, 1).To4())
+	_ = binary.Write(&buf, binary.BigEndian, port)
+	_ = binary.Write(&buf, binary.BigEndian, uint16(9999))
+	return buf.Bytes()
+}
+
+type nopCloser struct{ io.ReadWriter }
+
+func (nopCloser) Close() error { return nil }
+
+func TestReadProxyHeader(t *testing.T) {
+	cases := []struct {
+		header string
+		addr   string
+	}{
+		{"PROXY TCP4 192.0.2.7 10.0.0.1 5678 9999\r\n", "192.0.2.7:5678"},
+		{"PROXY TCP6 2001:db8::7 2001:db8::1 5678 9999\r\n", "[2001:db8::7]:5678"},
+		{string(proxyV2Header(net.IPv4(192, 0, 2, 7), 5678)), "192.0.2.7:5678"},
+	}
+	for _, c := range cases {
+		pc, err := readProxyHeader(nopCloser{bytes.NewBufferString(c.header + "rest")})
+		if err != nil {
+			t.Fatalf("read %q error: %v", c.header, err)
+		}
+		_assert(pc.RemoteAddr().String() == c.addr, "expect %s, but got %s", c.addr, pc.RemoteAddr())
+		rest, _ := io.ReadAll(pc)
+		_assert(string(rest) == "rest", "expect the data after the header, but got %q", rest)
+	}
+	for _, bad := range []string{"GET / HTTP/1.1\r\n\r\n", "PROXY TCP4 nonsense\r\n", "PROXY TCP4 192.0.2.7 10.0.0.1 5678"} {
+		_, err := readProxyHeader(nopCloser{bytes.NewBufferString(bad)})
+		_assert(err != nil, "expect an error for %q", bad)
+	}
+}
+
+func TestServer_ProxyProtocol(t *testing.T) {
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	server.SetAcceptProxyProtocol(true)
+
+	for _, header := range [][]byte{
+		[]byte("PROXY TCP4 192.0.2.7 10.0.0.1 5678 9999\r\n"),
+		proxyV2Header(net.IPv4(192, 0, 2, 7), 5678),
+	} {
+		c, s := net.Pipe()
+		go server.ServeConn(s)
+		if _, err := c.Write(header); err != nil {
+			t.Fatal("write header error:", err)
+		}
+		client, err := NewClient(c, DefaultOption)
+		if err != nil {
+			t.Fatal("handshake error:", err)
+		}
+		var reply int
+		err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+		_ = client.Close()
+	}
+
+	// without a header the connection is rejected
+	c, s := net.Pipe()
+	go server.ServeConn(s)
+	client, err := NewClient(c, DefaultOption)
+	if err == nil {
+		var reply int
+		err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_ = client.Close()
+	}
+	_assert(err != nil, "expect a connection without PROXY header to be rejected")
+}
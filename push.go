@@ -0,0 +1,397 @@
+package tinyrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"tinyrpc/codec"
+	"tinyrpc/rpclog"
+)
+
+// publishMethod is the ServiceMethod of the frames pushing a published
+// message to a subscribed client. Like GoAway, they have Seq 0, so
+// clients without subscriptions drop them. The topic is in the metadata.
+const publishMethod = "_ctrl_.Publish"
+
+// DefaultPushQueue is the number of messages queued per connection, and
+// per subscription on the client, before new messages are dropped.
+const DefaultPushQueue = 64
+
+// RawMessage is a message published on a topic, encoded with the codec
+// of the connection it arrived on.
+type RawMessage struct {
+	Topic     string
+	Data      []byte
+	Retained  bool // published before the subscription, see WithRetain
+	codecType codec.Type
+}
+
+// Decode decodes the message into v, a pointer.
+func (m RawMessage) Decode(v interface{}) error {
+	return unmarshal(m.codecType, m.Data, v)
+}
+
+func marshal(t codec.Type, v interface{}) ([]byte, error) {
+	if t != codec.GobType {
+		return nil, errors.New("rpc: cannot encode messages with codec " + string(t))
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func unmarshal(t codec.Type, data []byte, v interface{}) error {
+	if t != codec.GobType {
+		return errors.New("rpc: cannot decode messages with codec " + string(t))
+	}
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// pubsub is the subscriptions of the connections of a server.
+type pubsub struct {
+	mu        sync.Mutex                          // protect following
+	topics    map[string]map[*serverConn]struct{} // by the topic or pattern subscribed to
+	wildcards map[string]struct{}                 // the patterns of topics
+	retained  map[string]*retainedMessage         // by topic, see WithRetain
+	dropped   uint64                              // accessed atomically
+}
+
+// pushQueue sends the published messages of one connection in order.
+type pushQueue struct {
+	codecType codec.Type
+	frames    chan RawMessage
+	topics    map[string]struct{} // protected by pubsub.mu
+	dropped   map[string]uint64   // by topic subscribed to, protected by pubsub.mu
+}
+
+// retainedMessage is the last message published on a topic with WithRetain.
+type retainedMessage struct {
+	msg     interface{}
+	encoded map[codec.Type][]byte
+}
+
+// data returns the message encoded with t.
+func (m *retainedMessage) data(t codec.Type) ([]byte, error) {
+	if data, ok := m.encoded[t]; ok {
+		return data, nil
+	}
+	data, err := marshal(t, m.msg)
+	if err == nil {
+		m.encoded[t] = data
+	}
+	return data, err
+}
+
+// isPattern reports whether topic holds wildcards, see Client.Subscribe.
+func isPattern(topic string) bool {
+	return strings.Contains(topic, "*")
+}
+
+// matchTopic reports whether topic matches pattern: their segments
+// separated by dots are equal, or the one of pattern is "*".
+func matchTopic(pattern, topic string) bool {
+	if !isPattern(pattern) {
+		return pattern == topic
+	}
+	for {
+		p, pRest, pMore := strings.Cut(pattern, ".")
+		t, tRest, tMore := strings.Cut(topic, ".")
+		if (p != "*" || t == "") && p != t {
+			return false
+		}
+		if !pMore || !tMore {
+			return pMore == tMore
+		}
+		pattern, topic = pRest, tRest
+	}
+}
+
+// subscribe subscribes sc to topic, and returns the retained messages
+// matching it, encoded for sc.
+func (server *Server) subscribe(sc *serverConn, topic string) []RawMessage {
+	ps := &server.pubsub
+	sc.mu.Lock()
+	if sc.push == nil {
+		sc.push = &pushQueue{
+			codecType: sc.codecType,
+			frames:    make(chan RawMessage, DefaultPushQueue),
+			topics:    make(map[string]struct{}),
+		}
+		go server.sendPushes(sc, sc.push)
+	}
+	q := sc.push
+	sc.mu.Unlock()
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.topics == nil {
+		ps.topics = make(map[string]map[*serverConn]struct{})
+	}
+	if ps.topics[topic] == nil {
+		ps.topics[topic] = make(map[*serverConn]struct{})
+	}
+	ps.topics[topic][sc] = struct{}{}
+	q.topics[topic] = struct{}{}
+	if isPattern(topic) {
+		if ps.wildcards == nil {
+			ps.wildcards = make(map[string]struct{})
+		}
+		ps.wildcards[topic] = struct{}{}
+	}
+	var retained []RawMessage
+	for t, m := range ps.retained {
+		if !matchTopic(topic, t) {
+			continue
+		}
+		data, err := m.data(sc.codecType)
+		if err != nil {
+			server.log(rpclog.LevelError, "encode retained message error", "topic", t, "err", err)
+			continue
+		}
+		retained = append(retained, RawMessage{Topic: t, Data: data, Retained: true})
+	}
+	sort.Slice(retained, func(i, j int) bool { return retained[i].Topic < retained[j].Topic })
+	return retained
+}
+
+func (server *Server) unsubscribe(sc *serverConn, topic string) {
+	ps := &server.pubsub
+	sc.mu.Lock()
+	q := sc.push
+	sc.mu.Unlock()
+	if q == nil {
+		return
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	delete(q.topics, topic)
+	delete(q.dropped, topic)
+	server.unsubscribeLocked(sc, topic)
+}
+
+// unsubscribeAll removes the subscriptions of a closing connection and
+// stops its push goroutine.
+func (server *Server) unsubscribeAll(sc *serverConn) {
+	sc.mu.Lock()
+	q := sc.push
+	sc.mu.Unlock()
+	if q == nil {
+		return
+	}
+	ps := &server.pubsub
+	ps.mu.Lock()
+	for topic := range q.topics {
+		server.unsubscribeLocked(sc, topic)
+	}
+	close(q.frames) // Publish only sends under ps.mu to subscribed conns
+	ps.mu.Unlock()
+}
+
+// unsubscribeLocked removes sc from the subscribers of topic. pubsub.mu must be held.
+func (server *Server) unsubscribeLocked(sc *serverConn, topic string) {
+	ps := &server.pubsub
+	if subs := ps.topics[topic]; subs != nil {
+		delete(subs, sc)
+		if len(subs) == 0 {
+			delete(ps.topics, topic)
+			delete(ps.wildcards, topic)
+		}
+	}
+}
+
+// sendEncoded writes a message encoded for every connection of its
+// codec, see codec.EncodedWriter.
+func (server *Server) sendEncoded(cc codec.Codec, h *codec.Header, data []byte) error {
+	err := writeEncoded(cc, h, data)
+	if err != nil {
+		server.log(rpclog.LevelError, "write response error", "err", err)
+	}
+	return err
+}
+
+func (server *Server) sendPushes(sc *serverConn, q *pushQueue) {
+	cc := sc.cc
+	if sc.bulk != nil {
+		cc = sc.bulk
+	}
+	for m := range q.frames {
+		h := &codec.Header{ServiceMethod: publishMethod, Metadata: map[string]string{"topic": m.Topic}}
+		server.sendEncoded(cc, h, m.Data)
+	}
+}
+
+// PublishOption configures a call to Server.Publish.
+type PublishOption func(c *publishConfig)
+
+type publishConfig struct {
+	retain bool
+}
+
+// WithRetain makes the server keep the message as the last one of its
+// topic, replacing the one kept before, and send it to the connections
+// subscribing to the topic later, before the messages published after.
+// The message must not be modified after Publish. See ClearRetained.
+func WithRetain() PublishOption {
+	return func(c *publishConfig) { c.retain = true }
+}
+
+// Publish sends msg to every connection subscribed to topic, or to a
+// pattern matching it, encoded with the codec of each connection.
+// Messages for connections whose queue of DefaultPushQueue messages is
+// full are dropped and counted in ServerStats.PushDropped and TopicStats.
+// It returns the number of connections msg was queued for.
+func (server *Server) Publish(topic string, msg interface{}, opts ...PublishOption) (int, error) {
+	if isPattern(topic) {
+		return 0, errors.New("rpc server: publish to a pattern: " + topic)
+	}
+	var c publishConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+	ps := &server.pubsub
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	encoded := make(map[codec.Type][]byte)
+	if c.retain {
+		if ps.retained == nil {
+			ps.retained = make(map[string]*retainedMessage)
+		}
+		ps.retained[topic] = &retainedMessage{msg: msg, encoded: encoded}
+	}
+	var queued int
+	sent := make(map[*serverConn]bool)
+	push := func(pattern string) error {
+		for sc := range ps.topics[pattern] {
+			if sent[sc] {
+				continue // subscribed to several patterns matching topic
+			}
+			sent[sc] = true
+			q := sc.push
+			data, ok := encoded[q.codecType]
+			if !ok {
+				var err error
+				if data, err = marshal(q.codecType, msg); err != nil {
+					return err
+				}
+				encoded[q.codecType] = data
+			}
+			select {
+			case q.frames <- RawMessage{Topic: topic, Data: data}:
+				queued++
+			default:
+				atomic.AddUint64(&ps.dropped, 1)
+				if q.dropped == nil {
+					q.dropped = make(map[string]uint64)
+				}
+				q.dropped[pattern]++
+			}
+		}
+		return nil
+	}
+	if err := push(topic); err != nil {
+		return queued, err
+	}
+	for pattern := range ps.wildcards {
+		if matchTopic(pattern, topic) {
+			if err := push(pattern); err != nil {
+				return queued, err
+			}
+		}
+	}
+	return queued, nil
+}
+
+// ClearRetained forgets the message kept for topic, see WithRetain.
+func (server *Server) ClearRetained(topic string) {
+	ps := &server.pubsub
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	delete(ps.retained, topic)
+}
+
+// TopicStats describes a topic or pattern subscribed to, or a topic
+// with a retained message.
+type TopicStats struct {
+	Topic       string           // e.g. "orders.new" or "orders.*"
+	Subscribers int              // connections subscribed
+	Retained    bool             // a message is retained for the topic, see WithRetain
+	Slow        []SlowSubscriber // the subscribers that dropped messages of the topic
+}
+
+// SlowSubscriber is a connection whose queue of pushed messages was full.
+type SlowSubscriber struct {
+	ConnID  uint64 // see ConnInfo
+	Dropped uint64 // messages of the topic dropped
+}
+
+// TopicStats returns the topics and patterns subscribed to and those
+// retained, sorted by topic, with their slow subscribers sorted by ID.
+func (server *Server) TopicStats() []TopicStats {
+	ps := &server.pubsub
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	stats := make(map[string]*TopicStats)
+	get := func(topic string) *TopicStats {
+		if stats[topic] == nil {
+			stats[topic] = &TopicStats{Topic: topic}
+		}
+		return stats[topic]
+	}
+	for topic, subs := range ps.topics {
+		s := get(topic)
+		s.Subscribers = len(subs)
+		for sc := range subs {
+			if n := sc.push.dropped[topic]; n > 0 {
+				s.Slow = append(s.Slow, SlowSubscriber{ConnID: sc.id, Dropped: n})
+			}
+		}
+		sort.Slice(s.Slow, func(i, j int) bool { return s.Slow[i].ConnID < s.Slow[j].ConnID })
+	}
+	for topic := range ps.retained {
+		get(topic).Retained = true
+	}
+	list := make([]TopicStats, 0, len(stats))
+	for _, s := range stats {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Topic < list[j].Topic })
+	return list
+}
+
+// subscribeService is the built-in service "_sub_" clients call to
+// subscribe to topics on their connection.
+type subscribeService struct{ server *Server }
+
+func (s *subscribeService) Subscribe(ctx context.Context, topic string, reply *bool) error {
+	sc, ok := ctx.Value(connKey{}).(*serverConn)
+	if !ok {
+		return errors.New("rpc server: subscribe outside a connection")
+	}
+	// the retained messages are written before the reply, on the
+	// connection and not the bulk channel, so that the client takes them
+	// for this subscription
+	for _, m := range s.server.subscribe(sc, topic) {
+		h := &codec.Header{ServiceMethod: publishMethod, Metadata: map[string]string{"topic": m.Topic, "retained": topic}}
+		if err := s.server.sendEncoded(sc.cc, h, m.Data); err != nil {
+			return err
+		}
+	}
+	*reply = true
+	return nil
+}
+
+func (s *subscribeService) Unsubscribe(ctx context.Context, topic string, reply *bool) error {
+	sc, ok := ctx.Value(connKey{}).(*serverConn)
+	if !ok {
+		return errors.New("rpc server: unsubscribe outside a connection")
+	}
+	s.server.unsubscribe(sc, topic)
+	*reply = true
+	return nil
+}
@@ -0,0 +1,230 @@
+package tinyrpc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+	"tinyrpc/codec"
+)
+
+type ConfigChange struct {
+	Key, Value string
+	Version    int
+}
+
+func subscribers(server *Server, topic string) int {
+	server.pubsub.mu.Lock()
+	defer server.pubsub.mu.Unlock()
+	return len(server.pubsub.topics[topic])
+}
+
+func waitFor(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	server := NewServer()
+	lis := startServer(t, server)
+	dial := func() *Client {
+		client, err := Dial("tcp", lis.Addr().String())
+		if err != nil {
+			t.Fatal("dial error:", err)
+		}
+		t.Cleanup(func() { _ = client.Close() })
+		return client
+	}
+	fast, slow := dial(), dial()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fastCh, err := fast.Subscribe(ctx, "config")
+	_assert(err == nil, "subscribe error: %v", err)
+	slowCtx, slowCancel := context.WithCancel(context.Background())
+	slowCh, err := slow.Subscribe(slowCtx, "config")
+	_assert(err == nil, "subscribe error: %v", err)
+	_assert(subscribers(server, "config") == 2, "expect 2 subscribers")
+
+	const n = DefaultPushQueue + 36
+	for i := 1; i <= n; i++ {
+		queued, err := server.Publish("config", ConfigChange{Key: "k", Value: "v", Version: i})
+		_assert(err == nil && queued == 2, "expect the message queued twice, but got %d, %v", queued, err)
+		var m RawMessage
+		select {
+		case m = <-fastCh:
+		case <-time.After(time.Second):
+			t.Fatal("message not delivered")
+		}
+		var change ConfigChange
+		_assert(m.Topic == "config" && m.Decode(&change) == nil && change.Version == i,
+			"expect version %d, but got %+v", i, change)
+	}
+	// slow never reads: its channel filled up, the rest was dropped
+	waitFor(t, func() bool { return slow.PushDropped() == n-DefaultPushQueue }, "expect the overflow of slow dropped")
+	_assert(fast.PushDropped() == 0 && len(slowCh) == DefaultPushQueue, "expect nothing dropped for fast")
+
+	// cancelling the context unsubscribes and closes the channel
+	slowCancel()
+	waitFor(t, func() bool { return subscribers(server, "config") == 1 }, "expect slow unsubscribed")
+	for range slowCh {
+	}
+
+	// closing the connection removes its subscriptions
+	_ = fast.Close()
+	waitFor(t, func() bool { return subscribers(server, "config") == 0 }, "expect fast unsubscribed on close")
+	_, ok := <-fastCh
+	_assert(!ok, "expect the channel closed with the client")
+}
+
+func TestPublish_DropFullQueue(t *testing.T) {
+	server := NewServer()
+	sc := &serverConn{codecType: codec.GobType}
+	sc.push = &pushQueue{codecType: sc.codecType, frames: make(chan RawMessage, 1), topics: map[string]struct{}{}}
+	server.pubsub.topics = map[string]map[*serverConn]struct{}{"t": {sc: {}}}
+	queued, _ := server.Publish("t", 1)
+	_assert(queued == 1, "expect the first message queued")
+	queued, _ = server.Publish("t", 2)
+	_assert(queued == 0 && server.Stats().PushDropped == 1, "expect the second message dropped, but got %+v", server.Stats())
+}
+
+// receive returns the messages of ch arriving within a short while.
+func receive(ch <-chan RawMessage) []RawMessage {
+	var msgs []RawMessage
+	for {
+		select {
+		case m := <-ch:
+			msgs = append(msgs, m)
+		case <-time.After(50 * time.Millisecond):
+			return msgs
+		}
+	}
+}
+
+func topicsOf(msgs []RawMessage) []string {
+	var topics []string
+	for _, m := range msgs {
+		topics = append(topics, m.Topic)
+	}
+	return topics
+}
+
+func TestPublish_Wildcard(t *testing.T) {
+	server := NewServer()
+	client, err := Dial("tcp", startServer(t, server).Addr().String())
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+	orders, err := client.Subscribe(ctx, "orders.*")
+	_assert(err == nil, "subscribe error: %v", err)
+	created, err := client.Subscribe(ctx, "orders.created")
+	_assert(err == nil, "subscribe error: %v", err)
+	regional, err := client.Subscribe(ctx, "*.created.*")
+	_assert(err == nil, "subscribe error: %v", err)
+
+	for _, topic := range []string{"orders.created", "orders.paid", "orders.created.eu", "orders", "stock.created"} {
+		queued, err := server.Publish(topic, ConfigChange{Key: topic})
+		_assert(err == nil, "publish error: %v", err)
+		want := 1
+		if topic == "orders" || topic == "stock.created" {
+			want = 0
+		}
+		_assert(queued == want, "%s: expect %d queued once per connection, but got %d", topic, want, queued)
+	}
+	got := topicsOf(receive(orders))
+	_assert(reflect.DeepEqual(got, []string{"orders.created", "orders.paid"}), "orders.* got %v", got)
+	got = topicsOf(receive(created))
+	_assert(reflect.DeepEqual(got, []string{"orders.created"}), "orders.created got %v", got)
+	got = topicsOf(receive(regional))
+	_assert(reflect.DeepEqual(got, []string{"orders.created.eu"}), "*.created.* got %v", got)
+
+	_, err = server.Publish("orders.*", 1)
+	_assert(err != nil, "expect an error publishing to a pattern")
+}
+
+func TestPublish_Retained(t *testing.T) {
+	server := NewServer()
+	client, err := Dial("tcp", startServer(t, server).Addr().String())
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+	_, _ = server.Publish("config.db", ConfigChange{Key: "db", Version: 1}, WithRetain())
+	_, _ = server.Publish("config.db", ConfigChange{Key: "db", Version: 2}, WithRetain())
+	_, _ = server.Publish("config.cache", ConfigChange{Key: "cache", Version: 1}, WithRetain())
+	_, _ = server.Publish("config.log", ConfigChange{Key: "log", Version: 1}) // not retained
+
+	first, err := client.Subscribe(ctx, "config.*")
+	_assert(err == nil, "subscribe error: %v", err)
+	// on subscribe, before Subscribe returns
+	_assert(len(first) == 2, "expect the 2 retained messages delivered on subscribe, but got %d", len(first))
+	msgs := receive(first)
+	_assert(reflect.DeepEqual(topicsOf(msgs), []string{"config.cache", "config.db"}), "expect the retained topics, but got %v", topicsOf(msgs))
+	var change ConfigChange
+	_assert(msgs[1].Retained && msgs[1].Decode(&change) == nil && change.Version == 2, "expect the last db version, but got %+v", change)
+
+	// a second subscription gets them too, the first one not again
+	second, err := client.Subscribe(ctx, "config.db")
+	_assert(err == nil, "subscribe error: %v", err)
+	msgs = receive(second)
+	_assert(len(msgs) == 1 && msgs[0].Topic == "config.db" && msgs[0].Retained, "expect db retained, but got %v", topicsOf(msgs))
+	_assert(len(receive(first)) == 0, "expect no retained message twice")
+
+	_, _ = server.Publish("config.db", ConfigChange{Key: "db", Version: 3})
+	msgs = receive(second)
+	_assert(len(msgs) == 1 && !msgs[0].Retained, "expect the live message, but got %+v", msgs)
+
+	server.ClearRetained("config.db")
+	third, err := client.Subscribe(ctx, "config.db")
+	_assert(err == nil, "subscribe error: %v", err)
+	_assert(len(receive(third)) == 0, "expect nothing retained after ClearRetained")
+}
+
+func TestTopicStats(t *testing.T) {
+	server := NewServer()
+	lis := startServer(t, server)
+	dial := func() *Client {
+		client, err := Dial("tcp", lis.Addr().String())
+		_assert(err == nil, "dial error: %v", err)
+		return client
+	}
+	a, b := dial(), dial()
+	ctx := context.Background()
+	for _, sub := range []struct {
+		client *Client
+		topic  string
+	}{{a, "orders.*"}, {b, "orders.*"}, {b, "orders.paid"}} {
+		_, err := sub.client.Subscribe(ctx, sub.topic)
+		_assert(err == nil, "subscribe error: %v", err)
+	}
+	_, _ = server.Publish("stock", 1, WithRetain())
+
+	// a slow connection, whose queue holds one message
+	slow := &serverConn{id: 99, codecType: codec.GobType}
+	slow.push = &pushQueue{codecType: codec.GobType, frames: make(chan RawMessage, 1), topics: map[string]struct{}{}}
+	server.subscribe(slow, "orders.*")
+	for i := 0; i < 3; i++ {
+		_, _ = server.Publish("orders.new", i)
+	}
+	want := []TopicStats{
+		{Topic: "orders.*", Subscribers: 3, Slow: []SlowSubscriber{{ConnID: 99, Dropped: 2}}},
+		{Topic: "orders.paid", Subscribers: 1},
+		{Topic: "stock", Retained: true},
+	}
+	got := server.TopicStats()
+	_assert(reflect.DeepEqual(got, want), "expect %+v, but got %+v", want, got)
+
+	// the topics go with their last subscriber
+	server.unsubscribe(slow, "orders.*")
+	_ = a.Close()
+	_ = b.Close()
+	server.ClearRetained("stock")
+	waitFor(t, func() bool { return len(server.TopicStats()) == 0 }, "expect no topic left")
+	server.pubsub.mu.Lock()
+	defer server.pubsub.mu.Unlock()
+	_assert(len(server.pubsub.topics) == 0 && len(server.pubsub.wildcards) == 0, "expect the topic maps emptied")
+}
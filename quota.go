@@ -0,0 +1,192 @@
+package tinyrpc
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"tinyrpc/internal/clock"
+)
+
+// A PrincipalFunc returns who makes the call being handled in ctx, ""
+// if unknown.
+type PrincipalFunc func(ctx context.Context) string
+
+// TokenPrincipal is the Option.AuthToken of the connection.
+func TokenPrincipal(ctx context.Context) string { return AuthTokenFromContext(ctx) }
+
+// JWTSubject is the "sub" claim of the Option.AuthToken of the
+// connection, if it is a JWT. It doesn't verify the token, which the
+// authenticator of the server must do, see ServerConfig.Authenticate.
+func JWTSubject(ctx context.Context) string {
+	parts := strings.Split(AuthTokenFromContext(ctx), ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Sub string `json:"sub"`
+	}
+	if json.Unmarshal(payload, &claims) != nil {
+		return ""
+	}
+	return claims.Sub
+}
+
+// TLSPrincipal is the common name of the certificate of the client, if
+// it connected with TLS.
+func TLSPrincipal(ctx context.Context) string {
+	sc, ok := ctx.Value(connKey{}).(*serverConn)
+	if !ok {
+		return ""
+	}
+	conn, ok := sc.conn.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+	if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+		return certs[0].Subject.CommonName
+	}
+	return ""
+}
+
+// Quota limits the calls of one principal. Zero fields mean no limit.
+type Quota struct {
+	RequestsPerMinute int // with a burst of as many
+	MaxInFlight       int // calls being handled at once
+}
+
+// QuotaConfig configures QuotaInterceptor.
+type QuotaConfig struct {
+	Principal  PrincipalFunc    // TokenPrincipal if nil
+	Default    Quota            // of the principals not in Principals, and of ""
+	Principals map[string]Quota // by principal
+	Store      QuotaStore       // NewMemoryQuotaStore() if nil
+	Clock      Clock            // the real clock if nil
+}
+
+// A QuotaStore keeps the usage of the principals. NewMemoryQuotaStore
+// keeps it in memory; other stores may share it between the instances
+// of a server.
+type QuotaStore interface {
+	// TakeRequest counts a request of principal, allowed perMinute of
+	// them. If there are too many, it returns how long to wait instead.
+	TakeRequest(principal string, perMinute int, now time.Time) (retryAfter time.Duration, ok bool, err error)
+	// Acquire counts a call of principal in flight, at most max of them.
+	Acquire(principal string, max int) (ok bool, err error)
+	// Release ends a call counted by Acquire.
+	Release(principal string) error
+}
+
+// QuotaInterceptor enforces the quotas of cfg on the calls of each
+// principal. Calls over their quota fail with ErrResourceExhausted,
+// and a RetryAfterTrailer if over the request rate.
+func QuotaInterceptor(cfg QuotaConfig) Interceptor {
+	principal := cfg.Principal
+	if principal == nil {
+		principal = TokenPrincipal
+	}
+	store := cfg.Store
+	if store == nil {
+		store = NewMemoryQuotaStore()
+	}
+	c := clock.Or(cfg.Clock)
+	return func(ctx context.Context, serviceMethod string, args interface{}, next func(ctx context.Context) error) error {
+		p := principal(ctx)
+		q, ok := cfg.Principals[p]
+		if !ok {
+			q = cfg.Default
+		}
+		if q.RequestsPerMinute > 0 {
+			retryAfter, ok, err := store.TakeRequest(p, q.RequestsPerMinute, c.Now())
+			if err != nil {
+				return err
+			}
+			if !ok {
+				err := fmt.Errorf("%w: %d requests per minute for %q", ErrResourceExhausted, q.RequestsPerMinute, p)
+				return withRetryAfter(err, retryAfter)
+			}
+		}
+		if q.MaxInFlight > 0 {
+			ok, err := store.Acquire(p, q.MaxInFlight)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("%w: %d calls in flight for %q", ErrResourceExhausted, q.MaxInFlight, p)
+			}
+			defer func() { _ = store.Release(p) }()
+		}
+		return next(ctx)
+	}
+}
+
+// memoryQuotaStore is a QuotaStore in memory, see NewMemoryQuotaStore.
+type memoryQuotaStore struct {
+	mu    sync.Mutex // protect following
+	usage map[string]*principalUsage
+}
+
+type principalUsage struct {
+	tokens   float64 // requests allowed now
+	refilled time.Time
+	inflight int
+}
+
+// NewMemoryQuotaStore returns a QuotaStore for one server.
+func NewMemoryQuotaStore() QuotaStore {
+	return &memoryQuotaStore{usage: make(map[string]*principalUsage)}
+}
+
+func (s *memoryQuotaStore) get(principal string) *principalUsage {
+	u := s.usage[principal]
+	if u == nil {
+		u = &principalUsage{tokens: -1}
+		s.usage[principal] = u
+	}
+	return u
+}
+
+func (s *memoryQuotaStore) TakeRequest(principal string, perMinute int, now time.Time) (time.Duration, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.get(principal)
+	burst, rate := float64(perMinute), float64(perMinute)/float64(time.Minute)
+	if u.tokens < 0 {
+		u.tokens = burst // first request
+	} else {
+		u.tokens = math.Min(burst, u.tokens+float64(now.Sub(u.refilled))*rate)
+	}
+	u.refilled = now
+	if u.tokens < 1 {
+		return time.Duration((1 - u.tokens) / rate), false, nil
+	}
+	u.tokens--
+	return 0, true, nil
+}
+
+func (s *memoryQuotaStore) Acquire(principal string, max int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.get(principal)
+	if u.inflight >= max {
+		return false, nil
+	}
+	u.inflight++
+	return true, nil
+}
+
+func (s *memoryQuotaStore) Release(principal string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.get(principal).inflight--
+	return nil
+}
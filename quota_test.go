@@ -0,0 +1,83 @@
+package tinyrpc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+	"tinyrpc/tinyrpctest"
+)
+
+func TestQuotaInterceptor(t *testing.T) {
+	clock := tinyrpctest.NewClock()
+	quotas := QuotaInterceptor(QuotaConfig{
+		Default: Quota{RequestsPerMinute: 2},
+		Principals: map[string]Quota{
+			"batch": {RequestsPerMinute: 60, MaxInFlight: 1},
+		},
+		Clock: clock,
+	})
+	server := NewServer(WithInterceptors(quotas))
+	_assert(server.Register(&Waiter{done: make(chan time.Time, 10)}) == nil, "failed to register Waiter")
+	addr := startServer(t, server).Addr().String()
+	dial := func(token string) *Client {
+		client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1, AuthToken: token})
+		_assert(err == nil, "dial error: %v", err)
+		t.Cleanup(func() { _ = client.Close() })
+		return client
+	}
+	web, batch := dial("web"), dial("batch")
+
+	var wg sync.WaitGroup
+	var webErr, batchErr error
+	var trailer Metadata
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		var reply int
+		for i := 0; i < 2 && webErr == nil; i++ {
+			webErr = web.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		}
+		if webErr == nil {
+			webErr = web.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply, WithTrailer(&trailer))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		var reply int
+		for i := 0; i < 10 && batchErr == nil; i++ {
+			batchErr = batch.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		}
+	}()
+	wg.Wait()
+	_assert(errors.Is(webErr, ErrResourceExhausted), "expect web over its quota, but got %v", webErr)
+	_assert(trailer[RetryAfterTrailer] == "30000", "expect to retry after 30s, but got %v", trailer)
+	_assert(batchErr == nil, "expect batch within its quota, but got %v", batchErr)
+
+	clock.Advance(30 * time.Second)
+	var reply int
+	err := web.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil, "expect web allowed again, but got %v", err)
+
+	call := batch.Go("Waiter.Wait", 200, &reply, make(chan *Call, 1))
+	time.Sleep(50 * time.Millisecond)
+	err = batch.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(errors.Is(err, ErrResourceExhausted), "expect batch over its calls in flight, but got %v", err)
+	_assert((<-call.Done).Error == nil, "expect the call in flight to succeed")
+	err = batch.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil, "expect batch allowed once the call is done, but got %v", err)
+}
+
+func TestJWTSubject(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice","exp":1}`))
+	for token, want := range map[string]string{
+		"e30." + payload + ".sig": "alice",
+		"e30.e30.sig":             "",
+		"opaque":                  "",
+	} {
+		ctx := context.WithValue(context.Background(), connKey{}, &serverConn{authToken: token})
+		_assert(JWTSubject(ctx) == want, "expect subject %q of %q, but got %q", want, token, JWTSubject(ctx))
+	}
+}
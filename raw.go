@@ -0,0 +1,109 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"tinyrpc/codec"
+)
+
+// RawHandler handles the calls to methods that are not registered, see
+// Server.HandleRaw. Its reply is encoded like the reply of a method.
+type RawHandler func(ctx context.Context, serviceMethod string, body RawBody) (interface{}, error)
+
+// RawBody is the undecoded body of a call given to a RawHandler.
+//
+// The body is still in the connection: no other request of the connection
+// is read until it is decoded, or the handler returns. Handlers should
+// read it first.
+type RawBody interface {
+	// Decode decodes the body into v, a pointer, with the codec of the
+	// connection.
+	Decode(v interface{}) error
+	// Bytes returns the encoded body, if the codec of the connection
+	// frames its bodies (see codec.RawReader). The gob codec frames the
+	// bodies in a body codec only. The body is still unread if it fails
+	// with ErrNoRawBytes.
+	Bytes() ([]byte, error)
+	// BodyCodec returns the body codec of the call, see WithBodyCodec,
+	// empty for the codec of the connection. The reply is encoded with it.
+	BodyCodec() codec.Type
+}
+
+// ErrNoRawBytes is returned by RawBody.Bytes when the codec of the
+// connection can't read a body without decoding it.
+var ErrNoRawBytes = errors.New("rpc server: codec can't read raw bodies")
+
+// HandleRaw makes the server pass calls to services or methods that are
+// not registered to h, instead of failing them. Registered services and
+// built-in ones are still called as usual. It must be called before the
+// server starts serving.
+func (server *Server) HandleRaw(h RawHandler) {
+	server.rawHandler = h
+}
+
+// rawBody reads the body of a raw request from the connection. done is
+// closed once it is read, so that the next request can be read.
+type rawBody struct {
+	cc        codec.Codec
+	bodyCodec codec.Type
+	done      chan struct{}
+	mu        sync.Mutex // protect following
+	read      bool
+}
+
+func newRawBody(cc codec.Codec, bodyCodec codec.Type) *rawBody {
+	return &rawBody{cc: cc, bodyCodec: bodyCodec, done: make(chan struct{})}
+}
+
+var errBodyRead = errors.New("rpc server: raw body already read")
+
+// consume reads the body with read, once. A body not framed is left in
+// the connection.
+func (b *rawBody) consume(read func() error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.read {
+		return errBodyRead
+	}
+	err := read()
+	if errors.Is(err, codec.ErrNotFramed) {
+		return ErrNoRawBytes
+	}
+	b.read = true
+	close(b.done)
+	return err
+}
+
+func (b *rawBody) Decode(v interface{}) error {
+	return b.consume(func() error { return b.cc.ReadBody(v) })
+}
+
+func (b *rawBody) Bytes() (body []byte, err error) {
+	r, ok := b.cc.(codec.RawReader)
+	if !ok {
+		return nil, ErrNoRawBytes
+	}
+	err = b.consume(func() (err error) {
+		body, err = r.ReadRawBody()
+		return
+	})
+	return body, err
+}
+
+func (b *rawBody) BodyCodec() codec.Type {
+	return b.bodyCodec
+}
+
+// discard drains the body unless the handler read it.
+func (b *rawBody) discard() {
+	_ = b.consume(func() error { return codec.DiscardBody(b.cc) })
+}
+
+// callRaw calls the raw handler for req.
+func (server *Server) callRaw(ctx context.Context, req *request) error {
+	defer req.raw.discard()
+	reply, err := server.rawHandler(ctx, req.h.ServiceMethod, req.raw)
+	req.rawReply = reply
+	return err
+}
@@ -0,0 +1,88 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// proxySchema gives the argument and reply types of the proxied methods.
+var proxySchema = map[string][2]reflect.Type{
+	"Calc.Mul":  {reflect.TypeOf(Args{}), reflect.TypeOf(Product{})},
+	"Store.Get": {reflect.TypeOf(""), reflect.TypeOf("")},
+}
+
+// newProxy returns a server forwarding the calls it doesn't serve to backend.
+func newProxy(backend *Client) *Server {
+	proxy := NewServer()
+	proxy.HandleRaw(func(ctx context.Context, serviceMethod string, body RawBody) (interface{}, error) {
+		types, ok := proxySchema[serviceMethod]
+		if !ok {
+			return nil, errors.New("proxy: unknown method " + serviceMethod)
+		}
+		args := reflect.New(types[0])
+		if err := body.Decode(args.Interface()); err != nil {
+			return nil, err
+		}
+		reply := reflect.New(types[1])
+		err := backend.CallContext(ctx, serviceMethod, args.Elem().Interface(), reply.Interface())
+		return reply.Interface(), err
+	})
+	return proxy
+}
+
+func TestServer_HandleRaw(t *testing.T) {
+	backendServer := NewServer()
+	var calc Calc
+	var store Store
+	_ = backendServer.Register(&calc)
+	_ = backendServer.Register(&store)
+	backend, err := Dial("tcp", startServer(t, backendServer).Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = backend.Close() }()
+
+	proxy := newProxy(backend) // serves Foo itself
+	client, err := Dial("tcp", startServer(t, proxy).Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var product Product
+	err = client.Call("Calc.Mul", Args{Num1: 2, Num2: 3}, &product)
+	_assert(err == nil && product.Value == 6, "failed to call Calc.Mul through the proxy: %v", err)
+	var value string
+	err = client.Call("Store.Get", "missing", &value)
+	_assert(errors.Is(err, ErrNotFound), "expect the backend error forwarded, but got %v", err)
+	var sum int
+	err = client.Call("Foo.Sum", Args{Num1: 2, Num2: 2}, &sum)
+	_assert(err == nil && sum == 4, "expect registered services served locally, but got %v", err)
+	err = client.Call("Nope.Nope", 0, &sum)
+	_assert(err != nil && err.Error() == "proxy: unknown method Nope.Nope", "unexpected error %v", err)
+	var pong int
+	err = client.Call("_ping_.Ping", 7, &pong)
+	_assert(err == nil && pong == 7, "expect built-ins served locally, but got %v", err)
+
+	// calls keep working after a handler that didn't read its body
+	err = client.Call("Calc.Mul", Args{Num1: 3, Num2: 4}, &product)
+	_assert(err == nil && product.Value == 12, "failed to call Calc.Mul after an unread body: %v", err)
+}
+
+func TestRawBody_Bytes(t *testing.T) {
+	proxy := NewServer()
+	proxy.HandleRaw(func(ctx context.Context, serviceMethod string, body RawBody) (interface{}, error) {
+		_, err := body.Bytes()
+		return 0, err
+	})
+	client, err := Dial("tcp", startServer(t, proxy).Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call("Store.Get", "key", &reply)
+	_assert(err != nil && err.Error() == ErrNoRawBytes.Error(), "expect no raw bytes with gob, but got %v", err)
+}
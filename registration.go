@@ -0,0 +1,208 @@
+package tinyrpc
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"runtime"
+	"time"
+	"tinyrpc/internal/clock"
+	"tinyrpc/registry"
+	"tinyrpc/rpclog"
+)
+
+// RegistryConfig describes how a Server registers itself.
+type RegistryConfig struct {
+	URLs        []string      // registries to send heartbeats to
+	Interval    time.Duration // between heartbeats, 0 means registry.DefaultHeartbeatInterval
+	ServiceName string        // sent with the heartbeats, clients may filter on it
+	// AdvertiseAddr is the address registered, e.g. "tcp@10.0.0.5:9999".
+	// If empty, it is the listener's address, with the first non-loopback
+	// interface address in place of an unspecified IP such as 0.0.0.0.
+	AdvertiseAddr string
+	// Zone, if set, is appended to the address registered as "?zone=...",
+	// so clients can prefer servers of their own zone.
+	Zone string
+}
+
+// EnableRegistry makes the server register itself with cfg.URLs while it
+// serves a listener, and deregister when it shuts down. Failures to reach
+// a registry are logged and retried, they never stop the server.
+func (server *Server) EnableRegistry(cfg RegistryConfig) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.regCfg = &cfg
+}
+
+// advertise starts the heartbeats for a listener on addr,
+// and returns the address registered, or "" if none.
+func (server *Server) advertise(addr net.Addr) string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	cfg := server.regCfg
+	if cfg == nil || len(cfg.URLs) == 0 {
+		return ""
+	}
+	rpcAddr := cfg.AdvertiseAddr
+	if rpcAddr == "" {
+		rpcAddr = advertiseAddr(addr)
+	}
+	if cfg.Zone != "" {
+		rpcAddr += "?zone=" + url.QueryEscape(cfg.Zone)
+	}
+	if _, ok := server.adverts[rpcAddr]; ok {
+		return "" // already advertised by another listener
+	}
+	if server.adverts == nil {
+		server.adverts = make(map[string]*advert)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &advert{ctx: ctx, cancel: cancel, done: make(chan struct{})}
+	server.adverts[rpcAddr] = a
+	go a.heartbeat(server, *cfg, rpcAddr)
+	return rpcAddr
+}
+
+// advert is the heartbeat goroutine of an advertised address.
+type advert struct {
+	ctx    context.Context // done when the heartbeats must stop
+	cancel context.CancelFunc
+	done   chan struct{} // closed when the goroutine returned
+}
+
+// heartbeat registers rpcAddr with every registry each interval until
+// a.ctx is done. A failed registry is retried after a quarter of the interval.
+// Each heartbeat reports the current load of server.
+func (a *advert) heartbeat(server *Server, cfg RegistryConfig, rpcAddr string) {
+	defer close(a.done)
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = registry.DefaultHeartbeatInterval
+	}
+	var qps qpsMeter
+	c := clock.Or(server.clock)
+	for {
+		wait := interval
+		st := server.Stats()
+		load := &registry.Load{
+			InFlight: st.InFlight,
+			QPS:      qps.sample(c.Now(), st.Requests),
+			Procs:    runtime.GOMAXPROCS(0),
+		}
+		for _, url := range cfg.URLs {
+			if err := registry.Register(a.ctx, url, rpcAddr, cfg.ServiceName, load); err != nil {
+				wait = interval / 4
+			}
+		}
+		t := c.NewTimer(wait)
+		select {
+		case <-a.ctx.Done():
+			t.Stop()
+			return
+		case <-t.C():
+		}
+	}
+}
+
+// qpsMeter computes the request rate over the last minute from samples
+// of the request counter.
+type qpsMeter struct {
+	samples []qpsSample
+}
+
+type qpsSample struct {
+	at       time.Time
+	requests uint64
+}
+
+// sample records the counter and returns the rate since the latest sample
+// at least a minute old, or since the first sample if none is.
+func (m *qpsMeter) sample(now time.Time, requests uint64) float64 {
+	m.samples = append(m.samples, qpsSample{now, requests})
+	for len(m.samples) > 2 && now.Sub(m.samples[1].at) >= time.Minute {
+		m.samples = m.samples[1:]
+	}
+	first := m.samples[0]
+	elapsed := now.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(requests-first.requests) / elapsed
+}
+
+// deregister stops the heartbeats of rpcAddr and removes it from the registries.
+func (server *Server) deregister(ctx context.Context, rpcAddr string) {
+	server.mu.Lock()
+	a, ok := server.adverts[rpcAddr]
+	delete(server.adverts, rpcAddr)
+	cfg := server.regCfg
+	server.mu.Unlock()
+	if !ok {
+		return
+	}
+	a.cancel()
+	<-a.done // a heartbeat in progress must not register rpcAddr again
+	for _, url := range cfg.URLs {
+		if err := registry.Deregister(ctx, url, rpcAddr); err != nil {
+			server.log(rpclog.LevelError, "deregister error", "err", err)
+		}
+	}
+}
+
+func (server *Server) deregisterAll(ctx context.Context) {
+	server.mu.Lock()
+	addrs := make([]string, 0, len(server.adverts))
+	for rpcAddr := range server.adverts {
+		addrs = append(addrs, rpcAddr)
+	}
+	server.mu.Unlock()
+	for _, rpcAddr := range addrs {
+		server.deregister(ctx, rpcAddr)
+	}
+}
+
+// advertiseAddr returns the rpc address clients can dial to reach a
+// listener on addr.
+func advertiseAddr(addr net.Addr) string {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok || !tcp.IP.IsUnspecified() {
+		return addr.Network() + "@" + addr.String()
+	}
+	ip := firstNonLoopbackIP()
+	if ip == nil {
+		ip = net.IPv4(127, 0, 0, 1)
+	}
+	return "tcp@" + (&net.TCPAddr{IP: ip, Port: tcp.Port}).String()
+}
+
+// firstNonLoopbackIP returns the first IPv4 address of the interfaces
+// that are up and not loopback, or the first such IPv6 address.
+func firstNonLoopbackIP() net.IP {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var v6 net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok || ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			if ipnet.IP.To4() != nil {
+				return ipnet.IP
+			}
+			if v6 == nil {
+				v6 = ipnet.IP
+			}
+		}
+	}
+	return v6
+}
@@ -0,0 +1,70 @@
+package tinyrpc
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"tinyrpc/registry"
+)
+
+func TestAdvertiseAddr(t *testing.T) {
+	addr := advertiseAddr(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999})
+	_assert(addr == "tcp@127.0.0.1:9999", "expect the listen address, but got %s", addr)
+	addr = advertiseAddr(&net.TCPAddr{IP: net.IPv4zero, Port: 9999})
+	_assert(strings.HasPrefix(addr, "tcp@") && strings.HasSuffix(addr, ":9999") && !strings.Contains(addr, "0.0.0.0"),
+		"expect a dialable address, but got %s", addr)
+	addr = advertiseAddr(&net.UnixAddr{Name: "/tmp/rpc.sock", Net: "unix"})
+	_assert(addr == "unix@/tmp/rpc.sock", "expect the socket path, but got %s", addr)
+}
+
+func listed(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal("registry error:", err)
+	}
+	_ = resp.Body.Close()
+	return resp.Header.Get(registry.ServersHeader)
+}
+
+func TestServer_EnableRegistry(t *testing.T) {
+	ts := httptest.NewServer(registry.New(time.Minute))
+	defer ts.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	server := NewServer()
+	server.EnableRegistry(RegistryConfig{
+		URLs:        []string{down.URL, ts.URL},
+		Interval:    20 * time.Millisecond,
+		ServiceName: "Foo",
+	})
+	lis := startServer(t, server)
+	want := "tcp@" + lis.Addr().String()
+	deadline := time.Now().Add(time.Second)
+	for listed(t, ts.URL+"?service=Foo") != want && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_assert(listed(t, ts.URL+"?service=Foo") == want, "expect %s registered despite the down registry", want)
+	_assert(listed(t, ts.URL+"?service=Bar") == "", "expect no server named Bar")
+
+	_ = server.Shutdown(context.Background())
+	_assert(listed(t, ts.URL) == "", "expect the server deregistered by Shutdown")
+	time.Sleep(50 * time.Millisecond)
+	_assert(listed(t, ts.URL) == "", "expect no heartbeat after Shutdown")
+}
+
+func TestQPSMeter(t *testing.T) {
+	var m qpsMeter
+	now := time.Now()
+	_assert(m.sample(now, 0) == 0, "expect no rate from one sample")
+	_assert(m.sample(now.Add(10*time.Second), 100) == 10, "expect 10 qps")
+	_assert(m.sample(now.Add(30*time.Second), 500) == 500.0/30, "expect the rate since the first sample")
+	// the sample at 10s is the latest one at least a minute old
+	qps := m.sample(now.Add(80*time.Second), 1500)
+	_assert(qps == 1400.0/70, "expect the rate since the sample at 10s, but got %v", qps)
+}
@@ -0,0 +1,104 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+	"tinyrpc/rpclog"
+)
+
+// snapshotItem is the persisted form of a ServerItem.
+type snapshotItem struct {
+	Addr          string    `json:"addr"`
+	Service       string    `json:"service,omitempty"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+}
+
+// EnablePersistence restores the servers saved at path, if any, and
+// saves them there whenever the set changes, so a restarted registry
+// doesn't answer with an empty list. Restored servers are stale until
+// their next heartbeat, and get one timeout from now to send it.
+func (r *TinyRegistry) EnablePersistence(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var items []snapshotItem
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.path = path
+	now := r.clock.Now()
+	for _, it := range items {
+		if _, ok := r.servers[it.Addr]; !ok {
+			r.servers[it.Addr] = &ServerItem{Addr: it.Addr, Service: it.Service, start: now, stale: true}
+		}
+	}
+	return r.save()
+}
+
+// changed saves the set of servers after a change. r.mu must be held.
+func (r *TinyRegistry) changed() {
+	if err := r.save(); err != nil {
+		rpclog.Error("registry", "save snapshot error", "err", err)
+	}
+}
+
+// save writes the snapshot atomically: to a temporary file, synced,
+// then renamed over path. r.mu must be held.
+func (r *TinyRegistry) save() error {
+	if r.path == "" {
+		return nil
+	}
+	items := make([]snapshotItem, 0, len(r.servers))
+	for _, s := range r.servers {
+		items = append(items, snapshotItem{Addr: s.Addr, Service: s.Service, LastHeartbeat: s.start})
+	}
+	data, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(r.path), ".registry-")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), r.path)
+	}
+	return err
+}
+
+// Run prunes the servers that missed their heartbeats every interval,
+// rather than only when they are listed, and rewrites the snapshot so
+// it carries recent heartbeat times. It returns when ctx is done.
+func (r *TinyRegistry) Run(ctx context.Context, interval time.Duration) {
+	t := r.clock.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C():
+			r.mu.Lock()
+			r.prune(now)
+			if err := r.save(); err != nil {
+				rpclog.Error("registry", "save snapshot error", "err", err)
+			}
+			r.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"tinyrpc/tinyrpctest"
+)
+
+// swapHandler lets a test replace the registry behind a fixed URL.
+type swapHandler struct {
+	mu sync.Mutex
+	h  http.Handler
+}
+
+func (s *swapHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	h := s.h
+	s.mu.Unlock()
+	h.ServeHTTP(w, req)
+}
+
+func TestRegistry_Restart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	r := New(time.Minute)
+	if err := r.EnablePersistence(path); err != nil {
+		t.Fatal("persistence error:", err)
+	}
+	sh := &swapHandler{h: r}
+	ts := httptest.NewServer(sh)
+	defer ts.Close()
+	_ = sendHeartbeat(ts.URL, "tcp@a:1")
+	_ = sendHeartbeat(ts.URL, "tcp@b:1")
+
+	// restart: the new registry starts from the snapshot
+	r2 := New(time.Minute)
+	if err := r2.EnablePersistence(path); err != nil {
+		t.Fatal("persistence error:", err)
+	}
+	sh.mu.Lock()
+	sh.h = r2
+	sh.mu.Unlock()
+	servers := get(t, ts.URL)
+	_assert(servers == "tcp@a:1,tcp@b:1", "expect the saved servers, but got %q", servers)
+	st := r2.Stats()
+	_assert(st.Alive == 2 && st.Stale == 2, "expect 2 stale servers, but got %+v", st)
+
+	_ = sendHeartbeat(ts.URL, "tcp@a:1")
+	st = r2.Stats()
+	_assert(st.Alive == 2 && st.Stale == 1, "expect a fresh after its heartbeat, but got %+v", st)
+}
+
+func TestRegistry_Run(t *testing.T) {
+	r := New(50 * time.Millisecond)
+	clock := tinyrpctest.NewClock()
+	r.SetClock(clock)
+	r.putServer("tcp@a:1", "", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	clock.BlockUntil(1) // the ticker of Run
+	clock.Advance(60 * time.Millisecond)
+
+	// pruned by Run, without anyone listing the servers
+	var n int
+	var pruned int64
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+		r.mu.Lock()
+		n, pruned = len(r.servers), r.pruned
+		r.mu.Unlock()
+		if pruned > 0 {
+			break
+		}
+	}
+	_assert(n == 0 && pruned == 1, "expect a pruned, but got %d servers, %d pruned", n, pruned)
+	cancel()
+	<-done
+}
@@ -0,0 +1,318 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"tinyrpc/internal/clock"
+	"tinyrpc/rpclog"
+)
+
+// TinyRegistry is a simple register center, provide following functions.
+// add a server and receive heartbeat to keep it alive.
+// returns all alive servers and delete dead servers sync simultaneously.
+type TinyRegistry struct {
+	timeout time.Duration
+	clock   clock.Clock
+	mu      sync.Mutex // protect following
+	servers map[string]*ServerItem
+	pruned  int64  // servers removed for missing heartbeats
+	path    string // snapshot file, empty if not persisted
+}
+
+// ServerItem is a server known to the registry.
+type ServerItem struct {
+	Addr    string
+	Service string    // service name sent with the heartbeats, may be empty
+	Load    *Load     // load sent with the last heartbeat, nil if none
+	start   time.Time // time of the last heartbeat
+	stale   bool      // restored from a snapshot, no heartbeat since
+}
+
+const (
+	defaultPath    = "/_tinyrpc_/registry"
+	defaultTimeout = time.Minute * 5
+)
+
+// ServersHeader carries the comma-separated server addresses.
+const ServersHeader = "X-Tinyrpc-Servers"
+
+// ServerHeader carries the address of the server sending a heartbeat.
+const ServerHeader = "X-Tinyrpc-Server"
+
+// ServiceHeader carries the optional service name of the server sending
+// a heartbeat. GET requests with a service query parameter only list
+// the servers with that name.
+const ServiceHeader = "X-Tinyrpc-Service"
+
+// DefaultHeartbeatInterval leaves time for a heartbeat to arrive before
+// the server is removed from a registry with the default timeout.
+const DefaultHeartbeatInterval = defaultTimeout - time.Minute
+
+// New create a registry instance with timeout setting
+func New(timeout time.Duration) *TinyRegistry {
+	return &TinyRegistry{
+		servers: make(map[string]*ServerItem),
+		timeout: timeout,
+		clock:   clock.Real,
+	}
+}
+
+// SetClock sets the clock timing the heartbeats and expiries, such as
+// tinyrpctest.Clock in tests. It must be called before the registry is used.
+func (r *TinyRegistry) SetClock(c clock.Clock) {
+	r.clock = clock.Or(c)
+}
+
+// DefaultTinyRegister is the registry served by HandleHTTP.
+var DefaultTinyRegister = New(defaultTimeout)
+
+// Load is the load a server reports with its heartbeats.
+type Load struct {
+	InFlight int64   `json:"in_flight"` // requests being handled
+	QPS      float64 `json:"qps"`       // requests per second over the last minute
+	Procs    int     `json:"procs"`     // GOMAXPROCS
+}
+
+// Entry describes a server in the JSON body of a GET response.
+type Entry struct {
+	Addr    string `json:"addr"`
+	Service string `json:"service,omitempty"`
+	Load    *Load  `json:"load,omitempty"`
+	// AgeMillis is how long ago the server sent its last heartbeat.
+	AgeMillis int64 `json:"age_ms"`
+}
+
+func (r *TinyRegistry) putServer(addr, service string, load *Load) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.servers[addr]
+	if s == nil || s.Service != service {
+		r.servers[addr] = &ServerItem{Addr: addr, Service: service, Load: load, start: r.clock.Now()}
+		r.changed()
+	} else {
+		s.start = r.clock.Now() // if exists, update start time to keep alive
+		s.stale = false
+		s.Load = load
+	}
+}
+
+func (r *TinyRegistry) removeServer(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.servers[addr]; ok {
+		delete(r.servers, addr)
+		r.changed()
+	}
+}
+
+// aliveServers returns the servers named service, or all if service is
+// empty, sorted by address.
+func (r *TinyRegistry) aliveServers(service string) []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.clock.Now()
+	r.prune(now)
+	alive := make([]Entry, 0, len(r.servers))
+	for addr, s := range r.servers {
+		if service == "" || s.Service == service {
+			alive = append(alive, Entry{
+				Addr:      addr,
+				Service:   s.Service,
+				Load:      s.Load,
+				AgeMillis: now.Sub(s.start).Milliseconds(),
+			})
+		}
+	}
+	sort.Slice(alive, func(i, j int) bool { return alive[i].Addr < alive[j].Addr })
+	return alive
+}
+
+// prune removes the servers whose last heartbeat is older than the timeout.
+// r.mu must be held.
+func (r *TinyRegistry) prune(now time.Time) {
+	if r.timeout == 0 {
+		return
+	}
+	pruned := false
+	for addr, s := range r.servers {
+		if !s.start.Add(r.timeout).After(now) {
+			delete(r.servers, addr)
+			r.pruned++
+			pruned = true
+		}
+	}
+	if pruned {
+		r.changed()
+	}
+}
+
+// Stats are counters of a TinyRegistry.
+type Stats struct {
+	Alive  int   // servers returned to clients
+	Stale  int   // of which restored from a snapshot and not heard from since
+	Pruned int64 // servers removed for missing heartbeats
+}
+
+// Stats returns current counters of r.
+func (r *TinyRegistry) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(r.clock.Now())
+	st := Stats{Alive: len(r.servers), Pruned: r.pruned}
+	for _, s := range r.servers {
+		if s.stale {
+			st.Stale++
+		}
+	}
+	return st
+}
+
+// ServeHTTP runs at defaultPath.
+// GET returns the alive servers in ServersHeader, and with their load
+// as a JSON list of Entry in the body,
+// POST keeps the server in ServerHeader alive, with an optional JSON
+// Load in the body,
+// DELETE removes the server in ServerHeader.
+func (r *TinyRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		alive := r.aliveServers(req.URL.Query().Get("service"))
+		addrs := make([]string, len(alive))
+		for i, e := range alive {
+			addrs[i] = e.Addr
+		}
+		// keep it simple, server is in req.Header
+		w.Header().Set(ServersHeader, strings.Join(addrs, ","))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(alive)
+	case http.MethodPost, http.MethodDelete:
+		// keep it simple, server is in req.Header
+		addr := req.Header.Get(ServerHeader)
+		if addr == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if req.Method == http.MethodDelete {
+			r.removeServer(addr)
+			return
+		}
+		var load *Load
+		if req.ContentLength != 0 {
+			load = new(Load)
+			if err := json.NewDecoder(req.Body).Decode(load); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		r.putServer(addr, req.Header.Get(ServiceHeader), load)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleHTTP registers an HTTP handler for TinyRegistry messages on registryPath
+func (r *TinyRegistry) HandleHTTP(registryPath string) {
+	http.Handle(registryPath, r)
+	rpclog.Info("registry", "serving", "path", registryPath)
+}
+
+// HandleHTTP registers DefaultTinyRegister on the default path.
+func HandleHTTP() {
+	DefaultTinyRegister.HandleHTTP(defaultPath)
+}
+
+// Heartbeat send a heartbeat message every once in a while
+// it's a helper function for a server to register or send heartbeat
+func Heartbeat(registry, addr string, duration time.Duration) {
+	if duration == 0 {
+		// make sure there is enough time to send heart beat
+		// before it's removed from registry
+		duration = DefaultHeartbeatInterval
+	}
+	var err error
+	err = sendHeartbeat(registry, addr)
+	go func() {
+		t := time.NewTicker(duration)
+		for err == nil {
+			<-t.C
+			err = sendHeartbeat(registry, addr)
+		}
+	}()
+}
+
+// HeartbeatAll sends heartbeats to every registry, like Heartbeat,
+// except that it keeps retrying after failures, logging them.
+func HeartbeatAll(registries []string, addr string, duration time.Duration) {
+	if duration == 0 {
+		duration = DefaultHeartbeatInterval
+	}
+	for _, registry := range registries {
+		_ = sendHeartbeat(registry, addr)
+		go func(registry string) {
+			t := time.NewTicker(duration)
+			for range t.C {
+				_ = sendHeartbeat(registry, addr)
+			}
+		}(registry)
+	}
+}
+
+func sendHeartbeat(registry, addr string) error {
+	return Register(context.Background(), registry, addr, "", nil)
+}
+
+// Register sends one heartbeat for the server at addr named service,
+// reporting load if not nil.
+func Register(ctx context.Context, registry, addr, service string, load *Load) error {
+	rpclog.Debug("registry", "send heartbeat", "addr", addr, "registry", registry)
+	var body io.Reader
+	if load != nil {
+		data, err := json.Marshal(load)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, registry, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(ServerHeader, addr)
+	if service != "" {
+		req.Header.Set(ServiceHeader, service)
+	}
+	if err = do(req); err != nil {
+		rpclog.Error("registry", "heartbeat error", "err", err)
+	}
+	return err
+}
+
+// Deregister removes the server at addr from the registry.
+func Deregister(ctx context.Context, registry, addr string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, registry, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(ServerHeader, addr)
+	return do(req)
+}
+
+func do(req *http.Request) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("rpc registry: unexpected status " + resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"tinyrpc/tinyrpctest"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func get(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal("get error:", err)
+	}
+	_ = resp.Body.Close()
+	return resp.Header.Get(ServersHeader)
+}
+
+func TestRegistry(t *testing.T) {
+	r := New(100 * time.Millisecond)
+	clock := tinyrpctest.NewClock()
+	r.SetClock(clock)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	_assert(sendHeartbeat(ts.URL, "tcp@b:1") == nil, "heartbeat error")
+	_assert(sendHeartbeat(ts.URL, "tcp@a:1") == nil, "heartbeat error")
+	servers := get(t, ts.URL)
+	_assert(servers == "tcp@a:1,tcp@b:1", "expect both servers, but got %q", servers)
+
+	clock.Advance(60 * time.Millisecond)
+	_ = sendHeartbeat(ts.URL, "tcp@a:1")
+	clock.Advance(60 * time.Millisecond)
+	servers = get(t, ts.URL)
+	_assert(servers == "tcp@a:1", "expect b to expire, but got %q", servers)
+
+	resp, err := http.Post(ts.URL, "", nil)
+	_assert(err == nil && resp.StatusCode == http.StatusInternalServerError, "expect an error without server header")
+	_ = resp.Body.Close()
+}
+
+func TestHeartbeatAll(t *testing.T) {
+	r1, r2 := New(0), New(0)
+	ts1, ts2 := httptest.NewServer(r1), httptest.NewServer(r2)
+	defer ts2.Close()
+	ts1.Close() // the first registry is down
+	HeartbeatAll([]string{ts1.URL, ts2.URL}, "tcp@a:1", time.Hour)
+	servers := get(t, ts2.URL)
+	_assert(servers == "tcp@a:1", "expect the heartbeat despite the first registry, but got %q", servers)
+}
+
+func TestRegistry_Load(t *testing.T) {
+	ts := httptest.NewServer(New(0))
+	defer ts.Close()
+	load := &Load{InFlight: 2, QPS: 12.5, Procs: 4}
+	_assert(Register(context.Background(), ts.URL, "tcp@a:1", "", load) == nil, "register error")
+	_assert(Register(context.Background(), ts.URL, "tcp@b:1", "", nil) == nil, "register error")
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal("get error:", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var entries []Entry
+	_assert(json.NewDecoder(resp.Body).Decode(&entries) == nil, "expect a JSON body")
+	_assert(len(entries) == 2 && entries[0].Addr == "tcp@a:1", "expect a and b, but got %+v", entries)
+	_assert(entries[0].Load != nil && *entries[0].Load == *load, "expect the load of a, but got %+v", entries[0].Load)
+	_assert(entries[1].Load == nil, "expect no load for b, but got %+v", entries[1].Load)
+}
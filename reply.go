@@ -0,0 +1,110 @@
+package tinyrpc
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"tinyrpc/codec"
+)
+
+// DiscardReply is the reply of calls whose reply isn't wanted: it is read
+// and dropped, whatever its type.
+var DiscardReply interface{} = discardReply{}
+
+type discardReply struct{}
+
+// checkReply returns the error of a call with reply, nil if reply is a
+// non-nil pointer or DiscardReply.
+func checkReply(reply interface{}) error {
+	if reply == DiscardReply {
+		return nil
+	}
+	v := reflect.ValueOf(reply)
+	switch {
+	case !v.IsValid():
+		return fmt.Errorf("%w: nil reply, pass a pointer or DiscardReply", ErrInvalidReply)
+	case v.Kind() != reflect.Ptr:
+		return fmt.Errorf("%w: reply of type %T is not a pointer, pass &reply", ErrInvalidReply, reply)
+	case v.IsNil():
+		return fmt.Errorf("%w: reply is a nil %T", ErrInvalidReply, reply)
+	}
+	return nil
+}
+
+// undecodable returns the error of call, whose reply failed to decode
+// with err.
+func undecodable(call *Call, err error) error {
+	if errors.Is(err, codec.ErrStreamCorrupt) {
+		return fmt.Errorf("%w: reply of %s: %v", ErrCorrupted, call.ServiceMethod, err)
+	}
+	return fmt.Errorf("%w: reply of %s doesn't decode into %T: %v", ErrInvalidReply, call.ServiceMethod, call.Reply, err)
+}
+
+// readReply reads the reply of call, whose header was read last from cc,
+// and completes it, on a decode worker if the reply is framed and the
+// client has some, see WithDecodeWorkers. It returns the error of reading
+// cc.
+//
+// A framed reply that doesn't decode fails its call only, the next frame
+// is read from where the body ends, and so does a reply the codec failed
+// to decode with a recoverable codec.DecodeError. Others, such as gob
+// ones the stream is lost with, leave the stream at an unknown place: the
+// connection is closed and the pending calls fail.
+func (client *Client) readReply(cc codec.Codec, call *Call) error {
+	if call.Reply == DiscardReply {
+		err := codec.DiscardBody(cc)
+		client.replyRead(call, err)
+		return err
+	}
+	var decode func(v interface{}) error
+	var err error
+	if d, ok := cc.(codec.DeferredReader); ok {
+		if decode, err = d.ReadBodyDeferred(); err != nil {
+			client.replyRead(call, fmt.Errorf("reading body %w", err))
+			return err
+		}
+	}
+	if decode == nil {
+		err = cc.ReadBody(call.Reply)
+		var de *codec.DecodeError
+		if errors.As(err, &de) && !de.Fatal {
+			client.replyRead(call, undecodable(call, err))
+			return nil // the next frame is read from where the body ends
+		}
+		if err != nil {
+			err = undecodable(call, err)
+			_ = cc.Close()
+		}
+		client.replyRead(call, err)
+		return err
+	}
+	if client.decoders == nil {
+		client.replyRead(call, decodeReply(call, decode))
+		return nil
+	}
+	client.decoders <- struct{}{}
+	go func() {
+		defer func() { <-client.decoders }()
+		client.replyRead(call, decodeReply(call, decode))
+	}()
+	return nil
+}
+
+// decodeReply decodes the framed reply of call with decode.
+func decodeReply(call *Call, decode func(v interface{}) error) error {
+	if err := decode(call.Reply); err != nil {
+		return undecodable(call, err)
+	}
+	return nil
+}
+
+// replyRead completes call, whose reply was read with err.
+func (client *Client) replyRead(call *Call, err error) {
+	if err != nil {
+		call.Error = err
+	}
+	if t := call.trace; t != nil && t.GotResponseBody != nil {
+		t.GotResponseBody(call.ServiceMethod, call.Seq, err)
+	}
+	call.done()
+}
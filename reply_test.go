@@ -0,0 +1,90 @@
+package tinyrpc
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"tinyrpc/codec"
+)
+
+func TestClient_InvalidReply(t *testing.T) {
+	addr := startServer(t, NewServer()).Addr().String()
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var nilReply *int
+	var reply int
+	for _, tc := range []struct {
+		name  string
+		reply interface{}
+		want  string
+	}{
+		{"nil", nil, "nil reply"},
+		{"non-pointer", reply, "reply of type int is not a pointer"},
+		{"nil pointer", nilReply, "reply is a nil *int"},
+	} {
+		err := client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, tc.reply)
+		_assert(errors.Is(err, ErrInvalidReply) && strings.Contains(err.Error(), tc.want),
+			"%s: expect ErrInvalidReply with %q, but got %v", tc.name, tc.want, err)
+	}
+	_assert(client.Stats().Calls == 0, "expect the invalid calls not sent")
+
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, DiscardReply)
+	_assert(err == nil, "expect DiscardReply to drop the reply, but got %v", err)
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect 3 after the reply discarded, but got %d, %v", reply, err)
+}
+
+// wrongReply has no field in common with the int reply of Foo.Sum.
+type wrongReply struct{ Name string }
+
+// dialPending dials a client with a call to Gate.Wait pending.
+func dialPending(t *testing.T) (*Client, *Call) {
+	server := NewServer()
+	gate := make(Gate)
+	_ = server.Register(gate)
+	t.Cleanup(func() { close(gate) })
+	addr := startServer(t, server).Addr().String()
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1, AllowBodyCodecs: true})
+	_assert(err == nil, "dial error: %v", err)
+	t.Cleanup(func() { _ = client.Close() })
+	return client, client.Go("Gate.Wait", 1, new(int), nil)
+}
+
+func TestClient_WrongReplyGob(t *testing.T) {
+	client, pending := dialPending(t)
+	var reply wrongReply
+	err := client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(errors.Is(err, ErrInvalidReply) && strings.Contains(err.Error(), "Foo.Sum doesn't decode into *tinyrpc.wrongReply"),
+		"expect ErrInvalidReply naming the reply type, but got %v", err)
+
+	// gob read the whole message: the stream is still in step
+	var sum int
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &sum)
+	_assert(err == nil && sum == 3, "expect the connection to survive, but got %d, %v", sum, err)
+	select {
+	case call := <-pending.Done:
+		t.Fatalf("expect the pending call to wait, but got %v", call.Error)
+	default:
+	}
+}
+
+func TestClient_WrongReplyFramed(t *testing.T) {
+	client, pending := dialPending(t)
+	var reply wrongReply
+	err := client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply, WithBodyCodec(codec.JsonType))
+	_assert(errors.Is(err, ErrInvalidReply) && strings.Contains(err.Error(), "cannot unmarshal number into Go value of type tinyrpc.wrongReply"),
+		"expect ErrInvalidReply naming both types, but got %v", err)
+
+	var sum int
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &sum, WithBodyCodec(codec.JsonType))
+	_assert(err == nil && sum == 3, "expect the connection to survive, but got %d, %v", sum, err)
+	err = client.Call("Foo.Sum", Args{Num1: 2, Num2: 2}, &sum)
+	_assert(err == nil && sum == 4, "expect gob calls too, but got %d, %v", sum, err)
+	select {
+	case call := <-pending.Done:
+		t.Fatalf("expect the pending call to wait, but got %v", call.Error)
+	default:
+	}
+}
@@ -0,0 +1,98 @@
+package tinyrpc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// The environment variables passing listeners to a new process, as
+// systemd socket activation does: the listeners are the LISTEN_FDS file
+// descriptors from 3, for the process LISTEN_PID if set.
+const (
+	listenFdsEnv   = "LISTEN_FDS"
+	listenPidEnv   = "LISTEN_PID"
+	listenFdsStart = 3
+)
+
+// ErrNoInheritedListener is returned by InheritedListeners when the
+// process was started without listeners.
+var ErrNoInheritedListener = errors.New("rpc: no inherited listener")
+
+// ListenerFile returns a duplicate of the file descriptor of the listener
+// the server accepts connections on, to pass it to a new process with
+// PassListeners for a graceful restart: the new process serves new
+// connections on the same socket, while this one calls Shutdown to drain
+// its own. Unix socket files are kept when the listener is closed.
+func (server *Server) ListenerFile() (*os.File, error) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.listeners) != 1 {
+		return nil, fmt.Errorf("rpc: ListenerFile needs 1 listener, server has %d", len(server.listeners))
+	}
+	for lis := range server.listeners {
+		return listenerFile(lis)
+	}
+	panic("unreachable")
+}
+
+func listenerFile(lis net.Listener) (*os.File, error) {
+	switch l := lis.(type) {
+	case *unixListener:
+		l.handedOff = true
+		return listenerFile(l.Listener)
+	case *net.UnixListener:
+		l.SetUnlinkOnClose(false)
+		return l.File()
+	case interface{ File() (*os.File, error) }:
+		return l.File()
+	}
+	return nil, fmt.Errorf("rpc: cannot get the file of a %T listener", lis)
+}
+
+// PassListeners makes cmd inherit files, listeners returned by
+// Server.ListenerFile, which the started process gets back with
+// InheritedListeners. It must be called before cmd starts; the files can
+// be closed once it has.
+func PassListeners(cmd *exec.Cmd, files ...*os.File) {
+	cmd.ExtraFiles = append(cmd.ExtraFiles[:0:0], files...)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, listenFdsEnv+"="+strconv.Itoa(len(files)))
+}
+
+// InheritedListeners returns the listeners passed to the process with
+// PassListeners or by systemd socket activation, and unsets the
+// environment variables so that its own children don't inherit them. It
+// returns ErrNoInheritedListener if there are none. Serve them with Accept.
+func InheritedListeners() ([]net.Listener, error) {
+	fds := os.Getenv(listenFdsEnv)
+	pid := os.Getenv(listenPidEnv)
+	_ = os.Unsetenv(listenFdsEnv)
+	_ = os.Unsetenv(listenPidEnv)
+	if fds == "" || (pid != "" && pid != strconv.Itoa(os.Getpid())) {
+		return nil, ErrNoInheritedListener
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("rpc: invalid %s %q", listenFdsEnv, fds)
+	}
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "listener"+strconv.Itoa(fd))
+		lis, err := net.FileListener(f)
+		_ = f.Close() // FileListener made its own copy
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, fmt.Errorf("rpc: inherited fd %d: %w", fd, err)
+		}
+		listeners = append(listeners, lis)
+	}
+	return listeners, nil
+}
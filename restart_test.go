@@ -0,0 +1,106 @@
+package tinyrpc
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Proc reports the process serving the calls.
+type Proc int
+
+func (Proc) Pid(_ int, reply *int) error {
+	*reply = os.Getpid()
+	return nil
+}
+
+// restartChildEnv runs TestServer_GracefulRestart as the new process,
+// serving the inherited listener until its stdin is closed.
+const restartChildEnv = "TINYRPC_RESTART_CHILD"
+
+func TestServer_GracefulRestart(t *testing.T) {
+	if os.Getenv(restartChildEnv) != "" {
+		serveInherited(t)
+		return
+	}
+	server := NewServer()
+	_assert(server.Register(new(Proc)) == nil && server.Register(new(Slow)) == nil, "failed to register")
+	lis, err := server.Listen("tcp", "127.0.0.1:0")
+	_assert(err == nil, "listen error: %v", err)
+	go server.Accept(lis)
+	client, err := Dial("tcp", lis.Addr().String(), &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var pid int
+	_assert(client.Call("Proc.Pid", 0, &pid) == nil && pid == os.Getpid(), "expect the old process to serve, got %d", pid)
+
+	f, err := server.ListenerFile()
+	_assert(err == nil, "listener file error: %v", err)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestServer_GracefulRestart$")
+	cmd.Env = append(os.Environ(), restartChildEnv+"=1")
+	PassListeners(cmd, f)
+	stdin, err := cmd.StdinPipe()
+	_assert(err == nil, "stdin pipe error: %v", err)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	_assert(cmd.Start() == nil, "failed to start the new process")
+	_ = f.Close()
+	defer func() { _ = cmd.Process.Kill() }()
+
+	// a call in flight drains while the new process takes over
+	slow := client.Go("Slow.Sleep", 200, new(int), make(chan *Call, 1))
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_assert(server.Shutdown(ctx) == nil, "failed to drain the old process")
+	_assert((<-slow.Done).Error == nil, "expect the call in flight to complete")
+
+	next, err := Dial("tcp", lis.Addr().String(), &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial after restart error: %v", err)
+	defer func() { _ = next.Close() }()
+	err = next.Call("Proc.Pid", 0, &pid)
+	_assert(err == nil && pid == cmd.Process.Pid, "expect the new process %d to serve, got %d, %v", cmd.Process.Pid, pid, err)
+
+	_ = stdin.Close()
+	_assert(cmd.Wait() == nil, "expect the new process to exit cleanly")
+}
+
+func serveInherited(t *testing.T) {
+	listeners, err := InheritedListeners()
+	_assert(err == nil && len(listeners) == 1, "expect 1 inherited listener, got %d, %v", len(listeners), err)
+	_assert(os.Getenv(listenFdsEnv) == "", "expect LISTEN_FDS unset")
+	server := NewServer()
+	_assert(server.Register(new(Proc)) == nil, "failed to register Proc")
+	go server.Accept(listeners[0])
+	_, _ = io.Copy(io.Discard, os.Stdin)
+	_assert(server.Shutdown(context.Background()) == nil, "failed to shut down")
+}
+
+func TestInheritedListeners_None(t *testing.T) {
+	if os.Getenv(listenFdsEnv) != "" {
+		t.Skip("started with listeners")
+	}
+	_, err := InheritedListeners()
+	_assert(err == ErrNoInheritedListener, "expect ErrNoInheritedListener, but got %v", err)
+}
+
+func TestServer_ListenerFileKeepsUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rpc.sock")
+	server := NewServer()
+	server.SetSocketOptions(&SocketOptions{UnixMode: 0600})
+	lis, err := server.Listen("unix", path)
+	_assert(err == nil, "listen error: %v", err)
+	go server.Accept(lis)
+	f, err := server.ListenerFile()
+	for err != nil { // until Accept serves lis
+		time.Sleep(time.Millisecond)
+		f, err = server.ListenerFile()
+	}
+	defer func() { _ = f.Close() }()
+	_assert(server.Shutdown(context.Background()) == nil, "failed to shut down")
+	_, err = os.Stat(path)
+	_assert(err == nil, "expect the socket file kept for the new process, but got %v", err)
+}
@@ -0,0 +1,67 @@
+package tinyrpc
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+	"tinyrpc/codec"
+)
+
+// RetryAfterTrailer is the trailer of the calls the server shed, failing
+// with ErrRateLimited or ErrResourceExhausted: the milliseconds to wait
+// before trying again, as the limiter over which they are knows it. The
+// clients read it back with RetryAfter.
+const RetryAfterTrailer = "retry-after-ms"
+
+// retryAfterError is the error of a call shed by the server, with the
+// wait it asks of the client.
+type retryAfterError struct {
+	error
+	after time.Duration
+}
+
+func (e *retryAfterError) Unwrap() error { return e.error }
+
+// withRetryAfter returns err asking the client to wait d before trying
+// again.
+func withRetryAfter(err error, d time.Duration) error {
+	return &retryAfterError{err, d}
+}
+
+// setRetryAfter adds the wait err asks for, if any and if the trailer of
+// h has none, to a copy of the trailer.
+func setRetryAfter(h *codec.Header, err error) {
+	var re *retryAfterError
+	if !errors.As(err, &re) || re.after <= 0 || h.Metadata[RetryAfterTrailer] != "" {
+		return
+	}
+	md := make(map[string]string, len(h.Metadata)+1)
+	for k, v := range h.Metadata {
+		md[k] = v
+	}
+	ms := int64(math.Ceil(float64(re.after) / float64(time.Millisecond)))
+	md[RetryAfterTrailer] = strconv.FormatInt(ms, 10)
+	h.Metadata = md
+}
+
+// parseRetryAfter returns the wait of the trailer md, 0 if none.
+func parseRetryAfter(md map[string]string) time.Duration {
+	ms, err := strconv.ParseInt(md[RetryAfterTrailer], 10, 64)
+	if err != nil || ms <= 0 {
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// RetryAfter returns how long the server asked to wait before calling
+// again, with the error of a call it shed, see RetryAfterTrailer. It is 0
+// if err isn't such an error. The retry policies of the clients wait at
+// least this long before a retry, see RetryPolicy and xclient.
+func RetryAfter(err error) time.Duration {
+	var se *serverError
+	if errors.As(err, &se) {
+		return se.retryAfter
+	}
+	return 0
+}
@@ -0,0 +1,60 @@
+package tinyrpc
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tinyrpc/codec"
+	"tinyrpc/tinyrpctest"
+)
+
+func TestClient_RetryAfter(t *testing.T) {
+	clock := tinyrpctest.NewClock()
+	var headers int64
+	server := NewServer(WithServerTrace(&ServerTrace{GotRequestHeader: func(serviceMethod string, seq uint64) {
+		if serviceMethod == "Foo.Sum" {
+			atomic.AddInt64(&headers, 1)
+		}
+	}}))
+	server.SetClock(clock)
+	server.SetIPLimits(IPLimits{QPS: 10, Burst: 1})
+	addr := startServer(t, server).Addr().String()
+	sum := func(client *Client, opts ...CallOption) error {
+		var reply int
+		return client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply, opts...)
+	}
+
+	// the hint is sent whatever the body codec
+	for _, opts := range [][]CallOption{nil, {WithBodyCodec(codec.JsonType)}} {
+		client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1, AllowBodyCodecs: true})
+		_assert(err == nil, "dial error: %v", err)
+		clock.Advance(time.Second) // a full bucket
+		_assert(sum(client, opts...) == nil, "expect a call within the rate")
+		var trailer Metadata
+		err = sum(client, append(opts, WithTrailer(&trailer))...)
+		_assert(errors.Is(err, ErrRateLimited) && trailer[RetryAfterTrailer] == "100",
+			"expect a hint of 100ms, but got %v, %v", err, trailer)
+		_assert(RetryAfter(err) == 100*time.Millisecond, "expect the hint with the error, but got %v", RetryAfter(err))
+		_ = client.Close()
+	}
+	_assert(RetryAfter(ErrRateLimited) == 0, "expect no hint with a local error")
+
+	// the retry policy waits for the hint, even with a shorter backoff
+	clock.Advance(time.Second)
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1, Clock: clock}, WithRetryPolicy(RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}))
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(sum(client) == nil, "expect a call within the rate")
+	sent := atomic.LoadInt64(&headers)
+	waiters := clock.Waiters()
+	done := make(chan error, 1)
+	go func() { done <- sum(client) }()
+	clock.BlockUntil(waiters + 1) // the client waits to retry
+	clock.Advance(99 * time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	_assert(atomic.LoadInt64(&headers) == sent+1, "expect no retry before the hint elapses")
+	clock.Advance(time.Millisecond)
+	err = <-done
+	_assert(err == nil && atomic.LoadInt64(&headers) == sent+2, "expect the call retried after the hint, but got %v", err)
+}
@@ -0,0 +1,273 @@
+// Package rpclog is the logging of tinyrpc. Messages have a level, the
+// subsystem they come from ("server", "client", "registry", "codec"...)
+// and key-value pairs. The default logger prints them with the standard
+// log package; New returns one writing key=value or JSON lines whose
+// levels can be changed at runtime, per subsystem.
+package rpclog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the severity of a message.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l >= LevelDebug && l <= LevelError {
+		return levelNames[l]
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// ParseLevel returns the level called name, such as "debug".
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("rpclog: unknown level %q", name)
+}
+
+// Logger receives the messages of tinyrpc. kv alternates keys and values.
+type Logger interface {
+	Log(level Level, subsystem, msg string, kv ...interface{})
+}
+
+// Format is the output format of a StructuredLogger.
+type Format int
+
+const (
+	TextFormat Format = iota // level=info subsystem=server msg="..." key=value
+	JSONFormat               // {"time":"...","level":"info","subsystem":"server","msg":"...","key":"value"}
+)
+
+// StructuredLogger writes one line per message at or above the level of
+// its subsystem. It is safe for concurrent use.
+type StructuredLogger struct {
+	mu     sync.Mutex // protect following, and serialize writes
+	w      io.Writer
+	format Format
+	level  Level            // of the subsystems without their own
+	levels map[string]Level // by subsystem
+	sample uint64           // log 1 in sample debug messages, all if 0 or 1
+	counts map[string]uint64
+	now    func() time.Time
+}
+
+// New returns a StructuredLogger writing to w at LevelInfo.
+func New(w io.Writer, format Format) *StructuredLogger {
+	return &StructuredLogger{w: w, format: format, level: LevelInfo, now: time.Now}
+}
+
+// SetLevel sets the level of subsystem, or of all subsystems without
+// their own level if subsystem is "".
+func (l *StructuredLogger) SetLevel(subsystem string, level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if subsystem == "" {
+		l.level = level
+		return
+	}
+	if l.levels == nil {
+		l.levels = make(map[string]Level)
+	}
+	l.levels[subsystem] = level
+}
+
+// SetSampling logs only 1 in n debug messages, counted per subsystem
+// and message, so that high-volume lines stay readable. 0 logs all.
+func (l *StructuredLogger) SetSampling(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sample = uint64(n)
+}
+
+// Enabled reports whether messages of subsystem at level are written.
+func (l *StructuredLogger) Enabled(level Level, subsystem string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return level >= l.levelOf(subsystem)
+}
+
+func (l *StructuredLogger) levelOf(subsystem string) Level {
+	if level, ok := l.levels[subsystem]; ok {
+		return level
+	}
+	return l.level
+}
+
+// Log writes the message if its level is enabled.
+func (l *StructuredLogger) Log(level Level, subsystem, msg string, kv ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level < l.levelOf(subsystem) {
+		return
+	}
+	if level == LevelDebug && l.sample > 1 {
+		if l.counts == nil {
+			l.counts = make(map[string]uint64)
+		}
+		key := subsystem + "\x00" + msg
+		n := l.counts[key]
+		l.counts[key] = n + 1
+		if n%l.sample != 0 {
+			return
+		}
+	}
+	var line []byte
+	if l.format == JSONFormat {
+		line = l.appendJSON(level, subsystem, msg, kv)
+	} else {
+		line = appendText(level, subsystem, msg, kv)
+	}
+	_, _ = l.w.Write(append(line, '\n'))
+}
+
+func appendText(level Level, subsystem, msg string, kv []interface{}) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "level=%s subsystem=%s msg=%s", level, subsystem, quote(msg))
+	for i := 0; i < len(kv); i += 2 {
+		fmt.Fprintf(&b, " %s=%s", key(kv, i), quote(value(kv, i)))
+	}
+	return b.Bytes()
+}
+
+// quote leaves simple values as is, and quotes those with spaces or quotes.
+func quote(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func (l *StructuredLogger) appendJSON(level Level, subsystem, msg string, kv []interface{}) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"time":`)
+	writeJSON(&b, l.now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeJSON(&b, level.String())
+	b.WriteString(`,"subsystem":`)
+	writeJSON(&b, subsystem)
+	b.WriteString(`,"msg":`)
+	writeJSON(&b, msg)
+	for i := 0; i < len(kv); i += 2 {
+		b.WriteByte(',')
+		writeJSON(&b, key(kv, i))
+		b.WriteByte(':')
+		v := value(kv, i)
+		if err, ok := v.(error); ok {
+			v = err.Error() // most errors marshal to {}
+		}
+		writeJSON(&b, v)
+	}
+	b.WriteByte('}')
+	return b.Bytes()
+}
+
+func writeJSON(b *bytes.Buffer, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
+}
+
+func key(kv []interface{}, i int) string {
+	if s, ok := kv[i].(string); ok {
+		return s
+	}
+	return fmt.Sprint(kv[i])
+}
+
+func value(kv []interface{}, i int) interface{} {
+	if i+1 < len(kv) {
+		return kv[i+1]
+	}
+	return "(missing)"
+}
+
+// stdWriter writes lines with the standard log package, so they keep
+// its prefix, flags and output.
+type stdWriter struct{}
+
+func (stdWriter) Write(p []byte) (int, error) {
+	return len(p), log.Output(4, string(p))
+}
+
+var std atomic.Value // holds a loggerBox
+
+type loggerBox struct{ Logger }
+
+func init() {
+	std.Store(loggerBox{New(stdWriter{}, TextFormat)})
+}
+
+// Default returns the logger used by tinyrpc.
+func Default() Logger {
+	return std.Load().(loggerBox).Logger
+}
+
+// SetDefault makes tinyrpc log to l.
+func SetDefault(l Logger) {
+	std.Store(loggerBox{l})
+}
+
+// Enabled reports whether the default logger writes messages of subsystem
+// at level, so that callers can skip building expensive ones. Loggers
+// without an Enabled method receive them all.
+func Enabled(level Level, subsystem string) bool {
+	if l, ok := Default().(interface{ Enabled(Level, string) bool }); ok {
+		return l.Enabled(level, subsystem)
+	}
+	return true
+}
+
+// SetLevel sets the level of subsystem, or of all subsystems if it is "",
+// on the default logger. It reports false if the logger has no levels.
+func SetLevel(subsystem string, level Level) bool {
+	l, ok := Default().(interface{ SetLevel(string, Level) })
+	if ok {
+		l.SetLevel(subsystem, level)
+	}
+	return ok
+}
+
+// Debug logs msg at LevelDebug with the default logger.
+func Debug(subsystem, msg string, kv ...interface{}) {
+	Default().Log(LevelDebug, subsystem, msg, kv...)
+}
+
+// Info logs msg at LevelInfo with the default logger.
+func Info(subsystem, msg string, kv ...interface{}) {
+	Default().Log(LevelInfo, subsystem, msg, kv...)
+}
+
+// Warn logs msg at LevelWarn with the default logger.
+func Warn(subsystem, msg string, kv ...interface{}) {
+	Default().Log(LevelWarn, subsystem, msg, kv...)
+}
+
+// Error logs msg at LevelError with the default logger.
+func Error(subsystem, msg string, kv ...interface{}) {
+	Default().Log(LevelError, subsystem, msg, kv...)
+}
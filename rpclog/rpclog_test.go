@@ -0,0 +1,86 @@
+package rpclog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStructuredLogger_Text(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, TextFormat)
+	l.Log(LevelInfo, "server", "read header error", "err", errors.New("unexpected EOF"), "seq", 3)
+	l.Log(LevelDebug, "server", "hidden")
+	want := `level=info subsystem=server msg="read header error" err="unexpected EOF" seq=3` + "\n"
+	if buf.String() != want {
+		t.Fatalf("expect %q, but got %q", want, buf.String())
+	}
+}
+
+func TestStructuredLogger_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, JSONFormat)
+	l.now = func() time.Time { return time.Unix(1700000000, 0) }
+	l.Log(LevelError, "codec", "encode error", "err", errors.New("bad"), "seq", 7, "odd")
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expect a JSON line, but got %q: %v", buf.String(), err)
+	}
+	want := map[string]interface{}{
+		"time": "2023-11-14T22:13:20Z", "level": "error", "subsystem": "codec",
+		"msg": "encode error", "err": "bad", "seq": 7.0, "odd": "(missing)",
+	}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("expect %s=%v, but got %v", k, v, line[k])
+		}
+	}
+}
+
+func TestStructuredLogger_Levels(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, TextFormat)
+	l.SetLevel("codec", LevelDebug)
+	l.SetLevel("", LevelWarn)
+	l.Log(LevelDebug, "codec", "codec debug")
+	l.Log(LevelInfo, "server", "server info")
+	l.Log(LevelWarn, "server", "server warn")
+	out := buf.String()
+	if !strings.Contains(out, "codec debug") || strings.Contains(out, "server info") || !strings.Contains(out, "server warn") {
+		t.Fatalf("unexpected filtering: %q", out)
+	}
+	if !l.Enabled(LevelDebug, "codec") || l.Enabled(LevelInfo, "client") {
+		t.Fatal("unexpected Enabled")
+	}
+}
+
+func TestStructuredLogger_Sampling(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, TextFormat)
+	l.SetLevel("", LevelDebug)
+	l.SetSampling(10)
+	for i := 0; i < 25; i++ {
+		l.Log(LevelDebug, "codec", "write header")
+		l.Log(LevelInfo, "codec", "not sampled")
+	}
+	if n := strings.Count(buf.String(), "write header"); n != 3 {
+		t.Errorf("expect 1 in 10 debug lines, but got %d of 25", n)
+	}
+	if n := strings.Count(buf.String(), "not sampled"); n != 25 {
+		t.Errorf("expect every info line, but got %d of 25", n)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, level := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		if got, err := ParseLevel(strings.ToUpper(level.String())); err != nil || got != level {
+			t.Errorf("expect %v, but got %v, %v", level, got, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("expect an error for an unknown level")
+	}
+}
@@ -0,0 +1,230 @@
+package tinyrpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"tinyrpc/rpclog"
+)
+
+// Schema describes the shape of a type as its codec sees it: one line
+// per leaf field, with its path and kind, in the order of the fields.
+// Struct and field type names are left out, so that a client and a server
+// defining their own copies of the types agree.
+type Schema struct {
+	Hash   string   // of Fields
+	Fields []string // e.g. "Num1 int", "Items[].Name string"
+}
+
+// MethodSchema is the reply of "_schema_.Method".
+type MethodSchema struct {
+	Args  Schema
+	Reply Schema
+}
+
+var (
+	typeOfGobEncoder     = reflect.TypeOf((*gob.GobEncoder)(nil)).Elem()
+	typeOfBinaryMarshal  = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	errSchemaUnsupported = errors.New("the server doesn't serve schemas")
+)
+
+// SchemaOf returns the schema of t, or of what t points to.
+func SchemaOf(t reflect.Type) Schema {
+	var fields []string
+	schemaFields("", t, make(map[reflect.Type]bool), &fields)
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\n")))
+	return Schema{Hash: hex.EncodeToString(sum[:8]), Fields: fields}
+}
+
+// schemaFields appends the leaf fields of t to fields, prefixed with
+// path. within holds the structs being described, to cut cycles.
+func schemaFields(path string, t reflect.Type, within map[reflect.Type]bool, fields *[]string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	leaf := func(kind string) {
+		if path == "" {
+			*fields = append(*fields, kind)
+			return
+		}
+		*fields = append(*fields, path+" "+kind)
+	}
+	if t.Implements(typeOfGobEncoder) || reflect.PtrTo(t).Implements(typeOfGobEncoder) ||
+		t.Implements(typeOfBinaryMarshal) || reflect.PtrTo(t).Implements(typeOfBinaryMarshal) {
+		leaf(t.String()) // encoded opaquely, e.g. time.Time
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if within[t] {
+			leaf("cycle")
+			return
+		}
+		within[t] = true
+		defer delete(within, t)
+		n := len(*fields)
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.IsExported() {
+				name := f.Name
+				if path != "" {
+					name = path + "." + name
+				}
+				schemaFields(name, f.Type, within, fields)
+			}
+		}
+		if len(*fields) == n {
+			leaf("struct{}")
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			leaf("[]byte")
+			return
+		}
+		schemaFields(path+"[]", t.Elem(), within, fields)
+	case reflect.Map:
+		schemaFields(path+"[key]", t.Key(), within, fields)
+		schemaFields(path+"[]", t.Elem(), within, fields)
+	default:
+		leaf(t.Kind().String())
+	}
+}
+
+// schemaDiff describes how the schema local of the client differs from
+// remote, the one of the server.
+func schemaDiff(local, remote Schema) string {
+	onServer := make(map[string]bool, len(remote.Fields))
+	for _, f := range remote.Fields {
+		onServer[f] = true
+	}
+	var clientOnly, serverOnly []string
+	for _, f := range local.Fields {
+		if !onServer[f] {
+			clientOnly = append(clientOnly, f)
+		}
+		delete(onServer, f)
+	}
+	for f := range onServer {
+		serverOnly = append(serverOnly, f)
+	}
+	sort.Strings(serverOnly)
+	var parts []string
+	if len(clientOnly) > 0 {
+		parts = append(parts, "only on the client: "+strings.Join(clientOnly, ", "))
+	}
+	if len(serverOnly) > 0 {
+		parts = append(parts, "only on the server: "+strings.Join(serverOnly, ", "))
+	}
+	if len(parts) == 0 {
+		return "fields in another order"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// schemaService answers "_schema_.Method" with the MethodSchema of a
+// registered method, e.g. "Foo.Sum".
+type schemaService struct{ server *Server }
+
+func (s *schemaService) Method(serviceMethod string, reply *MethodSchema) error {
+	if strings.HasPrefix(serviceMethod, BuiltinPrefix) {
+		return fmt.Errorf("%w: %s", ErrMethodNotFound, serviceMethod)
+	}
+	_, mtype, err := s.server.findService(serviceMethod)
+	if err != nil {
+		return err
+	}
+	*reply = MethodSchema{Args: SchemaOf(mtype.ArgType), Reply: SchemaOf(mtype.ReplyType)}
+	return nil
+}
+
+// SchemaCheck is how a client checks that its types agree with those of
+// the server, see WithSchemaCheck.
+type SchemaCheck int
+
+const (
+	SchemaCheckOff    SchemaCheck = iota // no check, the default
+	SchemaCheckWarn                      // a mismatch is logged, the calls go on
+	SchemaCheckStrict                    // a mismatch fails the calls with ErrSchemaMismatch
+)
+
+// WithSchemaCheck makes Call and CallContext compare the schemas of the
+// args and the reply of the first call to each method, with each pair
+// of types, to the MethodSchema the server has before sending it, so that
+// a field renamed on one side is caught instead of decoded as a zero
+// value. The result is kept for the later calls. Servers without "_schema_" are
+// not checked. Go calls are not checked.
+func WithSchemaCheck(mode SchemaCheck) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) { c.schemaCheck = mode })
+}
+
+// schemaKey is what a client checks the schemas of.
+type schemaKey struct {
+	serviceMethod string
+	args, reply   reflect.Type
+}
+
+// checkSchema checks the schemas of the call to serviceMethod once, see
+// WithSchemaCheck, and returns the error to fail it with.
+func (client *Client) checkSchema(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	mode := client.config.schemaCheck
+	if mode == SchemaCheckOff || strings.HasPrefix(serviceMethod, BuiltinPrefix) {
+		return nil
+	}
+	key := schemaKey{serviceMethod, reflect.TypeOf(args), reflect.TypeOf(reply)}
+	client.mu.Lock()
+	err, checked := client.schemas[key]
+	client.mu.Unlock()
+	if checked {
+		return err
+	}
+	err = client.compareSchema(ctx, serviceMethod, args, reply)
+	if errors.Is(err, errSchemaUnsupported) {
+		err = nil
+	} else if err != nil && !errors.Is(err, ErrSchemaMismatch) {
+		return nil // not checked, e.g. the connection is closing: the call reports it
+	}
+	if err != nil && mode == SchemaCheckWarn {
+		client.config.log(rpclog.LevelWarn, "schema mismatch", "method", serviceMethod, "err", err)
+		err = nil
+	}
+	client.mu.Lock()
+	if client.schemas == nil {
+		client.schemas = make(map[schemaKey]error)
+	}
+	client.schemas[key] = err
+	client.mu.Unlock()
+	return err
+}
+
+func (client *Client) compareSchema(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	var remote MethodSchema
+	if err := client.callContext(ctx, "_schema_.Method", serviceMethod, &remote); err != nil {
+		if errors.Is(err, ErrMethodNotFound) && strings.Contains(err.Error(), "_schema_") {
+			return errSchemaUnsupported // no "_schema_" service
+		}
+		return err
+	}
+	var diffs []string
+	for _, side := range []struct {
+		name   string
+		value  interface{}
+		remote Schema
+	}{{"args", args, remote.Args}, {"reply", reply, remote.Reply}} {
+		if side.value == nil {
+			continue
+		}
+		if local := SchemaOf(reflect.TypeOf(side.value)); local.Hash != side.remote.Hash {
+			diffs = append(diffs, side.name+" ("+schemaDiff(local, side.remote)+")")
+		}
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("%w: %s: %s", ErrSchemaMismatch, serviceMethod, strings.Join(diffs, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package tinyrpc
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// RenamedArgs is Args of an older client, with Num2 renamed.
+type RenamedArgs struct{ Num1, Count int }
+
+func TestSchemaOf(t *testing.T) {
+	type node struct {
+		Name     string
+		Children []*node
+		Tags     map[string]int
+		Data     []byte
+		hidden   int
+	}
+	s := SchemaOf(reflect.TypeOf(&node{}))
+	want := []string{"Name string", "Children[] cycle", "Tags[key] string", "Tags[] int", "Data []byte"}
+	_assert(reflect.DeepEqual(s.Fields, want), "fields %q, expect %q", s.Fields, want)
+	_assert(SchemaOf(reflect.TypeOf(Args{})).Hash == SchemaOf(reflect.TypeOf(&struct{ Num1, Num2 int }{})).Hash,
+		"expect the hash independent of type names and pointers")
+	_assert(SchemaOf(reflect.TypeOf(Args{})).Hash != SchemaOf(reflect.TypeOf(RenamedArgs{})).Hash,
+		"expect a renamed field to change the hash")
+}
+
+func TestClient_SchemaCheck(t *testing.T) {
+	addr := startServer(t, NewServer()).Addr().String()
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1}, WithSchemaCheck(SchemaCheckStrict))
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect matching schemas to pass: %v", err)
+	for i := 0; i < 2; i++ { // the second time from the cache
+		err = client.Call("Foo.Sum", RenamedArgs{Num1: 1, Count: 2}, &reply)
+		_assert(errors.Is(err, ErrSchemaMismatch), "expect a mismatch, got %v", err)
+	}
+	msg := err.Error()
+	_assert(strings.Contains(msg, "Foo.Sum") && strings.Contains(msg, "only on the client: Count int") &&
+		strings.Contains(msg, "only on the server: Num2 int"), "expect the differing fields listed: %s", msg)
+}
+
+func TestClient_SchemaCheckWarn(t *testing.T) {
+	addr := startServer(t, NewServer()).Addr().String()
+	logger := &recordingLogger{}
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1}, WithSchemaCheck(SchemaCheckWarn), WithLogger(logger))
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call("Foo.Sum", RenamedArgs{Num1: 1, Count: 2}, &reply)
+	_assert(err == nil && reply == 1, "expect the call to go on, Count decoded as nothing: %v, %d", err, reply)
+	_assert(logger.has("schema mismatch"), "expect the mismatch logged: %q", logger.msgs)
+}
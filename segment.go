@@ -0,0 +1,218 @@
+package tinyrpc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Channels of a connection with Option.BulkChannel.
+const (
+	controlChannel = 0 // the requests, and the responses but those of bulk services
+	bulkChannel    = 1 // the responses of bulk services and the pushes
+)
+
+// DefaultSegmentSize is the largest segment a frame is split into on a
+// connection with Option.BulkChannel, the longest a control frame may
+// wait for the bulk frame being written.
+const DefaultSegmentSize = 64 << 10
+
+// maxSegmentBuffer is how many bytes of a channel are buffered before the
+// connection stops being read until they are.
+const maxSegmentBuffer = 1 << 20
+
+// segmentConn carries the two channels of a connection with
+// Option.BulkChannel, each a stream of its own for a codec. Every write
+// is sent in segments of at most DefaultSegmentSize: their channel, 1
+// byte, their length, 4 bytes, then their bytes. The segments of control
+// writes are sent before those of the bulk writes waiting, so that a huge
+// bulk frame delays the other frames by a segment at most. Codecs write
+// a frame at a time, so the frames of a channel keep their order.
+type segmentConn struct {
+	conn     io.ReadWriteCloser
+	r        *bufio.Reader
+	channels [2]*segmentChannel
+
+	mu      sync.Mutex // protect following
+	cond    *sync.Cond
+	writing bool // a segment is being written
+	control int  // control writers waiting for their turn
+	closed  bool
+}
+
+func newSegmentConn(conn io.ReadWriteCloser) *segmentConn {
+	s := &segmentConn{conn: conn, r: bufio.NewReader(conn)}
+	s.cond = sync.NewCond(&s.mu)
+	for ch := range s.channels {
+		c := &segmentChannel{conn: s, ch: byte(ch)}
+		c.cond = sync.NewCond(&c.mu)
+		s.channels[ch] = c
+	}
+	go s.demux()
+	return s
+}
+
+// channel returns the stream of channel ch.
+func (s *segmentConn) channel(ch int) io.ReadWriteCloser {
+	return s.channels[ch]
+}
+
+// write sends p on channel ch, in segments.
+func (s *segmentConn) write(ch byte, p []byte, buf *[]byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > DefaultSegmentSize {
+			n = DefaultSegmentSize
+		}
+		if cap(*buf) < 5+n {
+			*buf = make([]byte, 5+DefaultSegmentSize)
+		}
+		seg := (*buf)[:5+n]
+		seg[0] = ch
+		binary.BigEndian.PutUint32(seg[1:], uint32(n))
+		copy(seg[5:], p[:n])
+		if err := s.writeSegment(ch, seg); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// writeSegment writes seg in its turn: control segments first.
+func (s *segmentConn) writeSegment(ch byte, seg []byte) error {
+	s.mu.Lock()
+	if ch == controlChannel {
+		s.control++
+		for s.writing {
+			s.cond.Wait()
+		}
+		s.control--
+	} else {
+		for s.writing || s.control > 0 {
+			s.cond.Wait()
+		}
+	}
+	s.writing = true
+	s.mu.Unlock()
+	_, err := s.conn.Write(seg)
+	s.mu.Lock()
+	s.writing = false
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	return err
+}
+
+// demux reads the segments of the connection into their channel, until
+// it fails.
+func (s *segmentConn) demux() {
+	var head [5]byte
+	var err error
+	for {
+		if _, err = io.ReadFull(s.r, head[:]); err != nil {
+			break
+		}
+		ch, n := head[0], binary.BigEndian.Uint32(head[1:])
+		if int(ch) >= len(s.channels) || n > DefaultSegmentSize {
+			err = fmt.Errorf("%w: segment of %d bytes on channel %d", ErrCorrupted, n, ch)
+			break
+		}
+		seg := make([]byte, n)
+		if _, err = io.ReadFull(s.r, seg); err != nil {
+			break
+		}
+		if !s.channels[ch].add(seg) {
+			err = io.ErrClosedPipe
+			break
+		}
+	}
+	for _, c := range s.channels {
+		c.fail(err)
+	}
+}
+
+func (s *segmentConn) Close() error {
+	s.mu.Lock()
+	closed := s.closed
+	s.closed = true
+	s.mu.Unlock()
+	for _, c := range s.channels {
+		c.fail(io.ErrClosedPipe)
+	}
+	if closed {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// segmentChannel is one channel of a segmentConn. The bytes read from
+// the connection are buffered until the codec of the channel reads them.
+type segmentChannel struct {
+	conn *segmentConn
+	ch   byte
+	wbuf []byte // of the segment being written, the codec writes one frame at a time
+
+	mu       sync.Mutex // protect following
+	cond     *sync.Cond
+	segs     [][]byte // not read yet, the first one partly
+	buffered int
+	err      error // once segs are read
+}
+
+func (c *segmentChannel) Write(p []byte) (int, error) {
+	return c.conn.write(c.ch, p, &c.wbuf)
+}
+
+func (c *segmentChannel) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.segs) == 0 && c.err == nil {
+		c.cond.Wait()
+	}
+	if len(c.segs) == 0 {
+		return 0, c.err
+	}
+	n := copy(p, c.segs[0])
+	if c.segs[0] = c.segs[0][n:]; len(c.segs[0]) == 0 {
+		c.segs[0] = nil
+		c.segs = c.segs[1:]
+	}
+	c.buffered -= n
+	c.cond.Broadcast()
+	return n, nil
+}
+
+// add buffers seg, once the channel has room for it. It reports false
+// if the channel is closed.
+func (c *segmentChannel) add(seg []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.buffered >= maxSegmentBuffer && c.err == nil {
+		c.cond.Wait()
+	}
+	if c.err != nil {
+		return false
+	}
+	c.segs = append(c.segs, seg)
+	c.buffered += len(seg)
+	c.cond.Broadcast()
+	return true
+}
+
+// fail makes Read return err once the buffered bytes are read.
+func (c *segmentChannel) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.cond.Broadcast()
+}
+
+func (c *segmentChannel) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,85 @@
+package tinyrpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Bulk answers huge replies.
+type Bulk int
+
+func (b *Bulk) Get(n int, reply *[]byte) error {
+	*reply = make([]byte, n)
+	return nil
+}
+
+func TestSegmentConn(t *testing.T) {
+	a, b := net.Pipe()
+	sa, sb := newSegmentConn(a), newSegmentConn(b)
+	defer func() { _ = sa.Close() }()
+	big := bytes.Repeat([]byte{1}, 3*DefaultSegmentSize+5)
+	go func() {
+		_, _ = sa.channel(bulkChannel).Write(big)
+		_, _ = sa.channel(controlChannel).Write([]byte("small"))
+	}()
+	got := make([]byte, 5)
+	_, err := io.ReadFull(sb.channel(controlChannel), got)
+	_assert(err == nil && string(got) == "small", "control channel: %v, %q", err, got)
+	all := make([]byte, len(big))
+	_, err = io.ReadFull(sb.channel(bulkChannel), all)
+	_assert(err == nil && bytes.Equal(all, big), "bulk channel: %v", err)
+
+	_ = sb.Close()
+	_, err = sb.channel(controlChannel).Read(got)
+	_assert(err != nil, "expect reads to fail once closed")
+}
+
+// TestServer_BulkChannel checks that a 100MB response doesn't delay a
+// 1KB call made while it is being written.
+func TestServer_BulkChannel(t *testing.T) {
+	server := NewServer()
+	var bulk Bulk
+	var echo Echo
+	_ = server.Register(&bulk, WithBulkResponses())
+	_ = server.Register(&echo)
+	serverConn, clientConn := net.Pipe()
+	go server.ServeConn(serverConn)
+	client, err := NewClient(clientConn, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, HeartbeatIdle: -1, BulkChannel: true})
+	_assert(err == nil, "client error: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(client.ConnState().Bulk, "expect the bulk channel agreed on")
+
+	const size = 100 << 20
+	started := make(chan struct{})
+	ctx := WithClientTrace(context.Background(), &ClientTrace{
+		GotResponseHeader: func(string, uint64, error) { close(started) },
+	})
+	bigDone := make(chan error, 1)
+	go func() {
+		var reply []byte
+		err := client.CallContext(ctx, "Bulk.Get", size, &reply)
+		if err == nil && len(reply) != size {
+			err = io.ErrShortBuffer
+		}
+		bigDone <- err
+	}()
+	<-started // the body is being written
+
+	begin := time.Now()
+	var reply []byte
+	err = client.Call("Echo.Echo", make([]byte, 1<<10), &reply)
+	elapsed := time.Since(begin)
+	_assert(err == nil && len(reply) == 1<<10, "small call error: %v", err)
+	select {
+	case err := <-bigDone:
+		t.Fatalf("the 100MB call ended before the small one, %v", err)
+	default:
+	}
+	_assert(elapsed < 200*time.Millisecond, "the small call took %s", elapsed)
+	err = <-bigDone
+	_assert(err == nil, "big call error: %v", err)
+}
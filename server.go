@@ -5,14 +5,22 @@
 package tinyrpc
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 	"tinyrpc/codec"
+	"tinyrpc/internal/clock"
+	"tinyrpc/rpclog"
 )
 
 // ------------------------------
@@ -31,6 +39,90 @@ const MagicNumber = 0x3bef5c
 type Option struct {
 	MagicNumber int        // MagicNumber marks this's a geerpc request
 	CodecType   codec.Type // client may choose different Codec to encode body
+
+	// AllowCodecFallback is sent to the server, which then answers the
+	// options with the codecs it supports. If it doesn't have CodecType,
+	// the client switches to the first of FallbackCodecs it has, still on
+	// the same connection, or fails with ErrNoCommonCodec. This takes one
+	// round trip more than connecting without fallback.
+	AllowCodecFallback bool
+	FallbackCodecs     []codec.Type `json:"-"` // in order of preference
+
+	// AllowBodyCodecs is sent to the server, which then answers the
+	// options with the body codecs and compressions it supports, for
+	// WithBodyCodec, WithCompression and WithChecksum. This takes one
+	// round trip more, shared with AllowCodecFallback.
+	AllowBodyCodecs bool
+
+	// AuthToken is sent to the server, which checks it with its
+	// authenticators, see ServerConfig.Authenticate. If set, the server
+	// answers the options, and Dial fails with ErrUnauthenticated when
+	// the token is rejected.
+	AuthToken string
+
+	// EnableChecksum is sent to the server, which then answers the
+	// options. Past the handshake, every write of either side is then
+	// framed with its CRC32C, and a corrupted frame fails with
+	// ErrCorrupted and closes the connection before anything in it is
+	// decoded. It takes 8 bytes per write, and a server supporting it.
+	EnableChecksum bool
+
+	// EncryptionKeyID, if set, is sent to the server, which then answers
+	// the options. Past the handshake, both sides then seal every write
+	// with AES-GCM under EncryptionKey, which the server must have under
+	// this ID, see ServerConfig.EncryptionKeys and codec.NewEncryptedConn.
+	// Dial fails with ErrUnknownKey if it hasn't.
+	EncryptionKeyID string
+	EncryptionKey   []byte `json:"-"` // local to the client, 16, 24 or 32 bytes
+
+	// ProtocolVersion is the highest version of the protocol the client
+	// speaks, ProtocolVersion if 0. See Client.ProtocolVersion for the
+	// version agreed on with the server.
+	ProtocolVersion int
+
+	Socket *SocketOptions `json:"-"` // local to the client, DefaultSocketOptions if nil
+	Dialer Dialer         `json:"-"` // local to the client, a net.Dialer built from Socket if nil
+
+	TLSConfig *tls.Config `json:"-"` // local to the client, used by DialWebSocket for wss://
+
+	// HeartbeatIdle is how long the connection may be idle before the client
+	// pings the server, DefaultHeartbeatIdle if 0, never if negative.
+	// The TCP keepalive is set separately by Socket.KeepAlive.
+	HeartbeatIdle time.Duration `json:"-"`
+
+	// ConnectTimeout bounds connecting in Dial, no limit if 0.
+	ConnectTimeout time.Duration `json:"-"`
+	// WriteTimeout bounds every write of the client to the connection,
+	// DefaultWriteTimeout if 0, no limit if negative. A write that times
+	// out may leave a frame half written: the connection is closed, and
+	// its calls fail with ErrWriteTimeout.
+	WriteTimeout time.Duration `json:"-"`
+	// HandleTimeout is sent to the server, which answers calls it takes
+	// longer to handle with ErrHandleTimeout. No limit if 0.
+	HandleTimeout time.Duration
+
+	// WrapCodec, if not nil, wraps the codec of the client's connection,
+	// e.g. to capture its traffic.
+	WrapCodec func(codec.Codec) codec.Codec `json:"-"`
+
+	// OrderedResponses is sent to the server, which then writes the
+	// responses in the order it read the requests, for clients that don't
+	// match responses by Seq. The calls are still handled concurrently,
+	// but a slow call delays the responses of the calls after it, and
+	// the server stops reading requests while ResponseLimits.MaxReordered
+	// of them wait for their turn.
+	OrderedResponses bool
+
+	// BulkChannel is sent to the server, which then sends the responses
+	// of bulk services (see WithBulkResponses) and the pushes on a
+	// channel of their own, in segments interleaved with the other
+	// frames, so that a huge response doesn't hold them back. Servers
+	// without bulk channels ignore it.
+	BulkChannel bool
+
+	// Clock times the client's heartbeats and method timeouts, the real
+	// clock if nil. Connecting is always timed by the real clock.
+	Clock Clock `json:"-"`
 }
 
 var DefaultOption = &Option{
@@ -41,11 +133,77 @@ var DefaultOption = &Option{
 // ------------------------------
 
 // Server represents an RPC Server.
-type Server struct{}
+type Server struct {
+	serviceMap sync.Map
+	sockOpt    *SocketOptions
+	wrapConn   func(net.Conn) net.Conn
+	wrapCodec  func(codec.Codec) codec.Codec
+	clock      Clock        // the real clock if nil
+	codecs     []codec.Type // accepted, all the registered ones if nil
+
+	firstRequestTimeout time.Duration
+	limits              ResponseLimits
+	progressInterval    time.Duration
 
-// NewServer returns a new Server.
-func NewServer() *Server {
-	return &Server{}
+	proxyProtocol  bool
+	maxErrorLen    int
+	reserved       string // extra prefix of reserved service names
+	timeouts       methodTimeouts
+	logAdmin       bool       // serve "_log_", see EnableLogAdmin
+	authorizeAdmin Authorizer // serve "_admin_" if not nil, see EnableAdmin
+	maxVersion     int        // of the protocol, ProtocolVersion if 0
+	ipLimits       IPLimits   // see SetIPLimits
+	ips            *ipThrottle
+	rawHandler     RawHandler
+	validator      func(serviceMethod string, args interface{}) error
+	sched          *scheduler   // nil without ServerConfig.Workers
+	dedup          *dedupWindow // nil without ServerConfig.AtMostOnce
+
+	builtinOnce sync.Once
+	builtins    map[string]*service
+
+	config ServerConfig                 // frozen once started
+	live   atomic.Pointer[LimitsConfig] // set once started, see UpdateLimits
+
+	mu        sync.Mutex // protect following
+	started   bool       // a connection or a listener was served
+	listeners map[net.Listener]struct{}
+	conns     map[io.Closer]*serverConn // nil until the handshake is done
+	connsByID map[uint64]*serverConn    // the conns past the handshake
+	added     []addedListener           // added by AddListener, served by Run
+	shutdown  bool
+	notReady  bool            // see SetReady
+	readyFor  map[string]bool // by service, see SetReadyFor
+	connWg    sync.WaitGroup  // connections being served
+	regCfg    *RegistryConfig
+	adverts   map[string]*advert // advertised address -> its heartbeats
+	regErrs   []registerError    // of the failed registrations, see Validate
+
+	connSeq                 uint64               // last connection ID, accessed atomically
+	bytesRead, bytesWritten uint64               // accessed atomically
+	requests                uint64               // accessed atomically
+	inflight                int64                // accessed atomically
+	invalid                 uint64               // accessed atomically
+	handshakeOnly           uint64               // accessed atomically
+	slowDropped             uint64               // accessed atomically
+	notFound                uint64               // accessed atomically
+	corrupted               uint64               // accessed atomically
+	anomalies               [numAnomalies]uint64 // accessed atomically
+	limitWarnings           [numLimits]uint64    // accessed atomically
+
+	pubsub pubsub
+	sizes  sizeStats
+}
+
+// NewServer returns a server configured with opts, see Configure. It
+// panics if an option is invalid, e.g. a negative timeout.
+func NewServer(opts ...ServerOption) *Server {
+	server := &Server{}
+	server.ips = newIPThrottle(server)
+	if err := server.Configure(opts...); err != nil {
+		panic(err)
+	}
+	return server
 }
 
 // DefaultServer is the default instance of *Server.
@@ -70,111 +228,909 @@ serveCodec 方法将使用该编解码器对象来处理 RPC 请求和响应。
 // ServeConn runs the server on a single connection.
 // ServeConn blocks, serving the connection until the client hangs up.
 func (server *Server) ServeConn(conn io.ReadWriteCloser) {
+	server.serveConn(context.Background(), conn, nil)
+}
+
+func (server *Server) serveConn(ctx context.Context, conn io.ReadWriteCloser, lopt *ListenerOptions) {
 	defer func() { _ = conn.Close() }()
-	var opt Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-		log.Println("rpc server: options error: ", err)
+	if ctx.Err() != nil {
+		return
+	}
+	if !server.trackConn(conn, true) {
 		return
 	}
-	if opt.MagicNumber != MagicNumber {
-		log.Printf("rpc server: invalid magic number %x", opt.MagicNumber)
+	defer server.trackConn(conn, false)
+	accepted := clock.Or(server.clock).Now()
+	raw := conn // the key of server.conns
+	if server.proxyProtocol || (lopt != nil && lopt.ProxyProtocol) {
+		pc, err := readProxyHeader(conn)
+		if err != nil {
+			server.log(rpclog.LevelError, "proxy protocol error", "err", err)
+			return
+		}
+		conn = pc
+	}
+	if t := server.config.Trace; t != nil && t.ConnAccepted != nil {
+		t.ConnAccepted(remoteAddr(conn))
+	}
+	ip := ipOf(conn)
+	if limits := server.currentLimits().IP; limits.enabled() && ip != "" {
+		if !server.ips.acquire(ip, limits) {
+			server.log(rpclog.LevelWarn, "too many connections from ip, closing", "ip", ip)
+			return
+		}
+		defer server.ips.release(ip)
+	}
+	metered := &meteredConn{ReadWriteCloser: conn, server: server}
+	strict := server.strict()
+	dec := json.NewDecoder(strict.handshakeReader(metered))
+	if strict != nil && !strict.AllowUnknownOptions {
+		dec.DisallowUnknownFields()
+	}
+	opt, err := server.readOptions(dec, metered, lopt)
+	if err != nil {
+		if a, ok := strict.handshakeAnomaly(err); ok {
+			server.countAnomaly(a, err, "remote", remoteAddr(conn))
+			return
+		}
+		server.log(rpclog.LevelError, "options error", "err", err)
+		return
+	}
+	var out io.ReadWriteCloser = metered
+	var coalesced *coalescingConn
+	if wc := server.config.WriteCoalescing; wc.Delay > 0 {
+		coalesced = newCoalescingConn(metered, wc, server.clock)
+		defer func() { _ = coalesced.Close() }() // stops its goroutine
+		out = coalesced
+	}
+	var framed io.ReadWriteCloser = newHandshakeConn(out, dec)
+	if opt.EncryptionKeyID != "" {
+		if framed, err = codec.NewEncryptedConn(framed, server.config.EncryptionKeys[opt.EncryptionKeyID], false); err != nil {
+			server.log(rpclog.LevelError, "encryption error", "err", err)
+			return
+		}
+	}
+	if opt.EnableChecksum {
+		framed = newChecksumConn(framed)
+	}
+	var history *frameHistory
+	if server.config.FrameHistory {
+		history = newFrameHistory(server.config.History, server.clock)
+	}
+	var bulk codec.Codec
+	if opt.BulkChannel {
+		seg := newSegmentConn(framed)
+		framed = seg.channel(controlChannel)
+		bulk = codec.NewCodecFuncMap[opt.CodecType](seg.channel(bulkChannel))
+		if history != nil {
+			bulk = history.wrap(bulk)
+		}
+		if server.wrapCodec != nil {
+			bulk = server.wrapCodec(bulk)
+		}
+	}
+	stream := newLimitedConn(framed)
+	cc := codec.NewCodecFuncMap[opt.CodecType](stream)
+	if history != nil {
+		cc = history.wrap(cc)
+	}
+	if server.wrapCodec != nil {
+		cc = server.wrapCodec(cc)
+	}
+	sc := &serverConn{
+		id:          atomic.AddUint64(&server.connSeq, 1),
+		conn:        raw,
+		metered:     metered,
+		connectedAt: clock.Or(server.clock).Now(),
+		cc:          cc,
+		bulk:        bulk,
+		codecType:   opt.CodecType,
+		version:     server.protocolVersion(opt),
+		timeout:     opt.HandleTimeout,
+		ordered:     opt.OrderedResponses,
+		authToken:   opt.AuthToken,
+		ip:          ip,
+		stream:      stream,
+		bodyCodecs:  opt.AllowBodyCodecs,
+		idleTimeout: server.config.IdleTimeout,
+		coalesced:   coalesced,
+		history:     history,
+	}
+	sc.state = &ConnState{
+		Codec:             opt.CodecType,
+		BodyCodecs:        opt.AllowBodyCodecs,
+		Version:           sc.version,
+		Checksum:          opt.EnableChecksum,
+		Bulk:              opt.BulkChannel,
+		EncryptionKeyID:   opt.EncryptionKeyID,
+		TLS:               tlsState(raw),
+		RemoteAddr:        remoteAddr(conn),
+		HandshakeDuration: sc.connectedAt.Sub(accepted),
+	}
+	if lopt != nil {
+		sc.listenerMaxBody = lopt.MaxBodySize
+		sc.idleTimeout = time.Duration(minLimit(int64(sc.idleTimeout), int64(lopt.IdleTimeout)))
+	}
+	if !server.activateConn(sc) {
+		_ = sc.cc.Close()
+		return
+	}
+	defer server.watchContext(ctx, sc)()
+	server.serveCodec(sc)
+}
+
+// ServeCodec is like ServeConn, but serves cc, a codec past the
+// handshake, e.g. one replaying captured traffic. It returns once cc
+// returns an error from ReadHeader and the calls read are answered.
+// WrapCodec is not applied.
+func (server *Server) ServeCodec(cc codec.Codec) {
+	if !server.trackConn(cc, true) {
+		_ = cc.Close()
 		return
 	}
-	f := codec.NewCodecFuncMap[opt.CodecType]
-	if f == nil {
-		log.Printf("rpc server: invalid codec type %s", opt.CodecType)
+	defer server.trackConn(cc, false)
+	sc := &serverConn{
+		id:          atomic.AddUint64(&server.connSeq, 1),
+		conn:        cc,
+		metered:     &meteredConn{server: server}, // no bytes counted
+		connectedAt: clock.Or(server.clock).Now(),
+		cc:          cc,
+		idleTimeout: server.config.IdleTimeout,
+	}
+	if !server.activateConn(sc) {
+		_ = cc.Close()
 		return
 	}
-	server.serveCodec(f(conn))
+	server.serveCodec(sc)
+}
+
+// handshakeConn continues reading conn where the handshake decoder stopped.
+// The json decoder may have read ahead past the options, and json.Encoder
+// terminates the options with a newline that is not part of the codec stream.
+type handshakeConn struct {
+	io.ReadWriteCloser
+	r *bufio.Reader
+}
+
+func newHandshakeConn(conn io.ReadWriteCloser, dec *json.Decoder) *handshakeConn {
+	r := bufio.NewReader(io.MultiReader(dec.Buffered(), conn))
+	if b, err := r.ReadByte(); err == nil && b != '\n' {
+		_ = r.UnreadByte()
+	}
+	return &handshakeConn{ReadWriteCloser: conn, r: r}
 }
 
+func (c *handshakeConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *handshakeConn) ReadByte() (byte, error) { return c.r.ReadByte() }
+
 // --------------------------
 
 // invalidRequest is a placeholder for response argv when error occurs
 var invalidRequest = struct{}{}
 
-func (server *Server) serveCodec(cc codec.Codec) {
-	sending := new(sync.Mutex) // make sure to send a complete response
-	wg := new(sync.WaitGroup)  // wait until all request are handled
+func (server *Server) serveCodec(sc *serverConn) {
+	cc := sc.cc
+	wg := new(sync.WaitGroup) // wait until all request are handled
+	first := server.awaitFirstRequest(sc)
+	now := clock.Or(server.clock).Now
+	sc.mu.Lock()
+	sc.lastActive = now()
+	sc.mu.Unlock()
+	defer server.watchIdle(sc)()
+	sc.unsentCond = sync.NewCond(&sc.mu)
+	tooLarge := false
 	for {
-		req, err := server.readRequest(cc)
+		server.waitUnsent(sc)
+		req, err := server.readRequest(sc)
+		if req != nil {
+			first()
+		}
 		if err != nil {
+			if errors.Is(err, ErrCorrupted) || errors.Is(err, codec.ErrStreamCorrupt) {
+				server.quarantine(sc, err)
+				break
+			}
+			if errors.Is(err, ErrProtocolViolation) {
+				server.abort(sc, err) // counted by readRequest
+				break
+			}
 			if req == nil {
 				break // it's not possible to recover, so close the connection
 			}
-			req.h.Error = err.Error()
-			server.sendResponse(cc, req.h, invalidRequest, sending)
+			server.setError(req.h, err)
+			req.h.Metadata = nil // not a trailer
+			setRetryAfter(req.h, err)
+			req.h.BodyCodec = "" // maybe unknown
+			req.h.Compression, req.h.Checksum = "", false
+			req.turn = sc.takeTurn()
+			server.respond(sc, req, invalidRequest)
+			if errors.Is(err, ErrBodyTooLarge) {
+				tooLarge = true
+				break // the rest of the body is still to be read
+			}
 			continue
 		}
+		if req.h.ServiceMethod == cancelMethod {
+			sc.cancelRequest(req.h.Seq)
+			continue
+		}
+		req.call.sc, req.call.md = sc, callMetadata{seq: req.h.Seq, header: req.h.Metadata}
+		sc.track(req.h.Seq, &req.call)
+		req.turn = sc.takeTurn()
 		wg.Add(1)
-		go server.handleRequest(cc, req, sending, wg)
+		sc.begin(now())
+		atomic.AddUint64(&server.requests, 1)
+		atomic.AddInt64(&server.inflight, 1)
+		handle := func() {
+			defer atomic.AddInt64(&server.inflight, -1)
+			defer func() { sc.end(now()) }()
+			server.handleRequest(sc, req, wg)
+		}
+		if s := server.sched; s != nil && !sc.ordered && req.raw == nil && !strings.HasPrefix(req.h.ServiceMethod, BuiltinPrefix) {
+			if err := s.push(parsePriority(req.h.Metadata[PriorityHeader]), handle); err != nil {
+				go func() {
+					defer atomic.AddInt64(&server.inflight, -1)
+					defer func() { sc.end(now()) }()
+					server.reject(sc, req, wg, err)
+				}()
+			}
+		} else {
+			go handle()
+		}
+		if req.raw != nil {
+			<-req.raw.done // the handler reads the body
+			switch sc.stream.unlimit() {
+			case verdictDeny:
+				tooLarge = true
+			case verdictWarn:
+				server.warnLimit(sc, limitBodySize, req.h.ServiceMethod, "max", sc.maxBody)
+			}
+			if tooLarge {
+				break // the handler got ErrBodyTooLarge
+			}
+		}
 	}
 	wg.Wait()
+	if tooLarge {
+		server.linger(sc)
+	}
+	server.unsubscribeAll(sc)
+	if sc.coalesced != nil {
+		sc.coalesced.flush() // the responses are written before the conn is closed
+	}
 	_ = cc.Close()
 }
 
-// request stores all information of a call
+// quarantine ends sc, whose stream is corrupt: it tells the client why,
+// cancels the handlers of its calls and closes the connection at once,
+// failing the calls in flight rather than waiting for them.
+func (server *Server) quarantine(sc *serverConn, err error) {
+	atomic.AddUint64(&server.corrupted, 1)
+	if !errors.Is(err, ErrCorrupted) {
+		err = fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+	server.log(rpclog.LevelWarn, "corrupt stream, closing the connection", "err", err)
+	server.abort(sc, err)
+}
+
+// abort tells the client of sc the error ending it, cancels the handlers
+// of its calls and closes it at once.
+func (server *Server) abort(sc *serverConn, err error) {
+	server.logHistory(sc)
+	h := &codec.Header{} // about the connection
+	server.setError(h, err)
+	server.sendResponse(sc.cc, h, invalidRequest)
+	sc.cancelAll()
+	_ = sc.cc.Close()
+}
+
+// request stores all information of a call. What a call needs is held in
+// the request itself, so that it is allocated once.
 type request struct {
-	h            *codec.Header // header of request
+	h            *codec.Header // header of request, &header
+	header       codec.Header
 	argv, replyv reflect.Value // argv and replyv of request
+	mtype        *methodType
+	svc          *service
+	raw          *rawBody // body of a call to the raw handler
+	rawReply     interface{}
+	turn         uint64      // of its response, see Option.OrderedResponses
+	call         callContext // of the handler, cancelled by a cancel frame for h.Seq
+	timings      *Timings    // &stamps if the server collects them
+	stamps       Timings
 }
 
-func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
-	var h codec.Header
-	if err := cc.ReadHeader(&h); err != nil {
+func (server *Server) readRequestHeader(cc codec.Codec, h *codec.Header) error {
+	if err := cc.ReadHeader(h); err != nil {
 		if err != io.EOF && err != io.ErrUnexpectedEOF {
-			log.Println("rpc server: read header error:", err)
+			server.log(rpclog.LevelError, "read header error", "err", err)
 		}
-		return nil, err
+		return err
 	}
-	return &h, nil
+	return nil
 }
 
-func (server *Server) readRequest(cc codec.Codec) (*request, error) {
-	h, err := server.readRequestHeader(cc)
+func (server *Server) readRequest(sc *serverConn) (*request, error) {
+	cc := sc.cc
+	req := &request{}
+	req.h = &req.header
+	h := req.h
+	var now func() time.Time
+	var arrived time.Time
+	if len(server.config.Timings) > 0 {
+		now = clock.Or(server.clock).Now
+		if sc.stream != nil {
+			sc.stream.arriving(now)
+		} else {
+			arrived = now()
+		}
+	}
+	err := server.readRequestHeader(cc, h)
 	if err != nil {
 		return nil, err
 	}
-	req := &request{h: h}
-	// TODO: now we don't know the type of request argv
-	// day 1, just suppose it's string
-	req.argv = reflect.New(reflect.TypeOf(""))
-	if err = cc.ReadBody(req.argv.Interface()); err != nil {
-		log.Println("rpc server: read argv err:", err)
+	limits := server.currentLimits()
+	if strict := server.strict(); strict != nil {
+		switch v, err := strict.checkMetadata(h, limits.WarnOnly.Metadata); v {
+		case verdictDeny:
+			server.countAnomaly(anomalyOversizedMetadata, err, "conn", sc.id)
+			return req, err
+		case verdictWarn:
+			server.warnLimit(sc, limitMetadata, h.ServiceMethod, "err", err)
+		}
+		if a, err := strict.checkHeader(sc, h); err != nil {
+			server.countAnomaly(a, err, "conn", sc.id)
+			return req, err
+		}
+	}
+	if now != nil && !strings.HasPrefix(h.ServiceMethod, BuiltinPrefix) {
+		req.timings = &req.stamps
+		req.stamps.Arrived, req.stamps.HeaderRead = arrived, now()
+		if sc.stream != nil {
+			req.stamps.Arrived = sc.stream.arrived
+		}
+	}
+	if h.ServiceMethod == cancelMethod {
+		return req, codec.DiscardBody(cc)
+	}
+	if sc.contextDone() {
+		_ = codec.DiscardBody(cc)
+		return req, ErrServerClosed
+	}
+	if t := server.config.Trace; t != nil && t.GotRequestHeader != nil {
+		t.GotRequestHeader(h.ServiceMethod, h.Seq)
+	}
+	if !strings.HasPrefix(h.ServiceMethod, BuiltinPrefix) {
+		if err := server.checkLimits(sc, h, limits); err != nil {
+			_ = codec.DiscardBody(cc)
+			return req, err
+		}
+	}
+	req.svc, req.mtype, err = server.findService(h.ServiceMethod)
+	if err != nil && server.rawHandler != nil && !strings.HasPrefix(h.ServiceMethod, BuiltinPrefix) {
+		req.raw = newRawBody(cc, h.BodyCodec)
+		server.limitBody(sc, nil) // lifted once the handler read the body
+		if req.timings != nil {
+			req.stamps.BodyDecoded = req.stamps.HeaderRead
+		}
+		return req, nil
+	}
+	if err != nil {
+		// skip the body so the next header is read from the right place
+		atomic.AddUint64(&server.notFound, 1)
+		_ = codec.DiscardBody(cc)
+		return req, err
+	}
+	req.argv = req.mtype.newArgv()
+	req.replyv = req.mtype.newReplyv()
+
+	// make sure that argvi is a pointer, ReadBody need a pointer as parameter
+	argvi := req.argv
+	if !req.mtype.argIsPtr {
+		argvi = req.argv.Addr()
+	}
+	server.limitBody(sc, req.svc)
+	err = cc.ReadBody(argvi.Interface())
+	switch sc.stream.unlimit() {
+	case verdictDeny:
+		server.log(rpclog.LevelWarn, "request body too large", "method", h.ServiceMethod, "max", sc.maxBody)
+		atomic.AddUint64(&server.sizes.of(h.ServiceMethod).rejected, 1)
+		return req, ErrBodyTooLarge
+	case verdictWarn:
+		server.warnLimit(sc, limitBodySize, h.ServiceMethod, "max", sc.maxBody)
+	}
+	if err != nil {
+		server.log(rpclog.LevelError, "read body error", "err", err)
+		return req, err
+	}
+	server.countRequestSize(cc, req)
+	if req.timings != nil {
+		req.stamps.BodyDecoded = now()
 	}
 	return req, nil
 }
 
-func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) {
-	sending.Lock()
-	defer sending.Unlock()
-	if err := cc.Write(h, body); err != nil {
-		log.Println("rpc server: write response error:", err)
+func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}) error {
+	err := cc.Write(h, body)
+	if err != nil {
+		server.log(rpclog.LevelError, "write response error", "err", err)
+	}
+	return err
+}
+
+func (server *Server) handleRequest(sc *serverConn, req *request, wg *sync.WaitGroup) {
+	defer wg.Done()
+	var err error
+	if req.timings != nil {
+		req.timings.HandlerStart = clock.Or(server.clock).Now()
+		req.call.timings = req.timings
+		defer func() { server.collectTimings(req, err) }()
+	}
+	ctx, md := context.Context(&req.call), &req.call.md
+	stop := server.keepAlive(sc, req)
+	req.call.progress = server.newProgress(sc, req)
+	if req.svc != nil && req.svc.config.bodyCodec != "" && req.h.BodyCodec == "" && sc.bodyCodecs {
+		req.h.BodyCodec = req.svc.config.bodyCodec // of the response
+	}
+	trace := server.config.Trace
+	err = server.validate(req)
+	if err != nil {
+		atomic.AddUint64(&server.invalid, 1) // the method is not called
+	} else {
+		if trace != nil && trace.HandlerStart != nil {
+			trace.HandlerStart(req.h.ServiceMethod, req.h.Seq)
+		}
+		if timeout, timeoutErr := server.handleTimeout(sc, req); timeout > 0 {
+			err = server.callTimeout(ctx, timeout, timeoutErr, req)
+		} else {
+			err = server.call(ctx, req)
+		}
+		if trace != nil && trace.HandlerDone != nil {
+			trace.HandlerDone(req.h.ServiceMethod, req.h.Seq, err)
+		}
+	}
+	if req.timings != nil {
+		req.timings.HandlerDone = clock.Or(server.clock).Now()
+	}
+	stop()
+	req.call.progress.close()
+	if sc.untrack(req.h.Seq) {
+		sc.skipTurn(req.turn)
+		return // the client abandoned the call, it discards any response
+	}
+	req.h.Metadata = md.trailerMap() // the response carries the trailer
+	replyEncoding(req.h, req.h.Metadata, sc.bodyCodecs)
+	if err != nil {
+		server.setError(req.h, err)
+		setRetryAfter(req.h, err)
+		server.respond(sc, req, invalidRequest)
+		return
 	}
+	if req.raw != nil {
+		body := req.rawReply
+		if body == nil {
+			body = invalidRequest
+		}
+		server.respond(sc, req, body)
+		return
+	}
+	body, isNil := req.mtype.replyBody(req.replyv)
+	if isNil {
+		if req.h.Metadata == nil {
+			req.h.Metadata = make(map[string]string)
+		}
+		req.h.Metadata[nilReplyHeader] = "1"
+	}
+	server.respond(sc, req, body)
+	req.mtype.recycle(req.argv, req.replyv) // encoded, and the method returned
 }
 
-func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup) {
-	// TODO, should call registered rpc methods to get the right replyv
-	// day 1, just print argv and send a hello message
+// reject responds to req with err, without calling its method.
+func (server *Server) reject(sc *serverConn, req *request, wg *sync.WaitGroup, err error) {
 	defer wg.Done()
-	log.Println(req.h, req.argv.Elem())
-	req.replyv = reflect.ValueOf(fmt.Sprintf("geerpc resp %d", req.h.Seq))
-	server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
+	if sc.untrack(req.h.Seq) {
+		sc.skipTurn(req.turn)
+		return // the client abandoned the call
+	}
+	req.h.Metadata = nil // no trailer
+	server.setError(req.h, err)
+	setRetryAfter(req.h, err)
+	server.respond(sc, req, invalidRequest)
+}
+
+// call calls the method of req, or the raw handler.
+func (server *Server) call(ctx context.Context, req *request) error {
+	if len(server.config.Interceptors) == 0 || strings.HasPrefix(req.h.ServiceMethod, BuiltinPrefix) {
+		return server.invoke(ctx, req)
+	}
+	var args interface{} = req.raw
+	if req.raw == nil {
+		args = req.argv.Interface()
+	}
+	return intercept(server.config.Interceptors, ctx, req.h.ServiceMethod, args, func(ctx context.Context) error {
+		return server.invoke(ctx, req)
+	})
+}
+
+// invoke calls the method of req, or the raw handler, past the interceptors.
+func (server *Server) invoke(ctx context.Context, req *request) error {
+	if req.raw != nil {
+		return server.callRaw(ctx, req)
+	}
+	if server.dedup != nil {
+		if key, ok := parseCallID(req.call.md.header[AtMostOnceHeader]); ok {
+			return server.dedup.do(ctx, key, req, func() error {
+				return req.svc.call(ctx, req.mtype, req.argv, req.replyv)
+			})
+		}
+	}
+	return req.svc.call(ctx, req.mtype, req.argv, req.replyv)
+}
+
+// callTimeout calls the method of req, giving up with timeoutErr after
+// timeout. The method keeps running then, but its reply is not sent.
+func (server *Server) callTimeout(ctx context.Context, timeout time.Duration, timeoutErr error, req *request) error {
+	ctx, cancel := clock.WithTimeout(clock.Or(server.clock), ctx, timeout)
+	defer cancel()
+	called := make(chan error, 1)
+	go func() {
+		called <- server.call(ctx, req)
+	}()
+	select {
+	case err := <-called:
+		return err
+	case <-ctx.Done():
+		if ctx.Err() == context.Canceled {
+			return ErrCanceled // by the client, no response is sent
+		}
+		return fmt.Errorf("%w: expect within %s", timeoutErr, timeout)
+	}
+}
+
+// Register publishes in the server the set of methods of the
+// receiver value that satisfy the following conditions:
+//   - exported method of exported type
+//   - two arguments, both of exported type
+//   - the second argument is a pointer
+//   - one return value, of type error
+//
+// or that take one argument and return the reply and an error instead,
+// e.g. func (t *T) M(args *Args) (*Reply, error). Either kind may take a
+// context.Context first. A nil pointer reply is sent as the zero value.
+// Struct replies and struct args taken by value are reused by later calls
+// once the response is written, so a method must not keep its reply
+// pointer after returning.
+// opts override settings of the server for the methods of rcvr only, see
+// ServiceOption.
+func (server *Server) Register(rcvr interface{}, opts ...ServiceOption) error {
+	return server.register(rcvr, "", opts)
+}
+
+// RegisterName is like Register but uses the provided name for the type
+// instead of the receiver's concrete type.
+func (server *Server) RegisterName(name string, rcvr interface{}, opts ...ServiceOption) error {
+	if name == "" || strings.Contains(name, ".") {
+		return server.registerFailed(name, errors.New("rpc: invalid service name: "+name))
+	}
+	return server.register(rcvr, name, opts)
+}
+
+// register publishes rcvr as name, the name of its type if empty. The
+// error is also kept for Validate, until a service of the same name is
+// registered.
+func (server *Server) register(rcvr interface{}, name string, opts []ServiceOption) error {
+	if name == "" {
+		name = reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name()
+	}
+	return server.registerFailed(name, server.addService(rcvr, name, opts))
+}
+
+// registerFailed keeps err, the result of registering the service name,
+// for Validate and returns it. A nil err drops the errors kept for name.
+func (server *Server) registerFailed(name string, err error) error {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if err != nil {
+		server.regErrs = append(server.regErrs, registerError{name, err})
+		return err
+	}
+	kept := server.regErrs[:0]
+	for _, e := range server.regErrs {
+		if e.name != name {
+			kept = append(kept, e)
+		}
+	}
+	server.regErrs = kept
+	return nil
+}
+
+func (server *Server) addService(rcvr interface{}, name string, opts []ServiceOption) error {
+	if server.isReserved(name) {
+		return fmt.Errorf("rpc: service name %q is reserved for built-in services", name)
+	}
+	var config serviceConfig
+	for _, opt := range opts {
+		if err := opt.applyService(&config); err != nil {
+			return err
+		}
+	}
+	s, err := newConfiguredService(rcvr, name, config)
+	if err != nil {
+		return err
+	}
+	if _, dup := server.serviceMap.LoadOrStore(s.name, s); dup {
+		return errors.New("rpc: service already defined: " + s.name)
+	}
+	return nil
+}
+
+// Register publishes the receiver's methods in the DefaultServer.
+func Register(rcvr interface{}, opts ...ServiceOption) error {
+	return DefaultServer.Register(rcvr, opts...)
+}
+
+// RegisterName is like Register but uses the provided name for the type.
+func RegisterName(name string, rcvr interface{}, opts ...ServiceOption) error {
+	return DefaultServer.RegisterName(name, rcvr, opts...)
+}
+
+func (server *Server) findService(serviceMethod string) (svc *service, mtype *methodType, err error) {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		err = &serverError{msg: "rpc server: service/method request ill-formed: " + serviceMethod, err: ErrMethodNotFound}
+		return
+	}
+	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
+	// built-ins are looked up first, so user services can never shadow them
+	if svc = server.builtin(serviceName); svc == nil {
+		svci, ok := server.serviceMap.Load(serviceName)
+		if !ok {
+			err = &serverError{msg: "rpc server: can't find service " + serviceName, err: ErrMethodNotFound}
+			return
+		}
+		svc = svci.(*service)
+	}
+	mtype = svc.method[methodName]
+	if mtype == nil {
+		err = &serverError{msg: "rpc server: can't find method " + methodName, err: ErrMethodNotFound}
+	}
+	return
+}
+
+// SetSocketOptions sets the options applied to every accepted TCP connection
+// and used by Listen. It must be called before the server starts serving.
+func (server *Server) SetSocketOptions(opt *SocketOptions) {
+	server.sockOpt = opt
+}
+
+func (server *Server) socketOptions() *SocketOptions {
+	if server.sockOpt == nil {
+		return DefaultSocketOptions
+	}
+	return server.sockOpt
+}
+
+// WrapConn sets a function applied to every accepted connection before it is
+// served, e.g. for throttling or protocol sniffing. It must be called before
+// the server starts serving.
+func (server *Server) WrapConn(wrap func(net.Conn) net.Conn) {
+	server.wrapConn = wrap
+}
+
+// WrapCodec sets a function applied to the codec of every connection
+// after the handshake, e.g. to capture its traffic. It must be called
+// before the server starts serving.
+func (server *Server) WrapCodec(wrap func(codec.Codec) codec.Codec) {
+	server.wrapCodec = wrap
+}
+
+// SetClock sets the clock timing the handle timeouts and the registry
+// heartbeats of the server, see Clock. It must be called before the
+// server starts serving.
+func (server *Server) SetClock(c Clock) {
+	server.clock = c
+}
+
+// SetAcceptProxyProtocol makes ServeConn expect a PROXY protocol v1 or v2
+// header before the options, as sent by HAProxy or AWS NLB, and report
+// the advertised source as the peer address. Connections without a valid
+// header are rejected. It must be called before the server starts serving.
+func (server *Server) SetAcceptProxyProtocol(enabled bool) {
+	server.proxyProtocol = enabled
+}
+
+// Listen announces on the local network address, honoring SocketOptions.Control.
+func (server *Server) Listen(network, address string) (net.Listener, error) {
+	server.mu.Lock()
+	server.start()
+	server.mu.Unlock()
+	return server.socketOptions().listen(network, address)
 }
 
 // Accept accepts connections on the listener and serves requests
 // for each incoming connection.
 func (server *Server) Accept(lis net.Listener) {
+	if err := server.serve(context.Background(), lis, nil); err != nil && err != ErrServerClosed {
+		server.log(rpclog.LevelError, "accept error", "err", err)
+	}
+}
+
+// serve accepts connections until lis fails and returns that error,
+// ErrServerClosed after Shutdown, or the error of ctx once it is done and
+// the connections it accepted are closed. It serves nothing and returns
+// the ValidationErrors if the server or lopt fail Validate.
+func (server *Server) serve(ctx context.Context, lis net.Listener, lopt *ListenerOptions) error {
+	if err := server.checkConfig(lis, lopt); err != nil {
+		return err
+	}
+	if !server.trackListener(lis, true) {
+		return ErrServerClosed
+	}
+	defer server.trackListener(lis, false)
+	if addr := server.advertise(lis.Addr()); addr != "" {
+		defer server.deregister(context.Background(), addr)
+	}
+	var conns sync.WaitGroup
+	defer server.closeListenerOn(ctx, lis)()
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
-			log.Println("rpc server: accept error:", err)
-			return
+			if ctx.Err() != nil {
+				conns.Wait()
+				return ctx.Err()
+			}
+			if server.shuttingDown() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		server.socketOptions().apply(conn)
+		if server.wrapConn != nil {
+			conn = server.wrapConn(conn)
 		}
-		go server.ServeConn(conn)
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			server.serveConn(ctx, conn, lopt)
+		}()
 	}
 }
 
 // Accept accepts connections on the listener and serves requests
 // for each incoming connection.
 func Accept(lis net.Listener) { DefaultServer.Accept(lis) }
+
+// ListenAndServe listens on rpcAddr, e.g. "tcp@:9999", ":9999" or
+// "unix:///var/run/app.sock", and serves incoming connections.
+// A stale unix socket file left by a previous process is removed first.
+// It always returns a non-nil error, the one that stopped the listener,
+// or ErrServerClosed after Shutdown.
+func (server *Server) ListenAndServe(rpcAddr string) error {
+	lis, err := server.Listen(parseAddr(rpcAddr))
+	if err != nil {
+		return err
+	}
+	return server.serve(context.Background(), lis, nil)
+}
+
+// ListenAndServe serves the DefaultServer on rpcAddr.
+func ListenAndServe(rpcAddr string) error { return DefaultServer.ListenAndServe(rpcAddr) }
+
+// ErrServerClosed is returned by the serving methods after Shutdown.
+var ErrServerClosed = errors.New("rpc: server closed")
+
+func (server *Server) trackListener(lis net.Listener, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if add {
+		if server.shutdown {
+			return false
+		}
+		server.start()
+		if server.listeners == nil {
+			server.listeners = make(map[net.Listener]struct{})
+		}
+		server.listeners[lis] = struct{}{}
+	} else {
+		delete(server.listeners, lis)
+	}
+	return true
+}
+
+func (server *Server) trackConn(conn io.Closer, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if add {
+		if server.shutdown {
+			return false
+		}
+		server.start()
+		if max := server.config.MaxConnections; max > 0 && len(server.conns) >= max {
+			server.log(rpclog.LevelWarn, "too many connections, closing", "max", max)
+			return false
+		}
+		if server.conns == nil {
+			server.conns = make(map[io.Closer]*serverConn)
+		}
+		server.conns[conn] = nil
+		server.connWg.Add(1)
+	} else {
+		if sc := server.conns[conn]; sc != nil {
+			delete(server.connsByID, sc.id)
+		}
+		delete(server.conns, conn)
+		server.connWg.Done()
+	}
+	return true
+}
+
+// activateConn records that sc finished its handshake.
+func (server *Server) activateConn(sc *serverConn) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.shutdown {
+		return false
+	}
+	server.conns[sc.conn] = sc
+	if server.connsByID == nil {
+		server.connsByID = make(map[uint64]*serverConn)
+	}
+	server.connsByID[sc.id] = sc
+	return true
+}
+
+func (server *Server) shuttingDown() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.shutdown
+}
+
+// Shutdown stops the server gracefully: it stops being ready (see
+// SetReady), deregisters from the registries set by EnableRegistry,
+// closes every listener, sends a GoAway notice on every connection and
+// closes each one once it has no request in flight. It waits for all
+// connections to close; if ctx is done first, the remaining connections
+// are closed forcibly. The workers then exit, once the calls queued for
+// them are handled.
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.SetReady(false)
+	server.deregisterAll(ctx)
+	server.mu.Lock()
+	server.shutdown = true
+	if server.sched != nil {
+		defer server.sched.stop() // once the connections are closed
+	}
+	for lis := range server.listeners {
+		_ = lis.Close()
+	}
+	var active []*serverConn
+	for conn, sc := range server.conns {
+		if sc == nil {
+			_ = conn.Close() // still in the handshake
+		} else {
+			active = append(active, sc)
+		}
+	}
+	server.mu.Unlock()
+	for _, sc := range active {
+		sc.goAway(server, "")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		server.connWg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		server.mu.Lock()
+		for conn := range server.conns {
+			_ = conn.Close()
+		}
+		server.mu.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,113 @@
+package tinyrpc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"tinyrpc/codec"
+)
+
+// startServer registers Foo on a new Server and serves it on a random local port.
+func startServer(t *testing.T, server *Server) net.Listener {
+	t.Helper()
+	var foo Foo
+	if err := server.Register(&foo); err != nil {
+		t.Fatal("register error:", err)
+	}
+	lis, err := server.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	go server.Accept(lis)
+	t.Cleanup(func() { _ = lis.Close() })
+	return lis
+}
+
+func TestServer_Call(t *testing.T) {
+	lis := startServer(t, NewServer())
+	client, err := Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+
+	err = client.Call("Foo.Unknown", Args{}, &reply)
+	_assert(err != nil, "expect an error for unknown method")
+	err = client.Call("Foo.Sum", Args{Num1: 2, Num2: 2}, &reply)
+	_assert(err == nil && reply == 4, "stream broken after unknown method: %v", err)
+}
+
+func TestServer_MethodNotFound(t *testing.T) {
+	server := NewServer()
+	addr := startServer(t, server).Addr().String()
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1})
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	for i := 0; i < 1000; i++ {
+		method := fmt.Sprintf("Bogus%d.Sum", i)
+		if i%2 == 1 {
+			method = fmt.Sprintf("Foo.Bogus%d", i)
+		}
+		err := client.Call(method, Args{Num1: i, Num2: i}, &reply)
+		_assert(errors.Is(err, ErrMethodNotFound), "expect ErrMethodNotFound for %s, but got %v", method, err)
+	}
+	_assert(client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply) == nil && reply == 3, "expect valid calls to still work")
+	_assert(server.Stats().NotFound == 1000, "expect 1000 requests not found, but got %d", server.Stats().NotFound)
+}
+
+type Echo int
+
+func (e *Echo) Echo(args []byte, reply *[]byte) error {
+	*reply = args
+	return nil
+}
+
+// memConn reads a recorded stream and discards what is written.
+type memConn struct {
+	*bytes.Reader
+	io.Writer
+}
+
+func (memConn) Close() error { return nil }
+
+// smallCallAllocs is the budget of allocations of BenchmarkServerSmallCalls
+// per call, 24 before the allocations of the hot path were merged.
+const smallCallAllocs = 14
+
+// BenchmarkServerSmallCalls serves 64-byte echo calls read from memory,
+// so that allocs/op are those of the server alone, codec included. It
+// fails when they exceed smallCallAllocs.
+func BenchmarkServerSmallCalls(b *testing.B) {
+	server := NewServer()
+	var echo Echo
+	_ = server.Register(&echo)
+	var stream bytes.Buffer
+	cc := codec.NewGobCodec(memConn{Writer: &stream})
+	payload := make([]byte, 64)
+	for i := 0; i < b.N; i++ {
+		if err := cc.Write(&codec.Header{ServiceMethod: "Echo.Echo", Seq: uint64(i + 1)}, payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	server.ServeCodec(codec.NewGobCodec(memConn{Reader: bytes.NewReader(stream.Bytes()), Writer: io.Discard}))
+	runtime.ReadMemStats(&after)
+	if allocs := (after.Mallocs - before.Mallocs) / uint64(b.N); b.N >= 1000 && allocs > smallCallAllocs {
+		b.Fatalf("expect at most %d allocs per call, but got %d", smallCallAllocs, allocs)
+	}
+}
@@ -1,24 +1,77 @@
 package tinyrpc
 
 import (
+	"context"
+	"fmt"
 	"go/ast"
 	"log"
 	"reflect"
+	"sync"
 	"sync/atomic"
+	"tinyrpc/rpclog"
 )
 
 type methodType struct {
 	method    reflect.Method
 	ArgType   reflect.Type
 	ReplyType reflect.Type
-	numCalls  uint64
+	withCtx   bool // the method takes a context.Context before args
+	// returnsReply is set for methods returning their reply instead of
+	// filling in an out-pointer; ReplyType is then the returned type.
+	returnsReply bool
+	numCalls     uint64
+
+	// looked up once at registration rather than for every call
+	argIsPtr   bool          // ArgType is a pointer
+	validates  validatorKind // how ArgType implements Validator
+	emptyReply reflect.Value // the empty slice set in slice replies, shared
+	argPool    *valuePool    // of value struct args, nil if not pooled
+	replyPool  *valuePool    // of struct replies, nil if not pooled
+}
+
+// valuePool recycles the instances of a struct type, for the args and
+// the replies of a method. They are zeroed when put back, so that no
+// call sees the fields of the previous one, in a reply the method
+// leaves untouched or in an arg the codec decodes a partial body into.
+type valuePool struct {
+	pool sync.Pool // of pointers to the type
+	zero reflect.Value
+}
+
+func newValuePool(t reflect.Type) *valuePool {
+	p := &valuePool{zero: reflect.Zero(t)}
+	p.pool.New = func() interface{} { return reflect.New(t).Interface() }
+	return p
+}
+
+// get returns a pointer to a zero instance.
+func (p *valuePool) get() reflect.Value {
+	return reflect.ValueOf(p.pool.Get())
 }
 
+// put zeroes the instance ptr points to, and recycles it.
+func (p *valuePool) put(ptr reflect.Value) {
+	ptr.Elem().Set(p.zero)
+	p.pool.Put(ptr.Interface())
+}
+
+var (
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// nilReplyHeader is set in the trailer of responses whose method returned
+// a nil reply, sent as the zero value of the reply type.
+const nilReplyHeader = "tinyrpc-nil-reply"
+
 func (m *methodType) NumCalls() uint64 {
 	return atomic.LoadUint64(&m.numCalls)
 }
 
 func (m *methodType) newArgv() reflect.Value {
+	if m.argPool != nil {
+		return m.argPool.get().Elem()
+	}
 	var argv reflect.Value
 	// arg may be a pointer type, or a value type
 	if m.ArgType.Kind() == reflect.Ptr {
@@ -30,65 +83,192 @@ func (m *methodType) newArgv() reflect.Value {
 }
 
 func (m *methodType) newReplyv() reflect.Value {
+	if m.replyPool != nil {
+		return m.replyPool.get()
+	}
+	if m.returnsReply {
+		// holds the returned reply, the reply itself is made by the method
+		return reflect.New(m.ReplyType)
+	}
 	// reply must be a pointer type
 	replyv := reflect.New(m.ReplyType.Elem())
 	switch m.ReplyType.Elem().Kind() {
 	case reflect.Map:
 		replyv.Elem().Set(reflect.MakeMap(m.ReplyType.Elem()))
 	case reflect.Slice:
-		replyv.Elem().Set(reflect.MakeSlice(m.ReplyType.Elem(), 0, 0))
+		// of capacity 0, so appending to it never writes to the shared array
+		replyv.Elem().Set(m.emptyReply)
 	}
 	return replyv
 }
 
+// recycle puts argv and replyv, made by newArgv and newReplyv, back in
+// their pools once the method returned and the response was written.
+// Pointer args are never pooled: the method owns them.
+func (m *methodType) recycle(argv, replyv reflect.Value) {
+	if m.argPool != nil {
+		m.argPool.put(argv.Addr())
+	}
+	if m.replyPool != nil {
+		m.replyPool.put(replyv)
+	}
+}
+
+// setPools pools the args passed by value and the replies of m that are
+// structs, the large ones worth recycling.
+func (m *methodType) setPools() {
+	if m.ArgType.Kind() == reflect.Struct {
+		m.argPool = newValuePool(m.ArgType)
+	}
+	replyType := m.ReplyType // holding the returned reply
+	if !m.returnsReply && replyType.Kind() == reflect.Ptr {
+		replyType = replyType.Elem()
+	}
+	if replyType.Kind() == reflect.Struct {
+		m.replyPool = newValuePool(replyType)
+	}
+}
+
+// replyBody returns the body of the response for replyv, made by
+// newReplyv, and whether the method returned a nil reply.
+func (m *methodType) replyBody(replyv reflect.Value) (interface{}, bool) {
+	if !m.returnsReply {
+		return replyv.Interface(), false
+	}
+	reply := replyv.Elem()
+	if reply.Kind() == reflect.Ptr && reply.IsNil() {
+		return reflect.New(m.ReplyType.Elem()).Interface(), true
+	}
+	return reply.Interface(), false
+}
+
 type service struct {
 	name   string
 	typ    reflect.Type
 	rcvr   reflect.Value
 	method map[string]*methodType
+	config serviceConfig // set by the ServiceOptions of Register
 }
 
 func newService(rcvr interface{}) *service {
+	return newNamedService(rcvr, "")
+}
+
+// newNamedService publishes rcvr under name, or under its type name
+// if name is empty.
+func newNamedService(rcvr interface{}, name string) *service {
+	s, err := newConfiguredService(rcvr, name, serviceConfig{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return s
+}
+
+// newConfiguredService is like newNamedService, with the config set by
+// the ServiceOptions of Register.
+func newConfiguredService(rcvr interface{}, name string, config serviceConfig) (*service, error) {
 	s := new(service)
 	s.rcvr = reflect.ValueOf(rcvr)
 	s.name = reflect.Indirect(s.rcvr).Type().Name()
 	s.typ = reflect.TypeOf(rcvr)
-	if !ast.IsExported(s.name) {
+	s.config = config
+	if name != "" {
+		s.name = name
+	} else if !ast.IsExported(s.name) {
 		log.Fatalf("rpc server: %s is not a valid service name", s.name)
 	}
+	ambiguous := ambiguousMethods(s.typ)
+	for name := range config.excluded {
+		if _, ok := s.typ.MethodByName(name); !ok && ambiguous[name] == nil {
+			return nil, fmt.Errorf("rpc: no method %s.%s to exclude", s.name, name)
+		}
+	}
+	if err := s.checkAmbiguous(ambiguous); err != nil {
+		return nil, err
+	}
 	s.registerMethods()
-	return s
+	return s, nil
+}
+
+// newMethodType returns the methodType of method, nil if it is not
+// an RPC method.
+func newMethodType(method reflect.Method) *methodType {
+	mType := method.Type
+	// func (T) M(args, reply *R) error or func (T) M(ctx, args, reply *R) error,
+	// or func (T) M(args) (R, error) or func (T) M(ctx, args) (R, error)
+	withCtx := mType.NumIn() > 2 && mType.In(1) == typeOfContext
+	numIn := mType.NumIn()
+	if withCtx {
+		numIn--
+	}
+	returnsReply := numIn == 2 && mType.NumOut() == 2
+	if (numIn != 3 || mType.NumOut() != 1) && !returnsReply {
+		return nil
+	}
+	if mType.Out(mType.NumOut()-1) != typeOfError {
+		return nil
+	}
+	argType, replyType := mType.In(mType.NumIn()-2), mType.In(mType.NumIn()-1)
+	if returnsReply {
+		argType, replyType = mType.In(mType.NumIn()-1), mType.Out(0)
+	}
+	if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
+		return nil
+	}
+	return &methodType{
+		method:       method,
+		ArgType:      argType,
+		ReplyType:    replyType,
+		withCtx:      withCtx,
+		returnsReply: returnsReply,
+		argIsPtr:     argType.Kind() == reflect.Ptr,
+		validates:    validatorKindOf(argType),
+	}
 }
 
+// registerMethods registers the RPC methods of the method set of s.typ,
+// those promoted from embedded fields included, but the excluded ones.
 func (s *service) registerMethods() {
 	s.method = make(map[string]*methodType)
 	for i := 0; i < s.typ.NumMethod(); i++ {
 		method := s.typ.Method(i)
-		mType := method.Type
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		m := newMethodType(method)
+		if m == nil || s.config.excluded[method.Name] {
 			continue
 		}
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
-			continue
-		}
-		argType, replyType := mType.In(1), mType.In(2)
-		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
-			continue
+		argType, replyType := m.ArgType, m.ReplyType
+		if !m.returnsReply && replyType.Kind() == reflect.Ptr && replyType.Elem().Kind() == reflect.Slice {
+			m.emptyReply = reflect.MakeSlice(replyType.Elem(), 0, 0)
 		}
-		s.method[method.Name] = &methodType{
-			method:    method,
-			ArgType:   argType,
-			ReplyType: replyType,
+		m.setPools()
+		s.method[method.Name] = m
+		rpclog.Info("server", "register", "method", s.name+"."+method.Name)
+		for _, t := range []reflect.Type{argType, replyType} {
+			for _, field := range unregisteredInterfaceFields(t) {
+				rpclog.Warn("server", "no type passed to RegisterType implements interface field",
+					"method", s.name+"."+method.Name, "field", field)
+			}
 		}
-		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
 	}
 }
 
-func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+func (s *service) call(ctx context.Context, m *methodType, argv, replyv reflect.Value) error {
 	atomic.AddUint64(&m.numCalls, 1)
 	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv})
-	if errInter := returnValues[0].Interface(); errInter != nil {
+	var buf [4]reflect.Value
+	in := append(buf[:0], s.rcvr)
+	if m.withCtx {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	in = append(in, argv)
+	if !m.returnsReply {
+		in = append(in, replyv)
+	}
+	returnValues := f.Call(in)
+	if m.returnsReply {
+		replyv.Elem().Set(returnValues[0])
+	}
+	if errInter := returnValues[len(returnValues)-1].Interface(); errInter != nil {
 		return errInter.(error)
 	}
 	return nil
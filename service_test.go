@@ -1,6 +1,8 @@
 package tinyrpc
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"testing"
@@ -43,6 +45,240 @@ func TestMethodType_Call(t *testing.T) {
 	argv := mType.newArgv()
 	replyv := mType.newReplyv()
 	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
-	err := s.call(mType, argv, replyv)
+	err := s.call(context.Background(), mType, argv, replyv)
 	_assert(err == nil && *replyv.Interface().(*int) == 4 && mType.NumCalls() == 1, "failed to call Foo.Sum")
 }
+
+type Calc int
+
+type Product struct{ Value int }
+
+// Sum fills in an out-pointer
+func (c *Calc) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+// Mul returns its reply, nil if a factor is 0
+func (c *Calc) Mul(ctx context.Context, args *Args) (*Product, error) {
+	if args.Num1 == 0 || args.Num2 == 0 {
+		return nil, nil
+	}
+	return &Product{args.Num1 * args.Num2}, nil
+}
+
+// Neg returns a value reply
+func (c *Calc) Neg(n int) (int, error) {
+	if n == 0 {
+		return 0, errors.New("zero")
+	}
+	return -n, nil
+}
+
+func TestNewService_ReturnedReply(t *testing.T) {
+	var calc Calc
+	s := newService(&calc)
+	_assert(len(s.method) == 3, "expect 3 methods, but got %d", len(s.method))
+	mType := s.method["Mul"]
+	_assert(mType.returnsReply && mType.withCtx && mType.ReplyType == reflect.TypeOf(&Product{}),
+		"unexpected method type %+v", mType)
+
+	argv, replyv := mType.newArgv(), mType.newReplyv()
+	argv.Elem().Set(reflect.ValueOf(Args{Num1: 2, Num2: 3}))
+	err := s.call(context.Background(), mType, argv, replyv)
+	body, isNil := mType.replyBody(replyv)
+	_assert(err == nil && !isNil && body.(*Product).Value == 6, "failed to call Calc.Mul: %v", err)
+}
+
+func TestServer_ReturnedReply(t *testing.T) {
+	server := NewServer()
+	var calc Calc
+	_ = server.Register(&calc)
+	client, err := Dial("tcp", startServer(t, server).Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var sum int
+	err = client.Call("Calc.Sum", Args{Num1: 1, Num2: 2}, &sum)
+	_assert(err == nil && sum == 3, "failed to call Calc.Sum: %v", err)
+	var product Product
+	var trailer Metadata
+	err = client.Call("Calc.Mul", &Args{Num1: 2, Num2: 3}, &product, WithTrailer(&trailer))
+	_assert(err == nil && product.Value == 6 && trailer[nilReplyHeader] == "", "failed to call Calc.Mul: %v", err)
+	product = Product{}
+	err = client.Call("Calc.Mul", &Args{Num1: 0, Num2: 3}, &product, WithTrailer(&trailer))
+	_assert(err == nil && product.Value == 0 && trailer[nilReplyHeader] == "1", "expect a flagged nil reply, but got %v, %v", trailer, err)
+	var neg int
+	err = client.Call("Calc.Neg", 4, &neg)
+	_assert(err == nil && neg == -4, "failed to call Calc.Neg: %v", err)
+	err = client.Call("Calc.Neg", 0, &neg)
+	_assert(err != nil && err.Error() == "zero", "expect the error of Calc.Neg, but got %v", err)
+}
+
+// Wide is a large struct, the kind of arg and reply worth pooling.
+type Wide struct {
+	S00 string
+	S01 string
+	S02 string
+	S03 string
+	S04 string
+	S05 string
+	S06 string
+	S07 string
+	S08 string
+	S09 string
+	S10 string
+	S11 string
+	S12 string
+	S13 string
+	S14 string
+	S15 string
+	S16 string
+	S17 string
+	S18 string
+	S19 string
+	N00 int64
+	N01 int64
+	N02 int64
+	N03 int64
+	N04 int64
+	N05 int64
+	N06 int64
+	N07 int64
+	N08 int64
+	N09 int64
+	N10 int64
+	N11 int64
+	N12 int64
+	N13 int64
+	N14 int64
+	N15 int64
+	N16 int64
+	N17 int64
+	N18 int64
+	N19 int64
+}
+
+// filledWide has every field of a Wide set.
+func filledWide() Wide {
+	w := Wide{}
+	v := reflect.ValueOf(&w).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		switch f := v.Field(i); f.Kind() {
+		case reflect.String:
+			f.SetString("s")
+		case reflect.Int64:
+			f.SetInt(int64(i))
+		}
+	}
+	return w
+}
+
+type WideService int
+
+// Echo replies with its args, showing what was decoded into them.
+func (WideService) Echo(args Wide, reply *Wide) error {
+	*reply = args
+	return nil
+}
+
+// Touch fills in its reply only for non-zero args.
+func (WideService) Touch(args Wide, reply *Wide) error {
+	if args.N01 != 0 {
+		*reply = args
+	}
+	return nil
+}
+
+func TestMethodType_PooledValues(t *testing.T) {
+	var ws WideService
+	s := newService(&ws)
+	mType := s.method["Touch"]
+	_assert(mType.argPool != nil && mType.replyPool != nil, "expect Wide args and replies pooled")
+	_assert(newService(new(Foo)).method["Sum"].replyPool == nil, "expect *int replies not pooled")
+
+	reused := 0
+	var last *Wide
+	for i := 0; i < 100; i++ {
+		argv, replyv := mType.newArgv(), mType.newReplyv()
+		reply := replyv.Interface().(*Wide)
+		_assert(argv.Interface().(Wide) == Wide{} && *reply == Wide{}, "pooled values not zeroed: %+v %+v", argv, *reply)
+		if reply == last {
+			reused++
+		}
+		last = reply
+		argv.Set(reflect.ValueOf(filledWide()))
+		_ = s.call(context.Background(), mType, argv, replyv)
+		mType.recycle(argv, replyv)
+	}
+	_assert(reused > 0, "expect the replies reused")
+}
+
+// TestServer_PooledValues alternates callers filling every field with
+// callers sending zero args: a pooled value not zeroed between them would
+// leak the fields of the previous call.
+func TestServer_PooledValues(t *testing.T) {
+	server := NewServer()
+	var ws WideService
+	_ = server.Register(&ws)
+	client, err := Dial("tcp", startServer(t, server).Addr().String(), &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	full := filledWide()
+	for i := 0; i < 20; i++ {
+		for _, method := range []string{"WideService.Echo", "WideService.Touch"} {
+			var reply Wide
+			err := client.Call(method, full, &reply)
+			_assert(err == nil && reply == full, "%s error: %v, reply %+v", method, err, reply)
+			reply = Wide{}
+			err = client.Call(method, Wide{}, &reply)
+			_assert(err == nil && reply == Wide{}, "%s leaked a previous call: %v, reply %+v", method, err, reply)
+		}
+	}
+}
+
+func BenchmarkMethodType_WideValues(b *testing.B) {
+	var ws WideService
+	mType := newService(&ws).method["Echo"]
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			argv, replyv := mType.newArgv(), mType.newReplyv()
+			mType.recycle(argv, replyv)
+		}
+	})
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = reflect.New(mType.ArgType).Elem(), reflect.New(mType.ReplyType.Elem())
+		}
+	})
+}
+
+func BenchmarkServer_WideCalls(b *testing.B) {
+	server := NewServer()
+	var ws WideService
+	_ = server.Register(&ws)
+	lis, err := server.Listen("tcp", "127.0.0.1:0")
+	_assert(err == nil, "listen error: %v", err)
+	defer func() { _ = lis.Close() }()
+	go server.Accept(lis)
+	client, err := Dial("tcp", lis.Addr().String(), &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	args := filledWide()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			var reply Wide
+			if err := client.Call("WideService.Echo", args, &reply); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package tinyrpc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"tinyrpc/codec"
+)
+
+// A ServiceOption configures the methods of one service, see Register.
+// WithHandleTimeout and WithMaxBodySize are ServiceOptions too.
+type ServiceOption interface {
+	applyService(c *serviceConfig) error
+}
+
+// serviceConfig overrides settings of the server for the methods of one
+// service. Zero fields keep the settings of the server.
+type serviceConfig struct {
+	handleTimeout time.Duration   // replaces ServerConfig.HandleTimeout
+	maxBodySize   int64           // replaces ServerConfig.MaxBodySize
+	bodyCodec     codec.Type      // of the responses, see WithPreferredBodyCodec
+	excluded      map[string]bool // methods not registered, see WithoutMethods
+	bulk          bool            // see WithBulkResponses
+}
+
+// applyService makes WithHandleTimeout and WithMaxBodySize service
+// options. They replace the settings of the server for the service, and
+// may raise them: a listener's MaxBodySize still applies. The other
+// ServerOptions are not service options.
+func (o ServerOption) applyService(c *serviceConfig) error {
+	var sc ServerConfig
+	if err := o(&sc); err != nil {
+		return err
+	}
+	if sc.MaxConnections != 0 || sc.IdleTimeout != 0 || sc.Logger != nil ||
+		sc.Interceptors != nil || sc.Authenticate != nil {
+		return errors.New("rpc server: server option passed to Register")
+	}
+	if sc.HandleTimeout > 0 {
+		c.handleTimeout = sc.HandleTimeout
+	}
+	if sc.MaxBodySize > 0 {
+		c.maxBodySize = sc.MaxBodySize
+	}
+	return nil
+}
+
+type serviceOptionFunc func(c *serviceConfig) error
+
+func (f serviceOptionFunc) applyService(c *serviceConfig) error { return f(c) }
+
+// WithPreferredBodyCodec encodes the replies of the methods of the
+// service with t, one of codec.BodyCodecs, for the calls that didn't
+// choose a body codec with WithBodyCodec. It applies to the clients
+// that set Option.AllowBodyCodecs; the others get the codec of their
+// connection.
+func WithPreferredBodyCodec(t codec.Type) ServiceOption {
+	return serviceOptionFunc(func(c *serviceConfig) error {
+		if codec.BodyCodecs[t] == nil {
+			return fmt.Errorf("%w: %s", codec.ErrUnknownBodyCodec, t)
+		}
+		c.bodyCodec = t
+		return nil
+	})
+}
+
+// WithoutMethods leaves the methods called names out of the service,
+// e.g. methods promoted from an embedded type that are not to be
+// served, or promoted by two embedded types at once. Names that are not
+// methods of the receiver fail Register.
+func WithoutMethods(names ...string) ServiceOption {
+	return serviceOptionFunc(func(c *serviceConfig) error {
+		if c.excluded == nil {
+			c.excluded = make(map[string]bool, len(names))
+		}
+		for _, name := range names {
+			c.excluded[name] = true
+		}
+		return nil
+	})
+}
+
+// WithBulkResponses sends the responses of the methods of the service,
+// typically huge ones, on the bulk channel of the connections with
+// Option.BulkChannel, so that they don't delay the other responses.
+func WithBulkResponses() ServiceOption {
+	return serviceOptionFunc(func(c *serviceConfig) error {
+		c.bulk = true
+		return nil
+	})
+}
+
+// limitBody makes reading the next body fail, or warn, past the
+// MaxBodySize of svc or of the server, and of the listener of sc. svc is
+// nil for the raw handler.
+func (server *Server) limitBody(sc *serverConn, svc *service) {
+	if sc.stream == nil {
+		return
+	}
+	limits := server.currentLimits()
+	max := limits.MaxBodySize
+	if svc != nil && svc.config.maxBodySize > 0 {
+		max = svc.config.maxBodySize
+	}
+	sc.maxBody = minLimit(max, sc.listenerMaxBody)
+	sc.stream.limit(sc.maxBody, limits.WarnOnly.BodySize)
+}
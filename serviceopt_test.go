@@ -0,0 +1,45 @@
+package tinyrpc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+	"tinyrpc/codec"
+)
+
+func TestRegister_ServiceOptions(t *testing.T) {
+	server := NewServer(WithMaxBodySize(1 << 20))
+	_assert(server.Register(Blob{}) == nil, "failed to register Blob")
+	err := server.RegisterName("BlobStore", Blob{},
+		WithMaxBodySize(64<<20), WithPreferredBodyCodec(codec.JsonType), WithHandleTimeout(time.Minute))
+	_assert(err == nil, "failed to register BlobStore: %v", err)
+	addr := startServer(t, server).Addr().String()
+
+	rec := &bodyRecorder{}
+	opt := &Option{HeartbeatIdle: -1, AllowBodyCodecs: true, WrapCodec: func(cc codec.Codec) codec.Codec {
+		rec.Codec = cc
+		return rec
+	}}
+	client, err := Dial("tcp", addr, opt)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call("BlobStore.Len", make([]byte, 10<<20), &reply)
+	_assert(err == nil && reply == 10<<20, "expect a 10MB body accepted by BlobStore, but got %d, %v", reply, err)
+	rec.mu.Lock()
+	_assert(reflect.DeepEqual(rec.reads, []codec.Type{codec.JsonType}), "expect a json reply, but got %v", rec.reads)
+	rec.mu.Unlock()
+	err = client.Call("Blob.Len", make([]byte, 10<<20), &reply)
+	_assert(errors.Is(err, ErrBodyTooLarge), "expect ErrBodyTooLarge for Blob, but got %v", err)
+}
+
+func TestRegister_InvalidServiceOptions(t *testing.T) {
+	server := NewServer()
+	err := server.Register(Blob{}, WithMaxConnections(1))
+	_assert(err != nil, "expect a server-wide option to fail")
+	err = server.Register(Blob{}, WithPreferredBodyCodec("application/x-unknown"))
+	_assert(errors.Is(err, codec.ErrUnknownBodyCodec), "expect ErrUnknownBodyCodec, but got %v", err)
+	_assert(server.Register(Blob{}) == nil, "expect a failed Register to publish nothing")
+}
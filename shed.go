@@ -0,0 +1,20 @@
+package tinyrpc
+
+// WithMaxOutstanding caps the calls a client has sent and waits for the
+// response of at n, 0 for no cap. Calls over the cap fail at once with
+// ErrClientOverloaded instead of queueing behind the goroutine reading
+// the responses, so that a client falling behind doesn't pile them up.
+// Calls to the built-in services, such as heartbeat pings, are not capped.
+func WithMaxOutstanding(n int) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) { c.maxOutstanding = n })
+}
+
+// WithDecodeWorkers makes a client decode the replies framed on their own,
+// such as those in a body codec (see WithBodyCodec), on up to n goroutines
+// besides the one reading the responses, so that a huge reply doesn't
+// delay the responses of the other calls. Replies in the codec of the
+// connection are decoded as they are read if it doesn't frame them, as
+// gob doesn't. 0 decodes them all as they are read.
+func WithDecodeWorkers(n int) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) { c.decodeWorkers = n })
+}
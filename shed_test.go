@@ -0,0 +1,100 @@
+package tinyrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+	"tinyrpc/codec"
+)
+
+// Gate holds its calls until it is closed.
+type Gate chan struct{}
+
+func (g Gate) Wait(args int, reply *int) error {
+	<-g
+	*reply = args
+	return nil
+}
+
+func TestClient_MaxOutstanding(t *testing.T) {
+	server := NewServer()
+	gate := make(Gate)
+	_ = server.Register(gate)
+	addr := startServer(t, server).Addr().String()
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1}, WithMaxOutstanding(3))
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	calls := make([]*Call, 3)
+	for i := range calls {
+		calls[i] = client.Go("Gate.Wait", i, new(int), nil)
+	}
+	for i := 0; i < 10; i++ {
+		start := time.Now()
+		var reply int
+		err := client.Call("Gate.Wait", 0, &reply)
+		_assert(errors.Is(err, ErrClientOverloaded), "expect ErrClientOverloaded, but got %v", err)
+		_assert(time.Since(start) < 50*time.Millisecond, "expect a fast failure, but took %s", time.Since(start))
+	}
+	stats := client.Stats()
+	_assert(stats.Outstanding == 3 && stats.Overloaded == 10, "expect 3 outstanding and 10 overloaded, but got %+v", stats)
+	_assert(stats.TransportErrors == 0, "expect the overloaded calls not counted as transport errors")
+	var reply int
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(errors.Is(err, ErrClientOverloaded), "expect every method capped, but got %v", err)
+
+	close(gate)
+	for i, call := range calls {
+		call = <-call.Done
+		_assert(call.Error == nil && *call.Reply.(*int) == i, "call %d: %v", i, call.Error)
+	}
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect calls again under the cap, but got %v", err)
+	_assert(client.Stats().Outstanding == 0, "expect no call outstanding, but got %d", client.Stats().Outstanding)
+}
+
+// gatedInt decodes from JSON once its gate is closed.
+type gatedInt struct {
+	gate chan struct{}
+	n    int
+}
+
+func (g *gatedInt) UnmarshalJSON(data []byte) error {
+	<-g.gate
+	return json.Unmarshal(data, &g.n)
+}
+
+func TestClient_DecodeWorkers(t *testing.T) {
+	addr := startServer(t, NewServer()).Addr().String()
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1, AllowBodyCodecs: true}, WithDecodeWorkers(2))
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	slow := &gatedInt{gate: make(chan struct{})}
+	call := client.Go("Foo.Sum", Args{Num1: 1, Num2: 2}, slow, nil, WithBodyCodec(codec.JsonType))
+	time.Sleep(20 * time.Millisecond) // let its response be read first
+	done := make(chan error, 1)
+	go func() {
+		var reply int
+		done <- client.Call("Foo.Sum", Args{Num1: 2, Num2: 3}, &reply, WithBodyCodec(codec.JsonType))
+	}()
+	select {
+	case err := <-done:
+		_assert(err == nil, "call error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("expect the call not to wait for the reply being decoded")
+	}
+	_assert(client.Stats().Outstanding == 0, "expect the decoded call not outstanding anymore")
+	close(slow.gate)
+	call = <-call.Done
+	_assert(call.Error == nil && slow.n == 3, "expect 3, but got %d, %v", slow.n, call.Error)
+
+	// a reply that doesn't decode fails its call only
+	var s string
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &s, WithBodyCodec(codec.JsonType))
+	_assert(err != nil, "expect an error decoding an int into a string")
+	var reply int
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect the connection to survive, but got %v", err)
+}
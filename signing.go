@@ -0,0 +1,160 @@
+package tinyrpc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+	"tinyrpc/internal/clock"
+)
+
+// The metadata of a signed request, see WithRequestSigning.
+const (
+	SignatureKeyHeader  = "sig-key" // the ID of the key
+	SignatureTimeHeader = "sig-ts"  // when the client signed, in unix milliseconds
+	SignatureHeader     = "sig"     // the HMAC-SHA256, in hex
+)
+
+// DefaultSignatureSkew is how far from the server clock a signature may
+// be dated.
+const DefaultSignatureSkew = 30 * time.Second
+
+// requestSigner signs the requests of a client.
+type requestSigner struct {
+	keyID string
+	key   []byte
+}
+
+// WithRequestSigning makes the client sign every request with key, an
+// HMAC-SHA256 of its method, seq, arguments and time sent in its
+// metadata along with keyID, for VerifySignatures. The arguments are
+// signed in their JSON encoding, so they must encode the same once
+// decoded by the server. Calls to the built-in services are not signed.
+func WithRequestSigning(keyID string, key []byte) ClientOption {
+	return clientOptionFunc(func(c *clientConfig) {
+		c.signer = &requestSigner{keyID: keyID, key: key}
+	})
+}
+
+// signature returns the HMAC of a request.
+func signature(key []byte, serviceMethod string, seq uint64, ts string, args interface{}) (string, error) {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return "", fmt.Errorf("rpc: signing arguments: %w", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%d\n%s\n", serviceMethod, seq, ts)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// sign returns md with the signature of the request added.
+func (s *requestSigner) sign(serviceMethod string, seq uint64, args interface{}, md Metadata, now time.Time) (Metadata, error) {
+	ts := strconv.FormatInt(now.UnixMilli(), 10)
+	sig, err := signature(s.key, serviceMethod, seq, ts, args)
+	if err != nil {
+		return nil, err
+	}
+	signed := make(Metadata, len(md)+3)
+	for k, v := range md {
+		signed[k] = v
+	}
+	signed[SignatureKeyHeader], signed[SignatureTimeHeader], signed[SignatureHeader] = s.keyID, ts, sig
+	return signed, nil
+}
+
+// SignatureConfig configures VerifySignatures.
+type SignatureConfig struct {
+	// Key returns the key with keyID, or an error if there is none, so
+	// that keys can be added and retired while the server runs.
+	Key func(keyID string) ([]byte, error)
+	// Skew is how far from the server clock a signature may be dated,
+	// DefaultSignatureSkew if 0.
+	Skew  time.Duration
+	Clock Clock // the real clock if nil
+}
+
+// VerifySignatures returns a server interceptor failing the calls not
+// signed with WithRequestSigning by a key of cfg.Key with
+// ErrPermissionDenied, as well as the calls signed too far from now and
+// the signatures seen already, i.e. replayed requests. The handlers of
+// the calls verified get the key ID with SignatureKeyFromContext.
+func VerifySignatures(cfg SignatureConfig) Interceptor {
+	skew := cfg.Skew
+	if skew <= 0 {
+		skew = DefaultSignatureSkew
+	}
+	c := clock.Or(cfg.Clock)
+	seen := &seenSignatures{sigs: make(map[string]time.Time)}
+	return func(ctx context.Context, serviceMethod string, args interface{}, next func(ctx context.Context) error) error {
+		md := HeaderFromContext(ctx)
+		keyID, ts, sig := md[SignatureKeyHeader], md[SignatureTimeHeader], md[SignatureHeader]
+		if sig == "" {
+			return fmt.Errorf("%w: unsigned request", ErrPermissionDenied)
+		}
+		key, err := cfg.Key(keyID)
+		if err != nil {
+			return fmt.Errorf("%w: signing key %q: %v", ErrPermissionDenied, keyID, err)
+		}
+		ms, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: invalid signature time %q", ErrPermissionDenied, ts)
+		}
+		now, signed := c.Now(), time.UnixMilli(ms)
+		if signed.Before(now.Add(-skew)) || signed.After(now.Add(skew)) {
+			return fmt.Errorf("%w: signature dated %s, %s from now", ErrPermissionDenied, signed.UTC().Format(time.RFC3339), signed.Sub(now))
+		}
+		want, err := signature(key, serviceMethod, seqFromContext(ctx), ts, args)
+		if err != nil {
+			return err
+		}
+		if !hmac.Equal([]byte(sig), []byte(want)) {
+			return fmt.Errorf("%w: invalid signature", ErrPermissionDenied)
+		}
+		if !seen.add(sig, signed.Add(skew), now) {
+			return fmt.Errorf("%w: replayed signature", ErrPermissionDenied)
+		}
+		return next(context.WithValue(ctx, signatureKey{}, keyID))
+	}
+}
+
+type signatureKey struct{}
+
+// SignatureKeyFromContext returns the ID of the key that signed the call
+// being handled in ctx, verified by VerifySignatures, "" if none.
+func SignatureKeyFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(signatureKey{}).(string)
+	return id
+}
+
+// seenSignatures are the signatures verified, until they expire with
+// their skew window.
+type seenSignatures struct {
+	mu        sync.Mutex // protect following
+	sigs      map[string]time.Time
+	lastSweep time.Time
+}
+
+// add records sig, valid until expiry, and reports whether it is new.
+func (s *seenSignatures) add(sig string, expiry, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) >= time.Second {
+		s.lastSweep = now
+		for k, e := range s.sigs {
+			if now.After(e) {
+				delete(s.sigs, k)
+			}
+		}
+	}
+	if _, ok := s.sigs[sig]; ok {
+		return false
+	}
+	s.sigs[sig] = expiry
+	return true
+}
@@ -0,0 +1,116 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"tinyrpc/codec"
+	"tinyrpc/tinyrpctest"
+)
+
+// tamperingCodec changes the arguments of the requests to Foo.Sum after
+// they are signed, and writes them twice if replay is set.
+type tamperingCodec struct {
+	codec.Codec
+	tamper, replay bool
+}
+
+func (c *tamperingCodec) Write(h *codec.Header, body interface{}) error {
+	if h.ServiceMethod == "Foo.Sum" && c.tamper {
+		body = Args{Num1: 100, Num2: 2}
+	}
+	if err := c.Codec.Write(h, body); err != nil || h.ServiceMethod != "Foo.Sum" || !c.replay {
+		return err
+	}
+	return c.Codec.Write(h, body)
+}
+
+func TestVerifySignatures(t *testing.T) {
+	keys := map[string][]byte{"k1": []byte("secret-1"), "k2": []byte("secret-2")}
+	clock := tinyrpctest.NewClock()
+	var mu sync.Mutex
+	var errs []error
+	var keyIDs []string
+	record := func(ctx context.Context, serviceMethod string, args interface{}, next func(ctx context.Context) error) error {
+		err := next(ctx)
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+		return err
+	}
+	verify := VerifySignatures(SignatureConfig{
+		Key: func(keyID string) ([]byte, error) {
+			if key, ok := keys[keyID]; ok {
+				return key, nil
+			}
+			return nil, fmt.Errorf("unknown key")
+		},
+		Clock: clock,
+	})
+	keyID := func(ctx context.Context, serviceMethod string, args interface{}, next func(ctx context.Context) error) error {
+		mu.Lock()
+		keyIDs = append(keyIDs, SignatureKeyFromContext(ctx))
+		mu.Unlock()
+		return next(ctx)
+	}
+	addr := startServer(t, NewServer(WithInterceptors(record, verify, keyID))).Addr().String()
+	dial := func(cc *tamperingCodec, opts ...ClientOption) *Client {
+		opt := &Option{HeartbeatIdle: -1, Clock: clock}
+		if cc != nil {
+			opt.WrapCodec = func(c codec.Codec) codec.Codec {
+				cc.Codec = c
+				return cc
+			}
+		}
+		client, err := Dial("tcp", addr, append(opts, opt)...)
+		_assert(err == nil, "dial error: %v", err)
+		t.Cleanup(func() { _ = client.Close() })
+		return client
+	}
+
+	var reply int
+	err := dial(nil, WithRequestSigning("k2", keys["k2"])).Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect a signed call to succeed, but got %d, %v", reply, err)
+	mu.Lock()
+	_assert(len(keyIDs) == 1 && keyIDs[0] == "k2", "expect the handler to get key k2, but got %v", keyIDs)
+	mu.Unlock()
+
+	for name, client := range map[string]*Client{
+		"unsigned":    dial(nil),
+		"unknown key": dial(nil, WithRequestSigning("k3", []byte("secret-3"))),
+		"wrong key":   dial(nil, WithRequestSigning("k1", keys["k2"])),
+		"tampered":    dial(&tamperingCodec{tamper: true}, WithRequestSigning("k1", keys["k1"])),
+	} {
+		err := client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(errors.Is(err, ErrPermissionDenied), "expect %s call denied, but got %v", name, err)
+	}
+
+	mu.Lock()
+	errs = nil
+	mu.Unlock()
+	err = dial(&tamperingCodec{replay: true}, WithRequestSigning("k1", keys["k1"])).Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil, "expect the original call to succeed, but got %v", err)
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+		mu.Lock()
+		n := len(errs)
+		mu.Unlock()
+		if n == 2 || time.Now().After(deadline) {
+			break
+		}
+	}
+	mu.Lock()
+	_assert(len(errs) == 2 && errs[0] == nil && errors.Is(errs[1], ErrPermissionDenied) && strings.Contains(errs[1].Error(), "replayed"),
+		"expect the replayed request denied, but got %v", errs)
+	mu.Unlock()
+
+	stale, err := Dial("tcp", addr, WithRequestSigning("k1", keys["k1"]), &Option{HeartbeatIdle: -1, Clock: tinyrpctest.NewClock()})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = stale.Close() }()
+	clock.Advance(time.Hour)
+	err = stale.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(errors.Is(err, ErrPermissionDenied) && strings.Contains(err.Error(), "from now"), "expect a stale signature denied, but got %v", err)
+}
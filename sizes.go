@@ -0,0 +1,115 @@
+package tinyrpc
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"tinyrpc/codec"
+	"tinyrpc/rpclog"
+)
+
+// SizeHistogram counts encoded body sizes: Counts[i] bodies took at most
+// Bounds[i] bytes, and the last count is of the larger ones. Max is the
+// largest size seen.
+type SizeHistogram struct {
+	Bounds []int64
+	Counts []uint64
+	Max    int64
+}
+
+// MethodSizes are the body sizes of the calls to one method, measured on
+// the connections whose codec is a codec.SizeReporter.
+type MethodSizes struct {
+	Requests  SizeHistogram
+	Responses SizeHistogram // of the successful calls
+	// Rejected counts the requests failing with ErrBodyTooLarge, which
+	// are not in Requests.
+	Rejected uint64
+}
+
+var sizeBounds = []int64{64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20}
+
+// sizeHistogram is a SizeHistogram updated atomically.
+type sizeHistogram struct {
+	counts [11]uint64 // len(sizeBounds) + 1
+	max    int64
+}
+
+func (h *sizeHistogram) observe(n int64) {
+	i := sort.Search(len(sizeBounds), func(i int) bool { return n <= sizeBounds[i] })
+	atomic.AddUint64(&h.counts[i], 1)
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if n <= max || atomic.CompareAndSwapInt64(&h.max, max, n) {
+			return
+		}
+	}
+}
+
+func (h *sizeHistogram) snapshot() SizeHistogram {
+	s := SizeHistogram{Bounds: sizeBounds, Counts: make([]uint64, len(h.counts)), Max: atomic.LoadInt64(&h.max)}
+	for i := range h.counts {
+		s.Counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+	return s
+}
+
+type methodSizes struct {
+	requests, responses sizeHistogram
+	rejected            uint64 // accessed atomically
+}
+
+// sizeStats are the MethodSizes of the registered methods.
+type sizeStats struct {
+	methods sync.Map // service method -> *methodSizes
+}
+
+func (s *sizeStats) of(serviceMethod string) *methodSizes {
+	if m, ok := s.methods.Load(serviceMethod); ok {
+		return m.(*methodSizes)
+	}
+	m, _ := s.methods.LoadOrStore(serviceMethod, new(methodSizes))
+	return m.(*methodSizes)
+}
+
+func (s *sizeStats) snapshot() map[string]MethodSizes {
+	var sizes map[string]MethodSizes
+	s.methods.Range(func(k, v interface{}) bool {
+		if sizes == nil {
+			sizes = make(map[string]MethodSizes)
+		}
+		m := v.(*methodSizes)
+		sizes[k.(string)] = MethodSizes{
+			Requests:  m.requests.snapshot(),
+			Responses: m.responses.snapshot(),
+			Rejected:  atomic.LoadUint64(&m.rejected),
+		}
+		return true
+	})
+	return sizes
+}
+
+// countRequestSize records the size of the body of req just read, if cc
+// reports it. The built-in services are not counted.
+func (server *Server) countRequestSize(cc codec.Codec, req *request) {
+	if sr, ok := cc.(codec.SizeReporter); ok && !strings.HasPrefix(req.h.ServiceMethod, BuiltinPrefix) {
+		server.sizes.of(req.h.ServiceMethod).requests.observe(sr.ReadBodySize())
+	}
+}
+
+// writeResponse sends the response of req, recording its size if cc
+// reports it and the call succeeded.
+func (server *Server) writeResponse(cc codec.Codec, req *request, body interface{}) error {
+	sr, ok := cc.(codec.SizeReporter)
+	if !ok || req.svc == nil || req.h.Error != "" || strings.HasPrefix(req.h.ServiceMethod, BuiltinPrefix) {
+		return server.sendResponse(cc, req.h, body)
+	}
+	n, err := sr.WriteSized(req.h, body)
+	if err != nil {
+		server.log(rpclog.LevelError, "write response error", "err", err)
+		return err
+	}
+	server.sizes.of(req.h.ServiceMethod).responses.observe(n)
+	return nil
+}
@@ -0,0 +1,146 @@
+package tinyrpc
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"tinyrpc/rpclog"
+)
+
+// SocketOptions tunes the sockets used by Server and Client.
+// The TCP settings are applied only when the connection is a *net.TCPConn,
+// other connections are left untouched. UnixMode applies to unix
+// socket files created by Server.Listen.
+type SocketOptions struct {
+	TCPDelay    bool          // enable Nagle's algorithm, TCP_NODELAY is set unless true
+	KeepAlive   time.Duration // TCP keepalive period, 0 means Go's default of 15s, negative disables it
+	ReadBuffer  int           // SO_RCVBUF in bytes, 0 keeps the OS default
+	WriteBuffer int           // SO_SNDBUF in bytes, 0 keeps the OS default
+	UnixMode    os.FileMode   // permissions of unix socket files created by Listen, 0 keeps the umask default
+	// Control is passed to net.ListenConfig and net.Dialer, so that raw
+	// options such as SO_REUSEPORT can be set before bind or connect.
+	Control func(network, address string, c syscall.RawConn) error
+}
+
+// DefaultSocketOptions is used when no SocketOptions are given.
+var DefaultSocketOptions = &SocketOptions{}
+
+func (o *SocketOptions) apply(conn net.Conn) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if err := tcp.SetNoDelay(!o.TCPDelay); err != nil {
+		rpclog.Warn("socket", "set TCP_NODELAY error", "err", err)
+	}
+	if o.KeepAlive != 0 {
+		if err := tcp.SetKeepAlive(o.KeepAlive > 0); err != nil {
+			rpclog.Warn("socket", "set keepalive error", "err", err)
+		}
+		if o.KeepAlive > 0 {
+			if err := tcp.SetKeepAlivePeriod(o.KeepAlive); err != nil {
+				rpclog.Warn("socket", "set keepalive period error", "err", err)
+			}
+		}
+	}
+	if o.ReadBuffer > 0 {
+		if err := tcp.SetReadBuffer(o.ReadBuffer); err != nil {
+			rpclog.Warn("socket", "set read buffer error", "err", err)
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := tcp.SetWriteBuffer(o.WriteBuffer); err != nil {
+			rpclog.Warn("socket", "set write buffer error", "err", err)
+		}
+	}
+}
+
+func (o *SocketOptions) listen(network, address string) (net.Listener, error) {
+	if network == "unix" {
+		removeStaleSocket(address)
+	}
+	lc := net.ListenConfig{Control: o.Control, KeepAlive: o.KeepAlive}
+	if network == "unix" && o.UnixMode != 0 {
+		return o.listenUnix(lc, address)
+	}
+	return lc.Listen(context.Background(), network, address)
+}
+
+// listenUnix creates the socket inside a private 0700 directory, sets
+// UnixMode there and only then moves it to address, so it never accepts
+// connections with the umask default permissions.
+func (o *SocketOptions) listenUnix(lc net.ListenConfig, address string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(address), ".tinyrpc-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	tmp := filepath.Join(dir, "s")
+	lis, err := lc.Listen(context.Background(), "unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(tmp, o.UnixMode); err == nil {
+		err = os.Rename(tmp, address)
+	}
+	if err != nil {
+		_ = lis.Close()
+		return nil, err
+	}
+	// the listener would unlink tmp, remove the renamed file instead
+	lis.(*net.UnixListener).SetUnlinkOnClose(false)
+	return &unixListener{Listener: lis, path: address}, nil
+}
+
+// unixListener removes its socket file on Close, unless handed off to
+// another process with Server.ListenerFile.
+type unixListener struct {
+	net.Listener
+	path      string
+	handedOff bool
+}
+
+func (l *unixListener) Close() error {
+	err := l.Listener.Close()
+	if !l.handedOff {
+		_ = os.Remove(l.path)
+	}
+	return err
+}
+
+// removeStaleSocket removes the socket file left at path by a previous
+// process, unless someone is still listening on it.
+func removeStaleSocket(path string) {
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		_ = conn.Close()
+		return
+	}
+	if err = os.Remove(path); err != nil {
+		rpclog.Warn("socket", "remove stale socket error", "err", err)
+	}
+}
+
+// parseAddr splits an rpc address into network and address. It accepts
+// "unix:///path/to.sock", "protocol@addr" such as "unix@/path/to.sock" or
+// "tcp@host:port", and a bare "host:port" which means tcp.
+func parseAddr(rpcAddr string) (network, address string) {
+	if strings.HasPrefix(rpcAddr, "unix://") {
+		return "unix", strings.TrimPrefix(rpcAddr, "unix://")
+	}
+	if i := strings.Index(rpcAddr, "@"); i > 0 {
+		return rpcAddr[:i], rpcAddr[i+1:]
+	}
+	return "tcp", rpcAddr
+}
+
+func (o *SocketOptions) dialer() *net.Dialer {
+	return &net.Dialer{Control: o.Control, KeepAlive: o.KeepAlive}
+}
@@ -0,0 +1,102 @@
+package tinyrpc
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// recordDialer hands out the dialed conns so the test can inspect them.
+type recordDialer struct {
+	*net.Dialer
+	conns chan net.Conn
+}
+
+func (d *recordDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.Dialer.DialContext(ctx, network, address)
+	if err == nil {
+		d.conns <- conn
+	}
+	return conn, err
+}
+
+func getsockopt(t *testing.T, conn net.Conn, level, opt int) int {
+	t.Helper()
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal("syscall conn error:", err)
+	}
+	var v int
+	var serr error
+	err = raw.Control(func(fd uintptr) { v, serr = syscall.GetsockoptInt(int(fd), level, opt) })
+	if err != nil || serr != nil {
+		t.Fatal("getsockopt error:", err, serr)
+	}
+	return v
+}
+
+func assertSocketOptions(t *testing.T, side string, conn net.Conn, nodelay, keepalive int, buffer int) {
+	t.Helper()
+	got := getsockopt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	_assert(got == nodelay, "%s: expect TCP_NODELAY %d, but got %d", side, nodelay, got)
+	got = getsockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+	_assert(got == keepalive, "%s: expect SO_KEEPALIVE %d, but got %d", side, keepalive, got)
+	if buffer > 0 {
+		// Linux doubles the requested size to leave room for bookkeeping
+		got = getsockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		_assert(got >= buffer, "%s: expect SO_RCVBUF >= %d, but got %d", side, buffer, got)
+		got = getsockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+		_assert(got >= buffer, "%s: expect SO_SNDBUF >= %d, but got %d", side, buffer, got)
+	}
+}
+
+// dialInspect serves Foo with opt on both sides, makes one call
+// and returns the dialed and accepted conns.
+func dialInspect(t *testing.T, opt *SocketOptions) (dialed, accepted net.Conn) {
+	t.Helper()
+	server := NewServer()
+	server.SetSocketOptions(opt)
+	accepts := make(chan net.Conn, 1)
+	server.WrapConn(func(conn net.Conn) net.Conn {
+		accepts <- conn
+		return conn
+	})
+	lis := startServer(t, server)
+
+	d := &recordDialer{Dialer: opt.dialer(), conns: make(chan net.Conn, 1)}
+	client, err := Dial("tcp", lis.Addr().String(), &Option{Socket: opt, Dialer: d})
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	var reply int
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+	return <-d.conns, <-accepts
+}
+
+func TestSocketOptions(t *testing.T) {
+	var controls int32
+	opt := &SocketOptions{
+		KeepAlive:   30 * time.Second,
+		ReadBuffer:  64 << 10,
+		WriteBuffer: 64 << 10,
+		Control: func(network, address string, c syscall.RawConn) error {
+			atomic.AddInt32(&controls, 1)
+			return nil
+		},
+	}
+	dialed, accepted := dialInspect(t, opt)
+	assertSocketOptions(t, "dialed", dialed, 1, 1, opt.ReadBuffer)
+	assertSocketOptions(t, "accepted", accepted, 1, 1, opt.ReadBuffer)
+	_assert(atomic.LoadInt32(&controls) == 2, "expect Control on listen and dial, but got %d", controls)
+}
+
+func TestSocketOptions_Disable(t *testing.T) {
+	dialed, accepted := dialInspect(t, &SocketOptions{TCPDelay: true, KeepAlive: -1})
+	assertSocketOptions(t, "dialed", dialed, 0, 0, 0)
+	assertSocketOptions(t, "accepted", accepted, 0, 0, 0)
+}
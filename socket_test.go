@@ -0,0 +1,74 @@
+package tinyrpc
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseAddr(t *testing.T) {
+	cases := []struct{ in, network, address string }{
+		{"unix:///var/run/app.sock", "unix", "/var/run/app.sock"},
+		{"unix@/var/run/app.sock", "unix", "/var/run/app.sock"},
+		{"tcp@127.0.0.1:9999", "tcp", "127.0.0.1:9999"},
+		{"127.0.0.1:9999", "tcp", "127.0.0.1:9999"},
+	}
+	for _, c := range cases {
+		network, address := parseAddr(c.in)
+		_assert(network == c.network && address == c.address, "parseAddr(%q) = %s, %s", c.in, network, address)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rpc.sock")
+	// leave a stale socket file behind, as a crashed process would
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	server.SetSocketOptions(&SocketOptions{UnixMode: 0600})
+	lis, err := server.Listen("unix", path)
+	if err != nil {
+		t.Fatal("listen over stale socket error:", err)
+	}
+	go server.Accept(lis)
+	defer func() { _ = lis.Close() }()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal("stat socket error:", err)
+	}
+	_assert(fi.Mode().Perm() == 0600, "wrong socket permissions: %v", fi.Mode())
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	_assert(len(entries) == 1, "expect the private directory to be removed, but got %d entries", len(entries))
+
+	client, err := XDial("unix://" + path)
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+
+	_ = lis.Close()
+	_, err = os.Stat(path)
+	_assert(os.IsNotExist(err), "expect Close to remove the socket file, but got %v", err)
+}
+
+func TestListenAndServe_Error(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	defer func() { _ = lis.Close() }()
+	err = NewServer().ListenAndServe("tcp@" + lis.Addr().String())
+	_assert(err != nil, "expect an error for an address in use")
+}
@@ -0,0 +1,119 @@
+package tinyrpc
+
+import (
+	"fmt"
+	"sync/atomic"
+	"tinyrpc/codec"
+	"tinyrpc/rpclog"
+)
+
+// WarnOnly flags the limits a server only warns about, e.g. while a new
+// one is rolled out: a request over them is counted in
+// ServerStats.LimitWarnings and logged with its peer and method, but
+// served. Clearing a flag, e.g. with UpdateLimits once the warnings show
+// who would break, enforces the limit.
+type WarnOnly struct {
+	BodySize bool // MaxBodySize, of the server, its services and its listeners
+	QPS      bool // IPLimits.QPS
+	Metadata bool // StrictChecks.MaxMetadataKeys and MaxMetadataBytes
+	InFlight bool // MaxInFlight
+}
+
+// WithWarnOnly sets ServerConfig.WarnOnly.
+func WithWarnOnly(w WarnOnly) ServerOption {
+	return func(c *ServerConfig) error {
+		c.WarnOnly = w
+		return nil
+	}
+}
+
+// LimitWarnings count the requests served over a limit in WarnOnly mode,
+// by limit.
+type LimitWarnings struct {
+	BodySize uint64
+	QPS      uint64
+	Metadata uint64
+	InFlight uint64
+}
+
+// verdict is the outcome of checking a request against a limit.
+type verdict int
+
+const (
+	verdictAllow verdict = iota // within the limit
+	verdictWarn                 // over a limit only warned about, served
+	verdictDeny                 // over a limit enforced, rejected
+)
+
+// judge returns the verdict of a request over a limit or not, the limit
+// being only warned about or not.
+func judge(over, warnOnly bool) verdict {
+	switch {
+	case !over:
+		return verdictAllow
+	case warnOnly:
+		return verdictWarn
+	}
+	return verdictDeny
+}
+
+type limitKind int
+
+const (
+	limitBodySize limitKind = iota
+	limitQPS
+	limitMetadata
+	limitInFlight
+	numLimits
+)
+
+var limitNames = [numLimits]string{"body_size", "qps", "metadata", "in_flight"}
+
+// warnLimit counts a request of sc to method served over the limit k,
+// and logs it with kv.
+func (server *Server) warnLimit(sc *serverConn, k limitKind, method string, kv ...interface{}) {
+	atomic.AddUint64(&server.limitWarnings[k], 1)
+	kv = append([]interface{}{"limit", limitNames[k], "peer", remoteAddr(sc.metered.ReadWriteCloser), "method", method}, kv...)
+	server.log(rpclog.LevelWarn, "request over a limit, served in warn mode", kv...)
+}
+
+func (server *Server) limitWarningStats() LimitWarnings {
+	return LimitWarnings{
+		BodySize: atomic.LoadUint64(&server.limitWarnings[limitBodySize]),
+		QPS:      atomic.LoadUint64(&server.limitWarnings[limitQPS]),
+		Metadata: atomic.LoadUint64(&server.limitWarnings[limitMetadata]),
+		InFlight: atomic.LoadUint64(&server.limitWarnings[limitInFlight]),
+	}
+}
+
+// admit returns the verdict of sc handling one more request, within max
+// of them at once, no limit if 0.
+func (sc *serverConn) admit(max int, warnOnly bool) verdict {
+	if max <= 0 {
+		return verdictAllow
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return judge(sc.inflight >= max, warnOnly)
+}
+
+// checkLimits returns the error of the request of h over the QPS of the
+// IP of sc or over the MaxInFlight of limits, and warns about those only
+// warned about.
+func (server *Server) checkLimits(sc *serverConn, h *codec.Header, limits *LimitsConfig) error {
+	if sc.ip != "" {
+		switch v, wait := server.ips.allow(sc.ip, limits.IP, limits.WarnOnly.QPS); v {
+		case verdictDeny:
+			return withRetryAfter(ErrRateLimited, wait)
+		case verdictWarn:
+			server.warnLimit(sc, limitQPS, h.ServiceMethod, "qps", limits.IP.QPS)
+		}
+	}
+	switch sc.admit(limits.MaxInFlight, limits.WarnOnly.InFlight) {
+	case verdictDeny:
+		return fmt.Errorf("%w: %d requests of the connection in flight", ErrResourceExhausted, limits.MaxInFlight)
+	case verdictWarn:
+		server.warnLimit(sc, limitInFlight, h.ServiceMethod, "max", limits.MaxInFlight)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package tinyrpc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"tinyrpc/rpclog"
+)
+
+// warnLogger keeps the limit, peer and method of the warnings logged.
+type warnLogger struct {
+	mu       sync.Mutex
+	warnings []string
+}
+
+func (l *warnLogger) Log(level rpclog.Level, subsystem, msg string, kv ...interface{}) {
+	if msg != "request over a limit, served in warn mode" {
+		return
+	}
+	fields := make(map[interface{}]interface{})
+	for i := 0; i+1 < len(kv); i += 2 {
+		fields[kv[i]] = kv[i+1]
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warnings = append(l.warnings, fmt.Sprintf("%v %v %v", fields["limit"], fields["peer"] != "", fields["method"]))
+}
+
+func (l *warnLogger) count(warning string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, w := range l.warnings {
+		if w == warning {
+			n++
+		}
+	}
+	return n
+}
+
+// sleeper blocks its calls until release is closed.
+type sleeper struct{ release chan struct{} }
+
+func (s sleeper) Wait(n int, reply *int) error {
+	<-s.release
+	return nil
+}
+
+func TestServer_WarnOnly(t *testing.T) {
+	logger := &warnLogger{}
+	warnOnly := WarnOnly{BodySize: true, QPS: true, Metadata: true, InFlight: true}
+	server := NewServer(WithLogger(logger), WithMaxBodySize(256), WithMaxInFlight(1), WithWarnOnly(warnOnly),
+		WithStrictProtocol(StrictChecks{MaxMetadataKeys: 1}))
+	server.SetIPLimits(IPLimits{QPS: 0.001, Burst: 4})
+	s := sleeper{release: make(chan struct{})}
+	_ = server.Register(Blob{})
+	_ = server.Register(s)
+	addr := startServer(t, server).Addr().String()
+	client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	sum := func(opts ...CallOption) error {
+		var reply int
+		return client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply, opts...)
+	}
+	update := func(f func(l *LimitsConfig)) {
+		limits := server.Limits()
+		f(&limits)
+		_assert(server.UpdateLimits(limits) == nil, "failed to update the limits")
+	}
+
+	// served over each limit, while warned about
+	var n int
+	_assert(client.Call("Blob.Len", make([]byte, 1000), &n) == nil && n == 1000, "expect a body over the limit served")
+	_assert(sum(WithHeader("a", "1"), WithHeader("b", "2")) == nil, "expect metadata over the limit served")
+	wait := client.Go("sleeper.Wait", 0, new(int), make(chan *Call, 1))
+	waitFor(t, func() bool { return server.Stats().InFlight == 1 }, "expect a call in flight")
+	_assert(sum() == nil, "expect a call over the in-flight limit served")
+	_assert(sum() == nil, "expect a call over the rate limit served")
+	w := server.Stats().LimitWarnings
+	_assert(w == LimitWarnings{BodySize: 1, Metadata: 1, InFlight: 2, QPS: 1}, "expect the violations counted, but got %+v", w)
+	_assert(logger.count("body_size true Blob.Len") == 1 && logger.count("in_flight true Foo.Sum") == 2,
+		"expect the violations logged with the peer and method, but got %q", logger.warnings)
+	_assert(server.Stats().ProtocolAnomalies.OversizedMetadata == 0, "expect no anomaly in warn mode")
+
+	// rejected once enforced
+	update(func(l *LimitsConfig) { l.WarnOnly.QPS = false })
+	_assert(errors.Is(sum(), ErrRateLimited), "expect the rate limit enforced")
+	update(func(l *LimitsConfig) { l.WarnOnly.InFlight, l.IP.QPS = false, 0 })
+	err = sum()
+	_assert(errors.Is(err, ErrResourceExhausted), "expect the in-flight limit enforced, but got %v", err)
+	close(s.release)
+	<-wait.Done
+	update(func(l *LimitsConfig) { l.WarnOnly.Metadata = false })
+	err = sum(WithHeader("a", "1"), WithHeader("b", "2"))
+	_assert(errors.Is(err, ErrProtocolViolation), "expect the metadata limit enforced, but got %v", err)
+
+	client, err = Dial("tcp", addr, &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	update(func(l *LimitsConfig) { l.WarnOnly.BodySize = false })
+	err = client.Call("Blob.Len", make([]byte, 1000), &n)
+	_assert(errors.Is(err, ErrBodyTooLarge), "expect the body limit enforced, but got %v", err)
+	w = server.Stats().LimitWarnings
+	_assert(w == LimitWarnings{BodySize: 1, Metadata: 1, InFlight: 2, QPS: 1}, "expect enforced violations not counted as warnings, but got %+v", w)
+}
@@ -0,0 +1,240 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"io"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+	"tinyrpc/codec"
+)
+
+// ServerStats is a snapshot of the server counters.
+type ServerStats struct {
+	Connections  int    // connections being served
+	BytesRead    uint64 // read from all connections, including closed ones
+	BytesWritten uint64 // written to all connections, including closed ones
+	Requests     uint64 // requests received since the server started
+	InFlight     int64  // requests being handled
+	Invalid      uint64 // requests rejected by argument validation
+	NotFound     uint64 // requests for methods that are not registered
+	// HandshakeOnly counts the connections closed for sending no request
+	// within the first-request timeout.
+	HandshakeOnly uint64
+	SlowDropped   uint64 // connections closed by the ResponseLimits
+	// CorruptedStreams counts the connections closed for a corrupt
+	// stream: a frame failing its checksum, or a decode error after which
+	// the codec can't read on, see codec.ErrStreamCorrupt.
+	CorruptedStreams uint64
+	PushDropped      uint64 // published messages dropped for slow subscribers
+	Deduplicated     uint64 // retries answered by ServerConfig.AtMostOnce
+	// ProtocolAnomalies count the connections closed by
+	// ServerConfig.StrictProtocol.
+	ProtocolAnomalies ProtocolAnomalies
+	// LimitWarnings count the requests served over the limits only
+	// warned about, see ServerConfig.WarnOnly.
+	LimitWarnings LimitWarnings
+	// Sizes are the body sizes of the calls, by method. Nil until a
+	// connection reports sizes, see codec.SizeReporter.
+	Sizes map[string]MethodSizes
+	// Priorities are the counters of the workers, by priority name such
+	// as "high". Nil without ServerConfig.Workers.
+	Priorities map[string]PriorityStats
+}
+
+// ConnInfo describes one connection being served.
+type ConnInfo struct {
+	ID           uint64
+	RemoteAddr   string // empty if the conn has no address
+	ConnectedAt  time.Time
+	BytesRead    uint64
+	BytesWritten uint64
+	UnsentHigh   int        // most responses ready but not written at once
+	InFlight     int        // requests being handled
+	Codec        codec.Type // negotiated in the handshake, empty if served by ServeCodec
+	Version      int        // of the protocol, 0 if served by ServeCodec
+	// History holds the last frames of the connection, oldest first, see
+	// ServerConfig.FrameHistory. Only _admin_.DescribeConnection sets it.
+	History []Frame
+}
+
+// meteredConn counts the bytes moved through a connection,
+// both for the connection and for the whole server.
+type meteredConn struct {
+	io.ReadWriteCloser
+	server                *Server
+	bytesRead, bytesWrote uint64
+}
+
+func (c *meteredConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	atomic.AddUint64(&c.bytesRead, uint64(n))
+	atomic.AddUint64(&c.server.bytesRead, uint64(n))
+	return n, err
+}
+
+func (c *meteredConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	atomic.AddUint64(&c.bytesWrote, uint64(n))
+	atomic.AddUint64(&c.server.bytesWritten, uint64(n))
+	return n, err
+}
+
+func remoteAddr(conn interface{}) string {
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok && c.RemoteAddr() != nil {
+		return c.RemoteAddr().String()
+	}
+	return ""
+}
+
+// Stats returns a snapshot of the server counters.
+func (server *Server) Stats() ServerStats {
+	server.mu.Lock()
+	conns := len(server.conns)
+	server.mu.Unlock()
+	stats := ServerStats{
+		Connections:       conns,
+		BytesRead:         atomic.LoadUint64(&server.bytesRead),
+		BytesWritten:      atomic.LoadUint64(&server.bytesWritten),
+		Requests:          atomic.LoadUint64(&server.requests),
+		InFlight:          atomic.LoadInt64(&server.inflight),
+		Invalid:           atomic.LoadUint64(&server.invalid),
+		HandshakeOnly:     atomic.LoadUint64(&server.handshakeOnly),
+		NotFound:          atomic.LoadUint64(&server.notFound),
+		SlowDropped:       atomic.LoadUint64(&server.slowDropped),
+		CorruptedStreams:  atomic.LoadUint64(&server.corrupted),
+		PushDropped:       atomic.LoadUint64(&server.pubsub.dropped),
+		Sizes:             server.sizes.snapshot(),
+		ProtocolAnomalies: server.protocolAnomalies(),
+		LimitWarnings:     server.limitWarningStats(),
+	}
+	if server.sched != nil {
+		stats.Priorities = server.sched.stats()
+	}
+	if server.dedup != nil {
+		stats.Deduplicated = atomic.LoadUint64(&server.dedup.replayed)
+	}
+	return stats
+}
+
+// Connections lists the connections past the handshake, ordered by ID.
+func (server *Server) Connections() []ConnInfo {
+	server.mu.Lock()
+	infos := make([]ConnInfo, 0, len(server.conns))
+	for _, sc := range server.conns {
+		if sc != nil {
+			infos = append(infos, sc.info())
+		}
+	}
+	server.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// ClientStats is a snapshot of the counters of a client. Calls to the
+// built-in services, such as heartbeat pings, are not counted.
+type ClientStats struct {
+	Calls             uint64 // calls started
+	InFlight          int64  // calls waiting for their response
+	TransportErrors   uint64 // calls that failed to reach the server or get its response
+	ApplicationErrors uint64 // calls that the server answered with an error
+	Timeouts          uint64 // calls past their deadline or canceled, on either side
+	Overloaded        uint64 // calls failed with ErrClientOverloaded, not sent
+	Outstanding       int64  // calls sent and waiting for their response, see WithMaxOutstanding
+	Latency           LatencyHistogram
+}
+
+// LatencyHistogram counts call latencies: Counts[i] calls took at most
+// Bounds[i], and the last count is of the calls that took longer.
+type LatencyHistogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+}
+
+var latencyBounds = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2 * time.Second, 5 * time.Second,
+}
+
+// Merge adds the counters of o to s, e.g. to sum the clients of a pool.
+func (s *ClientStats) Merge(o ClientStats) {
+	s.Calls += o.Calls
+	s.InFlight += o.InFlight
+	s.TransportErrors += o.TransportErrors
+	s.ApplicationErrors += o.ApplicationErrors
+	s.Timeouts += o.Timeouts
+	s.Overloaded += o.Overloaded
+	s.Outstanding += o.Outstanding
+	if s.Latency.Counts == nil {
+		s.Latency.Bounds = latencyBounds
+		s.Latency.Counts = make([]uint64, len(latencyBounds)+1)
+	}
+	for i, n := range o.Latency.Counts {
+		s.Latency.Counts[i] += n
+	}
+}
+
+// clientStats are the counters of a client, updated atomically.
+type clientStats struct {
+	calls, transportErrs, appErrs, timeouts, overloaded uint64
+	inflight                                            int64
+	latency                                             [13]uint64 // len(latencyBounds) + 1
+}
+
+func (s *clientStats) begin() {
+	atomic.AddUint64(&s.calls, 1)
+	atomic.AddInt64(&s.inflight, 1)
+}
+
+// end counts a call done after d with err. Deadlines and cancellations
+// count as timeouts, wherever they happened.
+func (s *clientStats) end(d time.Duration, err error) {
+	atomic.AddInt64(&s.inflight, -1)
+	i := sort.Search(len(latencyBounds), func(i int) bool { return d <= latencyBounds[i] })
+	atomic.AddUint64(&s.latency[i], 1)
+	var se *serverError
+	switch {
+	case err == nil:
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled):
+		atomic.AddUint64(&s.timeouts, 1)
+	case errors.Is(err, ErrClientOverloaded):
+		atomic.AddUint64(&s.overloaded, 1)
+	case errors.As(err, &se):
+		atomic.AddUint64(&s.appErrs, 1)
+	default:
+		atomic.AddUint64(&s.transportErrs, 1)
+	}
+}
+
+// Stats returns a snapshot of the client counters.
+func (client *Client) Stats() ClientStats {
+	s := &client.stats
+	stats := ClientStats{
+		Calls:             atomic.LoadUint64(&s.calls),
+		InFlight:          atomic.LoadInt64(&s.inflight),
+		TransportErrors:   atomic.LoadUint64(&s.transportErrs),
+		ApplicationErrors: atomic.LoadUint64(&s.appErrs),
+		Timeouts:          atomic.LoadUint64(&s.timeouts),
+		Overloaded:        atomic.LoadUint64(&s.overloaded),
+		Latency:           LatencyHistogram{Bounds: latencyBounds, Counts: make([]uint64, len(s.latency))},
+	}
+	for i := range s.latency {
+		stats.Latency.Counts[i] = atomic.LoadUint64(&s.latency[i])
+	}
+	client.mu.Lock()
+	stats.Outstanding = int64(client.outstanding)
+	client.mu.Unlock()
+	return stats
+}
+
+// PublishClientStats publishes the stats of c under name with expvar,
+// so they are served on /debug/vars. Like expvar.Publish, it panics if
+// name is already taken.
+func PublishClientStats(name string, c *Client) {
+	expvar.Publish(name, expvar.Func(func() interface{} { return c.Stats() }))
+}
@@ -0,0 +1,136 @@
+package tinyrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServer_ByteCounters(t *testing.T) {
+	server := NewServer()
+	lis := startServer(t, server)
+	client, err := Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+	var reply int
+	if err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil {
+		t.Fatal("call error:", err)
+	}
+	first := server.Stats()
+	if err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil {
+		t.Fatal("call error:", err)
+	}
+	second := server.Stats()
+
+	// the first call carries the options and the gob type definitions
+	_assert(first.BytesRead > 100 && first.BytesWritten > 50, "unexpected first counts %+v", first)
+	// later calls only carry the values: a small header and two ints
+	read, written := second.BytesRead-first.BytesRead, second.BytesWritten-first.BytesWritten
+	_assert(read > 10 && read < 60, "unexpected request size %d", read)
+	_assert(written > 10 && written < 60, "unexpected response size %d", written)
+
+	conns := server.Connections()
+	_assert(second.Connections == 1 && len(conns) == 1, "expect one connection, but got %d", len(conns))
+	_assert(conns[0].BytesRead == second.BytesRead && conns[0].BytesWritten == second.BytesWritten,
+		"connection counts %+v don't match server counts %+v", conns[0], second)
+	_assert(conns[0].RemoteAddr != "", "expect the remote address of the connection")
+}
+
+func BenchmarkServer_Call(b *testing.B) {
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	lis, _ := server.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(lis)
+	defer func() { _ = lis.Close() }()
+	client, err := Dial("tcp", lis.Addr().String())
+	if err != nil {
+		b.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var reply int
+		if err := client.Call("Foo.Sum", Args{Num1: i, Num2: 1}, &reply); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestClient_Stats(t *testing.T) {
+	server := NewServer()
+	var slow Slow
+	_ = server.Register(&slow)
+	lis := startServer(t, server)
+	client, err := Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	name := "tinyrpc_test_client_" + strconv.FormatInt(time.Now().UnixNano(), 10) // unique with -count
+	PublishClientStats(name, client)
+
+	var reply int
+	for i := 0; i < 3; i++ {
+		_ = client.Call("Foo.Sum", Args{Num1: i, Num2: 1}, &reply)
+	}
+	_ = client.Call("Foo.Missing", Args{}, &reply)
+	_ = client.Call("Missing.Sum", Args{}, &reply)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_ = client.CallContext(ctx, "Slow.Sleep", 100, &reply)
+	_ = client.Close()
+	_ = client.Call("Foo.Sum", Args{}, &reply)
+
+	stats := client.Stats()
+	_assert(stats.Calls == 7 && stats.InFlight == 0, "unexpected call counts %+v", stats)
+	_assert(stats.ApplicationErrors == 2 && stats.Timeouts == 1 && stats.TransportErrors == 1,
+		"unexpected error counts %+v", stats)
+	var total uint64
+	for _, n := range stats.Latency.Counts {
+		total += n
+	}
+	_assert(total == 7 && len(stats.Latency.Counts) == len(stats.Latency.Bounds)+1,
+		"expect every call in the histogram, but got %v", stats.Latency.Counts)
+
+	var published ClientStats
+	err = json.Unmarshal([]byte(expvar.Get(name).String()), &published)
+	_assert(err == nil && published.Calls == 7, "expect the stats published, but got %+v, %v", published, err)
+}
+
+func TestServer_SizeStats(t *testing.T) {
+	server := NewServer(WithMaxBodySize(2000))
+	_assert(server.Register(Blob{}) == nil, "failed to register Blob")
+	addr := startServer(t, server).Addr().String()
+	call := func(n int) error {
+		client, err := Dial("tcp", addr, &Option{HeartbeatIdle: -1})
+		_assert(err == nil, "dial error: %v", err)
+		defer func() { _ = client.Close() }()
+		var reply int
+		return client.Call("Blob.Len", make([]byte, n), &reply)
+	}
+	_assert(call(10) == nil && call(500) == nil && call(500) == nil, "failed to call Blob.Len")
+	_assert(errors.Is(call(3000), ErrBodyTooLarge), "expect the large body rejected")
+
+	sizes, ok := server.Stats().Sizes["Blob.Len"]
+	_assert(ok, "expect the sizes of Blob.Len, but got %v", server.Stats().Sizes)
+	req := sizes.Requests
+	_assert(req.Counts[0] == 1 && req.Counts[2] == 2 && countsTotal(req.Counts) == 3,
+		"expect 10 bytes under %d and 500 under %d, but got %v", req.Bounds[0], req.Bounds[2], req.Counts)
+	_assert(req.Max >= 500 && req.Max < 520, "expect the largest request about 500 bytes, but got %d", req.Max)
+	_assert(sizes.Rejected == 1, "expect 1 rejected request, but got %d", sizes.Rejected)
+	_assert(sizes.Responses.Counts[0] == 3 && countsTotal(sizes.Responses.Counts) == 3, "expect 3 small responses, but got %v", sizes.Responses.Counts)
+}
+
+func countsTotal(counts []uint64) (n uint64) {
+	for _, c := range counts {
+		n += c
+	}
+	return n
+}
@@ -0,0 +1,189 @@
+package tinyrpc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"tinyrpc/codec"
+	"tinyrpc/rpclog"
+)
+
+// Defaults of StrictChecks.
+const (
+	DefaultMaxHandshakeBytes = 8 << 10
+	DefaultMaxMetadataKeys   = 64
+	DefaultMaxMetadataBytes  = 16 << 10
+	DefaultMaxSeqReorder     = 1024
+)
+
+// StrictChecks tune the checks of ServerConfig.StrictProtocol. The zero
+// value runs them all with the defaults.
+type StrictChecks struct {
+	// AllowUnknownOptions accepts options with JSON fields the server
+	// doesn't know, e.g. those of newer clients.
+	AllowUnknownOptions bool
+	// MaxHandshakeBytes bounds the options sent in the handshake, those
+	// of a codec fallback included. DefaultMaxHandshakeBytes if 0, no
+	// limit if negative.
+	MaxHandshakeBytes int
+	// MaxMetadataKeys and MaxMetadataBytes bound the metadata of a
+	// request, its keys and values counted in bytes. The defaults if 0,
+	// no limit if negative.
+	MaxMetadataKeys  int
+	MaxMetadataBytes int
+	// MaxSeqReorder is how far below the highest Seq of the connection
+	// the Seq of a request may be, since a client writes its concurrent
+	// calls in any order. A request whose Seq is 0, or is that of a call
+	// in flight, or is further behind, violates the protocol.
+	// DefaultMaxSeqReorder if 0, Seqs aren't checked if negative.
+	MaxSeqReorder int
+}
+
+// WithStrictProtocol sets ServerConfig.StrictProtocol, with checks.
+func WithStrictProtocol(checks StrictChecks) ServerOption {
+	return func(c *ServerConfig) error {
+		c.StrictProtocol, c.StrictChecks = true, checks
+		return nil
+	}
+}
+
+// ProtocolAnomalies count the connections closed by
+// ServerConfig.StrictProtocol, by reason.
+type ProtocolAnomalies struct {
+	UnknownOptions     uint64 // options with unknown JSON fields
+	OversizedHandshake uint64 // more than MaxHandshakeBytes of options
+	OversizedMetadata  uint64 // request metadata over its limits
+	InvalidSeq         uint64 // a request Seq that is 0, in flight or too far behind
+}
+
+type anomaly int
+
+const (
+	anomalyUnknownOptions anomaly = iota
+	anomalyOversizedHandshake
+	anomalyOversizedMetadata
+	anomalyInvalidSeq
+	numAnomalies
+)
+
+// errHandshakeTooLarge is returned by the reader of the options past
+// MaxHandshakeBytes.
+var errHandshakeTooLarge = errors.New("rpc server: handshake too large")
+
+// strict returns the checks of the server, nil unless StrictProtocol.
+func (server *Server) strict() *StrictChecks {
+	if !server.config.StrictProtocol {
+		return nil
+	}
+	return &server.config.StrictChecks
+}
+
+// strictLimit returns v, or def if v is 0. Negative values mean no limit.
+func strictLimit(v, def int) int {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+// handshakeReader fails the reads of the options past left bytes.
+type handshakeReader struct {
+	r    io.Reader
+	left int
+}
+
+func (h *handshakeReader) Read(p []byte) (int, error) {
+	if h.left <= 0 {
+		return 0, errHandshakeTooLarge
+	}
+	if len(p) > h.left {
+		p = p[:h.left]
+	}
+	n, err := h.r.Read(p)
+	h.left -= n
+	return n, err
+}
+
+// handshakeReader returns the reader of the options of a connection
+// reading r, within the MaxHandshakeBytes of checks.
+func (checks *StrictChecks) handshakeReader(r io.Reader) io.Reader {
+	if checks == nil {
+		return r
+	}
+	if n := strictLimit(checks.MaxHandshakeBytes, DefaultMaxHandshakeBytes); n > 0 {
+		return &handshakeReader{r: r, left: n}
+	}
+	return r
+}
+
+// handshakeAnomaly returns the anomaly that err, failing the handshake,
+// stands for, false if none.
+func (checks *StrictChecks) handshakeAnomaly(err error) (anomaly, bool) {
+	switch {
+	case checks == nil:
+	case errors.Is(err, errHandshakeTooLarge):
+		return anomalyOversizedHandshake, true
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return anomalyUnknownOptions, true
+	}
+	return 0, false
+}
+
+// checkMetadata returns the verdict of the metadata of a request header
+// against the limits of checks, with the error of a violation.
+func (checks *StrictChecks) checkMetadata(h *codec.Header, warnOnly bool) (verdict, error) {
+	maxKeys := strictLimit(checks.MaxMetadataKeys, DefaultMaxMetadataKeys)
+	if maxKeys > 0 && len(h.Metadata) > maxKeys {
+		return judge(true, warnOnly), fmt.Errorf("%w: %d metadata keys, expect at most %d", ErrProtocolViolation, len(h.Metadata), maxKeys)
+	}
+	if maxBytes := strictLimit(checks.MaxMetadataBytes, DefaultMaxMetadataBytes); maxBytes > 0 {
+		n := 0
+		for k, v := range h.Metadata {
+			n += len(k) + len(v)
+		}
+		if n > maxBytes {
+			return judge(true, warnOnly), fmt.Errorf("%w: %d bytes of metadata, expect at most %d", ErrProtocolViolation, n, maxBytes)
+		}
+	}
+	return verdictAllow, nil
+}
+
+// checkHeader returns the error of a request header violating the other
+// checks, with its anomaly.
+func (checks *StrictChecks) checkHeader(sc *serverConn, h *codec.Header) (anomaly, error) {
+	window := strictLimit(checks.MaxSeqReorder, DefaultMaxSeqReorder)
+	if window < 0 || h.ServiceMethod == cancelMethod {
+		return 0, nil // cancel frames name a call sent before
+	}
+	seq := h.Seq
+	switch {
+	case seq == 0:
+		return anomalyInvalidSeq, fmt.Errorf("%w: request seq 0", ErrProtocolViolation)
+	case seq+uint64(window) < sc.maxSeq:
+		return anomalyInvalidSeq, fmt.Errorf("%w: request seq %d after seq %d", ErrProtocolViolation, seq, sc.maxSeq)
+	case sc.inFlight(seq):
+		return anomalyInvalidSeq, fmt.Errorf("%w: request seq %d of a call in flight", ErrProtocolViolation, seq)
+	}
+	if seq > sc.maxSeq {
+		sc.maxSeq = seq
+	}
+	return 0, nil
+}
+
+// countAnomaly counts a connection closed for a, the reason of err, and
+// logs it with kv.
+func (server *Server) countAnomaly(a anomaly, err error, kv ...interface{}) {
+	atomic.AddUint64(&server.anomalies[a], 1)
+	server.log(rpclog.LevelWarn, "protocol violation, closing the connection", append(kv, "err", err)...)
+}
+
+func (server *Server) protocolAnomalies() ProtocolAnomalies {
+	return ProtocolAnomalies{
+		UnknownOptions:     atomic.LoadUint64(&server.anomalies[anomalyUnknownOptions]),
+		OversizedHandshake: atomic.LoadUint64(&server.anomalies[anomalyOversizedHandshake]),
+		OversizedMetadata:  atomic.LoadUint64(&server.anomalies[anomalyOversizedMetadata]),
+		InvalidSeq:         atomic.LoadUint64(&server.anomalies[anomalyInvalidSeq]),
+	}
+}
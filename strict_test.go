@@ -0,0 +1,111 @@
+package tinyrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"tinyrpc/codec"
+)
+
+// expectClosed fails unless the server closes peer, after any error frame.
+func expectClosed(t *testing.T, peer net.Conn) {
+	t.Helper()
+	_ = peer.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := io.Copy(io.Discard, peer)
+	_assert(err == nil || errors.Is(err, io.ErrClosedPipe), "expect the connection closed, but got %v", err)
+}
+
+func TestServer_StrictOptions(t *testing.T) {
+	server := NewServer(WithStrictProtocol(StrictChecks{MaxHandshakeBytes: 512}))
+	for _, options := range []string{
+		fmt.Sprintf(`{"MagicNumber": %d, "CodecType": %q, "Probe": true}`, MagicNumber, codec.GobType),
+		fmt.Sprintf(`{"MagicNumber": %d, "CodecType": %q, "AuthToken": %q}`, MagicNumber, codec.GobType, strings.Repeat("x", 1024)),
+	} {
+		conn, peer := net.Pipe()
+		go server.ServeConn(conn)
+		go func() { _, _ = io.WriteString(peer, options+"\n") }()
+		expectClosed(t, peer)
+	}
+	a := server.Stats().ProtocolAnomalies
+	_assert(a.UnknownOptions == 1 && a.OversizedHandshake == 1, "expect one of each handshake anomaly, but got %+v", a)
+
+	// permissive without strict mode
+	lenient := NewServer()
+	lis := startServer(t, lenient)
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = conn.Close() }()
+	_, _ = fmt.Fprintf(conn, `{"MagicNumber": %d, "CodecType": %q, "Probe": true}`+"\n", MagicNumber, codec.GobType)
+	cc := codec.NewGobCodec(conn)
+	_ = cc.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1}, Args{Num1: 1, Num2: 2})
+	var h codec.Header
+	var reply int
+	_assert(cc.ReadHeader(&h) == nil && cc.ReadBody(&reply) == nil && reply == 3, "expect unknown options tolerated by default")
+}
+
+func TestServer_StrictMetadata(t *testing.T) {
+	server := NewServer(WithStrictProtocol(StrictChecks{MaxMetadataKeys: 2, MaxMetadataBytes: 64}))
+	lis := startServer(t, server)
+	for _, opts := range [][]CallOption{
+		{WithHeader("a", "1"), WithHeader("b", "2"), WithHeader("c", "3")},
+		{WithHeader("a", strings.Repeat("x", 100))},
+	} {
+		client, err := Dial("tcp", lis.Addr().String(), &Option{HeartbeatIdle: -1})
+		_assert(err == nil, "dial error: %v", err)
+		_assert(client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, new(int), WithHeader("a", "1")) == nil, "expect metadata within the limits accepted")
+		err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, new(int), opts...)
+		_assert(errors.Is(err, ErrProtocolViolation), "expect ErrProtocolViolation, but got %v", err)
+		waitFor(t, func() bool { return !client.IsAvailable() }, "expect the connection closed")
+		_ = client.Close()
+	}
+	a := server.Stats().ProtocolAnomalies
+	_assert(a.OversizedMetadata == 2, "expect two metadata anomalies, but got %+v", a)
+}
+
+func TestServer_StrictSeq(t *testing.T) {
+	server := NewServer(WithStrictProtocol(StrictChecks{MaxSeqReorder: 10}))
+	gate := make(Gate)
+	defer close(gate)
+	_ = server.Register(gate)
+	for _, seqs := range [][]uint64{
+		{0},
+		{100, 50},  // too far behind
+		{100, 100}, // in flight
+	} {
+		conn, peer := net.Pipe()
+		go server.ServeConn(conn)
+		go func() {
+			_ = json.NewEncoder(peer).Encode(DefaultOption)
+			cc := codec.NewGobCodec(peer)
+			for _, seq := range seqs {
+				_ = cc.Write(&codec.Header{ServiceMethod: "Gate.Wait", Seq: seq}, 1)
+			}
+		}()
+		expectClosed(t, peer)
+	}
+	a := server.Stats().ProtocolAnomalies
+	_assert(a.InvalidSeq == 3, "expect three seq anomalies, but got %+v", a)
+
+	// concurrent calls written out of order stay within the window
+	server = NewServer(WithStrictProtocol(StrictChecks{}))
+	lis := startServer(t, server)
+	client, err := Dial("tcp", lis.Addr().String(), &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_assert(client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, new(int)) == nil, "expect a strict server to accept a well-behaved client")
+		}()
+	}
+	wg.Wait()
+	_assert(server.Stats().ProtocolAnomalies == (ProtocolAnomalies{}), "expect no anomaly")
+}
@@ -0,0 +1,112 @@
+package tinyrpc
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// subscription is a channel Subscribe returned.
+type subscription struct {
+	ch chan RawMessage
+	// backfill holds, while the subscription is being made, the topics
+	// of the messages that reached ch: the retained message of a topic
+	// is taken unless one of the topic came first. It is nil once made.
+	backfill map[string]bool
+}
+
+// Subscribe asks the server to push the messages published on topic to
+// this client, see Server.Publish. topic may be a pattern whose segments,
+// separated by dots, are "*" to match any segment, such as "orders.*".
+// The messages retained for the topics matching it, see WithRetain, are
+// delivered first. Messages arriving while the channel holds
+// DefaultPushQueue unread messages are dropped, see PushDropped.
+// The channel is closed when ctx is done or the client shuts down.
+func (client *Client) Subscribe(ctx context.Context, topic string) (<-chan RawMessage, error) {
+	sub := &subscription{ch: make(chan RawMessage, DefaultPushQueue), backfill: make(map[string]bool)}
+	client.mu.Lock()
+	if client.shutdown || client.closing {
+		client.mu.Unlock()
+		return nil, ErrShutdown
+	}
+	if client.subs == nil {
+		client.subs = make(map[string][]*subscription)
+	}
+	client.subs[topic] = append(client.subs[topic], sub)
+	client.mu.Unlock()
+
+	// the server is asked for every subscription, to send the retained messages
+	var ok bool
+	if err := client.CallContext(ctx, "_sub_.Subscribe", topic, &ok); err != nil {
+		client.unsubscribe(topic, sub, false)
+		return nil, err
+	}
+	client.mu.Lock()
+	sub.backfill = nil
+	client.mu.Unlock()
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.unsubscribe(topic, sub, true)
+		case <-client.done: // terminateCalls closes ch
+		}
+	}()
+	return sub.ch, nil
+}
+
+// unsubscribe closes the channel of sub, and tells the server when it was
+// the last subscription to topic if notify is set.
+func (client *Client) unsubscribe(topic string, sub *subscription, notify bool) {
+	client.mu.Lock()
+	subs := client.subs[topic]
+	for i, s := range subs {
+		if s == sub {
+			subs = append(subs[:i:i], subs[i+1:]...)
+			close(sub.ch)
+			break
+		}
+	}
+	if len(subs) == 0 {
+		delete(client.subs, topic)
+	} else {
+		client.subs[topic] = subs
+	}
+	last := len(subs) == 0 && !client.shutdown
+	client.mu.Unlock()
+	if last && notify {
+		var ok bool
+		_ = client.Call("_sub_.Unsubscribe", topic, &ok) // best effort
+	}
+}
+
+// deliver passes a pushed message to the subscriptions of the topics
+// and patterns matching topic, dropping it for those that are full. A
+// retained message is passed to the subscriptions of retained being made.
+func (client *Client) deliver(topic, retained string, data []byte) {
+	m := RawMessage{Topic: topic, Data: data, Retained: retained != "", codecType: client.opt.CodecType}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for pattern, subs := range client.subs {
+		if (m.Retained && pattern != retained) || !matchTopic(pattern, topic) {
+			continue
+		}
+		for _, s := range subs {
+			if m.Retained && (s.backfill == nil || s.backfill[topic]) {
+				continue // subscribed already, or newer message first
+			}
+			if s.backfill != nil {
+				s.backfill[topic] = true
+			}
+			select {
+			case s.ch <- m:
+			default:
+				atomic.AddUint64(&client.pushDropped, 1)
+			}
+		}
+	}
+}
+
+// PushDropped returns the number of pushed messages dropped because a
+// subscription channel was full.
+func (client *Client) PushDropped() uint64 {
+	return atomic.LoadUint64(&client.pushDropped)
+}
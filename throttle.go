@@ -0,0 +1,160 @@
+package tinyrpc
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+	"tinyrpc/internal/clock"
+)
+
+// IPLimits throttle the clients of a server by remote IP, the one sent
+// in the PROXY header if SetAcceptProxyProtocol is on, so that one busy
+// machine can't starve the others. Zero fields mean no limit.
+type IPLimits struct {
+	// MaxConns closes the connections of an IP accepted beyond this many
+	// being served.
+	MaxConns int
+	// QPS is the rate of the requests of an IP, shared by all its
+	// connections, up to Burst at once (QPS rounded up if 0). Requests
+	// over it are answered with ErrRateLimited, and a RetryAfterTrailer,
+	// unless WarnOnly.QPS. Calls to the built-in
+	// services, such as heartbeat pings, are not counted.
+	QPS   float64
+	Burst int
+	// IdleExpiry forgets the IPs without connections for this long,
+	// DefaultIPIdleExpiry if 0.
+	IdleExpiry time.Duration
+}
+
+// DefaultIPIdleExpiry is how long an IP without connections is tracked.
+const DefaultIPIdleExpiry = time.Minute
+
+// SetIPLimits sets the limits of every remote IP. It must be called
+// before the server starts serving, see UpdateLimits afterwards.
+func (server *Server) SetIPLimits(limits IPLimits) {
+	server.ipLimits = limits.withDefaults()
+}
+
+func (l IPLimits) withDefaults() IPLimits {
+	if l.Burst <= 0 {
+		l.Burst = int(l.QPS)
+		if float64(l.Burst) < l.QPS {
+			l.Burst++
+		}
+	}
+	if l.IdleExpiry <= 0 {
+		l.IdleExpiry = DefaultIPIdleExpiry
+	}
+	return l
+}
+
+// enabled reports whether the IPs need tracking.
+func (l IPLimits) enabled() bool { return l.MaxConns > 0 || l.QPS > 0 }
+
+func (l IPLimits) validate() error {
+	if l.MaxConns < 0 || l.QPS < 0 || l.Burst < 0 || l.IdleExpiry < 0 {
+		return fmt.Errorf("rpc server: negative ip limits %+v", l)
+	}
+	return nil
+}
+
+func newIPThrottle(server *Server) *ipThrottle {
+	now := func() time.Time { return clock.Or(server.clock).Now() }
+	return &ipThrottle{now: now, ips: make(map[string]*ipState)}
+}
+
+// ipThrottle tracks the connections and the requests of each remote IP,
+// within the IPLimits given to each method.
+type ipThrottle struct {
+	now func() time.Time
+
+	mu        sync.Mutex // protect following
+	ips       map[string]*ipState
+	lastSweep time.Time
+}
+
+type ipState struct {
+	conns     int
+	tokens    float64   // requests allowed now, up to Burst
+	refilled  time.Time // when tokens was computed
+	idleSince time.Time // when the last connection ended
+}
+
+// ipOf returns the IP of the remote address of conn, "" if it has none.
+func ipOf(conn interface{}) string {
+	addr := remoteAddr(conn)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// state returns the state of ip, tracking it if needed. t.mu must be held.
+func (t *ipThrottle) state(ip string, now time.Time, limits IPLimits) *ipState {
+	if now.Sub(t.lastSweep) >= limits.IdleExpiry {
+		t.lastSweep = now
+		for addr, s := range t.ips {
+			if s.conns == 0 && now.Sub(s.idleSince) >= limits.IdleExpiry {
+				delete(t.ips, addr)
+			}
+		}
+	}
+	s := t.ips[ip]
+	if s == nil {
+		s = &ipState{tokens: float64(limits.Burst), refilled: now, idleSince: now}
+		t.ips[ip] = s
+	}
+	return s
+}
+
+// acquire reports whether ip may open one more connection, and counts it.
+func (t *ipThrottle) acquire(ip string, limits IPLimits) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.state(ip, t.now(), limits)
+	if limits.MaxConns > 0 && s.conns >= limits.MaxConns {
+		return false
+	}
+	s.conns++
+	return true
+}
+
+// release ends a connection of ip.
+func (t *ipThrottle) release(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s := t.ips[ip]; s != nil {
+		s.conns--
+		s.idleSince = t.now()
+	}
+}
+
+// allow returns the verdict of ip making one more request, and takes its
+// token if it has one, else returns how long until it has one.
+func (t *ipThrottle) allow(ip string, limits IPLimits, warnOnly bool) (verdict, time.Duration) {
+	if limits.QPS <= 0 {
+		return verdictAllow, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	s := t.state(ip, now, limits)
+	s.tokens += now.Sub(s.refilled).Seconds() * limits.QPS
+	if burst := float64(limits.Burst); s.tokens > burst {
+		s.tokens = burst
+	}
+	s.refilled = now
+	if s.tokens < 1 {
+		return judge(true, warnOnly), time.Duration((1 - s.tokens) / limits.QPS * float64(time.Second))
+	}
+	s.tokens--
+	return verdictAllow, 0
+}
+
+// tracked returns the number of IPs tracked.
+func (t *ipThrottle) tracked() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.ips)
+}
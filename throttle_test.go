@@ -0,0 +1,103 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+	"tinyrpc/tinyrpctest"
+)
+
+// ipConn is the server end of a pipe, as if connected from ip.
+type ipConn struct {
+	net.Conn
+	ip net.IP
+}
+
+func (c *ipConn) RemoteAddr() net.Addr { return &net.TCPAddr{IP: c.ip, Port: 40000} }
+
+// ipDialer hands the server ends of its pipes to a pipeListener, as
+// connected from ip.
+type ipDialer struct {
+	l  *pipeListener
+	ip net.IP
+}
+
+func (d ipDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case d.l.conns <- &ipConn{server, d.ip}:
+		return client, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestServer_IPLimits(t *testing.T) {
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	clock := tinyrpctest.NewClock()
+	server.SetClock(clock)
+	server.SetIPLimits(IPLimits{MaxConns: 2, QPS: 1, Burst: 3, IdleExpiry: time.Minute})
+	l := newPipeListener()
+	go server.Accept(l)
+	t.Cleanup(func() { _ = l.Close() })
+
+	dial := func(ip string) (*Client, error) {
+		// the token makes Dial wait for the handshake, so that the
+		// connections are counted in order
+		opt := &Option{AuthToken: "token", HeartbeatIdle: -1}
+		client, err := Dial("pipe", "", opt, WithDialer(ipDialer{l, net.ParseIP(ip)}))
+		if err == nil {
+			t.Cleanup(func() { _ = client.Close() })
+		}
+		return client, err
+	}
+	mustDial := func(ip string) *Client {
+		client, err := dial(ip)
+		_assert(err == nil, "dial error: %v", err)
+		return client
+	}
+	sum := func(client *Client) error {
+		var reply int
+		return client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	}
+
+	a1, a2 := mustDial("10.0.0.1"), mustDial("10.0.0.1")
+	_, err := dial("10.0.0.1")
+	_assert(err != nil, "expect the third connection of an IP closed")
+	b := mustDial("10.0.0.2")
+	for i, client := range []*Client{a1, a2, a1} {
+		_assert(sum(client) == nil, "expect call %d within the burst of the IP", i)
+	}
+	err = sum(a2)
+	_assert(errors.Is(err, ErrRateLimited), "expect the burst shared by the connections of the IP, but got %v", err)
+	var reply int
+	_assert(a1.Call("_ping_.Ping", 1, &reply) == nil, "expect built-in calls not limited")
+	for i := 0; i < 3; i++ {
+		_assert(sum(b) == nil, "expect another IP to have its own budget, call %d", i)
+	}
+
+	clock.Advance(time.Second)
+	_assert(sum(a1) == nil, "expect a token refilled after a second")
+	_assert(errors.Is(sum(a1), ErrRateLimited), "expect one token only")
+
+	_ = b.Close()
+	for server.ips.tracked() != 2 || server.ips.conns("10.0.0.2") != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Minute)
+	_ = sum(a1) // sweeps
+	_assert(server.ips.tracked() == 1, "expect the idle IP forgotten, but got %d IPs", server.ips.tracked())
+}
+
+func (t *ipThrottle) conns(ip string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s := t.ips[ip]; s != nil {
+		return s.conns
+	}
+	return -1
+}
@@ -0,0 +1,130 @@
+package tinyrpc
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"tinyrpc/internal/clock"
+)
+
+// timeoutHeader carries the time left until the deadline of the client's
+// context, in milliseconds, in the metadata of a request.
+const timeoutHeader = "tinyrpc-timeout"
+
+// methodTimeouts maps "Service.Method" and "Service.*" patterns to timeouts.
+type methodTimeouts struct {
+	mu      sync.RWMutex // protect following
+	methods map[string]time.Duration
+	globs   map[string]time.Duration // by service name
+}
+
+func (m *methodTimeouts) set(pattern string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	table, key := &m.methods, pattern
+	if strings.HasSuffix(pattern, ".*") {
+		table, key = &m.globs, strings.TrimSuffix(pattern, ".*")
+	}
+	if d <= 0 {
+		delete(*table, key)
+		return
+	}
+	if *table == nil {
+		*table = make(map[string]time.Duration)
+	}
+	(*table)[key] = d
+}
+
+// each calls fn with every pattern and its timeout, sorted by pattern.
+func (m *methodTimeouts) each(fn func(pattern string, d time.Duration)) {
+	m.mu.RLock()
+	all := make(map[string]time.Duration, len(m.methods)+len(m.globs))
+	for k, d := range m.methods {
+		all[k] = d
+	}
+	for k, d := range m.globs {
+		all[k+".*"] = d
+	}
+	m.mu.RUnlock()
+	patterns := make([]string, 0, len(all))
+	for k := range all {
+		patterns = append(patterns, k)
+	}
+	sort.Strings(patterns)
+	for _, k := range patterns {
+		fn(k, all[k])
+	}
+}
+
+// lookup returns the timeout of serviceMethod, 0 if none. An exact name
+// is more specific than a "Service.*" glob.
+func (m *methodTimeouts) lookup(serviceMethod string) time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if d, ok := m.methods[serviceMethod]; ok {
+		return d
+	}
+	if dot := strings.LastIndex(serviceMethod, "."); dot > 0 {
+		return m.globs[serviceMethod[:dot]]
+	}
+	return 0
+}
+
+// SetMethodTimeout sets the timeout of the methods matching pattern,
+// either "Service.Method" or "Service.*", or removes it if d is 0.
+// An exact name wins over a glob. The server answers calls that take
+// longer with ErrHandleTimeout. The handler sees the smallest of this
+// timeout, Option.HandleTimeout of the client and the deadline the client
+// propagates with CallContext.
+func (server *Server) SetMethodTimeout(pattern string, d time.Duration) {
+	server.timeouts.set(pattern, d)
+}
+
+// SetMethodTimeout sets the default timeout of calls to the methods
+// matching pattern, either "Service.Method" or "Service.*", or removes it
+// if d is 0. Call and CallContext give up after it with
+// ErrDeadlineExceeded, unless the context has an earlier deadline.
+func (client *Client) SetMethodTimeout(pattern string, d time.Duration) {
+	client.timeouts.set(pattern, d)
+}
+
+// withDeadlineHeader returns opts with the time left until the deadline
+// of ctx in the request metadata, if it has one.
+func withDeadlineHeader(c Clock, ctx context.Context, opts []CallOption) []CallOption {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return opts
+	}
+	// rounded up, so that the server never gives up before the client
+	ms := int64((clock.Until(c, deadline) + time.Millisecond - 1) / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	return append(opts[:len(opts):len(opts)], WithHeader(timeoutHeader, strconv.FormatInt(ms, 10)))
+}
+
+// handleTimeout returns how long the server may handle req, 0 if there
+// is no limit, and the error to give up with.
+func (server *Server) handleTimeout(sc *serverConn, req *request) (time.Duration, error) {
+	d, err := sc.timeout, ErrHandleTimeout
+	s := server.currentLimits().HandleTimeout
+	if req.svc != nil && req.svc.config.handleTimeout > 0 {
+		s = req.svc.config.handleTimeout
+	}
+	if s > 0 && (d == 0 || s < d) {
+		d = s
+	}
+	if m := server.timeouts.lookup(req.h.ServiceMethod); m > 0 && (d == 0 || m < d) {
+		d = m
+	}
+	if v, ok := req.h.Metadata[timeoutHeader]; ok {
+		ms, perr := strconv.ParseInt(v, 10, 64)
+		if p := time.Duration(ms) * time.Millisecond; perr == nil && p > 0 && (d == 0 || p < d) {
+			d, err = p, ErrDeadlineExceeded
+		}
+	}
+	return d, err
+}
@@ -0,0 +1,134 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"tinyrpc/tinyrpctest"
+)
+
+// Budget reports how long its handler may run, in milliseconds, -1 if forever.
+type Budget int
+
+func (b Budget) Left(ctx context.Context, _ int, reply *int64) error {
+	*reply = -1
+	if deadline, ok := ctx.Deadline(); ok {
+		*reply = time.Until(deadline).Milliseconds()
+	}
+	return nil
+}
+
+func (b Budget) Other(ctx context.Context, n int, reply *int64) error {
+	return b.Left(ctx, n, reply)
+}
+
+func TestMethodTimeouts_Lookup(t *testing.T) {
+	var m methodTimeouts
+	m.set("Budget.*", time.Second)
+	m.set("Budget.Left", 2*time.Second)
+	_assert(m.lookup("Budget.Left") == 2*time.Second, "expect the exact name to win")
+	_assert(m.lookup("Budget.Other") == time.Second, "expect the glob to match")
+	_assert(m.lookup("Slow.Sleep") == 0, "expect no timeout for other services")
+	m.set("Budget.Left", 0)
+	_assert(m.lookup("Budget.Left") == time.Second, "expect the exact timeout removed")
+}
+
+func TestServer_SetMethodTimeout(t *testing.T) {
+	server := NewServer()
+	var b Budget
+	_ = server.Register(&b)
+	server.SetMethodTimeout("Budget.*", 300*time.Millisecond)
+	server.SetMethodTimeout("Budget.Left", 600*time.Millisecond)
+	addr := startServer(t, server).Addr().String()
+
+	tests := []struct {
+		name     string
+		method   string
+		global   time.Duration // Option.HandleTimeout
+		deadline time.Duration // of the client context, none if 0
+		want     time.Duration
+	}{
+		{"exact wins over glob", "Budget.Left", 0, 0, 600 * time.Millisecond},
+		{"glob", "Budget.Other", 0, 0, 300 * time.Millisecond},
+		{"propagated deadline is smaller", "Budget.Left", 0, 100 * time.Millisecond, 100 * time.Millisecond},
+		{"method timeout is smaller", "Budget.Other", 0, time.Second, 300 * time.Millisecond},
+		{"global is smaller", "Budget.Left", 150 * time.Millisecond, time.Second, 150 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := Dial("tcp", addr, &Option{HandleTimeout: tt.global})
+			if err != nil {
+				t.Fatal("dial error:", err)
+			}
+			defer func() { _ = client.Close() }()
+			ctx := context.Background()
+			if tt.deadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.deadline)
+				defer cancel()
+			}
+			var left int64
+			err = client.CallContext(ctx, tt.method, 0, &left)
+			got := time.Duration(left) * time.Millisecond
+			_assert(err == nil && got <= tt.want && got > tt.want-50*time.Millisecond,
+				"expect about %v left, but got %v, %v", tt.want, got, err)
+		})
+	}
+}
+
+// goCall makes the call in the background, returning its error on the channel.
+func goCall(call func() error) <-chan error {
+	errc := make(chan error, 1)
+	go func() { errc <- call() }()
+	return errc
+}
+
+func TestServer_MethodTimeoutError(t *testing.T) {
+	server := NewServer()
+	clock := tinyrpctest.NewClock()
+	server.SetClock(clock)
+	var slow Slow
+	_ = server.Register(&slow)
+	server.SetMethodTimeout("Slow.Sleep", 50*time.Millisecond)
+	client, err := Dial("tcp", startServer(t, server).Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+	var reply int
+	errc := goCall(func() error { return client.Call("Slow.Sleep", 300, &reply) })
+	clock.BlockUntil(1) // the handle timeout
+	clock.Advance(50 * time.Millisecond)
+	err = <-errc
+	_assert(errors.Is(err, ErrHandleTimeout), "expect a handle timeout, but got %v", err)
+}
+
+func TestClient_SetMethodTimeout(t *testing.T) {
+	server := NewServer()
+	var slow Slow
+	_ = server.Register(&slow)
+	clock := tinyrpctest.NewClock()
+	client, err := Dial("tcp", startServer(t, server).Addr().String(), &Option{Clock: clock, HeartbeatIdle: -1})
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+	client.SetMethodTimeout("Slow.*", 50*time.Millisecond)
+
+	var reply int
+	errc := goCall(func() error { return client.Call("Slow.Sleep", 300, &reply) })
+	clock.BlockUntil(1) // the method timeout
+	clock.Advance(50 * time.Millisecond)
+	err = <-errc
+	_assert(errors.Is(err, ErrDeadlineExceeded), "expect a deadline error, but got %v", err)
+
+	// an earlier deadline of the context wins
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	client.SetMethodTimeout("Slow.*", time.Second)
+	start := time.Now()
+	err = client.CallContext(ctx, "Slow.Sleep", 300, &reply)
+	_assert(errors.Is(err, ErrDeadlineExceeded) && time.Since(start) < 200*time.Millisecond,
+		"expect the context deadline to win, but got %v", err)
+}
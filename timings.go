@@ -0,0 +1,173 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync/atomic"
+	"time"
+	"tinyrpc/rpclog"
+)
+
+// Timings are the boundaries of the phases of a call on the server,
+// read from the clock of the server, so that they hold monotonic
+// readings. They are recorded only if the server has a collector, see
+// WithTimings.
+type Timings struct {
+	// Arrived is when the first byte of the request was read, or when
+	// the server started reading it on a connection of ServeCodec.
+	Arrived time.Time
+	// HeaderRead is when the header was decoded.
+	HeaderRead time.Time
+	// BodyDecoded is when the arguments were decoded. Calls to the raw
+	// handler, which reads its own body, have it equal to HeaderRead.
+	BodyDecoded time.Time
+	// HandlerStart is when the call left the queue of the workers, if
+	// any, to be validated and passed to the interceptors.
+	HandlerStart time.Time
+	// HandlerDone is when the handler returned or timed out.
+	HandlerDone time.Time
+	// Written is when the response was encoded and written, its turn
+	// and the flow control of the connection included.
+	Written time.Time
+}
+
+// Header is the time reading the header took.
+func (t Timings) Header() time.Duration { return t.HeaderRead.Sub(t.Arrived) }
+
+// Decode is the time decoding the arguments took.
+func (t Timings) Decode() time.Duration { return t.BodyDecoded.Sub(t.HeaderRead) }
+
+// Queue is the time the call waited for a goroutine to handle it.
+func (t Timings) Queue() time.Duration { return t.HandlerStart.Sub(t.BodyDecoded) }
+
+// Handler is the time the interceptors and the handler took.
+func (t Timings) Handler() time.Duration { return t.HandlerDone.Sub(t.HandlerStart) }
+
+// Write is the time sending the response took.
+func (t Timings) Write() time.Duration { return t.Written.Sub(t.HandlerDone) }
+
+// Total is the time from the arrival of the request to its response
+// written, the sum of the phases.
+func (t Timings) Total() time.Duration { return t.Written.Sub(t.Arrived) }
+
+// TimingsFunc collects the Timings of a call answered with err, nil if
+// it succeeded. It is called by the goroutine handling the call, once
+// the response is written, so it should return quickly.
+type TimingsFunc func(serviceMethod string, t Timings, err error)
+
+// WithTimings appends fns to ServerConfig.Timings. Calls to the built-in
+// services, and calls whose client abandoned them, are not collected.
+func WithTimings(fns ...TimingsFunc) ServerOption {
+	return func(c *ServerConfig) error {
+		for _, fn := range fns {
+			if fn == nil {
+				return errors.New("rpc server: nil timings collector")
+			}
+		}
+		c.Timings = append(c.Timings, fns...)
+		return nil
+	}
+}
+
+type timingsKey struct{}
+
+// TimingsFromContext returns the Timings of the call handled with ctx so
+// far, up to HandlerStart, e.g. for an interceptor. It returns false
+// outside a handler, or if the server has no collector.
+func TimingsFromContext(ctx context.Context) (Timings, bool) {
+	t, ok := ctx.Value(timingsKey{}).(*Timings)
+	if !ok {
+		return Timings{}, false
+	}
+	// the later fields are written once the handler returns, maybe
+	// while an interceptor that timed out still runs
+	return Timings{Arrived: t.Arrived, HeaderRead: t.HeaderRead, BodyDecoded: t.BodyDecoded, HandlerStart: t.HandlerStart}, true
+}
+
+// collectTimings passes the Timings of req, answered with err, to the
+// collectors of the server, unless no response was written.
+func (server *Server) collectTimings(req *request, err error) {
+	if req.timings == nil || req.timings.Written.IsZero() {
+		return
+	}
+	for _, fn := range server.config.Timings {
+		fn(req.h.ServiceMethod, *req.timings, err)
+	}
+}
+
+// AccessLog returns a TimingsFunc logging every call to l at LevelInfo,
+// with its error and the durations of its phases.
+func AccessLog(l rpclog.Logger) TimingsFunc {
+	return func(serviceMethod string, t Timings, err error) {
+		errMsg := ""
+		if err != nil {
+			errMsg = err.Error()
+		}
+		l.Log(rpclog.LevelInfo, "access", "call",
+			"method", serviceMethod, "err", errMsg, "total", t.Total(),
+			"header", t.Header(), "decode", t.Decode(), "queue", t.Queue(),
+			"handler", t.Handler(), "write", t.Write())
+	}
+}
+
+// PhaseStats are the durations of the phases of the calls, see Timings.
+type PhaseStats struct {
+	Header, Decode, Queue, Handler, Write, Total LatencyHistogram
+}
+
+// phaseBounds are finer than latencyBounds, the phases around the
+// handler taking microseconds.
+var phaseBounds = []time.Duration{
+	10 * time.Microsecond, 50 * time.Microsecond, 100 * time.Microsecond,
+	500 * time.Microsecond, time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 5 * time.Second,
+}
+
+// phaseHistogram is a LatencyHistogram of phaseBounds updated atomically.
+type phaseHistogram [13]uint64 // len(phaseBounds) + 1
+
+func (h *phaseHistogram) observe(d time.Duration) {
+	i := sort.Search(len(phaseBounds), func(i int) bool { return d <= phaseBounds[i] })
+	atomic.AddUint64(&h[i], 1)
+}
+
+func (h *phaseHistogram) snapshot() LatencyHistogram {
+	s := LatencyHistogram{Bounds: phaseBounds, Counts: make([]uint64, len(h))}
+	for i := range h {
+		s.Counts[i] = atomic.LoadUint64(&h[i])
+	}
+	return s
+}
+
+// PhaseHistograms count the durations of the phases of the calls a
+// server collects with their Observe method, e.g.
+//
+//	h := new(tinyrpc.PhaseHistograms)
+//	server := tinyrpc.NewServer(tinyrpc.WithTimings(h.Observe))
+type PhaseHistograms struct {
+	header, decode, queue, handler, write, total phaseHistogram
+}
+
+// Observe counts t. It is a TimingsFunc.
+func (h *PhaseHistograms) Observe(_ string, t Timings, _ error) {
+	h.header.observe(t.Header())
+	h.decode.observe(t.Decode())
+	h.queue.observe(t.Queue())
+	h.handler.observe(t.Handler())
+	h.write.observe(t.Write())
+	h.total.observe(t.Total())
+}
+
+// Stats returns a snapshot of the histograms.
+func (h *PhaseHistograms) Stats() PhaseStats {
+	return PhaseStats{
+		Header:  h.header.snapshot(),
+		Decode:  h.decode.snapshot(),
+		Queue:   h.queue.snapshot(),
+		Handler: h.handler.snapshot(),
+		Write:   h.write.snapshot(),
+		Total:   h.total.snapshot(),
+	}
+}
@@ -0,0 +1,117 @@
+package tinyrpc
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"tinyrpc/rpclog"
+)
+
+type timedCall struct {
+	method string
+	t      Timings
+	err    error
+}
+
+func collectTo(calls chan timedCall) TimingsFunc {
+	return func(serviceMethod string, t Timings, err error) {
+		calls <- timedCall{serviceMethod, t, err}
+	}
+}
+
+func TestServer_Timings(t *testing.T) {
+	calls := make(chan timedCall, 4)
+	var log bytes.Buffer
+	var phases PhaseHistograms
+	fromCtx := make(chan bool, 1)
+	server := NewServer(
+		WithTimings(AccessLog(rpclog.New(&log, rpclog.TextFormat)), phases.Observe, collectTo(calls)),
+		WithInterceptors(func(ctx context.Context, serviceMethod string, args interface{}, next func(ctx context.Context) error) error {
+			t, ok := TimingsFromContext(ctx)
+			fromCtx <- ok && !t.HandlerStart.IsZero() && !t.HandlerStart.Before(t.BodyDecoded) && t.HandlerDone.IsZero()
+			return next(ctx)
+		}),
+	)
+	_ = server.Register(new(Slow))
+	lis := startServer(t, server)
+	client, err := Dial("tcp", lis.Addr().String(), &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	start := time.Now()
+	var reply int
+	_assert(client.Call("Slow.Sleep", 50, &reply) == nil, "failed to call Slow.Sleep")
+	elapsed := time.Since(start)
+	_assert(<-fromCtx, "expect the interceptor to get the timings up to HandlerStart")
+	c := <-calls
+	_assert(c.method == "Slow.Sleep" && c.err == nil, "expect Slow.Sleep collected, but got %s: %v", c.method, c.err)
+
+	tm := c.t
+	for _, d := range []time.Duration{tm.Header(), tm.Decode(), tm.Queue(), tm.Handler(), tm.Write()} {
+		_assert(d >= 0, "expect the phases in order, but got %+v", tm)
+	}
+	sum := tm.Header() + tm.Decode() + tm.Queue() + tm.Handler() + tm.Write()
+	_assert(sum == tm.Total(), "expect the phases to sum to %s, but got %s", tm.Total(), sum)
+	_assert(tm.Handler() >= 50*time.Millisecond, "expect the handler to take 50ms, but took %s", tm.Handler())
+	_assert(tm.Total() <= elapsed && tm.Total() >= elapsed/2,
+		"expect the total %s to be most of the round trip %s", tm.Total(), elapsed)
+
+	_assert(strings.Contains(log.String(), "msg=call method=Slow.Sleep"), "expect an access log entry, but got %q", log.String())
+	stats := phases.Stats()
+	var n uint64
+	for _, count := range stats.Handler.Counts {
+		n += count
+	}
+	_assert(n == 1, "expect one handler phase counted, but got %d", n)
+
+	// built-in calls are not collected
+	_assert(client.Call("_ping_.Ping", 0, new(int)) == nil, "failed to ping")
+	select {
+	case c := <-calls:
+		t.Fatalf("expect the built-in call not collected, but got %s", c.method)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestServer_TimingsQueue(t *testing.T) {
+	calls := make(chan timedCall, 2)
+	server := NewServer(WithWorkers(1, 0), WithTimings(collectTo(calls)))
+	s := sleeper{release: make(chan struct{})}
+	_ = server.Register(s)
+	lis := startServer(t, server)
+	client, err := Dial("tcp", lis.Addr().String(), &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	first := client.Go("sleeper.Wait", 1, new(int), nil)
+	waitFor(t, func() bool { return server.Stats().Priorities["normal"].Handled == 1 }, "expect the first call taken by the worker")
+	second := client.Go("sleeper.Wait", 2, new(int), nil)
+	waitFor(t, func() bool { return server.Stats().Priorities["normal"].Queued == 1 }, "expect the second call queued")
+	queuedAt := time.Now() // the second call waits for the worker from before
+	time.Sleep(20 * time.Millisecond)
+	released := time.Now()
+	close(s.release)
+	_assert((<-first.Done).Error == nil && (<-second.Done).Error == nil, "failed to call sleeper.Wait")
+	queued := (<-calls).t.Queue()
+	if q := (<-calls).t.Queue(); q > queued {
+		queued = q
+	}
+	_assert(queued >= released.Sub(queuedAt), "expect the call queued behind the first one until released, but waited %s", queued)
+}
+
+func TestServer_NoTimings(t *testing.T) {
+	fromCtx := make(chan bool, 1)
+	server := NewServer(WithInterceptors(func(ctx context.Context, serviceMethod string, args interface{}, next func(ctx context.Context) error) error {
+		_, ok := TimingsFromContext(ctx)
+		fromCtx <- ok
+		return next(ctx)
+	}))
+	lis := startServer(t, server)
+	client, err := Dial("tcp", lis.Addr().String(), &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, new(int)) == nil, "failed to call Foo.Sum")
+	_assert(!<-fromCtx, "expect no timings without a collector")
+}
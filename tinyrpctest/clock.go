@@ -0,0 +1,140 @@
+// Package tinyrpctest provides helpers for testing code built on tinyrpc.
+package tinyrpctest
+
+import (
+	"sync"
+	"time"
+	"tinyrpc/internal/clock"
+)
+
+// Clock is a fake clock, for Option.Clock, Server.SetClock and
+// TinyRegistry.SetClock. Its time only moves when Advance is called, which
+// fires the timers and tickers that are due.
+type Clock struct {
+	mu      sync.Mutex // protect following
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*waiter
+}
+
+// waiter is a timer, or a ticker if period is not 0.
+type waiter struct {
+	c        chan time.Time
+	deadline time.Time
+	period   time.Duration
+}
+
+var _ clock.Clock = (*Clock)(nil)
+
+// NewClock returns a Clock set to a fixed time.
+func NewClock() *Clock {
+	c := &Clock{now: time.Unix(1700000000, 0)}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Clock) add(d, period time.Duration) *waiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &waiter{c: make(chan time.Time, 1), deadline: c.now.Add(d), period: period}
+	c.waiters = append(c.waiters, w)
+	c.cond.Broadcast()
+	return w
+}
+
+func (c *Clock) remove(w *waiter) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range c.waiters {
+		if other == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// NewTimer returns a timer firing once the clock is advanced by d.
+func (c *Clock) NewTimer(d time.Duration) clock.Timer {
+	return &fakeTimer{c, c.add(d, 0)}
+}
+
+// After returns the channel of a new timer.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// NewTicker returns a ticker firing every d the clock is advanced by.
+// Like time.Ticker, it drops the ticks of a slow receiver.
+func (c *Clock) NewTicker(d time.Duration) clock.Ticker {
+	if d <= 0 {
+		panic("tinyrpctest: non-positive interval for NewTicker")
+	}
+	return &fakeTicker{c, c.add(d, d)}
+}
+
+// Advance moves the clock forward by d and fires the timers and tickers
+// that are due.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.deadline.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		select {
+		case w.c <- c.now:
+		default:
+		}
+		if w.period > 0 {
+			for !w.deadline.After(c.now) {
+				w.deadline = w.deadline.Add(w.period)
+			}
+			waiters = append(waiters, w)
+		}
+	}
+	c.waiters = waiters
+}
+
+// Waiters returns the number of timers and tickers not fired or stopped.
+func (c *Clock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// BlockUntil waits until at least n timers and tickers are pending, for
+// tests to advance the clock only once the code under test waits on it.
+func (c *Clock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+type fakeTimer struct {
+	clock *Clock
+	w     *waiter
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.w.c }
+func (t *fakeTimer) Stop() bool          { return t.clock.remove(t.w) }
+
+type fakeTicker struct {
+	clock *Clock
+	w     *waiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.w.c }
+func (t *fakeTicker) Stop()               { t.clock.remove(t.w) }
@@ -0,0 +1,64 @@
+package tinyrpctest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+	"tinyrpc/internal/clock"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func fired(c <-chan time.Time) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestClock_Timers(t *testing.T) {
+	c := NewClock()
+	start := c.Now()
+	timer, after := c.NewTimer(time.Second), c.After(2*time.Second)
+	stopped := c.NewTimer(time.Second)
+	_assert(stopped.Stop() && !stopped.Stop(), "expect Stop to report a pending timer once")
+	c.Advance(time.Second)
+	_assert(fired(timer.C()) && !fired(after) && !fired(stopped.C()), "expect only the first timer fired")
+	c.Advance(time.Second)
+	_assert(fired(after) && c.Now().Sub(start) == 2*time.Second && c.Waiters() == 0, "expect every timer fired")
+}
+
+func TestClock_Ticker(t *testing.T) {
+	c := NewClock()
+	ticker := c.NewTicker(time.Second)
+	c.Advance(time.Second)
+	_assert(fired(ticker.C()), "expect a tick")
+	c.Advance(3 * time.Second) // the ticks of a slow receiver are dropped
+	_assert(fired(ticker.C()) && !fired(ticker.C()), "expect one tick kept")
+	ticker.Stop()
+	c.Advance(time.Second)
+	_assert(!fired(ticker.C()) && c.Waiters() == 0, "expect no tick once stopped")
+}
+
+func TestWithTimeout(t *testing.T) {
+	c := NewClock()
+	ctx, cancel := clock.WithTimeout(c, context.Background(), time.Minute)
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	_assert(ok && deadline.Equal(c.Now().Add(time.Minute)), "expect a deadline of the fake clock, but got %v", deadline)
+	c.BlockUntil(1)
+	c.Advance(time.Minute)
+	<-ctx.Done()
+	_assert(ctx.Err() == context.DeadlineExceeded, "expect a deadline error, but got %v", ctx.Err())
+
+	ctx, cancel = clock.WithTimeout(c, context.Background(), time.Minute)
+	cancel()
+	_assert(ctx.Err() == context.Canceled, "expect a canceled context, but got %v", ctx.Err())
+}
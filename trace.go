@@ -0,0 +1,74 @@
+package tinyrpc
+
+import "context"
+
+// ClientTrace is a set of hooks run at the stages of the connection and
+// the calls of a client, like net/http/httptrace. Any of them may be nil.
+// They are run without the locks of the client held, some of them by the
+// goroutine reading the responses, so they should return quickly.
+//
+// The hooks of the connection are taken from the context of DialContext,
+// those of a call from the context of CallContext, or else from the
+// context the client was dialed with.
+type ClientTrace struct {
+	// DialStart is called before connecting to address.
+	DialStart func(network, address string)
+	// DialDone is called once connected, or with the error of connecting.
+	DialDone func(network, address string, err error)
+	// HandshakeDone is called once the handshake is over, with the state
+	// agreed on or with its error.
+	HandshakeDone func(state ConnState, err error)
+	// WroteRequest is called once the request is written, with the error
+	// of writing it.
+	WroteRequest func(serviceMethod string, seq uint64, err error)
+	// GotResponseHeader is called when the header of the response is
+	// read, with the error answered by the server.
+	GotResponseHeader func(serviceMethod string, seq uint64, err error)
+	// GotResponseBody is called when the reply of a successful call is
+	// read, with the error of decoding it.
+	GotResponseBody func(serviceMethod string, seq uint64, err error)
+	// ConnClosed is called when the connection is over, with the error
+	// failing the calls still pending.
+	ConnClosed func(err error)
+}
+
+type clientTraceKey struct{}
+
+// WithClientTrace returns a context carrying trace, for DialContext and
+// CallContext.
+func WithClientTrace(ctx context.Context, trace *ClientTrace) context.Context {
+	return context.WithValue(ctx, clientTraceKey{}, trace)
+}
+
+// ContextClientTrace returns the ClientTrace of ctx, nil if none.
+func ContextClientTrace(ctx context.Context) *ClientTrace {
+	trace, _ := ctx.Value(clientTraceKey{}).(*ClientTrace)
+	return trace
+}
+
+// traceOption traces a call with the ClientTrace of its context.
+type traceOption struct{ trace *ClientTrace }
+
+func (o traceOption) before(call *Call) { call.trace = o.trace }
+func (o traceOption) after(*Call)       {}
+
+// ServerTrace is a set of hooks run at the stages of the connections and
+// the calls of a server, the built-in services included, set with
+// WithServerTrace. Any of them may be nil. They are run without the locks
+// of the server held, by the goroutines serving the connections, so they
+// should return quickly.
+type ServerTrace struct {
+	// ConnAccepted is called when a connection is accepted, before its
+	// handshake.
+	ConnAccepted func(remoteAddr string)
+	// GotRequestHeader is called when the header of a request is read.
+	GotRequestHeader func(serviceMethod string, seq uint64)
+	// HandlerStart is called before the interceptors and the method of a
+	// valid request are called.
+	HandlerStart func(serviceMethod string, seq uint64)
+	// HandlerDone is called once they returned, with their error.
+	HandlerDone func(serviceMethod string, seq uint64, err error)
+	// WroteResponse is called once a response is written, with the error
+	// of writing it.
+	WroteResponse func(serviceMethod string, seq uint64, err error)
+}
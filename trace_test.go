@@ -0,0 +1,116 @@
+package tinyrpc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// traceLog records the hooks called, in order.
+type traceLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *traceLog) add(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, fmt.Sprintf(format, args...))
+}
+
+func (l *traceLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.events...)
+}
+
+func (l *traceLog) clientTrace() *ClientTrace {
+	return &ClientTrace{
+		DialStart:     func(network, address string) { l.add("DialStart %s", network) },
+		DialDone:      func(network, address string, err error) { l.add("DialDone %v", err) },
+		HandshakeDone: func(state ConnState, err error) { l.add("HandshakeDone %s %v", state.Codec, err) },
+		WroteRequest: func(serviceMethod string, seq uint64, err error) {
+			l.add("WroteRequest %s %d %v", serviceMethod, seq, err)
+		},
+		GotResponseHeader: func(serviceMethod string, seq uint64, err error) {
+			l.add("GotResponseHeader %s %d %v", serviceMethod, seq, err)
+		},
+		GotResponseBody: func(serviceMethod string, seq uint64, err error) {
+			l.add("GotResponseBody %s %d %v", serviceMethod, seq, err)
+		},
+		ConnClosed: func(err error) { l.add("ConnClosed") },
+	}
+}
+
+func TestTrace(t *testing.T) {
+	var server traceLog
+	wrote := make(chan struct{}, 1)
+	lis := startServer(t, NewServer(WithServerTrace(&ServerTrace{
+		ConnAccepted:     func(remoteAddr string) { server.add("ConnAccepted") },
+		GotRequestHeader: func(serviceMethod string, seq uint64) { server.add("GotRequestHeader %s %d", serviceMethod, seq) },
+		HandlerStart:     func(serviceMethod string, seq uint64) { server.add("HandlerStart %s %d", serviceMethod, seq) },
+		HandlerDone: func(serviceMethod string, seq uint64, err error) {
+			server.add("HandlerDone %s %d %v", serviceMethod, seq, err)
+		},
+		WroteResponse: func(serviceMethod string, seq uint64, err error) {
+			server.add("WroteResponse %s %d %v", serviceMethod, seq, err)
+			wrote <- struct{}{}
+		},
+	})))
+
+	var client traceLog
+	ctx := WithClientTrace(context.Background(), client.clientTrace())
+	c, err := DialContext(ctx, "tcp", lis.Addr().String(), &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	var reply int
+	err = c.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "call error: %v, reply %d", err, reply)
+	<-wrote
+	_ = c.Close()
+	<-c.done
+	got := client.get()
+	want := []string{
+		"DialStart tcp",
+		"DialDone <nil>",
+		"HandshakeDone application/gob <nil>",
+		"WroteRequest Foo.Sum 1 <nil>",
+		"GotResponseHeader Foo.Sum 1 <nil>",
+		"GotResponseBody Foo.Sum 1 <nil>",
+		"ConnClosed",
+	}
+	_assert(reflect.DeepEqual(got, want), "client trace %q, expect %q", got, want)
+	got = server.get()
+	want = []string{
+		"ConnAccepted",
+		"GotRequestHeader Foo.Sum 1",
+		"HandlerStart Foo.Sum 1",
+		"HandlerDone Foo.Sum 1 <nil>",
+		"WroteResponse Foo.Sum 1 <nil>",
+	}
+	_assert(reflect.DeepEqual(got, want), "server trace %q, expect %q", got, want)
+}
+
+func TestTrace_PerCall(t *testing.T) {
+	lis := startServer(t, NewServer())
+	// nil hooks are skipped
+	ctx := WithClientTrace(context.Background(), &ClientTrace{})
+	c, err := DialContext(ctx, "tcp", lis.Addr().String(), &Option{HeartbeatIdle: -1})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = c.Close() }()
+
+	var calls traceLog
+	ctx, cancel := context.WithTimeout(WithClientTrace(context.Background(), calls.clientTrace()), time.Second)
+	defer cancel()
+	var reply int
+	err = c.CallContext(ctx, "Foo.Missing", Args{}, &reply)
+	_assert(err != nil, "expect an error for an unknown method")
+	got := calls.get()
+	want := []string{
+		"WroteRequest Foo.Missing 1 <nil>",
+		"GotResponseHeader Foo.Missing 1 rpc server: can't find method Missing",
+	}
+	_assert(reflect.DeepEqual(got, want), "call trace %q, expect %q", got, want)
+}
@@ -0,0 +1,92 @@
+package tinyrpc
+
+import (
+	"encoding/gob"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// typeRegistry records the concrete types passed to RegisterType, so that
+// interface-typed fields of args and replies can be checked at Register time.
+var typeRegistry struct {
+	sync.RWMutex
+	types map[reflect.Type]struct{}
+}
+
+// RegisterType records the concrete types of values and registers them
+// with gob, so they can be transmitted as the dynamic value of an
+// interface-typed field in args or replies.
+func RegisterType(values ...interface{}) {
+	typeRegistry.Lock()
+	defer typeRegistry.Unlock()
+	if typeRegistry.types == nil {
+		typeRegistry.types = make(map[reflect.Type]struct{})
+	}
+	for _, v := range values {
+		gob.Register(v)
+		typeRegistry.types[reflect.TypeOf(v)] = struct{}{}
+	}
+}
+
+// RegisteredTypes returns the names of all types passed to RegisterType, sorted.
+func RegisteredTypes() []string {
+	typeRegistry.RLock()
+	defer typeRegistry.RUnlock()
+	names := make([]string, 0, len(typeRegistry.types))
+	for t := range typeRegistry.types {
+		names = append(names, t.String())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hasRegisteredImpl reports whether any registered type implements iface.
+func hasRegisteredImpl(iface reflect.Type) bool {
+	typeRegistry.RLock()
+	defer typeRegistry.RUnlock()
+	for t := range typeRegistry.types {
+		if t.Implements(iface) {
+			return true
+		}
+	}
+	return false
+}
+
+// unregisteredInterfaceFields walks t and returns the path of every
+// interface-typed field that no type passed to RegisterType implements.
+// gob fails on those at runtime with "type not registered for interface".
+// Empty interfaces are skipped: every type implements them, and gob
+// pre-registers the builtin types they often carry. Types registered
+// directly with gob.Register are not tracked.
+func unregisteredInterfaceFields(t reflect.Type) []string {
+	var fields []string
+	walkInterfaceFields(t, t.String(), make(map[reflect.Type]bool), &fields)
+	return fields
+}
+
+func walkInterfaceFields(t reflect.Type, path string, seen map[reflect.Type]bool, fields *[]string) {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		walkInterfaceFields(t.Elem(), path, seen, fields)
+	case reflect.Map:
+		walkInterfaceFields(t.Key(), path, seen, fields)
+		walkInterfaceFields(t.Elem(), path, seen, fields)
+	case reflect.Interface:
+		if t.NumMethod() > 0 && !hasRegisteredImpl(t) {
+			*fields = append(*fields, path)
+		}
+	case reflect.Struct:
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue // gob ignores unexported fields
+			}
+			walkInterfaceFields(f.Type, path+"."+f.Name, seen, fields)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package tinyrpc
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type Shape interface{ Area() float64 }
+
+type Named interface{ Name() string }
+
+// Drawing's interfaces are never passed to RegisterType.
+type Drawing struct {
+	Shapes []Shape
+	Label  Named
+	Extra  interface{}
+}
+
+type Sized interface{ Size() int }
+
+type Box struct{ W, H int }
+
+func (b Box) Size() int { return b.W * b.H }
+
+type Parcel struct {
+	Content Sized
+	Note    Named
+}
+
+type Painter int
+
+func (p Painter) Paint(args Drawing, reply *int) error { return nil }
+
+type Measurer int
+
+func (m Measurer) Measure(args Parcel, reply *int) error {
+	*reply = args.Content.Size()
+	return nil
+}
+
+func TestUnregisteredInterfaceFields(t *testing.T) {
+	fields := unregisteredInterfaceFields(reflect.TypeOf(Drawing{}))
+	want := []string{"tinyrpc.Drawing.Shapes", "tinyrpc.Drawing.Label"}
+	_assert(reflect.DeepEqual(fields, want), "expect %v, but got %v", want, fields)
+
+	RegisterType(Box{})
+	fields = unregisteredInterfaceFields(reflect.TypeOf(&Parcel{}))
+	want = []string{"*tinyrpc.Parcel.Note"}
+	_assert(reflect.DeepEqual(fields, want), "expect %v, but got %v", want, fields)
+
+	names := RegisteredTypes()
+	found := false
+	for _, name := range names {
+		found = found || name == "tinyrpc.Box"
+	}
+	_assert(found, "expect tinyrpc.Box in registered types, but got %v", names)
+}
+
+func TestRegister_WarnsUnregisteredInterface(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	var p Painter
+	_ = NewServer().Register(&p)
+	out := buf.String()
+	_assert(strings.Contains(out, "tinyrpc.Drawing.Shapes") && strings.Contains(out, "tinyrpc.Drawing.Label"),
+		"expect warnings for Shapes and Label, but got %q", out)
+	_assert(!strings.Contains(out, "Extra"), "expect no warning for an empty interface, but got %q", out)
+}
+
+func TestRegisterType_Call(t *testing.T) {
+	RegisterType(Box{})
+	var m Measurer
+	server := NewServer()
+	_ = server.Register(&m)
+	lis, err := server.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	go server.Accept(lis)
+	defer func() { _ = lis.Close() }()
+
+	client, err := Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call("Measurer.Measure", Parcel{Content: Box{W: 2, H: 3}}, &reply)
+	_assert(err == nil && reply == 6, "failed to call through an interface field: %v", err)
+}
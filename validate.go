@@ -0,0 +1,92 @@
+package tinyrpc
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Validator is implemented by arguments that check themselves. The server
+// calls Validate on the decoded arguments of a call before its method, and
+// fails the call with ErrInvalidArgument, without calling the method, if
+// it returns an error. Its message, e.g. naming the invalid field, is sent
+// to the client.
+type Validator interface {
+	Validate() error
+}
+
+// SetValidator sets fn to check the decoded arguments of every call to a
+// registered method, after their own Validate method if they have one.
+// Errors fail the call like those of Validate. It must be called before
+// the server starts serving.
+func (server *Server) SetValidator(fn func(serviceMethod string, args interface{}) error) {
+	server.validator = fn
+}
+
+// validate checks the arguments of req, returning an error matching
+// ErrInvalidArgument if they are invalid. Calls to the raw handler have no
+// decoded arguments and are not checked.
+func (server *Server) validate(req *request) error {
+	if req.raw != nil {
+		return nil
+	}
+	if err := validateArgs(req.mtype.validates, req.argv); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidArgument, err)
+	}
+	if server.validator != nil {
+		if err := server.validator(req.h.ServiceMethod, req.argv.Interface()); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidArgument, err)
+		}
+	}
+	return nil
+}
+
+// validatorKind tells how an argument type implements Validator, so that
+// calls don't look it up.
+type validatorKind uint8
+
+const (
+	noValidator      validatorKind = iota
+	valueValidator                 // the type has Validate
+	pointerValidator               // only its pointer type has Validate
+	dynamicValidator               // an interface, its dynamic type may have Validate
+)
+
+var typeOfValidator = reflect.TypeOf((*Validator)(nil)).Elem()
+
+func validatorKindOf(t reflect.Type) validatorKind {
+	switch {
+	case t.Kind() == reflect.Interface:
+		return dynamicValidator
+	case t.Implements(typeOfValidator):
+		return valueValidator
+	case reflect.PtrTo(t).Implements(typeOfValidator):
+		return pointerValidator
+	}
+	return noValidator
+}
+
+// validateArgs calls the Validate method of argv, which may be declared
+// on its pointer type.
+func validateArgs(kind validatorKind, argv reflect.Value) error {
+	switch kind {
+	case noValidator:
+		return nil
+	case pointerValidator:
+		if argv.CanAddr() {
+			return argv.Addr().Interface().(Validator).Validate()
+		}
+		return nil
+	}
+	if argv.Kind() == reflect.Ptr && argv.IsNil() {
+		return nil
+	}
+	if v, ok := argv.Interface().(Validator); ok {
+		return v.Validate()
+	}
+	if argv.CanAddr() {
+		if v, ok := argv.Addr().Interface().(Validator); ok {
+			return v.Validate()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package tinyrpc
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type Account struct {
+	Name    string
+	Balance int
+}
+
+func (a *Account) Validate() error {
+	if a.Name == "" {
+		return errors.New("name: must not be empty")
+	}
+	return nil
+}
+
+type Bank struct{ calls int32 }
+
+func (b *Bank) Open(args Account, reply *string) error {
+	atomic.AddInt32(&b.calls, 1)
+	*reply = args.Name
+	return nil
+}
+
+func (b *Bank) Deposit(args *Account, reply *int) error {
+	atomic.AddInt32(&b.calls, 1)
+	*reply = args.Balance
+	return nil
+}
+
+func TestServer_Validate(t *testing.T) {
+	server := NewServer()
+	var bank Bank
+	_ = server.Register(&bank)
+	server.SetValidator(func(serviceMethod string, args interface{}) error {
+		if a, ok := args.(*Account); ok && serviceMethod == "Bank.Deposit" && a.Balance < 0 {
+			return errors.New("balance: must not be negative")
+		}
+		return nil
+	})
+	client, err := Dial("tcp", startServer(t, server).Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var name string
+	err = client.Call("Bank.Open", Account{}, &name)
+	_assert(errors.Is(err, ErrInvalidArgument) && strings.HasSuffix(err.Error(), "name: must not be empty"),
+		"expect an invalid argument error naming the field, but got %v", err)
+	var balance int
+	err = client.Call("Bank.Deposit", &Account{Name: "a", Balance: -1}, &balance)
+	_assert(errors.Is(err, ErrInvalidArgument) && strings.HasSuffix(err.Error(), "balance: must not be negative"),
+		"expect the server validator to run, but got %v", err)
+	err = client.Call("Bank.Deposit", &Account{Balance: 1}, &balance)
+	_assert(errors.Is(err, ErrInvalidArgument), "expect Validate of a pointer argument to run, but got %v", err)
+	_assert(atomic.LoadInt32(&bank.calls) == 0, "expect the handler never called")
+	_assert(server.Stats().Invalid == 3, "expect 3 invalid requests, but got %d", server.Stats().Invalid)
+
+	err = client.Call("Bank.Open", Account{Name: "a"}, &name)
+	_assert(err == nil && name == "a" && atomic.LoadInt32(&bank.calls) == 1, "failed to call Bank.Open: %v", err)
+}
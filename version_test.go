@@ -0,0 +1,38 @@
+package tinyrpc
+
+import "testing"
+
+func TestProtocolVersion(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		client, server int // highest versions, 2 stands for a later release
+		want           int
+	}{
+		{"current", 0, 0, ProtocolVersion},
+		{"newer client", 2, 0, 1},
+		{"newer server", 0, 2, 1},
+		{"both newer", 2, 2, 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer()
+			server.maxVersion = tt.server
+			client, err := Dial("tcp", startServer(t, server).Addr().String(), &Option{HeartbeatIdle: -1, ProtocolVersion: tt.client})
+			_assert(err == nil, "dial error: %v", err)
+			defer func() { _ = client.Close() }()
+			var reply int
+			err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+			_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+			_assert(client.ProtocolVersion() == tt.want, "expect the client to speak %d, but got %d", tt.want, client.ProtocolVersion())
+			conns := server.Connections()
+			_assert(len(conns) == 1 && conns[0].Version == tt.want, "expect the server to speak %d, but got %+v", tt.want, conns)
+		})
+	}
+}
+
+func TestProtocolVersion_UnversionedServer(t *testing.T) {
+	// servers before versions answer without one
+	v := agreedVersion(&Option{ProtocolVersion: 2}, &handshakeReply{Accepted: true})
+	_assert(v == 1, "expect version 1 with an unversioned server, but got %d", v)
+	v = agreedVersion(&Option{}, nil)
+	_assert(v == 1, "expect version 1 without an answer, but got %d", v)
+}
@@ -0,0 +1,325 @@
+package tinyrpc
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"tinyrpc/rpclog"
+)
+
+// The WebSocket transport carries the usual byte stream (options followed
+// by codec frames) in binary messages, see RFC 6455. Message boundaries
+// carry no meaning: each message is just the next chunk of the stream.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// wsConn adapts a WebSocket connection to a byte stream.
+type wsConn struct {
+	net.Conn
+	br     *bufio.Reader
+	client bool // clients mask the frames they send
+
+	wmu sync.Mutex // protect writing frames
+
+	remain  uint64  // unread payload bytes of the current frame
+	masked  bool    // whether the current frame is masked
+	maskKey [4]byte // mask key of the current frame
+	maskPos int     // position in maskKey of the next payload byte
+}
+
+var _ net.Conn = (*wsConn)(nil)
+
+func newWSConn(conn net.Conn, br *bufio.Reader, client bool) *wsConn {
+	return &wsConn{Conn: conn, br: br, client: client}
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for c.remain == 0 {
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if uint64(len(p)) > c.remain {
+		p = p[:c.remain]
+	}
+	n, err := c.br.Read(p)
+	c.unmask(p[:n])
+	c.remain -= uint64(n)
+	return n, err
+}
+
+// nextFrame reads the next frame header, answering control frames on the way.
+func (c *wsConn) nextFrame() error {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return err
+	}
+	opcode := head[0] & 0x0f
+	c.masked = head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if c.masked {
+		if _, err := io.ReadFull(c.br, c.maskKey[:]); err != nil {
+			return err
+		}
+	}
+	c.maskPos = 0
+	if !c.client && !c.masked {
+		return errors.New("rpc websocket: unmasked client frame")
+	}
+	switch opcode {
+	case wsContinuation, wsBinary:
+		c.remain = length
+		return nil
+	case wsText:
+		return errors.New("rpc websocket: text frames are not supported")
+	case wsPing, wsPong, wsClose:
+		if length > 125 {
+			return errors.New("rpc websocket: control frame too long")
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return err
+		}
+		c.unmask(payload)
+		switch opcode {
+		case wsPing:
+			return c.writeFrame(wsPong, payload)
+		case wsClose:
+			_ = c.writeFrame(wsClose, payload)
+			return io.EOF
+		}
+		return nil
+	default:
+		return fmt.Errorf("rpc websocket: unknown opcode %d", opcode)
+	}
+}
+
+func (c *wsConn) unmask(p []byte) {
+	if !c.masked {
+		return
+	}
+	for i := range p {
+		p[i] ^= c.maskKey[c.maskPos&3]
+		c.maskPos++
+	}
+}
+
+// Write sends p as one binary message.
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(wsBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if !c.client {
+		frame = append(frame, payload...)
+	} else {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		frame = append(frame, key[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^key[i&3])
+		}
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// wsCloseTimeout bounds the best-effort close frame sent by Close.
+const wsCloseTimeout = time.Second
+
+// Close sends a close frame on a best-effort basis, then closes the connection.
+// The write deadline also unblocks a Write stuck on an unresponsive peer.
+func (c *wsConn) Close() error {
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(wsCloseTimeout))
+	_ = c.writeFrame(wsClose, nil)
+	return c.Conn.Close()
+}
+
+func websocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WebSocketHandler returns an http.Handler that upgrades requests to
+// WebSocket and serves RPC requests over them.
+func (server *Server) WebSocketHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get("Sec-WebSocket-Key")
+		if req.Method != http.MethodGet || key == "" ||
+			!headerContains(req.Header, "Connection", "upgrade") ||
+			!headerContains(req.Header, "Upgrade", "websocket") {
+			http.Error(w, "rpc websocket: not a websocket handshake", http.StatusBadRequest)
+			return
+		}
+		if req.Header.Get("Sec-WebSocket-Version") != "13" {
+			w.Header().Set("Sec-WebSocket-Version", "13")
+			http.Error(w, "rpc websocket: unsupported version", http.StatusUpgradeRequired)
+			return
+		}
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "rpc websocket: connection cannot be hijacked", http.StatusInternalServerError)
+			return
+		}
+		conn, buf, err := hj.Hijack()
+		if err != nil {
+			server.log(rpclog.LevelError, "websocket hijacking error", "remote", req.RemoteAddr, "err", err)
+			return
+		}
+		// hijacked connections keep the deadlines set by http.Server
+		_ = conn.SetDeadline(time.Time{})
+		_, _ = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+			"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: "+websocketAccept(key)+"\r\n\r\n")
+		server.ServeConn(newWSConn(conn, buf.Reader, false))
+	})
+}
+
+// DialWebSocket connects to an RPC server behind a WebSocketHandler
+// at the given ws:// or wss:// url. wss:// uses Option.TLSConfig if set.
+func DialWebSocket(rawURL string, opts ...ClientOption) (client *Client, err error) {
+	cfg, err := parseClientOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	opt := &cfg.opt
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "wss" {
+			host += ":443"
+		} else {
+			host += ":80"
+		}
+	}
+	conn, err := dialConn(context.Background(), opt, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = conn.Close()
+		}
+	}()
+	switch u.Scheme {
+	case "ws":
+	case "wss":
+		cfg := &tls.Config{}
+		if opt.TLSConfig != nil {
+			cfg = opt.TLSConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		conn = tls.Client(conn, cfg)
+	default:
+		return nil, fmt.Errorf("rpc websocket: unsupported scheme %q", u.Scheme)
+	}
+
+	var nonce [16]byte
+	if _, err = rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: u.Path, RawQuery: u.RawQuery},
+		Host:   u.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	if err = req.Write(conn); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, errors.New("rpc websocket: unexpected HTTP response: " + resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		return nil, errors.New("rpc websocket: invalid Sec-WebSocket-Accept")
+	}
+	return newClient(newWSConn(conn, br, true), cfg)
+}
@@ -0,0 +1,61 @@
+package tinyrpc
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebSocket(t *testing.T) {
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	ts := httptest.NewServer(server.WebSocketHandler())
+	defer ts.Close()
+
+	client, err := DialWebSocket("ws" + strings.TrimPrefix(ts.URL, "http") + "/rpc")
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = client.Close() }()
+	for i := 0; i < 3; i++ {
+		var reply int
+		err = client.Call("Foo.Sum", Args{Num1: i, Num2: 2}, &reply)
+		_assert(err == nil && reply == i+2, "failed to call Foo.Sum: %v", err)
+	}
+}
+
+func TestWSConn_Stream(t *testing.T) {
+	a, b := net.Pipe()
+	client := newWSConn(a, bufio.NewReader(a), true)
+	server := newWSConn(b, bufio.NewReader(b), false)
+	defer func() {
+		// nobody reads the pipe any more, Close must not hang on the close frame
+		go func() { _, _ = io.Copy(io.Discard, server) }()
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	// exercise the 7 bit, 16 bit and 64 bit payload lengths
+	for _, n := range []int{10, 1000, 70000} {
+		data := bytes.Repeat([]byte{byte(n)}, n)
+		go func() { _, _ = client.Write(data) }()
+		got := make([]byte, n)
+		_, err := io.ReadFull(server, got)
+		_assert(err == nil && bytes.Equal(got, data), "payload of %d bytes mismatch: %v", n, err)
+	}
+}
+
+func TestWSConn_RejectUnmasked(t *testing.T) {
+	a, b := net.Pipe()
+	peer := newWSConn(a, bufio.NewReader(a), false) // server side framing, so no mask
+	server := newWSConn(b, bufio.NewReader(b), false)
+	defer func() { _ = a.Close(); _ = b.Close() }()
+	go func() { _, _ = peer.Write([]byte("hello")) }()
+	_, err := server.Read(make([]byte, 5))
+	_assert(err != nil, "expect an error for an unmasked client frame")
+}
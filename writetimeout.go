@@ -0,0 +1,63 @@
+package tinyrpc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+	"tinyrpc/rpclog"
+)
+
+// DefaultWriteTimeout bounds the writes of a client to its connection,
+// see Option.WriteTimeout.
+const DefaultWriteTimeout = 30 * time.Second
+
+// writeTimeout returns the write timeout of opt, 0 if none.
+func (opt *Option) writeTimeout() time.Duration {
+	switch {
+	case opt.WriteTimeout == 0:
+		return DefaultWriteTimeout
+	case opt.WriteTimeout < 0:
+		return 0
+	}
+	return opt.WriteTimeout
+}
+
+// writeTimeoutConn bounds every write to its conn by timeout, so that a
+// server whose buffers are full can't block the calls sharing the
+// connection forever. Deadlines are set with the real clock.
+type writeTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+	client  atomic.Pointer[Client] // nil during the handshake
+}
+
+func (c *writeTimeoutConn) Write(p []byte) (int, error) {
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	n, err := c.Conn.Write(p)
+	var ne net.Error
+	if err != nil && errors.As(err, &ne) && ne.Timeout() {
+		err = fmt.Errorf("%w: wrote %d of %d bytes in %s", ErrWriteTimeout, n, len(p), c.timeout)
+		if client := c.client.Load(); client != nil {
+			client.suspect(err)
+		} else {
+			_ = c.Conn.Close()
+		}
+	}
+	return n, err
+}
+
+// suspect closes the connection after a write failed with err: part of a
+// frame may have been written, so nothing can follow it. The pending
+// calls fail with err, and the client is no longer available.
+func (client *Client) suspect(err error) {
+	client.config.log(rpclog.LevelWarn, "write timeout, closing connection", "err", err)
+	client.mu.Lock()
+	if client.closeErr == nil {
+		client.closeErr = err
+	}
+	client.shutdown = true // no call is sent after it
+	client.mu.Unlock()
+	_ = client.cc.Close()
+}
@@ -0,0 +1,47 @@
+package tinyrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+	"tinyrpc/codec"
+)
+
+func TestClient_WriteTimeout(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer func() { _ = peer.Close() }()
+	go func() {
+		var opt Option
+		_ = json.NewDecoder(peer).Decode(&opt) // then stops reading
+	}()
+	client, err := NewClient(conn, &Option{MagicNumber: MagicNumber, CodecType: codec.GobType, HeartbeatIdle: -1, WriteTimeout: 50 * time.Millisecond})
+	_assert(err == nil, "client error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	start := time.Now()
+	stuck := client.Go("Foo.Sum", Args{Num1: 1, Num2: 2}, new(int), nil)
+	queued := client.Go("Foo.Sum", Args{Num1: 3, Num2: 4}, new(int), nil)
+	for _, call := range []*Call{stuck, queued} {
+		select {
+		case call = <-call.Done:
+			// the queued call is sent before or after the timeout
+			ok := errors.Is(call.Error, ErrWriteTimeout) || (call == queued && errors.Is(call.Error, ErrShutdown))
+			_assert(ok, "expect ErrWriteTimeout, but got %v", call.Error)
+		case <-time.After(time.Second):
+			t.Fatal("expect the calls to fail once the write timed out")
+		}
+	}
+	_assert(time.Since(start) < 500*time.Millisecond, "expect the write timeout, but took %s", time.Since(start))
+	_assert(!client.IsAvailable(), "expect the connection closed")
+	err = client.Call("Foo.Sum", Args{Num1: 1, Num2: 2}, new(int))
+	_assert(errors.Is(err, ErrShutdown), "expect the connection not reused, but got %v", err)
+}
+
+func TestClient_WriteTimeoutDisabled(t *testing.T) {
+	opt := &Option{WriteTimeout: -1}
+	_assert(opt.writeTimeout() == 0, "expect no write timeout")
+	opt.WriteTimeout = 0
+	_assert(opt.writeTimeout() == DefaultWriteTimeout, "expect DefaultWriteTimeout")
+}
@@ -0,0 +1,153 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+	"tinyrpc"
+)
+
+// DefaultSessionTTL is how long an unused session stays pinned.
+const DefaultSessionTTL = 30 * time.Minute
+
+// sessions pins session IDs to servers for AffinitySelect.
+type sessions struct {
+	mu       sync.Mutex // protect following
+	pins     map[string]*pin
+	ttl      time.Duration
+	lastGC   time.Time
+	onRepin  func(sessionID, oldAddr, newAddr string)
+	disabled map[string]time.Time // servers that failed, and when
+	r        *rand.Rand           // pick the server of new sessions
+}
+
+// failCooldown is how long a failed server gets no new sessions.
+const failCooldown = 30 * time.Second
+
+type pin struct {
+	addr    string
+	lastUse time.Time
+}
+
+// SetSessionTTL sets how long an unused session stays pinned,
+// DefaultSessionTTL if d is 0.
+func (xc *XClient) SetSessionTTL(d time.Duration) {
+	xc.sessions.mu.Lock()
+	defer xc.sessions.mu.Unlock()
+	xc.sessions.ttl = d
+}
+
+// OnRepin sets a callback invoked when a session moves from oldAddr to
+// newAddr, because oldAddr left the discovery or failed, so the
+// application can rebuild the per-session state on the new server.
+func (xc *XClient) OnRepin(fn func(sessionID, oldAddr, newAddr string)) {
+	xc.sessions.mu.Lock()
+	defer xc.sessions.mu.Unlock()
+	xc.sessions.onRepin = fn
+}
+
+// CallWithSession is like Call, but all calls with the same sessionID go
+// to the same server until that server leaves the discovery or can't be
+// reached, then the session is pinned to another server.
+func (xc *XClient) CallWithSession(ctx context.Context, sessionID, serviceMethod string, args, reply interface{}, opts ...tinyrpc.CallOption) error {
+	rpcAddr, err := xc.sessionAddr(sessionID)
+	if err != nil {
+		return err
+	}
+	err = xc.call(rpcAddr, ctx, serviceMethod, args, reply, opts...)
+	if err != nil && isTransportError(err) {
+		xc.sessions.fail(rpcAddr)
+	}
+	return err
+}
+
+// sessionAddr returns the server sessionID is pinned to, pinning it first if needed.
+func (xc *XClient) sessionAddr(sessionID string) (string, error) {
+	servers, err := xc.getAll()
+	if err != nil {
+		return "", err
+	}
+	s := &xc.sessions
+	s.mu.Lock()
+	now := time.Now()
+	s.gc(now)
+	live := make(map[string]bool, len(servers))
+	for _, addr := range servers {
+		live[addr] = true
+	}
+	for addr, at := range s.disabled {
+		if !live[addr] || now.Sub(at) > failCooldown {
+			delete(s.disabled, addr)
+		}
+	}
+	old := s.pins[sessionID]
+	if old != nil && now.Sub(old.lastUse) > s.sessionTTL() {
+		old = nil // expired, a new session rather than a re-pin
+	}
+	if old != nil && live[old.addr] {
+		if _, failed := s.disabled[old.addr]; !failed {
+			old.lastUse = now
+			s.mu.Unlock()
+			return old.addr, nil
+		}
+	}
+	var candidates []string
+	for _, addr := range servers {
+		if _, failed := s.disabled[addr]; !failed {
+			candidates = append(candidates, addr)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = servers // all failed, try them again rather than nothing
+	}
+	if len(candidates) == 0 {
+		s.mu.Unlock()
+		return "", errors.New("rpc discovery: no available servers")
+	}
+	if s.pins == nil {
+		s.pins = make(map[string]*pin)
+		s.r = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	addr := candidates[s.r.Intn(len(candidates))]
+	s.pins[sessionID] = &pin{addr: addr, lastUse: now}
+	onRepin := s.onRepin
+	s.mu.Unlock()
+	if old != nil && onRepin != nil {
+		onRepin(sessionID, old.addr, addr)
+	}
+	return addr, nil
+}
+
+// fail excludes rpcAddr from new pins, and makes the sessions
+// pinned to it move on their next call.
+func (s *sessions) fail(rpcAddr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.disabled == nil {
+		s.disabled = make(map[string]time.Time)
+	}
+	s.disabled[rpcAddr] = time.Now()
+}
+
+func (s *sessions) sessionTTL() time.Duration {
+	if s.ttl == 0 {
+		return DefaultSessionTTL
+	}
+	return s.ttl
+}
+
+// gc drops the expired pins, at most once per minute.
+func (s *sessions) gc(now time.Time) {
+	if now.Sub(s.lastGC) < time.Minute {
+		return
+	}
+	s.lastGC = now
+	ttl := s.sessionTTL()
+	for id, p := range s.pins {
+		if now.Sub(p.lastUse) > ttl {
+			delete(s.pins, id)
+		}
+	}
+}
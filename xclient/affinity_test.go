@@ -0,0 +1,51 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestCallWithSession(t *testing.T) {
+	addrs := startServers(t, 2)
+	d := NewMultiServerDiscovery(addrs)
+	xc := NewXClient(d, AffinitySelect, nil)
+	defer func() { _ = xc.Close() }()
+	repins := make(map[string]int)
+	xc.OnRepin(func(sessionID, oldAddr, newAddr string) {
+		repins[sessionID]++
+		_assert(oldAddr != newAddr, "expect a new server for %s", sessionID)
+	})
+
+	ctx := context.Background()
+	pinned := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("session-%d", i)
+		var name string
+		if err := xc.CallWithSession(ctx, id, "Who.Name", 0, &name); err != nil {
+			t.Fatal("call error:", err)
+		}
+		pinned[id] = name
+		for j := 0; j < 5; j++ {
+			_ = xc.CallWithSession(ctx, id, "Who.Name", 0, &name)
+			_assert(name == pinned[id], "expect %s to stay on %s, but got %s", id, pinned[id], name)
+		}
+	}
+
+	// addrs[0] leaves: its sessions move once, the others stay
+	_ = d.Update(addrs[1:])
+	for round := 0; round < 3; round++ {
+		for id, was := range pinned {
+			var name string
+			if err := xc.CallWithSession(ctx, id, "Who.Name", 0, &name); err != nil {
+				t.Fatal("call error:", err)
+			}
+			_assert(name == addrs[1], "expect %s on the remaining server, but got %s", id, name)
+			if was == addrs[0] {
+				_assert(repins[id] == 1, "expect exactly one re-pin for %s, but got %d", id, repins[id])
+			} else {
+				_assert(repins[id] == 0, "expect no re-pin for %s, but got %d", id, repins[id])
+			}
+		}
+	}
+}
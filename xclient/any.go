@@ -0,0 +1,93 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"tinyrpc"
+)
+
+// DefaultFanout is the number of servers CallAny calls at once.
+const DefaultFanout = 2
+
+// SetFanout sets the number of servers CallAny calls at once,
+// DefaultFanout if n is 0.
+func (xc *XClient) SetFanout(n int) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.fanout = n
+}
+
+// CallAny invokes the named function on up to the fanout number of
+// servers simultaneously, and returns as soon as one of them succeeds,
+// cancelling the others. If all fail, the error lists every failure.
+func (xc *XClient) CallAny(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	servers, err := xc.getAll()
+	if err != nil {
+		return err
+	}
+	if len(servers) == 0 {
+		return errors.New("rpc discovery: no available servers")
+	}
+	xc.mu.Lock()
+	n := xc.fanout
+	if n <= 0 {
+		n = DefaultFanout
+	}
+	if n < len(servers) {
+		xc.r.Shuffle(len(servers), func(i, j int) { servers[i], servers[j] = servers[j], servers[i] })
+		servers = servers[:n]
+	}
+	xc.mu.Unlock()
+
+	type result struct {
+		reply interface{}
+		err   error
+	}
+	// buffered, so the losers never block after CallAny returned
+	results := make(chan result, len(servers))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // the losers remove their pending calls when cancelled
+	for _, rpcAddr := range servers {
+		go func(rpcAddr string) {
+			clonedReply := tinyrpc.DiscardReply
+			if reply != nil {
+				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+			}
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
+			if err != nil {
+				err = fmt.Errorf("%s: %w", rpcAddr, err)
+			}
+			results <- result{clonedReply, err}
+		}(rpcAddr)
+	}
+	var errs anyError
+	for range servers {
+		r := <-results
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		if reply != nil {
+			reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(r.reply).Elem())
+		}
+		return nil
+	}
+	return errs
+}
+
+// anyError collects the failures of every server tried by CallAny.
+type anyError []error
+
+func (e anyError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return "rpc xclient: all calls failed: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap lets errors.Is and errors.As look at every failure.
+func (e anyError) Unwrap() []error { return e }
@@ -0,0 +1,77 @@
+package xclient
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"tinyrpc"
+)
+
+func TestXClient_CallAny(t *testing.T) {
+	fast := startServer(t, func(string) interface{} { return &Shard{n: 1, delay: 10 * time.Millisecond} })
+	slow := startServer(t, func(string) interface{} { return &Shard{n: 2, delay: time.Second} })
+	xc := NewXClient(NewMultiServerDiscovery([]string{fast, slow}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	t.Run("fastest wins", func(t *testing.T) {
+		start := time.Now()
+		var reply int
+		err := xc.CallAny(context.Background(), "Shard.Count", 0, &reply)
+		_assert(err == nil && reply == 1, "expect 1 from the fast server, but got %d, %v", reply, err)
+		_assert(time.Since(start) < 500*time.Millisecond, "expect the latency of the fast server, but took %s", time.Since(start))
+	})
+	t.Run("all fail", func(t *testing.T) {
+		bad1 := startServer(t, func(string) interface{} { return &Shard{n: -1} })
+		bad2 := startServer(t, func(string) interface{} { return &Shard{n: -1} })
+		xc := NewXClient(NewMultiServerDiscovery([]string{bad1, bad2}), RandomSelect, nil)
+		defer func() { _ = xc.Close() }()
+		err := xc.CallAny(context.Background(), "Shard.Count", 0, new(int))
+		_assert(err != nil && strings.Contains(err.Error(), bad1) && strings.Contains(err.Error(), bad2),
+			"expect both failures, but got %v", err)
+	})
+	t.Run("fanout", func(t *testing.T) {
+		xc.SetFanout(1)
+		defer xc.SetFanout(0)
+		// with one server at a time, some calls go to the slow server only
+		var timeouts int
+		for i := 0; i < 20; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			if err := xc.CallAny(ctx, "Shard.Count", 0, new(int)); err != nil {
+				timeouts++
+			}
+			cancel()
+		}
+		_assert(timeouts > 0 && timeouts < 20, "expect a single server per call, but %d of 20 timed out", timeouts)
+	})
+}
+
+func TestXClient_CallAnyBlackholed(t *testing.T) {
+	good := startServer(t, func(string) interface{} { return &Shard{n: 1} })
+	xc := NewXClient(NewMultiServerDiscovery([]string{blackholeAddr, good}), RandomSelect, &tinyrpc.Option{Dialer: newBlackholeDialer()})
+	defer func() { _ = xc.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	var reply int
+	err := xc.CallAny(ctx, "Shard.Count", 0, &reply)
+	_assert(err == nil && reply == 1, "expect 1 from the reachable server, but got %d, %v", reply, err)
+	_assert(time.Since(start) < 500*time.Millisecond, "expect no wait on the blackholed server, but took %s", time.Since(start))
+
+	xc.SetFanout(1)
+	defer xc.SetFanout(0)
+	// with one server at a time, some calls go to the blackholed server only
+	var timeouts int
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		if err := xc.CallAny(ctx, "Shard.Count", 0, new(int)); err != nil {
+			_assert(strings.Contains(err.Error(), blackholeAddr), "expect the blackholed server to fail, but got %v", err)
+			timeouts++
+		}
+		_assert(time.Since(start) < 500*time.Millisecond, "expect CallAny to stop at the deadline, but took %s", time.Since(start))
+		cancel()
+	}
+	_assert(timeouts > 0, "expect some calls to the blackholed server")
+}
@@ -0,0 +1,78 @@
+package xclient
+
+import (
+	"fmt"
+	"tinyrpc"
+)
+
+// XClientConfig describes an XClient calling a fixed list of servers,
+// see NewFromConfig.
+type XClientConfig struct {
+	Servers      []string // rpcAddrs, see ParseServerEntry
+	Mode         SelectMode
+	Options      []tinyrpc.ClientOption // of the connections, as by tinyrpc.Dial
+	Retries      int                    // see XClient.SetRetries
+	Fanout       int                    // see XClient.SetFanout
+	RoutingRules []RoutingRule          // see XClient.SetRoutingRules
+	Pool         PoolConfig             // see XClient.SetPoolConfig
+}
+
+// Validate checks c without dialing anything: the servers must parse and
+// be listed once, and the mode, the options and the settings must be
+// valid. It returns every problem found as tinyrpc.ValidationErrors, nil
+// if none.
+func (c *XClientConfig) Validate() error {
+	var errs tinyrpc.ValidationErrors
+	if len(c.Servers) == 0 {
+		errs = append(errs, fmt.Errorf("rpc xclient: no servers"))
+	}
+	seen := make(map[string]bool, len(c.Servers))
+	for _, rpcAddr := range c.Servers {
+		e, err := ParseServerEntry(rpcAddr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if key := e.String(); seen[key] {
+			errs = append(errs, fmt.Errorf("rpc xclient: server %s listed twice", rpcAddr))
+		} else {
+			seen[key] = true
+		}
+	}
+	if c.Mode < RandomSelect || c.Mode > WeightedSelect {
+		errs = append(errs, fmt.Errorf("rpc xclient: unknown select mode %d", c.Mode))
+	}
+	if _, err := tinyrpc.MergeOptions(c.Options...); err != nil {
+		errs = append(errs, fmt.Errorf("rpc xclient: options: %w", err))
+	}
+	if c.Retries < 0 {
+		errs = append(errs, fmt.Errorf("rpc xclient: negative Retries %d", c.Retries))
+	}
+	if c.Fanout < 0 {
+		errs = append(errs, fmt.Errorf("rpc xclient: negative Fanout %d", c.Fanout))
+	}
+	errs = append(errs, routingRuleErrors(c.RoutingRules)...)
+	if c.Pool.MaxIdle < 0 || c.Pool.IdleTimeout < 0 || c.Pool.MaxLifetime < 0 {
+		errs = append(errs, fmt.Errorf("rpc xclient: negative pool limit %+v", c.Pool))
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// NewFromConfig returns an XClient configured with c, or the error of
+// c.Validate if it is invalid.
+func NewFromConfig(c XClientConfig) (*XClient, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	xc := NewXClient(NewMultiServerDiscovery(c.Servers), c.Mode, c.Options...)
+	xc.SetRetries(c.Retries)
+	xc.SetFanout(c.Fanout)
+	_ = xc.SetRoutingRules(c.RoutingRules)
+	if c.Pool != (PoolConfig{}) {
+		xc.SetPoolConfig(c.Pool)
+	}
+	return xc, nil
+}
@@ -0,0 +1,57 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"tinyrpc"
+)
+
+func TestXClientConfig_Validate(t *testing.T) {
+	c := XClientConfig{
+		Servers:      []string{"tcp@127.0.0.1:1", "tcp@", "tcp@127.0.0.1:1", "tcp@127.0.0.1:2?weight=x"},
+		Mode:         SelectMode(42),
+		Options:      []tinyrpc.ClientOption{tinyrpc.WithWorkers(2, 0)},
+		Retries:      -1,
+		Fanout:       -1,
+		RoutingRules: []RoutingRule{{Match: " ", Percent: 10}, {Match: "v=2", Percent: 95}},
+		Pool:         PoolConfig{MaxIdle: -1},
+	}
+	err := c.Validate()
+	var errs tinyrpc.ValidationErrors
+	_assert(errors.As(err, &errs), "expect ValidationErrors, but got %v", err)
+	for _, want := range []string{
+		`no address in "tcp@"`,
+		"server tcp@127.0.0.1:1 listed twice",
+		"weight of",
+		"unknown select mode 42",
+		"options:",
+		"negative Retries",
+		"negative Fanout",
+		"invalid routing rule",
+		"routing rules exceed 100 percent",
+		"negative pool limit",
+	} {
+		_assert(strings.Contains(err.Error(), want), "expect %q reported, but got %v", want, err)
+	}
+	_assert(len(errs) == 10, "expect 10 problems, but got %d: %v", len(errs), err)
+
+	_, err = NewFromConfig(XClientConfig{})
+	_assert(err != nil && strings.Contains(err.Error(), "no servers"), "expect no servers refused, but got %v", err)
+}
+
+func TestNewFromConfig(t *testing.T) {
+	addrs := startServers(t, 2)
+	xc, err := NewFromConfig(XClientConfig{Servers: addrs, Mode: RoundRobinSelect, Retries: 1})
+	_assert(err == nil, "config error: %v", err)
+	defer func() { _ = xc.Close() }()
+	seen := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		var reply string
+		err := xc.Call(context.Background(), "Who.Name", 0, &reply)
+		_assert(err == nil, "call error: %v", err)
+		seen[reply] = true
+	}
+	_assert(len(seen) == 2, "expect both servers called, but got %v", seen)
+}
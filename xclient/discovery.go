@@ -0,0 +1,195 @@
+package xclient
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// SelectMode is the strategy XClient uses to pick a server.
+type SelectMode int
+
+const (
+	RandomSelect     SelectMode = iota // select randomly
+	RoundRobinSelect                   // select using Robbin algorithm
+	AffinitySelect                     // pin each session to one server, see XClient.CallWithSession
+	LoadAwareSelect                    // select randomly, weighted inversely to the reported load
+	WeightedSelect                     // select randomly, weighted by ServerEntry.Weight
+)
+
+// Discovery finds the servers an XClient may call. Implementations of
+// the string form older versions defined are adapted by FromStringDiscovery.
+type Discovery interface {
+	Refresh() error // refresh from remote registry
+	UpdateEntries(entries []ServerEntry) error
+	GetEntry(mode SelectMode) (ServerEntry, error)
+	GetAllEntries() ([]ServerEntry, error)
+}
+
+var (
+	_ Discovery       = (*MultiServersDiscovery)(nil)
+	_ StringDiscovery = (*MultiServersDiscovery)(nil)
+)
+
+// MultiServersDiscovery is a discovery for multi servers without a registry center.
+// user provides the server addresses explicitly instead
+type MultiServersDiscovery struct {
+	r       *rand.Rand   // generate random number
+	mu      sync.RWMutex // protect following
+	servers []ServerEntry
+	addrs   []string // the string form of servers, their keys
+	index   int      // record the selected position for robin algorithm
+	q       quarantine
+
+	loads         map[string]reportedLoad
+	loadStaleness time.Duration
+	zone          string  // preferred zone, see SetZone
+	spillover     float64 // healthy fraction of the zone needed to keep to it
+}
+
+// NewMultiServerDiscovery creates a MultiServersDiscovery instance.
+// Servers that don't parse, see ParseServerEntry, are skipped.
+func NewMultiServerDiscovery(servers []string) *MultiServersDiscovery {
+	return NewEntryDiscovery(parseValid(servers))
+}
+
+// NewEntryDiscovery creates a MultiServersDiscovery of entries.
+func NewEntryDiscovery(entries []ServerEntry) *MultiServersDiscovery {
+	d := &MultiServersDiscovery{
+		r: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	d.setEntries(entries)
+	d.index = d.r.Intn(math.MaxInt32 - 1)
+	return d
+}
+
+// parseValid parses servers, skipping those that don't parse.
+func parseValid(servers []string) []ServerEntry {
+	entries := make([]ServerEntry, 0, len(servers))
+	for _, rpcAddr := range servers {
+		if e, err := ParseServerEntry(rpcAddr); err == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// setEntries replaces the servers. d.mu must be held, but by the constructor.
+func (d *MultiServersDiscovery) setEntries(entries []ServerEntry) {
+	d.servers = entries
+	d.addrs = FormatServerEntries(entries)
+	d.pruneLoads(d.addrs)
+}
+
+// Refresh doesn't make sense for MultiServersDiscovery, so ignore it
+func (d *MultiServersDiscovery) Refresh() error {
+	return nil
+}
+
+// UpdateEntries replaces the servers of discovery dynamically if needed
+func (d *MultiServersDiscovery) UpdateEntries(entries []ServerEntry) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setEntries(entries)
+	return nil
+}
+
+// Update is UpdateEntries of rpcAddr strings.
+func (d *MultiServersDiscovery) Update(servers []string) error {
+	entries, err := ParseServerEntries(servers)
+	if err != nil {
+		return err
+	}
+	return d.UpdateEntries(entries)
+}
+
+// GetEntry returns a server according to mode
+func (d *MultiServersDiscovery) GetEntry(mode SelectMode) (ServerEntry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	servers := d.preferZone(d.alive(now))
+	n := len(servers)
+	if n == 0 {
+		return ServerEntry{}, errors.New("rpc discovery: no available servers")
+	}
+	switch mode {
+	case RandomSelect, AffinitySelect:
+		return d.servers[servers[d.r.Intn(n)]], nil
+	case RoundRobinSelect:
+		s := servers[d.index%n] // servers could be updated, so mode n to ensure safety
+		d.index = (d.index + 1) % n
+		return d.servers[s], nil
+	case LoadAwareSelect:
+		return d.servers[d.weighted(servers, now)], nil
+	case WeightedSelect:
+		return d.servers[d.byWeight(servers)], nil
+	default:
+		return ServerEntry{}, errors.New("rpc discovery: not supported select mode")
+	}
+}
+
+// Get is GetEntry in the string form, see ServerEntry.String.
+func (d *MultiServersDiscovery) Get(mode SelectMode) (string, error) {
+	e, err := d.GetEntry(mode)
+	if err != nil {
+		return "", err
+	}
+	return e.String(), nil
+}
+
+// GetAllEntries returns all servers in discovery, except the quarantined ones
+func (d *MultiServersDiscovery) GetAllEntries() ([]ServerEntry, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	alive := d.alive(time.Now())
+	entries := make([]ServerEntry, len(alive))
+	for i, s := range alive {
+		entries[i] = d.servers[s]
+	}
+	return entries, nil
+}
+
+// GetAll is GetAllEntries in the string form, see ServerEntry.String.
+func (d *MultiServersDiscovery) GetAll() ([]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	alive := d.alive(time.Now())
+	servers := make([]string, len(alive))
+	for i, s := range alive {
+		servers[i] = d.addrs[s]
+	}
+	return servers, nil
+}
+
+// alive returns the indexes of the servers not quarantined. d.mu must be
+// held, for reading at least.
+func (d *MultiServersDiscovery) alive(now time.Time) []int {
+	keep := d.q.keep(len(d.addrs), func(i int) string { return d.addrs[i] }, now)
+	alive := make([]int, 0, len(d.addrs))
+	for i := range d.addrs {
+		if keep == nil || keep[i] {
+			alive = append(alive, i)
+		}
+	}
+	return alive
+}
+
+// byWeight picks one of the servers indexed at random, weighted by their Weight.
+// d.mu must be held.
+func (d *MultiServersDiscovery) byWeight(servers []int) int {
+	var total int
+	for _, s := range servers {
+		total += d.servers[s].weight()
+	}
+	x := d.r.Intn(total)
+	for _, s := range servers {
+		if x < d.servers[s].weight() {
+			return s
+		}
+		x -= d.servers[s].weight()
+	}
+	return servers[len(servers)-1]
+}
@@ -0,0 +1,293 @@
+package xclient
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"tinyrpc/registry"
+	"tinyrpc/rpclog"
+)
+
+// RegistryDiscovery is a discovery fetching the servers from a TinyRegistry.
+// With several registries, they are asked in order until one answers.
+type RegistryDiscovery struct {
+	*MultiServersDiscovery
+	timeout time.Duration
+
+	refreshMu   sync.Mutex // serialize refreshes, protect following
+	jitter      float64
+	minInterval time.Duration
+	lastFetch   time.Time // when the registry was last asked
+	next        time.Time // when the list is stale
+	failures    int       // consecutive failed fetches
+	onUpdate    func(old, new []string)
+	fetched     bool // the list came from a registry at least once
+	registries  []RegistryHealth
+}
+
+// RegistryHealth describes the requests made to one registry.
+type RegistryHealth struct {
+	URL         string
+	LastSuccess time.Time
+	LastError   error // error of the last request, nil if it succeeded
+	Failures    int   // consecutive failed requests
+}
+
+const (
+	defaultUpdateTimeout = time.Second * 10
+	// DefaultRefreshJitter spreads the refreshes of clients started together.
+	DefaultRefreshJitter = 0.1
+	// DefaultMinRefreshInterval is the least time between two registry requests.
+	DefaultMinRefreshInterval = time.Second
+)
+
+// NewRegistryDiscovery returns a discovery refreshing from registerAddr
+// when its list is older than timeout, give or take the refresh jitter.
+func NewRegistryDiscovery(registerAddr string, timeout time.Duration) *RegistryDiscovery {
+	return NewMultiRegistryDiscovery([]string{registerAddr}, timeout)
+}
+
+// NewMultiRegistryDiscovery is like NewRegistryDiscovery with redundant
+// registries, asked in order until one answers.
+func NewMultiRegistryDiscovery(registries []string, timeout time.Duration) *RegistryDiscovery {
+	if timeout == 0 {
+		timeout = defaultUpdateTimeout
+	}
+	d := &RegistryDiscovery{
+		MultiServersDiscovery: NewMultiServerDiscovery(make([]string, 0)),
+		timeout:               timeout,
+		jitter:                DefaultRefreshJitter,
+		minInterval:           DefaultMinRefreshInterval,
+	}
+	for _, url := range registries {
+		d.registries = append(d.registries, RegistryHealth{URL: url})
+	}
+	return d
+}
+
+// Registries returns the health of each registry, in the order they are asked.
+func (d *RegistryDiscovery) Registries() []RegistryHealth {
+	d.refreshMu.Lock()
+	defer d.refreshMu.Unlock()
+	return append([]RegistryHealth(nil), d.registries...)
+}
+
+// SetRefreshJitter makes each refresh interval vary randomly by
+// ±fraction of the timeout, 0 disables the jitter.
+func (d *RegistryDiscovery) SetRefreshJitter(fraction float64) {
+	d.refreshMu.Lock()
+	defer d.refreshMu.Unlock()
+	d.jitter = fraction
+}
+
+// SetMinRefreshInterval sets the least time between two requests to the
+// registry, also enforced on ForceRefresh.
+func (d *RegistryDiscovery) SetMinRefreshInterval(interval time.Duration) {
+	d.refreshMu.Lock()
+	defer d.refreshMu.Unlock()
+	d.minInterval = interval
+}
+
+// OnUpdate sets a callback invoked with the old and new servers, in the
+// string form of their entries, whenever the list changes.
+func (d *RegistryDiscovery) OnUpdate(fn func(old, new []string)) {
+	d.refreshMu.Lock()
+	defer d.refreshMu.Unlock()
+	d.onUpdate = fn
+}
+
+// UpdateEntries replaces the servers and resets the refresh timer.
+func (d *RegistryDiscovery) UpdateEntries(entries []ServerEntry) error {
+	d.refreshMu.Lock()
+	defer d.refreshMu.Unlock()
+	d.setServers(entries, time.Now())
+	return nil
+}
+
+// Update is UpdateEntries of rpcAddr strings.
+func (d *RegistryDiscovery) Update(servers []string) error {
+	entries, err := ParseServerEntries(servers)
+	if err != nil {
+		return err
+	}
+	return d.UpdateEntries(entries)
+}
+
+// Refresh fetches the servers from the registry if the list is stale.
+func (d *RegistryDiscovery) Refresh() error {
+	d.refreshMu.Lock()
+	defer d.refreshMu.Unlock()
+	if time.Now().Before(d.next) {
+		return nil
+	}
+	return d.fetch()
+}
+
+// ForceRefresh fetches the servers from the registry even if the list
+// is fresh, unless the last request was less than the minimum refresh
+// interval ago.
+func (d *RegistryDiscovery) ForceRefresh() error {
+	d.refreshMu.Lock()
+	defer d.refreshMu.Unlock()
+	return d.fetch()
+}
+
+// fetch asks the registries for the servers. If none answers, the
+// cached list is kept for an interval doubling with each consecutive
+// failure, up to the timeout, and the error is returned only if there is
+// no cached list. refreshMu must be held.
+func (d *RegistryDiscovery) fetch() error {
+	now := time.Now()
+	if now.Sub(d.lastFetch) < d.minInterval {
+		return nil
+	}
+	d.lastFetch = now
+	entries, err := d.getAny(now)
+	if err != nil {
+		d.failures++
+		backoff := d.minInterval
+		if backoff <= 0 {
+			backoff = DefaultMinRefreshInterval
+		}
+		for i := 1; i < d.failures && backoff < d.timeout; i++ {
+			backoff *= 2
+		}
+		if backoff > d.timeout {
+			backoff = d.timeout
+		}
+		d.next = now.Add(backoff)
+		if d.fetched {
+			return nil // keep serving the cached list
+		}
+		return err
+	}
+	d.failures = 0
+	d.fetched = true
+	servers := make([]ServerEntry, 0, len(entries))
+	for _, e := range entries {
+		entry, err := ParseServerEntry(e.Addr)
+		if err != nil {
+			rpclog.Error("registry", "invalid server", "addr", e.Addr, "err", err)
+			continue
+		}
+		servers = append(servers, entry)
+	}
+	d.setServers(servers, now)
+	for _, e := range entries {
+		if e.Load != nil {
+			d.UpdateLoad(e.Addr, *e.Load, now.Add(-time.Duration(e.AgeMillis)*time.Millisecond))
+		}
+	}
+	return nil
+}
+
+// getAny asks each registry in turn and returns the first answer.
+// refreshMu must be held.
+func (d *RegistryDiscovery) getAny(now time.Time) ([]registry.Entry, error) {
+	err := errors.New("rpc registry: no registry")
+	for i := range d.registries {
+		h := &d.registries[i]
+		rpclog.Debug("registry", "refresh servers from registry", "url", h.URL)
+		var entries []registry.Entry
+		entries, err = get(h.URL)
+		if err == nil {
+			h.LastSuccess, h.LastError, h.Failures = now, nil, 0
+			return entries, nil
+		}
+		rpclog.Error("registry", "refresh error", "err", err)
+		h.LastError = err
+		h.Failures++
+	}
+	return nil, err
+}
+
+// get returns the servers listed by a registry, with their load if the
+// registry sends a JSON body.
+func get(registryURL string) ([]registry.Entry, error) {
+	resp, err := http.Get(registryURL)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("rpc registry: unexpected status " + resp.Status)
+	}
+	var entries []registry.Entry
+	if resp.Header.Get("Content-Type") == "application/json" {
+		if err = json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+			return nil, err
+		}
+		return entries, nil
+	}
+	for _, server := range strings.Split(resp.Header.Get(registry.ServersHeader), ",") {
+		if strings.TrimSpace(server) != "" {
+			entries = append(entries, registry.Entry{Addr: strings.TrimSpace(server)})
+		}
+	}
+	return entries, nil
+}
+
+// setServers replaces the servers and schedules the next refresh.
+// refreshMu must be held.
+func (d *RegistryDiscovery) setServers(servers []ServerEntry, now time.Time) {
+	d.mu.Lock()
+	old := d.addrs
+	d.setEntries(servers)
+	addrs := d.addrs
+	interval := d.timeout
+	if d.jitter > 0 {
+		interval = time.Duration(float64(interval) * (1 + d.jitter*(2*d.r.Float64()-1)))
+	}
+	d.mu.Unlock()
+	d.next = now.Add(interval)
+	if d.onUpdate != nil && !sameServers(old, addrs) {
+		d.onUpdate(old, addrs)
+	}
+}
+
+func sameServers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// GetEntry refreshes the servers if needed and picks one according to mode.
+func (d *RegistryDiscovery) GetEntry(mode SelectMode) (ServerEntry, error) {
+	if err := d.Refresh(); err != nil {
+		return ServerEntry{}, err
+	}
+	return d.MultiServersDiscovery.GetEntry(mode)
+}
+
+// GetAllEntries refreshes the servers if needed and returns them all.
+func (d *RegistryDiscovery) GetAllEntries() ([]ServerEntry, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAllEntries()
+}
+
+// Get refreshes the servers if needed and picks one according to mode.
+func (d *RegistryDiscovery) Get(mode SelectMode) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServersDiscovery.Get(mode)
+}
+
+// GetAll refreshes the servers if needed and returns them all.
+func (d *RegistryDiscovery) GetAll() ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAll()
+}
@@ -0,0 +1,150 @@
+package xclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tinyrpc/registry"
+)
+
+// countingRegistry answers GETs with servers, or fails with status if
+// set, and counts the requests.
+type countingRegistry struct {
+	requests int64
+	status   int64
+	mu       sync.Mutex
+	servers  string
+}
+
+func (r *countingRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt64(&r.requests, 1)
+	if status := atomic.LoadInt64(&r.status); status != 0 {
+		w.WriteHeader(int(status))
+		return
+	}
+	r.mu.Lock()
+	w.Header().Set(registry.ServersHeader, r.servers)
+	r.mu.Unlock()
+}
+
+func TestRegistryDiscovery_MinInterval(t *testing.T) {
+	r := &countingRegistry{servers: "tcp@a:1"}
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	d := NewRegistryDiscovery(ts.URL, time.Millisecond)
+	d.SetMinRefreshInterval(100 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(350 * time.Millisecond)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				_ = d.ForceRefresh()
+				_, _ = d.GetAll()
+			}
+		}()
+	}
+	wg.Wait()
+	n := atomic.LoadInt64(&r.requests)
+	_assert(n >= 3 && n <= 4, "expect one request per 100ms, but got %d in 350ms", n)
+}
+
+func TestRegistryDiscovery_Backoff(t *testing.T) {
+	r := &countingRegistry{status: http.StatusInternalServerError}
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	d := NewRegistryDiscovery(ts.URL, time.Second)
+	d.SetMinRefreshInterval(20 * time.Millisecond)
+
+	// refreshes back off 20ms, 40ms, 80ms, 160ms... instead of one per 20ms
+	deadline := time.Now().Add(400 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		_, _ = d.GetAll()
+		time.Sleep(time.Millisecond)
+	}
+	n := atomic.LoadInt64(&r.requests)
+	_assert(n >= 3 && n <= 5, "expect backoff to 4 or 5 requests, but got %d", n)
+
+	atomic.StoreInt64(&r.status, 0)
+	time.Sleep(time.Second)
+	servers, err := d.GetAll()
+	_assert(err == nil, "expect the registry to recover, but got %v", err)
+	_assert(len(servers) == 0, "expect no servers, but got %v", servers)
+}
+
+func TestRegistryDiscovery_Jitter(t *testing.T) {
+	r := &countingRegistry{servers: "tcp@a:1"}
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	min, max := time.Hour, time.Duration(0)
+	for i := 0; i < 50; i++ {
+		d := NewRegistryDiscovery(ts.URL, time.Minute)
+		d.SetRefreshJitter(0.5)
+		start := time.Now()
+		_ = d.Refresh()
+		interval := d.next.Sub(start)
+		_assert(interval >= 29*time.Second && interval <= 91*time.Second, "expect 30s to 90s, but got %s", interval)
+		if interval < min {
+			min = interval
+		}
+		if interval > max {
+			max = interval
+		}
+	}
+	_assert(max-min > 10*time.Second, "expect the intervals to spread, but got %s to %s", min, max)
+}
+
+func TestRegistryDiscovery_OnUpdate(t *testing.T) {
+	r := &countingRegistry{servers: "tcp@a:1"}
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	d := NewRegistryDiscovery(ts.URL, time.Minute)
+	d.SetMinRefreshInterval(0)
+	var updates [][2][]string
+	d.OnUpdate(func(old, new []string) { updates = append(updates, [2][]string{old, new}) })
+
+	_ = d.Refresh()
+	_ = d.ForceRefresh() // unchanged
+	r.mu.Lock()
+	r.servers = "tcp@a:1,tcp@b:1"
+	r.mu.Unlock()
+	_ = d.ForceRefresh()
+	_assert(len(updates) == 2, "expect 2 updates, but got %v", updates)
+	_assert(len(updates[0][0]) == 0 && len(updates[0][1]) == 1, "expect [] -> [a], but got %v", updates[0])
+	_assert(len(updates[1][0]) == 1 && len(updates[1][1]) == 2, "expect [a] -> [a b], but got %v", updates[1])
+}
+
+func TestRegistryDiscovery_Fallback(t *testing.T) {
+	r1 := &countingRegistry{servers: "tcp@a:1"}
+	r2 := &countingRegistry{servers: "tcp@a:1,tcp@b:1"}
+	ts1, ts2 := httptest.NewServer(r1), httptest.NewServer(r2)
+	defer ts1.Close()
+	defer ts2.Close()
+	d := NewMultiRegistryDiscovery([]string{ts1.URL, ts2.URL}, time.Minute)
+	d.SetMinRefreshInterval(0)
+
+	servers, err := d.GetAll()
+	_assert(err == nil && len(servers) == 1, "expect the first registry, but got %v, %v", servers, err)
+	_assert(atomic.LoadInt64(&r2.requests) == 0, "expect the second registry unused")
+
+	atomic.StoreInt64(&r1.status, http.StatusInternalServerError)
+	_ = d.ForceRefresh()
+	servers, _ = d.GetAll()
+	_assert(len(servers) == 2, "expect the second registry, but got %v", servers)
+	h := d.Registries()
+	_assert(h[0].Failures == 1 && h[0].LastError != nil, "expect the first registry failing, but got %+v", h[0])
+	_assert(h[1].Failures == 0 && !h[1].LastSuccess.IsZero(), "expect the second registry healthy, but got %+v", h[1])
+
+	// all down: keep serving the cached list
+	atomic.StoreInt64(&r2.status, http.StatusInternalServerError)
+	err = d.ForceRefresh()
+	servers, _ = d.GetAll()
+	_assert(err == nil && len(servers) == 2, "expect the cached list, but got %v, %v", servers, err)
+	h = d.Registries()
+	_assert(h[0].Failures == 2 && h[1].Failures == 1, "expect both registries failing, but got %+v", h)
+}
@@ -0,0 +1,237 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+	"tinyrpc"
+)
+
+// DefaultEagerInterval is how often an eager XClient checks the discovery
+// for new servers to connect to.
+const DefaultEagerInterval = time.Second
+
+// eager connects to servers ahead of the calls, see EnableEagerDial.
+type eager struct {
+	warmup   int
+	interval time.Duration
+	mu       sync.Mutex               // protect following
+	warm     map[string]warmState     // of the servers seen
+	services map[serviceKey]warmState // of the services called, by server
+	stop     chan struct{}
+}
+
+// serviceKey is a service of a server.
+type serviceKey struct {
+	rpcAddr, service string
+}
+
+type warmState int
+
+const (
+	warming  warmState = iota // connecting and warming up
+	ready                     // warmed up and ready, see tinyrpc.Server.SetReady
+	notReady                  // warmed up, but not ready
+)
+
+// EnableEagerDial makes xc connect to every server of the discovery now
+// and whenever new ones appear, checked every interval (DefaultEagerInterval
+// if 0), instead of on their first call. Each new connection makes warmup
+// pings before Call selects the server, e.g. to fill the server's caches.
+// Call only selects the servers whose health service answers that they
+// are ready, along with the service called, checked again on every check
+// of the discovery, and those without one. A service is taken as ready
+// until its first check answers. It returns once the servers known now
+// are connected. Servers that fail to connect or to answer the pings are
+// reported to the discovery, if it is a FailureReporter, and tried again
+// on the next check.
+func (xc *XClient) EnableEagerDial(warmup int, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultEagerInterval
+	}
+	e := &eager{warmup: warmup, interval: interval, warm: make(map[string]warmState),
+		services: make(map[serviceKey]warmState), stop: make(chan struct{})}
+	xc.mu.Lock()
+	old := xc.eager
+	xc.eager = e
+	xc.mu.Unlock()
+	if old != nil {
+		old.close()
+	}
+	xc.warmAll(e)
+	go xc.watchServers(e)
+}
+
+func (xc *XClient) eagerDial() *eager {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return xc.eager
+}
+
+// warmAll connects to the servers not connected yet, checks again the
+// readiness of the others, and waits for them.
+func (xc *XClient) warmAll(e *eager) {
+	servers, err := xc.getAll()
+	if err != nil {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, rpcAddr := range servers {
+		warm := func(rpcAddr string) { xc.warmUp(e, rpcAddr, e.warmup) }
+		if !e.start(rpcAddr) {
+			if e.state(rpcAddr) == warming {
+				continue
+			}
+			warm = func(rpcAddr string) { xc.warmUp(e, rpcAddr, 0) }
+		}
+		wg.Add(1)
+		go func(rpcAddr string) {
+			defer wg.Done()
+			warm(rpcAddr)
+		}(rpcAddr)
+	}
+	wg.Wait()
+}
+
+func (xc *XClient) watchServers(e *eager) {
+	ticker := xc.clock.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			xc.warmAll(e)
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// start reports whether rpcAddr needs warming up, and marks it in progress.
+func (e *eager) start(rpcAddr string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.warm[rpcAddr]; ok {
+		return false
+	}
+	e.warm[rpcAddr] = warming
+	return true
+}
+
+// state returns the state of rpcAddr, seen already.
+func (e *eager) state(rpcAddr string) warmState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.warm[rpcAddr]
+}
+
+// serviceState returns the readiness of service on rpcAddr, and whether
+// it is checked already. It marks it checked otherwise.
+func (e *eager) serviceState(rpcAddr, service string) (warmState, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key := serviceKey{rpcAddr, service}
+	state, ok := e.services[key]
+	if !ok {
+		e.services[key] = ready // until checked
+	}
+	return state, ok
+}
+
+// servicesOf returns the services of rpcAddr checked already.
+func (e *eager) servicesOf(rpcAddr string) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var services []string
+	for key := range e.services {
+		if key.rpcAddr == rpcAddr {
+			services = append(services, key.service)
+		}
+	}
+	return services
+}
+
+// selectable reports whether rpcAddr is connected, warmed up and ready,
+// along with the service of serviceMethod. It starts warming up servers
+// it has not seen yet, and checking services it has not checked yet, in
+// the background.
+func (xc *XClient) selectable(e *eager, rpcAddr, serviceMethod string) bool {
+	if e.start(rpcAddr) {
+		go xc.warmUp(e, rpcAddr, e.warmup)
+		return false
+	}
+	if e.state(rpcAddr) != ready {
+		return false
+	}
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot <= 0 {
+		return true
+	}
+	state, checked := e.serviceState(rpcAddr, serviceMethod[:dot])
+	if !checked {
+		go xc.warmUp(e, rpcAddr, 0)
+		return true
+	}
+	return state == ready
+}
+
+// warmUp dials rpcAddr, pings it pings times, and checks its readiness
+// and that of its services called.
+func (xc *XClient) warmUp(e *eager, rpcAddr string, pings int) {
+	pc, err := xc.dial(context.Background(), rpcAddr)
+	for i := 0; err == nil && i < pings; i++ {
+		var reply int
+		err = pc.client.Call("_ping_.Ping", i, &reply)
+	}
+	state := ready
+	if err == nil {
+		state, err = checkReady(pc.client, "")
+	}
+	services := make(map[string]warmState)
+	for _, service := range e.servicesOf(rpcAddr) {
+		if err != nil {
+			break
+		}
+		services[service], err = checkReady(pc.client, service)
+	}
+	if pc != nil {
+		xc.release(pc)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for service, state := range services {
+		e.services[serviceKey{rpcAddr, service}] = state
+	}
+	if err != nil {
+		delete(e.warm, rpcAddr) // tried again on the next check
+		if _, isDial := err.(*dialError); !isDial {
+			if r, ok := xc.d.(FailureReporter); ok {
+				r.ReportFailure(rpcAddr) // dial already reported its failures
+			}
+		}
+		return
+	}
+	e.warm[rpcAddr] = state
+}
+
+// checkReady returns the readiness of the server of client, and of
+// service too if not empty. Servers without a health service are ready.
+func checkReady(client *tinyrpc.Client, service string) (warmState, error) {
+	var ok bool
+	err := client.Call(tinyrpc.HealthCheckMethod, service, &ok)
+	switch {
+	case errors.Is(err, tinyrpc.ErrMethodNotFound):
+		return ready, nil
+	case err != nil:
+		return warming, err
+	case !ok:
+		return notReady, nil
+	}
+	return ready, nil
+}
+
+// close stops checking the discovery for new servers.
+func (e *eager) close() {
+	close(e.stop)
+}
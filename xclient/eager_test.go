@@ -0,0 +1,131 @@
+package xclient
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+	"tinyrpc"
+)
+
+// slowDialer takes delay to connect.
+type slowDialer struct{ delay time.Duration }
+
+func (d slowDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	time.Sleep(d.delay)
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, address)
+}
+
+func firstCallLatency(t *testing.T, xc *XClient) time.Duration {
+	t.Helper()
+	start := time.Now()
+	var reply string
+	if err := xc.Call(context.Background(), "Who.Name", 0, &reply); err != nil {
+		t.Fatal("call error:", err)
+	}
+	return time.Since(start)
+}
+
+func TestXClient_EagerDial(t *testing.T) {
+	addrs := startServers(t, 2)
+	opt := &tinyrpc.Option{Dialer: slowDialer{100 * time.Millisecond}}
+
+	lazy := NewXClient(NewMultiServerDiscovery(addrs), RoundRobinSelect, opt)
+	defer func() { _ = lazy.Close() }()
+	_assert(firstCallLatency(t, lazy) >= 100*time.Millisecond, "expect the lazy client to connect on the first call")
+
+	eager := NewXClient(NewMultiServerDiscovery(addrs), RoundRobinSelect, opt)
+	defer func() { _ = eager.Close() }()
+	eager.EnableEagerDial(3, 0)
+	_assert(firstCallLatency(t, eager) < 50*time.Millisecond, "expect the eager client to be connected")
+}
+
+func TestXClient_EagerWarmup(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	server := tinyrpc.NewServer()
+	go server.Accept(lis)
+	t.Cleanup(func() { _ = lis.Close() })
+	live := "tcp@" + lis.Addr().String()
+	dead := deadAddr(t)
+
+	d := NewMultiServerDiscovery([]string{live, dead})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.EnableEagerDial(3, 20*time.Millisecond)
+
+	_assert(server.Stats().Requests == 4, "expect 3 warmup pings and a readiness check, but got %d", server.Stats().Requests)
+	q := d.Quarantined()
+	_assert(len(q) == 1 && q[0].Addr == dead, "expect the dead server quarantined, but got %v", q)
+
+	// new servers are connected soon after they are discovered
+	added := startServers(t, 1)[0]
+	_ = d.Update([]string{live, added})
+	e := xc.eagerDial()
+	for i := 0; i < 50 && e.state(added) != ready; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_assert(e.state(added) == ready, "expect the added server warmed up")
+}
+
+func TestXClient_EagerReadiness(t *testing.T) {
+	servers, addrs := startCountedServers(t, 2)
+	servers[1].SetReady(false)
+	xc := NewXClient(NewMultiServerDiscovery(addrs), RoundRobinSelect, &tinyrpc.Option{HeartbeatIdle: -1})
+	defer func() { _ = xc.Close() }()
+	xc.EnableEagerDial(0, 20*time.Millisecond)
+	names := func() map[string]bool {
+		seen := make(map[string]bool)
+		for i := 0; i < 6; i++ {
+			var reply string
+			_assert(xc.Call(context.Background(), "Who.Name", 0, &reply) == nil, "failed to call Who.Name")
+			seen[reply] = true
+		}
+		return seen
+	}
+	seen := names()
+	_assert(len(seen) == 1 && seen[addrs[0]], "expect the server not ready skipped, but got %v", seen)
+
+	// checked again on every interval
+	servers[0].SetReady(false)
+	servers[1].SetReady(true)
+	e := xc.eagerDial()
+	waitReady := func(rpcAddr string, want warmState) {
+		for i := 0; i < 50 && e.state(rpcAddr) != want; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		_assert(e.state(rpcAddr) == want, "expect %s in state %d", rpcAddr, want)
+	}
+	waitReady(addrs[0], notReady)
+	waitReady(addrs[1], ready)
+	seen = names()
+	_assert(len(seen) == 1 && seen[addrs[1]], "expect the calls moved to the ready server, but got %v", seen)
+}
+
+func TestXClient_EagerServiceReadiness(t *testing.T) {
+	servers, addrs := startCountedServers(t, 2)
+	xc := NewXClient(NewMultiServerDiscovery(addrs), RoundRobinSelect, &tinyrpc.Option{HeartbeatIdle: -1})
+	defer func() { _ = xc.Close() }()
+	xc.EnableEagerDial(0, 20*time.Millisecond)
+	var reply string
+	_assert(xc.Call(context.Background(), "Who.Name", 0, &reply) == nil, "failed to call Who.Name")
+
+	// the server stays ready, but not for the service called
+	servers[1].SetReadyFor("Who", false)
+	e := xc.eagerDial()
+	for i := 0; i < 50; i++ {
+		if state, _ := e.serviceState(addrs[1], "Who"); state == notReady {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	state, _ := e.serviceState(addrs[1], "Who")
+	_assert(state == notReady && e.state(addrs[1]) == ready, "expect Who alone not ready on %s", addrs[1])
+	for i := 0; i < 6; i++ {
+		_assert(xc.Call(context.Background(), "Who.Name", 0, &reply) == nil, "failed to call Who.Name")
+		_assert(reply == addrs[0], "expect the calls kept off the server not ready for Who, but got %s", reply)
+	}
+}
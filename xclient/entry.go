@@ -0,0 +1,177 @@
+package xclient
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ServerEntry is a server a Discovery lists, the parsed form of the
+// legacy rpcAddr strings such as "tcp@host:port?zone=us-east-1a&weight=3".
+type ServerEntry struct {
+	Addr    string            // e.g. "host:port" or "/path/to.sock"
+	Network string            // e.g. "tcp" or "unix", "" for a bare "host:port" meaning tcp
+	Weight  int               // relative share of WeightedSelect, 0 means 1
+	Zone    string            // see MultiServersDiscovery.SetZone
+	Tags    map[string]string // labels matched by the routing rules, "tag.k=v" in the string form
+	Meta    map[string]string // any other label
+}
+
+// ParseServerEntry parses rpcAddr, "protocol@addr?labels", a bare
+// "host:port" or "unix:///path/to.sock". The labels zone and weight set
+// Zone and Weight, those prefixed with "tag." Tags, the others Meta.
+func ParseServerEntry(rpcAddr string) (ServerEntry, error) {
+	var e ServerEntry
+	addr, query := rpcAddr, ""
+	if i := strings.IndexByte(rpcAddr, '?'); i >= 0 {
+		addr, query = rpcAddr[:i], rpcAddr[i+1:]
+	}
+	switch i := strings.Index(addr, "@"); {
+	case strings.HasPrefix(addr, "unix://"):
+		e.Network, e.Addr = "unix", strings.TrimPrefix(addr, "unix://")
+	case i > 0:
+		e.Network, e.Addr = addr[:i], addr[i+1:]
+	default:
+		e.Addr = addr
+	}
+	if e.Addr == "" {
+		return ServerEntry{}, fmt.Errorf("rpc discovery: no address in %q", rpcAddr)
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return ServerEntry{}, fmt.Errorf("rpc discovery: labels of %q: %v", rpcAddr, err)
+	}
+	for k := range values {
+		v := values.Get(k)
+		switch {
+		case k == "zone":
+			e.Zone = v
+		case k == "weight":
+			if e.Weight, err = strconv.Atoi(v); err != nil || e.Weight < 0 {
+				return ServerEntry{}, fmt.Errorf("rpc discovery: weight of %q: %q", rpcAddr, v)
+			}
+		case strings.HasPrefix(k, "tag."):
+			if e.Tags == nil {
+				e.Tags = make(map[string]string)
+			}
+			e.Tags[strings.TrimPrefix(k, "tag.")] = v
+		default:
+			if e.Meta == nil {
+				e.Meta = make(map[string]string)
+			}
+			e.Meta[k] = v
+		}
+	}
+	return e, nil
+}
+
+// String formats e as a legacy rpcAddr, with its labels sorted. It is the
+// address XClient dials and keeps its connections by.
+func (e ServerEntry) String() string {
+	s := e.Addr
+	if e.Network != "" {
+		s = e.Network + "@" + e.Addr
+	}
+	values := e.labels()
+	if len(values) == 0 {
+		return s
+	}
+	return s + "?" + values.Encode()
+}
+
+// labels returns the labels of e, as in its string form.
+func (e ServerEntry) labels() url.Values {
+	values := url.Values{}
+	if e.Zone != "" {
+		values.Set("zone", e.Zone)
+	}
+	if e.Weight != 0 {
+		values.Set("weight", strconv.Itoa(e.Weight))
+	}
+	for k, v := range e.Tags {
+		values.Set("tag."+k, v)
+	}
+	for k, v := range e.Meta {
+		values.Set(k, v)
+	}
+	return values
+}
+
+// weight returns the share of e in WeightedSelect.
+func (e ServerEntry) weight() int {
+	if e.Weight <= 0 {
+		return 1
+	}
+	return e.Weight
+}
+
+// ParseServerEntries parses each of rpcAddrs, see ParseServerEntry.
+func ParseServerEntries(rpcAddrs []string) ([]ServerEntry, error) {
+	entries := make([]ServerEntry, len(rpcAddrs))
+	for i, rpcAddr := range rpcAddrs {
+		e, err := ParseServerEntry(rpcAddr)
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = e
+	}
+	return entries, nil
+}
+
+// FormatServerEntries formats each of entries, see ServerEntry.String.
+func FormatServerEntries(entries []ServerEntry) []string {
+	rpcAddrs := make([]string, len(entries))
+	for i, e := range entries {
+		rpcAddrs[i] = e.String()
+	}
+	return rpcAddrs
+}
+
+// canonical returns the string form of the entry rpcAddr parses to, the
+// key of the servers in a discovery, or rpcAddr itself if it doesn't parse.
+func canonical(rpcAddr string) string {
+	e, err := ParseServerEntry(rpcAddr)
+	if err != nil {
+		return rpcAddr
+	}
+	return e.String()
+}
+
+// StringDiscovery is the Discovery of rpcAddr strings older versions
+// defined, see FromStringDiscovery.
+type StringDiscovery interface {
+	Refresh() error
+	Update(servers []string) error
+	Get(mode SelectMode) (string, error)
+	GetAll() ([]string, error)
+}
+
+// FromStringDiscovery adapts d to a Discovery, parsing the servers it returns.
+func FromStringDiscovery(d StringDiscovery) Discovery {
+	return stringDiscovery{d}
+}
+
+type stringDiscovery struct{ d StringDiscovery }
+
+func (s stringDiscovery) Refresh() error { return s.d.Refresh() }
+
+func (s stringDiscovery) UpdateEntries(entries []ServerEntry) error {
+	return s.d.Update(FormatServerEntries(entries))
+}
+
+func (s stringDiscovery) GetEntry(mode SelectMode) (ServerEntry, error) {
+	rpcAddr, err := s.d.Get(mode)
+	if err != nil {
+		return ServerEntry{}, err
+	}
+	return ParseServerEntry(rpcAddr)
+}
+
+func (s stringDiscovery) GetAllEntries() ([]ServerEntry, error) {
+	servers, err := s.d.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return ParseServerEntries(servers)
+}
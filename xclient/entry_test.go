@@ -0,0 +1,84 @@
+package xclient
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestServerEntry_RoundTrip(t *testing.T) {
+	for _, rpcAddr := range []string{
+		"127.0.0.1:1234",
+		"tcp@127.0.0.1:1234",
+		"unix@/tmp/rpc.sock",
+		"tcp@a:1?zone=us-east-1a",
+		"tcp@a:1?tag.version=v2&weight=3&zone=b",
+		"tcp@a:1?owner=team+x&tag.canary=",
+	} {
+		e, err := ParseServerEntry(rpcAddr)
+		_assert(err == nil, "parse %q: %v", rpcAddr, err)
+		_assert(e.String() == rpcAddr, "expect %q, but got %q", rpcAddr, e.String())
+	}
+
+	e, err := ParseServerEntry("tcp@a:1?zone=b&weight=3&tag.version=v2&owner=x")
+	want := ServerEntry{
+		Addr: "a:1", Network: "tcp", Weight: 3, Zone: "b",
+		Tags: map[string]string{"version": "v2"},
+		Meta: map[string]string{"owner": "x"},
+	}
+	_assert(err == nil && reflect.DeepEqual(e, want), "expect %+v, but got %+v, %v", want, e, err)
+	e, _ = ParseServerEntry("unix:///tmp/rpc.sock")
+	_assert(e.Network == "unix" && e.Addr == "/tmp/rpc.sock", "expect the unix socket, but got %+v", e)
+
+	for _, bad := range []string{"", "tcp@?zone=a", "tcp@a:1?weight=x", "tcp@a:1?weight=-1", "tcp@a:1?zone=%zz"} {
+		_, err := ParseServerEntry(bad)
+		_assert(err != nil, "expect an error for %q", bad)
+	}
+}
+
+func TestWeightedSelect(t *testing.T) {
+	heavy := ServerEntry{Addr: "heavy:1", Network: "tcp", Weight: 9, Zone: "a"}
+	light := ServerEntry{Addr: "light:1", Network: "tcp", Zone: "a"} // weight 0 counts as 1
+	remote := ServerEntry{Addr: "remote:1", Network: "tcp", Weight: 100, Zone: "b"}
+	d := NewEntryDiscovery([]ServerEntry{heavy, light, remote})
+	d.SetZone("a", 0)
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		e, err := d.GetEntry(WeightedSelect)
+		_assert(err == nil, "get error: %v", err)
+		counts[e.Addr]++
+	}
+	_assert(counts["remote:1"] == 0, "expect only zone a, but got %v", counts)
+	_assert(counts["heavy:1"] > 800 && counts["light:1"] > 50, "expect about 9 to 1, but got %v", counts)
+
+	// the string form keeps working on the same servers
+	rpcAddr, err := d.Get(WeightedSelect)
+	_assert(err == nil && (rpcAddr == heavy.String() || rpcAddr == light.String()), "get %q, %v", rpcAddr, err)
+	d.ReportFailure("tcp@heavy:1?zone=a&weight=9") // labels in another order
+	d.ReportFailure(light.String())
+	e, _ := d.GetEntry(WeightedSelect)
+	_assert(e.Addr == "remote:1", "expect a spillover to zone b, but got %+v", e)
+}
+
+func TestFromStringDiscovery(t *testing.T) {
+	addr := startServers(t, 1)[0]
+	legacy := NewMultiServerDiscovery([]string{addr + "?zone=a"})
+	xc := NewXClient(FromStringDiscovery(legacyDiscovery{legacy}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	var name string
+	err := xc.Call(context.Background(), "Who.Name", 0, &name)
+	_assert(err == nil && name == addr, "call error: %v, name %q", err, name)
+
+	d := FromStringDiscovery(legacyDiscovery{legacy})
+	_ = d.UpdateEntries([]ServerEntry{{Addr: "b:1", Network: "tcp", Weight: 2}})
+	all, _ := legacy.GetAll()
+	_assert(reflect.DeepEqual(all, []string{"tcp@b:1?weight=2"}), "expect the entries updated, but got %v", all)
+}
+
+// legacyDiscovery hides the entry methods, as a Discovery of older versions.
+type legacyDiscovery struct{ d *MultiServersDiscovery }
+
+func (l legacyDiscovery) Refresh() error                      { return l.d.Refresh() }
+func (l legacyDiscovery) Update(servers []string) error       { return l.d.Update(servers) }
+func (l legacyDiscovery) Get(mode SelectMode) (string, error) { return l.d.Get(mode) }
+func (l legacyDiscovery) GetAll() ([]string, error)           { return l.d.GetAll() }
@@ -0,0 +1,42 @@
+package xclient
+
+import (
+	"context"
+	"sync"
+)
+
+// GatherResult is the outcome of a call to one server in Gather.
+type GatherResult struct {
+	Reply interface{} // the value returned by newReply, filled in if Err is nil
+	Err   error
+}
+
+// Gather invokes the named function on every server concurrently and
+// returns each server's result keyed by its address. newReply returns a
+// fresh reply value for each call, e.g. func() interface{} { return new(Stats) }.
+// Servers that have not answered when ctx is done, or could not be
+// connected to by then, get ctx's error, the results of the others are
+// kept. The error is non-nil only if the
+// servers can't be listed.
+func (xc *XClient) Gather(ctx context.Context, serviceMethod string, args interface{}, newReply func() interface{}) (map[string]GatherResult, error) {
+	servers, err := xc.getAll()
+	if err != nil {
+		return nil, err
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex // protect results
+	results := make(map[string]GatherResult, len(servers))
+	for _, rpcAddr := range servers {
+		wg.Add(1)
+		go func(rpcAddr string) {
+			defer wg.Done()
+			reply := newReply()
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+			mu.Lock()
+			results[rpcAddr] = GatherResult{Reply: reply, Err: err}
+			mu.Unlock()
+		}(rpcAddr)
+	}
+	wg.Wait()
+	return results, nil
+}
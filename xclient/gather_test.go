@@ -0,0 +1,65 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"tinyrpc"
+)
+
+// Shard answers Shard.Count with n, fails if n is negative,
+// and takes delay to answer.
+type Shard struct {
+	n     int
+	delay time.Duration
+}
+
+func (s *Shard) Count(args int, reply *int) error {
+	time.Sleep(s.delay)
+	if s.n < 0 {
+		return errors.New("shard unavailable")
+	}
+	*reply = s.n
+	return nil
+}
+
+func TestXClient_Gather(t *testing.T) {
+	good := startServer(t, func(string) interface{} { return &Shard{n: 3} })
+	bad := startServer(t, func(string) interface{} { return &Shard{n: -1} })
+	slow := startServer(t, func(string) interface{} { return &Shard{n: 5, delay: time.Second} })
+	xc := NewXClient(NewMultiServerDiscovery([]string{good, bad, slow}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	results, err := xc.Gather(ctx, "Shard.Count", 0, func() interface{} { return new(int) })
+	_assert(err == nil, "expect no error, but got %v", err)
+	_assert(time.Since(start) < 900*time.Millisecond, "expect Gather to stop at the deadline")
+	_assert(len(results) == 3, "expect 3 results, but got %d", len(results))
+
+	r := results[good]
+	_assert(r.Err == nil && *r.Reply.(*int) == 3, "expect 3 from %s, but got %v, %v", good, r.Reply, r.Err)
+	r = results[bad]
+	_assert(r.Err != nil && r.Err.Error() == "shard unavailable", "expect the method error, but got %v", r.Err)
+	r = results[slow]
+	_assert(errors.Is(r.Err, context.DeadlineExceeded), "expect a deadline error, but got %v", r.Err)
+}
+
+func TestXClient_GatherBlackholed(t *testing.T) {
+	good := startServer(t, func(string) interface{} { return &Shard{n: 3} })
+	dialer := newBlackholeDialer()
+	xc := NewXClient(NewMultiServerDiscovery([]string{blackholeAddr, good}), RandomSelect, &tinyrpc.Option{Dialer: dialer})
+	defer func() { _ = xc.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	results, err := xc.Gather(ctx, "Shard.Count", 0, func() interface{} { return new(int) })
+	_assert(err == nil && time.Since(start) < 900*time.Millisecond, "expect Gather to stop at the deadline, but got %v after %s", err, time.Since(start))
+	r := results[good]
+	_assert(r.Err == nil && *r.Reply.(*int) == 3, "expect the result of the reachable server, but got %v, %v", r.Reply, r.Err)
+	r = results[blackholeAddr]
+	_assert(errors.Is(r.Err, context.DeadlineExceeded), "expect a deadline error for the blackholed server, but got %v", r.Err)
+}
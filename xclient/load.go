@@ -0,0 +1,101 @@
+package xclient
+
+import (
+	"time"
+	"tinyrpc/registry"
+)
+
+// DefaultLoadStaleness is how long a reported load is used by LoadAwareSelect.
+const DefaultLoadStaleness = 5 * time.Minute
+
+type reportedLoad struct {
+	load registry.Load
+	at   time.Time
+}
+
+// UpdateLoad records the load rpcAddr reported at the given time.
+// RegistryDiscovery calls it with the loads sent by the registry.
+func (d *MultiServersDiscovery) UpdateLoad(rpcAddr string, load registry.Load, at time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.loads == nil {
+		d.loads = make(map[string]reportedLoad)
+	}
+	d.loads[canonical(rpcAddr)] = reportedLoad{load, at}
+}
+
+// pruneLoads forgets the loads of servers no longer listed. d.mu must be held.
+func (d *MultiServersDiscovery) pruneLoads(servers []string) {
+	if len(d.loads) == 0 {
+		return
+	}
+	listed := make(map[string]bool, len(servers))
+	for _, addr := range servers {
+		listed[addr] = true
+	}
+	for addr := range d.loads {
+		if !listed[addr] {
+			delete(d.loads, addr)
+		}
+	}
+}
+
+// SetLoadStaleness sets how long a reported load is used by
+// LoadAwareSelect, DefaultLoadStaleness if 0.
+func (d *MultiServersDiscovery) SetLoadStaleness(staleness time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.loadStaleness = staleness
+}
+
+// loadWeight is the inverse of the requests per CPU, in flight and per
+// second. Servers below one request per CPU are equally idle.
+func loadWeight(l registry.Load) float64 {
+	procs := l.Procs
+	if procs < 1 {
+		procs = 1
+	}
+	score := (float64(l.InFlight) + l.QPS) / float64(procs)
+	if score < 1 {
+		score = 1
+	}
+	return 1 / score
+}
+
+// weighted picks one of the servers indexed at random, weighted by their load.
+// Servers without a fresh load get the average weight. d.mu must be held.
+func (d *MultiServersDiscovery) weighted(servers []int, now time.Time) int {
+	staleness := d.loadStaleness
+	if staleness == 0 {
+		staleness = DefaultLoadStaleness
+	}
+	weights := make([]float64, len(servers))
+	var sum float64
+	var known int
+	for i, s := range servers {
+		if l, ok := d.loads[d.addrs[s]]; ok && now.Sub(l.at) <= staleness {
+			weights[i] = loadWeight(l.load)
+			sum += weights[i]
+			known++
+		}
+	}
+	avg := 1.0
+	if known > 0 {
+		avg = sum / float64(known)
+	}
+	var total float64
+	for i := range weights {
+		if weights[i] == 0 {
+			weights[i] = avg
+		}
+		total += weights[i]
+	}
+	x := d.r.Float64() * total
+	for i, w := range weights {
+		if x < w {
+			return servers[i]
+		}
+		x -= w
+	}
+	return servers[len(servers)-1]
+}
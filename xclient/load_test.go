@@ -0,0 +1,52 @@
+package xclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"tinyrpc/registry"
+)
+
+func TestLoadAwareSelect(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{"light", "heavy", "unknown", "stale"})
+	now := time.Now()
+	d.UpdateLoad("light", registry.Load{QPS: 100, Procs: 1}, now)
+	d.UpdateLoad("heavy", registry.Load{QPS: 900, InFlight: 100, Procs: 1}, now)
+	d.UpdateLoad("stale", registry.Load{QPS: 1e6, Procs: 1}, now.Add(-time.Hour))
+
+	counts := make(map[string]int)
+	for i := 0; i < 40000; i++ {
+		addr, err := d.Get(LoadAwareSelect)
+		_assert(err == nil, "get error: %v", err)
+		counts[addr]++
+	}
+	// weights 0.01, 0.001, and the average 0.0055 for the other two
+	ratio := float64(counts["light"]) / float64(counts["heavy"])
+	_assert(ratio > 8 && ratio < 12, "expect 10x the traffic on light, but got %v", counts)
+	ratio = float64(counts["unknown"]) / float64(counts["stale"])
+	_assert(ratio > 0.85 && ratio < 1.15, "expect missing and stale loads weighted alike, but got %v", counts)
+	ratio = float64(counts["light"]) / float64(counts["unknown"])
+	_assert(ratio > 1.6 && ratio < 2.0, "expect average weight for missing loads, but got %v", counts)
+}
+
+func TestRegistryDiscovery_Load(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode([]registry.Entry{
+			{Addr: "tcp@a:1", Load: &registry.Load{QPS: 10, Procs: 1}, AgeMillis: 1000},
+			{Addr: "tcp@b:1"},
+		})
+	}))
+	defer ts.Close()
+	d := NewRegistryDiscovery(ts.URL, time.Minute)
+	servers, err := d.GetAll()
+	_assert(err == nil && len(servers) == 2, "expect 2 servers, but got %v, %v", servers, err)
+	d.mu.Lock()
+	l, ok := d.loads["tcp@a:1"]
+	_, okB := d.loads["tcp@b:1"]
+	d.mu.Unlock()
+	_assert(ok && l.load.QPS == 10 && time.Since(l.at) >= time.Second, "expect the load of a, but got %+v", l)
+	_assert(!okB, "expect no load for b")
+}
@@ -0,0 +1,33 @@
+package xclient
+
+import (
+	"context"
+	"testing"
+	"tinyrpc"
+)
+
+type Tagger string
+
+func (tg *Tagger) Tag(ctx context.Context, args int, reply *string) error {
+	*reply = tinyrpc.HeaderFromContext(ctx)["user"]
+	tinyrpc.SetTrailer(ctx, "server", string(*tg))
+	return nil
+}
+
+func TestXClient_CallOptions(t *testing.T) {
+	addr := startServer(t, func(addr string) interface{} {
+		tg := Tagger(addr)
+		return &tg
+	})
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var reply string
+	var md tinyrpc.Metadata
+	err := xc.Call(context.Background(), "Tagger.Tag", 0, &reply, tinyrpc.WithHeader("user", "u1"), tinyrpc.WithTrailer(&md))
+	_assert(err == nil && reply == "u1" && md["server"] == addr, "expect both directions, but got %q, %v, %v", reply, md, err)
+
+	md = nil
+	err = xc.CallWithSession(context.Background(), "s1", "Tagger.Tag", 0, &reply, tinyrpc.WithHeader("user", "u2"), tinyrpc.WithTrailer(&md))
+	_assert(err == nil && reply == "u2" && md["server"] == addr, "expect both directions, but got %q, %v, %v", reply, md, err)
+}
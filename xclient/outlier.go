@@ -0,0 +1,246 @@
+package xclient
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// OutlierConfig configures the ejection of servers failing more often
+// than their peers. Zero fields take the defaults below.
+type OutlierConfig struct {
+	Window          time.Duration // calls counted by the error rates, DefaultOutlierWindow
+	MinRequests     int           // calls of a server in the window before it is judged, DefaultOutlierMinRequests
+	Multiplier      float64       // how many times the peers' error rate makes an outlier, DefaultOutlierMultiplier
+	MinErrorRate    float64       // error rate below which no server is ejected, DefaultOutlierMinErrorRate
+	EjectionTime    time.Duration // how long an outlier is excluded, DefaultOutlierEjectionTime
+	MaxEjectPercent float64       // share of the servers ejected at most, DefaultOutlierMaxEjectPercent
+}
+
+// Defaults of OutlierConfig.
+const (
+	DefaultOutlierWindow          = 10 * time.Second
+	DefaultOutlierMinRequests     = 20
+	DefaultOutlierMultiplier      = 2
+	DefaultOutlierMinErrorRate    = 0.05
+	DefaultOutlierEjectionTime    = 30 * time.Second
+	DefaultOutlierMaxEjectPercent = 50
+)
+
+// outlierBuckets divide the window, so that old calls expire gradually.
+const outlierBuckets = 10
+
+type outcomeBucket struct {
+	start     time.Time
+	ok, fails int
+}
+
+// serverOutcomes are the results of the calls to one server in the window.
+type serverOutcomes struct {
+	buckets      [outlierBuckets]outcomeBucket
+	ejectedUntil time.Time // zero if not ejected
+}
+
+func (s *serverOutcomes) add(now time.Time, width time.Duration, failed bool) {
+	start := now.Truncate(width)
+	b := &s.buckets[start.UnixNano()/int64(width)%outlierBuckets]
+	if !b.start.Equal(start) {
+		*b = outcomeBucket{start: start}
+	}
+	if failed {
+		b.fails++
+	} else {
+		b.ok++
+	}
+}
+
+func (s *serverOutcomes) counts(now time.Time, window time.Duration) (total, fails int) {
+	for _, b := range s.buckets {
+		if now.Sub(b.start) < window {
+			total += b.ok + b.fails
+			fails += b.fails
+		}
+	}
+	return total, fails
+}
+
+// outliers ejects servers whose error rate is Multiplier times the mean
+// error rate of the other servers.
+type outliers struct {
+	mu      sync.Mutex // protect following
+	cfg     OutlierConfig
+	servers map[string]*serverOutcomes
+	events  []func(rpcAddr string, ejected bool)
+	now     func() time.Time
+}
+
+// EnableOutlierDetection makes xc count the errors of the calls to each
+// server and stop selecting the servers that fail noticeably more often
+// than their peers, for cfg.EjectionTime. At most cfg.MaxEjectPercent of
+// the servers are ejected at once, so a global outage ejects no more.
+// fn, if not nil, is called when a server is ejected or readmitted.
+// The XClients of a ClientRegistry dialing with the same options share
+// the detection, configured by the first one enabling it.
+func (xc *XClient) EnableOutlierDetection(cfg OutlierConfig, fn func(rpcAddr string, ejected bool)) {
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultOutlierWindow
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = DefaultOutlierMinRequests
+	}
+	if cfg.Multiplier <= 0 {
+		cfg.Multiplier = DefaultOutlierMultiplier
+	}
+	if cfg.MinErrorRate <= 0 {
+		cfg.MinErrorRate = DefaultOutlierMinErrorRate
+	}
+	if cfg.EjectionTime <= 0 {
+		cfg.EjectionTime = DefaultOutlierEjectionTime
+	}
+	if cfg.MaxEjectPercent <= 0 {
+		cfg.MaxEjectPercent = DefaultOutlierMaxEjectPercent
+	}
+	o := &outliers{cfg: cfg, servers: make(map[string]*serverOutcomes), now: xc.clock.Now}
+	if fn != nil {
+		o.events = append(o.events, fn)
+	}
+	if xc.health != nil {
+		o = xc.health.outlierDetection(o, fn)
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.outliers = o
+}
+
+// Ejected returns the servers currently ejected as outliers, sorted.
+func (xc *XClient) Ejected() []string {
+	o := xc.outlierDetection()
+	if o == nil {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := o.now()
+	var addrs []string
+	for addr, s := range o.servers {
+		if now.Before(s.ejectedUntil) {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func (xc *XClient) outlierDetection() *outliers {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return xc.outliers
+}
+
+// record counts the result of a call to rpcAddr, and ejects rpcAddr if
+// this makes it an outlier.
+func (o *outliers) record(rpcAddr string, failed bool) {
+	o.mu.Lock()
+	now := o.now()
+	s := o.servers[rpcAddr]
+	if s == nil {
+		s = &serverOutcomes{}
+		o.servers[rpcAddr] = s
+	}
+	s.add(now, o.cfg.Window/outlierBuckets, failed)
+	ejected := failed && s.ejectedUntil.IsZero() && o.isOutlier(rpcAddr, now) && o.canEject(now)
+	if ejected {
+		s.ejectedUntil = now.Add(o.cfg.EjectionTime)
+	}
+	events := o.events
+	o.mu.Unlock()
+	if ejected {
+		for _, fn := range events {
+			fn(rpcAddr, true)
+		}
+	}
+}
+
+// isOutlier compares the error rate of rpcAddr to the mean of its peers
+// with enough calls in the window. o.mu must be held.
+func (o *outliers) isOutlier(rpcAddr string, now time.Time) bool {
+	total, fails := o.servers[rpcAddr].counts(now, o.cfg.Window)
+	if total < o.cfg.MinRequests {
+		return false
+	}
+	rate := float64(fails) / float64(total)
+	if rate < o.cfg.MinErrorRate {
+		return false
+	}
+	var sum float64
+	peers := 0
+	for addr, s := range o.servers {
+		if addr == rpcAddr {
+			continue
+		}
+		if t, f := s.counts(now, o.cfg.Window); t >= o.cfg.MinRequests {
+			sum += float64(f) / float64(t)
+			peers++
+		}
+	}
+	return peers > 0 && rate > o.cfg.Multiplier*sum/float64(peers)
+}
+
+// canEject reports whether one more server may be ejected. o.mu must be held.
+func (o *outliers) canEject(now time.Time) bool {
+	ejected := 0
+	for _, s := range o.servers {
+		if now.Before(s.ejectedUntil) {
+			ejected++
+		}
+	}
+	return float64(ejected+1) <= float64(len(o.servers))*o.cfg.MaxEjectPercent/100
+}
+
+// ejected reports whether rpcAddr is ejected, readmitting it once its
+// ejection time is over.
+func (o *outliers) ejected(rpcAddr string) bool {
+	o.mu.Lock()
+	s := o.servers[rpcAddr]
+	if s == nil || s.ejectedUntil.IsZero() {
+		o.mu.Unlock()
+		return false
+	}
+	if o.now().Before(s.ejectedUntil) {
+		o.mu.Unlock()
+		return true
+	}
+	*s = serverOutcomes{} // judged afresh
+	events := o.events
+	o.mu.Unlock()
+	for _, fn := range events {
+		fn(rpcAddr, false)
+	}
+	return false
+}
+
+// pick returns a server from the discovery for serviceMethod that is not
+// ejected, not quarantined by the registry of xc and, if xc dials
+// eagerly, warmed up and ready for the service. When every server tried
+// is unfit it returns the last one, so calls still go somewhere.
+func (xc *XClient) pick(serviceMethod string) (string, error) {
+	rpcAddr, err := xc.get()
+	o, e, h := xc.outlierDetection(), xc.eagerDial(), xc.health
+	if err != nil || (o == nil && e == nil && h == nil) {
+		return rpcAddr, err
+	}
+	unfit := func(rpcAddr string) bool {
+		return (o != nil && o.ejected(rpcAddr)) || (e != nil && !xc.selectable(e, rpcAddr, serviceMethod)) ||
+			(h != nil && h.q.quarantined(rpcAddr, xc.clock.Now()))
+	}
+	tries := 1
+	if entries, err := xc.d.GetAllEntries(); err == nil {
+		tries = len(entries) // the quarantined ones included
+	}
+	for ; tries > 0 && unfit(rpcAddr); tries-- {
+		if rpcAddr, err = xc.get(); err != nil {
+			return "", err
+		}
+	}
+	return rpcAddr, nil
+}
@@ -0,0 +1,97 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Flaky fails the given share of its calls, in tenths.
+type Flaky struct {
+	addr  string
+	tenth int64
+	calls int64
+}
+
+func (f *Flaky) Name(args int, reply *string) error {
+	if atomic.AddInt64(&f.calls, 1)%10 < f.tenth {
+		return errors.New("flaky")
+	}
+	*reply = f.addr
+	return nil
+}
+
+func startFlaky(t *testing.T, tenth int64) string {
+	return startServer(t, func(addr string) interface{} {
+		return &Flaky{addr: addr, tenth: tenth}
+	})
+}
+
+type ejections struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (e *ejections) record(rpcAddr string, ejected bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if ejected {
+		e.events = append(e.events, "eject "+rpcAddr)
+	} else {
+		e.events = append(e.events, "readmit "+rpcAddr)
+	}
+}
+
+func (e *ejections) list() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.events...)
+}
+
+func TestXClient_OutlierEjection(t *testing.T) {
+	flaky := startFlaky(t, 3)
+	addrs := []string{startFlaky(t, 0), startFlaky(t, 0), startFlaky(t, 0), flaky}
+	xc := NewXClient(NewMultiServerDiscovery(addrs), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	var events ejections
+	xc.EnableOutlierDetection(OutlierConfig{EjectionTime: 200 * time.Millisecond}, events.record)
+
+	for i := 0; i < 200; i++ {
+		var reply string
+		_ = xc.Call(context.Background(), "Flaky.Name", 0, &reply)
+	}
+	_assert(len(xc.Ejected()) == 1 && xc.Ejected()[0] == flaky, "expect the flaky server ejected, but got %v", xc.Ejected())
+	_assert(len(events.list()) == 1 && events.list()[0] == "eject "+flaky, "unexpected events %v", events.list())
+
+	for i := 0; i < 30; i++ {
+		var reply string
+		err := xc.Call(context.Background(), "Flaky.Name", 0, &reply)
+		_assert(err == nil && reply != flaky, "expect the ejected server skipped, but got %q, %v", reply, err)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	for i := 0; i < len(addrs); i++ {
+		var reply string
+		_ = xc.Call(context.Background(), "Flaky.Name", 0, &reply)
+	}
+	got := events.list()
+	_assert(len(got) == 2 && got[1] == "readmit "+flaky, "expect the flaky server readmitted, but got %v", got)
+	_assert(len(xc.Ejected()) == 0, "expect no server ejected after readmission")
+}
+
+func TestXClient_OutlierEjectionCap(t *testing.T) {
+	// a global outage: every server fails, some a bit more than others
+	addrs := []string{startFlaky(t, 10), startFlaky(t, 10), startFlaky(t, 5), startFlaky(t, 5)}
+	xc := NewXClient(NewMultiServerDiscovery(addrs), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.EnableOutlierDetection(OutlierConfig{Multiplier: 1.2, MaxEjectPercent: 25}, nil)
+
+	for i := 0; i < 400; i++ {
+		var reply string
+		_ = xc.Call(context.Background(), "Flaky.Name", 0, &reply)
+	}
+	_assert(len(xc.Ejected()) == 1, "expect at most 25%% of the servers ejected, but got %v", xc.Ejected())
+}
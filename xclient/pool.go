@@ -0,0 +1,275 @@
+package xclient
+
+import (
+	"context"
+	"expvar"
+	"sort"
+	"time"
+	"tinyrpc"
+)
+
+// PoolConfig limits the connections XClient keeps to the servers.
+// Zero fields mean no limit.
+type PoolConfig struct {
+	// MaxIdle is the number of connections without calls in flight kept
+	// open; the least recently used ones are closed first.
+	MaxIdle int
+	// IdleTimeout closes connections unused for this long, keeping at
+	// least one connection open.
+	IdleTimeout time.Duration
+	// MaxLifetime replaces connections older than this once they have
+	// no calls in flight, e.g. so that a load balancer in front of the
+	// servers can rebalance them. The new connection is made before the
+	// old one is closed.
+	MaxLifetime time.Duration
+}
+
+// PoolStats counts the connections of an XClient.
+type PoolStats struct {
+	Open     int    // connections open now
+	Dialed   uint64 // connections made, including replacements
+	Expired  uint64 // closed by IdleTimeout
+	Evicted  uint64 // closed by MaxIdle
+	Recycled uint64 // replaced by MaxLifetime
+}
+
+// DefaultPoolSweep is how often the pool is checked when only MaxIdle is set.
+const DefaultPoolSweep = time.Second
+
+// pooledConn is a connection of the pool.
+type pooledConn struct {
+	client    *tinyrpc.Client
+	created   time.Time
+	lastUsed  time.Time
+	inflight  int
+	retired   bool // removed from the pool, closed once inflight drops to 0
+	recycling bool // a replacement is being dialed
+}
+
+// pool is the state of the connections of an XClient, protected by its mu.
+type pool struct {
+	cfg  PoolConfig
+	now  func() time.Time
+	stop chan struct{} // closed to stop the sweeper, nil if none
+
+	dialed, expired, evicted, recycled uint64
+
+	targets map[string]*targetStats
+}
+
+// targetStats are the stats of the closed connections to a server.
+type targetStats struct {
+	closed tinyrpc.ClientStats
+	dials  uint64
+}
+
+func (p *pool) target(rpcAddr string) *targetStats {
+	if p.targets == nil {
+		p.targets = make(map[string]*targetStats)
+	}
+	t := p.targets[rpcAddr]
+	if t == nil {
+		t = &targetStats{}
+		p.targets[rpcAddr] = t
+	}
+	return t
+}
+
+func (p *pool) newConn(rpcAddr string, client *tinyrpc.Client) *pooledConn {
+	p.dialed++
+	p.target(rpcAddr).dials++
+	now := p.now()
+	return &pooledConn{client: client, created: now, lastUsed: now}
+}
+
+// SetPoolConfig sets the limits of the connections to the servers. They
+// are enforced in the background, on every sweep interval: half the
+// smallest of IdleTimeout and MaxLifetime, or DefaultPoolSweep.
+func (xc *XClient) SetPoolConfig(cfg PoolConfig) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.pool.cfg = cfg
+	if xc.pool.stop != nil {
+		close(xc.pool.stop)
+		xc.pool.stop = nil
+	}
+	if cfg.MaxIdle <= 0 && cfg.IdleTimeout <= 0 && cfg.MaxLifetime <= 0 {
+		return
+	}
+	interval := DefaultPoolSweep
+	for _, d := range []time.Duration{cfg.IdleTimeout, cfg.MaxLifetime} {
+		if d > 0 && d/2 < interval {
+			interval = d / 2
+		}
+	}
+	stop := make(chan struct{})
+	xc.pool.stop = stop
+	go func() {
+		ticker := xc.clock.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				xc.sweep()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// PoolStats returns the counters of the connections to the servers.
+func (xc *XClient) PoolStats() PoolStats {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return PoolStats{
+		Open:     len(xc.clients),
+		Dialed:   xc.pool.dialed,
+		Expired:  xc.pool.expired,
+		Evicted:  xc.pool.evicted,
+		Recycled: xc.pool.recycled,
+	}
+}
+
+// release ends a call on pc.
+func (xc *XClient) release(pc *pooledConn) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	pc.inflight--
+	pc.lastUsed = xc.pool.now()
+	if pc.retired && pc.inflight == 0 {
+		xc.closeClient(pc.client)
+	}
+}
+
+// removeConn removes pc from the pool, closing it unless calls are in
+// flight. xc.mu must be held.
+func (xc *XClient) removeConn(rpcAddr string, pc *pooledConn) {
+	if xc.clients[rpcAddr] == pc {
+		delete(xc.clients, rpcAddr)
+	}
+	if pc.retired {
+		return
+	}
+	if xc.shared == nil { // else the registry counts it once closed
+		stats := pc.client.Stats()
+		stats.InFlight = 0 // the calls still in flight are no longer counted
+		xc.pool.target(rpcAddr).closed.Merge(stats)
+	}
+	pc.retired = true
+	if pc.inflight == 0 {
+		xc.closeClient(pc.client)
+	}
+}
+
+// idleConns returns the addresses of the connections without calls in
+// flight, least recently used first. xc.mu must be held.
+func (xc *XClient) idleConns() []string {
+	var addrs []string
+	for addr, pc := range xc.clients {
+		if pc.inflight == 0 {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return xc.clients[addrs[i]].lastUsed.Before(xc.clients[addrs[j]].lastUsed)
+	})
+	return addrs
+}
+
+// sweep enforces the PoolConfig.
+func (xc *XClient) sweep() {
+	xc.mu.Lock()
+	cfg, now := xc.pool.cfg, xc.pool.now()
+	idle := xc.idleConns()
+	if cfg.IdleTimeout > 0 {
+		kept := idle[:0]
+		for _, addr := range idle {
+			if len(xc.clients) > 1 && now.Sub(xc.clients[addr].lastUsed) >= cfg.IdleTimeout {
+				xc.removeConn(addr, xc.clients[addr])
+				xc.pool.expired++
+				continue
+			}
+			kept = append(kept, addr)
+		}
+		idle = kept
+	}
+	if cfg.MaxIdle > 0 {
+		for len(idle) > cfg.MaxIdle {
+			xc.removeConn(idle[0], xc.clients[idle[0]])
+			xc.pool.evicted++
+			idle = idle[1:]
+		}
+	}
+	var old []*pooledConn
+	var addrs []string
+	if cfg.MaxLifetime > 0 {
+		for _, addr := range idle {
+			if pc := xc.clients[addr]; !pc.recycling && now.Sub(pc.created) >= cfg.MaxLifetime {
+				pc.recycling = true
+				old, addrs = append(old, pc), append(addrs, addr)
+			}
+		}
+	}
+	xc.mu.Unlock()
+
+	for i, addr := range addrs {
+		xc.recycle(addr, old[i])
+	}
+}
+
+// recycle replaces pc, the connection to rpcAddr, with a new one. pc is
+// kept if the new one cannot be made.
+func (xc *XClient) recycle(rpcAddr string, pc *pooledConn) {
+	client, err := xc.dialClient(context.Background(), rpcAddr, true)
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	pc.recycling = false
+	if err != nil {
+		return
+	}
+	if xc.clients[rpcAddr] != pc {
+		xc.closeClient(client) // closed or replaced meanwhile
+		return
+	}
+	xc.removeConn(rpcAddr, pc)
+	xc.clients[rpcAddr] = xc.pool.newConn(rpcAddr, client)
+	xc.pool.recycled++
+}
+
+// TargetStats are the stats of the calls to one server, over all the
+// connections made to it.
+type TargetStats struct {
+	tinyrpc.ClientStats
+	Reconnects uint64 // connections made after the first one
+}
+
+// Stats returns the stats of the calls to each server, by address. Those
+// of an XClient sharing its connections, see ClientRegistry, count the
+// calls of all the XClients sharing them.
+func (xc *XClient) Stats() map[string]TargetStats {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if xc.shared != nil {
+		xc.shared.mu.Lock()
+		defer xc.shared.mu.Unlock()
+		return xc.shared.stats(xc.pool.targets)
+	}
+	stats := make(map[string]TargetStats, len(xc.pool.targets))
+	for addr, t := range xc.pool.targets {
+		s := TargetStats{Reconnects: t.dials - 1}
+		s.Merge(t.closed)
+		if pc := xc.clients[addr]; pc != nil {
+			s.Merge(pc.client.Stats())
+		}
+		stats[addr] = s
+	}
+	return stats
+}
+
+// PublishStats publishes the stats of xc under name with expvar, so
+// they are served on /debug/vars. Like expvar.Publish, it panics if name
+// is already taken.
+func PublishStats(name string, xc *XClient) {
+	expvar.Publish(name, expvar.Func(func() interface{} { return xc.Stats() }))
+}
@@ -0,0 +1,94 @@
+package xclient
+
+import (
+	"context"
+	"testing"
+	"time"
+	"tinyrpc"
+	"tinyrpc/tinyrpctest"
+)
+
+// newPoolClient returns an XClient with cfg and a fake clock, connected
+// to each of servers in turn, one fake second apart.
+func newPoolClient(t *testing.T, servers []string, cfg PoolConfig) (*XClient, *tinyrpctest.Clock) {
+	t.Helper()
+	clock := tinyrpctest.NewClock()
+	xc := NewXClient(NewMultiServerDiscovery(servers), RoundRobinSelect, &tinyrpc.Option{Clock: clock})
+	t.Cleanup(func() { _ = xc.Close() })
+	xc.pool.cfg = cfg // no background sweeps
+	for _, addr := range servers {
+		var reply string
+		if err := xc.call(addr, context.Background(), "Who.Name", 0, &reply); err != nil {
+			t.Fatal("call error:", err)
+		}
+		clock.Advance(time.Second)
+	}
+	return xc, clock
+}
+
+func TestPool_IdleTimeout(t *testing.T) {
+	xc, clock := newPoolClient(t, startServers(t, 3), PoolConfig{IdleTimeout: 30 * time.Second})
+	clock.Advance(27 * time.Second) // unused for 30s, 29s and 28s
+	xc.sweep()
+	stats := xc.PoolStats()
+	_assert(stats.Open == 2 && stats.Expired == 1, "expect the oldest connection expired, but got %+v", stats)
+
+	clock.Advance(time.Hour)
+	xc.sweep()
+	stats = xc.PoolStats()
+	_assert(stats.Open == 1 && stats.Expired == 2, "expect one connection kept, but got %+v", stats)
+}
+
+func TestPool_MaxIdle(t *testing.T) {
+	servers := startServers(t, 3)
+	xc, _ := newPoolClient(t, servers, PoolConfig{MaxIdle: 1})
+	xc.sweep()
+	stats := xc.PoolStats()
+	_assert(stats.Open == 1 && stats.Evicted == 2, "expect 2 connections evicted, but got %+v", stats)
+	xc.mu.Lock()
+	_, kept := xc.clients[servers[2]]
+	xc.mu.Unlock()
+	_assert(kept, "expect the most recently used connection kept")
+}
+
+func TestPool_MaxLifetime(t *testing.T) {
+	servers := startServers(t, 1)
+	xc, clock := newPoolClient(t, servers, PoolConfig{MaxLifetime: time.Minute})
+	clock.Advance(2 * time.Minute)
+
+	// a call in flight defers the recycling
+	pc, err := xc.dial(context.Background(), servers[0])
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	xc.sweep()
+	_assert(xc.PoolStats().Recycled == 0, "expect no recycling with a call in flight")
+	xc.release(pc)
+
+	xc.sweep()
+	stats := xc.PoolStats()
+	_assert(stats.Open == 1 && stats.Recycled == 1 && stats.Dialed == 2, "expect the connection recycled, but got %+v", stats)
+	_assert(!pc.client.IsAvailable(), "expect the old connection closed")
+	var reply string
+	err = xc.Call(context.Background(), "Who.Name", 0, &reply)
+	_assert(err == nil && reply == servers[0], "expect calls to use the new connection, but got %v", err)
+	_assert(xc.PoolStats().Dialed == 2, "expect no dial on the call")
+}
+
+func TestXClient_Stats(t *testing.T) {
+	servers := startServers(t, 2)
+	xc, _ := newPoolClient(t, servers, PoolConfig{MaxLifetime: time.Nanosecond})
+	for i := 0; i < 3; i++ {
+		var reply string
+		_ = xc.call(servers[0], context.Background(), "Who.Name", 0, &reply)
+		_ = xc.call(servers[0], context.Background(), "Who.Missing", 0, &reply)
+	}
+	xc.sweep() // recycles both connections, their counts are kept
+
+	stats := xc.Stats()
+	first, second := stats[servers[0]], stats[servers[1]]
+	_assert(first.Calls == 7 && first.ApplicationErrors == 3 && first.Reconnects == 1,
+		"unexpected stats of the first server %+v", first)
+	_assert(second.Calls == 1 && second.ApplicationErrors == 0 && second.Reconnects == 1,
+		"unexpected stats of the second server %+v", second)
+}
@@ -0,0 +1,154 @@
+package xclient
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// FailureReporter is implemented by discoveries that exclude the servers
+// XClient failed to dial, so other clients don't try them again either.
+type FailureReporter interface {
+	ReportFailure(rpcAddr string)
+}
+
+var (
+	_ FailureReporter = (*MultiServersDiscovery)(nil)
+	_ FailureReporter = (*RegistryDiscovery)(nil)
+)
+
+const (
+	// DefaultQuarantine is how long a server is excluded after its first failure.
+	DefaultQuarantine = 5 * time.Second
+	// DefaultMaxQuarantine caps the quarantine of servers failing repeatedly.
+	DefaultMaxQuarantine = 5 * time.Minute
+)
+
+// QuarantineInfo describes a quarantined server.
+type QuarantineInfo struct {
+	Addr     string
+	Failures int       // consecutive failures, each one doubles the quarantine
+	Until    time.Time // when the server is returned again
+}
+
+// quarantine excludes failed servers for a period growing exponentially
+// with their consecutive failures.
+type quarantine struct {
+	mu      sync.Mutex // protect following
+	base    time.Duration
+	max     time.Duration
+	entries map[string]*QuarantineInfo
+}
+
+func (q *quarantine) durations() (base, max time.Duration) {
+	base, max = q.base, q.max
+	if base == 0 {
+		base = DefaultQuarantine
+	}
+	if max == 0 {
+		max = DefaultMaxQuarantine
+	}
+	return base, max
+}
+
+func (q *quarantine) report(rpcAddr string, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	base, max := q.durations()
+	if q.entries == nil {
+		q.entries = make(map[string]*QuarantineInfo)
+	}
+	e := q.entries[rpcAddr]
+	if e == nil {
+		e = &QuarantineInfo{Addr: rpcAddr}
+		q.entries[rpcAddr] = e
+	}
+	if now.Before(e.Until) {
+		return // already excluded, a report from a call started earlier
+	}
+	e.Failures++
+	d := base
+	for i := 1; i < e.Failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	e.Until = now.Add(d)
+}
+
+// filter returns servers without the quarantined ones.
+func (q *quarantine) filter(servers []string, now time.Time) []string {
+	keep := q.keep(len(servers), func(i int) string { return servers[i] }, now)
+	if keep == nil {
+		return servers
+	}
+	alive := make([]string, 0, len(servers))
+	for i, addr := range servers {
+		if keep[i] {
+			alive = append(alive, addr)
+		}
+	}
+	return alive
+}
+
+// keep reports which of the n servers, addr(i) for each, are not
+// quarantined, nil if none has failed. A server that has been out of quarantine
+// for max without failing starts over at base.
+func (q *quarantine) keep(n int, addr func(i int) string, now time.Time) []bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) == 0 {
+		return nil
+	}
+	_, max := q.durations()
+	for addr, e := range q.entries {
+		if now.Sub(e.Until) > max {
+			delete(q.entries, addr)
+		}
+	}
+	keep := make([]bool, n)
+	for i := range keep {
+		e := q.entries[addr(i)]
+		keep[i] = e == nil || !now.Before(e.Until)
+	}
+	return keep
+}
+
+// quarantined reports whether rpcAddr is quarantined.
+func (q *quarantine) quarantined(rpcAddr string, now time.Time) bool {
+	keep := q.keep(1, func(int) string { return rpcAddr }, now)
+	return keep != nil && !keep[0]
+}
+
+func (q *quarantine) list(now time.Time) []QuarantineInfo {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var infos []QuarantineInfo
+	for _, e := range q.entries {
+		if now.Before(e.Until) {
+			infos = append(infos, *e)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Addr < infos[j].Addr })
+	return infos
+}
+
+// ReportFailure excludes rpcAddr from Get and GetAll for the quarantine
+// period, doubled for each consecutive failure.
+func (d *MultiServersDiscovery) ReportFailure(rpcAddr string) {
+	d.q.report(canonical(rpcAddr), time.Now())
+}
+
+// SetQuarantine sets the quarantine of a server after its first failure
+// and its cap for repeated failures, the defaults if 0.
+func (d *MultiServersDiscovery) SetQuarantine(base, max time.Duration) {
+	d.q.mu.Lock()
+	defer d.q.mu.Unlock()
+	d.q.base, d.q.max = base, max
+}
+
+// Quarantined returns the servers currently excluded, sorted by address.
+func (d *MultiServersDiscovery) Quarantined() []QuarantineInfo {
+	return d.q.list(time.Now())
+}
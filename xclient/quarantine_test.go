@@ -0,0 +1,92 @@
+package xclient
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"tinyrpc/registry"
+)
+
+// deadAddr returns the address of a closed local port.
+func deadAddr(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	addr := "tcp@" + lis.Addr().String()
+	_ = lis.Close()
+	return addr
+}
+
+func TestQuarantine_DeadServer(t *testing.T) {
+	live := startServers(t, 1)[0]
+	dead := deadAddr(t)
+	d := NewMultiServerDiscovery([]string{dead, live})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var failed bool
+	for i := 0; i < 2; i++ {
+		var name string
+		if err := xc.Call(context.Background(), "Who.Name", 0, &name); err != nil {
+			failed = true
+		}
+	}
+	_assert(failed, "expect one call to hit the dead server")
+	servers, _ := d.GetAll()
+	_assert(len(servers) == 1 && servers[0] == live, "expect only %s, but got %v", live, servers)
+	q := d.Quarantined()
+	_assert(len(q) == 1 && q[0].Addr == dead && q[0].Failures == 1, "expect %s quarantined, but got %+v", dead, q)
+
+	// a new XClient on the same discovery doesn't try the dead server again
+	xc2 := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc2.Close() }()
+	for i := 0; i < 10; i++ {
+		var name string
+		err := xc2.Call(context.Background(), "Who.Name", 0, &name)
+		_assert(err == nil && name == live, "expect %s, but got %q, %v", live, name, err)
+	}
+}
+
+func TestQuarantine_Backoff(t *testing.T) {
+	var q quarantine
+	q.base, q.max = time.Second, 5*time.Second
+	now := time.Now()
+	servers := []string{"a", "b"}
+	for i, want := range []time.Duration{1, 2, 4, 5, 5} {
+		q.report("a", now)
+		q.report("a", now) // reports during the quarantine don't count
+		until := q.list(now)[0].Until
+		_assert(until.Sub(now) == want*time.Second, "failure %d: expect %ds, but got %s", i+1, want, until.Sub(now))
+		alive := q.filter(servers, now)
+		_assert(len(alive) == 1 && alive[0] == "b", "expect a excluded, but got %v", alive)
+		now = until
+	}
+	// back in service, and forgiven after max without failures
+	_assert(len(q.filter(servers, now)) == 2, "expect a back after its quarantine")
+	_ = q.filter(servers, now.Add(6*time.Second))
+	q.report("a", now.Add(6*time.Second))
+	_assert(q.list(now.Add(6 * time.Second))[0].Failures == 1, "expect the failures to start over")
+}
+
+func TestRegistryDiscovery_ReportFailure(t *testing.T) {
+	r := registry.New(0)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	live := startServers(t, 1)[0]
+	dead := deadAddr(t)
+	registry.Heartbeat(ts.URL, live, time.Hour)
+	registry.Heartbeat(ts.URL, dead, time.Hour)
+
+	d := NewRegistryDiscovery(ts.URL, 0)
+	servers, err := d.GetAll()
+	_assert(err == nil && len(servers) == 2, "expect 2 servers, but got %v, %v", servers, err)
+	d.ReportFailure(dead)
+	d.SetMinRefreshInterval(0)
+	_ = d.ForceRefresh() // the registry still lists dead
+	servers, _ = d.GetAll()
+	_assert(len(servers) == 1 && servers[0] == live, "expect only %s, but got %v", live, servers)
+}
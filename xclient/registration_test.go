@@ -0,0 +1,57 @@
+package xclient
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"tinyrpc"
+	"tinyrpc/registry"
+)
+
+func TestRegistry_Integration(t *testing.T) {
+	ts := httptest.NewServer(registry.New(time.Minute))
+	defer ts.Close()
+
+	var addrs []string
+	var servers []*tinyrpc.Server
+	for i := 0; i < 2; i++ {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal("network error:", err)
+		}
+		addr := "tcp@" + lis.Addr().String()
+		who := Who(addr)
+		server := tinyrpc.NewServer()
+		_ = server.Register(&who)
+		server.EnableRegistry(tinyrpc.RegistryConfig{URLs: []string{ts.URL}, ServiceName: "Who"})
+		go server.Accept(lis)
+		addrs = append(addrs, addr)
+		servers = append(servers, server)
+	}
+	defer func() { _ = servers[1].Shutdown(context.Background()) }()
+
+	d := NewRegistryDiscovery(ts.URL+"?service=Who", time.Minute)
+	d.SetMinRefreshInterval(0)
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	deadline := time.Now().Add(time.Second)
+	seen := make(map[string]bool)
+	for len(seen) < 2 && time.Now().Before(deadline) {
+		_ = d.ForceRefresh()
+		var name string
+		if err := xc.Call(context.Background(), "Who.Name", 0, &name); err == nil {
+			seen[name] = true
+		}
+	}
+	_assert(seen[addrs[0]] && seen[addrs[1]], "expect calls to reach both servers, but got %v", seen)
+
+	_ = servers[0].Shutdown(context.Background())
+	_ = d.ForceRefresh()
+	for i := 0; i < 4; i++ {
+		var name string
+		err := xc.Call(context.Background(), "Who.Name", 0, &name)
+		_assert(err == nil && name == addrs[1], "expect only %s after shutdown, but got %q, %v", addrs[1], name, err)
+	}
+}
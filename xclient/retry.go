@@ -0,0 +1,125 @@
+package xclient
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// DefaultRetryRatio is the share of a retry earned by each successful call.
+	DefaultRetryRatio = 0.1
+	// DefaultRetryBurst is the number of retries saved up at most.
+	DefaultRetryBurst = 10
+)
+
+// ErrRetryBudgetExhausted matches the errors of calls that failed over
+// no further because the retry budget was spent.
+var ErrRetryBudgetExhausted = errors.New("rpc xclient: retry budget exhausted")
+
+// budgetError is the error of the last attempt of a call denied a retry.
+type budgetError struct{ err error }
+
+func (e *budgetError) Error() string        { return ErrRetryBudgetExhausted.Error() + ": " + e.err.Error() }
+func (e *budgetError) Unwrap() error        { return e.err }
+func (e *budgetError) Is(target error) bool { return target == ErrRetryBudgetExhausted }
+
+// RetryStats describes the retry budget of an XClient.
+type RetryStats struct {
+	Tokens    float64 // retries currently allowed
+	Retries   uint64  // retries made
+	Exhausted uint64  // retries denied for lack of budget
+}
+
+// retryScale counts tokens in thousandths of a retry, so that ten
+// deposits of 0.1 add up to exactly one.
+const retryScale = 1000
+
+// retryBudget is a token bucket: successful calls add ratio tokens, up
+// to burst, and every retry takes one. During an outage, when nothing
+// succeeds, retries add at most burst calls to the load.
+type retryBudget struct {
+	mu     sync.Mutex // protect following
+	ratio  int64      // in thousandths, like burst and tokens
+	burst  int64
+	tokens int64
+	init   bool
+
+	retries, exhausted uint64 // accessed atomically
+}
+
+func (b *retryBudget) lazyInit() {
+	if !b.init {
+		b.set(DefaultRetryRatio, DefaultRetryBurst)
+	}
+}
+
+func (b *retryBudget) set(ratio float64, burst int) {
+	b.ratio = int64(math.Round(ratio * retryScale))
+	b.burst = int64(burst) * retryScale
+	b.tokens, b.init = b.burst, true
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lazyInit()
+	if b.tokens += b.ratio; b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// withdraw reports whether a retry is allowed, and if so takes its token.
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lazyInit()
+	if b.tokens < retryScale {
+		atomic.AddUint64(&b.exhausted, 1)
+		return false
+	}
+	b.tokens -= retryScale
+	atomic.AddUint64(&b.retries, 1)
+	return true
+}
+
+// SetRetries makes Call try up to n other servers when a server cannot
+// be reached, or sheds the call with a tinyrpc.RetryAfter, as long as
+// the retry budget allows it. 0, the default, disables retries. Other
+// errors returned by the called method are not retried.
+func (xc *XClient) SetRetries(n int) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.retries = n
+}
+
+// SetRetryBudget sets the share of a retry earned by each successful call
+// and the number of retries saved up at most, DefaultRetryRatio and
+// DefaultRetryBurst if 0. The budget starts full.
+func (xc *XClient) SetRetryBudget(ratio float64, burst int) {
+	if ratio == 0 {
+		ratio = DefaultRetryRatio
+	}
+	if burst == 0 {
+		burst = DefaultRetryBurst
+	}
+	b := &xc.budget
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.set(ratio, burst)
+}
+
+// RetryStats returns the state of the retry budget.
+func (xc *XClient) RetryStats() RetryStats {
+	b := &xc.budget
+	b.mu.Lock()
+	b.lazyInit()
+	tokens := b.tokens
+	b.mu.Unlock()
+	return RetryStats{
+		Tokens:    float64(tokens) / retryScale,
+		Retries:   atomic.LoadUint64(&b.retries),
+		Exhausted: atomic.LoadUint64(&b.exhausted),
+	}
+}
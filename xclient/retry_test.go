@@ -0,0 +1,107 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tinyrpc"
+	"tinyrpc/tinyrpctest"
+)
+
+// downDialer counts the connection attempts and fails them all.
+type downDialer struct{ attempts int64 }
+
+func (d *downDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	atomic.AddInt64(&d.attempts, 1)
+	return nil, errors.New("connection refused")
+}
+
+func TestXClient_RetryFailover(t *testing.T) {
+	addrs := append(startServers(t, 1), deadAddr(t))
+	d := NewMultiServerDiscovery(addrs)
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetRetries(1)
+
+	for i := 0; i < 4; i++ {
+		var reply string
+		err := xc.Call(context.Background(), "Who.Name", 0, &reply)
+		_assert(err == nil && reply == addrs[0], "expect the live server, but got %q, %v", reply, err)
+	}
+	_assert(xc.RetryStats().Retries >= 1, "expect a retry of the dead server")
+}
+
+func TestXClient_RetryBudget(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{"tcp@a:1", "tcp@b:1", "tcp@c:1"})
+	d.SetQuarantine(time.Nanosecond, time.Nanosecond) // keep returning the down servers
+	dialer := &downDialer{}
+	xc := NewXClient(d, RoundRobinSelect, &tinyrpc.Option{Dialer: dialer})
+	defer func() { _ = xc.Close() }()
+	xc.SetRetries(3)
+
+	const calls = 200
+	var err error
+	for i := 0; i < calls; i++ {
+		var reply string
+		err = xc.Call(context.Background(), "Who.Name", 0, &reply)
+	}
+	attempts := atomic.LoadInt64(&dialer.attempts)
+	_assert(attempts <= calls*11/10, "expect at most 1.1x the calls, but got %d attempts for %d calls", attempts, calls)
+	_assert(errors.Is(err, ErrRetryBudgetExhausted), "expect the budget to be exhausted, but got %v", err)
+	stats := xc.RetryStats()
+	_assert(stats.Retries == DefaultRetryBurst && stats.Exhausted == calls-3, // 3 retries for each of the first 3 calls
+		"unexpected stats %+v", stats)
+	_assert(stats.Tokens < 1, "expect no tokens left, but got %v", stats.Tokens)
+}
+
+func TestXClient_RetryAfter(t *testing.T) {
+	clock := tinyrpctest.NewClock()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	addr := "tcp@" + lis.Addr().String()
+	server := tinyrpc.NewServer()
+	who := Who(addr)
+	_ = server.Register(&who)
+	server.SetClock(clock)
+	server.SetIPLimits(tinyrpc.IPLimits{QPS: 10, Burst: 1})
+	go server.Accept(lis)
+	t.Cleanup(func() { _ = lis.Close() })
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RoundRobinSelect, &tinyrpc.Option{HeartbeatIdle: -1, Clock: clock})
+	defer func() { _ = xc.Close() }()
+	xc.SetRetries(1)
+	var reply string
+	_assert(xc.Call(context.Background(), "Who.Name", 0, &reply) == nil, "expect a call within the rate")
+	waiters := clock.Waiters()
+	done := make(chan error, 1)
+	go func() { done <- xc.Call(context.Background(), "Who.Name", 0, &reply) }()
+	clock.BlockUntil(waiters + 1) // the retry waits for the hint
+	clock.Advance(99 * time.Millisecond)
+	select {
+	case err := <-done:
+		_assert(false, "expect no retry before the hint elapses, but got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.Advance(time.Millisecond)
+	err = <-done
+	_assert(err == nil && reply == addr, "expect the call retried after the hint, but got %v", err)
+	_assert(xc.RetryStats().Retries == 1, "expect the retry taken from the budget, but got %+v", xc.RetryStats())
+}
+
+func TestRetryBudget_Refill(t *testing.T) {
+	var b retryBudget
+	for i := 0; i < DefaultRetryBurst; i++ {
+		_assert(b.withdraw(), "expect the budget to start full")
+	}
+	_assert(!b.withdraw(), "expect the budget to run out")
+	for i := 0; i < 10; i++ {
+		b.deposit()
+	}
+	_assert(b.withdraw(), "expect ten successes to earn a retry")
+	_assert(!b.withdraw(), "expect a single retry earned")
+}
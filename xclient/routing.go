@@ -0,0 +1,144 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync/atomic"
+)
+
+// RoutingRule sends a share of the calls to the servers whose labels
+// match an expression. Labels follow '?' in the address, e.g.
+// "tcp@host:port?version=v2".
+type RoutingRule struct {
+	// Match is a comma-separated list of label=value or label!=value
+	// terms, all of which must hold, e.g. "version=v2,zone=a".
+	Match string
+	// Percent of the calls sent to the matching servers, 0 to 100.
+	Percent float64
+}
+
+// SetRoutingRules replaces the routing rules of Call. Each rule receives
+// its percentage of the calls, in order; the rest go to the servers
+// matching no rule. Calls with a key set by WithRoutingKey always take
+// the same route while the rules don't change; the others are split
+// evenly by a low-discrepancy sequence, so any run of calls follows the
+// percentages closely. Routed calls pick a server at random within the
+// route, whatever the select mode. Connections are kept across changes.
+// No rules, the default, means the select mode applies to all servers.
+func (xc *XClient) SetRoutingRules(rules []RoutingRule) error {
+	if errs := routingRuleErrors(rules); len(errs) > 0 {
+		return errs[0]
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.rules = append([]RoutingRule(nil), rules...)
+	return nil
+}
+
+// routingRuleErrors returns the problems of rules, none if they are valid.
+func routingRuleErrors(rules []RoutingRule) []error {
+	var errs []error
+	var total float64
+	for _, r := range rules {
+		if r.Percent < 0 || strings.TrimSpace(r.Match) == "" {
+			errs = append(errs, errors.New("rpc xclient: invalid routing rule "+r.Match))
+		}
+		total += r.Percent
+	}
+	if total > 100 {
+		errs = append(errs, errors.New("rpc xclient: routing rules exceed 100 percent"))
+	}
+	return errs
+}
+
+type routingKey struct{}
+
+// WithRoutingKey returns a context whose calls are routed by key, such
+// as a user ID, so the same key consistently reaches the same route.
+func WithRoutingKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, routingKey{}, key)
+}
+
+// matches reports whether the labels of rpcAddr satisfy expr.
+func matches(rpcAddr, expr string) bool {
+	l := labels(rpcAddr)
+	for _, term := range strings.Split(expr, ",") {
+		term = strings.TrimSpace(term)
+		if i := strings.Index(term, "!="); i >= 0 {
+			if l.Get(term[:i]) == term[i+2:] {
+				return false
+			}
+		} else if i := strings.IndexByte(term, '='); i >= 0 {
+			if l.Get(term[:i]) != term[i+1:] {
+				return false
+			}
+		} else if _, ok := l[term]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// bucket returns a point in [0, 100) deciding the route of a call.
+func (xc *XClient) bucket(ctx context.Context) float64 {
+	if key, ok := ctx.Value(routingKey{}).(string); ok {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		return float64(h.Sum64()%1000000) / 10000
+	}
+	// the golden ratio sequence spreads consecutive calls evenly
+	n := atomic.AddUint64(&xc.routed, 1)
+	_, frac := math.Modf(float64(n) * (math.Sqrt(5) - 1) / 2)
+	return frac * 100
+}
+
+// route returns the server of a call under the routing rules,
+// or "" if there are none.
+func (xc *XClient) route(ctx context.Context) (string, error) {
+	xc.mu.Lock()
+	rules := xc.rules
+	xc.mu.Unlock()
+	if len(rules) == 0 {
+		return "", nil
+	}
+	servers, err := xc.getAll()
+	if err != nil {
+		return "", err
+	}
+	groups := make([][]string, len(rules)+1) // the last one is the stable set
+	for _, addr := range servers {
+		g := len(rules)
+		for i, r := range rules {
+			if matches(addr, r.Match) {
+				g = i
+				break
+			}
+		}
+		groups[g] = append(groups[g], addr)
+	}
+	b := xc.bucket(ctx)
+	chosen := len(rules)
+	for i, r := range rules {
+		if b < r.Percent {
+			chosen = i
+			break
+		}
+		b -= r.Percent
+	}
+	candidates := groups[chosen]
+	if len(candidates) == 0 {
+		candidates = groups[len(rules)] // the route has no server, use the stable set
+	}
+	if len(candidates) == 0 {
+		candidates = servers
+	}
+	if len(candidates) == 0 {
+		return "", errors.New("rpc discovery: no available servers")
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return candidates[xc.r.Intn(len(candidates))], nil
+}
@@ -0,0 +1,76 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestMatches(t *testing.T) {
+	addr := "tcp@a:1?version=v2&zone=a"
+	for expr, want := range map[string]bool{
+		"version=v2":         true,
+		"version=v2,zone=a":  true,
+		"version=v2,zone=b":  false,
+		"version!=v1":        true,
+		"version!=v2":        false,
+		"zone":               true,
+		"canary":             false,
+		"version=v2, zone=a": true,
+	} {
+		_assert(matches(addr, expr) == want, "expect matches(%q, %q) == %v", addr, expr, want)
+	}
+}
+
+func TestXClient_RoutingSplit(t *testing.T) {
+	stable, canary := "tcp@a:1?version=v1", "tcp@b:1?version=v2"
+	xc := NewXClient(NewMultiServerDiscovery([]string{stable, canary}), RandomSelect, nil)
+	share := func(ctx func(i int) context.Context) float64 {
+		var n int
+		for i := 0; i < 10000; i++ {
+			addr, err := xc.route(ctx(i))
+			_assert(err == nil, "route error: %v", err)
+			if addr == canary {
+				n++
+			}
+		}
+		return float64(n) / 100
+	}
+	plain := func(int) context.Context { return context.Background() }
+	keyed := func(i int) context.Context { return WithRoutingKey(context.Background(), fmt.Sprint("user-", i)) }
+
+	_ = xc.SetRoutingRules([]RoutingRule{{Match: "version=v2", Percent: 10}})
+	got := share(plain)
+	_assert(math.Abs(got-10) < 1, "expect 10%% on the canary, but got %v%%", got)
+	got = share(keyed)
+	_assert(math.Abs(got-10) < 1, "expect 10%% of the keys on the canary, but got %v%%", got)
+
+	_ = xc.SetRoutingRules([]RoutingRule{{Match: "version=v2", Percent: 50}})
+	got = share(plain)
+	_assert(math.Abs(got-50) < 1, "expect 50%% after the change, but got %v%%", got)
+
+	// a key keeps its route
+	ctx := WithRoutingKey(context.Background(), "user-42")
+	first, _ := xc.route(ctx)
+	for i := 0; i < 100; i++ {
+		addr, _ := xc.route(ctx)
+		_assert(addr == first, "expect user-42 to stay on %s, but got %s", first, addr)
+	}
+
+	err := xc.SetRoutingRules([]RoutingRule{{Match: "a=1", Percent: 60}, {Match: "b=1", Percent: 50}})
+	_assert(err != nil, "expect an error above 100%%")
+}
+
+func TestXClient_RoutingCall(t *testing.T) {
+	addrs := startServers(t, 2)
+	canary := addrs[1] + "?version=v2"
+	xc := NewXClient(NewMultiServerDiscovery([]string{addrs[0], canary}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	_ = xc.SetRoutingRules([]RoutingRule{{Match: "version=v2", Percent: 100}})
+	for i := 0; i < 5; i++ {
+		var name string
+		err := xc.Call(context.Background(), "Who.Name", 0, &name)
+		_assert(err == nil && name == addrs[1], "expect the canary, but got %q, %v", name, err)
+	}
+}
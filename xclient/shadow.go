@@ -0,0 +1,176 @@
+package xclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"reflect"
+	"sync/atomic"
+	"time"
+	"tinyrpc"
+)
+
+// DefaultShadowTimeout bounds the calls mirrored to a shadow.
+const DefaultShadowTimeout = time.Second
+
+// shadow mirrors calls to another set of servers.
+type shadow struct {
+	xc       *XClient
+	percent  float64
+	methods  map[string]bool // nil means all
+	timeout  time.Duration
+	diverged func(serviceMethod string, primary, shadow interface{})
+
+	mirrored, failed, divergences uint64 // accessed atomically
+}
+
+// ShadowStats counts the calls mirrored to the shadow.
+type ShadowStats struct {
+	Mirrored uint64 // calls sent to the shadow
+	Failed   uint64 // shadow calls that returned an error
+	Diverged uint64 // shadow replies different from the primary reply
+}
+
+// EnableShadow copies percent (0 to 100) of the calls made with Call to
+// one of the servers of target, if their method is in methods or methods
+// is empty. Mirrored calls run in the background with their own timeout;
+// their replies and errors never reach the caller. A nil target disables
+// the shadow.
+func (xc *XClient) EnableShadow(target Discovery, percent float64, methods []string) {
+	var s *shadow
+	if target != nil {
+		s = &shadow{
+			xc:      NewXClient(target, RandomSelect, xc.opts...),
+			percent: percent,
+			timeout: DefaultShadowTimeout,
+		}
+		if len(methods) > 0 {
+			s.methods = make(map[string]bool, len(methods))
+			for _, m := range methods {
+				s.methods[m] = true
+			}
+		}
+	}
+	xc.mu.Lock()
+	old := xc.shadow
+	if old != nil && s != nil {
+		s.timeout, s.diverged = old.timeout, old.diverged
+	}
+	xc.shadow = s
+	xc.mu.Unlock()
+	if old != nil {
+		_ = old.xc.Close()
+	}
+}
+
+// SetShadowTimeout sets the timeout of mirrored calls,
+// DefaultShadowTimeout if d is 0.
+func (xc *XClient) SetShadowTimeout(d time.Duration) {
+	if d == 0 {
+		d = DefaultShadowTimeout
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if xc.shadow != nil {
+		xc.shadow.timeout = d
+	}
+}
+
+// OnShadowDivergence makes the shadow compare its replies to the primary
+// replies, and calls fn with both when they differ.
+func (xc *XClient) OnShadowDivergence(fn func(serviceMethod string, primary, shadow interface{})) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if xc.shadow != nil {
+		xc.shadow.diverged = fn
+	}
+}
+
+// ShadowStats returns the counters of the current shadow.
+func (xc *XClient) ShadowStats() ShadowStats {
+	xc.mu.Lock()
+	s := xc.shadow
+	xc.mu.Unlock()
+	if s == nil {
+		return ShadowStats{}
+	}
+	return ShadowStats{
+		Mirrored: atomic.LoadUint64(&s.mirrored),
+		Failed:   atomic.LoadUint64(&s.failed),
+		Diverged: atomic.LoadUint64(&s.divergences),
+	}
+}
+
+// mirror decides whether to shadow a call, and if so returns a function
+// that starts the shadow call given the primary result.
+// args are copied right away, so the caller may reuse them.
+func (xc *XClient) mirror(serviceMethod string, args, reply interface{}) func(error) {
+	xc.mu.Lock()
+	s := xc.shadow
+	sampled := s != nil && xc.r.Float64()*100 < s.percent
+	var timeout time.Duration
+	var diverged func(string, interface{}, interface{})
+	if s != nil {
+		timeout, diverged = s.timeout, s.diverged
+	}
+	xc.mu.Unlock()
+	if !sampled || (s.methods != nil && !s.methods[serviceMethod]) {
+		return nil
+	}
+	argsCopy, err := deepCopy(args)
+	if err != nil {
+		return nil
+	}
+	return func(primaryErr error) {
+		var primary interface{}
+		if diverged != nil && primaryErr == nil && reply != nil {
+			if primary, err = deepCopy(reply); err != nil {
+				primary = nil
+			}
+		}
+		go s.call(serviceMethod, argsCopy, reply, primary, timeout, diverged)
+	}
+}
+
+func (s *shadow) call(serviceMethod string, args, reply, primary interface{},
+	timeout time.Duration, diverged func(string, interface{}, interface{})) {
+	atomic.AddUint64(&s.mirrored, 1)
+	shadowReply := tinyrpc.DiscardReply
+	if reply != nil {
+		shadowReply = reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := s.xc.Call(ctx, serviceMethod, args, shadowReply); err != nil {
+		atomic.AddUint64(&s.failed, 1)
+		return
+	}
+	if primary != nil && !reflect.DeepEqual(primary, shadowReply) {
+		atomic.AddUint64(&s.divergences, 1)
+		diverged(serviceMethod, primary, shadowReply)
+	}
+}
+
+// deepCopy returns a copy of v made by a gob round trip, of the same type.
+func deepCopy(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	t := reflect.TypeOf(v)
+	ptr := t.Kind() == reflect.Ptr
+	if ptr {
+		t = t.Elem()
+	}
+	c := reflect.New(t)
+	if err := gob.NewDecoder(&buf).DecodeValue(c); err != nil {
+		return nil, err
+	}
+	if ptr {
+		return c.Interface(), nil
+	}
+	return c.Elem().Interface(), nil
+}
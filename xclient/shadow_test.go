@@ -0,0 +1,88 @@
+package xclient
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Echo replies with its args plus offset, mutating args first,
+// after delay.
+type Echo struct {
+	offset int
+	delay  time.Duration
+}
+
+type EchoArgs struct{ Nums []int }
+
+func (e *Echo) Sum(args *EchoArgs, reply *int) error {
+	time.Sleep(e.delay)
+	for _, n := range args.Nums {
+		*reply += n
+	}
+	args.Nums = nil // handlers may mutate their args
+	*reply += e.offset
+	return nil
+}
+
+func TestXClient_Shadow(t *testing.T) {
+	primary := startServer(t, func(string) interface{} { return &Echo{} })
+	same := startServer(t, func(string) interface{} { return &Echo{delay: 500 * time.Millisecond} })
+	differ := startServer(t, func(string) interface{} { return &Echo{offset: 1} })
+	xc := NewXClient(NewMultiServerDiscovery([]string{primary}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	t.Run("slow shadow", func(t *testing.T) {
+		xc.EnableShadow(NewMultiServerDiscovery([]string{same}), 100, nil)
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			var reply int
+			args := &EchoArgs{Nums: []int{1, 2}}
+			err := xc.Call(context.Background(), "Echo.Sum", args, &reply)
+			_assert(err == nil && reply == 3, "expect 3, but got %d, %v", reply, err)
+		}
+		_assert(time.Since(start) < 400*time.Millisecond, "expect the primary unaffected by the shadow, but took %s", time.Since(start))
+		time.Sleep(700 * time.Millisecond)
+		st := xc.ShadowStats()
+		_assert(st.Mirrored == 5 && st.Failed == 0 && st.Diverged == 0, "expect 5 mirrored calls, but got %+v", st)
+	})
+
+	t.Run("divergence", func(t *testing.T) {
+		xc.EnableShadow(NewMultiServerDiscovery([]string{differ}), 100, []string{"Echo.Sum"})
+		var mu sync.Mutex
+		var diffs [][2]int
+		xc.OnShadowDivergence(func(serviceMethod string, primary, shadow interface{}) {
+			mu.Lock()
+			diffs = append(diffs, [2]int{*primary.(*int), *shadow.(*int)})
+			mu.Unlock()
+		})
+		var reply int
+		_ = xc.Call(context.Background(), "Echo.Sum", &EchoArgs{Nums: []int{1, 2}}, &reply)
+		reply = 42 // the caller owns its reply, the comparison uses a copy
+		time.Sleep(200 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		_assert(len(diffs) == 1 && diffs[0] == [2]int{3, 4}, "expect 3 vs 4, but got %v", diffs)
+		_assert(xc.ShadowStats().Diverged == 1, "expect one divergence, but got %+v", xc.ShadowStats())
+	})
+
+	t.Run("filtered and sampled", func(t *testing.T) {
+		xc.EnableShadow(NewMultiServerDiscovery([]string{same}), 100, []string{"Echo.Other"})
+		var reply int
+		_ = xc.Call(context.Background(), "Echo.Sum", &EchoArgs{Nums: []int{1}}, &reply)
+		xc.EnableShadow(NewMultiServerDiscovery([]string{same}), 0, nil)
+		_ = xc.Call(context.Background(), "Echo.Sum", &EchoArgs{Nums: []int{1}}, &reply)
+		_assert(xc.ShadowStats().Mirrored == 0, "expect no mirrored call, but got %+v", xc.ShadowStats())
+	})
+}
+
+func TestDeepCopy(t *testing.T) {
+	args := &EchoArgs{Nums: []int{1, 2}}
+	c, err := deepCopy(args)
+	_assert(err == nil, "copy error: %v", err)
+	args.Nums[0] = 9
+	_assert(c.(*EchoArgs).Nums[0] == 1, "expect an independent copy, but got %v", c)
+	v, _ := deepCopy(EchoArgs{Nums: []int{3}})
+	_assert(v.(EchoArgs).Nums[0] == 3, "expect a value copy, but got %v", v)
+}
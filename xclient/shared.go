@@ -0,0 +1,234 @@
+package xclient
+
+import (
+	"context"
+	"sync"
+	"tinyrpc"
+)
+
+// ClientRegistry shares the connections to the servers between the
+// XClients made with its NewXClient, e.g. by the libraries of a process
+// calling the same backends. The XClients dialing an address with
+// options of the same tinyrpc.OptionsKey share one connection to it,
+// closed once the last of them closes or drops it. They also share what
+// they learn about the servers, see sharedHealth. The zero value is ready
+// to use.
+type ClientRegistry struct {
+	mu      sync.Mutex // protect following
+	current map[sharedKey]*sharedConn
+	conns   map[*tinyrpc.Client]*sharedConn
+	targets map[string]*targetStats  // by address, of the closed connections
+	health  map[string]*sharedHealth // by options key
+}
+
+// sharedHealth is the state of the servers kept for the XClients of a
+// registry dialing with the same options, like their connections: the
+// quarantine of the servers they fail to dial and the outlier detection.
+type sharedHealth struct {
+	q quarantine
+
+	mu       sync.Mutex // protect following
+	outliers *outliers  // nil until one of the XClients enables the detection
+}
+
+// outlierDetection returns the outlier detection of the servers, o with
+// its config if none is enabled yet, calling fn too on its events.
+func (h *sharedHealth) outlierDetection(o *outliers, fn func(rpcAddr string, ejected bool)) *outliers {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.outliers == nil {
+		h.outliers = o
+		return o
+	}
+	if fn != nil {
+		h.outliers.mu.Lock()
+		h.outliers.events = append(h.outliers.events, fn)
+		h.outliers.mu.Unlock()
+	}
+	return h.outliers
+}
+
+type sharedKey struct {
+	rpcAddr, options string
+}
+
+// sharedConn is a connection of the registry, with its references.
+type sharedConn struct {
+	key    sharedKey
+	ready  chan struct{} // closed once dialed
+	client *tinyrpc.Client
+	err    error
+	refs   int
+}
+
+// NewXClient returns an XClient like the NewXClient of the package,
+// sharing the connections of r.
+func (r *ClientRegistry) NewXClient(d Discovery, mode SelectMode, opts ...tinyrpc.ClientOption) *XClient {
+	xc := NewXClient(d, mode, opts...)
+	key, err := tinyrpc.OptionsKey(opts...)
+	if err != nil {
+		return xc // the dials fail with err, there is nothing to share
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.health == nil {
+		r.health = make(map[string]*sharedHealth)
+	}
+	h := r.health[key]
+	if h == nil {
+		h = &sharedHealth{}
+		r.health[key] = h
+	}
+	xc.shared, xc.optsKey, xc.health = r, key, h
+	return xc
+}
+
+// dial returns a connection to rpcAddr made with opts, whose key is
+// options, with one more reference. It dials a new one if there is none,
+// if it is unavailable, or if fresh, e.g. to recycle the connection.
+// Waiting for the dial of another XClient stops when ctx is done.
+func (r *ClientRegistry) dial(ctx context.Context, rpcAddr, options string, fresh bool, opts []tinyrpc.ClientOption) (*tinyrpc.Client, error) {
+	key := sharedKey{rpcAddr, options}
+	r.mu.Lock()
+	sc := r.current[key]
+	if sc != nil && !fresh && (sc.client == nil || sc.client.IsAvailable()) {
+		sc.refs++
+		r.mu.Unlock()
+		var err error
+		select {
+		case <-sc.ready:
+			err = sc.err
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			r.release(sc)
+			return nil, err
+		}
+		return sc.client, nil
+	}
+	sc = &sharedConn{key: key, ready: make(chan struct{}), refs: 1}
+	if r.current == nil {
+		r.current = make(map[sharedKey]*sharedConn)
+		r.conns = make(map[*tinyrpc.Client]*sharedConn)
+	}
+	r.current[key] = sc
+	r.mu.Unlock()
+
+	client, err := tinyrpc.XDialContext(ctx, rpcAddr, opts...)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sc.client, sc.err = client, err
+	close(sc.ready)
+	if err != nil {
+		if r.current[key] == sc {
+			delete(r.current, key)
+		}
+		return nil, err
+	}
+	r.conns[client] = sc
+	r.target(rpcAddr).dials++
+	return client, nil
+}
+
+// releaseClient drops a reference to client, see release.
+func (r *ClientRegistry) releaseClient(client *tinyrpc.Client) {
+	r.mu.Lock()
+	sc := r.conns[client]
+	r.mu.Unlock()
+	if sc != nil {
+		r.release(sc)
+	}
+}
+
+// release drops a reference to sc, closing its client with the last one,
+// once dialed.
+func (r *ClientRegistry) release(sc *sharedConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sc.refs--; sc.refs > 0 || sc.client == nil {
+		return
+	}
+	client := sc.client
+	delete(r.conns, client)
+	if r.current[sc.key] == sc {
+		delete(r.current, sc.key)
+	}
+	stats := client.Stats()
+	stats.InFlight = 0
+	r.target(sc.key.rpcAddr).closed.Merge(stats)
+	_ = client.Close()
+}
+
+// target returns the stats of the closed connections to rpcAddr. r.mu
+// must be held.
+func (r *ClientRegistry) target(rpcAddr string) *targetStats {
+	if r.targets == nil {
+		r.targets = make(map[string]*targetStats)
+	}
+	t := r.targets[rpcAddr]
+	if t == nil {
+		t = &targetStats{}
+		r.targets[rpcAddr] = t
+	}
+	return t
+}
+
+// Len returns the number of connections open.
+func (r *ClientRegistry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+// Stats returns the stats of the calls to each server, by address, over
+// all the connections made to it for all the XClients of r, each call
+// counted once.
+func (r *ClientRegistry) Stats() map[string]TargetStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats(nil)
+}
+
+// stats returns the stats of the servers of addrs, all if nil. r.mu must
+// be held.
+func (r *ClientRegistry) stats(addrs map[string]*targetStats) map[string]TargetStats {
+	stats := make(map[string]TargetStats, len(r.targets))
+	for addr, t := range r.targets {
+		if _, ok := addrs[addr]; addrs != nil && !ok {
+			continue
+		}
+		s := TargetStats{}
+		if t.dials > 0 {
+			s.Reconnects = t.dials - 1
+		}
+		s.Merge(t.closed)
+		stats[addr] = s
+	}
+	for client, sc := range r.conns {
+		if s, ok := stats[sc.key.rpcAddr]; ok {
+			s.Merge(client.Stats())
+			stats[sc.key.rpcAddr] = s
+		}
+	}
+	return stats
+}
+
+// dialClient returns a new client to rpcAddr, connecting within ctx,
+// shared if xc has a registry.
+func (xc *XClient) dialClient(ctx context.Context, rpcAddr string, fresh bool) (*tinyrpc.Client, error) {
+	if xc.shared != nil {
+		return xc.shared.dial(ctx, rpcAddr, xc.optsKey, fresh, xc.opts)
+	}
+	return tinyrpc.XDialContext(ctx, rpcAddr, xc.opts...)
+}
+
+// closeClient closes client, or drops the reference of xc to it if it
+// is shared.
+func (xc *XClient) closeClient(client *tinyrpc.Client) {
+	if xc.shared != nil {
+		xc.shared.releaseClient(client)
+		return
+	}
+	_ = client.Close()
+}
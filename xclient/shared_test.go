@@ -0,0 +1,168 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+	"tinyrpc"
+)
+
+// startCountedServers starts n servers answering Who.Name, and returns
+// them along with their addresses.
+func startCountedServers(t *testing.T, n int) ([]*tinyrpc.Server, []string) {
+	t.Helper()
+	var servers []*tinyrpc.Server
+	var addrs []string
+	for i := 0; i < n; i++ {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal("network error:", err)
+		}
+		addr := "tcp@" + lis.Addr().String()
+		server := tinyrpc.NewServer()
+		who := Who(addr)
+		_ = server.Register(&who)
+		go server.Accept(lis)
+		t.Cleanup(func() { _ = lis.Close() })
+		servers, addrs = append(servers, server), append(addrs, addr)
+	}
+	return servers, addrs
+}
+
+func TestClientRegistry_Shares(t *testing.T) {
+	servers, addrs := startCountedServers(t, 2)
+	var r ClientRegistry
+	opt := &tinyrpc.Option{HeartbeatIdle: -1}
+	a := r.NewXClient(NewMultiServerDiscovery(addrs), RoundRobinSelect, opt)
+	b := r.NewXClient(NewMultiServerDiscovery(addrs), RoundRobinSelect, opt)
+	for _, xc := range []*XClient{a, b} {
+		var reply string
+		_assert(xc.Broadcast(context.Background(), "Who.Name", 0, &reply) == nil, "failed to broadcast")
+	}
+	for i, server := range servers {
+		_assert(server.Stats().Connections == 1, "expect one connection to server %d, but got %d", i, server.Stats().Connections)
+	}
+	_assert(r.Len() == 2, "expect a connection per address, but got %d", r.Len())
+
+	stats := r.Stats()
+	for _, addr := range addrs {
+		s := stats[addr]
+		_assert(s.Calls == 2 && s.Reconnects == 0, "expect both calls to %s counted once, but got %+v", addr, s)
+	}
+	_assert(a.Stats()[addrs[0]].Calls == 2, "expect the xclient stats to be those of the shared connection")
+
+	// the connections outlive the first xclient closed
+	_ = a.Close()
+	var reply string
+	_assert(b.Call(context.Background(), "Who.Name", 0, &reply) == nil, "expect the shared connections kept for b")
+	_assert(r.Len() == 2, "expect the connections open while b uses them, but got %d", r.Len())
+	_ = b.Close()
+	_assert(r.Len() == 0, "expect the last xclient to close the connections, but got %d", r.Len())
+	for i, server := range servers {
+		deadline := time.Now().Add(time.Second)
+		for server.Stats().Connections != 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		_assert(server.Stats().Connections == 0, "expect the connection to server %d closed", i)
+	}
+	var calls uint64
+	for _, s := range r.Stats() {
+		calls += s.Calls
+	}
+	_assert(calls == 5, "expect the calls of the closed connections still counted, but got %d", calls)
+}
+
+func TestClientRegistry_OptionsKey(t *testing.T) {
+	servers, addrs := startCountedServers(t, 1)
+	var r ClientRegistry
+	a := r.NewXClient(NewMultiServerDiscovery(addrs), RandomSelect, &tinyrpc.Option{HeartbeatIdle: -1})
+	b := r.NewXClient(NewMultiServerDiscovery(addrs), RandomSelect, &tinyrpc.Option{HeartbeatIdle: -1, HandleTimeout: time.Second})
+	defer func() { _ = a.Close() }()
+	defer func() { _ = b.Close() }()
+	for _, xc := range []*XClient{a, b} {
+		var reply string
+		_assert(xc.Call(context.Background(), "Who.Name", 0, &reply) == nil, "failed to call Who.Name")
+	}
+	_assert(servers[0].Stats().Connections == 2, "expect other options dialed apart, but got %d connections", servers[0].Stats().Connections)
+}
+
+func TestClientRegistry_Redial(t *testing.T) {
+	_, addrs := startCountedServers(t, 1)
+	var r ClientRegistry
+	a := r.NewXClient(NewMultiServerDiscovery(addrs), RandomSelect, &tinyrpc.Option{HeartbeatIdle: -1})
+	b := r.NewXClient(NewMultiServerDiscovery(addrs), RandomSelect, &tinyrpc.Option{HeartbeatIdle: -1})
+	defer func() { _ = b.Close() }()
+	var reply string
+	_assert(a.Call(context.Background(), "Who.Name", 0, &reply) == nil, "failed to call Who.Name")
+	_assert(b.Call(context.Background(), "Who.Name", 0, &reply) == nil, "failed to call Who.Name")
+
+	// a broken shared connection is replaced for both
+	a.mu.Lock()
+	_ = a.clients[addrs[0]].client.Close()
+	a.mu.Unlock()
+	_assert(a.Call(context.Background(), "Who.Name", 0, &reply) == nil, "expect a new connection")
+	_assert(b.Call(context.Background(), "Who.Name", 0, &reply) == nil, "expect b to share the new connection")
+	_assert(r.Len() == 1, "expect the broken connection released, but got %d", r.Len())
+	_ = a.Close()
+	_assert(r.Len() == 1, "expect b to keep the new connection, but got %d", r.Len())
+}
+
+func TestClientRegistry_SharesHealth(t *testing.T) {
+	_, addrs := startCountedServers(t, 1)
+	dead := deadAddr(t)
+	var r ClientRegistry
+	opt := &tinyrpc.Option{HeartbeatIdle: -1}
+	a := r.NewXClient(NewMultiServerDiscovery([]string{dead, addrs[0]}), RoundRobinSelect, opt)
+	defer func() { _ = a.Close() }()
+	b := r.NewXClient(NewMultiServerDiscovery([]string{dead, addrs[0]}), RoundRobinSelect, opt)
+	defer func() { _ = b.Close() }()
+
+	// a failing to dial quarantines the server for b too
+	_, err := a.dial(context.Background(), dead)
+	_assert(err != nil, "expect the dial to fail")
+	for i := 0; i < 20; i++ {
+		var reply string
+		_assert(b.Call(context.Background(), "Who.Name", 0, &reply) == nil && reply == addrs[0],
+			"expect b to avoid the quarantined server, but got %q", reply)
+	}
+	servers, _ := b.getAll()
+	_assert(len(servers) == 1 && servers[0] == addrs[0], "expect the quarantined server left out, but got %v", servers)
+
+	// and an outlier a detects is ejected for b
+	var events []string
+	a.EnableOutlierDetection(OutlierConfig{}, nil)
+	b.EnableOutlierDetection(OutlierConfig{}, func(rpcAddr string, ejected bool) { events = append(events, rpcAddr) })
+	o := a.outlierDetection()
+	for i := 0; i < DefaultOutlierMinRequests; i++ {
+		o.record("tcp@b", false)
+	}
+	for i := 0; i < DefaultOutlierMinRequests; i++ {
+		o.record("tcp@a", true)
+	}
+	ejected := b.Ejected()
+	_assert(len(ejected) == 1 && ejected[0] == "tcp@a", "expect a's outlier ejected for b, but got %v", ejected)
+	_assert(len(events) == 1 && events[0] == "tcp@a", "expect b told of the ejection, but got %v", events)
+}
+
+func TestClientRegistry_DialWithinCtx(t *testing.T) {
+	dialer := newBlackholeDialer()
+	var r ClientRegistry
+	opt := &tinyrpc.Option{Dialer: dialer}
+	a := r.NewXClient(NewMultiServerDiscovery([]string{blackholeAddr}), RandomSelect, opt)
+	defer func() { _ = a.Close() }()
+	b := r.NewXClient(NewMultiServerDiscovery([]string{blackholeAddr}), RandomSelect, opt)
+	defer func() { _ = b.Close() }()
+
+	slow, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _, _ = a.dial(slow, blackholeAddr) }()
+	<-dialer.started
+	ctx, cancelB := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelB()
+	start := time.Now()
+	_, err := b.dial(ctx, blackholeAddr)
+	_assert(errors.Is(err, context.DeadlineExceeded) && time.Since(start) < 500*time.Millisecond,
+		"expect b to stop waiting for a's dial at its deadline, but got %v after %s", err, time.Since(start))
+}
@@ -0,0 +1,318 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+	"tinyrpc"
+	"tinyrpc/internal/clock"
+)
+
+// XClient calls the servers found by a Discovery, keeping one
+// connection per server.
+type XClient struct {
+	d       Discovery
+	mode    SelectMode
+	opts    []tinyrpc.ClientOption
+	mu      sync.Mutex // protect following
+	clients map[string]*pooledConn
+	dialing map[string]*pendingDial // by address, the dials in progress
+	closed  bool
+	fanout  int        // servers tried at once by CallAny, 0 means DefaultFanout
+	r       *rand.Rand // pick the servers of CallAny, sample the shadowed calls
+	shadow  *shadow
+	rules   []RoutingRule
+	routed  uint64 // calls routed without a key, accessed atomically
+
+	sessions sessions
+	retries  int // other servers tried by Call when one is unreachable
+	budget   retryBudget
+	outliers *outliers // nil unless EnableOutlierDetection was called
+	eager    *eager    // nil unless EnableEagerDial was called
+	pool     pool
+	clock    tinyrpc.Clock   // of opts, or the real clock
+	shared   *ClientRegistry // nil unless made by ClientRegistry.NewXClient
+	optsKey  string          // of opts, for shared
+	health   *sharedHealth   // of shared for optsKey
+}
+
+var _ io.Closer = (*XClient)(nil)
+
+// NewXClient returns an XClient selecting servers of d according to mode.
+// Its connections are made with opts, as by tinyrpc.Dial. The Clock of
+// opts, if set, also times the connection pool, the warmup checks and the
+// outlier detection.
+func NewXClient(d Discovery, mode SelectMode, opts ...tinyrpc.ClientOption) *XClient {
+	var c tinyrpc.Clock
+	if opt, err := tinyrpc.MergeOptions(opts...); err == nil {
+		c = opt.Clock
+	}
+	c = clock.Or(c)
+	return &XClient{
+		d:       d,
+		mode:    mode,
+		opts:    opts,
+		clients: make(map[string]*pooledConn),
+		dialing: make(map[string]*pendingDial),
+		r:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		pool:    pool{now: c.Now},
+		clock:   c,
+	}
+}
+
+// Close closes the connections to all servers. Those shared with other
+// XClients, see ClientRegistry, are closed by the last one using them.
+func (xc *XClient) Close() error {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.closed = true
+	if xc.shadow != nil {
+		_ = xc.shadow.xc.Close()
+	}
+	if xc.eager != nil {
+		xc.eager.close()
+		xc.eager = nil
+	}
+	if xc.pool.stop != nil {
+		close(xc.pool.stop)
+		xc.pool.stop = nil
+	}
+	for key, pc := range xc.clients {
+		// I have no idea how to deal with error, just ignore it.
+		xc.removeConn(key, pc)
+		if xc.shared == nil {
+			_ = pc.client.Close() // with its calls in flight
+		}
+	}
+	return nil
+}
+
+// dialError marks errors reaching a server, as opposed to errors
+// returned by the called method.
+type dialError struct{ err error }
+
+func (e *dialError) Error() string { return e.err.Error() }
+func (e *dialError) Unwrap() error { return e.err }
+
+// isTransportError reports whether err means the server could not be
+// used at all, rather than that the call itself failed. A corrupt stream
+// or a write timeout closed the connection: the next call dials a new one.
+func isTransportError(err error) bool {
+	var de *dialError
+	return errors.As(err, &de) || errors.Is(err, tinyrpc.ErrShutdown) || errors.Is(err, tinyrpc.ErrGoAway) ||
+		errors.Is(err, tinyrpc.ErrCorrupted) || errors.Is(err, tinyrpc.ErrWriteTimeout)
+}
+
+// pendingDial is a dial in progress, shared by the calls to its server.
+type pendingDial struct {
+	done    chan struct{} // closed once dialed
+	err     error
+	ctxDone bool // err is the context of the call that dialed being done
+}
+
+// dial returns the connection to rpcAddr, connecting within ctx if
+// needed, with one more call in flight. The caller must release it. The
+// dial is made without xc.mu held, so that a slow server delays only its
+// own calls, and once for all the calls needing it at the same time.
+func (xc *XClient) dial(ctx context.Context, rpcAddr string) (*pooledConn, error) {
+	xc.mu.Lock()
+	for {
+		pc, ok := xc.clients[rpcAddr]
+		if ok && !pc.client.IsAvailable() {
+			xc.removeConn(rpcAddr, pc)
+			pc = nil
+		}
+		if pc != nil {
+			pc.inflight++
+			pc.lastUsed = xc.pool.now()
+			xc.mu.Unlock()
+			return pc, nil
+		}
+		d := xc.dialing[rpcAddr]
+		if d == nil {
+			break
+		}
+		xc.mu.Unlock()
+		select {
+		case <-d.done:
+		case <-ctx.Done():
+			return nil, &dialError{ctx.Err()}
+		}
+		if d.err != nil && !d.ctxDone {
+			return nil, &dialError{d.err}
+		}
+		xc.mu.Lock() // dialed, or to dial again within ctx
+	}
+	if xc.closed {
+		xc.mu.Unlock()
+		return nil, &dialError{tinyrpc.ErrShutdown}
+	}
+	d := &pendingDial{done: make(chan struct{})}
+	xc.dialing[rpcAddr] = d
+	xc.mu.Unlock()
+
+	client, err := xc.dialClient(ctx, rpcAddr, false)
+	xc.mu.Lock()
+	delete(xc.dialing, rpcAddr)
+	d.err, d.ctxDone = err, ctx.Err() != nil
+	close(d.done)
+	if err == nil && xc.closed {
+		xc.closeClient(client)
+		client, err = nil, tinyrpc.ErrShutdown
+	}
+	if err != nil {
+		xc.mu.Unlock()
+		if r, ok := xc.d.(FailureReporter); ok && !d.ctxDone {
+			r.ReportFailure(rpcAddr)
+		}
+		if xc.health != nil && !d.ctxDone {
+			xc.health.q.report(rpcAddr, xc.clock.Now())
+		}
+		return nil, &dialError{err}
+	}
+	pc := xc.pool.newConn(rpcAddr, client)
+	xc.clients[rpcAddr] = pc
+	pc.inflight++
+	pc.lastUsed = xc.pool.now()
+	xc.mu.Unlock()
+	return pc, nil
+}
+
+// get picks a server of the discovery, in the string form the
+// connections are kept by.
+func (xc *XClient) get() (string, error) {
+	e, err := xc.d.GetEntry(xc.mode)
+	if err != nil {
+		return "", err
+	}
+	return e.String(), nil
+}
+
+// getAll returns the servers of the discovery, in their string form,
+// but those quarantined by the registry of xc.
+func (xc *XClient) getAll() ([]string, error) {
+	entries, err := xc.d.GetAllEntries()
+	if err != nil {
+		return nil, err
+	}
+	servers := FormatServerEntries(entries)
+	if xc.health != nil {
+		servers = xc.health.q.filter(servers, xc.clock.Now())
+	}
+	return servers, nil
+}
+
+func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}, opts ...tinyrpc.CallOption) error {
+	pc, err := xc.dial(ctx, rpcAddr)
+	if err == nil {
+		err = pc.client.CallContext(ctx, serviceMethod, args, reply, opts...)
+		xc.release(pc)
+	}
+	if o := xc.outlierDetection(); o != nil && ctx.Err() == nil {
+		o.record(rpcAddr, err != nil)
+	}
+	return err
+}
+
+// sleep waits d, timed by the clock of xc, and reports whether ctx
+// was not done first.
+func (xc *XClient) sleep(ctx context.Context, d time.Duration) bool {
+	t := xc.clock.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Call invokes the named function, waits for it to complete,
+// and returns its error status.
+// xc will choose a proper server. opts are passed to the client as is.
+// If the server cannot be reached, or sheds the call with a
+// tinyrpc.RetryAfter, other ones are tried as allowed by SetRetries and
+// the retry budget, the latter after the wait the server asked for.
+func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...tinyrpc.CallOption) error {
+	ctx = tinyrpc.AtMostOnceContext(ctx) // one call to the servers, see tinyrpc.WithAtMostOnce
+	rpcAddr, err := xc.route(ctx)
+	if err == nil && rpcAddr == "" {
+		rpcAddr, err = xc.pick(serviceMethod)
+	}
+	if err != nil {
+		return err
+	}
+	mirror := xc.mirror(serviceMethod, args, reply)
+	xc.mu.Lock()
+	retries := xc.retries
+	xc.mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		err = xc.call(rpcAddr, ctx, serviceMethod, args, reply, opts...)
+		if err == nil {
+			xc.budget.deposit()
+			break
+		}
+		wait := tinyrpc.RetryAfter(err)
+		if attempt >= retries || (!isTransportError(err) && wait == 0) || ctx.Err() != nil {
+			break
+		}
+		if !xc.budget.withdraw() {
+			err = &budgetError{err}
+			break
+		}
+		if wait > 0 && !xc.sleep(ctx, wait) {
+			break
+		}
+		next, gerr := xc.pick(serviceMethod)
+		if gerr != nil {
+			break
+		}
+		rpcAddr = next
+	}
+	if mirror != nil {
+		mirror(err)
+	}
+	return err
+}
+
+// Broadcast invokes the named function for every server registered in discovery.
+// args may be a *tinyrpc.PreencodedBody, encoded once for all of them.
+func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	servers, err := xc.getAll()
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex // protect e and replyDone
+	var e error
+	replyDone := reply == nil // if reply is nil, don't need to set value
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for _, rpcAddr := range servers {
+		wg.Add(1)
+		go func(rpcAddr string) {
+			defer wg.Done()
+			clonedReply := tinyrpc.DiscardReply
+			if reply != nil {
+				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+			}
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
+			mu.Lock()
+			if err != nil && e == nil {
+				e = err
+				cancel() // if any call failed, cancel unfinished calls
+			}
+			if err == nil && !replyDone {
+				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(clonedReply).Elem())
+				replyDone = true
+			}
+			mu.Unlock()
+		}(rpcAddr)
+	}
+	wg.Wait()
+	return e
+}
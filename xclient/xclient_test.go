@@ -0,0 +1,130 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tinyrpc"
+)
+
+type Who string
+
+func (w *Who) Name(args int, reply *string) error {
+	*reply = string(*w)
+	return nil
+}
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func TestXClient_ClientOptions(t *testing.T) {
+	addrs := startServers(t, 2)
+	var calls int64
+	count := func(ctx context.Context, _ string, _ interface{}, next func(context.Context) error) error {
+		atomic.AddInt64(&calls, 1)
+		return next(ctx)
+	}
+	xc := NewXClient(NewMultiServerDiscovery(addrs), RoundRobinSelect,
+		&tinyrpc.Option{ConnectTimeout: time.Second}, tinyrpc.WithInterceptors(count))
+	defer func() { _ = xc.Close() }()
+	for i := 0; i < 4; i++ {
+		var reply string
+		err := xc.Call(context.Background(), "Who.Name", 0, &reply)
+		_assert(err == nil, "call error: %v", err)
+	}
+	_assert(atomic.LoadInt64(&calls) == 4, "expect the options forwarded to every connection, but got %d calls", calls)
+	_assert(xc.PoolStats().Dialed == 2, "expect a connection to each server, but got %+v", xc.PoolStats())
+}
+
+// blackholeAddr is the address blackholeDialer never connects to.
+const blackholeAddr = "tcp@blackhole:1"
+
+// blackholeDialer dials the addresses but blackholeAddr, whose dials
+// hang until their ctx is done, counted in started.
+type blackholeDialer struct{ started chan struct{} }
+
+func newBlackholeDialer() *blackholeDialer {
+	return &blackholeDialer{started: make(chan struct{}, 16)}
+}
+
+func (d *blackholeDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if "tcp@"+address == blackholeAddr {
+		d.started <- struct{}{}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	var nd net.Dialer
+	return nd.DialContext(ctx, network, address)
+}
+
+func TestXClient_SlowDial(t *testing.T) {
+	live := startServers(t, 1)[0]
+	dialer := newBlackholeDialer()
+	xc := NewXClient(NewMultiServerDiscovery([]string{blackholeAddr, live}), RoundRobinSelect, &tinyrpc.Option{Dialer: dialer})
+
+	slow, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hung := make(chan error, 1)
+	go func() {
+		var reply string
+		hung <- xc.call(blackholeAddr, slow, "Who.Name", 0, &reply)
+	}()
+	<-dialer.started
+
+	// the other servers are not held up by the dial
+	var reply string
+	err := xc.call(live, context.Background(), "Who.Name", 0, &reply)
+	_assert(err == nil && reply == live, "expect the live server called during the dial, but got %v", err)
+	_assert(xc.PoolStats().Open == 1, "expect the stats during the dial")
+
+	// the calls to the server share its dial, within their own ctx
+	ctx, stop := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer stop()
+	err = xc.call(blackholeAddr, ctx, "Who.Name", 0, &reply)
+	_assert(errors.Is(err, context.DeadlineExceeded) && isTransportError(err), "expect the deadline of the call, but got %v", err)
+	select {
+	case <-dialer.started:
+		_assert(false, "expect one dial of the server at a time")
+	default:
+	}
+
+	cancel()
+	err = <-hung
+	_assert(errors.Is(err, context.Canceled), "expect the dial given up with its ctx, but got %v", err)
+	_assert(xc.Close() == nil, "failed to close")
+}
+
+// startServers starts n servers, each answering Who.Name with its address.
+func startServers(t *testing.T, n int) []string {
+	t.Helper()
+	var addrs []string
+	for i := 0; i < n; i++ {
+		addrs = append(addrs, startServer(t, func(addr string) interface{} {
+			who := Who(addr)
+			return &who
+		}))
+	}
+	return addrs
+}
+
+// startServer starts a server serving newRcvr(addr) and returns its address.
+func startServer(t *testing.T, newRcvr func(addr string) interface{}) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	addr := "tcp@" + lis.Addr().String()
+	server := tinyrpc.NewServer()
+	_ = server.Register(newRcvr(addr))
+	go server.Accept(lis)
+	t.Cleanup(func() { _ = lis.Close() })
+	return addr
+}
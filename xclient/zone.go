@@ -0,0 +1,61 @@
+package xclient
+
+import (
+	"net/url"
+	"strings"
+)
+
+// DefaultZoneSpillover is the fraction of the servers of the local zone
+// that must be healthy for Get to keep to them.
+const DefaultZoneSpillover = 0.5
+
+// labels returns the labels of rpcAddr, the query after '?' in
+// "tcp@host:port?zone=us-east-1a".
+func labels(rpcAddr string) url.Values {
+	i := strings.IndexByte(rpcAddr, '?')
+	if i < 0 {
+		return nil
+	}
+	values, _ := url.ParseQuery(rpcAddr[i+1:])
+	return values
+}
+
+// SetZone makes GetEntry and Get prefer the servers whose Zone is zone,
+// such as "tcp@host:port?zone=us-east-1a". Servers of other zones are selected
+// only when fewer than the spillover fraction of the local servers are
+// healthy, i.e. not quarantined, or there are none. spillover 0 means
+// DefaultZoneSpillover, an empty zone disables the preference.
+// GetAll is not affected.
+func (d *MultiServersDiscovery) SetZone(zone string, spillover float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.zone, d.spillover = zone, spillover
+}
+
+// preferZone returns, of the indexes of the healthy servers, those of
+// the local zone, unless they are too few. d.mu must be held.
+func (d *MultiServersDiscovery) preferZone(healthy []int) []int {
+	if d.zone == "" {
+		return healthy
+	}
+	var total int
+	for _, e := range d.servers {
+		if e.Zone == d.zone {
+			total++
+		}
+	}
+	var local []int
+	for _, s := range healthy {
+		if d.servers[s].Zone == d.zone {
+			local = append(local, s)
+		}
+	}
+	spillover := d.spillover
+	if spillover == 0 {
+		spillover = DefaultZoneSpillover
+	}
+	if len(local) == 0 || float64(len(local)) < spillover*float64(total) {
+		return healthy
+	}
+	return local
+}
@@ -0,0 +1,47 @@
+package xclient
+
+import (
+	"context"
+	"testing"
+)
+
+func TestZone_Get(t *testing.T) {
+	a1, a2, b1 := "tcp@a1:1?zone=a", "tcp@a2:1?zone=a", "tcp@b1:1?zone=b"
+	d := NewMultiServerDiscovery([]string{a1, a2, b1})
+	d.SetZone("a", 0)
+	for i := 0; i < 100; i++ {
+		addr, _ := d.Get(RandomSelect)
+		_assert(addr == a1 || addr == a2, "expect only zone a when healthy, but got %s", addr)
+	}
+
+	d.ReportFailure(a1) // one of two healthy is enough with the default 0.5
+	for i := 0; i < 100; i++ {
+		addr, _ := d.Get(RoundRobinSelect)
+		_assert(addr == a2, "expect a2, but got %s", addr)
+	}
+
+	d.SetZone("a", 0.75) // now one of two is too few
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		addr, _ := d.Get(RoundRobinSelect)
+		seen[addr] = true
+	}
+	_assert(seen[a2] && seen[b1] && !seen[a1], "expect a spillover to b, but got %v", seen)
+}
+
+func TestZone_Spillover(t *testing.T) {
+	remote := startServers(t, 1)[0] + "?zone=b"
+	local := deadAddr(t) + "?zone=a"
+	d := NewMultiServerDiscovery([]string{local, remote})
+	d.SetZone("a", 0)
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var name string
+	err := xc.Call(context.Background(), "Who.Name", 0, &name)
+	_assert(err != nil, "expect the dead local server to be tried first")
+	for i := 0; i < 10; i++ {
+		err = xc.Call(context.Background(), "Who.Name", 0, &name)
+		_assert(err == nil, "expect a spillover to zone b, but got %v", err)
+	}
+}